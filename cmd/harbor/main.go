@@ -13,11 +13,15 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/CaioWing/Harbor/internal/api"
+	"github.com/CaioWing/Harbor/internal/archive"
 	"github.com/CaioWing/Harbor/internal/auth"
 	"github.com/CaioWing/Harbor/internal/config"
 	"github.com/CaioWing/Harbor/internal/repository/postgres"
 	"github.com/CaioWing/Harbor/internal/service"
+	"github.com/CaioWing/Harbor/internal/storage"
+	"github.com/CaioWing/Harbor/internal/storage/encrypted"
 	"github.com/CaioWing/Harbor/internal/storage/local"
+	"github.com/CaioWing/Harbor/internal/storage/s3"
 )
 
 func main() {
@@ -40,7 +44,7 @@ func run(log *slog.Logger) error {
 	log.Info("starting Harbor",
 		"listen", cfg.ListenAddr(),
 		"db_host", cfg.DB.Host,
-		"storage", cfg.Storage.Path,
+		"storage", cfg.Storage.Backend,
 	)
 
 	// Run migrations
@@ -66,30 +70,128 @@ func run(log *slog.Logger) error {
 	log.Info("database connected")
 
 	// File storage
-	store, err := local.New(cfg.Storage.Path)
+	store, err := newFileStore(cfg.Storage)
 	if err != nil {
 		return fmt.Errorf("init storage: %w", err)
 	}
-	log.Info("storage initialized", "path", cfg.Storage.Path)
+	if cfg.Storage.Backend == "s3" {
+		log.Info("storage initialized", "backend", "s3", "bucket", cfg.Storage.S3.Bucket)
+	} else {
+		log.Info("storage initialized", "backend", "local", "path", cfg.Storage.Path)
+	}
 
 	// Repositories
 	deviceRepo := postgres.NewDeviceRepo(pool)
 	artifactRepo := postgres.NewArtifactRepo(pool)
 	deploymentRepo := postgres.NewDeploymentRepo(pool)
 	auditRepo := postgres.NewAuditRepo(pool)
+	tokenRepo := postgres.NewTokenRepo(pool)
+	userRepo := postgres.NewUserRepo(pool)
+	settingsRepo := postgres.NewSettingsRepo(pool)
+	orgRepo := postgres.NewOrganizationRepo(pool)
+	signingKeyRepo := postgres.NewSigningKeyRepo(pool)
+	deltaRepo := postgres.NewArtifactDeltaRepo(pool)
+	uploadRepo := postgres.NewUploadSessionRepo(pool)
+	blobRepo := postgres.NewBlobRepo(pool)
+	scrubRepo := postgres.NewScrubRepo(pool)
+	dataKeyRepo := postgres.NewDataKeyRepo(pool)
+	channelRepo := postgres.NewChannelRepo(pool)
+	manifestRepo := postgres.NewManifestRepo(pool)
+
+	// Encryption at rest
+	if cfg.Encryption.Key != nil {
+		encStore, err := newEncryptedStore(store, dataKeyRepo, cfg.Encryption)
+		if err != nil {
+			return fmt.Errorf("init storage encryption: %w", err)
+		}
+		store = encStore
+		log.Info("storage encryption enabled", "key_id", cfg.Encryption.KeyID)
+
+		// Move data keys of files written before a key rotation to the
+		// current master key
+		go func() {
+			n, err := encStore.Rewrap(ctx)
+			if err != nil {
+				log.Warn("failed to rewrap data keys", "rewrapped", n, "err", err)
+			} else if n > 0 {
+				log.Info("data keys rewrapped", "count", n, "key_id", cfg.Encryption.KeyID)
+			}
+		}()
+	}
+
+	// Auth
+	var previousKeys []auth.JWTKey
+	for kid, secret := range cfg.Auth.JWTPreviousKeys {
+		previousKeys = append(previousKeys, auth.JWTKey{ID: kid, Secret: secret})
+	}
+	jwtMgr := auth.NewJWTManager(
+		auth.JWTKey{ID: cfg.Auth.JWTKeyID, Secret: cfg.Auth.JWTSecret},
+		previousKeys,
+		cfg.Auth.JWTExpiry,
+	)
+
+	var signer *auth.Signer
+	if cfg.Signing.PrivateKey != "" {
+		signer, err = auth.NewSigner(cfg.Signing.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("invalid HARBOR_SIGNING_KEY: %w", err)
+		}
+		log.Info("server artifact signing enabled", "key_id", signer.KeyID())
+	}
 
 	// Services
-	deviceSvc := service.NewDeviceService(deviceRepo, log)
-	artifactSvc := service.NewArtifactService(artifactRepo, store, log)
+	deviceSvc := service.NewDeviceService(deviceRepo, orgRepo, log)
+	signingSvc := service.NewSigningService(signingKeyRepo, signer, log)
+	deltaSvc := service.NewDeltaService(deltaRepo, artifactRepo, deviceRepo, store, service.DeltaConfig{
+		MaxSources:  cfg.Delta.Sources,
+		MaxFileSize: cfg.Delta.MaxFileSize,
+	}, log)
+	validationCfg := service.ValidationConfig{DeviceTypes: make(map[string]service.DeviceTypeLimits)}
+	for dt, limits := range cfg.Validation.DeviceTypes {
+		validationCfg.DeviceTypes[dt] = service.DeviceTypeLimits{Arch: limits.Arch, MaxSize: limits.MaxSize}
+	}
+	for _, v := range cfg.Validation.Validators {
+		validationCfg.Validators = append(validationCfg.Validators, service.ExternalValidator{
+			Name:     v.Name,
+			Path:     v.Path,
+			Blocking: v.Blocking,
+			Timeout:  time.Duration(v.TimeoutSec) * time.Second,
+		})
+	}
+	validationSvc := service.NewValidationService(store, validationCfg, log)
+	artifactSvc := service.NewArtifactService(artifactRepo, blobRepo, store, signingSvc, deltaSvc, validationSvc, archive.Limits{
+		MaxEntries: cfg.Archive.MaxEntries,
+		MaxSize:    cfg.Archive.MaxSize,
+	}, cfg.Storage.Quota, log)
+	uploadSvc := service.NewUploadService(uploadRepo, artifactSvc, store, cfg.Storage.UploadSessionTTL, log)
 	deploymentSvc := service.NewDeploymentService(deploymentRepo, deviceRepo, artifactRepo, log)
 	auditSvc := service.NewAuditService(auditRepo, log)
-	cleanupSvc := service.NewCleanupService(artifactRepo, deploymentRepo, store, log)
+	channelSvc := service.NewChannelService(channelRepo, artifactRepo, deploymentSvc, auditSvc, log)
+	stateSvc := service.NewStateService(deploymentRepo, deviceRepo, artifactRepo, log)
+	manifestSvc := service.NewManifestService(manifestRepo, artifactRepo, deviceRepo, deploymentRepo, deploymentSvc, log)
+	cleanupSvc := service.NewCleanupService(uploadSvc, artifactSvc, deploymentRepo, manifestRepo, channelRepo, orgRepo, stateSvc, service.RetentionPolicy{
+		KeepVersions: cfg.Retention.KeepVersions,
+		KeepDeployed: cfg.Retention.KeepDeployed,
+	}, log)
+	scrubSvc := service.NewScrubService(blobRepo, artifactRepo, scrubRepo, store, log)
+	userSvc := service.NewUserService(userRepo, settingsRepo, log)
+	orgSvc := service.NewOrganizationService(orgRepo, log)
+	authSvc := service.NewAuthService(tokenRepo, userRepo, settingsRepo, jwtMgr, cfg.Auth.RefreshTokenExpiry, log)
+
+	if err := userSvc.EnsureAdmin(ctx, cfg.Auth.AdminEmail, cfg.Auth.AdminPassword); err != nil {
+		return fmt.Errorf("bootstrap admin: %w", err)
+	}
 
 	// Start cleanup scheduler (every 6 hours)
 	go cleanupSvc.StartScheduler(ctx, 6*time.Hour)
 
-	// Auth
-	jwtMgr := auth.NewJWTManager(cfg.Auth.JWTSecret, cfg.Auth.JWTExpiry)
+	// Verify stored files against their checksums
+	if cfg.Storage.ScrubInterval > 0 {
+		go scrubSvc.StartScheduler(ctx, cfg.Storage.ScrubInterval)
+	}
+
+	// Purge expired refresh tokens and denylist entries (hourly)
+	go authSvc.StartPurgeScheduler(ctx, time.Hour)
 
 	// Router
 	router := api.NewRouter(api.RouterDeps{
@@ -97,7 +199,17 @@ func run(log *slog.Logger) error {
 		ArtifactSvc:   artifactSvc,
 		DeploymentSvc: deploymentSvc,
 		AuditSvc:      auditSvc,
-		JWTManager:    jwtMgr,
+		AuthSvc:       authSvc,
+		UserSvc:       userSvc,
+		OrgSvc:        orgSvc,
+		SigningSvc:    signingSvc,
+		DeltaSvc:      deltaSvc,
+		UploadSvc:     uploadSvc,
+		ScrubSvc:      scrubSvc,
+		CleanupSvc:    cleanupSvc,
+		ChannelSvc:    channelSvc,
+		StateSvc:      stateSvc,
+		ManifestSvc:   manifestSvc,
 		CORSOrigins:   cfg.CORS.AllowedOrigins,
 		Logger:        log,
 	})
@@ -143,3 +255,29 @@ func run(log *slog.Logger) error {
 	log.Info("server stopped")
 	return nil
 }
+
+func newEncryptedStore(inner storage.FileStore, keys *postgres.DataKeyRepo, cfg config.EncryptionConfig) (*encrypted.Store, error) {
+	var previous []encrypted.MasterKey
+	for kid, key := range cfg.PreviousKeys {
+		previous = append(previous, encrypted.MasterKey{ID: kid, Key: key})
+	}
+	return encrypted.New(inner, keys, encrypted.MasterKey{ID: cfg.KeyID, Key: cfg.Key}, previous)
+}
+
+func newFileStore(cfg config.StorageConfig) (storage.FileStore, error) {
+	if cfg.Backend != "s3" {
+		return local.New(cfg.Path)
+	}
+	return s3.New(s3.Config{
+		Endpoint:       cfg.S3.Endpoint,
+		PublicEndpoint: cfg.S3.PublicEndpoint,
+		Region:         cfg.S3.Region,
+		Bucket:         cfg.S3.Bucket,
+		AccessKey:      cfg.S3.AccessKey,
+		SecretKey:      cfg.S3.SecretKey,
+		Prefix:         cfg.S3.Prefix,
+		PathStyle:      cfg.S3.PathStyle,
+		PartSize:       cfg.S3.PartSize,
+		PresignExpiry:  cfg.S3.PresignExpiry,
+	})
+}
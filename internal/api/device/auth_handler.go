@@ -48,6 +48,10 @@ func (h *AuthHandler) Authenticate(w http.ResponseWriter, r *http.Request) {
 			response.Error(w, http.StatusForbidden, "device has been rejected")
 			return
 		}
+		if errors.Is(err, domain.ErrUnauthorized) {
+			response.Error(w, http.StatusUnauthorized, "invalid tenant token")
+			return
+		}
 		if errors.Is(err, domain.ErrInvalidInput) {
 			response.Error(w, http.StatusBadRequest, err.Error())
 			return
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -13,15 +14,18 @@ import (
 	"github.com/CaioWing/Harbor/internal/api/response"
 	"github.com/CaioWing/Harbor/internal/domain"
 	"github.com/CaioWing/Harbor/internal/service"
+	"github.com/CaioWing/Harbor/internal/storage/encrypted"
 )
 
 type DeploymentHandler struct {
 	deploySvc   *service.DeploymentService
 	artifactSvc *service.ArtifactService
+	deltaSvc    *service.DeltaService
+	deviceSvc   *service.DeviceService
 }
 
-func NewDeploymentHandler(deploySvc *service.DeploymentService, artifactSvc *service.ArtifactService) *DeploymentHandler {
-	return &DeploymentHandler{deploySvc: deploySvc, artifactSvc: artifactSvc}
+func NewDeploymentHandler(deploySvc *service.DeploymentService, artifactSvc *service.ArtifactService, deltaSvc *service.DeltaService, deviceSvc *service.DeviceService) *DeploymentHandler {
+	return &DeploymentHandler{deploySvc: deploySvc, artifactSvc: artifactSvc, deltaSvc: deltaSvc, deviceSvc: deviceSvc}
 }
 
 type nextDeploymentResponse struct {
@@ -38,15 +42,60 @@ type retryConfig struct {
 }
 
 type artifactResponse struct {
-	Name           string `json:"name"`
-	Version        string `json:"version"`
+	Name           string                `json:"name"`
+	Version        string                `json:"version"`
+	Kind           domain.ArtifactKind   `json:"kind"`
+	TargetPath     string                `json:"target_path,omitempty"`
+	SourcePath     string                `json:"source_path,omitempty"`
+	FileMode       string                `json:"file_mode,omitempty"`
+	FileOwner      string                `json:"file_owner,omitempty"`
+	ChecksumSHA256 string                `json:"checksum_sha256"`
+	FileSize       int64                 `json:"file_size"`
+	DownloadURL    string                `json:"download_url,omitempty"`
+	DirectURL      string                `json:"direct_url,omitempty"`
+	Files          []fileResponse        `json:"files,omitempty"`
+	ArchiveFormat  string                `json:"archive_format,omitempty"`
+	Entries        []domain.ArchiveEntry `json:"entries,omitempty"`
+	Script         *domain.ScriptSpec    `json:"script,omitempty"`
+	PreInstallCmd  string                `json:"pre_install_cmd,omitempty"`
+	PostInstallCmd string                `json:"post_install_cmd,omitempty"`
+	Signature      string                `json:"signature,omitempty"`
+	SigningKeyID   string                `json:"signing_key_id,omitempty"`
+	Delta          *deltaResponse        `json:"delta,omitempty"`
+	Encryption     *encryption           `json:"encryption,omitempty"`
+}
+
+// fileResponse is one file of a bundle. The agent downloads every file,
+// checks the signature over the bundle manifest, and only then installs them
+// all, running the bundle's hooks once.
+type fileResponse struct {
 	TargetPath     string `json:"target_path"`
 	FileMode       string `json:"file_mode"`
+	FileOwner      string `json:"file_owner,omitempty"`
+	ChecksumSHA256 string `json:"checksum_sha256"`
+	FileSize       int64  `json:"file_size"`
+	DownloadURL    string `json:"download_url"`
+	DirectURL      string `json:"direct_url,omitempty"`
+}
+
+// encryption tells the agent that the download is sealed for its registered
+// key. Checksum, size and signature are those of the decrypted file.
+type encryption struct {
+	Algorithm     string `json:"algorithm"`
+	EncryptedSize int64  `json:"encrypted_size"`
+}
+
+// deltaResponse offers a patch from the version installed on the device. The
+// agent applies it only if its file matches SourceChecksum and must fall back
+// to DownloadURL of the artifact when the patched file does not match the
+// artifact checksum.
+type deltaResponse struct {
+	SourceVersion  string `json:"source_version"`
+	SourceChecksum string `json:"source_checksum_sha256"`
 	ChecksumSHA256 string `json:"checksum_sha256"`
 	FileSize       int64  `json:"file_size"`
 	DownloadURL    string `json:"download_url"`
-	PreInstallCmd  string `json:"pre_install_cmd,omitempty"`
-	PostInstallCmd string `json:"post_install_cmd,omitempty"`
+	DirectURL      string `json:"direct_url,omitempty"`
 }
 
 func (h *DeploymentHandler) GetNext(w http.ResponseWriter, r *http.Request) {
@@ -57,7 +106,7 @@ func (h *DeploymentHandler) GetNext(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dd, dep, art, err := h.deploySvc.GetNextForDevice(r.Context(), deviceID)
+	dd, dep, art, err := h.deploySvc.GetNextForDevice(r.Context(), middleware.OrgID(r.Context()), deviceID)
 	if err != nil {
 		if errors.Is(err, domain.ErrNotFound) {
 			w.WriteHeader(http.StatusNoContent)
@@ -67,31 +116,131 @@ func (h *DeploymentHandler) GetNext(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	response.JSON(w, http.StatusOK, nextDeploymentResponse{
+	var dev *domain.Device
+	if art.EncryptForDevice || art.IsTemplate() {
+		if dev, err = h.deviceSvc.GetByID(r.Context(), middleware.OrgID(r.Context()), deviceID); err != nil {
+			response.Error(w, http.StatusInternalServerError, "failed to check deployments")
+			return
+		}
+	}
+	if art.EncryptForDevice && dev.EncryptionKey == "" {
+		response.Error(w, http.StatusConflict, "device must register an encryption key to receive this deployment")
+		return
+	}
+
+	resp := nextDeploymentResponse{
 		DeploymentID: dep.ID.String(),
 		DDID:         dd.ID.String(),
 		Artifact: artifactResponse{
 			Name:           art.Name,
 			Version:        art.Version,
-			TargetPath:     art.TargetPath,
-			FileMode:       art.FileMode,
+			Kind:           art.Kind,
 			ChecksumSHA256: art.ChecksumSHA256,
 			FileSize:       art.FileSize,
-			DownloadURL:    fmt.Sprintf("/api/v1/device/deployments/%s/download", dd.ID),
 			PreInstallCmd:  art.PreInstallCmd,
 			PostInstallCmd: art.PostInstallCmd,
+			Signature:      art.Signature,
+			SigningKeyID:   art.SigningKeyID,
 		},
 		Retry: retryConfig{
 			MaxAttempts: 3,
 			IntervalSec: 30,
 			BackoffMul:  2, // exponential backoff: 30s, 60s, 120s
 		},
-	})
+	}
+
+	if art.IsBundle() {
+		for i := range art.Files {
+			f := &art.Files[i]
+			file := fileResponse{
+				TargetPath:     f.TargetPath,
+				FileMode:       f.FileMode,
+				FileOwner:      f.FileOwner,
+				ChecksumSHA256: f.ChecksumSHA256,
+				FileSize:       f.FileSize,
+				DownloadURL:    fmt.Sprintf("/api/v1/device/deployments/%s/files/%d", dd.ID, i),
+			}
+			if url, err := h.artifactSvc.FileDirectURL(f); err == nil {
+				file.DirectURL = url
+			}
+			resp.Artifact.Files = append(resp.Artifact.Files, file)
+		}
+		response.JSON(w, http.StatusOK, resp)
+		return
+	}
+
+	if art.IsOperation() {
+		// Nothing to download: the agent applies the operation and checks
+		// the signature over the operation manifest
+		resp.Artifact.TargetPath = art.TargetPath
+		resp.Artifact.SourcePath = art.SourcePath
+		resp.Artifact.FileMode = art.FileMode
+		resp.Artifact.FileOwner = art.FileOwner
+		response.JSON(w, http.StatusOK, resp)
+		return
+	}
+
+	if art.IsTemplate() {
+		// Checksum, size and signature are those of the file rendered for
+		// this device
+		rendered, err := h.artifactSvc.RenderForDevice(art, dev, dep.Variables)
+		if err != nil {
+			if errors.Is(err, domain.ErrInvalidInput) {
+				// The device cannot install a file that does not render
+				h.deploySvc.UpdateDeviceStatus(r.Context(), middleware.OrgID(r.Context()), dd.DeviceID, dd.ID, domain.DDStatusFailure, err.Error())
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			response.Error(w, http.StatusInternalServerError, "failed to render template")
+			return
+		}
+		resp.Artifact.ChecksumSHA256 = rendered.ChecksumSHA256
+		resp.Artifact.FileSize = int64(len(rendered.Content))
+		resp.Artifact.Signature = rendered.Signature
+		resp.Artifact.SigningKeyID = rendered.SigningKeyID
+	}
+
+	resp.Artifact.TargetPath = art.TargetPath
+	resp.Artifact.FileMode = art.FileMode
+	resp.Artifact.ArchiveFormat = art.ArchiveFormat
+	resp.Artifact.Entries = art.Entries
+	resp.Artifact.Script = art.Script
+	resp.Artifact.DownloadURL = fmt.Sprintf("/api/v1/device/deployments/%s/download", dd.ID)
+	if art.EncryptForDevice {
+		resp.Artifact.Encryption = &encryption{
+			Algorithm:     encrypted.DeviceAlgorithm,
+			EncryptedSize: encrypted.SealedSize(resp.Artifact.FileSize),
+		}
+	}
+
+	// Direct URLs let the device download from the object store; without one,
+	// or when it expires, the device uses DownloadURL
+	if url, err := h.artifactSvc.DirectURL(art); err == nil {
+		resp.Artifact.DirectURL = url
+	}
+
+	// A delta is an optimisation: on any error the full file is offered
+	if d, err := h.deltaSvc.ForDevice(r.Context(), middleware.OrgID(r.Context()), deviceID, art); err == nil && d != nil {
+		resp.Artifact.Delta = &deltaResponse{
+			SourceVersion:  d.SourceVersion,
+			SourceChecksum: d.SourceChecksum,
+			ChecksumSHA256: d.ChecksumSHA256,
+			FileSize:       d.FileSize,
+			DownloadURL:    fmt.Sprintf("/api/v1/device/deployments/%s/delta", dd.ID),
+		}
+		if url, err := h.deltaSvc.DirectURL(d, deltaFileName(art, d)); err == nil {
+			resp.Artifact.Delta.DirectURL = url
+		}
+	}
+
+	response.JSON(w, http.StatusOK, resp)
 }
 
 type statusUpdateRequest struct {
 	Status string `json:"status"`
 	Log    string `json:"log"`
+	// ExitCode is the exit code of a script artifact
+	ExitCode *int `json:"exit_code,omitempty"`
 }
 
 func (h *DeploymentHandler) UpdateStatus(w http.ResponseWriter, r *http.Request) {
@@ -117,7 +266,29 @@ func (h *DeploymentHandler) UpdateStatus(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if err := h.deploySvc.UpdateDeviceStatus(r.Context(), ddID, status, req.Log); err != nil {
+	deviceIDStr, _ := r.Context().Value(middleware.DeviceIDKey).(string)
+	deviceID, err := uuid.Parse(deviceIDStr)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid device context")
+		return
+	}
+
+	if req.ExitCode != nil {
+		err = h.deploySvc.FinishScript(r.Context(), middleware.OrgID(r.Context()), deviceID, ddID, status, req.Log, *req.ExitCode)
+		switch {
+		case err == nil:
+			w.WriteHeader(http.StatusNoContent)
+		case errors.Is(err, domain.ErrInvalidInput):
+			response.Error(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, domain.ErrNotFound):
+			response.Error(w, http.StatusNotFound, "no active deployment with this id")
+		default:
+			response.Error(w, http.StatusInternalServerError, "failed to update status")
+		}
+		return
+	}
+
+	if err := h.deploySvc.UpdateDeviceStatus(r.Context(), middleware.OrgID(r.Context()), deviceID, ddID, status, req.Log); err != nil {
 		if errors.Is(err, domain.ErrNotFound) {
 			response.Error(w, http.StatusNotFound, "deployment device not found")
 			return
@@ -129,47 +300,173 @@ func (h *DeploymentHandler) UpdateStatus(w http.ResponseWriter, r *http.Request)
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func (h *DeploymentHandler) Download(w http.ResponseWriter, r *http.Request) {
-	ddIDStr := chi.URLParam(r, "id")
-	_, err := uuid.Parse(ddIDStr)
+type outputRequest struct {
+	Stream string `json:"stream"`
+	Data   string `json:"data"`
+}
+
+type outputAck struct {
+	Truncated bool `json:"truncated"`
+}
+
+// AppendOutput stores a chunk of the output of the script of a deployment
+// device entry. Agents send chunks as the script writes them, in order, and
+// report the exit code with the final status.
+func (h *DeploymentHandler) AppendOutput(w http.ResponseWriter, r *http.Request) {
+	ddID, err := uuid.Parse(chi.URLParam(r, "id"))
 	if err != nil {
-		response.Error(w, http.StatusBadRequest, "invalid id")
+		response.Error(w, http.StatusBadRequest, "invalid deployment device id")
 		return
 	}
-
-	// For now, extract artifact from the deployment context
-	// In a full implementation, we'd validate the dd belongs to the requesting device
 	deviceIDStr, _ := r.Context().Value(middleware.DeviceIDKey).(string)
-	deviceID, _ := uuid.Parse(deviceIDStr)
+	deviceID, err := uuid.Parse(deviceIDStr)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid device context")
+		return
+	}
+
+	var req outputRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
 
-	dd, _, art, err := h.deploySvc.GetNextForDevice(r.Context(), deviceID)
+	truncated, err := h.deploySvc.AppendOutput(r.Context(), middleware.OrgID(r.Context()), deviceID, ddID, domain.OutputStream(req.Stream), req.Data)
 	if err != nil {
-		response.Error(w, http.StatusNotFound, "no pending deployment")
+		if errors.Is(err, domain.ErrInvalidInput) {
+			response.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, domain.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "no active deployment with this id")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to store output")
 		return
 	}
-	_ = dd
 
-	reader, artifact, err := h.artifactSvc.OpenFile(r.Context(), art.ID)
+	response.JSON(w, http.StatusOK, outputAck{Truncated: truncated})
+}
+
+// Download serves the artifact of a deployment device entry of the calling
+// device, rendered for the device when it is a template and sealed for the
+// device's key when the artifact is encrypted for each device. It keeps
+// working while the entry is downloading or installing, and honours Range
+// requests so that interrupted downloads can resume.
+func (h *DeploymentHandler) Download(w http.ResponseWriter, r *http.Request) {
+	dd, dep, art, ok := h.deploymentForDownload(w, r)
+	if !ok {
+		return
+	}
+
+	dev, err := h.deviceSvc.GetByID(r.Context(), middleware.OrgID(r.Context()), dd.DeviceID)
+	if err != nil {
+		response.Error(w, http.StatusInternalServerError, "failed to open artifact")
+		return
+	}
+
+	reader, checksum, err := h.artifactSvc.OpenForDevice(art, dev, dep.Variables, dd.DeliverySeed)
 	if err != nil {
+		if errors.Is(err, domain.ErrNoEncryptionKey) {
+			response.Error(w, http.StatusConflict, "device must register an encryption key to receive this deployment")
+			return
+		}
+		if errors.Is(err, domain.ErrInvalidInput) {
+			response.Error(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
 		response.Error(w, http.StatusInternalServerError, "failed to open artifact")
 		return
 	}
 	defer reader.Close()
 
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, artifact.FileName))
-	w.Header().Set("X-Checksum-SHA256", artifact.ChecksumSHA256)
-	http.ServeContent(w, r, artifact.FileName, artifact.CreatedAt, nil)
-
-	// Stream the file
-	buf := make([]byte, 32*1024)
-	for {
-		n, readErr := reader.Read(buf)
-		if n > 0 {
-			w.Write(buf[:n])
+	name := art.FileName
+	if art.EncryptForDevice {
+		name += ".enc"
+	}
+	response.File(w, r, reader, name, checksum, art.CreatedAt)
+}
+
+// DownloadFile serves one file of the bundle of a deployment device entry,
+// by its index in the files of the bundle.
+func (h *DeploymentHandler) DownloadFile(w http.ResponseWriter, r *http.Request) {
+	index, err := strconv.Atoi(chi.URLParam(r, "index"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid file index")
+		return
+	}
+	_, _, art, ok := h.deploymentForDownload(w, r)
+	if !ok {
+		return
+	}
+
+	reader, file, err := h.artifactSvc.OpenBundleFile(art, index)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "file not found in bundle")
+			return
 		}
-		if readErr != nil {
-			break
+		response.Error(w, http.StatusInternalServerError, "failed to open file")
+		return
+	}
+	defer reader.Close()
+
+	response.File(w, r, reader, file.FileName, file.ChecksumSHA256, art.CreatedAt)
+}
+
+// DownloadDelta serves the patch offered for a deployment device entry.
+func (h *DeploymentHandler) DownloadDelta(w http.ResponseWriter, r *http.Request) {
+	dd, _, art, ok := h.deploymentForDownload(w, r)
+	if !ok {
+		return
+	}
+
+	d, err := h.deltaSvc.ForDevice(r.Context(), middleware.OrgID(r.Context()), dd.DeviceID, art)
+	if err != nil {
+		response.Error(w, http.StatusInternalServerError, "failed to find delta")
+		return
+	}
+	if d == nil {
+		response.Error(w, http.StatusNotFound, "no delta for the installed version")
+		return
+	}
+
+	reader, err := h.deltaSvc.Open(d)
+	if err != nil {
+		response.Error(w, http.StatusInternalServerError, "failed to open delta")
+		return
+	}
+	defer reader.Close()
+
+	response.File(w, r, reader, deltaFileName(art, d), d.ChecksumSHA256, d.CreatedAt)
+}
+
+func deltaFileName(art *domain.Artifact, d *domain.ArtifactDelta) string {
+	return fmt.Sprintf("%s-%s-from-%s.delta", art.Name, art.Version, d.SourceVersion)
+}
+
+func (h *DeploymentHandler) deploymentForDownload(w http.ResponseWriter, r *http.Request) (*domain.DeploymentDevice, *domain.Deployment, *domain.Artifact, bool) {
+	ddID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid id")
+		return nil, nil, nil, false
+	}
+
+	deviceIDStr, _ := r.Context().Value(middleware.DeviceIDKey).(string)
+	deviceID, err := uuid.Parse(deviceIDStr)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid device context")
+		return nil, nil, nil, false
+	}
+
+	dd, dep, art, err := h.deploySvc.GetForDownload(r.Context(), middleware.OrgID(r.Context()), deviceID, ddID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "no active deployment with this id")
+			return nil, nil, nil, false
 		}
+		response.Error(w, http.StatusInternalServerError, "failed to check deployment")
+		return nil, nil, nil, false
 	}
+	return dd, dep, art, true
 }
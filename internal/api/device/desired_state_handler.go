@@ -0,0 +1,40 @@
+package device
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"github.com/CaioWing/Harbor/internal/api/middleware"
+	"github.com/CaioWing/Harbor/internal/api/response"
+	"github.com/CaioWing/Harbor/internal/service"
+)
+
+type DesiredStateHandler struct {
+	manifestSvc *service.ManifestService
+}
+
+func NewDesiredStateHandler(manifestSvc *service.ManifestService) *DesiredStateHandler {
+	return &DesiredStateHandler{manifestSvc: manifestSvc}
+}
+
+// Get returns the artifacts the manifests of the device declare, compared
+// with its inventory. Those it does not match are queued for it when it
+// reports its inventory, and returned by /deployments/next as any
+// deployment.
+func (h *DesiredStateHandler) Get(w http.ResponseWriter, r *http.Request) {
+	deviceIDStr, _ := r.Context().Value(middleware.DeviceIDKey).(string)
+	deviceID, err := uuid.Parse(deviceIDStr)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid device context")
+		return
+	}
+
+	items, err := h.manifestSvc.DesiredState(r.Context(), middleware.OrgID(r.Context()), deviceID)
+	if err != nil {
+		response.Error(w, http.StatusInternalServerError, "failed to get desired state")
+		return
+	}
+
+	response.JSON(w, http.StatusOK, map[string]interface{}{"data": items})
+}
@@ -2,23 +2,28 @@ package device
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/google/uuid"
 
 	"github.com/CaioWing/Harbor/internal/api/middleware"
 	"github.com/CaioWing/Harbor/internal/api/response"
+	"github.com/CaioWing/Harbor/internal/domain"
 	"github.com/CaioWing/Harbor/internal/service"
 )
 
 type InventoryHandler struct {
-	deviceSvc *service.DeviceService
+	deviceSvc   *service.DeviceService
+	manifestSvc *service.ManifestService
 }
 
-func NewInventoryHandler(deviceSvc *service.DeviceService) *InventoryHandler {
-	return &InventoryHandler{deviceSvc: deviceSvc}
+func NewInventoryHandler(deviceSvc *service.DeviceService, manifestSvc *service.ManifestService) *InventoryHandler {
+	return &InventoryHandler{deviceSvc: deviceSvc, manifestSvc: manifestSvc}
 }
 
+// Update stores the inventory the device reports and queues it for the
+// artifacts of its manifests the report does not match.
 func (h *InventoryHandler) Update(w http.ResponseWriter, r *http.Request) {
 	deviceIDStr, _ := r.Context().Value(middleware.DeviceIDKey).(string)
 	deviceID, err := uuid.Parse(deviceIDStr)
@@ -33,10 +38,50 @@ func (h *InventoryHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.deviceSvc.UpdateInventory(r.Context(), deviceID, inventory); err != nil {
+	if err := h.deviceSvc.UpdateInventory(r.Context(), middleware.OrgID(r.Context()), deviceID, inventory); err != nil {
+		if errors.Is(err, domain.ErrInvalidInput) {
+			response.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		response.Error(w, http.StatusInternalServerError, "failed to update inventory")
 		return
 	}
+	if err := h.manifestSvc.Reconcile(r.Context(), middleware.OrgID(r.Context()), deviceID); err != nil {
+		response.Error(w, http.StatusInternalServerError, "failed to reconcile manifests")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type encryptionKeyRequest struct {
+	PublicKey string `json:"public_key"`
+}
+
+// SetEncryptionKey registers the X25519 public key that artifacts encrypted
+// for each device are sealed for. The agent keeps the private key.
+func (h *InventoryHandler) SetEncryptionKey(w http.ResponseWriter, r *http.Request) {
+	deviceIDStr, _ := r.Context().Value(middleware.DeviceIDKey).(string)
+	deviceID, err := uuid.Parse(deviceIDStr)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid device context")
+		return
+	}
+
+	var req encryptionKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := h.deviceSvc.SetEncryptionKey(r.Context(), middleware.OrgID(r.Context()), deviceID, req.PublicKey); err != nil {
+		if errors.Is(err, domain.ErrInvalidInput) {
+			response.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to set encryption key")
+		return
+	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,44 @@
+package device
+
+import (
+	"net/http"
+
+	"github.com/CaioWing/Harbor/internal/api/middleware"
+	"github.com/CaioWing/Harbor/internal/api/response"
+	"github.com/CaioWing/Harbor/internal/service"
+)
+
+type SigningKeyHandler struct {
+	signingSvc *service.SigningService
+}
+
+func NewSigningKeyHandler(signingSvc *service.SigningService) *SigningKeyHandler {
+	return &SigningKeyHandler{signingSvc: signingSvc}
+}
+
+type trustedKeyResponse struct {
+	KeyID     string `json:"key_id"`
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"public_key"`
+}
+
+// List returns the public keys the device should accept artifact
+// signatures from. Revoked keys are omitted.
+func (h *SigningKeyHandler) List(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.signingSvc.TrustedKeys(r.Context(), middleware.OrgID(r.Context()))
+	if err != nil {
+		response.Error(w, http.StatusInternalServerError, "failed to list signing keys")
+		return
+	}
+
+	resp := make([]trustedKeyResponse, 0, len(keys))
+	for _, k := range keys {
+		resp = append(resp, trustedKeyResponse{
+			KeyID:     k.KeyID,
+			Algorithm: "ed25519",
+			PublicKey: k.PublicKey,
+		})
+	}
+
+	response.JSON(w, http.StatusOK, resp)
+}
@@ -1,14 +1,17 @@
 package management
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 
+	"github.com/CaioWing/Harbor/internal/api/middleware"
 	"github.com/CaioWing/Harbor/internal/api/response"
 	"github.com/CaioWing/Harbor/internal/domain"
 	"github.com/CaioWing/Harbor/internal/service"
@@ -16,10 +19,13 @@ import (
 
 type ArtifactHandler struct {
 	artifactSvc *service.ArtifactService
+	deltaSvc    *service.DeltaService
+	deviceSvc   *service.DeviceService
+	deploySvc   *service.DeploymentService
 }
 
-func NewArtifactHandler(artifactSvc *service.ArtifactService) *ArtifactHandler {
-	return &ArtifactHandler{artifactSvc: artifactSvc}
+func NewArtifactHandler(artifactSvc *service.ArtifactService, deltaSvc *service.DeltaService, deviceSvc *service.DeviceService, deploySvc *service.DeploymentService) *ArtifactHandler {
+	return &ArtifactHandler{artifactSvc: artifactSvc, deltaSvc: deltaSvc, deviceSvc: deviceSvc, deploySvc: deploySvc}
 }
 
 func (h *ArtifactHandler) List(w http.ResponseWriter, r *http.Request) {
@@ -39,8 +45,16 @@ func (h *ArtifactHandler) List(w http.ResponseWriter, r *http.Request) {
 	if dt := q.Get("device_type"); dt != "" {
 		filter.DeviceType = &dt
 	}
+	if v := q.Get("quarantined"); v != "" {
+		quarantined, err := strconv.ParseBool(v)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, "invalid quarantined filter")
+			return
+		}
+		filter.Quarantined = &quarantined
+	}
 
-	artifacts, total, err := h.artifactSvc.List(r.Context(), filter)
+	artifacts, total, err := h.artifactSvc.List(r.Context(), middleware.OrgID(r.Context()), filter)
 	if err != nil {
 		response.Error(w, http.StatusInternalServerError, "failed to list artifacts")
 		return
@@ -49,6 +63,58 @@ func (h *ArtifactHandler) List(w http.ResponseWriter, r *http.Request) {
 	response.Paginated(w, http.StatusOK, artifacts, page, perPage, total)
 }
 
+// Latest returns the highest version of each artifact name for each device
+// type, optionally for one name or device type. Prereleases are left out
+// unless prerelease=true.
+func (h *ArtifactHandler) Latest(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var filter domain.LatestArtifactFilter
+	if name := q.Get("name"); name != "" {
+		filter.Name = &name
+	}
+	if dt := q.Get("device_type"); dt != "" {
+		filter.DeviceType = &dt
+	}
+	if v := q.Get("prerelease"); v != "" {
+		prerelease, err := strconv.ParseBool(v)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, "invalid prerelease filter")
+			return
+		}
+		filter.Prerelease = prerelease
+	}
+
+	latest, err := h.artifactSvc.ListLatest(r.Context(), middleware.OrgID(r.Context()), filter)
+	if err != nil {
+		response.Error(w, http.StatusInternalServerError, "failed to list latest artifacts")
+		return
+	}
+
+	response.JSON(w, http.StatusOK, map[string]interface{}{"data": latest})
+}
+
+// Lineage returns the versions of the artifact name given in the query,
+// highest first, with their deployments and success rates.
+func (h *ArtifactHandler) Lineage(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		response.Error(w, http.StatusBadRequest, "name is required")
+		return
+	}
+
+	lineage, err := h.deploySvc.Lineage(r.Context(), middleware.OrgID(r.Context()), name)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "artifact not found")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to get artifact lineage")
+		return
+	}
+
+	response.JSON(w, http.StatusOK, lineage)
+}
+
 func (h *ArtifactHandler) Get(w http.ResponseWriter, r *http.Request) {
 	id, err := uuid.Parse(chi.URLParam(r, "id"))
 	if err != nil {
@@ -56,7 +122,7 @@ func (h *ArtifactHandler) Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	artifact, err := h.artifactSvc.GetByID(r.Context(), id)
+	artifact, err := h.artifactSvc.GetByID(r.Context(), middleware.OrgID(r.Context()), id)
 	if err != nil {
 		if errors.Is(err, domain.ErrNotFound) {
 			response.Error(w, http.StatusNotFound, "artifact not found")
@@ -69,6 +135,101 @@ func (h *ArtifactHandler) Get(w http.ResponseWriter, r *http.Request) {
 	response.JSON(w, http.StatusOK, artifact)
 }
 
+// ListDeltas returns the deltas generated to and from an artifact.
+func (h *ArtifactHandler) ListDeltas(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid artifact id")
+		return
+	}
+
+	orgID := middleware.OrgID(r.Context())
+	if _, err := h.artifactSvc.GetByID(r.Context(), orgID, id); err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "artifact not found")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to get artifact")
+		return
+	}
+
+	deltas, err := h.deltaSvc.List(r.Context(), orgID, id)
+	if err != nil {
+		response.Error(w, http.StatusInternalServerError, "failed to list deltas")
+		return
+	}
+
+	response.JSON(w, http.StatusOK, deltas)
+}
+
+type previewRequest struct {
+	DeviceID  string            `json:"device_id"`
+	Variables map[string]string `json:"variables"`
+}
+
+type previewResponse struct {
+	Content        string `json:"content"`
+	ChecksumSHA256 string `json:"checksum_sha256"`
+	FileSize       int64  `json:"file_size"`
+}
+
+// Preview renders a template artifact for a device with the given
+// variables, as a deployment with those variables would deliver it.
+func (h *ArtifactHandler) Preview(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid artifact id")
+		return
+	}
+
+	var req previewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	deviceID, err := uuid.Parse(req.DeviceID)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid device_id")
+		return
+	}
+
+	orgID := middleware.OrgID(r.Context())
+	artifact, err := h.artifactSvc.GetByID(r.Context(), orgID, id)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "artifact not found")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to get artifact")
+		return
+	}
+	device, err := h.deviceSvc.GetByID(r.Context(), orgID, deviceID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "device not found")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to get device")
+		return
+	}
+
+	rendered, err := h.artifactSvc.RenderForDevice(artifact, device, req.Variables)
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidInput) {
+			response.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to render template")
+		return
+	}
+
+	response.JSON(w, http.StatusOK, previewResponse{
+		Content:        string(rendered.Content),
+		ChecksumSHA256: rendered.ChecksumSHA256,
+		FileSize:       int64(len(rendered.Content)),
+	})
+}
+
 func (h *ArtifactHandler) Upload(w http.ResponseWriter, r *http.Request) {
 	// Max 500MB
 	if err := r.ParseMultipartForm(500 << 20); err != nil {
@@ -76,6 +237,33 @@ func (h *ArtifactHandler) Upload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	deviceTypes := strings.Split(r.FormValue("device_types"), ",")
+	for i := range deviceTypes {
+		deviceTypes[i] = strings.TrimSpace(deviceTypes[i])
+	}
+
+	var requirements *domain.Requirements
+	if v := r.FormValue("requirements"); v != "" {
+		if err := json.Unmarshal([]byte(v), &requirements); err != nil {
+			response.Error(w, http.StatusBadRequest, "invalid requirements")
+			return
+		}
+	}
+
+	kind := domain.ArtifactKind(r.FormValue("kind"))
+	switch kind {
+	case "", domain.ArtifactKindFile, domain.ArtifactKindArchive, domain.ArtifactKindTemplate, domain.ArtifactKindScript:
+	case domain.ArtifactKindBundle:
+		h.uploadBundle(w, r, deviceTypes, requirements)
+		return
+	case domain.ArtifactKindDelete, domain.ArtifactKindRename, domain.ArtifactKindSymlink, domain.ArtifactKindMkdir:
+		h.uploadOperation(w, r, kind, deviceTypes, requirements)
+		return
+	default:
+		response.Error(w, http.StatusBadRequest, "invalid kind")
+		return
+	}
+
 	file, header, err := r.FormFile("file")
 	if err != nil {
 		response.Error(w, http.StatusBadRequest, "file is required")
@@ -83,27 +271,190 @@ func (h *ArtifactHandler) Upload(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	deviceTypes := strings.Split(r.FormValue("device_types"), ",")
-	for i := range deviceTypes {
-		deviceTypes[i] = strings.TrimSpace(deviceTypes[i])
+	var encryptForDevice bool
+	if v := r.FormValue("encrypt_for_device"); v != "" {
+		if encryptForDevice, err = strconv.ParseBool(v); err != nil {
+			response.Error(w, http.StatusBadRequest, "invalid encrypt_for_device")
+			return
+		}
+	}
+
+	script, err := scriptFromForm(r)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
 	input := service.CreateArtifactInput{
+		OrgID:            middleware.OrgID(r.Context()),
+		Kind:             kind,
+		Name:             r.FormValue("name"),
+		Version:          r.FormValue("version"),
+		Description:      r.FormValue("description"),
+		FileName:         header.Filename,
+		TargetPath:       r.FormValue("target_path"),
+		FileMode:         r.FormValue("file_mode"),
+		FileOwner:        r.FormValue("file_owner"),
+		DeviceTypes:      deviceTypes,
+		PreInstallCmd:    r.FormValue("pre_install_cmd"),
+		PostInstallCmd:   r.FormValue("post_install_cmd"),
+		RollbackCmd:      r.FormValue("rollback_cmd"),
+		Signature:        r.FormValue("signature"),
+		SigningKeyID:     r.FormValue("signing_key_id"),
+		ChecksumSHA256:   r.FormValue("checksum_sha256"),
+		EncryptForDevice: encryptForDevice,
+		Script:           script,
+		Requirements:     requirements,
+		File:             file,
+		Size:             header.Size,
+	}
+
+	artifact, err := h.artifactSvc.Create(r.Context(), input)
+	writeCreated(w, artifact, err)
+}
+
+// scriptFromForm reads how a script artifact is run from the interpreter,
+// timeout_sec, working_dir and env (a JSON object) fields. It returns nil when
+// none is set.
+func scriptFromForm(r *http.Request) (*domain.ScriptSpec, error) {
+	interpreter, timeout := r.FormValue("interpreter"), r.FormValue("timeout_sec")
+	workingDir, env := r.FormValue("working_dir"), r.FormValue("env")
+	if interpreter == "" && timeout == "" && workingDir == "" && env == "" {
+		if domain.ArtifactKind(r.FormValue("kind")) == domain.ArtifactKindScript {
+			return &domain.ScriptSpec{}, nil
+		}
+		return nil, nil
+	}
+
+	spec := &domain.ScriptSpec{Interpreter: interpreter, WorkingDir: workingDir}
+	if timeout != "" {
+		t, err := strconv.Atoi(timeout)
+		if err != nil {
+			return nil, errors.New("invalid timeout_sec")
+		}
+		spec.TimeoutSec = t
+	}
+	if env != "" {
+		if err := json.Unmarshal([]byte(env), &spec.Env); err != nil {
+			return nil, errors.New("invalid env")
+		}
+	}
+	return spec, nil
+}
+
+// uploadOperation creates an operation artifact from the fields of the form;
+// the form has no file.
+func (h *ArtifactHandler) uploadOperation(w http.ResponseWriter, r *http.Request, kind domain.ArtifactKind, deviceTypes []string, requirements *domain.Requirements) {
+	if v := r.FormValue("encrypt_for_device"); v != "" {
+		if encrypt, err := strconv.ParseBool(v); err != nil || encrypt {
+			response.Error(w, http.StatusBadRequest, "operations have no file to encrypt")
+			return
+		}
+	}
+
+	input := service.CreateOperationInput{
+		OrgID:          middleware.OrgID(r.Context()),
+		Kind:           kind,
 		Name:           r.FormValue("name"),
 		Version:        r.FormValue("version"),
 		Description:    r.FormValue("description"),
-		FileName:       header.Filename,
 		TargetPath:     r.FormValue("target_path"),
+		SourcePath:     r.FormValue("source_path"),
 		FileMode:       r.FormValue("file_mode"),
 		FileOwner:      r.FormValue("file_owner"),
 		DeviceTypes:    deviceTypes,
 		PreInstallCmd:  r.FormValue("pre_install_cmd"),
 		PostInstallCmd: r.FormValue("post_install_cmd"),
 		RollbackCmd:    r.FormValue("rollback_cmd"),
-		File:           file,
+		Signature:      r.FormValue("signature"),
+		SigningKeyID:   r.FormValue("signing_key_id"),
+		Requirements:   requirements,
 	}
 
-	artifact, err := h.artifactSvc.Create(r.Context(), input)
+	artifact, err := h.artifactSvc.CreateOperation(r.Context(), input)
+	writeCreated(w, artifact, err)
+}
+
+// bundleManifestEntry describes one file of a bundle upload. File is the
+// filename of one of the "file" parts of the form.
+type bundleManifestEntry struct {
+	File       string `json:"file"`
+	TargetPath string `json:"target_path"`
+	FileMode   string `json:"file_mode"`
+	FileOwner  string `json:"file_owner"`
+}
+
+// uploadBundle creates a bundle from a form with a "manifest" field and one
+// "file" part per entry of the manifest.
+func (h *ArtifactHandler) uploadBundle(w http.ResponseWriter, r *http.Request, deviceTypes []string, requirements *domain.Requirements) {
+	if v := r.FormValue("encrypt_for_device"); v != "" {
+		if encrypt, err := strconv.ParseBool(v); err != nil || encrypt {
+			response.Error(w, http.StatusBadRequest, "bundles cannot be encrypted for each device")
+			return
+		}
+	}
+
+	var manifest []bundleManifestEntry
+	if err := json.Unmarshal([]byte(r.FormValue("manifest")), &manifest); err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid manifest")
+		return
+	}
+
+	parts := make(map[string]int)
+	for i, fh := range r.MultipartForm.File["file"] {
+		parts[fh.Filename] = i
+	}
+	if len(parts) != len(r.MultipartForm.File["file"]) {
+		response.Error(w, http.StatusBadRequest, "file parts must have distinct filenames")
+		return
+	}
+	if len(manifest) != len(parts) {
+		response.Error(w, http.StatusBadRequest, "manifest must list every file part once")
+		return
+	}
+
+	input := service.CreateBundleInput{
+		OrgID:          middleware.OrgID(r.Context()),
+		Name:           r.FormValue("name"),
+		Version:        r.FormValue("version"),
+		Description:    r.FormValue("description"),
+		DeviceTypes:    deviceTypes,
+		PreInstallCmd:  r.FormValue("pre_install_cmd"),
+		PostInstallCmd: r.FormValue("post_install_cmd"),
+		RollbackCmd:    r.FormValue("rollback_cmd"),
+		Signature:      r.FormValue("signature"),
+		SigningKeyID:   r.FormValue("signing_key_id"),
+		Requirements:   requirements,
+	}
+	for _, entry := range manifest {
+		i, ok := parts[entry.File]
+		if !ok {
+			response.Error(w, http.StatusBadRequest, fmt.Sprintf("manifest file %q has no file part", entry.File))
+			return
+		}
+		delete(parts, entry.File)
+		part := r.MultipartForm.File["file"][i]
+		file, err := part.Open()
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, "failed to read file part")
+			return
+		}
+		defer file.Close()
+		input.Files = append(input.Files, service.BundleFileInput{
+			FileName:   entry.File,
+			TargetPath: entry.TargetPath,
+			FileMode:   entry.FileMode,
+			FileOwner:  entry.FileOwner,
+			File:       file,
+			Size:       part.Size,
+		})
+	}
+
+	artifact, err := h.artifactSvc.CreateBundle(r.Context(), input)
+	writeCreated(w, artifact, err)
+}
+
+func writeCreated(w http.ResponseWriter, artifact *domain.Artifact, err error) {
 	if err != nil {
 		if errors.Is(err, domain.ErrInvalidInput) {
 			response.Error(w, http.StatusBadRequest, err.Error())
@@ -113,6 +464,10 @@ func (h *ArtifactHandler) Upload(w http.ResponseWriter, r *http.Request) {
 			response.Error(w, http.StatusConflict, "artifact with this name and version already exists")
 			return
 		}
+		if errors.Is(err, domain.ErrQuotaExceeded) {
+			response.Error(w, http.StatusInsufficientStorage, err.Error())
+			return
+		}
 		response.Error(w, http.StatusInternalServerError, "failed to create artifact")
 		return
 	}
@@ -127,32 +482,60 @@ func (h *ArtifactHandler) Download(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	reader, artifact, err := h.artifactSvc.OpenFile(r.Context(), id)
+	reader, artifact, err := h.artifactSvc.OpenFile(r.Context(), middleware.OrgID(r.Context()), id)
 	if err != nil {
 		if errors.Is(err, domain.ErrNotFound) {
 			response.Error(w, http.StatusNotFound, "artifact not found")
 			return
 		}
+		if errors.Is(err, domain.ErrInvalidInput) {
+			response.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		response.Error(w, http.StatusInternalServerError, "failed to open artifact")
 		return
 	}
 	defer reader.Close()
 
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, artifact.FileName))
-	w.Header().Set("X-Checksum-SHA256", artifact.ChecksumSHA256)
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", artifact.FileSize))
+	response.File(w, r, reader, artifact.FileName, artifact.ChecksumSHA256, artifact.CreatedAt)
+}
+
+// DownloadFile serves one file of a bundle, by its index in the files of the
+// bundle.
+func (h *ArtifactHandler) DownloadFile(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid artifact id")
+		return
+	}
+	index, err := strconv.Atoi(chi.URLParam(r, "index"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid file index")
+		return
+	}
 
-	buf := make([]byte, 32*1024)
-	for {
-		n, readErr := reader.Read(buf)
-		if n > 0 {
-			w.Write(buf[:n])
+	artifact, err := h.artifactSvc.GetByID(r.Context(), middleware.OrgID(r.Context()), id)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "artifact not found")
+			return
 		}
-		if readErr != nil {
-			break
+		response.Error(w, http.StatusInternalServerError, "failed to get artifact")
+		return
+	}
+
+	reader, file, err := h.artifactSvc.OpenBundleFile(artifact, index)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "file not found in bundle")
+			return
 		}
+		response.Error(w, http.StatusInternalServerError, "failed to open file")
+		return
 	}
+	defer reader.Close()
+
+	response.File(w, r, reader, file.FileName, file.ChecksumSHA256, artifact.CreatedAt)
 }
 
 func (h *ArtifactHandler) Delete(w http.ResponseWriter, r *http.Request) {
@@ -162,11 +545,15 @@ func (h *ArtifactHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.artifactSvc.Delete(r.Context(), id); err != nil {
+	if err := h.artifactSvc.Delete(r.Context(), middleware.OrgID(r.Context()), id); err != nil {
 		if errors.Is(err, domain.ErrNotFound) {
 			response.Error(w, http.StatusNotFound, "artifact not found")
 			return
 		}
+		if errors.Is(err, domain.ErrConflict) {
+			response.Error(w, http.StatusConflict, err.Error())
+			return
+		}
 		response.Error(w, http.StatusInternalServerError, "failed to delete artifact")
 		return
 	}
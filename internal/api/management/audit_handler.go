@@ -3,6 +3,9 @@ package management
 import (
 	"net/http"
 
+	"github.com/google/uuid"
+
+	"github.com/CaioWing/Harbor/internal/api/middleware"
 	"github.com/CaioWing/Harbor/internal/api/response"
 	"github.com/CaioWing/Harbor/internal/domain"
 	"github.com/CaioWing/Harbor/internal/service"
@@ -16,7 +19,18 @@ func NewAuditHandler(auditSvc *service.AuditService) *AuditHandler {
 	return &AuditHandler{auditSvc: auditSvc}
 }
 
+// List returns the audit log of the organization selected for the request.
 func (h *AuditHandler) List(w http.ResponseWriter, r *http.Request) {
+	h.list(w, r, middleware.OrgID(r.Context()))
+}
+
+// System returns server-level audit entries such as logins and user
+// administration, which do not belong to any organization.
+func (h *AuditHandler) System(w http.ResponseWriter, r *http.Request) {
+	h.list(w, r, uuid.Nil)
+}
+
+func (h *AuditHandler) list(w http.ResponseWriter, r *http.Request, orgID uuid.UUID) {
 	page, perPage := response.ParsePagination(r)
 
 	filter := domain.AuditFilter{
@@ -37,7 +51,7 @@ func (h *AuditHandler) List(w http.ResponseWriter, r *http.Request) {
 		filter.SortOrder = v
 	}
 
-	entries, total, err := h.auditSvc.List(r.Context(), filter)
+	entries, total, err := h.auditSvc.List(r.Context(), orgID, filter)
 	if err != nil {
 		response.Error(w, http.StatusInternalServerError, "failed to list audit log")
 		return
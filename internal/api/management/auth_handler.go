@@ -2,41 +2,43 @@ package management
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
+	"github.com/google/uuid"
+
 	"github.com/CaioWing/Harbor/internal/api/middleware"
 	"github.com/CaioWing/Harbor/internal/api/response"
 	"github.com/CaioWing/Harbor/internal/auth"
-
-	"golang.org/x/crypto/bcrypt"
+	"github.com/CaioWing/Harbor/internal/domain"
+	"github.com/CaioWing/Harbor/internal/service"
 )
 
 type AuthHandler struct {
-	jwtMgr       *auth.JWTManager
-	adminEmail   string
-	adminPassHash string
+	authSvc *service.AuthService
+	userSvc *service.UserService
 }
 
-// NewAuthHandler creates a simple auth handler with a single admin user.
-// For production, replace with a proper user store.
-func NewAuthHandler(jwtMgr *auth.JWTManager) *AuthHandler {
-	// Default admin credentials — override via env in production
-	hash, _ := bcrypt.GenerateFromPassword([]byte("admin"), bcrypt.DefaultCost)
-	return &AuthHandler{
-		jwtMgr:       jwtMgr,
-		adminEmail:   "admin@harbor.local",
-		adminPassHash: string(hash),
-	}
+func NewAuthHandler(authSvc *service.AuthService, userSvc *service.UserService) *AuthHandler {
+	return &AuthHandler{authSvc: authSvc, userSvc: userSvc}
 }
 
 type loginRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email        string `json:"email"`
+	Password     string `json:"password"`
+	TOTPCode     string `json:"totp_code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
 }
 
 type loginResponse struct {
-	Token     string `json:"token"`
-	ExpiresAt string `json:"expires_at"`
+	Token            string `json:"token"`
+	ExpiresAt        string `json:"expires_at"`
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresAt string `json:"refresh_expires_at"`
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
 }
 
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
@@ -46,44 +48,241 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Email != h.adminEmail {
-		response.Error(w, http.StatusUnauthorized, "invalid credentials")
+	pair, err := h.authSvc.Login(r.Context(), service.LoginInput{
+		Email:        req.Email,
+		Password:     req.Password,
+		TOTPCode:     req.TOTPCode,
+		RecoveryCode: req.RecoveryCode,
+	})
+	if err != nil {
+		if errors.Is(err, domain.ErrTOTPRequired) {
+			response.JSON(w, http.StatusUnauthorized, map[string]interface{}{
+				"error":         "two-factor code required",
+				"totp_required": true,
+			})
+			return
+		}
+		if errors.Is(err, domain.ErrTOTPLocked) {
+			response.Error(w, http.StatusTooManyRequests, err.Error())
+			return
+		}
+		if errors.Is(err, domain.ErrUnauthorized) {
+			response.Error(w, http.StatusUnauthorized, "invalid credentials")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to generate token")
 		return
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(h.adminPassHash), []byte(req.Password)); err != nil {
-		response.Error(w, http.StatusUnauthorized, "invalid credentials")
+	response.JSON(w, http.StatusOK, newLoginResponse(pair))
+}
+
+// Refresh exchanges a refresh token for a new access/refresh token pair.
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req refreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		response.Error(w, http.StatusBadRequest, "refresh_token is required")
 		return
 	}
 
-	token, expiresAt, err := h.jwtMgr.Generate("admin")
+	pair, err := h.authSvc.Refresh(r.Context(), req.RefreshToken)
 	if err != nil {
+		if errors.Is(err, domain.ErrUnauthorized) {
+			response.Error(w, http.StatusUnauthorized, "invalid refresh token")
+			return
+		}
 		response.Error(w, http.StatusInternalServerError, "failed to generate token")
 		return
 	}
 
-	response.JSON(w, http.StatusOK, loginResponse{
-		Token:     token,
-		ExpiresAt: expiresAt.Format("2006-01-02T15:04:05Z"),
-	})
+	response.JSON(w, http.StatusOK, newLoginResponse(pair))
 }
 
-// Refresh generates a new JWT token for an already authenticated user.
-func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value(middleware.UserIDKey).(string)
-	if !ok || userID == "" {
+// Logout revokes the current access token. If a refresh token is supplied
+// only that session is ended; otherwise every session of the user is.
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.ClaimsKey).(*auth.ManagementClaims)
+	if !ok || claims == nil {
+		response.Error(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+
+	var req refreshRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.Error(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+	}
+
+	if err := h.authSvc.Logout(r.Context(), claims, req.RefreshToken); err != nil {
+		if errors.Is(err, domain.ErrForbidden) {
+			response.Error(w, http.StatusForbidden, "refresh token belongs to another user")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to logout")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Me returns the authenticated user.
+func (h *AuthHandler) Me(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
 		response.Error(w, http.StatusUnauthorized, "invalid token")
 		return
 	}
 
-	token, expiresAt, err := h.jwtMgr.Generate(userID)
+	user, err := h.userSvc.GetByID(r.Context(), userID)
 	if err != nil {
-		response.Error(w, http.StatusInternalServerError, "failed to generate token")
+		if errors.Is(err, domain.ErrNotFound) {
+			response.Error(w, http.StatusUnauthorized, "user no longer exists")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to get user")
 		return
 	}
 
-	response.JSON(w, http.StatusOK, loginResponse{
-		Token:     token,
-		ExpiresAt: expiresAt.Format("2006-01-02T15:04:05Z"),
-	})
+	response.JSON(w, http.StatusOK, user)
+}
+
+type totpCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// EnrollTOTP starts TOTP enrolment and returns the secret and provisioning URI.
+func (h *AuthHandler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		response.Error(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+
+	enrolment, err := h.userSvc.BeginTOTPEnrolment(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, domain.ErrConflict) {
+			response.Error(w, http.StatusConflict, "two-factor authentication is already enabled")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to start enrolment")
+		return
+	}
+
+	response.JSON(w, http.StatusOK, enrolment)
+}
+
+// ConfirmTOTP enables TOTP after verifying a code from the authenticator and
+// returns the recovery codes. They are shown only once.
+func (h *AuthHandler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		response.Error(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+
+	var req totpCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	codes, err := h.userSvc.ConfirmTOTPEnrolment(r.Context(), userID, req.Code)
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidInput) {
+			response.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, domain.ErrConflict) {
+			response.Error(w, http.StatusConflict, "two-factor authentication is already enabled")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to confirm enrolment")
+		return
+	}
+
+	response.JSON(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTOTP turns off TOTP for the current user.
+func (h *AuthHandler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		response.Error(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+
+	var req totpCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := h.userSvc.DisableTOTP(r.Context(), userID, req.Code); err != nil {
+		h.writeTOTPError(w, err, "failed to disable two-factor authentication")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes replaces the current user's recovery codes.
+func (h *AuthHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		response.Error(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+
+	var req totpCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	codes, err := h.userSvc.RegenerateRecoveryCodes(r.Context(), userID, req.Code)
+	if err != nil {
+		h.writeTOTPError(w, err, "failed to regenerate recovery codes")
+		return
+	}
+
+	response.JSON(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (h *AuthHandler) writeTOTPError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, domain.ErrTOTPLocked):
+		response.Error(w, http.StatusTooManyRequests, err.Error())
+	case errors.Is(err, domain.ErrUnauthorized), errors.Is(err, domain.ErrTOTPRequired):
+		response.Error(w, http.StatusBadRequest, "invalid two-factor code")
+	case errors.Is(err, domain.ErrInvalidInput):
+		response.Error(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, domain.ErrForbidden):
+		response.Error(w, http.StatusForbidden, err.Error())
+	default:
+		response.Error(w, http.StatusInternalServerError, fallback)
+	}
+}
+
+func currentUserID(r *http.Request) (uuid.UUID, bool) {
+	userIDStr, _ := r.Context().Value(middleware.UserIDKey).(string)
+	id, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+func newLoginResponse(pair *service.TokenPair) loginResponse {
+	return loginResponse{
+		Token:            pair.AccessToken,
+		ExpiresAt:        pair.AccessExpiresAt.UTC().Format("2006-01-02T15:04:05Z"),
+		RefreshToken:     pair.RefreshToken,
+		RefreshExpiresAt: pair.RefreshExpiresAt.UTC().Format("2006-01-02T15:04:05Z"),
+	}
 }
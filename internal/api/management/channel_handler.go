@@ -0,0 +1,190 @@
+package management
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/CaioWing/Harbor/internal/api/middleware"
+	"github.com/CaioWing/Harbor/internal/api/response"
+	"github.com/CaioWing/Harbor/internal/domain"
+	"github.com/CaioWing/Harbor/internal/service"
+)
+
+type ChannelHandler struct {
+	channelSvc *service.ChannelService
+}
+
+func NewChannelHandler(channelSvc *service.ChannelService) *ChannelHandler {
+	return &ChannelHandler{channelSvc: channelSvc}
+}
+
+type createChannelRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	DeviceTags  []string `json:"device_tags"`
+	UpstreamID  string   `json:"upstream_id,omitempty"`
+}
+
+type promoteRequest struct {
+	ArtifactID string `json:"artifact_id"`
+	Reason     string `json:"reason"`
+}
+
+func (h *ChannelHandler) List(w http.ResponseWriter, r *http.Request) {
+	channels, err := h.channelSvc.List(r.Context(), middleware.OrgID(r.Context()))
+	if err != nil {
+		response.Error(w, http.StatusInternalServerError, "failed to list channels")
+		return
+	}
+
+	response.JSON(w, http.StatusOK, map[string]interface{}{"data": channels})
+}
+
+func (h *ChannelHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req createChannelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	input := service.CreateChannelInput{
+		OrgID:       middleware.OrgID(r.Context()),
+		Name:        req.Name,
+		Description: req.Description,
+		DeviceTags:  req.DeviceTags,
+	}
+	if req.UpstreamID != "" {
+		upstreamID, err := uuid.Parse(req.UpstreamID)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, "invalid upstream_id")
+			return
+		}
+		input.UpstreamID = &upstreamID
+	}
+
+	channel, err := h.channelSvc.Create(r.Context(), input)
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidInput) {
+			response.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, domain.ErrConflict) {
+			response.Error(w, http.StatusConflict, "channel already exists")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to create channel")
+		return
+	}
+
+	response.JSON(w, http.StatusCreated, channel)
+}
+
+func (h *ChannelHandler) Get(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid channel id")
+		return
+	}
+
+	channel, err := h.channelSvc.GetByID(r.Context(), middleware.OrgID(r.Context()), id)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "channel not found")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to get channel")
+		return
+	}
+
+	response.JSON(w, http.StatusOK, channel)
+}
+
+// Delete removes a channel and cancels its continuous deployment.
+func (h *ChannelHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid channel id")
+		return
+	}
+
+	if err := h.channelSvc.Delete(r.Context(), middleware.OrgID(r.Context()), id); err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "channel not found")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to delete channel")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Promote makes an artifact the current version of a channel. The promotion
+// is audited with the calling user and the reason given.
+func (h *ChannelHandler) Promote(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid channel id")
+		return
+	}
+
+	var req promoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	artifactID, err := uuid.Parse(req.ArtifactID)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid artifact_id")
+		return
+	}
+
+	actor, _ := r.Context().Value(middleware.UserIDKey).(string)
+	promotion, err := h.channelSvc.Promote(r.Context(), service.PromoteInput{
+		OrgID:      middleware.OrgID(r.Context()),
+		ChannelID:  id,
+		ArtifactID: artifactID,
+		Actor:      actor,
+		IPAddress:  r.RemoteAddr,
+		Reason:     req.Reason,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrInvalidInput):
+			response.Error(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, domain.ErrConflict):
+			response.Error(w, http.StatusConflict, err.Error())
+		case errors.Is(err, domain.ErrNotFound):
+			response.Error(w, http.StatusNotFound, "channel not found")
+		default:
+			response.Error(w, http.StatusInternalServerError, "failed to promote artifact")
+		}
+		return
+	}
+
+	response.JSON(w, http.StatusCreated, promotion)
+}
+
+func (h *ChannelHandler) ListPromotions(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid channel id")
+		return
+	}
+
+	promotions, err := h.channelSvc.ListPromotions(r.Context(), middleware.OrgID(r.Context()), id)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "channel not found")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to list promotions")
+		return
+	}
+
+	response.JSON(w, http.StatusOK, map[string]interface{}{"data": promotions})
+}
@@ -4,10 +4,12 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"strconv"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 
+	"github.com/CaioWing/Harbor/internal/api/middleware"
 	"github.com/CaioWing/Harbor/internal/api/response"
 	"github.com/CaioWing/Harbor/internal/domain"
 	"github.com/CaioWing/Harbor/internal/service"
@@ -28,6 +30,8 @@ type createDeploymentRequest struct {
 	TargetDeviceTags  []string    `json:"target_device_tags,omitempty"`
 	TargetDeviceTypes []string    `json:"target_device_types,omitempty"`
 	MaxParallel       int         `json:"max_parallel"`
+	Variables         map[string]string `json:"variables,omitempty"`
+	Continuous        bool              `json:"continuous"`
 }
 
 func (h *DeploymentHandler) Create(w http.ResponseWriter, r *http.Request) {
@@ -54,12 +58,15 @@ func (h *DeploymentHandler) Create(w http.ResponseWriter, r *http.Request) {
 	}
 
 	input := service.CreateDeploymentInput{
+		OrgID:             middleware.OrgID(r.Context()),
 		Name:              req.Name,
 		ArtifactID:        artifactID,
 		TargetDeviceIDs:   deviceIDs,
 		TargetDeviceTags:  req.TargetDeviceTags,
 		TargetDeviceTypes: req.TargetDeviceTypes,
 		MaxParallel:       req.MaxParallel,
+		Variables:         req.Variables,
+		Continuous:        req.Continuous,
 	}
 
 	deployment, err := h.deploySvc.Create(r.Context(), input)
@@ -95,7 +102,7 @@ func (h *DeploymentHandler) List(w http.ResponseWriter, r *http.Request) {
 		filter.Status = &status
 	}
 
-	deployments, total, err := h.deploySvc.List(r.Context(), filter)
+	deployments, total, err := h.deploySvc.List(r.Context(), middleware.OrgID(r.Context()), filter)
 	if err != nil {
 		response.Error(w, http.StatusInternalServerError, "failed to list deployments")
 		return
@@ -111,7 +118,7 @@ func (h *DeploymentHandler) Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	deployment, err := h.deploySvc.GetByID(r.Context(), id)
+	deployment, err := h.deploySvc.GetByID(r.Context(), middleware.OrgID(r.Context()), id)
 	if err != nil {
 		if errors.Is(err, domain.ErrNotFound) {
 			response.Error(w, http.StatusNotFound, "deployment not found")
@@ -131,7 +138,7 @@ func (h *DeploymentHandler) Cancel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.deploySvc.Cancel(r.Context(), id); err != nil {
+	if err := h.deploySvc.Cancel(r.Context(), middleware.OrgID(r.Context()), id); err != nil {
 		if errors.Is(err, domain.ErrNotFound) {
 			response.Error(w, http.StatusNotFound, "deployment not found")
 			return
@@ -147,6 +154,32 @@ func (h *DeploymentHandler) Cancel(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// Rollback returns the devices that installed the artifact of a deployment
+// to the version they had before, with a deployment per version.
+func (h *DeploymentHandler) Rollback(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid deployment id")
+		return
+	}
+
+	result, err := h.deploySvc.Rollback(r.Context(), middleware.OrgID(r.Context()), id)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "deployment not found")
+			return
+		}
+		if errors.Is(err, domain.ErrInvalidInput) {
+			response.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to roll back deployment")
+		return
+	}
+
+	response.JSON(w, http.StatusCreated, result)
+}
+
 func (h *DeploymentHandler) GetDevices(w http.ResponseWriter, r *http.Request) {
 	id, err := uuid.Parse(chi.URLParam(r, "id"))
 	if err != nil {
@@ -154,7 +187,7 @@ func (h *DeploymentHandler) GetDevices(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	devices, err := h.deploySvc.GetDeploymentDevices(r.Context(), id)
+	devices, err := h.deploySvc.GetDeploymentDevices(r.Context(), middleware.OrgID(r.Context()), id)
 	if err != nil {
 		response.Error(w, http.StatusInternalServerError, "failed to get deployment devices")
 		return
@@ -163,8 +196,57 @@ func (h *DeploymentHandler) GetDevices(w http.ResponseWriter, r *http.Request) {
 	response.JSON(w, http.StatusOK, map[string]interface{}{"data": devices})
 }
 
+type outputResponse struct {
+	DeploymentDevice *domain.DeploymentDevice `json:"deployment_device"`
+	Data             []*domain.OutputChunk    `json:"data"`
+}
+
+// GetOutput returns the output a device reported for a script artifact.
+// Passing the seq of the last chunk received as "after" returns the chunks
+// that arrived since.
+func (h *DeploymentHandler) GetOutput(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid deployment id")
+		return
+	}
+	ddID, err := uuid.Parse(chi.URLParam(r, "dd_id"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid deployment device id")
+		return
+	}
+
+	q := r.URL.Query()
+	var after int64
+	if v := q.Get("after"); v != "" {
+		if after, err = strconv.ParseInt(v, 10, 64); err != nil {
+			response.Error(w, http.StatusBadRequest, "invalid after")
+			return
+		}
+	}
+	var limit int
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil {
+			response.Error(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+
+	dd, chunks, err := h.deploySvc.GetOutput(r.Context(), middleware.OrgID(r.Context()), id, ddID, after, limit)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "deployment device not found")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to get output")
+		return
+	}
+
+	response.JSON(w, http.StatusOK, outputResponse{DeploymentDevice: dd, Data: chunks})
+}
+
 func (h *DeploymentHandler) Stats(w http.ResponseWriter, r *http.Request) {
-	stats, err := h.deploySvc.GetStats(r.Context())
+	stats, err := h.deploySvc.GetStats(r.Context(), middleware.OrgID(r.Context()))
 	if err != nil {
 		response.Error(w, http.StatusInternalServerError, "failed to get stats")
 		return
@@ -8,6 +8,7 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 
+	"github.com/CaioWing/Harbor/internal/api/middleware"
 	"github.com/CaioWing/Harbor/internal/api/response"
 	"github.com/CaioWing/Harbor/internal/domain"
 	"github.com/CaioWing/Harbor/internal/service"
@@ -43,7 +44,7 @@ func (h *DeviceHandler) List(w http.ResponseWriter, r *http.Request) {
 		filter.Tags = tags
 	}
 
-	devices, total, err := h.deviceSvc.List(r.Context(), filter)
+	devices, total, err := h.deviceSvc.List(r.Context(), middleware.OrgID(r.Context()), filter)
 	if err != nil {
 		response.Error(w, http.StatusInternalServerError, "failed to list devices")
 		return
@@ -59,7 +60,7 @@ func (h *DeviceHandler) Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	device, err := h.deviceSvc.GetByID(r.Context(), id)
+	device, err := h.deviceSvc.GetByID(r.Context(), middleware.OrgID(r.Context()), id)
 	if err != nil {
 		if errors.Is(err, domain.ErrNotFound) {
 			response.Error(w, http.StatusNotFound, "device not found")
@@ -97,7 +98,7 @@ func (h *DeviceHandler) UpdateStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.deviceSvc.UpdateStatus(r.Context(), id, status); err != nil {
+	if err := h.deviceSvc.UpdateStatus(r.Context(), middleware.OrgID(r.Context()), id, status); err != nil {
 		if errors.Is(err, domain.ErrNotFound) {
 			response.Error(w, http.StatusNotFound, "device not found")
 			return
@@ -126,7 +127,7 @@ func (h *DeviceHandler) UpdateTags(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.deviceSvc.UpdateTags(r.Context(), id, req.Tags); err != nil {
+	if err := h.deviceSvc.UpdateTags(r.Context(), middleware.OrgID(r.Context()), id, req.Tags); err != nil {
 		if errors.Is(err, domain.ErrNotFound) {
 			response.Error(w, http.StatusNotFound, "device not found")
 			return
@@ -145,7 +146,7 @@ func (h *DeviceHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.deviceSvc.Decommission(r.Context(), id); err != nil {
+	if err := h.deviceSvc.Decommission(r.Context(), middleware.OrgID(r.Context()), id); err != nil {
 		if errors.Is(err, domain.ErrNotFound) {
 			response.Error(w, http.StatusNotFound, "device not found")
 			return
@@ -158,7 +159,7 @@ func (h *DeviceHandler) Delete(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *DeviceHandler) Count(w http.ResponseWriter, r *http.Request) {
-	counts, err := h.deviceSvc.CountByStatus(r.Context())
+	counts, err := h.deviceSvc.CountByStatus(r.Context(), middleware.OrgID(r.Context()))
 	if err != nil {
 		response.Error(w, http.StatusInternalServerError, "failed to count devices")
 		return
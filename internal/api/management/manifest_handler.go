@@ -0,0 +1,167 @@
+package management
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/CaioWing/Harbor/internal/api/middleware"
+	"github.com/CaioWing/Harbor/internal/api/response"
+	"github.com/CaioWing/Harbor/internal/domain"
+	"github.com/CaioWing/Harbor/internal/service"
+)
+
+type ManifestHandler struct {
+	manifestSvc *service.ManifestService
+}
+
+func NewManifestHandler(manifestSvc *service.ManifestService) *ManifestHandler {
+	return &ManifestHandler{manifestSvc: manifestSvc}
+}
+
+type createManifestRequest struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	DeviceTags  []string    `json:"device_tags"`
+	ArtifactIDs []uuid.UUID `json:"artifact_ids"`
+}
+
+type setManifestArtifactsRequest struct {
+	ArtifactIDs []uuid.UUID `json:"artifact_ids"`
+}
+
+func (h *ManifestHandler) List(w http.ResponseWriter, r *http.Request) {
+	manifests, err := h.manifestSvc.List(r.Context(), middleware.OrgID(r.Context()))
+	if err != nil {
+		response.Error(w, http.StatusInternalServerError, "failed to list manifests")
+		return
+	}
+
+	response.JSON(w, http.StatusOK, map[string]interface{}{"data": manifests})
+}
+
+func (h *ManifestHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req createManifestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	manifest, err := h.manifestSvc.Create(r.Context(), service.CreateManifestInput{
+		OrgID:       middleware.OrgID(r.Context()),
+		Name:        req.Name,
+		Description: req.Description,
+		DeviceTags:  req.DeviceTags,
+		ArtifactIDs: req.ArtifactIDs,
+	})
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidInput) {
+			response.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, domain.ErrConflict) {
+			response.Error(w, http.StatusConflict, "manifest already exists")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to create manifest")
+		return
+	}
+
+	response.JSON(w, http.StatusCreated, manifest)
+}
+
+func (h *ManifestHandler) Get(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid manifest id")
+		return
+	}
+
+	manifest, err := h.manifestSvc.GetByID(r.Context(), middleware.OrgID(r.Context()), id)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "manifest not found")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to get manifest")
+		return
+	}
+
+	response.JSON(w, http.StatusOK, manifest)
+}
+
+// SetArtifacts replaces the artifacts of a manifest.
+func (h *ManifestHandler) SetArtifacts(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid manifest id")
+		return
+	}
+
+	var req setManifestArtifactsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	manifest, err := h.manifestSvc.SetArtifacts(r.Context(), middleware.OrgID(r.Context()), id, req.ArtifactIDs)
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidInput) {
+			response.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, domain.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "manifest not found")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to update manifest")
+		return
+	}
+
+	response.JSON(w, http.StatusOK, manifest)
+}
+
+// Delete removes a manifest and cancels its deployments.
+func (h *ManifestHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid manifest id")
+		return
+	}
+
+	if err := h.manifestSvc.Delete(r.Context(), middleware.OrgID(r.Context()), id); err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "manifest not found")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to delete manifest")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Convergence returns how many devices of a manifest have all of its
+// artifacts installed.
+func (h *ManifestHandler) Convergence(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid manifest id")
+		return
+	}
+
+	convergence, err := h.manifestSvc.Convergence(r.Context(), middleware.OrgID(r.Context()), id)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "manifest not found")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to compute convergence")
+		return
+	}
+
+	response.JSON(w, http.StatusOK, convergence)
+}
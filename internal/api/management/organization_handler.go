@@ -0,0 +1,176 @@
+package management
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/CaioWing/Harbor/internal/api/middleware"
+	"github.com/CaioWing/Harbor/internal/api/response"
+	"github.com/CaioWing/Harbor/internal/auth"
+	"github.com/CaioWing/Harbor/internal/domain"
+	"github.com/CaioWing/Harbor/internal/service"
+)
+
+type OrganizationHandler struct {
+	orgSvc *service.OrganizationService
+}
+
+func NewOrganizationHandler(orgSvc *service.OrganizationService) *OrganizationHandler {
+	return &OrganizationHandler{orgSvc: orgSvc}
+}
+
+type createOrganizationRequest struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+type organizationWithTokenResponse struct {
+	*domain.Organization
+	TenantToken string `json:"tenant_token"`
+}
+
+type tenantTokenResponse struct {
+	TenantToken string `json:"tenant_token"`
+}
+
+// List returns the organizations the current user can access.
+func (h *OrganizationHandler) List(w http.ResponseWriter, r *http.Request) {
+	claims, ok := r.Context().Value(middleware.ClaimsKey).(*auth.ManagementClaims)
+	userID, idOK := currentUserID(r)
+	if !ok || claims == nil || !idOK {
+		response.Error(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+
+	orgs, err := h.orgSvc.ListForUser(r.Context(), userID, domain.Role(claims.Role))
+	if err != nil {
+		response.Error(w, http.StatusInternalServerError, "failed to list organizations")
+		return
+	}
+
+	response.JSON(w, http.StatusOK, orgs)
+}
+
+// Create adds an organization and returns its tenant token. The token is
+// shown only once.
+func (h *OrganizationHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req createOrganizationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	org, token, err := h.orgSvc.Create(r.Context(), service.CreateOrganizationInput{
+		Name: req.Name,
+		Slug: req.Slug,
+	})
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidInput) {
+			response.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, domain.ErrConflict) {
+			response.Error(w, http.StatusConflict, "organization with this slug already exists")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to create organization")
+		return
+	}
+
+	response.JSON(w, http.StatusCreated, organizationWithTokenResponse{Organization: org, TenantToken: token})
+}
+
+// RotateTenantToken issues a new tenant token for device enrolment.
+func (h *OrganizationHandler) RotateTenantToken(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid organization id")
+		return
+	}
+
+	token, err := h.orgSvc.RotateTenantToken(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "organization not found")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to rotate tenant token")
+		return
+	}
+
+	response.JSON(w, http.StatusOK, tenantTokenResponse{TenantToken: token})
+}
+
+func (h *OrganizationHandler) ListMembers(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid organization id")
+		return
+	}
+
+	users, err := h.orgSvc.ListMembers(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "organization not found")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to list members")
+		return
+	}
+
+	response.JSON(w, http.StatusOK, users)
+}
+
+func (h *OrganizationHandler) AddMember(w http.ResponseWriter, r *http.Request) {
+	orgID, userID, ok := parseMemberParams(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.orgSvc.AddMember(r.Context(), orgID, userID); err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "organization or user not found")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to add member")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *OrganizationHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	orgID, userID, ok := parseMemberParams(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.orgSvc.RemoveMember(r.Context(), orgID, userID); err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "member not found")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to remove member")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func parseMemberParams(w http.ResponseWriter, r *http.Request) (orgID, userID uuid.UUID, ok bool) {
+	orgID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid organization id")
+		return uuid.Nil, uuid.Nil, false
+	}
+	userID, err = uuid.Parse(chi.URLParam(r, "userId"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid user id")
+		return uuid.Nil, uuid.Nil, false
+	}
+	return orgID, userID, true
+}
@@ -0,0 +1,86 @@
+package management
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/CaioWing/Harbor/internal/api/middleware"
+	"github.com/CaioWing/Harbor/internal/api/response"
+	"github.com/CaioWing/Harbor/internal/domain"
+	"github.com/CaioWing/Harbor/internal/service"
+)
+
+type SigningKeyHandler struct {
+	signingSvc *service.SigningService
+}
+
+func NewSigningKeyHandler(signingSvc *service.SigningService) *SigningKeyHandler {
+	return &SigningKeyHandler{signingSvc: signingSvc}
+}
+
+type addSigningKeyRequest struct {
+	Name      string `json:"name"`
+	PublicKey string `json:"public_key"`
+}
+
+func (h *SigningKeyHandler) List(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.signingSvc.ListKeys(r.Context(), middleware.OrgID(r.Context()))
+	if err != nil {
+		response.Error(w, http.StatusInternalServerError, "failed to list signing keys")
+		return
+	}
+
+	response.JSON(w, http.StatusOK, keys)
+}
+
+// Add trusts a new Ed25519 public key for the organization's artifacts.
+func (h *SigningKeyHandler) Add(w http.ResponseWriter, r *http.Request) {
+	var req addSigningKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	key, err := h.signingSvc.AddKey(r.Context(), service.AddSigningKeyInput{
+		OrgID:     middleware.OrgID(r.Context()),
+		Name:      req.Name,
+		PublicKey: req.PublicKey,
+	})
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidInput) {
+			response.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, domain.ErrConflict) {
+			response.Error(w, http.StatusConflict, "signing key already registered")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to add signing key")
+		return
+	}
+
+	response.JSON(w, http.StatusCreated, key)
+}
+
+func (h *SigningKeyHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid signing key id")
+		return
+	}
+
+	if err := h.signingSvc.RevokeKey(r.Context(), middleware.OrgID(r.Context()), id); err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "signing key not found")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to revoke signing key")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
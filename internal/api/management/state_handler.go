@@ -0,0 +1,55 @@
+package management
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/CaioWing/Harbor/internal/api/middleware"
+	"github.com/CaioWing/Harbor/internal/api/response"
+	"github.com/CaioWing/Harbor/internal/domain"
+	"github.com/CaioWing/Harbor/internal/service"
+)
+
+type StateHandler struct {
+	stateSvc *service.StateService
+}
+
+func NewStateHandler(stateSvc *service.StateService) *StateHandler {
+	return &StateHandler{stateSvc: stateSvc}
+}
+
+// Get returns the desired and the reported state of each managed file of a
+// device.
+func (h *StateHandler) Get(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid device id")
+		return
+	}
+
+	state, err := h.stateSvc.DeviceState(r.Context(), middleware.OrgID(r.Context()), id)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "device not found")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to get device state")
+		return
+	}
+
+	response.JSON(w, http.StatusOK, state)
+}
+
+// Drift lists the devices whose files differ from what was deployed to them.
+func (h *StateHandler) Drift(w http.ResponseWriter, r *http.Request) {
+	states, err := h.stateSvc.Drift(r.Context(), middleware.OrgID(r.Context()))
+	if err != nil {
+		response.Error(w, http.StatusInternalServerError, "failed to compute drift")
+		return
+	}
+
+	response.JSON(w, http.StatusOK, map[string]interface{}{"data": states})
+}
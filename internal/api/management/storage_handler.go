@@ -0,0 +1,81 @@
+package management
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/CaioWing/Harbor/internal/api/response"
+	"github.com/CaioWing/Harbor/internal/domain"
+	"github.com/CaioWing/Harbor/internal/service"
+)
+
+type StorageHandler struct {
+	scrubSvc   *service.ScrubService
+	cleanupSvc *service.CleanupService
+}
+
+func NewStorageHandler(scrubSvc *service.ScrubService, cleanupSvc *service.CleanupService) *StorageHandler {
+	return &StorageHandler{scrubSvc: scrubSvc, cleanupSvc: cleanupSvc}
+}
+
+type scrubStatusResponse struct {
+	Running bool                `json:"running"`
+	Report  *domain.ScrubReport `json:"report"`
+}
+
+// LatestScrub returns whether a scrub is running and the report of the last
+// one that finished, which is null before the first scrub.
+func (h *StorageHandler) LatestScrub(w http.ResponseWriter, r *http.Request) {
+	report, err := h.scrubSvc.LatestReport(r.Context())
+	if err != nil && !errors.Is(err, domain.ErrNotFound) {
+		response.Error(w, http.StatusInternalServerError, "failed to get scrub report")
+		return
+	}
+
+	response.JSON(w, http.StatusOK, scrubStatusResponse{
+		Running: h.scrubSvc.Running(),
+		Report:  report,
+	})
+}
+
+// StartScrub starts a scrub in the background. Its report is available from
+// LatestScrub once it finishes.
+func (h *StorageHandler) StartScrub(w http.ResponseWriter, r *http.Request) {
+	if err := h.scrubSvc.Start(r.Context()); err != nil {
+		if errors.Is(err, domain.ErrConflict) {
+			response.Error(w, http.StatusConflict, "a scrub is already running")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to start scrub")
+		return
+	}
+
+	response.JSON(w, http.StatusAccepted, map[string]bool{"running": true})
+}
+
+// ApplyRetention runs the retention policy and returns what it deleted. It is
+// a dry run, deleting nothing, unless dry_run=false is given.
+func (h *StorageHandler) ApplyRetention(w http.ResponseWriter, r *http.Request) {
+	dryRun := true
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			response.Error(w, http.StatusBadRequest, "invalid dry_run")
+			return
+		}
+		dryRun = b
+	}
+
+	report, err := h.cleanupSvc.ApplyRetention(r.Context(), dryRun)
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidInput) {
+			response.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to apply retention")
+		return
+	}
+
+	response.JSON(w, http.StatusOK, report)
+}
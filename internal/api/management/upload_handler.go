@@ -0,0 +1,226 @@
+package management
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/CaioWing/Harbor/internal/api/middleware"
+	"github.com/CaioWing/Harbor/internal/api/response"
+	"github.com/CaioWing/Harbor/internal/domain"
+	"github.com/CaioWing/Harbor/internal/service"
+)
+
+// statusChecksumMismatch is the tus checksum extension's response when a
+// chunk does not match its Upload-Checksum header.
+const statusChecksumMismatch = 460
+
+// UploadHandler serves resumable uploads. The flow follows the tus protocol:
+// Upload-Offset and Upload-Length headers report progress and chunks carry
+// an optional "Upload-Checksum: sha256 <base64>" header.
+type UploadHandler struct {
+	uploadSvc *service.UploadService
+}
+
+func NewUploadHandler(uploadSvc *service.UploadService) *UploadHandler {
+	return &UploadHandler{uploadSvc: uploadSvc}
+}
+
+type createUploadRequest struct {
+	domain.UploadMetadata
+	Size           int64  `json:"size"`
+	ChecksumSHA256 string `json:"checksum_sha256"`
+}
+
+func (h *UploadHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req createUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	session, err := h.uploadSvc.CreateSession(r.Context(), service.CreateUploadInput{
+		OrgID:          middleware.OrgID(r.Context()),
+		Metadata:       req.UploadMetadata,
+		Size:           req.Size,
+		ChecksumSHA256: req.ChecksumSHA256,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrInvalidInput):
+			response.Error(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, domain.ErrQuotaExceeded):
+			response.Error(w, http.StatusInsufficientStorage, err.Error())
+		default:
+			response.Error(w, http.StatusInternalServerError, "failed to create upload session")
+		}
+		return
+	}
+
+	w.Header().Set("Location", r.URL.Path+"/"+session.ID.String())
+	setUploadHeaders(w, session.Offset, session.Size)
+	response.JSON(w, http.StatusCreated, session)
+}
+
+// Get returns the session. HEAD requests only get the Upload-Offset and
+// Upload-Length headers, which is how a client finds where to resume.
+func (h *UploadHandler) Get(w http.ResponseWriter, r *http.Request) {
+	session, ok := h.session(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	setUploadHeaders(w, session.Offset, session.Size)
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	response.JSON(w, http.StatusOK, session)
+}
+
+// WriteChunk appends the request body at the Upload-Offset header.
+func (h *UploadHandler) WriteChunk(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid upload id")
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		response.Error(w, http.StatusBadRequest, "Upload-Offset header is required")
+		return
+	}
+	checksum, err := parseUploadChecksum(r.Header.Get("Upload-Checksum"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	next, err := h.uploadSvc.WriteChunk(r.Context(), middleware.OrgID(r.Context()), id, offset, checksum, r.Body)
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrNotFound):
+			response.Error(w, http.StatusNotFound, "upload session not found")
+		case errors.Is(err, domain.ErrConflict):
+			w.Header().Set("Upload-Offset", strconv.FormatInt(next, 10))
+			response.Error(w, http.StatusConflict, err.Error())
+		case errors.Is(err, domain.ErrChecksumMismatch):
+			response.Error(w, statusChecksumMismatch, "chunk checksum mismatch")
+		case errors.Is(err, domain.ErrInvalidInput):
+			response.Error(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, domain.ErrQuotaExceeded):
+			response.Error(w, http.StatusInsufficientStorage, err.Error())
+		default:
+			response.Error(w, http.StatusInternalServerError, "failed to write chunk")
+		}
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(next, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Complete creates the artifact once every byte has been received.
+func (h *UploadHandler) Complete(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid upload id")
+		return
+	}
+
+	artifact, err := h.uploadSvc.Complete(r.Context(), middleware.OrgID(r.Context()), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrNotFound):
+			response.Error(w, http.StatusNotFound, "upload session not found")
+		case errors.Is(err, domain.ErrInvalidInput):
+			response.Error(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, domain.ErrConflict):
+			response.Error(w, http.StatusConflict, "artifact with this name and version already exists")
+		case errors.Is(err, domain.ErrQuotaExceeded):
+			response.Error(w, http.StatusInsufficientStorage, err.Error())
+		default:
+			response.Error(w, http.StatusInternalServerError, "failed to create artifact")
+		}
+		return
+	}
+
+	response.JSON(w, http.StatusCreated, artifact)
+}
+
+func (h *UploadHandler) Abort(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid upload id")
+		return
+	}
+
+	if err := h.uploadSvc.Abort(r.Context(), middleware.OrgID(r.Context()), id); err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "upload session not found")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to abort upload")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *UploadHandler) session(w http.ResponseWriter, r *http.Request) (*domain.UploadSession, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid upload id")
+		return nil, false
+	}
+
+	session, err := h.uploadSvc.GetSession(r.Context(), middleware.OrgID(r.Context()), id)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "upload session not found")
+			return nil, false
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to get upload session")
+		return nil, false
+	}
+	return session, true
+}
+
+func setUploadHeaders(w http.ResponseWriter, offset, size int64) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(size, 10))
+}
+
+// parseUploadChecksum turns a tus "sha256 <base64>" header into a hex digest.
+// A bare hex digest is accepted too.
+func parseUploadChecksum(header string) (string, error) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return "", nil
+	}
+
+	algo, value, found := strings.Cut(header, " ")
+	if !found {
+		if _, err := hex.DecodeString(header); err == nil && len(header) == 64 {
+			return header, nil
+		}
+		return "", errors.New("invalid Upload-Checksum header")
+	}
+	if !strings.EqualFold(algo, "sha256") {
+		return "", fmt.Errorf("unsupported checksum algorithm %q", algo)
+	}
+	sum, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil || len(sum) != 32 {
+		return "", errors.New("invalid Upload-Checksum header")
+	}
+	return hex.EncodeToString(sum), nil
+}
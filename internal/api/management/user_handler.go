@@ -0,0 +1,169 @@
+package management
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/CaioWing/Harbor/internal/api/response"
+	"github.com/CaioWing/Harbor/internal/domain"
+	"github.com/CaioWing/Harbor/internal/service"
+)
+
+type UserHandler struct {
+	userSvc *service.UserService
+}
+
+func NewUserHandler(userSvc *service.UserService) *UserHandler {
+	return &UserHandler{userSvc: userSvc}
+}
+
+func (h *UserHandler) List(w http.ResponseWriter, r *http.Request) {
+	users, err := h.userSvc.List(r.Context())
+	if err != nil {
+		response.Error(w, http.StatusInternalServerError, "failed to list users")
+		return
+	}
+
+	response.JSON(w, http.StatusOK, users)
+}
+
+type createUserRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+func (h *UserHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req createUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	user, err := h.userSvc.Create(r.Context(), service.CreateUserInput{
+		Email:    req.Email,
+		Password: req.Password,
+		Role:     domain.Role(req.Role),
+	})
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidInput) {
+			response.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, domain.ErrConflict) {
+			response.Error(w, http.StatusConflict, "user with this email already exists")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to create user")
+		return
+	}
+
+	response.JSON(w, http.StatusCreated, user)
+}
+
+type updateRoleRequest struct {
+	Role string `json:"role"`
+}
+
+func (h *UserHandler) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+
+	var req updateRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := h.userSvc.UpdateRole(r.Context(), id, domain.Role(req.Role)); err != nil {
+		if errors.Is(err, domain.ErrInvalidInput) {
+			response.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, domain.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "user not found")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to update role")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *UserHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+
+	if uid, ok := currentUserID(r); ok && uid == id {
+		response.Error(w, http.StatusBadRequest, "cannot delete your own account")
+		return
+	}
+
+	if err := h.userSvc.Delete(r.Context(), id); err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "user not found")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to delete user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResetTOTP disables TOTP for a user who lost their authenticator and
+// recovery codes.
+func (h *UserHandler) ResetTOTP(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+
+	if err := h.userSvc.ResetTOTP(r.Context(), id); err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			response.Error(w, http.StatusNotFound, "user not found")
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "failed to reset two-factor authentication")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *UserHandler) GetSecurityPolicy(w http.ResponseWriter, r *http.Request) {
+	policy, err := h.userSvc.GetSecurityPolicy(r.Context())
+	if err != nil {
+		response.Error(w, http.StatusInternalServerError, "failed to get security policy")
+		return
+	}
+
+	response.JSON(w, http.StatusOK, policy)
+}
+
+func (h *UserHandler) UpdateSecurityPolicy(w http.ResponseWriter, r *http.Request) {
+	var policy domain.SecurityPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := h.userSvc.UpdateSecurityPolicy(r.Context(), &policy); err != nil {
+		response.Error(w, http.StatusInternalServerError, "failed to update security policy")
+		return
+	}
+
+	response.JSON(w, http.StatusOK, policy)
+}
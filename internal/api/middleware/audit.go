@@ -4,6 +4,8 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/google/uuid"
+
 	"github.com/CaioWing/Harbor/internal/domain"
 	"github.com/CaioWing/Harbor/internal/service"
 )
@@ -42,9 +44,15 @@ func AuditLog(auditSvc *service.AuditService) func(http.Handler) http.Handler {
 				Details:   map[string]interface{}{"method": r.Method, "path": r.URL.Path},
 			}
 
+			if orgID := OrgID(r.Context()); orgID != uuid.Nil {
+				entry.OrgID = &orgID
+			}
+
 			// Extract resource ID from URL if present
 			parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/management/"), "/")
-			if len(parts) >= 2 {
+			if len(parts) >= 3 && parts[1] == "uploads" {
+				entry.ResourceID = parts[2]
+			} else if len(parts) >= 2 {
 				entry.ResourceID = parts[1]
 			}
 
@@ -63,14 +71,72 @@ func classifyRequest(method, path string) (action, resource string) {
 		return "device.update_tags", "device"
 	case strings.HasPrefix(p, "devices") && method == http.MethodDelete:
 		return "device.decommission", "device"
+	case strings.HasPrefix(p, "artifacts/uploads") && (method == http.MethodPatch || method == http.MethodPut):
+		return "", "" // individual chunks are not audited
+	case strings.HasPrefix(p, "artifacts/uploads") && strings.HasSuffix(p, "complete"):
+		return "artifact.upload", "artifact"
+	case strings.HasPrefix(p, "artifacts/uploads") && method == http.MethodPost:
+		return "artifact.upload_start", "upload"
+	case strings.HasPrefix(p, "artifacts/uploads") && method == http.MethodDelete:
+		return "artifact.upload_abort", "upload"
 	case strings.HasPrefix(p, "artifacts") && method == http.MethodPost:
 		return "artifact.upload", "artifact"
 	case strings.HasPrefix(p, "artifacts") && method == http.MethodDelete:
 		return "artifact.delete", "artifact"
 	case strings.HasPrefix(p, "deployments") && method == http.MethodPost && strings.HasSuffix(p, "cancel"):
 		return "deployment.cancel", "deployment"
+	case strings.HasPrefix(p, "deployments") && method == http.MethodPost && strings.HasSuffix(p, "rollback"):
+		return "deployment.rollback", "deployment"
 	case strings.HasPrefix(p, "deployments") && method == http.MethodPost:
 		return "deployment.create", "deployment"
+	case strings.HasPrefix(p, "channels") && strings.HasSuffix(p, "promotions"):
+		return "", "" // recorded by the channel service, with the reason
+	case strings.HasPrefix(p, "channels") && method == http.MethodPost:
+		return "channel.create", "channel"
+	case strings.HasPrefix(p, "channels") && method == http.MethodDelete:
+		return "channel.delete", "channel"
+	case strings.HasPrefix(p, "manifests") && method == http.MethodPost:
+		return "manifest.create", "manifest"
+	case strings.HasPrefix(p, "manifests") && method == http.MethodPut:
+		return "manifest.update", "manifest"
+	case strings.HasPrefix(p, "manifests") && method == http.MethodDelete:
+		return "manifest.delete", "manifest"
+	case strings.HasPrefix(p, "signing-keys") && method == http.MethodPost:
+		return "signing_key.add", "signing_key"
+	case strings.HasPrefix(p, "signing-keys") && method == http.MethodDelete:
+		return "signing_key.revoke", "signing_key"
+	case strings.HasPrefix(p, "users") && strings.HasSuffix(p, "totp/reset"):
+		return "user.reset_totp", "user"
+	case strings.HasPrefix(p, "users") && method == http.MethodPost:
+		return "user.create", "user"
+	case strings.HasPrefix(p, "users") && method == http.MethodPatch:
+		return "user.update_role", "user"
+	case strings.HasPrefix(p, "users") && method == http.MethodDelete:
+		return "user.delete", "user"
+	case strings.HasPrefix(p, "organizations") && strings.HasSuffix(p, "tenant-token"):
+		return "organization.rotate_token", "organization"
+	case strings.HasPrefix(p, "organizations") && strings.Contains(p, "/members/") && method == http.MethodPut:
+		return "organization.add_member", "organization"
+	case strings.HasPrefix(p, "organizations") && strings.Contains(p, "/members/") && method == http.MethodDelete:
+		return "organization.remove_member", "organization"
+	case strings.HasPrefix(p, "organizations") && method == http.MethodPost:
+		return "organization.create", "organization"
+	case strings.HasPrefix(p, "system/storage/scrub") && method == http.MethodPost:
+		return "storage.scrub", "storage"
+	case strings.HasPrefix(p, "system/storage/retention") && method == http.MethodPost:
+		return "storage.retention", "storage"
+	case strings.HasPrefix(p, "security/policy"):
+		return "security.update_policy", "security"
+	case strings.HasPrefix(p, "auth/totp/enroll"):
+		return "auth.totp_enroll", "auth"
+	case strings.HasPrefix(p, "auth/totp/confirm"):
+		return "auth.totp_enable", "auth"
+	case strings.HasPrefix(p, "auth/totp/disable"):
+		return "auth.totp_disable", "auth"
+	case strings.HasPrefix(p, "auth/totp/recovery-codes"):
+		return "auth.totp_recovery_codes", "auth"
+	case strings.HasPrefix(p, "auth/logout"):
+		return "auth.logout", "auth"
 	case strings.HasPrefix(p, "auth"):
 		return "auth.login", "auth"
 	default:
@@ -5,17 +5,28 @@ import (
 	"net/http"
 	"strings"
 
-	"github.com/CaioWing/Harbor/internal/auth"
+	"github.com/google/uuid"
+
+	"github.com/CaioWing/Harbor/internal/service"
 )
 
 type contextKey string
 
 const (
 	UserIDKey   contextKey = "user_id"
+	ClaimsKey   contextKey = "claims"
 	DeviceIDKey contextKey = "device_id"
+	OrgIDKey    contextKey = "org_id"
 )
 
-func ManagementAuth(jwtMgr *auth.JWTManager) func(http.Handler) http.Handler {
+// OrgID returns the organization that scopes the request, or uuid.Nil if
+// none has been resolved.
+func OrgID(ctx context.Context) uuid.UUID {
+	id, _ := ctx.Value(OrgIDKey).(uuid.UUID)
+	return id
+}
+
+func ManagementAuth(authSvc *service.AuthService) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			header := r.Header.Get("Authorization")
@@ -30,13 +41,14 @@ func ManagementAuth(jwtMgr *auth.JWTManager) func(http.Handler) http.Handler {
 				return
 			}
 
-			claims, err := jwtMgr.Validate(token)
+			claims, err := authSvc.ValidateAccessToken(r.Context(), token)
 			if err != nil {
 				http.Error(w, `{"error":"invalid token"}`, http.StatusUnauthorized)
 				return
 			}
 
 			ctx := context.WithValue(r.Context(), UserIDKey, claims.UserID)
+			ctx = context.WithValue(ctx, ClaimsKey, claims)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
@@ -30,6 +30,7 @@ func DeviceAuth(deviceSvc *service.DeviceService) func(http.Handler) http.Handle
 			}
 
 			ctx := context.WithValue(r.Context(), DeviceIDKey, device.ID.String())
+			ctx = context.WithValue(ctx, OrgIDKey, device.OrgID)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"github.com/CaioWing/Harbor/internal/auth"
+	"github.com/CaioWing/Harbor/internal/domain"
+	"github.com/CaioWing/Harbor/internal/service"
+)
+
+// OrganizationHeader selects the organization a management request acts on.
+// Requests without it use the default organization.
+const OrganizationHeader = "X-Organization-ID"
+
+// OrganizationScope resolves the organization of a management request and
+// checks that the user may access it. Must run after ManagementAuth.
+func OrganizationScope(orgSvc *service.OrganizationService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := r.Context().Value(ClaimsKey).(*auth.ManagementClaims)
+			if !ok || claims == nil {
+				http.Error(w, `{"error":"invalid token"}`, http.StatusUnauthorized)
+				return
+			}
+			userID, err := uuid.Parse(claims.UserID)
+			if err != nil {
+				http.Error(w, `{"error":"invalid token"}`, http.StatusUnauthorized)
+				return
+			}
+
+			orgID := domain.DefaultOrganizationID
+			if h := r.Header.Get(OrganizationHeader); h != "" {
+				orgID, err = uuid.Parse(h)
+				if err != nil {
+					http.Error(w, `{"error":"invalid organization id"}`, http.StatusBadRequest)
+					return
+				}
+			}
+
+			if _, err := orgSvc.Resolve(r.Context(), userID, domain.Role(claims.Role), orgID); err != nil {
+				switch {
+				case errors.Is(err, domain.ErrNotFound):
+					http.Error(w, `{"error":"organization not found"}`, http.StatusNotFound)
+				case errors.Is(err, domain.ErrForbidden):
+					http.Error(w, `{"error":"not a member of this organization"}`, http.StatusForbidden)
+				default:
+					http.Error(w, `{"error":"failed to resolve organization"}`, http.StatusInternalServerError)
+				}
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), OrgIDKey, orgID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
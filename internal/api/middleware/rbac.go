@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/CaioWing/Harbor/internal/auth"
+	"github.com/CaioWing/Harbor/internal/domain"
+	"github.com/CaioWing/Harbor/internal/service"
+)
+
+// RequireRole rejects requests whose access token does not carry one of the
+// given roles. Must run after ManagementAuth.
+func RequireRole(roles ...domain.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := r.Context().Value(ClaimsKey).(*auth.ManagementClaims)
+			if !ok || claims == nil {
+				http.Error(w, `{"error":"invalid token"}`, http.StatusUnauthorized)
+				return
+			}
+
+			for _, role := range roles {
+				if domain.Role(claims.Role) == role {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			http.Error(w, `{"error":"insufficient permissions"}`, http.StatusForbidden)
+		})
+	}
+}
+
+// RequireTOTPPolicy blocks sessions that were established without a second
+// factor when the security policy requires one for the user's role. Routes
+// needed to enrol must be registered outside this middleware.
+func RequireTOTPPolicy(authSvc *service.AuthService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := r.Context().Value(ClaimsKey).(*auth.ManagementClaims)
+			if !ok || claims == nil {
+				http.Error(w, `{"error":"invalid token"}`, http.StatusUnauthorized)
+				return
+			}
+
+			if err := authSvc.CheckTOTPPolicy(r.Context(), claims); err != nil {
+				if errors.Is(err, domain.ErrTOTPEnrolment) {
+					http.Error(w, `{"error":"two-factor enrolment required"}`, http.StatusForbidden)
+					return
+				}
+				http.Error(w, `{"error":"failed to check security policy"}`, http.StatusInternalServerError)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -2,9 +2,12 @@ package response
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"math"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 type Pagination struct {
@@ -53,3 +56,14 @@ func ParsePagination(r *http.Request) (page, perPage int) {
 	}
 	return
 }
+
+// File serves a stored file with Range, If-Range and conditional request
+// support. The SHA-256 checksum doubles as a strong ETag, so a client resuming
+// a download with If-Range gets the rest of the same file or the whole new one.
+func File(w http.ResponseWriter, r *http.Request, content io.ReadSeeker, name, checksum string, modTime time.Time) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+	w.Header().Set("X-Checksum-SHA256", checksum)
+	w.Header().Set("ETag", `"`+checksum+`"`)
+	http.ServeContent(w, r, name, modTime, content)
+}
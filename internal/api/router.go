@@ -14,7 +14,7 @@ import (
 	"github.com/CaioWing/Harbor/internal/api/management"
 	"github.com/CaioWing/Harbor/internal/api/middleware"
 	"github.com/CaioWing/Harbor/internal/api/response"
-	"github.com/CaioWing/Harbor/internal/auth"
+	"github.com/CaioWing/Harbor/internal/domain"
 	"github.com/CaioWing/Harbor/internal/service"
 )
 
@@ -23,7 +23,17 @@ type RouterDeps struct {
 	ArtifactSvc   *service.ArtifactService
 	DeploymentSvc *service.DeploymentService
 	AuditSvc      *service.AuditService
-	JWTManager    *auth.JWTManager
+	AuthSvc       *service.AuthService
+	UserSvc       *service.UserService
+	OrgSvc        *service.OrganizationService
+	SigningSvc    *service.SigningService
+	DeltaSvc      *service.DeltaService
+	UploadSvc     *service.UploadService
+	ScrubSvc      *service.ScrubService
+	CleanupSvc    *service.CleanupService
+	ChannelSvc    *service.ChannelService
+	StateSvc      *service.StateService
+	ManifestSvc   *service.ManifestService
 	CORSOrigins   string
 	Logger        *slog.Logger
 }
@@ -45,9 +55,9 @@ func NewRouter(deps RouterDeps) http.Handler {
 	origins := strings.Split(deps.CORSOrigins, ",")
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   origins,
-		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
-		ExposedHeaders:   []string{"X-Checksum-SHA256", "Content-Disposition"},
+		AllowedMethods:   []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", middleware.OrganizationHeader, "Upload-Offset", "Upload-Checksum"},
+		ExposedHeaders:   []string{"X-Checksum-SHA256", "Content-Disposition", "ETag", "Accept-Ranges", "Content-Range", "Location", "Upload-Offset", "Upload-Length"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
@@ -69,8 +79,10 @@ func NewRouter(deps RouterDeps) http.Handler {
 
 	// Device API — used by harbor-agent on devices
 	deviceAuthHandler := device.NewAuthHandler(deps.DeviceSvc)
-	deviceDeployHandler := device.NewDeploymentHandler(deps.DeploymentSvc, deps.ArtifactSvc)
-	deviceInventoryHandler := device.NewInventoryHandler(deps.DeviceSvc)
+	deviceDeployHandler := device.NewDeploymentHandler(deps.DeploymentSvc, deps.ArtifactSvc, deps.DeltaSvc, deps.DeviceSvc)
+	deviceInventoryHandler := device.NewInventoryHandler(deps.DeviceSvc, deps.ManifestSvc)
+	deviceDesiredStateHandler := device.NewDesiredStateHandler(deps.ManifestSvc)
+	deviceSigningHandler := device.NewSigningKeyHandler(deps.SigningSvc)
 
 	r.Route("/api/v1/device", func(r chi.Router) {
 		// Rate limit device polling: 10 req/s with burst of 20
@@ -84,61 +96,155 @@ func NewRouter(deps RouterDeps) http.Handler {
 			r.Use(middleware.DeviceAuth(deps.DeviceSvc))
 			r.Get("/deployments/next", deviceDeployHandler.GetNext)
 			r.Put("/deployments/{id}/status", deviceDeployHandler.UpdateStatus)
+			r.Post("/deployments/{id}/output", deviceDeployHandler.AppendOutput)
 			r.Get("/deployments/{id}/download", deviceDeployHandler.Download)
+			r.Get("/deployments/{id}/delta", deviceDeployHandler.DownloadDelta)
+			r.Get("/deployments/{id}/files/{index}", deviceDeployHandler.DownloadFile)
 			r.Patch("/inventory", deviceInventoryHandler.Update)
+			r.Get("/desired-state", deviceDesiredStateHandler.Get)
+			r.Put("/encryption-key", deviceInventoryHandler.SetEncryptionKey)
+			r.Get("/signing-keys", deviceSigningHandler.List)
 		})
 	})
 
 	// Management API — used by React frontend
-	mgmtAuthHandler := management.NewAuthHandler(deps.JWTManager)
+	mgmtAuthHandler := management.NewAuthHandler(deps.AuthSvc, deps.UserSvc)
+	mgmtUserHandler := management.NewUserHandler(deps.UserSvc)
 	mgmtDeviceHandler := management.NewDeviceHandler(deps.DeviceSvc)
-	mgmtArtifactHandler := management.NewArtifactHandler(deps.ArtifactSvc)
+	mgmtArtifactHandler := management.NewArtifactHandler(deps.ArtifactSvc, deps.DeltaSvc, deps.DeviceSvc, deps.DeploymentSvc)
 	mgmtDeploymentHandler := management.NewDeploymentHandler(deps.DeploymentSvc)
 	mgmtAuditHandler := management.NewAuditHandler(deps.AuditSvc)
+	mgmtOrgHandler := management.NewOrganizationHandler(deps.OrgSvc)
+	mgmtSigningHandler := management.NewSigningKeyHandler(deps.SigningSvc)
+	mgmtUploadHandler := management.NewUploadHandler(deps.UploadSvc)
+	mgmtStorageHandler := management.NewStorageHandler(deps.ScrubSvc, deps.CleanupSvc)
+	mgmtChannelHandler := management.NewChannelHandler(deps.ChannelSvc)
+	mgmtStateHandler := management.NewStateHandler(deps.StateSvc)
+	mgmtManifestHandler := management.NewManifestHandler(deps.ManifestSvc)
 
 	r.Route("/api/v1/management", func(r chi.Router) {
 		// Rate limit management API: 30 req/s with burst of 60
 		r.Use(middleware.RateLimit(30, 60))
 
-		// Login (no auth required)
+		// Login and refresh (no access token required)
 		r.Post("/auth/login", mgmtAuthHandler.Login)
-
-		// Refresh token (requires valid JWT)
-		r.Group(func(r chi.Router) {
-			r.Use(middleware.ManagementAuth(deps.JWTManager))
-			r.Post("/auth/refresh", mgmtAuthHandler.Refresh)
-		})
+		r.Post("/auth/refresh", mgmtAuthHandler.Refresh)
 
 		// Authenticated management endpoints
 		r.Group(func(r chi.Router) {
-			r.Use(middleware.ManagementAuth(deps.JWTManager))
-			r.Use(middleware.AuditLog(deps.AuditSvc))
-
-			// Devices
-			r.Get("/devices", mgmtDeviceHandler.List)
-			r.Get("/devices/count", mgmtDeviceHandler.Count)
-			r.Get("/devices/{id}", mgmtDeviceHandler.Get)
-			r.Put("/devices/{id}/status", mgmtDeviceHandler.UpdateStatus)
-			r.Patch("/devices/{id}/tags", mgmtDeviceHandler.UpdateTags)
-			r.Delete("/devices/{id}", mgmtDeviceHandler.Delete)
-
-			// Artifacts
-			r.Get("/artifacts", mgmtArtifactHandler.List)
-			r.Post("/artifacts", mgmtArtifactHandler.Upload)
-			r.Get("/artifacts/{id}", mgmtArtifactHandler.Get)
-			r.Get("/artifacts/{id}/download", mgmtArtifactHandler.Download)
-			r.Delete("/artifacts/{id}", mgmtArtifactHandler.Delete)
-
-			// Deployments
-			r.Get("/deployments", mgmtDeploymentHandler.List)
-			r.Post("/deployments", mgmtDeploymentHandler.Create)
-			r.Get("/deployments/statistics", mgmtDeploymentHandler.Stats)
-			r.Get("/deployments/{id}", mgmtDeploymentHandler.Get)
-			r.Post("/deployments/{id}/cancel", mgmtDeploymentHandler.Cancel)
-			r.Get("/deployments/{id}/devices", mgmtDeploymentHandler.GetDevices)
-
-			// Audit Log
-			r.Get("/audit", mgmtAuditHandler.List)
+			r.Use(middleware.ManagementAuth(deps.AuthSvc))
+
+			// Session and two-factor enrolment. These stay reachable when the
+			// security policy blocks a session so that the user can enrol.
+			r.Group(func(r chi.Router) {
+				r.Use(middleware.AuditLog(deps.AuditSvc))
+
+				r.Post("/auth/logout", mgmtAuthHandler.Logout)
+				r.Get("/auth/me", mgmtAuthHandler.Me)
+				r.Post("/auth/totp/enroll", mgmtAuthHandler.EnrollTOTP)
+				r.Post("/auth/totp/confirm", mgmtAuthHandler.ConfirmTOTP)
+				r.Post("/auth/totp/disable", mgmtAuthHandler.DisableTOTP)
+				r.Post("/auth/totp/recovery-codes", mgmtAuthHandler.RegenerateRecoveryCodes)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(middleware.RequireTOTPPolicy(deps.AuthSvc))
+
+				r.Get("/organizations", mgmtOrgHandler.List)
+
+				// Server-wide administration, not scoped to an organization
+				r.Group(func(r chi.Router) {
+					r.Use(middleware.RequireRole(domain.RoleAdmin))
+					r.Use(middleware.AuditLog(deps.AuditSvc))
+
+					r.Get("/users", mgmtUserHandler.List)
+					r.Post("/users", mgmtUserHandler.Create)
+					r.Patch("/users/{id}", mgmtUserHandler.UpdateRole)
+					r.Delete("/users/{id}", mgmtUserHandler.Delete)
+					r.Post("/users/{id}/totp/reset", mgmtUserHandler.ResetTOTP)
+					r.Get("/security/policy", mgmtUserHandler.GetSecurityPolicy)
+					r.Put("/security/policy", mgmtUserHandler.UpdateSecurityPolicy)
+					r.Post("/organizations", mgmtOrgHandler.Create)
+					r.Post("/organizations/{id}/tenant-token", mgmtOrgHandler.RotateTenantToken)
+					r.Get("/organizations/{id}/members", mgmtOrgHandler.ListMembers)
+					r.Put("/organizations/{id}/members/{userId}", mgmtOrgHandler.AddMember)
+					r.Delete("/organizations/{id}/members/{userId}", mgmtOrgHandler.RemoveMember)
+					r.Get("/system/audit", mgmtAuditHandler.System)
+					r.Get("/system/storage/scrub", mgmtStorageHandler.LatestScrub)
+					r.Post("/system/storage/scrub", mgmtStorageHandler.StartScrub)
+					r.Post("/system/storage/retention", mgmtStorageHandler.ApplyRetention)
+				})
+
+				// Organization-scoped endpoints. The organization is selected
+				// with the X-Organization-ID header.
+				r.Group(func(r chi.Router) {
+					r.Use(middleware.OrganizationScope(deps.OrgSvc))
+					r.Use(middleware.AuditLog(deps.AuditSvc))
+
+					// Read-only endpoints, available to every role
+					r.Get("/devices", mgmtDeviceHandler.List)
+					r.Get("/devices/count", mgmtDeviceHandler.Count)
+					r.Get("/devices/drift", mgmtStateHandler.Drift)
+					r.Get("/devices/{id}", mgmtDeviceHandler.Get)
+					r.Get("/devices/{id}/state", mgmtStateHandler.Get)
+					r.Get("/artifacts", mgmtArtifactHandler.List)
+					r.Get("/artifacts/latest", mgmtArtifactHandler.Latest)
+					r.Get("/artifacts/lineage", mgmtArtifactHandler.Lineage)
+					r.Get("/artifacts/{id}", mgmtArtifactHandler.Get)
+					r.Get("/artifacts/{id}/download", mgmtArtifactHandler.Download)
+					r.Get("/artifacts/{id}/files/{index}", mgmtArtifactHandler.DownloadFile)
+					r.Get("/artifacts/{id}/deltas", mgmtArtifactHandler.ListDeltas)
+					r.Post("/artifacts/{id}/preview", mgmtArtifactHandler.Preview)
+					r.Get("/deployments", mgmtDeploymentHandler.List)
+					r.Get("/deployments/statistics", mgmtDeploymentHandler.Stats)
+					r.Get("/deployments/{id}", mgmtDeploymentHandler.Get)
+					r.Get("/deployments/{id}/devices", mgmtDeploymentHandler.GetDevices)
+					r.Get("/deployments/{id}/devices/{dd_id}/output", mgmtDeploymentHandler.GetOutput)
+					r.Get("/channels", mgmtChannelHandler.List)
+					r.Get("/channels/{id}", mgmtChannelHandler.Get)
+					r.Get("/channels/{id}/promotions", mgmtChannelHandler.ListPromotions)
+					r.Get("/manifests", mgmtManifestHandler.List)
+					r.Get("/manifests/{id}", mgmtManifestHandler.Get)
+					r.Get("/manifests/{id}/convergence", mgmtManifestHandler.Convergence)
+					r.Get("/audit", mgmtAuditHandler.List)
+					r.Get("/signing-keys", mgmtSigningHandler.List)
+
+					// Mutating endpoints
+					r.Group(func(r chi.Router) {
+						r.Use(middleware.RequireRole(domain.RoleAdmin, domain.RoleOperator))
+
+						r.Put("/devices/{id}/status", mgmtDeviceHandler.UpdateStatus)
+						r.Patch("/devices/{id}/tags", mgmtDeviceHandler.UpdateTags)
+						r.Delete("/devices/{id}", mgmtDeviceHandler.Delete)
+						r.Post("/artifacts", mgmtArtifactHandler.Upload)
+						r.Delete("/artifacts/{id}", mgmtArtifactHandler.Delete)
+						r.Post("/artifacts/uploads", mgmtUploadHandler.Create)
+						r.Get("/artifacts/uploads/{id}", mgmtUploadHandler.Get)
+						r.Head("/artifacts/uploads/{id}", mgmtUploadHandler.Get)
+						r.Patch("/artifacts/uploads/{id}", mgmtUploadHandler.WriteChunk)
+						r.Put("/artifacts/uploads/{id}", mgmtUploadHandler.WriteChunk)
+						r.Post("/artifacts/uploads/{id}/complete", mgmtUploadHandler.Complete)
+						r.Delete("/artifacts/uploads/{id}", mgmtUploadHandler.Abort)
+						r.Post("/deployments", mgmtDeploymentHandler.Create)
+						r.Post("/deployments/{id}/cancel", mgmtDeploymentHandler.Cancel)
+						r.Post("/deployments/{id}/rollback", mgmtDeploymentHandler.Rollback)
+						r.Post("/channels", mgmtChannelHandler.Create)
+						r.Delete("/channels/{id}", mgmtChannelHandler.Delete)
+						r.Post("/channels/{id}/promotions", mgmtChannelHandler.Promote)
+						r.Post("/manifests", mgmtManifestHandler.Create)
+						r.Put("/manifests/{id}/artifacts", mgmtManifestHandler.SetArtifacts)
+						r.Delete("/manifests/{id}", mgmtManifestHandler.Delete)
+					})
+
+					// Trusted signing keys
+					r.Group(func(r chi.Router) {
+						r.Use(middleware.RequireRole(domain.RoleAdmin))
+
+						r.Post("/signing-keys", mgmtSigningHandler.Add)
+						r.Delete("/signing-keys/{id}", mgmtSigningHandler.Revoke)
+					})
+				})
+			})
 		})
 	})
 
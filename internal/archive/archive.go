@@ -0,0 +1,235 @@
+// Package archive validates tar.gz and zip artifacts and lists their
+// entries, so that an agent can extract them into a directory without
+// trusting the archive.
+//
+// Only regular files and directories are accepted. Entry names must be
+// relative paths that stay inside the target directory once cleaned; links,
+// devices, absolute paths and ".." components are rejected, as are
+// duplicate entries and files whose parent is a file. Sizes are counted on
+// the bytes actually decompressed, not on the sizes recorded in headers.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+const (
+	FormatTarGz = "tar.gz"
+	FormatZip   = "zip"
+)
+
+// ErrInvalid is wrapped by every error about the content of an archive.
+var ErrInvalid = errors.New("invalid archive")
+
+// Limits bound what an archive may extract to. Zero values disable a limit.
+type Limits struct {
+	MaxEntries int
+	// MaxSize is the total size of the extracted files
+	MaxSize int64
+}
+
+// Inspect detects the format of the archive in r, validates its entries and
+// returns them sorted by path, with the SHA-256 of each file.
+func Inspect(r io.ReadSeeker, limits Limits) (string, []domain.ArchiveEntry, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", nil, fmt.Errorf("get archive size: %w", err)
+	}
+	head := make([]byte, 4)
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", nil, fmt.Errorf("seek archive: %w", err)
+	}
+	n, _ := io.ReadFull(r, head)
+	head = head[:n]
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", nil, fmt.Errorf("seek archive: %w", err)
+	}
+
+	v := &validator{limits: limits, types: make(map[string]string), parents: make(map[string]bool)}
+	var format string
+	switch {
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		format, err = FormatTarGz, v.tarGz(r)
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")) || bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		format, err = FormatZip, v.zip(readerAt{r}, size)
+	default:
+		return "", nil, fmt.Errorf("%w: not a tar.gz or zip file", ErrInvalid)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	sort.Slice(v.entries, func(i, j int) bool { return v.entries[i].Path < v.entries[j].Path })
+	return format, v.entries, nil
+}
+
+type validator struct {
+	limits  Limits
+	entries []domain.ArchiveEntry
+	types   map[string]string // path -> entry type
+	parents map[string]bool   // directories holding an entry
+	total   int64
+}
+
+func (v *validator) tarGz(r io.Reader) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			err = v.add(hdr.Name, domain.ArchiveEntryFile, hdr.FileInfo().Mode(), tr)
+		case tar.TypeDir:
+			err = v.add(hdr.Name, domain.ArchiveEntryDir, hdr.FileInfo().Mode(), nil)
+		case tar.TypeXGlobalHeader:
+			// pax metadata, not an entry
+		default:
+			err = fmt.Errorf("%w: %s: only regular files and directories are allowed", ErrInvalid, hdr.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (v *validator) zip(r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	for _, f := range zr.File {
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			err = v.add(f.Name, domain.ArchiveEntryDir, mode, nil)
+		case mode.IsRegular():
+			var rc io.ReadCloser
+			if rc, err = f.Open(); err != nil {
+				return fmt.Errorf("%w: %s: %v", ErrInvalid, f.Name, err)
+			}
+			err = v.add(f.Name, domain.ArchiveEntryFile, mode, rc)
+			rc.Close()
+		default:
+			err = fmt.Errorf("%w: %s: only regular files and directories are allowed", ErrInvalid, f.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add validates one entry and, for files, hashes its content.
+func (v *validator) add(name, typ string, mode fs.FileMode, content io.Reader) error {
+	p, err := cleanName(name)
+	if err != nil {
+		return err
+	}
+	if p == "" {
+		// The archive root, as in "./"
+		return nil
+	}
+	if v.limits.MaxEntries > 0 && len(v.entries) >= v.limits.MaxEntries {
+		return fmt.Errorf("%w: more than %d entries", ErrInvalid, v.limits.MaxEntries)
+	}
+	if prev, ok := v.types[p]; ok {
+		// Tar archives may list a directory again
+		if prev == domain.ArchiveEntryDir && typ == domain.ArchiveEntryDir {
+			return nil
+		}
+		return fmt.Errorf("%w: %s appears more than once", ErrInvalid, p)
+	}
+	if typ == domain.ArchiveEntryFile && v.parents[p] {
+		return fmt.Errorf("%w: %s is a file but holds other entries", ErrInvalid, p)
+	}
+	for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+		if v.types[dir] == domain.ArchiveEntryFile {
+			return fmt.Errorf("%w: %s is inside %s, which is a file", ErrInvalid, p, dir)
+		}
+		v.parents[dir] = true
+	}
+
+	entry := domain.ArchiveEntry{Path: p, Type: typ, Mode: fmt.Sprintf("%04o", mode.Perm())}
+	if typ == domain.ArchiveEntryFile {
+		if v.limits.MaxSize > 0 {
+			// One byte past the limit is enough to reject the archive
+			content = io.LimitReader(content, v.limits.MaxSize-v.total+1)
+		}
+		h := sha256.New()
+		n, err := io.Copy(h, content)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalid, p, err)
+		}
+		v.total += n
+		if v.limits.MaxSize > 0 && v.total > v.limits.MaxSize {
+			return fmt.Errorf("%w: extracted size exceeds %d bytes", ErrInvalid, v.limits.MaxSize)
+		}
+		entry.Size = n
+		entry.ChecksumSHA256 = hex.EncodeToString(h.Sum(nil))
+	}
+	v.types[p] = typ
+	v.entries = append(v.entries, entry)
+	return nil
+}
+
+// cleanName returns the slash-separated path of an entry relative to the
+// target directory, or an error if it could leave it.
+func cleanName(name string) (string, error) {
+	if strings.ContainsAny(name, "\\\x00\r\n") {
+		return "", fmt.Errorf("%w: %q: invalid characters in path", ErrInvalid, name)
+	}
+	if strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("%w: %s: absolute paths are not allowed", ErrInvalid, name)
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("%w: %s: path leaves the target directory", ErrInvalid, name)
+		}
+	}
+	p := path.Clean(name)
+	if p == "." {
+		return "", nil
+	}
+	return p, nil
+}
+
+// readerAt reads a seekable file at offsets, as zip needs. It is not safe
+// for concurrent use.
+type readerAt struct {
+	r io.ReadSeeker
+}
+
+func (ra readerAt) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := ra.r.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(ra.r, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,154 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+type entry struct {
+	name     string
+	typeflag byte
+	mode     int64
+	body     string
+	linkname string
+}
+
+func tarGz(t *testing.T, entries ...entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Mode: e.mode, Size: int64(len(e.body)), Linkname: e.linkname}
+		if e.typeflag != tar.TypeReg {
+			hdr.Size = 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("write header: %v", err)
+		}
+		if hdr.Size > 0 {
+			tw.Write([]byte(e.body))
+		}
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func zipFile(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, body := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		w.Write([]byte(body))
+	}
+	zw.Close()
+	return buf.Bytes()
+}
+
+func sum(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func TestInspect_TarGz(t *testing.T) {
+	data := tarGz(t,
+		entry{name: "./", typeflag: tar.TypeDir, mode: 0755},
+		entry{name: "static/", typeflag: tar.TypeDir, mode: 0750},
+		entry{name: "static/app.js", typeflag: tar.TypeReg, mode: 0644, body: "console.log(1)"},
+		entry{name: "./index.html", typeflag: tar.TypeReg, mode: 0600, body: "<html>"},
+	)
+
+	format, entries, err := Inspect(bytes.NewReader(data), Limits{})
+	if err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	if format != FormatTarGz {
+		t.Errorf("format = %s", format)
+	}
+	want := []domain.ArchiveEntry{
+		{Path: "index.html", Type: domain.ArchiveEntryFile, Mode: "0600", Size: 6, ChecksumSHA256: sum("<html>")},
+		{Path: "static", Type: domain.ArchiveEntryDir, Mode: "0750"},
+		{Path: "static/app.js", Type: domain.ArchiveEntryFile, Mode: "0644", Size: 14, ChecksumSHA256: sum("console.log(1)")},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %+v", entries)
+	}
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+}
+
+func TestInspect_Zip(t *testing.T) {
+	data := zipFile(t, map[string]string{"pkg/__init__.py": "", "pkg/mod.py": "x = 1\n"})
+
+	format, entries, err := Inspect(bytes.NewReader(data), Limits{})
+	if err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	if format != FormatZip || len(entries) != 2 || entries[1].Path != "pkg/mod.py" || entries[1].ChecksumSHA256 != sum("x = 1\n") {
+		t.Errorf("got %s %+v", format, entries)
+	}
+}
+
+func TestInspect_RejectsUnsafeEntries(t *testing.T) {
+	cases := map[string][]byte{
+		"parent traversal": tarGz(t, entry{name: "../etc/passwd", typeflag: tar.TypeReg, body: "x"}),
+		"nested traversal": tarGz(t, entry{name: "a/../../b", typeflag: tar.TypeReg, body: "x"}),
+		"absolute path":    tarGz(t, entry{name: "/etc/passwd", typeflag: tar.TypeReg, body: "x"}),
+		"symlink":          tarGz(t, entry{name: "link", typeflag: tar.TypeSymlink, linkname: "/etc/shadow"}),
+		"hard link":        tarGz(t, entry{name: "link", typeflag: tar.TypeLink, linkname: "other"}),
+		"duplicate": tarGz(t,
+			entry{name: "a", typeflag: tar.TypeReg, body: "1"},
+			entry{name: "./a", typeflag: tar.TypeReg, body: "2"}),
+		"file holding entries": tarGz(t,
+			entry{name: "a/b", typeflag: tar.TypeReg, body: "1"},
+			entry{name: "a", typeflag: tar.TypeReg, body: "2"}),
+		"zip traversal":  zipFile(t, map[string]string{"../evil": "x"}),
+		"zip backslash":  zipFile(t, map[string]string{"..\\evil": "x"}),
+		"not an archive": []byte("#!/bin/sh\necho hi\n"),
+		"truncated":      tarGz(t, entry{name: "a", typeflag: tar.TypeReg, body: "content"})[:20],
+	}
+	for name, data := range cases {
+		if _, _, err := Inspect(bytes.NewReader(data), Limits{}); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: expected ErrInvalid, got %v", name, err)
+		}
+	}
+}
+
+func TestInspect_Limits(t *testing.T) {
+	data := tarGz(t,
+		entry{name: "a", typeflag: tar.TypeReg, body: "12345"},
+		entry{name: "b", typeflag: tar.TypeReg, body: "67890"},
+	)
+
+	if _, _, err := Inspect(bytes.NewReader(data), Limits{MaxEntries: 2, MaxSize: 10}); err != nil {
+		t.Errorf("expected archive at the limits to pass, got %v", err)
+	}
+	if _, _, err := Inspect(bytes.NewReader(data), Limits{MaxEntries: 1}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected too many entries to be rejected, got %v", err)
+	}
+	if _, _, err := Inspect(bytes.NewReader(data), Limits{MaxSize: 9}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected extracted size over the limit to be rejected, got %v", err)
+	}
+
+	// A small archive that expands well past the limit
+	bomb := tarGz(t, entry{name: "zeros", typeflag: tar.TypeReg, body: string(make([]byte, 1<<20))})
+	if _, _, err := Inspect(bytes.NewReader(bomb), Limits{MaxSize: 1 << 10}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected compressed bomb to be rejected, got %v", err)
+	}
+}
@@ -8,6 +8,27 @@ import (
 )
 
 func GenerateDeviceToken() (token string, hash string, err error) {
+	return generateOpaqueToken()
+}
+
+// GenerateRefreshToken returns an opaque management refresh token and the
+// hash that is persisted server-side.
+func GenerateRefreshToken() (token string, hash string, err error) {
+	return generateOpaqueToken()
+}
+
+// GenerateTenantToken returns an organization's device enrolment token and
+// its hash. Devices present the token in their identity payload.
+func GenerateTenantToken() (token string, hash string, err error) {
+	return generateOpaqueToken()
+}
+
+func HashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+func generateOpaqueToken() (token string, hash string, err error) {
 	b := make([]byte, 32)
 	if _, err := rand.Read(b); err != nil {
 		return "", "", fmt.Errorf("generate random: %w", err)
@@ -17,8 +38,3 @@ func GenerateDeviceToken() (token string, hash string, err error) {
 	hash = HashToken(token)
 	return token, hash, nil
 }
-
-func HashToken(token string) string {
-	h := sha256.Sum256([]byte(token))
-	return hex.EncodeToString(h[:])
-}
@@ -5,38 +5,70 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
+// JWTKey is an HMAC secret identified by the kid header of the tokens it signs.
+type JWTKey struct {
+	ID     string
+	Secret string
+}
+
 type JWTManager struct {
-	secret []byte
-	expiry time.Duration
+	keys       map[string][]byte
+	signingKID string
+	expiry     time.Duration
+}
+
+// Subject describes the management user an access token is issued to.
+type Subject struct {
+	UserID string
+	Role   string
+	MFA    bool // session was established with a second factor
 }
 
 type ManagementClaims struct {
 	UserID string `json:"user_id"`
+	Role   string `json:"role,omitempty"`
+	MFA    bool   `json:"mfa,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func NewJWTManager(secret string, expiry time.Duration) *JWTManager {
+// NewJWTManager creates a manager that signs with current and accepts tokens
+// signed by any of the previous keys, so secrets can be rotated without
+// invalidating sessions that are still in flight.
+func NewJWTManager(current JWTKey, previous []JWTKey, expiry time.Duration) *JWTManager {
+	keys := make(map[string][]byte, len(previous)+1)
+	for _, k := range previous {
+		keys[k.ID] = []byte(k.Secret)
+	}
+	keys[current.ID] = []byte(current.Secret)
+
 	return &JWTManager{
-		secret: []byte(secret),
-		expiry: expiry,
+		keys:       keys,
+		signingKID: current.ID,
+		expiry:     expiry,
 	}
 }
 
-func (m *JWTManager) Generate(userID string) (string, time.Time, error) {
-	expiresAt := time.Now().Add(m.expiry)
+func (m *JWTManager) Generate(sub Subject) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(m.expiry)
 	claims := ManagementClaims{
-		UserID: userID,
+		UserID: sub.UserID,
+		Role:   sub.Role,
+		MFA:    sub.MFA,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
 			ExpiresAt: jwt.NewNumericDate(expiresAt),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			IssuedAt:  jwt.NewNumericDate(now),
 			Issuer:    "harbor",
 		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signed, err := token.SignedString(m.secret)
+	token.Header["kid"] = m.signingKID
+	signed, err := token.SignedString(m.keys[m.signingKID])
 	if err != nil {
 		return "", time.Time{}, fmt.Errorf("sign jwt: %w", err)
 	}
@@ -49,7 +81,17 @@ func (m *JWTManager) Validate(tokenStr string) (*ManagementClaims, error) {
 		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
 		}
-		return m.secret, nil
+		// Tokens issued before key IDs were introduced carry no kid and
+		// are checked against the current signing key.
+		kid, _ := t.Header["kid"].(string)
+		if kid == "" {
+			kid = m.signingKID
+		}
+		secret, ok := m.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id: %s", kid)
+		}
+		return secret, nil
 	})
 	if err != nil {
 		return nil, fmt.Errorf("parse jwt: %w", err)
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Artifact signatures are detached Ed25519 signatures over the raw 32-byte
+// SHA-256 digest of the file, so that signing and verifying never require the
+// whole file in memory. Signatures and keys are exchanged base64-encoded.
+
+// KeyFingerprint identifies a public key: the first 8 bytes of its SHA-256
+// hash, hex-encoded. CI pipelines can compute it without talking to Harbor.
+func KeyFingerprint(pub ed25519.PublicKey) string {
+	h := sha256.Sum256(pub)
+	return hex.EncodeToString(h[:8])
+}
+
+// ParsePublicKey decodes a base64 Ed25519 public key.
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("decode public key: %w", err)
+	}
+	if len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key must be %d bytes, got %d", ed25519.PublicKeySize, len(b))
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+// VerifyDigest checks a base64 signature of digest against pub.
+func VerifyDigest(pub ed25519.PublicKey, digest []byte, signature string) bool {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(signature))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return false
+	}
+	return ed25519.Verify(pub, digest, sig)
+}
+
+// Signer holds the server's own signing key, used for artifacts uploaded
+// without a signature.
+type Signer struct {
+	keyID string
+	key   ed25519.PrivateKey
+}
+
+// NewSigner parses a base64 Ed25519 seed (32 bytes) or private key (64 bytes).
+func NewSigner(encoded string) (*Signer, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("decode signing key: %w", err)
+	}
+
+	var key ed25519.PrivateKey
+	switch len(b) {
+	case ed25519.SeedSize:
+		key = ed25519.NewKeyFromSeed(b)
+	case ed25519.PrivateKeySize:
+		key = ed25519.PrivateKey(b)
+	default:
+		return nil, fmt.Errorf("signing key must be a %d-byte seed or %d-byte private key", ed25519.SeedSize, ed25519.PrivateKeySize)
+	}
+
+	pub := key.Public().(ed25519.PublicKey)
+	return &Signer{keyID: KeyFingerprint(pub), key: key}, nil
+}
+
+func (s *Signer) KeyID() string {
+	return s.keyID
+}
+
+func (s *Signer) PublicKey() ed25519.PublicKey {
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+// SignDigest returns the base64 signature of digest.
+func (s *Signer) SignDigest(digest []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, digest))
+}
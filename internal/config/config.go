@@ -1,17 +1,28 @@
 package config
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type Config struct {
-	Server  ServerConfig
-	DB      DBConfig
-	Auth    AuthConfig
-	Storage StorageConfig
-	CORS    CORSConfig
+	Server     ServerConfig
+	DB         DBConfig
+	Auth       AuthConfig
+	Storage    StorageConfig
+	Signing    SigningConfig
+	Encryption EncryptionConfig
+	Delta      DeltaConfig
+	Archive    ArchiveConfig
+	Retention  RetentionConfig
+	Validation ValidationConfig
+	CORS       CORSConfig
 }
 
 type ServerConfig struct {
@@ -37,12 +48,97 @@ func (c DBConfig) DSN() string {
 
 type AuthConfig struct {
 	JWTSecret          string
+	JWTKeyID           string
+	JWTPreviousKeys    map[string]string // kid -> secret, accepted for validation only
 	JWTExpiry          time.Duration
+	RefreshTokenExpiry time.Duration
 	DeviceTokenExpiry  time.Duration
+	// Bootstrap admin, created on startup only when no users exist
+	AdminEmail    string
+	AdminPassword string
 }
 
 type StorageConfig struct {
-	Path string
+	// Backend is "local" (files under Path) or "s3"
+	Backend string
+	Path    string
+	S3      S3Config
+	// Resumable upload sessions expire this long after their last chunk
+	UploadSessionTTL time.Duration
+	// Interval between integrity scrubs of stored files; 0 disables them
+	ScrubInterval time.Duration
+	// Bytes all stored files may use together; 0 means unlimited
+	Quota int64
+}
+
+type S3Config struct {
+	Endpoint       string
+	PublicEndpoint string
+	Region         string
+	Bucket         string
+	AccessKey      string
+	SecretKey      string
+	Prefix         string
+	PathStyle      bool
+	PartSize       int64
+	// Lifetime of presigned download URLs handed to devices; 0 disables them
+	PresignExpiry time.Duration
+}
+
+type SigningConfig struct {
+	// Base64 Ed25519 seed or private key used to sign artifacts uploaded
+	// without a signature. Empty disables server-side signing.
+	PrivateKey string
+}
+
+type EncryptionConfig struct {
+	// 256-bit master key wrapping the data keys of stored files. Nil
+	// leaves files unencrypted.
+	Key          []byte
+	KeyID        string
+	PreviousKeys map[string][]byte // kid -> key, for files wrapped before a rotation
+}
+
+type DeltaConfig struct {
+	// Previous versions diffed against each new upload; 0 disables deltas
+	Sources     int
+	MaxFileSize int64
+}
+
+type ArchiveConfig struct {
+	// Limits on what an archive artifact may extract to
+	MaxEntries int
+	MaxSize    int64
+}
+
+type RetentionConfig struct {
+	// Versions kept per artifact name; 0 disables retention
+	KeepVersions int
+	// Artifacts deployed within this window are kept regardless
+	KeepDeployed time.Duration
+}
+
+// ValidationConfig is read from the JSON file named by
+// HARBOR_VALIDATION_CONFIG, if any.
+type ValidationConfig struct {
+	DeviceTypes map[string]DeviceTypeLimits `json:"device_types"`
+	Validators  []ValidatorConfig           `json:"validators"`
+}
+
+type DeviceTypeLimits struct {
+	// Architectures ELF binaries for the device type may be built for
+	Arch []string `json:"arch"`
+	// Maximum artifact size in bytes; 0 means unlimited
+	MaxSize int64 `json:"max_size"`
+}
+
+type ValidatorConfig struct {
+	Name string `json:"name"`
+	// Absolute path of the executable, run with the file to check as its
+	// argument
+	Path       string `json:"path"`
+	Blocking   bool   `json:"blocking"`
+	TimeoutSec int    `json:"timeout_sec"`
 }
 
 type CORSConfig struct {
@@ -50,16 +146,101 @@ type CORSConfig struct {
 }
 
 func Load() (*Config, error) {
-	jwtExpiry, err := time.ParseDuration(envOrDefault("HARBOR_JWT_EXPIRY", "24h"))
+	jwtExpiry, err := time.ParseDuration(envOrDefault("HARBOR_JWT_EXPIRY", "15m"))
 	if err != nil {
 		return nil, fmt.Errorf("invalid HARBOR_JWT_EXPIRY: %w", err)
 	}
 
+	refreshTokenExpiry, err := time.ParseDuration(envOrDefault("HARBOR_REFRESH_TOKEN_EXPIRY", "720h"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid HARBOR_REFRESH_TOKEN_EXPIRY: %w", err)
+	}
+
+	previousKeys, err := parseKeyList(os.Getenv("HARBOR_JWT_PREVIOUS_KEYS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid HARBOR_JWT_PREVIOUS_KEYS: %w", err)
+	}
+
 	deviceTokenExpiry, err := time.ParseDuration(envOrDefault("HARBOR_DEVICE_TOKEN_EXPIRY", "8760h"))
 	if err != nil {
 		return nil, fmt.Errorf("invalid HARBOR_DEVICE_TOKEN_EXPIRY: %w", err)
 	}
 
+	uploadSessionTTL, err := time.ParseDuration(envOrDefault("HARBOR_UPLOAD_SESSION_TTL", "24h"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid HARBOR_UPLOAD_SESSION_TTL: %w", err)
+	}
+
+	scrubInterval, err := time.ParseDuration(envOrDefault("HARBOR_SCRUB_INTERVAL", "24h"))
+	if err != nil || scrubInterval < 0 {
+		return nil, fmt.Errorf("invalid HARBOR_SCRUB_INTERVAL: must be a non-negative duration")
+	}
+
+	storageBackend := envOrDefault("HARBOR_STORAGE_BACKEND", "local")
+	if storageBackend != "local" && storageBackend != "s3" {
+		return nil, fmt.Errorf("invalid HARBOR_STORAGE_BACKEND: must be local or s3")
+	}
+
+	s3PathStyle, err := strconv.ParseBool(envOrDefault("HARBOR_S3_PATH_STYLE", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid HARBOR_S3_PATH_STYLE: %w", err)
+	}
+
+	s3PartSize, err := strconv.ParseInt(envOrDefault("HARBOR_S3_PART_SIZE", "16777216"), 10, 64)
+	if err != nil || s3PartSize <= 0 {
+		return nil, fmt.Errorf("invalid HARBOR_S3_PART_SIZE: must be a size in bytes")
+	}
+
+	s3PresignExpiry, err := time.ParseDuration(envOrDefault("HARBOR_S3_PRESIGN_EXPIRY", "15m"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid HARBOR_S3_PRESIGN_EXPIRY: %w", err)
+	}
+
+	encryption, err := loadEncryptionConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	deltaSources, err := strconv.Atoi(envOrDefault("HARBOR_DELTA_SOURCES", "3"))
+	if err != nil || deltaSources < 0 {
+		return nil, fmt.Errorf("invalid HARBOR_DELTA_SOURCES: must be a non-negative integer")
+	}
+
+	deltaMaxFileSize, err := strconv.ParseInt(envOrDefault("HARBOR_DELTA_MAX_FILE_SIZE", "16777216"), 10, 64)
+	if err != nil || deltaMaxFileSize < 0 {
+		return nil, fmt.Errorf("invalid HARBOR_DELTA_MAX_FILE_SIZE: must be a size in bytes")
+	}
+
+	archiveMaxEntries, err := strconv.Atoi(envOrDefault("HARBOR_ARCHIVE_MAX_ENTRIES", "10000"))
+	if err != nil || archiveMaxEntries <= 0 {
+		return nil, fmt.Errorf("invalid HARBOR_ARCHIVE_MAX_ENTRIES: must be a positive integer")
+	}
+
+	archiveMaxSize, err := strconv.ParseInt(envOrDefault("HARBOR_ARCHIVE_MAX_SIZE", "2147483648"), 10, 64)
+	if err != nil || archiveMaxSize <= 0 {
+		return nil, fmt.Errorf("invalid HARBOR_ARCHIVE_MAX_SIZE: must be a size in bytes")
+	}
+
+	storageQuota, err := strconv.ParseInt(envOrDefault("HARBOR_STORAGE_QUOTA", "0"), 10, 64)
+	if err != nil || storageQuota < 0 {
+		return nil, fmt.Errorf("invalid HARBOR_STORAGE_QUOTA: must be a size in bytes")
+	}
+
+	retentionKeepVersions, err := strconv.Atoi(envOrDefault("HARBOR_RETENTION_KEEP_VERSIONS", "0"))
+	if err != nil || retentionKeepVersions < 0 {
+		return nil, fmt.Errorf("invalid HARBOR_RETENTION_KEEP_VERSIONS: must be a non-negative integer")
+	}
+
+	retentionKeepDeployed, err := time.ParseDuration(envOrDefault("HARBOR_RETENTION_KEEP_DEPLOYED", "720h"))
+	if err != nil || retentionKeepDeployed < 0 {
+		return nil, fmt.Errorf("invalid HARBOR_RETENTION_KEEP_DEPLOYED: must be a non-negative duration")
+	}
+
+	validation, err := loadValidationConfig()
+	if err != nil {
+		return nil, err
+	}
+
 	cfg := &Config{
 		Server: ServerConfig{
 			Host: envOrDefault("HARBOR_HOST", "0.0.0.0"),
@@ -74,13 +255,51 @@ func Load() (*Config, error) {
 			SSLMode:  envOrDefault("HARBOR_DB_SSLMODE", "disable"),
 		},
 		Auth: AuthConfig{
-			JWTSecret:         envOrDefault("HARBOR_JWT_SECRET", "change-me-in-production"),
-			JWTExpiry:         jwtExpiry,
-			DeviceTokenExpiry: deviceTokenExpiry,
+			JWTSecret:          envOrDefault("HARBOR_JWT_SECRET", "change-me-in-production"),
+			JWTKeyID:           envOrDefault("HARBOR_JWT_KEY_ID", "default"),
+			JWTPreviousKeys:    previousKeys,
+			JWTExpiry:          jwtExpiry,
+			RefreshTokenExpiry: refreshTokenExpiry,
+			DeviceTokenExpiry:  deviceTokenExpiry,
+			AdminEmail:         envOrDefault("HARBOR_ADMIN_EMAIL", "admin@harbor.local"),
+			AdminPassword:      envOrDefault("HARBOR_ADMIN_PASSWORD", "admin"),
 		},
 		Storage: StorageConfig{
-			Path: envOrDefault("HARBOR_STORAGE_PATH", "/data/artifacts"),
+			Backend: storageBackend,
+			Path:    envOrDefault("HARBOR_STORAGE_PATH", "/data/artifacts"),
+			S3: S3Config{
+				Endpoint:       os.Getenv("HARBOR_S3_ENDPOINT"),
+				PublicEndpoint: os.Getenv("HARBOR_S3_PUBLIC_ENDPOINT"),
+				Region:         envOrDefault("HARBOR_S3_REGION", "us-east-1"),
+				Bucket:         os.Getenv("HARBOR_S3_BUCKET"),
+				AccessKey:      os.Getenv("HARBOR_S3_ACCESS_KEY"),
+				SecretKey:      os.Getenv("HARBOR_S3_SECRET_KEY"),
+				Prefix:         os.Getenv("HARBOR_S3_PREFIX"),
+				PathStyle:      s3PathStyle,
+				PartSize:       s3PartSize,
+				PresignExpiry:  s3PresignExpiry,
+			},
+			UploadSessionTTL: uploadSessionTTL,
+			ScrubInterval:    scrubInterval,
+			Quota:            storageQuota,
+		},
+		Signing: SigningConfig{
+			PrivateKey: os.Getenv("HARBOR_SIGNING_KEY"),
+		},
+		Encryption: encryption,
+		Delta: DeltaConfig{
+			Sources:     deltaSources,
+			MaxFileSize: deltaMaxFileSize,
+		},
+		Archive: ArchiveConfig{
+			MaxEntries: archiveMaxEntries,
+			MaxSize:    archiveMaxSize,
 		},
+		Retention: RetentionConfig{
+			KeepVersions: retentionKeepVersions,
+			KeepDeployed: retentionKeepDeployed,
+		},
+		Validation: validation,
 		CORS: CORSConfig{
 			AllowedOrigins: envOrDefault("HARBOR_CORS_ORIGINS", "http://localhost:3000"),
 		},
@@ -99,3 +318,132 @@ func envOrDefault(key, fallback string) string {
 	}
 	return fallback
 }
+
+// loadEncryptionConfig reads the master keys from HARBOR_ENCRYPTION_KEY_FILE,
+// which lists "kid:base64-key" lines with the current key first, or from
+// HARBOR_ENCRYPTION_KEY, HARBOR_ENCRYPTION_KEY_ID and
+// HARBOR_ENCRYPTION_PREVIOUS_KEYS.
+func loadEncryptionConfig() (EncryptionConfig, error) {
+	cfg := EncryptionConfig{PreviousKeys: make(map[string][]byte)}
+	var current string
+	var previous map[string]string
+
+	if path := os.Getenv("HARBOR_ENCRYPTION_KEY_FILE"); path != "" {
+		if os.Getenv("HARBOR_ENCRYPTION_KEY") != "" {
+			return cfg, fmt.Errorf("set either HARBOR_ENCRYPTION_KEY or HARBOR_ENCRYPTION_KEY_FILE, not both")
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("read HARBOR_ENCRYPTION_KEY_FILE: %w", err)
+		}
+		previous = make(map[string]string)
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			kid, key, ok := strings.Cut(line, ":")
+			if !ok || kid == "" || key == "" {
+				return cfg, fmt.Errorf("invalid HARBOR_ENCRYPTION_KEY_FILE: expected kid:key lines")
+			}
+			if current == "" {
+				cfg.KeyID, current = kid, key
+				continue
+			}
+			previous[kid] = key
+		}
+		if current == "" {
+			return cfg, fmt.Errorf("invalid HARBOR_ENCRYPTION_KEY_FILE: no key found")
+		}
+	} else {
+		current = os.Getenv("HARBOR_ENCRYPTION_KEY")
+		cfg.KeyID = envOrDefault("HARBOR_ENCRYPTION_KEY_ID", "default")
+		var err error
+		previous, err = parseKeyList(os.Getenv("HARBOR_ENCRYPTION_PREVIOUS_KEYS"))
+		if err != nil {
+			return cfg, fmt.Errorf("invalid HARBOR_ENCRYPTION_PREVIOUS_KEYS: %w", err)
+		}
+	}
+
+	if current == "" {
+		if len(previous) > 0 {
+			return cfg, fmt.Errorf("previous encryption keys require a current HARBOR_ENCRYPTION_KEY")
+		}
+		return cfg, nil
+	}
+
+	key, err := decodeEncryptionKey(current)
+	if err != nil {
+		return cfg, fmt.Errorf("invalid encryption key %q: %w", cfg.KeyID, err)
+	}
+	cfg.Key = key
+	for kid, v := range previous {
+		key, err := decodeEncryptionKey(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid encryption key %q: %w", kid, err)
+		}
+		cfg.PreviousKeys[kid] = key
+	}
+	return cfg, nil
+}
+
+// loadValidationConfig reads the device type limits and external
+// validators from the JSON file named by HARBOR_VALIDATION_CONFIG.
+func loadValidationConfig() (ValidationConfig, error) {
+	var cfg ValidationConfig
+	path := os.Getenv("HARBOR_VALIDATION_CONFIG")
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("read HARBOR_VALIDATION_CONFIG: %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("invalid HARBOR_VALIDATION_CONFIG: %w", err)
+	}
+	for dt, limits := range cfg.DeviceTypes {
+		if limits.MaxSize < 0 {
+			return cfg, fmt.Errorf("invalid HARBOR_VALIDATION_CONFIG: max_size of %s must be a size in bytes", dt)
+		}
+	}
+	names := make(map[string]bool)
+	for _, v := range cfg.Validators {
+		if v.Name == "" || names[v.Name] {
+			return cfg, fmt.Errorf("invalid HARBOR_VALIDATION_CONFIG: validators need distinct names")
+		}
+		names[v.Name] = true
+		if !filepath.IsAbs(v.Path) {
+			return cfg, fmt.Errorf("invalid HARBOR_VALIDATION_CONFIG: path of validator %s must be absolute", v.Name)
+		}
+		if v.TimeoutSec < 0 {
+			return cfg, fmt.Errorf("invalid HARBOR_VALIDATION_CONFIG: timeout_sec of validator %s must not be negative", v.Name)
+		}
+	}
+	return cfg, nil
+}
+
+func decodeEncryptionKey(v string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("must be 32 bytes, base64 encoded")
+	}
+	return key, nil
+}
+
+// parseKeyList parses a comma-separated list of "kid:secret" pairs.
+func parseKeyList(v string) (map[string]string, error) {
+	keys := make(map[string]string)
+	for _, item := range strings.Split(v, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kid, secret, ok := strings.Cut(item, ":")
+		if !ok || kid == "" || secret == "" {
+			return nil, fmt.Errorf("expected kid:secret, got %q", item)
+		}
+		keys[kid] = secret
+	}
+	return keys, nil
+}
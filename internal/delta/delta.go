@@ -0,0 +1,275 @@
+// Package delta creates and applies binary patches between two versions of a
+// file using the bsdiff algorithm (Colin Percival, "Naive differences of
+// executable code").
+//
+// The patch layout is that of BSDIFF40 with gzip in place of bzip2, which the
+// standard library cannot write:
+//
+//	offset  size  content
+//	0       8     magic "HBSDIF01"
+//	8       8     length of the compressed control block
+//	16      8     length of the compressed diff block
+//	24      8     size of the new file
+//	32      ...   control block, diff block and extra block, each gzip
+//
+// Integers are 64-bit little-endian sign-magnitude, as in bsdiff. The control
+// block is a sequence of (x, y, z) triples: add x bytes of the diff block to
+// x bytes of the old file, copy y bytes of the extra block, then move the old
+// file cursor by z.
+package delta
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Magic identifies a Harbor delta patch.
+const Magic = "HBSDIF01"
+
+const headerSize = 32
+
+var ErrCorrupt = errors.New("corrupt patch")
+
+// Diff returns a patch that turns oldData into newData.
+func Diff(oldData, newData []byte) ([]byte, error) {
+	I := make([]int, len(oldData)+1)
+	V := make([]int, len(oldData)+1)
+	qsufsort(I, V, oldData)
+	V = nil
+
+	var ctrl, db, eb bytes.Buffer
+	var buf [8]byte
+
+	oldSize, newSize := len(oldData), len(newData)
+	var scan, pos, length int
+	var lastScan, lastPos, lastOffset int
+
+	for scan < newSize {
+		oldScore := 0
+		scan += length
+		for scsc := scan; scan < newSize; scan++ {
+			pos, length = search(I, oldData, newData[scan:], 0, oldSize)
+
+			for ; scsc < scan+length; scsc++ {
+				if scsc+lastOffset < oldSize && oldData[scsc+lastOffset] == newData[scsc] {
+					oldScore++
+				}
+			}
+
+			if (length == oldScore && length != 0) || length > oldScore+8 {
+				break
+			}
+
+			if scan+lastOffset < oldSize && oldData[scan+lastOffset] == newData[scan] {
+				oldScore--
+			}
+		}
+
+		if length == oldScore && scan != newSize {
+			continue
+		}
+
+		// Extend the previous match forwards
+		s, sf, lenf := 0, 0, 0
+		for i := 0; lastScan+i < scan && lastPos+i < oldSize; {
+			if oldData[lastPos+i] == newData[lastScan+i] {
+				s++
+			}
+			i++
+			if s*2-i > sf*2-lenf {
+				sf, lenf = s, i
+			}
+		}
+
+		// and the next match backwards
+		lenb := 0
+		if scan < newSize {
+			s, sb := 0, 0
+			for i := 1; scan >= lastScan+i && pos >= i; i++ {
+				if oldData[pos-i] == newData[scan-i] {
+					s++
+				}
+				if s*2-i > sb*2-lenb {
+					sb, lenb = s, i
+				}
+			}
+		}
+
+		// Split any overlap between the two
+		if lastScan+lenf > scan-lenb {
+			overlap := (lastScan + lenf) - (scan - lenb)
+			s, ss, lens := 0, 0, 0
+			for i := 0; i < overlap; i++ {
+				if newData[lastScan+lenf-overlap+i] == oldData[lastPos+lenf-overlap+i] {
+					s++
+				}
+				if newData[scan-lenb+i] == oldData[pos-lenb+i] {
+					s--
+				}
+				if s > ss {
+					ss, lens = s, i+1
+				}
+			}
+			lenf += lens - overlap
+			lenb -= lens
+		}
+
+		for i := 0; i < lenf; i++ {
+			db.WriteByte(newData[lastScan+i] - oldData[lastPos+i])
+		}
+		extra := (scan - lenb) - (lastScan + lenf)
+		eb.Write(newData[lastScan+lenf : lastScan+lenf+extra])
+
+		for _, v := range []int{lenf, extra, (pos - lenb) - (lastPos + lenf)} {
+			putInt(buf[:], int64(v))
+			ctrl.Write(buf[:])
+		}
+
+		lastScan = scan - lenb
+		lastPos = pos - lenb
+		lastOffset = pos - scan
+	}
+
+	ctrlZ, err := compress(ctrl.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	dbZ, err := compress(db.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	ebZ, err := compress(eb.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	patch := make([]byte, headerSize, headerSize+len(ctrlZ)+len(dbZ)+len(ebZ))
+	copy(patch, Magic)
+	putInt(patch[8:], int64(len(ctrlZ)))
+	putInt(patch[16:], int64(len(dbZ)))
+	putInt(patch[24:], int64(newSize))
+	patch = append(patch, ctrlZ...)
+	patch = append(patch, dbZ...)
+	patch = append(patch, ebZ...)
+	return patch, nil
+}
+
+// Apply rebuilds the new file from oldData and a patch made by Diff. A patch
+// that is truncated, damaged or does not add up to the size in its header
+// fails with ErrCorrupt.
+func Apply(oldData, patch []byte) ([]byte, error) {
+	if len(patch) < headerSize || string(patch[:8]) != Magic {
+		return nil, fmt.Errorf("%w: bad header", ErrCorrupt)
+	}
+	ctrlLen := getInt(patch[8:])
+	dbLen := getInt(patch[16:])
+	newSize := getInt(patch[24:])
+	bodyLen := int64(len(patch) - headerSize)
+	if ctrlLen < 0 || dbLen < 0 || newSize < 0 || ctrlLen > bodyLen || dbLen > bodyLen-ctrlLen {
+		return nil, fmt.Errorf("%w: bad header", ErrCorrupt)
+	}
+
+	body := patch[headerSize:]
+	ctrl, err := gzip.NewReader(bytes.NewReader(body[:ctrlLen]))
+	if err != nil {
+		return nil, fmt.Errorf("%w: control block: %v", ErrCorrupt, err)
+	}
+	db, err := gzip.NewReader(bytes.NewReader(body[ctrlLen : ctrlLen+dbLen]))
+	if err != nil {
+		return nil, fmt.Errorf("%w: diff block: %v", ErrCorrupt, err)
+	}
+	eb, err := gzip.NewReader(bytes.NewReader(body[ctrlLen+dbLen:]))
+	if err != nil {
+		return nil, fmt.Errorf("%w: extra block: %v", ErrCorrupt, err)
+	}
+
+	// The output grows with the blocks rather than with the size in the
+	// header, which a damaged patch may overstate
+	var out bytes.Buffer
+	var buf [24]byte
+	var oldPos, newPos int64
+	oldSize := int64(len(oldData))
+
+	for newPos < newSize {
+		if _, err := io.ReadFull(ctrl, buf[:]); err != nil {
+			return nil, fmt.Errorf("%w: control block: %v", ErrCorrupt, err)
+		}
+		x, y, z := getInt(buf[0:]), getInt(buf[8:]), getInt(buf[16:])
+		if x < 0 || y < 0 || x > newSize-newPos || y > newSize-newPos-x {
+			return nil, fmt.Errorf("%w: control entry out of range", ErrCorrupt)
+		}
+
+		if n, err := io.CopyN(&out, db, x); err != nil {
+			return nil, fmt.Errorf("%w: diff block: %d of %d bytes: %v", ErrCorrupt, n, x, err)
+		}
+		added := out.Bytes()[newPos:]
+		for i := int64(0); i < x; i++ {
+			if oldPos+i >= 0 && oldPos+i < oldSize {
+				added[i] += oldData[oldPos+i]
+			}
+		}
+		newPos += x
+		oldPos += x
+
+		if n, err := io.CopyN(&out, eb, y); err != nil {
+			return nil, fmt.Errorf("%w: extra block: %d of %d bytes: %v", ErrCorrupt, n, y, err)
+		}
+		newPos += y
+		oldPos += z
+	}
+
+	// Reading each block to its end also checks its gzip checksum
+	for _, block := range []struct {
+		name string
+		r    io.Reader
+	}{{"control", ctrl}, {"diff", db}, {"extra", eb}} {
+		n, err := io.Copy(io.Discard, block.r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s block: %v", ErrCorrupt, block.name, err)
+		}
+		if n != 0 {
+			return nil, fmt.Errorf("%w: %s block does not end with the file", ErrCorrupt, block.name)
+		}
+	}
+	return out.Bytes(), nil
+}
+
+func compress(b []byte) ([]byte, error) {
+	var out bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&out, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func putInt(b []byte, v int64) {
+	u := uint64(v)
+	if v < 0 {
+		u = uint64(-v) | 1<<63
+	}
+	for i := 0; i < 8; i++ {
+		b[i] = byte(u >> (8 * i))
+	}
+}
+
+func getInt(b []byte) int64 {
+	var u uint64
+	for i := 0; i < 8; i++ {
+		u |= uint64(b[i]) << (8 * i)
+	}
+	v := int64(u &^ (1 << 63))
+	if u&(1<<63) != 0 {
+		v = -v
+	}
+	return v
+}
@@ -0,0 +1,160 @@
+package delta
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func roundTrip(t *testing.T, oldData, newData []byte) []byte {
+	t.Helper()
+	patch, err := Diff(oldData, newData)
+	if err != nil {
+		t.Fatalf("diff: %v", err)
+	}
+	got, err := Apply(oldData, patch)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if !bytes.Equal(got, newData) {
+		t.Fatalf("apply rebuilt %d bytes that differ from the %d bytes of the new file", len(got), len(newData))
+	}
+	return patch
+}
+
+func randomBytes(r *rand.Rand, n int) []byte {
+	b := make([]byte, n)
+	r.Read(b)
+	return b
+}
+
+func TestRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := randomBytes(r, 4096)
+	text := bytes.Repeat([]byte("harbor delta updates\n"), 200)
+
+	cases := []struct {
+		name             string
+		oldData, newData []byte
+	}{
+		{"both empty", nil, nil},
+		{"empty old", nil, text},
+		{"empty new", text, nil},
+		{"identical", text, text},
+		{"single byte", []byte{0}, []byte{1}},
+		{"fully different", bytes.Repeat([]byte{0xaa}, 4096), bytes.Repeat([]byte{0x55}, 4096)},
+		{"random", random, randomBytes(r, 4096)},
+		{"unrelated sizes", randomBytes(r, 100), randomBytes(r, 10000)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			roundTrip(t, c.oldData, c.newData)
+		})
+	}
+}
+
+func TestRoundTrip_Edits(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	oldData := randomBytes(r, 64<<10)
+
+	// Changed, inserted and removed bytes, as between two builds
+	newData := append([]byte(nil), oldData[:20000]...)
+	newData = append(newData, randomBytes(r, 300)...)
+	newData = append(newData, oldData[20000:40000]...)
+	newData = append(newData, oldData[45000:]...)
+	for i := 0; i < 200; i++ {
+		newData[r.Intn(len(newData))]++
+	}
+
+	patch := roundTrip(t, oldData, newData)
+	if len(patch) > len(newData)/4 {
+		t.Errorf("expected a small patch for a small change, got %d bytes for %d", len(patch), len(newData))
+	}
+	if patch := roundTrip(t, oldData, oldData); len(patch) > 1024 {
+		t.Errorf("expected a tiny patch for identical files, got %d bytes", len(patch))
+	}
+}
+
+func TestRoundTrip_Large(t *testing.T) {
+	if testing.Short() {
+		t.Skip("large round trip")
+	}
+	r := rand.New(rand.NewSource(3))
+	oldData := randomBytes(r, 4<<20)
+	newData := append([]byte(nil), oldData...)
+	for i := 0; i < 1000; i++ {
+		newData[r.Intn(len(newData))] ^= 0xff
+	}
+	newData = append(newData, randomBytes(r, 1<<20)...)
+	roundTrip(t, oldData, newData)
+}
+
+func TestApply_Corrupt(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	oldData := randomBytes(r, 2048)
+	newData := append(append([]byte(nil), oldData[:1000]...), randomBytes(r, 500)...)
+	patch, err := Diff(oldData, newData)
+	if err != nil {
+		t.Fatalf("diff: %v", err)
+	}
+
+	withInt := func(offset int, v int64) []byte {
+		p := append([]byte(nil), patch...)
+		putInt(p[offset:], v)
+		return p
+	}
+	cases := map[string][]byte{
+		"empty":               nil,
+		"header only":         patch[:headerSize],
+		"bad magic":           append([]byte("BSDIFF40"), patch[8:]...),
+		"larger size":         withInt(24, int64(len(newData))+1),
+		"smaller size":        withInt(24, int64(len(newData))-1),
+		"zero size":           withInt(24, 0),
+		"huge size":           withInt(24, 1<<62),
+		"negative size":       withInt(24, -1),
+		"control past end":    withInt(8, int64(len(patch))),
+		"huge control length": withInt(8, 1<<62),
+		"huge diff length":    withInt(16, 1<<62),
+		"trailing garbage":    append(append([]byte(nil), patch...), 0),
+	}
+	for n := headerSize + 1; n < len(patch); n += 7 {
+		cases["truncated to "+strconv.Itoa(n)] = patch[:n]
+	}
+	for name, p := range cases {
+		if _, err := Apply(oldData, p); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: expected ErrCorrupt, got %v", name, err)
+		}
+	}
+}
+
+// TestApply_DamagedBytes changes each byte of a patch in turn. Apply must not
+// panic, and must fail unless the byte does not matter, such as the time in
+// a gzip header.
+func TestApply_DamagedBytes(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	oldData := randomBytes(r, 512)
+	newData := append(append([]byte(nil), oldData[:300]...), randomBytes(r, 100)...)
+	patch, err := Diff(oldData, newData)
+	if err != nil {
+		t.Fatalf("diff: %v", err)
+	}
+
+	for i := range patch {
+		for _, mask := range []byte{0x01, 0x80, 0xff} {
+			damaged := append([]byte(nil), patch...)
+			damaged[i] ^= mask
+			got, err := Apply(oldData, damaged)
+			if err != nil {
+				if !errors.Is(err, ErrCorrupt) {
+					t.Fatalf("byte %d ^ %#x: expected ErrCorrupt, got %v", i, mask, err)
+				}
+				continue
+			}
+			if !bytes.Equal(got, newData) {
+				t.Fatalf("byte %d ^ %#x: damaged patch silently rebuilt a different file", i, mask)
+			}
+		}
+	}
+}
@@ -7,37 +7,307 @@ import (
 	"github.com/google/uuid"
 )
 
+type ArtifactKind string
+
+const (
+	ArtifactKindFile ArtifactKind = "file"
+	// ArtifactKindBundle artifacts hold several files that the agent
+	// installs together. Their ChecksumSHA256 is the digest of the bundle
+	// manifest and FileSize the size of all files.
+	ArtifactKindBundle ArtifactKind = "bundle"
+	// ArtifactKindArchive artifacts are tar.gz or zip files that the agent
+	// extracts into TargetPath as a directory, replacing the previous one
+	// atomically. FileMode is the mode of that directory.
+	ArtifactKindArchive ArtifactKind = "archive"
+	// ArtifactKindTemplate artifacts are Go text/template files that the
+	// server renders for each device when it downloads them.
+	ArtifactKindTemplate ArtifactKind = "template"
+	// ArtifactKindScript artifacts are scripts that the agent runs as
+	// described by Script instead of installing them. TargetPath is unused.
+	ArtifactKindScript ArtifactKind = "script"
+
+	// Operation artifacts change the device's filesystem without a file.
+	// Their ChecksumSHA256 is the digest of the operation manifest.
+
+	// ArtifactKindDelete removes TargetPath
+	ArtifactKindDelete ArtifactKind = "delete"
+	// ArtifactKindRename moves SourcePath to TargetPath
+	ArtifactKindRename ArtifactKind = "rename"
+	// ArtifactKindSymlink creates TargetPath as a symbolic link to SourcePath
+	ArtifactKindSymlink ArtifactKind = "symlink"
+	// ArtifactKindMkdir ensures TargetPath is a directory with FileMode and
+	// FileOwner
+	ArtifactKindMkdir ArtifactKind = "mkdir"
+)
+
 type Artifact struct {
-	ID             uuid.UUID `json:"id"`
-	Name           string    `json:"name"`
-	Version        string    `json:"version"`
-	Description    string    `json:"description"`
-	FileName       string    `json:"file_name"`
-	FileSize       int64     `json:"file_size"`
-	ChecksumSHA256 string    `json:"checksum_sha256"`
-	TargetPath     string    `json:"target_path"`
-	FileMode       string    `json:"file_mode"`
-	FileOwner      string    `json:"file_owner"`
-	DeviceTypes    []string  `json:"device_types"`
-	StoragePath    string    `json:"-"`
-	PreInstallCmd  string    `json:"pre_install_cmd,omitempty"`
-	PostInstallCmd string    `json:"post_install_cmd,omitempty"`
-	RollbackCmd    string    `json:"rollback_cmd,omitempty"`
-	CreatedAt      time.Time `json:"created_at"`
+	ID             uuid.UUID    `json:"id"`
+	OrgID          uuid.UUID    `json:"organization_id"`
+	Kind           ArtifactKind `json:"kind"`
+	Name           string       `json:"name"`
+	Version        string       `json:"version"`
+	Description    string       `json:"description"`
+	FileName       string       `json:"file_name"`
+	FileSize       int64        `json:"file_size"`
+	ChecksumSHA256 string       `json:"checksum_sha256"`
+	TargetPath     string       `json:"target_path"`
+	SourcePath     string       `json:"source_path,omitempty"`
+	FileMode       string       `json:"file_mode"`
+	FileOwner      string       `json:"file_owner"`
+	DeviceTypes    []string     `json:"device_types"`
+	StoragePath    string       `json:"-"`
+	PreInstallCmd  string       `json:"pre_install_cmd,omitempty"`
+	PostInstallCmd string       `json:"post_install_cmd,omitempty"`
+	RollbackCmd    string       `json:"rollback_cmd,omitempty"`
+	Signature      string       `json:"signature,omitempty"`
+	SigningKeyID   string       `json:"signing_key_id,omitempty"`
+	// EncryptForDevice makes the server encrypt the file for the
+	// registered key of each device it is delivered to.
+	EncryptForDevice bool `json:"encrypt_for_device"`
+	// Files are the files of a bundle, in installation order
+	Files []ArtifactFile `json:"files,omitempty"`
+	// Script says how the agent runs a script artifact
+	Script *ScriptSpec `json:"script,omitempty"`
+	// Requirements are checked, with DeviceTypes, against each device the
+	// artifact is deployed to
+	Requirements *Requirements `json:"requirements,omitempty"`
+	// ArchiveFormat and Entries describe the content of an archive
+	ArchiveFormat string         `json:"archive_format,omitempty"`
+	Entries       []ArchiveEntry `json:"entries,omitempty"`
+	// Validation holds the results of the checks run on the files when the
+	// artifact was uploaded
+	Validation []ValidationResult `json:"validation,omitempty"`
+	// QuarantinedAt is set when the storage scrubber found the file missing
+	// or corrupted. Quarantined artifacts are not delivered to devices.
+	QuarantinedAt    *time.Time `json:"quarantined_at,omitempty"`
+	QuarantineReason string     `json:"quarantine_reason,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+// IsBundle reports whether the artifact is a bundle. An empty kind is a
+// single file.
+func (a *Artifact) IsBundle() bool {
+	return a.Kind == ArtifactKindBundle
+}
+
+// IsOperation reports whether the artifact is a filesystem operation with
+// no file to download.
+func (a *Artifact) IsOperation() bool {
+	switch a.Kind {
+	case ArtifactKindDelete, ArtifactKindRename, ArtifactKindSymlink, ArtifactKindMkdir:
+		return true
+	}
+	return false
+}
+
+// IsScript reports whether the agent runs the artifact instead of
+// installing it.
+func (a *Artifact) IsScript() bool {
+	return a.Kind == ArtifactKindScript
+}
+
+// IsTemplate reports whether the artifact is rendered for each device.
+func (a *Artifact) IsTemplate() bool {
+	return a.Kind == ArtifactKindTemplate
+}
+
+// ValidationBlock returns why the validation of the artifact keeps it from
+// being deployed, or "" if it does not.
+func (a *Artifact) ValidationBlock() string {
+	for _, r := range a.Validation {
+		if r.Blocking {
+			return r.String()
+		}
+	}
+	return ""
+}
+
+type ValidationStatus string
+
+const (
+	ValidationPassed ValidationStatus = "passed"
+	ValidationFailed ValidationStatus = "failed"
+	// ValidationError is a check that could not run, such as an external
+	// validator that timed out
+	ValidationError ValidationStatus = "error"
+)
+
+// Checks run on uploaded artifacts. External validators are reported as
+// ValidationCheckExternal with their name in Validator.
+const (
+	ValidationCheckMaxSize  = "max_size"
+	ValidationCheckELFArch  = "elf_arch"
+	ValidationCheckShell    = "shell_syntax"
+	ValidationCheckConfig   = "config_syntax"
+	ValidationCheckExternal = "external"
+)
+
+// ValidationResult is the outcome of one check on an artifact, or on one
+// file of it. Blocking is set on the results that keep the artifact from
+// being deployed.
+type ValidationResult struct {
+	Check     string           `json:"check"`
+	Validator string           `json:"validator,omitempty"`
+	File      string           `json:"file,omitempty"`
+	Status    ValidationStatus `json:"status"`
+	Blocking  bool             `json:"blocking"`
+	Detail    string           `json:"detail,omitempty"`
+}
+
+func (r ValidationResult) String() string {
+	s := r.Check
+	if r.Validator != "" {
+		s += " " + r.Validator
+	}
+	if r.File != "" {
+		s += " on " + r.File
+	}
+	s += " " + string(r.Status)
+	if r.Detail != "" {
+		s += ": " + r.Detail
+	}
+	return s
+}
+
+// ScriptSpec says how the agent runs the file of a script artifact: with
+// Interpreter, in WorkingDir, with Env added to its environment, and killed
+// after TimeoutSec seconds.
+type ScriptSpec struct {
+	Interpreter string            `json:"interpreter"`
+	TimeoutSec  int               `json:"timeout_sec"`
+	WorkingDir  string            `json:"working_dir,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+}
+
+// Inventory attributes that requirements are checked against. Versions are
+// semantic versions and free disk space is a number of bytes.
+const (
+	InventoryOSVersionKey = "os_version"
+	InventoryArchKey      = "arch"
+	InventoryFreeDiskKey  = "free_disk_bytes"
+)
+
+// Requirements are what a device needs, besides one of the artifact's
+// device types, to receive an artifact. Devices that report their free disk
+// space also need room for FileSize, whether or not Requirements are set.
+type Requirements struct {
+	// MinOSVersion and MaxOSVersion bound, inclusively, the os_version the
+	// device reports
+	MinOSVersion string `json:"min_os_version,omitempty"`
+	MaxOSVersion string `json:"max_os_version,omitempty"`
+	// Arch lists the architectures the device may report as arch
+	Arch []string `json:"arch,omitempty"`
+	// InventoryKeys are inventory attributes the device must report
+	InventoryKeys []string `json:"inventory_keys,omitempty"`
+	// Depends are artifacts the device must have installed
+	Depends []Dependency `json:"depends,omitempty"`
+}
+
+// Dependency is met by a device that has an artifact named Name installed at
+// MinVersion or a higher version, or at any version when MinVersion is
+// empty. The version is the one the device reports in its inventory, or of
+// the last artifact of that name it installed when it reports none.
+type Dependency struct {
+	Name       string `json:"name"`
+	MinVersion string `json:"min_version,omitempty"`
+}
+
+// ArtifactFile is one file of a bundle artifact. Its content is a blob, as
+// the file of a single-file artifact.
+type ArtifactFile struct {
+	FileName       string `json:"file_name"`
+	TargetPath     string `json:"target_path"`
+	FileMode       string `json:"file_mode"`
+	FileOwner      string `json:"file_owner"`
+	FileSize       int64  `json:"file_size"`
+	ChecksumSHA256 string `json:"checksum_sha256"`
+	StoragePath    string `json:"-"`
+}
+
+const (
+	ArchiveEntryFile = "file"
+	ArchiveEntryDir  = "dir"
+)
+
+// ArchiveEntry is a file or directory of an archive artifact. Path is
+// relative to the target directory and slash-separated.
+type ArchiveEntry struct {
+	Path           string `json:"path"`
+	Type           string `json:"type"`
+	Mode           string `json:"mode"`
+	Size           int64  `json:"size,omitempty"`
+	ChecksumSHA256 string `json:"checksum_sha256,omitempty"`
 }
 
 type ArtifactFilter struct {
 	Name       *string
 	DeviceType *string
-	Page       int
-	PerPage    int
-	SortBy     string
-	SortOrder  string
+	// Quarantined selects quarantined (true) or deliverable (false) artifacts
+	Quarantined *bool
+	Page        int
+	PerPage     int
+	SortBy      string
+	SortOrder   string
+}
+
+// LatestArtifactFilter selects the artifacts listed by ListLatest.
+type LatestArtifactFilter struct {
+	Name       *string
+	DeviceType *string
+	// Prerelease includes versions with prerelease identifiers
+	Prerelease bool
+}
+
+// LatestArtifact is the highest version of an artifact name that targets a
+// device type.
+type LatestArtifact struct {
+	Name       string    `json:"name"`
+	DeviceType string    `json:"device_type"`
+	Artifact   *Artifact `json:"artifact"`
+}
+
+// ArtifactLineage lists the versions of an artifact name, highest first,
+// with their deployments.
+type ArtifactLineage struct {
+	Name     string                    `json:"name"`
+	Versions []*ArtifactVersionHistory `json:"versions"`
+}
+
+type ArtifactVersionHistory struct {
+	Artifact    *Artifact            `json:"artifact"`
+	Deployments []*DeploymentSummary `json:"deployments"`
+	// Devices counts the entries of every deployment of the version by
+	// status. SuccessRate is the share of the finished ones (success or
+	// failure) that succeeded; it is nil until one finishes.
+	Devices     map[DeploymentDeviceStatus]int `json:"devices"`
+	SuccessRate *float64                       `json:"success_rate"`
 }
 
 type ArtifactRepository interface {
 	Create(ctx context.Context, artifact *Artifact) error
-	GetByID(ctx context.Context, id uuid.UUID) (*Artifact, error)
-	List(ctx context.Context, filter ArtifactFilter) ([]*Artifact, int, error)
-	Delete(ctx context.Context, id uuid.UUID) error
+	GetByID(ctx context.Context, orgID, id uuid.UUID) (*Artifact, error)
+	List(ctx context.Context, orgID uuid.UUID, filter ArtifactFilter) ([]*Artifact, int, error)
+	// ListByName returns every version of the named artifact, newest first.
+	ListByName(ctx context.Context, orgID uuid.UUID, name string) ([]*Artifact, error)
+	// ListLatest returns, for each artifact name and device type, the
+	// deliverable artifact with the highest semantic version. Versions
+	// stored before versions had to be semantic are not considered, nor
+	// artifacts whose validation blocks their deployment.
+	ListLatest(ctx context.Context, orgID uuid.UUID, filter LatestArtifactFilter) ([]*LatestArtifact, error)
+	// Delete fails with ErrConflict while a deployment, manifest or channel
+	// promotion refers to the artifact.
+	Delete(ctx context.Context, orgID, id uuid.UUID) error
+	// DeleteWithDeployments deletes an artifact and its completed or
+	// cancelled deployments, with their device entries, in one transaction,
+	// and returns how many deployments were deleted. It fails with
+	// ErrConflict while an unfinished deployment, a manifest or a channel
+	// refers to the artifact.
+	DeleteWithDeployments(ctx context.Context, orgID, id uuid.UUID) (int, error)
+	// SetQuarantine quarantines every artifact with the given content,
+	// including bundles with a file of that content, and returns their IDs.
+	SetQuarantine(ctx context.Context, orgID uuid.UUID, checksum, reason string) ([]uuid.UUID, error)
+	// ReleaseQuarantine releases the quarantined artifacts with the given
+	// content, except bundles that also hold a file whose checksum is in
+	// broken. It returns the IDs of the released artifacts.
+	ReleaseQuarantine(ctx context.Context, orgID uuid.UUID, checksum string, broken []string) ([]uuid.UUID, error)
 }
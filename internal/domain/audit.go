@@ -9,6 +9,7 @@ import (
 
 type AuditEntry struct {
 	ID         uuid.UUID              `json:"id"`
+	OrgID      *uuid.UUID             `json:"organization_id,omitempty"` // nil for server-level events
 	Actor      string                 `json:"actor"`
 	ActorType  string                 `json:"actor_type"` // management, device, system
 	Action     string                 `json:"action"`     // e.g. device.accept, artifact.upload
@@ -30,5 +31,7 @@ type AuditFilter struct {
 
 type AuditRepository interface {
 	Create(ctx context.Context, entry *AuditEntry) error
-	List(ctx context.Context, filter AuditFilter) ([]*AuditEntry, int, error)
+	// List returns the entries of orgID, or the server-level entries that
+	// belong to no organization when orgID is uuid.Nil.
+	List(ctx context.Context, orgID uuid.UUID, filter AuditFilter) ([]*AuditEntry, int, error)
 }
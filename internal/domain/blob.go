@@ -0,0 +1,42 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Blob is a stored file addressed by its SHA-256. Artifacts of an
+// organization with the same content share one blob, which is deleted with
+// its file once no artifact references it. Blobs are not shared between
+// organizations.
+type Blob struct {
+	OrgID          uuid.UUID
+	ChecksumSHA256 string
+	Size           int64
+	StoragePath    string
+	RefCount       int
+	CreatedAt      time.Time
+}
+
+type BlobRepository interface {
+	// Acquire takes a reference to the blob with blob.ChecksumSHA256,
+	// creating it from blob when the organization has none. For an existing
+	// blob the fields are replaced with the stored ones and created is false,
+	// so the caller's copy of the file is not needed.
+	Acquire(ctx context.Context, blob *Blob) (created bool, err error)
+	// AddRef takes a reference to an existing blob, or fails with
+	// ErrNotFound.
+	AddRef(ctx context.Context, orgID uuid.UUID, checksum string) (*Blob, error)
+	// Release drops a reference. When it was the last one the blob is
+	// deleted and returned so that its file can be removed; otherwise the
+	// result is nil.
+	Release(ctx context.Context, orgID uuid.UUID, checksum string) (*Blob, error)
+	// ListAll returns the blobs of every organization.
+	ListAll(ctx context.Context) ([]*Blob, error)
+	// StoredSize returns the size of every stored file of every
+	// organization, blobs and delta files, with the declared size of every
+	// upload session, which reserves it for its chunks.
+	StoredSize(ctx context.Context) (int64, error)
+}
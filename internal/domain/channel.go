@@ -0,0 +1,55 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Channel is a release channel, such as dev, staging or stable. Devices with
+// all of DeviceTags are subscribed to it. Promoting an artifact to a channel
+// replaces the continuous deployment of the channel with one of the artifact.
+type Channel struct {
+	ID          uuid.UUID `json:"id"`
+	OrgID       uuid.UUID `json:"organization_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	DeviceTags  []string  `json:"device_tags"`
+	// UpstreamID is the channel an artifact must be promoted to before it
+	// can be promoted to this one.
+	UpstreamID *uuid.UUID `json:"upstream_id,omitempty"`
+	// ArtifactID and DeploymentID are set by the latest promotion
+	ArtifactID   *uuid.UUID `json:"artifact_id,omitempty"`
+	DeploymentID *uuid.UUID `json:"deployment_id,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// ChannelPromotion records who promoted an artifact to a channel, and why.
+type ChannelPromotion struct {
+	ID           uuid.UUID  `json:"id"`
+	OrgID        uuid.UUID  `json:"organization_id"`
+	ChannelID    uuid.UUID  `json:"channel_id"`
+	ArtifactID   uuid.UUID  `json:"artifact_id"`
+	DeploymentID *uuid.UUID `json:"deployment_id,omitempty"`
+	PromotedBy   string     `json:"promoted_by"`
+	Reason       string     `json:"reason"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+type ChannelRepository interface {
+	// Create fails with ErrConflict if the organization has a channel with
+	// the same name.
+	Create(ctx context.Context, channel *Channel) error
+	GetByID(ctx context.Context, orgID, id uuid.UUID) (*Channel, error)
+	List(ctx context.Context, orgID uuid.UUID) ([]*Channel, error)
+	Delete(ctx context.Context, orgID, id uuid.UUID) error
+	// CreatePromotion records a promotion and makes its artifact and
+	// deployment the current ones of the channel.
+	CreatePromotion(ctx context.Context, promotion *ChannelPromotion) error
+	// ListPromotions returns the promotions of a channel, newest first.
+	ListPromotions(ctx context.Context, orgID, channelID uuid.UUID) ([]*ChannelPromotion, error)
+	// HasPromotion reports whether artifactID was ever promoted to channelID.
+	HasPromotion(ctx context.Context, orgID, channelID, artifactID uuid.UUID) (bool, error)
+}
@@ -0,0 +1,33 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// DataKey is the key a stored file is encrypted with, itself encrypted
+// ("wrapped") with the master key MasterKeyID. Rotating the master key only
+// re-wraps data keys; the files are not rewritten.
+type DataKey struct {
+	StoragePath string
+	MasterKeyID string
+	WrappedKey  []byte
+	CreatedAt   time.Time
+}
+
+type DataKeyRepository interface {
+	// Create stores the key of a new file, replacing the key of an earlier
+	// file written to the same path.
+	Create(ctx context.Context, key *DataKey) error
+	// Get returns the data key of a file, or ErrNotFound for a file stored
+	// before encryption was enabled.
+	Get(ctx context.Context, storagePath string) (*DataKey, error)
+	Delete(ctx context.Context, storagePath string) error
+	// ListForRewrap returns up to limit keys not wrapped with masterKeyID,
+	// ordered by storage path and starting after afterPath.
+	ListForRewrap(ctx context.Context, masterKeyID, afterPath string, limit int) ([]*DataKey, error)
+	// Rewrap stores key.WrappedKey and key.MasterKeyID, unless the key was
+	// re-wrapped with another master key than oldMasterKeyID meanwhile, in
+	// which case it fails with ErrConflict.
+	Rewrap(ctx context.Context, key *DataKey, oldMasterKeyID string) error
+}
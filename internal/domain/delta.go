@@ -0,0 +1,59 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ArtifactDelta is a binary patch that rebuilds the file of ArtifactID from
+// the file of an earlier version of the same artifact, SourceArtifactID.
+type ArtifactDelta struct {
+	ID               uuid.UUID `json:"id"`
+	OrgID            uuid.UUID `json:"organization_id"`
+	ArtifactID       uuid.UUID `json:"artifact_id"`
+	SourceArtifactID uuid.UUID `json:"source_artifact_id"`
+	SourceVersion    string    `json:"source_version"`
+	SourceChecksum   string    `json:"source_checksum_sha256"`
+	FileSize         int64     `json:"file_size"`
+	ChecksumSHA256   string    `json:"checksum_sha256"`
+	StoragePath      string    `json:"-"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// InventoryInstalledKey is the inventory attribute where devices report what
+// is installed, keyed by target path:
+//
+//	"installed": {"/usr/local/bin/myapp": {"version": "1.1.0", "checksum_sha256": "..."}}
+const InventoryInstalledKey = "installed"
+
+// InstalledFile is a device's report of the file at a target path.
+type InstalledFile struct {
+	Version        string `json:"version"`
+	ChecksumSHA256 string `json:"checksum_sha256,omitempty"`
+}
+
+// InstalledAt returns what the device inventory reports at targetPath.
+func InstalledAt(inventory map[string]interface{}, targetPath string) (InstalledFile, bool) {
+	installed, _ := inventory[InventoryInstalledKey].(map[string]interface{})
+	entry, ok := installed[targetPath].(map[string]interface{})
+	if !ok {
+		return InstalledFile{}, false
+	}
+	version, _ := entry["version"].(string)
+	checksum, _ := entry["checksum_sha256"].(string)
+	if version == "" {
+		return InstalledFile{}, false
+	}
+	return InstalledFile{Version: version, ChecksumSHA256: checksum}, true
+}
+
+// ArtifactDeltaRepository stores generated deltas. Rows are removed with
+// either of their artifacts.
+type ArtifactDeltaRepository interface {
+	Create(ctx context.Context, delta *ArtifactDelta) error
+	Get(ctx context.Context, orgID, artifactID, sourceArtifactID uuid.UUID) (*ArtifactDelta, error)
+	// ListByArtifact returns the deltas that produce or start from an artifact.
+	ListByArtifact(ctx context.Context, orgID, artifactID uuid.UUID) ([]*ArtifactDelta, error)
+}
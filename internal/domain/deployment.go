@@ -29,6 +29,7 @@ const (
 
 type Deployment struct {
 	ID               uuid.UUID        `json:"id"`
+	OrgID            uuid.UUID        `json:"organization_id"`
 	Name             string           `json:"name"`
 	ArtifactID       uuid.UUID        `json:"artifact_id"`
 	Status           DeploymentStatus `json:"status"`
@@ -36,6 +37,17 @@ type Deployment struct {
 	TargetDeviceTags []string         `json:"target_device_tags,omitempty"`
 	TargetDeviceTypes []string        `json:"target_device_types,omitempty"`
 	MaxParallel      int              `json:"max_parallel"`
+	// Variables are available to template artifacts as .Vars
+	Variables        map[string]string `json:"variables,omitempty"`
+	// Continuous deployments stay active and enrol the devices that match
+	// their targets later on. ChannelID is set on those of a channel.
+	Continuous       bool             `json:"continuous"`
+	ChannelID        *uuid.UUID       `json:"channel_id,omitempty"`
+	// RollbackOf is the deployment a rollback deployment reverts
+	RollbackOf       *uuid.UUID       `json:"rollback_of,omitempty"`
+	// ManifestID is set on the deployments devices converge to a manifest
+	// through. Devices are added to them as they report drift.
+	ManifestID       *uuid.UUID       `json:"manifest_id,omitempty"`
 	CreatedAt        time.Time        `json:"created_at"`
 	StartedAt        *time.Time       `json:"started_at,omitempty"`
 	FinishedAt       *time.Time       `json:"finished_at,omitempty"`
@@ -50,6 +62,51 @@ type DeploymentDevice struct {
 	Log          string                 `json:"log"`
 	StartedAt    *time.Time             `json:"started_at,omitempty"`
 	FinishedAt   *time.Time             `json:"finished_at,omitempty"`
+	// ExitCode and the output are reported by the agent for script
+	// artifacts. Output beyond the size limit is dropped and marks the
+	// output truncated.
+	ExitCode        *int  `json:"exit_code,omitempty"`
+	OutputSize      int64 `json:"output_size"`
+	OutputTruncated bool  `json:"output_truncated"`
+	// DeliverySeed derives the ephemeral key an artifact encrypted for the
+	// device is sealed with, so that every download of the entry yields the
+	// same bytes and can be resumed.
+	DeliverySeed []byte `json:"-"`
+}
+
+type OutputStream string
+
+const (
+	OutputStdout OutputStream = "stdout"
+	OutputStderr OutputStream = "stderr"
+)
+
+// OutputChunk is a piece of the output of a script artifact on a device. Seq
+// orders the chunks of a deployment device entry.
+type OutputChunk struct {
+	Seq       int64        `json:"seq"`
+	Stream    OutputStream `json:"stream"`
+	Data      string       `json:"data"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// DeploymentSummary is a deployment with the number of its entries in each
+// status.
+type DeploymentSummary struct {
+	ID         uuid.UUID                      `json:"id"`
+	ArtifactID uuid.UUID                      `json:"artifact_id"`
+	Name       string                         `json:"name"`
+	Status     DeploymentStatus               `json:"status"`
+	Devices    map[DeploymentDeviceStatus]int `json:"devices"`
+	CreatedAt  time.Time                      `json:"created_at"`
+	FinishedAt *time.Time                     `json:"finished_at,omitempty"`
+}
+
+// Rollback is the outcome of rolling back a deployment: one deployment per
+// version the devices return to, and the devices that had no earlier version.
+type Rollback struct {
+	Deployments      []*Deployment `json:"deployments"`
+	SkippedDeviceIDs []uuid.UUID   `json:"skipped_device_ids"`
 }
 
 type DeploymentFilter struct {
@@ -70,17 +127,55 @@ type DeploymentStats struct {
 
 type DeploymentRepository interface {
 	Create(ctx context.Context, deployment *Deployment) error
-	GetByID(ctx context.Context, id uuid.UUID) (*Deployment, error)
-	List(ctx context.Context, filter DeploymentFilter) ([]*Deployment, int, error)
-	UpdateStatus(ctx context.Context, id uuid.UUID, status DeploymentStatus) error
-	SetStarted(ctx context.Context, id uuid.UUID) error
-	SetFinished(ctx context.Context, id uuid.UUID) error
-	GetStats(ctx context.Context) (*DeploymentStats, error)
-
-	// DeploymentDevice operations
+	GetByID(ctx context.Context, orgID, id uuid.UUID) (*Deployment, error)
+	List(ctx context.Context, orgID uuid.UUID, filter DeploymentFilter) ([]*Deployment, int, error)
+	UpdateStatus(ctx context.Context, orgID, id uuid.UUID, status DeploymentStatus) error
+	SetStarted(ctx context.Context, orgID, id uuid.UUID) error
+	SetFinished(ctx context.Context, orgID, id uuid.UUID) error
+	GetStats(ctx context.Context, orgID uuid.UUID) (*DeploymentStats, error)
+	// ListSummariesByArtifacts returns the deployments of the given
+	// artifacts, newest first.
+	ListSummariesByArtifacts(ctx context.Context, orgID uuid.UUID, artifactIDs []uuid.UUID) ([]*DeploymentSummary, error)
+
+	// DeploymentDevice operations. CreateDeploymentDevice fails with
+	// ErrNotFound if the device and deployment belong to different organizations.
 	CreateDeploymentDevice(ctx context.Context, dd *DeploymentDevice) error
-	GetDeploymentDevices(ctx context.Context, deploymentID uuid.UUID) ([]*DeploymentDevice, error)
-	GetPendingDeploymentForDevice(ctx context.Context, deviceID uuid.UUID) (*DeploymentDevice, *Deployment, *Artifact, error)
-	UpdateDeploymentDeviceStatus(ctx context.Context, id uuid.UUID, status DeploymentDeviceStatus, log string) error
-	CountDeploymentDevicesByStatus(ctx context.Context, deploymentID uuid.UUID) (map[DeploymentDeviceStatus]int, error)
+	GetDeploymentDevices(ctx context.Context, orgID, deploymentID uuid.UUID) ([]*DeploymentDevice, error)
+	// GetDeviceEntry returns the entry of deviceID in a deployment.
+	GetDeviceEntry(ctx context.Context, orgID, deploymentID, deviceID uuid.UUID) (*DeploymentDevice, error)
+	// ResetDeploymentDevice makes a finished entry pending again, so that the
+	// device installs the artifact once more.
+	ResetDeploymentDevice(ctx context.Context, orgID, id uuid.UUID) error
+	// ListPreviousArtifacts returns, for each device that installed the
+	// artifact of deploymentID in it, the last other artifact with the same
+	// name and target path the device installed before.
+	ListPreviousArtifacts(ctx context.Context, orgID, deploymentID uuid.UUID) (map[uuid.UUID]uuid.UUID, error)
+	// ListInstallations returns the successful entries of deviceIDs, oldest
+	// first.
+	ListInstallations(ctx context.Context, orgID uuid.UUID, deviceIDs []uuid.UUID) ([]*Installation, error)
+	// ListDeployedArtifacts returns the artifacts of the deployments that
+	// still have devices to update or are continuous, unless cancelled, and
+	// of those created or with a device finishing after since.
+	ListDeployedArtifacts(ctx context.Context, orgID uuid.UUID, since time.Time) ([]uuid.UUID, error)
+	// ListContinuous returns the active continuous deployments deviceID has
+	// no entry in.
+	ListContinuous(ctx context.Context, orgID, deviceID uuid.UUID) ([]*Deployment, error)
+	GetPendingDeploymentForDevice(ctx context.Context, orgID, deviceID uuid.UUID) (*DeploymentDevice, *Deployment, *Artifact, error)
+	// GetActiveDeploymentDevice returns a deployment device entry of deviceID
+	// that is still pending or in progress.
+	GetActiveDeploymentDevice(ctx context.Context, orgID, deviceID, ddID uuid.UUID) (*DeploymentDevice, *Deployment, *Artifact, error)
+	UpdateDeploymentDeviceStatus(ctx context.Context, orgID, id uuid.UUID, status DeploymentDeviceStatus, log string) error
+	// GetDeploymentDevice returns a deployment device entry by its ID.
+	GetDeploymentDevice(ctx context.Context, orgID, id uuid.UUID) (*DeploymentDevice, error)
+	// FinishScript sets the final status of an entry that is still pending
+	// or in progress, with the exit code of its script. It fails with
+	// ErrNotFound once the entry finished.
+	FinishScript(ctx context.Context, orgID, id uuid.UUID, status DeploymentDeviceStatus, log string, code int) error
+	// AppendOutput stores a chunk of output of an entry, cut so that the
+	// output of the entry stays within maxSize bytes. It reports whether the
+	// chunk was cut or dropped.
+	AppendOutput(ctx context.Context, orgID, id uuid.UUID, chunk *OutputChunk, maxSize int64) (bool, error)
+	// ListOutput returns up to limit chunks of an entry with Seq after after.
+	ListOutput(ctx context.Context, orgID, id uuid.UUID, after int64, limit int) ([]*OutputChunk, error)
+	CountDeploymentDevicesByStatus(ctx context.Context, orgID, deploymentID uuid.UUID) (map[DeploymentDeviceStatus]int, error)
 }
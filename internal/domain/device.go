@@ -18,8 +18,13 @@ const (
 
 type IdentityData map[string]string
 
+// TenantTokenKey is the identity attribute a device uses to present its
+// organization's tenant token. It is not stored or hashed with the identity.
+const TenantTokenKey = "tenant_token"
+
 type Device struct {
 	ID            uuid.UUID              `json:"id"`
+	OrgID         uuid.UUID              `json:"organization_id"`
 	IdentityHash  string                 `json:"identity_hash"`
 	IdentityData  IdentityData           `json:"identity_data"`
 	Status        DeviceStatus           `json:"status"`
@@ -27,9 +32,15 @@ type Device struct {
 	Inventory     map[string]interface{} `json:"inventory"`
 	DeviceType    string                 `json:"device_type"`
 	Tags          []string               `json:"tags"`
-	LastCheckIn   *time.Time             `json:"last_check_in"`
-	CreatedAt     time.Time              `json:"created_at"`
-	UpdatedAt     time.Time              `json:"updated_at"`
+	// EncryptionKey is the device's X25519 public key (base64) that
+	// artifacts with EncryptForDevice are encrypted for.
+	EncryptionKey string     `json:"encryption_key,omitempty"`
+	LastCheckIn   *time.Time `json:"last_check_in"`
+	// InventoryUpdatedAt is when the device last reported its inventory;
+	// UpdatedAt also changes with status, tags, keys and check-ins.
+	InventoryUpdatedAt *time.Time `json:"inventory_updated_at,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
 }
 
 type DeviceFilter struct {
@@ -42,16 +53,20 @@ type DeviceFilter struct {
 	SortOrder  string
 }
 
+// DeviceRepository methods are scoped to an organization, except
+// GetByAuthTokenHash which is how a device's organization is discovered.
 type DeviceRepository interface {
 	Create(ctx context.Context, device *Device) error
-	GetByID(ctx context.Context, id uuid.UUID) (*Device, error)
-	GetByIdentityHash(ctx context.Context, hash string) (*Device, error)
-	List(ctx context.Context, filter DeviceFilter) ([]*Device, int, error)
-	UpdateStatus(ctx context.Context, id uuid.UUID, status DeviceStatus) error
-	UpdateAuthToken(ctx context.Context, id uuid.UUID, tokenHash string) error
-	UpdateInventory(ctx context.Context, id uuid.UUID, inventory map[string]interface{}) error
-	UpdateTags(ctx context.Context, id uuid.UUID, tags []string) error
-	UpdateLastCheckIn(ctx context.Context, id uuid.UUID) error
-	Delete(ctx context.Context, id uuid.UUID) error
-	CountByStatus(ctx context.Context) (map[DeviceStatus]int, error)
+	GetByID(ctx context.Context, orgID, id uuid.UUID) (*Device, error)
+	GetByIdentityHash(ctx context.Context, orgID uuid.UUID, hash string) (*Device, error)
+	GetByAuthTokenHash(ctx context.Context, tokenHash string) (*Device, error)
+	List(ctx context.Context, orgID uuid.UUID, filter DeviceFilter) ([]*Device, int, error)
+	UpdateStatus(ctx context.Context, orgID, id uuid.UUID, status DeviceStatus) error
+	UpdateAuthToken(ctx context.Context, orgID, id uuid.UUID, tokenHash string) error
+	UpdateInventory(ctx context.Context, orgID, id uuid.UUID, inventory map[string]interface{}) error
+	UpdateTags(ctx context.Context, orgID, id uuid.UUID, tags []string) error
+	UpdateEncryptionKey(ctx context.Context, orgID, id uuid.UUID, key string) error
+	UpdateLastCheckIn(ctx context.Context, orgID, id uuid.UUID) error
+	Delete(ctx context.Context, orgID, id uuid.UUID) error
+	CountByStatus(ctx context.Context, orgID uuid.UUID) (map[DeviceStatus]int, error)
 }
@@ -12,4 +12,10 @@ var (
 	ErrInvalidInput     = errors.New("invalid input")
 	ErrArtifactInUse    = errors.New("artifact is referenced by active deployments")
 	ErrDeploymentActive = errors.New("deployment is already active")
+	ErrTOTPRequired     = errors.New("two-factor code required")
+	ErrTOTPEnrolment    = errors.New("two-factor enrolment required")
+	ErrTOTPLocked       = errors.New("too many failed two-factor attempts")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	ErrNoEncryptionKey  = errors.New("device has no encryption key registered")
+	ErrQuotaExceeded    = errors.New("storage quota exceeded")
 )
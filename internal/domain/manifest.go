@@ -0,0 +1,65 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Manifest declares the artifacts that devices with all of DeviceTags should
+// have installed. Devices converge to it through a deployment per artifact,
+// which a device is added to when its report differs from the artifact.
+type Manifest struct {
+	ID          uuid.UUID      `json:"id"`
+	OrgID       uuid.UUID      `json:"organization_id"`
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	DeviceTags  []string       `json:"device_tags"`
+	Items       []ManifestItem `json:"items"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+}
+
+// ManifestItem is an artifact of a manifest and the deployment its devices
+// install it through.
+type ManifestItem struct {
+	ArtifactID   uuid.UUID  `json:"artifact_id"`
+	DeploymentID *uuid.UUID `json:"deployment_id,omitempty"`
+}
+
+// DesiredItem is, for a device, an artifact of a manifest it belongs to and
+// whether its report matches it. A device that does not match installs it
+// through DeploymentDeviceID, as any deployment.
+type DesiredItem struct {
+	ManifestID         uuid.UUID              `json:"manifest_id"`
+	ArtifactID         uuid.UUID              `json:"artifact_id"`
+	ArtifactName       string                 `json:"artifact_name"`
+	Version            string                 `json:"version"`
+	TargetPath         string                 `json:"target_path"`
+	InSync             bool                   `json:"in_sync"`
+	DeploymentDeviceID *uuid.UUID             `json:"deployment_device_id,omitempty"`
+	Status             DeploymentDeviceStatus `json:"status,omitempty"`
+}
+
+// ManifestConvergence is how many of the devices of a manifest have all of
+// its artifacts installed.
+type ManifestConvergence struct {
+	ManifestID uuid.UUID `json:"manifest_id"`
+	Devices    int       `json:"devices"`
+	Converged  int       `json:"converged"`
+	Percentage float64   `json:"percentage"`
+}
+
+type ManifestRepository interface {
+	// Create stores a manifest and its items. It fails with ErrConflict if
+	// the organization has a manifest with the same name.
+	Create(ctx context.Context, manifest *Manifest) error
+	GetByID(ctx context.Context, orgID, id uuid.UUID) (*Manifest, error)
+	List(ctx context.Context, orgID uuid.UUID) ([]*Manifest, error)
+	// ListByTags returns the manifests whose device tags are all in tags.
+	ListByTags(ctx context.Context, orgID uuid.UUID, tags []string) ([]*Manifest, error)
+	// SetItems replaces the items of a manifest.
+	SetItems(ctx context.Context, orgID, id uuid.UUID, items []ManifestItem) error
+	Delete(ctx context.Context, orgID, id uuid.UUID) error
+}
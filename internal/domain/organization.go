@@ -0,0 +1,39 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultOrganizationID is the organization created by the multi-tenancy
+// migration. Data that existed before organizations were introduced belongs
+// to it, and devices that authenticate without a tenant token join it.
+var DefaultOrganizationID = uuid.MustParse("00000000-0000-0000-0000-000000000001")
+
+// Organization is a tenant. Devices, artifacts, deployments and audit
+// entries belong to exactly one organization.
+type Organization struct {
+	ID              uuid.UUID `json:"id"`
+	Name            string    `json:"name"`
+	Slug            string    `json:"slug"`
+	TenantTokenHash string    `json:"-"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+type OrganizationRepository interface {
+	Create(ctx context.Context, org *Organization) error
+	GetByID(ctx context.Context, id uuid.UUID) (*Organization, error)
+	GetByTenantTokenHash(ctx context.Context, hash string) (*Organization, error)
+	List(ctx context.Context) ([]*Organization, error)
+	ListForUser(ctx context.Context, userID uuid.UUID) ([]*Organization, error)
+	UpdateTenantToken(ctx context.Context, id uuid.UUID, tokenHash string) error
+
+	// Membership of management users
+	AddMember(ctx context.Context, orgID, userID uuid.UUID) error
+	RemoveMember(ctx context.Context, orgID, userID uuid.UUID) error
+	ListMembers(ctx context.Context, orgID uuid.UUID) ([]*User, error)
+	IsMember(ctx context.Context, orgID, userID uuid.UUID) (bool, error)
+}
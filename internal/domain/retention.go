@@ -0,0 +1,30 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RetentionCandidate is an artifact the retention policy deletes, together
+// with its finished deployments, or would delete on a dry run.
+type RetentionCandidate struct {
+	OrgID      uuid.UUID `json:"organization_id"`
+	ArtifactID uuid.UUID `json:"artifact_id"`
+	Name       string    `json:"name"`
+	Version    string    `json:"version"`
+	FileSize   int64     `json:"file_size"`
+}
+
+// RetentionReport is the outcome of one pass of the retention policy.
+// FreedBytes is an upper bound: files shared with other artifacts stay in
+// storage.
+type RetentionReport struct {
+	DryRun     bool                  `json:"dry_run"`
+	StartedAt  time.Time             `json:"started_at"`
+	FinishedAt time.Time             `json:"finished_at"`
+	Deleted    []*RetentionCandidate `json:"deleted"`
+	FreedBytes int64                 `json:"freed_bytes"`
+	UsedBytes  int64                 `json:"used_bytes"`
+	QuotaBytes int64                 `json:"quota_bytes"`
+}
@@ -0,0 +1,61 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ScrubFindingKind string
+
+const (
+	// ScrubChecksumMismatch is a blob whose content no longer hashes to its
+	// checksum. Its artifacts are quarantined.
+	ScrubChecksumMismatch ScrubFindingKind = "checksum_mismatch"
+	// ScrubMissingFile is a blob whose file is gone from storage. Its
+	// artifacts are quarantined.
+	ScrubMissingFile ScrubFindingKind = "missing_file"
+	// ScrubUnreadable is a blob that could not be read for another reason,
+	// such as the store being unreachable. Nothing is quarantined.
+	ScrubUnreadable ScrubFindingKind = "unreadable"
+	// ScrubOrphanFile is a file in storage that no record points to. It is
+	// reported only; removing it is left to an operator.
+	ScrubOrphanFile ScrubFindingKind = "orphan_file"
+	// ScrubRestored is a quarantined blob that verified again, for example
+	// after its file was restored from a backup. Its artifacts are released.
+	ScrubRestored ScrubFindingKind = "restored"
+)
+
+type ScrubFinding struct {
+	Kind           ScrubFindingKind `json:"kind"`
+	OrgID          *uuid.UUID       `json:"organization_id,omitempty"`
+	ChecksumSHA256 string           `json:"checksum_sha256,omitempty"`
+	ActualChecksum string           `json:"actual_checksum_sha256,omitempty"`
+	StoragePath    string           `json:"storage_path"`
+	Size           int64            `json:"size"`
+	ArtifactIDs    []uuid.UUID      `json:"artifact_ids,omitempty"`
+	Detail         string           `json:"detail,omitempty"`
+}
+
+// ScrubReport is the outcome of one pass of the storage scrubber.
+type ScrubReport struct {
+	ID           uuid.UUID      `json:"id"`
+	StartedAt    time.Time      `json:"started_at"`
+	FinishedAt   time.Time      `json:"finished_at"`
+	BlobsChecked int            `json:"blobs_checked"`
+	BytesChecked int64          `json:"bytes_checked"`
+	FilesListed  int            `json:"files_listed"`
+	Findings     []ScrubFinding `json:"findings"`
+}
+
+type ScrubRepository interface {
+	// CreateReport stores a report. Only the most recent reports are kept.
+	CreateReport(ctx context.Context, report *ScrubReport) error
+	// LatestReport returns the newest report, or ErrNotFound before the
+	// first scrub.
+	LatestReport(ctx context.Context) (*ScrubReport, error)
+	// ReferencedPaths returns every storage path a record points to:
+	// blobs, artifacts, deltas and upload chunks of all organizations.
+	ReferencedPaths(ctx context.Context) ([]string, error)
+}
@@ -0,0 +1,37 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RefreshToken is a server-side record of an opaque management refresh token.
+// Tokens are rotated on every use; all tokens descending from the same login
+// share a FamilyID so that replaying a rotated token revokes the whole chain.
+type RefreshToken struct {
+	ID         uuid.UUID  `json:"id"`
+	UserID     string     `json:"user_id"`
+	FamilyID   uuid.UUID  `json:"family_id"`
+	MFA        bool       `json:"mfa"`
+	TokenHash  string     `json:"-"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	ReplacedBy *uuid.UUID `json:"replaced_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type TokenRepository interface {
+	CreateRefreshToken(ctx context.Context, token *RefreshToken) error
+	GetRefreshTokenByHash(ctx context.Context, hash string) (*RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, oldID uuid.UUID, next *RefreshToken) error
+	RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error
+	RevokeUserRefreshTokens(ctx context.Context, userID string) error
+
+	// Access token denylist, keyed by the JWT jti claim
+	RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error)
+
+	DeleteExpired(ctx context.Context) (int, error)
+}
@@ -0,0 +1,28 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SigningKey is an Ed25519 public key trusted to sign an organization's
+// artifacts. KeyID is the key fingerprint and is what artifacts reference.
+type SigningKey struct {
+	ID        uuid.UUID  `json:"id"`
+	OrgID     uuid.UUID  `json:"organization_id"`
+	KeyID     string     `json:"key_id"`
+	Name      string     `json:"name"`
+	PublicKey string     `json:"public_key"`
+	Server    bool       `json:"server,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type SigningKeyRepository interface {
+	Create(ctx context.Context, key *SigningKey) error
+	GetByKeyID(ctx context.Context, orgID uuid.UUID, keyID string) (*SigningKey, error)
+	List(ctx context.Context, orgID uuid.UUID) ([]*SigningKey, error)
+	Revoke(ctx context.Context, orgID, id uuid.UUID) error
+}
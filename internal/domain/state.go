@@ -0,0 +1,64 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Installation is a successful deployment of an artifact to a device.
+type Installation struct {
+	DeviceID     uuid.UUID
+	DeploymentID uuid.UUID
+	ArtifactID   uuid.UUID
+	InstalledAt  time.Time
+}
+
+// DesiredFile is what the deployment history says a target path of a device
+// holds. ChecksumSHA256 is empty when the content cannot be compared with
+// the device's report, as for archives and templates.
+type DesiredFile struct {
+	ArtifactID     uuid.UUID `json:"artifact_id"`
+	ArtifactName   string    `json:"artifact_name"`
+	Version        string    `json:"version"`
+	ChecksumSHA256 string    `json:"checksum_sha256,omitempty"`
+	DeploymentID   uuid.UUID `json:"deployment_id"`
+	InstalledAt    time.Time `json:"installed_at"`
+}
+
+type DriftStatus string
+
+const (
+	DriftInSync DriftStatus = "in_sync"
+	// DriftModified files have the desired version but another checksum
+	DriftModified DriftStatus = "modified"
+	// DriftOutdated files have another version than the desired one
+	DriftOutdated DriftStatus = "outdated"
+	// DriftMissing paths are not reported by the device
+	DriftMissing DriftStatus = "missing"
+	// DriftUnmanaged paths are reported but no deployment installed them
+	DriftUnmanaged DriftStatus = "unmanaged"
+)
+
+// Drifted reports whether the status means the device differs from what it
+// was deployed.
+func (s DriftStatus) Drifted() bool {
+	return s == DriftModified || s == DriftOutdated || s == DriftMissing
+}
+
+// FileState compares the desired and the reported content of a target path.
+type FileState struct {
+	TargetPath string         `json:"target_path"`
+	Desired    *DesiredFile   `json:"desired,omitempty"`
+	Actual     *InstalledFile `json:"actual,omitempty"`
+	Status     DriftStatus    `json:"status"`
+}
+
+// DeviceState is the desired-versus-actual view of the managed files of a
+// device, by target path.
+type DeviceState struct {
+	DeviceID   uuid.UUID    `json:"device_id"`
+	DeviceType string       `json:"device_type"`
+	Drifted    bool         `json:"drifted"`
+	Files      []*FileState `json:"files"`
+}
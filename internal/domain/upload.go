@@ -0,0 +1,69 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UploadMetadata describes the artifact an upload session will create.
+type UploadMetadata struct {
+	// Kind is file (the default) or archive; bundles are not uploaded in parts
+	Kind             ArtifactKind  `json:"kind,omitempty"`
+	Name             string        `json:"name"`
+	Version          string        `json:"version"`
+	Description      string        `json:"description,omitempty"`
+	FileName         string        `json:"file_name"`
+	TargetPath       string        `json:"target_path"`
+	FileMode         string        `json:"file_mode,omitempty"`
+	FileOwner        string        `json:"file_owner,omitempty"`
+	DeviceTypes      []string      `json:"device_types"`
+	PreInstallCmd    string        `json:"pre_install_cmd,omitempty"`
+	PostInstallCmd   string        `json:"post_install_cmd,omitempty"`
+	RollbackCmd      string        `json:"rollback_cmd,omitempty"`
+	Signature        string        `json:"signature,omitempty"`
+	SigningKeyID     string        `json:"signing_key_id,omitempty"`
+	EncryptForDevice bool          `json:"encrypt_for_device,omitempty"`
+	Requirements     *Requirements `json:"requirements,omitempty"`
+}
+
+// UploadSession is a resumable artifact upload. The file is sent in chunks
+// written at Offset until it reaches Size, then the session is completed into
+// an artifact. Sessions without activity expire at ExpiresAt.
+type UploadSession struct {
+	ID             uuid.UUID      `json:"id"`
+	OrgID          uuid.UUID      `json:"organization_id"`
+	Metadata       UploadMetadata `json:"metadata"`
+	Size           int64          `json:"size"`
+	Offset         int64          `json:"offset"`
+	ChecksumSHA256 string         `json:"checksum_sha256,omitempty"`
+	ExpiresAt      time.Time      `json:"expires_at"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+}
+
+// UploadChunk is a stored piece of an upload session's file.
+type UploadChunk struct {
+	SessionID      uuid.UUID
+	Offset         int64
+	Size           int64
+	ChecksumSHA256 string
+	StoragePath    string
+}
+
+type UploadSessionRepository interface {
+	Create(ctx context.Context, session *UploadSession) error
+	GetByID(ctx context.Context, orgID, id uuid.UUID) (*UploadSession, error)
+	// AddChunk records a chunk written at chunk.Offset, advances the session
+	// offset past it and extends the expiry. It fails with ErrConflict if the
+	// session offset is no longer chunk.Offset.
+	AddChunk(ctx context.Context, orgID uuid.UUID, chunk *UploadChunk, expiresAt time.Time) error
+	ListChunks(ctx context.Context, sessionID uuid.UUID) ([]*UploadChunk, error)
+	Delete(ctx context.Context, orgID, id uuid.UUID) error
+	// ReservedAfter returns the declared size of the sessions of every
+	// organization created after session.
+	ReservedAfter(ctx context.Context, session *UploadSession) (int64, error)
+	// ListExpired returns sessions of every organization that expired before t.
+	ListExpired(ctx context.Context, t time.Time) ([]*UploadSession, error)
+}
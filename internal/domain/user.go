@@ -0,0 +1,86 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Role string
+
+const (
+	RoleAdmin    Role = "admin"    // everything, including user and policy management
+	RoleOperator Role = "operator" // manage devices, artifacts and deployments
+	RoleViewer   Role = "viewer"   // read-only
+)
+
+func (r Role) Valid() bool {
+	switch r {
+	case RoleAdmin, RoleOperator, RoleViewer:
+		return true
+	}
+	return false
+}
+
+// CanCreateDeployments reports whether the role may push artifacts to devices.
+func (r Role) CanCreateDeployments() bool {
+	return r == RoleAdmin || r == RoleOperator
+}
+
+// User is a management (frontend/API) user.
+type User struct {
+	ID                 uuid.UUID `json:"id"`
+	Email              string    `json:"email"`
+	PasswordHash       string    `json:"-"`
+	Role               Role      `json:"role"`
+	TOTPSecret         string    `json:"-"`
+	TOTPEnabled        bool      `json:"totp_enabled"`
+	TOTPLastStep       int64     `json:"-"`
+	RecoveryCodeHashes []string  `json:"-"`
+	// TOTPFailures counts the failed second-factor attempts since the last
+	// accepted one, the latest of which was at TOTPFailedAt.
+	TOTPFailures int        `json:"-"`
+	TOTPFailedAt *time.Time `json:"-"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// SecurityPolicy holds server-wide authentication settings managed by admins.
+type SecurityPolicy struct {
+	// RequireTOTPForDeployers forces every role that can create deployments
+	// to enrol in TOTP before using the management API.
+	RequireTOTPForDeployers bool `json:"require_totp_for_deployers"`
+}
+
+// RequiresTOTP reports whether the policy mandates a second factor for role.
+func (p SecurityPolicy) RequiresTOTP(role Role) bool {
+	return p.RequireTOTPForDeployers && role.CanCreateDeployments()
+}
+
+type UserRepository interface {
+	Create(ctx context.Context, user *User) error
+	GetByID(ctx context.Context, id uuid.UUID) (*User, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	List(ctx context.Context) ([]*User, error)
+	Count(ctx context.Context) (int, error)
+	UpdateRole(ctx context.Context, id uuid.UUID, role Role) error
+	UpdatePassword(ctx context.Context, id uuid.UUID, passwordHash string) error
+	UpdateTOTP(ctx context.Context, id uuid.UUID, secret string, enabled bool, recoveryCodeHashes []string) error
+	// AdvanceTOTPStep records step as the last accepted TOTP step and returns
+	// false if a code for this or a later step was already used.
+	AdvanceTOTPStep(ctx context.Context, id uuid.UUID, step int64) (bool, error)
+	// ConsumeRecoveryCode removes codeHash from the user's recovery codes and
+	// returns false if it was not present.
+	ConsumeRecoveryCode(ctx context.Context, id uuid.UUID, codeHash string) (bool, error)
+	// RecordTOTPFailure counts a failed second-factor attempt.
+	RecordTOTPFailure(ctx context.Context, id uuid.UUID) error
+	// ResetTOTPFailures clears the failed second-factor attempts.
+	ResetTOTPFailures(ctx context.Context, id uuid.UUID) error
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+type SettingsRepository interface {
+	GetSecurityPolicy(ctx context.Context) (*SecurityPolicy, error)
+	UpdateSecurityPolicy(ctx context.Context, policy *SecurityPolicy) error
+}
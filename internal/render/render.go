@@ -0,0 +1,94 @@
+// Package render renders template artifacts: Go text/template files that the
+// server turns into a different config file for each device.
+//
+// Templates see the device as .ID, .DeviceType, .Tags, .Identity and
+// .Inventory, and the variables of the deployment as .Vars. Referencing a
+// missing key with a field, as in {{ .Vars.site }}, fails the rendering; use
+// {{ index .Vars "site" | default "main" }} for optional values.
+package render
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+const (
+	// MaxTemplateSize bounds the size of an uploaded template
+	MaxTemplateSize = 1 << 20
+	// MaxOutputSize bounds the size of a rendered file
+	MaxOutputSize = 16 << 20
+)
+
+// ErrInvalid is wrapped by every error about the content of a template or
+// the data it is rendered with.
+var ErrInvalid = errors.New("invalid template")
+
+// Data is what a template is rendered with.
+type Data struct {
+	ID         string
+	DeviceType string
+	Tags       []string
+	Identity   map[string]string
+	Inventory  map[string]interface{}
+	Vars       map[string]string
+}
+
+var funcs = template.FuncMap{
+	"hasTag": func(tags []string, tag string) bool { return slices.Contains(tags, tag) },
+	"join":   func(sep string, elems []string) string { return strings.Join(elems, sep) },
+	// default returns value, or def if value is empty
+	"default": func(def, value interface{}) interface{} {
+		if value == nil || value == "" {
+			return def
+		}
+		return value
+	},
+}
+
+// Parse checks that src is a valid template.
+func Parse(src []byte) (*template.Template, error) {
+	if len(src) > MaxTemplateSize {
+		return nil, fmt.Errorf("%w: larger than %d bytes", ErrInvalid, MaxTemplateSize)
+	}
+	t, err := template.New("artifact").Option("missingkey=error").Funcs(funcs).Parse(string(src))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return t, nil
+}
+
+// Render renders the template src with data.
+func Render(src []byte, data Data) ([]byte, error) {
+	t, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	out := &limitedBuffer{max: MaxOutputSize}
+	if err := t.Execute(out, data); err != nil {
+		if errors.Is(err, errTooLarge) {
+			return nil, fmt.Errorf("%w: rendered file larger than %d bytes", ErrInvalid, MaxOutputSize)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return out.Bytes(), nil
+}
+
+var errTooLarge = errors.New("output too large")
+
+// limitedBuffer stops a template that loops over large data from using
+// unbounded memory.
+type limitedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.max {
+		return 0, errTooLarge
+	}
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,53 @@
+package render
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+var data = Data{
+	ID:         "4b6d6c1e-0000-0000-0000-000000000001",
+	DeviceType: "gateway",
+	Tags:       []string{"prod", "eu"},
+	Identity:   map[string]string{"mac": "aa:bb:cc"},
+	Inventory:  map[string]interface{}{"os": "linux", "cpus": 4},
+	Vars:       map[string]string{"site": "lisbon"},
+}
+
+func TestRender(t *testing.T) {
+	src := `host={{ .Identity.mac }}-{{ .Vars.site }}
+type={{ .DeviceType }} os={{ .Inventory.os }} cpus={{ .Inventory.cpus }}
+tags={{ join "," .Tags }}{{ if hasTag .Tags "prod" }} prod{{ end }}
+level={{ index .Vars "level" | default "info" }}
+`
+	out, err := Render([]byte(src), data)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	want := `host=aa:bb:cc-lisbon
+type=gateway os=linux cpus=4
+tags=prod,eu prod
+level=info
+`
+	if string(out) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestRender_Errors(t *testing.T) {
+	cases := map[string]string{
+		"parse error":      "{{ .Vars.site ",
+		"unknown func":     "{{ upper .Vars.site }}",
+		"missing key":      "{{ .Vars.region }}",
+		"missing field":    "{{ .Hostname }}",
+		"template too big": strings.Repeat("x", MaxTemplateSize+1),
+		"rendered too big": `{{ define "a" }}` + strings.Repeat("x", 1<<16) + `{{ end }}` +
+			strings.Repeat(`{{ template "a" }}`, MaxOutputSize>>16+1),
+	}
+	for name, src := range cases {
+		if _, err := Render([]byte(src), data); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: expected ErrInvalid, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+type ArtifactDeltaRepo struct {
+	pool *pgxpool.Pool
+}
+
+func NewArtifactDeltaRepo(pool *pgxpool.Pool) *ArtifactDeltaRepo {
+	return &ArtifactDeltaRepo{pool: pool}
+}
+
+const artifactDeltaColumns = `id, organization_id, artifact_id, source_artifact_id, source_version,
+	source_checksum_sha256, file_size, checksum_sha256, storage_path, created_at`
+
+func scanArtifactDelta(row pgx.Row) (*domain.ArtifactDelta, error) {
+	d := &domain.ArtifactDelta{}
+	if err := row.Scan(
+		&d.ID, &d.OrgID, &d.ArtifactID, &d.SourceArtifactID, &d.SourceVersion,
+		&d.SourceChecksum, &d.FileSize, &d.ChecksumSHA256, &d.StoragePath, &d.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (r *ArtifactDeltaRepo) Create(ctx context.Context, d *domain.ArtifactDelta) error {
+	err := r.pool.QueryRow(ctx, `
+		INSERT INTO artifact_deltas (organization_id, artifact_id, source_artifact_id, source_version,
+			source_checksum_sha256, file_size, checksum_sha256, storage_path)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at
+	`, d.OrgID, d.ArtifactID, d.SourceArtifactID, d.SourceVersion,
+		d.SourceChecksum, d.FileSize, d.ChecksumSHA256, d.StoragePath,
+	).Scan(&d.ID, &d.CreatedAt)
+
+	if err != nil {
+		if isUniqueViolation(err) {
+			return domain.ErrConflict
+		}
+		return fmt.Errorf("insert artifact delta: %w", err)
+	}
+	return nil
+}
+
+func (r *ArtifactDeltaRepo) Get(ctx context.Context, orgID, artifactID, sourceArtifactID uuid.UUID) (*domain.ArtifactDelta, error) {
+	d, err := scanArtifactDelta(r.pool.QueryRow(ctx, `
+		SELECT `+artifactDeltaColumns+` FROM artifact_deltas
+		WHERE organization_id = $1 AND artifact_id = $2 AND source_artifact_id = $3
+	`, orgID, artifactID, sourceArtifactID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, domain.ErrNotFound
+		}
+		return nil, fmt.Errorf("get artifact delta: %w", err)
+	}
+	return d, nil
+}
+
+func (r *ArtifactDeltaRepo) ListByArtifact(ctx context.Context, orgID, artifactID uuid.UUID) ([]*domain.ArtifactDelta, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+artifactDeltaColumns+` FROM artifact_deltas
+		WHERE organization_id = $1 AND (artifact_id = $2 OR source_artifact_id = $2)
+		ORDER BY created_at DESC
+	`, orgID, artifactID)
+	if err != nil {
+		return nil, fmt.Errorf("list artifact deltas: %w", err)
+	}
+	defer rows.Close()
+
+	deltas := []*domain.ArtifactDelta{}
+	for rows.Next() {
+		d, err := scanArtifactDelta(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan artifact delta: %w", err)
+		}
+		deltas = append(deltas, d)
+	}
+	return deltas, nil
+}
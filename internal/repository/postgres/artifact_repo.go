@@ -2,6 +2,8 @@ package postgres
 
 import (
 	"context"
+	"database/sql/driver"
+	"encoding/json"
 	"fmt"
 
 	"github.com/google/uuid"
@@ -9,6 +11,7 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/CaioWing/Harbor/internal/domain"
+	"github.com/CaioWing/Harbor/internal/semver"
 )
 
 type ArtifactRepo struct {
@@ -19,18 +22,236 @@ func NewArtifactRepo(pool *pgxpool.Pool) *ArtifactRepo {
 	return &ArtifactRepo{pool: pool}
 }
 
+// artifactColumns and artifactScanDest must be kept in the same order. The
+// artifacts table is aliased as "a" so that the list can be used in joins.
+const artifactColumns = `a.id, a.organization_id, a.kind, a.name, a.version, a.description, a.file_name,
+	a.file_size, a.checksum_sha256, a.target_path, a.source_path, a.file_mode, a.file_owner, a.device_types,
+	a.storage_path, a.pre_install_cmd, a.post_install_cmd, a.rollback_cmd,
+	a.signature, a.signing_key_id, a.encrypt_for_device, a.files, a.archive_format, a.entries, a.script,
+	a.requirements, a.validation, a.quarantined_at, a.quarantine_reason, a.created_at`
+
+func artifactScanDest(a *domain.Artifact) []interface{} {
+	return []interface{}{
+		&a.ID, &a.OrgID, &a.Kind, &a.Name, &a.Version, &a.Description, &a.FileName,
+		&a.FileSize, &a.ChecksumSHA256, &a.TargetPath, &a.SourcePath, &a.FileMode, &a.FileOwner, &a.DeviceTypes,
+		&a.StoragePath, &a.PreInstallCmd, &a.PostInstallCmd, &a.RollbackCmd,
+		&a.Signature, &a.SigningKeyID, &a.EncryptForDevice, artifactFiles{&a.Files}, &a.ArchiveFormat, archiveEntries{&a.Entries}, scriptSpec{&a.Script},
+		requirements{&a.Requirements}, validationResults{&a.Validation}, &a.QuarantinedAt, &a.QuarantineReason, &a.CreatedAt,
+	}
+}
+
+// artifactFiles stores the files of a bundle as JSON, storage paths
+// included.
+type artifactFiles struct {
+	files *[]domain.ArtifactFile
+}
+
+type artifactFileJSON struct {
+	FileName       string `json:"file_name"`
+	TargetPath     string `json:"target_path"`
+	FileMode       string `json:"file_mode"`
+	FileOwner      string `json:"file_owner"`
+	FileSize       int64  `json:"file_size"`
+	ChecksumSHA256 string `json:"checksum_sha256"`
+	StoragePath    string `json:"storage_path"`
+}
+
+func (f artifactFiles) Value() (driver.Value, error) {
+	rows := make([]artifactFileJSON, 0, len(*f.files))
+	for _, file := range *f.files {
+		rows = append(rows, artifactFileJSON(file))
+	}
+	b, err := json.Marshal(rows)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (f artifactFiles) Scan(src interface{}) error {
+	data, err := jsonBytes(src)
+	if err != nil {
+		return err
+	}
+	var rows []artifactFileJSON
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return fmt.Errorf("unmarshal artifact files: %w", err)
+	}
+	*f.files = nil
+	for _, row := range rows {
+		*f.files = append(*f.files, domain.ArtifactFile(row))
+	}
+	return nil
+}
+
+// jsonBytes returns a JSON column as read by pgx. A NULL reads as null.
+func jsonBytes(src interface{}) ([]byte, error) {
+	switch v := src.(type) {
+	case nil:
+		return []byte("null"), nil
+	case string:
+		return []byte(v), nil
+	case []byte:
+		return v, nil
+	default:
+		return nil, fmt.Errorf("scan json column: unexpected type %T", src)
+	}
+}
+
+// archiveEntries stores the entries of an archive as JSON.
+type archiveEntries struct {
+	entries *[]domain.ArchiveEntry
+}
+
+func (e archiveEntries) Value() (driver.Value, error) {
+	entries := *e.entries
+	if entries == nil {
+		entries = []domain.ArchiveEntry{}
+	}
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return nil, fmt.Errorf("marshal archive entries: %w", err)
+	}
+	return string(b), nil
+}
+
+func (e archiveEntries) Scan(src interface{}) error {
+	data, err := jsonBytes(src)
+	if err != nil {
+		return err
+	}
+	*e.entries = nil
+	if err := json.Unmarshal(data, e.entries); err != nil {
+		return fmt.Errorf("unmarshal archive entries: %w", err)
+	}
+	return nil
+}
+
+// scriptSpec stores how a script artifact is run as JSON, or NULL for other
+// artifacts.
+type scriptSpec struct {
+	spec **domain.ScriptSpec
+}
+
+func (s scriptSpec) Value() (driver.Value, error) {
+	if *s.spec == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(*s.spec)
+	if err != nil {
+		return nil, fmt.Errorf("marshal script: %w", err)
+	}
+	return string(b), nil
+}
+
+func (s scriptSpec) Scan(src interface{}) error {
+	*s.spec = nil
+	if src == nil {
+		return nil
+	}
+	data, err := jsonBytes(src)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, s.spec); err != nil {
+		return fmt.Errorf("unmarshal script: %w", err)
+	}
+	return nil
+}
+
+// requirements stores the requirements of an artifact as JSON, or NULL when
+// it has none.
+type requirements struct {
+	req **domain.Requirements
+}
+
+func (r requirements) Value() (driver.Value, error) {
+	if *r.req == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(*r.req)
+	if err != nil {
+		return nil, fmt.Errorf("marshal requirements: %w", err)
+	}
+	return string(b), nil
+}
+
+func (r requirements) Scan(src interface{}) error {
+	*r.req = nil
+	if src == nil {
+		return nil
+	}
+	data, err := jsonBytes(src)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, r.req); err != nil {
+		return fmt.Errorf("unmarshal requirements: %w", err)
+	}
+	return nil
+}
+
+// validationResults stores the upload check results of an artifact as JSON,
+// or NULL when no check ran.
+type validationResults struct {
+	results *[]domain.ValidationResult
+}
+
+func (v validationResults) Value() (driver.Value, error) {
+	if len(*v.results) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(*v.results)
+	if err != nil {
+		return nil, fmt.Errorf("marshal validation: %w", err)
+	}
+	return string(b), nil
+}
+
+func (v validationResults) Scan(src interface{}) error {
+	*v.results = nil
+	if src == nil {
+		return nil
+	}
+	data, err := jsonBytes(src)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v.results); err != nil {
+		return fmt.Errorf("unmarshal validation: %w", err)
+	}
+	return nil
+}
+
+// versionKey returns the sort key of a semantic version, or nil.
+func versionKey(version string) *string {
+	v, err := semver.Parse(version)
+	if err != nil {
+		return nil
+	}
+	key := v.Key()
+	return &key
+}
+
 func (r *ArtifactRepo) Create(ctx context.Context, a *domain.Artifact) error {
+	kind := a.Kind
+	if kind == "" {
+		kind = domain.ArtifactKindFile
+	}
 	err := r.pool.QueryRow(ctx, `
 		INSERT INTO artifacts (
-			name, version, description, file_name, file_size, checksum_sha256,
+			organization_id, kind, name, version, description, file_name, file_size, checksum_sha256,
 			target_path, file_mode, file_owner, device_types, storage_path,
-			pre_install_cmd, post_install_cmd, rollback_cmd
-		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14)
+			pre_install_cmd, post_install_cmd, rollback_cmd, signature, signing_key_id, encrypt_for_device, files,
+			archive_format, entries, source_path, script, version_key, requirements, validation
+		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22,$23,$24,$25,$26,$27)
 		RETURNING id, created_at
 	`,
-		a.Name, a.Version, a.Description, a.FileName, a.FileSize, a.ChecksumSHA256,
+		a.OrgID, kind, a.Name, a.Version, a.Description, a.FileName, a.FileSize, a.ChecksumSHA256,
 		a.TargetPath, a.FileMode, a.FileOwner, a.DeviceTypes, a.StoragePath,
-		a.PreInstallCmd, a.PostInstallCmd, a.RollbackCmd,
+		a.PreInstallCmd, a.PostInstallCmd, a.RollbackCmd, a.Signature, a.SigningKeyID, a.EncryptForDevice,
+		artifactFiles{&a.Files}, a.ArchiveFormat, archiveEntries{&a.Entries}, a.SourcePath, scriptSpec{&a.Script},
+		versionKey(a.Version), requirements{&a.Requirements}, validationResults{&a.Validation},
 	).Scan(&a.ID, &a.CreatedAt)
 
 	if err != nil {
@@ -42,18 +263,12 @@ func (r *ArtifactRepo) Create(ctx context.Context, a *domain.Artifact) error {
 	return nil
 }
 
-func (r *ArtifactRepo) GetByID(ctx context.Context, id uuid.UUID) (*domain.Artifact, error) {
+func (r *ArtifactRepo) GetByID(ctx context.Context, orgID, id uuid.UUID) (*domain.Artifact, error) {
 	a := &domain.Artifact{}
 	err := r.pool.QueryRow(ctx, `
-		SELECT id, name, version, description, file_name, file_size, checksum_sha256,
-		       target_path, file_mode, file_owner, device_types, storage_path,
-		       pre_install_cmd, post_install_cmd, rollback_cmd, created_at
-		FROM artifacts WHERE id = $1
-	`, id).Scan(
-		&a.ID, &a.Name, &a.Version, &a.Description, &a.FileName, &a.FileSize,
-		&a.ChecksumSHA256, &a.TargetPath, &a.FileMode, &a.FileOwner, &a.DeviceTypes,
-		&a.StoragePath, &a.PreInstallCmd, &a.PostInstallCmd, &a.RollbackCmd, &a.CreatedAt,
-	)
+		SELECT `+artifactColumns+`
+		FROM artifacts a WHERE a.organization_id = $1 AND a.id = $2
+	`, orgID, id).Scan(artifactScanDest(a)...)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, domain.ErrNotFound
@@ -63,7 +278,7 @@ func (r *ArtifactRepo) GetByID(ctx context.Context, id uuid.UUID) (*domain.Artif
 	return a, nil
 }
 
-func (r *ArtifactRepo) List(ctx context.Context, f domain.ArtifactFilter) ([]*domain.Artifact, int, error) {
+func (r *ArtifactRepo) List(ctx context.Context, orgID uuid.UUID, f domain.ArtifactFilter) ([]*domain.Artifact, int, error) {
 	if f.Page < 1 {
 		f.Page = 1
 	}
@@ -77,9 +292,9 @@ func (r *ArtifactRepo) List(ctx context.Context, f domain.ArtifactFilter) ([]*do
 		f.SortOrder = "desc"
 	}
 
-	where := "WHERE 1=1"
-	args := []interface{}{}
-	argIdx := 1
+	where := "WHERE organization_id = $1"
+	args := []interface{}{orgID}
+	argIdx := 2
 
 	if f.Name != nil {
 		where += fmt.Sprintf(" AND name ILIKE $%d", argIdx)
@@ -91,6 +306,13 @@ func (r *ArtifactRepo) List(ctx context.Context, f domain.ArtifactFilter) ([]*do
 		args = append(args, *f.DeviceType)
 		argIdx++
 	}
+	if f.Quarantined != nil {
+		if *f.Quarantined {
+			where += " AND quarantined_at IS NOT NULL"
+		} else {
+			where += " AND quarantined_at IS NULL"
+		}
+	}
 
 	var total int
 	if err := r.pool.QueryRow(ctx, "SELECT COUNT(*) FROM artifacts "+where, args...).Scan(&total); err != nil {
@@ -99,23 +321,32 @@ func (r *ArtifactRepo) List(ctx context.Context, f domain.ArtifactFilter) ([]*do
 
 	orderCol := "created_at"
 	switch f.SortBy {
-	case "created_at", "name", "version", "file_size":
+	case "created_at", "name", "file_size":
 		orderCol = f.SortBy
+	case "version":
+		// Semantic version order; versions without a key are the oldest
+		orderCol = "version_key"
 	}
 	orderDir := "DESC"
 	if f.SortOrder == "asc" {
 		orderDir = "ASC"
 	}
+	order := orderCol + " " + orderDir
+	if orderCol == "version_key" {
+		if orderDir == "ASC" {
+			order += " NULLS FIRST, created_at ASC"
+		} else {
+			order += " NULLS LAST, created_at DESC"
+		}
+	}
 
 	offset := (f.Page - 1) * f.PerPage
 	query := fmt.Sprintf(`
-		SELECT id, name, version, description, file_name, file_size, checksum_sha256,
-		       target_path, file_mode, file_owner, device_types, storage_path,
-		       pre_install_cmd, post_install_cmd, rollback_cmd, created_at
-		FROM artifacts %s
-		ORDER BY %s %s
+		SELECT `+artifactColumns+`
+		FROM artifacts a %s
+		ORDER BY %s
 		LIMIT $%d OFFSET $%d
-	`, where, orderCol, orderDir, argIdx, argIdx+1)
+	`, where, order, argIdx, argIdx+1)
 	args = append(args, f.PerPage, offset)
 
 	rows, err := r.pool.Query(ctx, query, args...)
@@ -127,11 +358,7 @@ func (r *ArtifactRepo) List(ctx context.Context, f domain.ArtifactFilter) ([]*do
 	var artifacts []*domain.Artifact
 	for rows.Next() {
 		a := &domain.Artifact{}
-		if err := rows.Scan(
-			&a.ID, &a.Name, &a.Version, &a.Description, &a.FileName, &a.FileSize,
-			&a.ChecksumSHA256, &a.TargetPath, &a.FileMode, &a.FileOwner, &a.DeviceTypes,
-			&a.StoragePath, &a.PreInstallCmd, &a.PostInstallCmd, &a.RollbackCmd, &a.CreatedAt,
-		); err != nil {
+		if err := rows.Scan(artifactScanDest(a)...); err != nil {
 			return nil, 0, fmt.Errorf("scan artifact: %w", err)
 		}
 		artifacts = append(artifacts, a)
@@ -144,9 +371,76 @@ func (r *ArtifactRepo) List(ctx context.Context, f domain.ArtifactFilter) ([]*do
 	return artifacts, total, nil
 }
 
-func (r *ArtifactRepo) Delete(ctx context.Context, id uuid.UUID) error {
-	tag, err := r.pool.Exec(ctx, `DELETE FROM artifacts WHERE id = $1`, id)
+func (r *ArtifactRepo) ListByName(ctx context.Context, orgID uuid.UUID, name string) ([]*domain.Artifact, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+artifactColumns+`
+		FROM artifacts a WHERE a.organization_id = $1 AND a.name = $2
+		ORDER BY a.created_at DESC
+	`, orgID, name)
+	if err != nil {
+		return nil, fmt.Errorf("list artifact versions: %w", err)
+	}
+	defer rows.Close()
+
+	artifacts := []*domain.Artifact{}
+	for rows.Next() {
+		a := &domain.Artifact{}
+		if err := rows.Scan(artifactScanDest(a)...); err != nil {
+			return nil, fmt.Errorf("scan artifact: %w", err)
+		}
+		artifacts = append(artifacts, a)
+	}
+	return artifacts, nil
+}
+
+func (r *ArtifactRepo) ListLatest(ctx context.Context, orgID uuid.UUID, f domain.LatestArtifactFilter) ([]*domain.LatestArtifact, error) {
+	where := `WHERE a.organization_id = $1 AND a.version_key IS NOT NULL AND a.quarantined_at IS NULL
+		AND NOT COALESCE(a.validation @> '[{"blocking": true}]', false)`
+	args := []interface{}{orgID}
+	argIdx := 2
+
+	if f.Name != nil {
+		where += fmt.Sprintf(" AND a.name = $%d", argIdx)
+		args = append(args, *f.Name)
+		argIdx++
+	}
+	if f.DeviceType != nil {
+		where += fmt.Sprintf(" AND dt = $%d", argIdx)
+		args = append(args, *f.DeviceType)
+	}
+	if !f.Prerelease {
+		// Release keys end in "~", prerelease keys do not (see semver.Key)
+		where += " AND right(a.version_key, 1) = '~'"
+	}
+
+	rows, err := r.pool.Query(ctx, `
+		SELECT DISTINCT ON (a.name, dt) dt, `+artifactColumns+`
+		FROM artifacts a, unnest(a.device_types) AS dt `+where+`
+		ORDER BY a.name, dt, a.version_key DESC, a.created_at DESC
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list latest artifacts: %w", err)
+	}
+	defer rows.Close()
+
+	latest := []*domain.LatestArtifact{}
+	for rows.Next() {
+		l := &domain.LatestArtifact{Artifact: &domain.Artifact{}}
+		if err := rows.Scan(append([]interface{}{&l.DeviceType}, artifactScanDest(l.Artifact)...)...); err != nil {
+			return nil, fmt.Errorf("scan artifact: %w", err)
+		}
+		l.Name = l.Artifact.Name
+		latest = append(latest, l)
+	}
+	return latest, rows.Err()
+}
+
+func (r *ArtifactRepo) Delete(ctx context.Context, orgID, id uuid.UUID) error {
+	tag, err := r.pool.Exec(ctx, `DELETE FROM artifacts WHERE organization_id = $1 AND id = $2`, orgID, id)
 	if err != nil {
+		if isForeignKeyViolation(err) {
+			return fmt.Errorf("%w: artifact is used by a deployment, manifest or channel", domain.ErrConflict)
+		}
 		return fmt.Errorf("delete artifact: %w", err)
 	}
 	if tag.RowsAffected() == 0 {
@@ -154,3 +448,114 @@ func (r *ArtifactRepo) Delete(ctx context.Context, id uuid.UUID) error {
 	}
 	return nil
 }
+
+func (r *ArtifactRepo) DeleteWithDeployments(ctx context.Context, orgID, id uuid.UUID) (int, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// The lock makes deployments, manifests and channels that start using
+	// the artifact wait for the delete, and then fail their key check
+	var locked uuid.UUID
+	err = tx.QueryRow(ctx, `
+		SELECT id FROM artifacts WHERE organization_id = $1 AND id = $2 FOR UPDATE
+	`, orgID, id).Scan(&locked)
+	if err == pgx.ErrNoRows {
+		return 0, domain.ErrNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("lock artifact: %w", err)
+	}
+
+	var used bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM deployments
+			WHERE organization_id = $1 AND artifact_id = $2 AND status NOT IN ('completed', 'cancelled')
+		) OR EXISTS (
+			SELECT 1 FROM channels WHERE organization_id = $1 AND artifact_id = $2
+		) OR EXISTS (
+			SELECT 1 FROM channel_promotions WHERE organization_id = $1 AND artifact_id = $2
+		) OR EXISTS (
+			SELECT 1 FROM manifest_items WHERE artifact_id = $2
+		)
+	`, orgID, id).Scan(&used)
+	if err != nil {
+		return 0, fmt.Errorf("check artifact references: %w", err)
+	}
+	if used {
+		return 0, fmt.Errorf("%w: artifact is used by an unfinished deployment, a manifest or a channel", domain.ErrConflict)
+	}
+
+	deployments, err := tx.Exec(ctx, `
+		DELETE FROM deployments
+		WHERE organization_id = $1 AND artifact_id = $2 AND status IN ('completed', 'cancelled')
+	`, orgID, id)
+	if err != nil {
+		return 0, fmt.Errorf("delete deployments: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM artifacts WHERE organization_id = $1 AND id = $2`, orgID, id); err != nil {
+		if isForeignKeyViolation(err) {
+			return 0, fmt.Errorf("%w: artifact is used by a deployment, manifest or channel", domain.ErrConflict)
+		}
+		return 0, fmt.Errorf("delete artifact: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("commit: %w", err)
+	}
+	return int(deployments.RowsAffected()), nil
+}
+
+func (r *ArtifactRepo) SetQuarantine(ctx context.Context, orgID uuid.UUID, checksum, reason string) ([]uuid.UUID, error) {
+	// Quarantining again keeps the time the problem was first seen
+	rows, err := r.pool.Query(ctx, `
+		UPDATE artifacts SET
+			quarantined_at = COALESCE(quarantined_at, NOW()),
+			quarantine_reason = $3
+		WHERE organization_id = $1
+		  AND (checksum_sha256 = $2 OR files @> jsonb_build_array(jsonb_build_object('checksum_sha256', $2::text)))
+		RETURNING id
+	`, orgID, checksum, reason)
+	if err != nil {
+		return nil, fmt.Errorf("set artifact quarantine: %w", err)
+	}
+	return scanIDs(rows)
+}
+
+func (r *ArtifactRepo) ReleaseQuarantine(ctx context.Context, orgID uuid.UUID, checksum string, broken []string) ([]uuid.UUID, error) {
+	if broken == nil {
+		broken = []string{}
+	}
+	rows, err := r.pool.Query(ctx, `
+		UPDATE artifacts SET quarantined_at = NULL, quarantine_reason = ''
+		WHERE organization_id = $1
+		  AND (checksum_sha256 = $2 OR files @> jsonb_build_array(jsonb_build_object('checksum_sha256', $2::text)))
+		  AND quarantined_at IS NOT NULL
+		  AND NOT EXISTS (
+			SELECT 1 FROM jsonb_array_elements(files) f
+			WHERE f->>'checksum_sha256' = ANY($3)
+		  )
+		RETURNING id
+	`, orgID, checksum, broken)
+	if err != nil {
+		return nil, fmt.Errorf("release artifact quarantine: %w", err)
+	}
+	return scanIDs(rows)
+}
+
+func scanIDs(rows pgx.Rows) ([]uuid.UUID, error) {
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan artifact id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
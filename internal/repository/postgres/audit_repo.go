@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/CaioWing/Harbor/internal/domain"
@@ -25,10 +26,10 @@ func (r *AuditRepo) Create(ctx context.Context, entry *domain.AuditEntry) error
 	}
 
 	err = r.pool.QueryRow(ctx, `
-		INSERT INTO audit_log (actor, actor_type, action, resource, resource_id, details, ip_address)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO audit_log (organization_id, actor, actor_type, action, resource, resource_id, details, ip_address)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 		RETURNING id, created_at
-	`, entry.Actor, entry.ActorType, entry.Action, entry.Resource,
+	`, entry.OrgID, entry.Actor, entry.ActorType, entry.Action, entry.Resource,
 		entry.ResourceID, detailsJSON, entry.IPAddress).
 		Scan(&entry.ID, &entry.CreatedAt)
 	if err != nil {
@@ -37,7 +38,7 @@ func (r *AuditRepo) Create(ctx context.Context, entry *domain.AuditEntry) error
 	return nil
 }
 
-func (r *AuditRepo) List(ctx context.Context, f domain.AuditFilter) ([]*domain.AuditEntry, int, error) {
+func (r *AuditRepo) List(ctx context.Context, orgID uuid.UUID, f domain.AuditFilter) ([]*domain.AuditEntry, int, error) {
 	if f.Page < 1 {
 		f.Page = 1
 	}
@@ -48,10 +49,16 @@ func (r *AuditRepo) List(ctx context.Context, f domain.AuditFilter) ([]*domain.A
 		f.SortOrder = "desc"
 	}
 
-	where := "WHERE 1=1"
+	where := "WHERE organization_id IS NULL"
 	args := []interface{}{}
 	argIdx := 1
 
+	if orgID != uuid.Nil {
+		where = "WHERE organization_id = $1"
+		args = append(args, orgID)
+		argIdx++
+	}
+
 	if f.Actor != nil {
 		where += fmt.Sprintf(" AND actor = $%d", argIdx)
 		args = append(args, *f.Actor)
@@ -81,7 +88,7 @@ func (r *AuditRepo) List(ctx context.Context, f domain.AuditFilter) ([]*domain.A
 
 	offset := (f.Page - 1) * f.PerPage
 	query := fmt.Sprintf(`
-		SELECT id, actor, actor_type, action, resource, resource_id, details, ip_address, created_at
+		SELECT id, organization_id, actor, actor_type, action, resource, resource_id, details, ip_address, created_at
 		FROM audit_log %s
 		ORDER BY created_at %s
 		LIMIT $%d OFFSET $%d
@@ -99,7 +106,7 @@ func (r *AuditRepo) List(ctx context.Context, f domain.AuditFilter) ([]*domain.A
 		e := &domain.AuditEntry{}
 		var detailsJSON []byte
 		if err := rows.Scan(
-			&e.ID, &e.Actor, &e.ActorType, &e.Action, &e.Resource,
+			&e.ID, &e.OrgID, &e.Actor, &e.ActorType, &e.Action, &e.Resource,
 			&e.ResourceID, &detailsJSON, &e.IPAddress, &e.CreatedAt,
 		); err != nil {
 			return nil, 0, fmt.Errorf("scan audit entry: %w", err)
@@ -0,0 +1,124 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+type BlobRepo struct {
+	pool *pgxpool.Pool
+}
+
+func NewBlobRepo(pool *pgxpool.Pool) *BlobRepo {
+	return &BlobRepo{pool: pool}
+}
+
+func (r *BlobRepo) Acquire(ctx context.Context, b *domain.Blob) (bool, error) {
+	// xmax is zero only for a row this statement inserted
+	var created bool
+	err := r.pool.QueryRow(ctx, `
+		INSERT INTO blobs (organization_id, checksum_sha256, size, storage_path, ref_count)
+		VALUES ($1, $2, $3, $4, 1)
+		ON CONFLICT (organization_id, checksum_sha256)
+		DO UPDATE SET ref_count = blobs.ref_count + 1
+		RETURNING size, storage_path, ref_count, created_at, xmax = 0
+	`, b.OrgID, b.ChecksumSHA256, b.Size, b.StoragePath,
+	).Scan(&b.Size, &b.StoragePath, &b.RefCount, &b.CreatedAt, &created)
+	if err != nil {
+		return false, fmt.Errorf("acquire blob: %w", err)
+	}
+	return created, nil
+}
+
+func (r *BlobRepo) AddRef(ctx context.Context, orgID uuid.UUID, checksum string) (*domain.Blob, error) {
+	b := &domain.Blob{OrgID: orgID, ChecksumSHA256: checksum}
+	err := r.pool.QueryRow(ctx, `
+		UPDATE blobs SET ref_count = ref_count + 1
+		WHERE organization_id = $1 AND checksum_sha256 = $2
+		RETURNING size, storage_path, ref_count, created_at
+	`, orgID, checksum).Scan(&b.Size, &b.StoragePath, &b.RefCount, &b.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, fmt.Errorf("add blob reference: %w", err)
+	}
+	return b, nil
+}
+
+func (r *BlobRepo) Release(ctx context.Context, orgID uuid.UUID, checksum string) (*domain.Blob, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	b := &domain.Blob{OrgID: orgID, ChecksumSHA256: checksum}
+	err = tx.QueryRow(ctx, `
+		UPDATE blobs SET ref_count = ref_count - 1
+		WHERE organization_id = $1 AND checksum_sha256 = $2 AND ref_count > 0
+		RETURNING size, storage_path, ref_count, created_at
+	`, orgID, checksum).Scan(&b.Size, &b.StoragePath, &b.RefCount, &b.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, fmt.Errorf("release blob: %w", err)
+	}
+
+	if b.RefCount == 0 {
+		if _, err := tx.Exec(ctx, `
+			DELETE FROM blobs WHERE organization_id = $1 AND checksum_sha256 = $2 AND ref_count = 0
+		`, orgID, checksum); err != nil {
+			return nil, fmt.Errorf("delete blob: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	if b.RefCount > 0 {
+		return nil, nil
+	}
+	return b, nil
+}
+
+func (r *BlobRepo) ListAll(ctx context.Context) ([]*domain.Blob, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT organization_id, checksum_sha256, size, storage_path, ref_count, created_at
+		FROM blobs ORDER BY created_at
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("list blobs: %w", err)
+	}
+	defer rows.Close()
+
+	blobs := []*domain.Blob{}
+	for rows.Next() {
+		b := &domain.Blob{}
+		if err := rows.Scan(&b.OrgID, &b.ChecksumSHA256, &b.Size, &b.StoragePath, &b.RefCount, &b.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan blob: %w", err)
+		}
+		blobs = append(blobs, b)
+	}
+	return blobs, rows.Err()
+}
+
+func (r *BlobRepo) StoredSize(ctx context.Context) (int64, error) {
+	var total int64
+	if err := r.pool.QueryRow(ctx, `
+		SELECT (SELECT COALESCE(SUM(size), 0) FROM blobs)
+		     + (SELECT COALESCE(SUM(file_size), 0) FROM artifact_deltas)
+		     + (SELECT COALESCE(SUM(size), 0) FROM upload_sessions)
+	`).Scan(&total); err != nil {
+		return 0, fmt.Errorf("sum stored sizes: %w", err)
+	}
+	return total, nil
+}
@@ -0,0 +1,160 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+type ChannelRepo struct {
+	pool *pgxpool.Pool
+}
+
+func NewChannelRepo(pool *pgxpool.Pool) *ChannelRepo {
+	return &ChannelRepo{pool: pool}
+}
+
+const channelColumns = `id, organization_id, name, description, device_tags, upstream_id,
+	artifact_id, deployment_id, created_at, updated_at`
+
+func scanChannel(row pgx.Row) (*domain.Channel, error) {
+	c := &domain.Channel{}
+	if err := row.Scan(
+		&c.ID, &c.OrgID, &c.Name, &c.Description, &c.DeviceTags, &c.UpstreamID,
+		&c.ArtifactID, &c.DeploymentID, &c.CreatedAt, &c.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (r *ChannelRepo) Create(ctx context.Context, c *domain.Channel) error {
+	err := r.pool.QueryRow(ctx, `
+		INSERT INTO channels (organization_id, name, description, device_tags, upstream_id)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, updated_at
+	`, c.OrgID, c.Name, c.Description, c.DeviceTags, c.UpstreamID).Scan(&c.ID, &c.CreatedAt, &c.UpdatedAt)
+
+	if err != nil {
+		if isUniqueViolation(err) {
+			return domain.ErrConflict
+		}
+		return fmt.Errorf("insert channel: %w", err)
+	}
+	return nil
+}
+
+func (r *ChannelRepo) GetByID(ctx context.Context, orgID, id uuid.UUID) (*domain.Channel, error) {
+	c, err := scanChannel(r.pool.QueryRow(ctx, `
+		SELECT `+channelColumns+` FROM channels WHERE organization_id = $1 AND id = $2
+	`, orgID, id))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, domain.ErrNotFound
+		}
+		return nil, fmt.Errorf("get channel: %w", err)
+	}
+	return c, nil
+}
+
+func (r *ChannelRepo) List(ctx context.Context, orgID uuid.UUID) ([]*domain.Channel, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+channelColumns+` FROM channels WHERE organization_id = $1 ORDER BY name
+	`, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("list channels: %w", err)
+	}
+	defer rows.Close()
+
+	channels := []*domain.Channel{}
+	for rows.Next() {
+		c, err := scanChannel(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan channel: %w", err)
+		}
+		channels = append(channels, c)
+	}
+	return channels, rows.Err()
+}
+
+func (r *ChannelRepo) Delete(ctx context.Context, orgID, id uuid.UUID) error {
+	tag, err := r.pool.Exec(ctx, `DELETE FROM channels WHERE organization_id = $1 AND id = $2`, orgID, id)
+	if err != nil {
+		return fmt.Errorf("delete channel: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+func (r *ChannelRepo) CreatePromotion(ctx context.Context, p *domain.ChannelPromotion) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE channels SET artifact_id = $3, deployment_id = $4, updated_at = NOW()
+		WHERE organization_id = $1 AND id = $2
+	`, p.OrgID, p.ChannelID, p.ArtifactID, p.DeploymentID)
+	if err != nil {
+		return fmt.Errorf("update channel: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrNotFound
+	}
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO channel_promotions (organization_id, channel_id, artifact_id, deployment_id, promoted_by, reason)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`, p.OrgID, p.ChannelID, p.ArtifactID, p.DeploymentID, p.PromotedBy, p.Reason).Scan(&p.ID, &p.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("insert promotion: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *ChannelRepo) ListPromotions(ctx context.Context, orgID, channelID uuid.UUID) ([]*domain.ChannelPromotion, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT id, organization_id, channel_id, artifact_id, deployment_id, promoted_by, reason, created_at
+		FROM channel_promotions
+		WHERE organization_id = $1 AND channel_id = $2
+		ORDER BY created_at DESC
+	`, orgID, channelID)
+	if err != nil {
+		return nil, fmt.Errorf("list promotions: %w", err)
+	}
+	defer rows.Close()
+
+	promotions := []*domain.ChannelPromotion{}
+	for rows.Next() {
+		p := &domain.ChannelPromotion{}
+		if err := rows.Scan(&p.ID, &p.OrgID, &p.ChannelID, &p.ArtifactID, &p.DeploymentID, &p.PromotedBy, &p.Reason, &p.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan promotion: %w", err)
+		}
+		promotions = append(promotions, p)
+	}
+	return promotions, rows.Err()
+}
+
+func (r *ChannelRepo) HasPromotion(ctx context.Context, orgID, channelID, artifactID uuid.UUID) (bool, error) {
+	var exists bool
+	err := r.pool.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM channel_promotions
+			WHERE organization_id = $1 AND channel_id = $2 AND artifact_id = $3
+		)
+	`, orgID, channelID, artifactID).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("check promotion: %w", err)
+	}
+	return exists, nil
+}
@@ -0,0 +1,93 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+type DataKeyRepo struct {
+	pool *pgxpool.Pool
+}
+
+func NewDataKeyRepo(pool *pgxpool.Pool) *DataKeyRepo {
+	return &DataKeyRepo{pool: pool}
+}
+
+func (r *DataKeyRepo) Create(ctx context.Context, k *domain.DataKey) error {
+	err := r.pool.QueryRow(ctx, `
+		INSERT INTO data_keys (storage_path, master_key_id, wrapped_key)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (storage_path)
+		DO UPDATE SET master_key_id = EXCLUDED.master_key_id, wrapped_key = EXCLUDED.wrapped_key, created_at = NOW()
+		RETURNING created_at
+	`, k.StoragePath, k.MasterKeyID, k.WrappedKey).Scan(&k.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("insert data key: %w", err)
+	}
+	return nil
+}
+
+func (r *DataKeyRepo) Get(ctx context.Context, storagePath string) (*domain.DataKey, error) {
+	k := &domain.DataKey{StoragePath: storagePath}
+	err := r.pool.QueryRow(ctx, `
+		SELECT master_key_id, wrapped_key, created_at FROM data_keys WHERE storage_path = $1
+	`, storagePath).Scan(&k.MasterKeyID, &k.WrappedKey, &k.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, fmt.Errorf("get data key: %w", err)
+	}
+	return k, nil
+}
+
+func (r *DataKeyRepo) Delete(ctx context.Context, storagePath string) error {
+	if _, err := r.pool.Exec(ctx, `DELETE FROM data_keys WHERE storage_path = $1`, storagePath); err != nil {
+		return fmt.Errorf("delete data key: %w", err)
+	}
+	return nil
+}
+
+func (r *DataKeyRepo) ListForRewrap(ctx context.Context, masterKeyID, afterPath string, limit int) ([]*domain.DataKey, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT storage_path, master_key_id, wrapped_key, created_at
+		FROM data_keys
+		WHERE master_key_id <> $1 AND storage_path > $2
+		ORDER BY storage_path
+		LIMIT $3
+	`, masterKeyID, afterPath, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list data keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []*domain.DataKey
+	for rows.Next() {
+		k := &domain.DataKey{}
+		if err := rows.Scan(&k.StoragePath, &k.MasterKeyID, &k.WrappedKey, &k.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan data key: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func (r *DataKeyRepo) Rewrap(ctx context.Context, k *domain.DataKey, oldMasterKeyID string) error {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE data_keys SET master_key_id = $2, wrapped_key = $3
+		WHERE storage_path = $1 AND master_key_id = $4
+	`, k.StoragePath, k.MasterKeyID, k.WrappedKey, oldMasterKeyID)
+	if err != nil {
+		return fmt.Errorf("rewrap data key: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrConflict
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
@@ -21,15 +22,23 @@ func NewDeploymentRepo(pool *pgxpool.Pool) *DeploymentRepo {
 }
 
 func (r *DeploymentRepo) Create(ctx context.Context, d *domain.Deployment) error {
-	err := r.pool.QueryRow(ctx, `
+	variablesJSON, err := json.Marshal(d.Variables)
+	if err != nil {
+		return fmt.Errorf("marshal variables: %w", err)
+	}
+	if d.Variables == nil {
+		variablesJSON = []byte("{}")
+	}
+
+	err = r.pool.QueryRow(ctx, `
 		INSERT INTO deployments (
-			name, artifact_id, status, target_device_ids,
-			target_device_tags, target_device_types, max_parallel
-		) VALUES ($1,$2,$3,$4,$5,$6,$7)
+			organization_id, name, artifact_id, status, target_device_ids,
+			target_device_tags, target_device_types, max_parallel, variables, continuous, channel_id, rollback_of, manifest_id
+		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13)
 		RETURNING id, created_at
 	`,
-		d.Name, d.ArtifactID, d.Status, d.TargetDeviceIDs,
-		d.TargetDeviceTags, d.TargetDeviceTypes, d.MaxParallel,
+		d.OrgID, d.Name, d.ArtifactID, d.Status, d.TargetDeviceIDs,
+		d.TargetDeviceTags, d.TargetDeviceTypes, d.MaxParallel, variablesJSON, d.Continuous, d.ChannelID, d.RollbackOf, d.ManifestID,
 	).Scan(&d.ID, &d.CreatedAt)
 
 	if err != nil {
@@ -38,16 +47,17 @@ func (r *DeploymentRepo) Create(ctx context.Context, d *domain.Deployment) error
 	return nil
 }
 
-func (r *DeploymentRepo) GetByID(ctx context.Context, id uuid.UUID) (*domain.Deployment, error) {
+func (r *DeploymentRepo) GetByID(ctx context.Context, orgID, id uuid.UUID) (*domain.Deployment, error) {
 	d := &domain.Deployment{}
+	var variablesJSON []byte
 	err := r.pool.QueryRow(ctx, `
-		SELECT id, name, artifact_id, status, target_device_ids,
-		       target_device_tags, target_device_types, max_parallel,
+		SELECT id, organization_id, name, artifact_id, status, target_device_ids,
+		       target_device_tags, target_device_types, max_parallel, variables, continuous, channel_id, rollback_of, manifest_id,
 		       created_at, started_at, finished_at
-		FROM deployments WHERE id = $1
-	`, id).Scan(
-		&d.ID, &d.Name, &d.ArtifactID, &d.Status, &d.TargetDeviceIDs,
-		&d.TargetDeviceTags, &d.TargetDeviceTypes, &d.MaxParallel,
+		FROM deployments WHERE organization_id = $1 AND id = $2
+	`, orgID, id).Scan(
+		&d.ID, &d.OrgID, &d.Name, &d.ArtifactID, &d.Status, &d.TargetDeviceIDs,
+		&d.TargetDeviceTags, &d.TargetDeviceTypes, &d.MaxParallel, &variablesJSON, &d.Continuous, &d.ChannelID, &d.RollbackOf, &d.ManifestID,
 		&d.CreatedAt, &d.StartedAt, &d.FinishedAt,
 	)
 	if err != nil {
@@ -56,10 +66,13 @@ func (r *DeploymentRepo) GetByID(ctx context.Context, id uuid.UUID) (*domain.Dep
 		}
 		return nil, fmt.Errorf("get deployment: %w", err)
 	}
+	if err := json.Unmarshal(variablesJSON, &d.Variables); err != nil {
+		return nil, fmt.Errorf("unmarshal variables: %w", err)
+	}
 	return d, nil
 }
 
-func (r *DeploymentRepo) List(ctx context.Context, f domain.DeploymentFilter) ([]*domain.Deployment, int, error) {
+func (r *DeploymentRepo) List(ctx context.Context, orgID uuid.UUID, f domain.DeploymentFilter) ([]*domain.Deployment, int, error) {
 	if f.Page < 1 {
 		f.Page = 1
 	}
@@ -73,9 +86,9 @@ func (r *DeploymentRepo) List(ctx context.Context, f domain.DeploymentFilter) ([
 		f.SortOrder = "desc"
 	}
 
-	where := "WHERE 1=1"
-	args := []interface{}{}
-	argIdx := 1
+	where := "WHERE organization_id = $1"
+	args := []interface{}{orgID}
+	argIdx := 2
 
 	if f.Status != nil {
 		where += fmt.Sprintf(" AND status = $%d", argIdx)
@@ -100,8 +113,8 @@ func (r *DeploymentRepo) List(ctx context.Context, f domain.DeploymentFilter) ([
 
 	offset := (f.Page - 1) * f.PerPage
 	query := fmt.Sprintf(`
-		SELECT id, name, artifact_id, status, target_device_ids,
-		       target_device_tags, target_device_types, max_parallel,
+		SELECT id, organization_id, name, artifact_id, status, target_device_ids,
+		       target_device_tags, target_device_types, max_parallel, variables, continuous, channel_id, rollback_of, manifest_id,
 		       created_at, started_at, finished_at
 		FROM deployments %s
 		ORDER BY %s %s
@@ -118,13 +131,15 @@ func (r *DeploymentRepo) List(ctx context.Context, f domain.DeploymentFilter) ([
 	var deployments []*domain.Deployment
 	for rows.Next() {
 		d := &domain.Deployment{}
+		var variablesJSON []byte
 		if err := rows.Scan(
-			&d.ID, &d.Name, &d.ArtifactID, &d.Status, &d.TargetDeviceIDs,
-			&d.TargetDeviceTags, &d.TargetDeviceTypes, &d.MaxParallel,
+			&d.ID, &d.OrgID, &d.Name, &d.ArtifactID, &d.Status, &d.TargetDeviceIDs,
+			&d.TargetDeviceTags, &d.TargetDeviceTypes, &d.MaxParallel, &variablesJSON, &d.Continuous, &d.ChannelID, &d.RollbackOf, &d.ManifestID,
 			&d.CreatedAt, &d.StartedAt, &d.FinishedAt,
 		); err != nil {
 			return nil, 0, fmt.Errorf("scan deployment: %w", err)
 		}
+		json.Unmarshal(variablesJSON, &d.Variables)
 		deployments = append(deployments, d)
 	}
 
@@ -135,8 +150,8 @@ func (r *DeploymentRepo) List(ctx context.Context, f domain.DeploymentFilter) ([
 	return deployments, total, nil
 }
 
-func (r *DeploymentRepo) UpdateStatus(ctx context.Context, id uuid.UUID, status domain.DeploymentStatus) error {
-	tag, err := r.pool.Exec(ctx, `UPDATE deployments SET status = $1 WHERE id = $2`, status, id)
+func (r *DeploymentRepo) UpdateStatus(ctx context.Context, orgID, id uuid.UUID, status domain.DeploymentStatus) error {
+	tag, err := r.pool.Exec(ctx, `UPDATE deployments SET status = $1 WHERE organization_id = $2 AND id = $3`, status, orgID, id)
 	if err != nil {
 		return fmt.Errorf("update deployment status: %w", err)
 	}
@@ -146,24 +161,24 @@ func (r *DeploymentRepo) UpdateStatus(ctx context.Context, id uuid.UUID, status
 	return nil
 }
 
-func (r *DeploymentRepo) SetStarted(ctx context.Context, id uuid.UUID) error {
-	_, err := r.pool.Exec(ctx, `UPDATE deployments SET started_at = NOW(), status = 'active' WHERE id = $1`, id)
+func (r *DeploymentRepo) SetStarted(ctx context.Context, orgID, id uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, `UPDATE deployments SET started_at = NOW(), status = 'active' WHERE organization_id = $1 AND id = $2`, orgID, id)
 	if err != nil {
 		return fmt.Errorf("set deployment started: %w", err)
 	}
 	return nil
 }
 
-func (r *DeploymentRepo) SetFinished(ctx context.Context, id uuid.UUID) error {
-	_, err := r.pool.Exec(ctx, `UPDATE deployments SET finished_at = NOW(), status = 'completed' WHERE id = $1`, id)
+func (r *DeploymentRepo) SetFinished(ctx context.Context, orgID, id uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, `UPDATE deployments SET finished_at = NOW(), status = 'completed' WHERE organization_id = $1 AND id = $2`, orgID, id)
 	if err != nil {
 		return fmt.Errorf("set deployment finished: %w", err)
 	}
 	return nil
 }
 
-func (r *DeploymentRepo) GetStats(ctx context.Context) (*domain.DeploymentStats, error) {
-	rows, err := r.pool.Query(ctx, `SELECT status, COUNT(*) FROM deployments GROUP BY status`)
+func (r *DeploymentRepo) GetStats(ctx context.Context, orgID uuid.UUID) (*domain.DeploymentStats, error) {
+	rows, err := r.pool.Query(ctx, `SELECT status, COUNT(*) FROM deployments WHERE organization_id = $1 GROUP BY status`, orgID)
 	if err != nil {
 		return nil, fmt.Errorf("get stats: %w", err)
 	}
@@ -193,14 +208,56 @@ func (r *DeploymentRepo) GetStats(ctx context.Context) (*domain.DeploymentStats,
 
 // DeploymentDevice operations
 
+func (r *DeploymentRepo) ListSummariesByArtifacts(ctx context.Context, orgID uuid.UUID, artifactIDs []uuid.UUID) ([]*domain.DeploymentSummary, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT d.id, d.artifact_id, d.name, d.status, d.created_at, d.finished_at, dd.status, COUNT(dd.id)
+		FROM deployments d
+		LEFT JOIN deployment_devices dd ON dd.deployment_id = d.id
+		WHERE d.organization_id = $1 AND d.artifact_id = ANY($2)
+		GROUP BY d.id, dd.status
+		ORDER BY d.created_at DESC, d.id
+	`, orgID, artifactIDs)
+	if err != nil {
+		return nil, fmt.Errorf("list deployment summaries: %w", err)
+	}
+	defer rows.Close()
+
+	summaries := []*domain.DeploymentSummary{}
+	var last *domain.DeploymentSummary
+	for rows.Next() {
+		s := &domain.DeploymentSummary{}
+		var status *domain.DeploymentDeviceStatus
+		var count int
+		if err := rows.Scan(&s.ID, &s.ArtifactID, &s.Name, &s.Status, &s.CreatedAt, &s.FinishedAt, &status, &count); err != nil {
+			return nil, fmt.Errorf("scan deployment summary: %w", err)
+		}
+		if last == nil || last.ID != s.ID {
+			s.Devices = make(map[domain.DeploymentDeviceStatus]int)
+			summaries = append(summaries, s)
+			last = s
+		}
+		if status != nil {
+			last.Devices[*status] = count
+		}
+	}
+	return summaries, rows.Err()
+}
+
 func (r *DeploymentRepo) CreateDeploymentDevice(ctx context.Context, dd *domain.DeploymentDevice) error {
+	// Only link devices that belong to the deployment's organization
 	err := r.pool.QueryRow(ctx, `
-		INSERT INTO deployment_devices (deployment_id, device_id, status)
-		VALUES ($1, $2, $3)
+		INSERT INTO deployment_devices (deployment_id, device_id, status, delivery_seed)
+		SELECT d.id, v.id, $3, $4
+		FROM deployments d
+		JOIN devices v ON v.organization_id = d.organization_id
+		WHERE d.id = $1 AND v.id = $2
 		RETURNING id
-	`, dd.DeploymentID, dd.DeviceID, dd.Status).Scan(&dd.ID)
+	`, dd.DeploymentID, dd.DeviceID, dd.Status, dd.DeliverySeed).Scan(&dd.ID)
 
 	if err != nil {
+		if err == pgx.ErrNoRows {
+			return domain.ErrNotFound
+		}
 		if isUniqueViolation(err) {
 			return domain.ErrConflict
 		}
@@ -209,12 +266,75 @@ func (r *DeploymentRepo) CreateDeploymentDevice(ctx context.Context, dd *domain.
 	return nil
 }
 
-func (r *DeploymentRepo) GetDeploymentDevices(ctx context.Context, deploymentID uuid.UUID) ([]*domain.DeploymentDevice, error) {
+// deploymentDeviceColumns and deploymentDeviceScanDest must be kept in the
+// same order.
+const deploymentDeviceColumns = `dd.id, dd.deployment_id, dd.device_id, dd.status, dd.attempts, dd.log,
+	dd.started_at, dd.finished_at, dd.exit_code, dd.output_size, dd.output_truncated`
+
+func deploymentDeviceScanDest(dd *domain.DeploymentDevice) []interface{} {
+	return []interface{}{
+		&dd.ID, &dd.DeploymentID, &dd.DeviceID, &dd.Status, &dd.Attempts, &dd.Log,
+		&dd.StartedAt, &dd.FinishedAt, &dd.ExitCode, &dd.OutputSize, &dd.OutputTruncated,
+	}
+}
+
+func (r *DeploymentRepo) GetDeploymentDevice(ctx context.Context, orgID, id uuid.UUID) (*domain.DeploymentDevice, error) {
+	dd := &domain.DeploymentDevice{}
+	err := r.pool.QueryRow(ctx, `
+		SELECT `+deploymentDeviceColumns+`
+		FROM deployment_devices dd
+		JOIN deployments d ON d.id = dd.deployment_id
+		WHERE d.organization_id = $1 AND dd.id = $2
+	`, orgID, id).Scan(deploymentDeviceScanDest(dd)...)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, domain.ErrNotFound
+		}
+		return nil, fmt.Errorf("get deployment_device: %w", err)
+	}
+	return dd, nil
+}
+
+func (r *DeploymentRepo) GetDeviceEntry(ctx context.Context, orgID, deploymentID, deviceID uuid.UUID) (*domain.DeploymentDevice, error) {
+	dd := &domain.DeploymentDevice{}
+	err := r.pool.QueryRow(ctx, `
+		SELECT `+deploymentDeviceColumns+`
+		FROM deployment_devices dd
+		JOIN deployments d ON d.id = dd.deployment_id
+		WHERE d.organization_id = $1 AND dd.deployment_id = $2 AND dd.device_id = $3
+	`, orgID, deploymentID, deviceID).Scan(deploymentDeviceScanDest(dd)...)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, domain.ErrNotFound
+		}
+		return nil, fmt.Errorf("get deployment_device: %w", err)
+	}
+	return dd, nil
+}
+
+func (r *DeploymentRepo) ResetDeploymentDevice(ctx context.Context, orgID, id uuid.UUID) error {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE deployment_devices
+		SET status = $1, log = '', started_at = NULL, finished_at = NULL, exit_code = NULL
+		WHERE id = $2 AND deployment_id IN (SELECT id FROM deployments WHERE organization_id = $3)
+	`, domain.DDStatusPending, id, orgID)
+	if err != nil {
+		return fmt.Errorf("reset deployment_device: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+func (r *DeploymentRepo) GetDeploymentDevices(ctx context.Context, orgID, deploymentID uuid.UUID) ([]*domain.DeploymentDevice, error) {
 	rows, err := r.pool.Query(ctx, `
-		SELECT id, deployment_id, device_id, status, attempts, log, started_at, finished_at
-		FROM deployment_devices WHERE deployment_id = $1
-		ORDER BY device_id
-	`, deploymentID)
+		SELECT `+deploymentDeviceColumns+`
+		FROM deployment_devices dd
+		JOIN deployments d ON d.id = dd.deployment_id
+		WHERE d.organization_id = $1 AND dd.deployment_id = $2
+		ORDER BY dd.device_id
+	`, orgID, deploymentID)
 	if err != nil {
 		return nil, fmt.Errorf("get deployment devices: %w", err)
 	}
@@ -223,10 +343,7 @@ func (r *DeploymentRepo) GetDeploymentDevices(ctx context.Context, deploymentID
 	var items []*domain.DeploymentDevice
 	for rows.Next() {
 		dd := &domain.DeploymentDevice{}
-		if err := rows.Scan(
-			&dd.ID, &dd.DeploymentID, &dd.DeviceID, &dd.Status,
-			&dd.Attempts, &dd.Log, &dd.StartedAt, &dd.FinishedAt,
-		); err != nil {
+		if err := rows.Scan(deploymentDeviceScanDest(dd)...); err != nil {
 			return nil, fmt.Errorf("scan deployment_device: %w", err)
 		}
 		items = append(items, dd)
@@ -239,34 +356,148 @@ func (r *DeploymentRepo) GetDeploymentDevices(ctx context.Context, deploymentID
 	return items, nil
 }
 
-func (r *DeploymentRepo) GetPendingDeploymentForDevice(ctx context.Context, deviceID uuid.UUID) (*domain.DeploymentDevice, *domain.Deployment, *domain.Artifact, error) {
+const deviceDeploymentSelect = `
+		SELECT
+			dd.id, dd.deployment_id, dd.device_id, dd.status, dd.attempts, dd.delivery_seed,
+			d.id, d.organization_id, d.name, d.artifact_id, d.status, d.variables,
+			` + artifactColumns + `
+		FROM deployment_devices dd
+		JOIN deployments d ON d.id = dd.deployment_id
+		JOIN artifacts a ON a.id = d.artifact_id`
+
+func scanDeviceDeployment(row pgx.Row) (*domain.DeploymentDevice, *domain.Deployment, *domain.Artifact, error) {
 	dd := &domain.DeploymentDevice{}
 	dep := &domain.Deployment{}
 	art := &domain.Artifact{}
+	var variablesJSON []byte
 
-	err := r.pool.QueryRow(ctx, `
-		SELECT
-			dd.id, dd.deployment_id, dd.device_id, dd.status, dd.attempts,
-			d.id, d.name, d.artifact_id, d.status,
-			a.id, a.name, a.version, a.file_name, a.file_size, a.checksum_sha256,
-			a.target_path, a.file_mode, a.file_owner, a.device_types, a.storage_path,
-			a.pre_install_cmd, a.post_install_cmd, a.rollback_cmd
+	err := row.Scan(append([]interface{}{
+		&dd.ID, &dd.DeploymentID, &dd.DeviceID, &dd.Status, &dd.Attempts, &dd.DeliverySeed,
+		&dep.ID, &dep.OrgID, &dep.Name, &dep.ArtifactID, &dep.Status, &variablesJSON,
+	}, artifactScanDest(art)...)...)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := json.Unmarshal(variablesJSON, &dep.Variables); err != nil {
+		return nil, nil, nil, fmt.Errorf("unmarshal variables: %w", err)
+	}
+	return dd, dep, art, nil
+}
+
+func (r *DeploymentRepo) ListPreviousArtifacts(ctx context.Context, orgID, deploymentID uuid.UUID) (map[uuid.UUID]uuid.UUID, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT DISTINCT ON (bad_dd.device_id) bad_dd.device_id, a.id
+		FROM deployments bad
+		JOIN artifacts bad_a ON bad_a.id = bad.artifact_id
+		JOIN deployment_devices bad_dd ON bad_dd.deployment_id = bad.id AND bad_dd.status = $3
+		JOIN deployment_devices dd ON dd.device_id = bad_dd.device_id AND dd.status = $3
+			AND dd.finished_at < bad_dd.finished_at
+		JOIN deployments d ON d.id = dd.deployment_id AND d.organization_id = $1
+		JOIN artifacts a ON a.id = d.artifact_id
+		WHERE bad.organization_id = $1 AND bad.id = $2
+		  AND a.id <> bad_a.id AND a.name = bad_a.name AND a.target_path = bad_a.target_path
+		ORDER BY bad_dd.device_id, dd.finished_at DESC
+	`, orgID, deploymentID, domain.DDStatusSuccess)
+	if err != nil {
+		return nil, fmt.Errorf("list previous artifacts: %w", err)
+	}
+	defer rows.Close()
+
+	previous := make(map[uuid.UUID]uuid.UUID)
+	for rows.Next() {
+		var deviceID, artifactID uuid.UUID
+		if err := rows.Scan(&deviceID, &artifactID); err != nil {
+			return nil, fmt.Errorf("scan previous artifact: %w", err)
+		}
+		previous[deviceID] = artifactID
+	}
+	return previous, rows.Err()
+}
+
+func (r *DeploymentRepo) ListInstallations(ctx context.Context, orgID uuid.UUID, deviceIDs []uuid.UUID) ([]*domain.Installation, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT dd.device_id, dd.deployment_id, d.artifact_id, dd.finished_at
 		FROM deployment_devices dd
 		JOIN deployments d ON d.id = dd.deployment_id
-		JOIN artifacts a ON a.id = d.artifact_id
-		WHERE dd.device_id = $1
+		WHERE d.organization_id = $1 AND dd.device_id = ANY($2) AND dd.status = $3
+		ORDER BY dd.finished_at, dd.id
+	`, orgID, deviceIDs, domain.DDStatusSuccess)
+	if err != nil {
+		return nil, fmt.Errorf("list installations: %w", err)
+	}
+	defer rows.Close()
+
+	var installations []*domain.Installation
+	for rows.Next() {
+		i := &domain.Installation{}
+		if err := rows.Scan(&i.DeviceID, &i.DeploymentID, &i.ArtifactID, &i.InstalledAt); err != nil {
+			return nil, fmt.Errorf("scan installation: %w", err)
+		}
+		installations = append(installations, i)
+	}
+	return installations, rows.Err()
+}
+
+func (r *DeploymentRepo) ListDeployedArtifacts(ctx context.Context, orgID uuid.UUID, since time.Time) ([]uuid.UUID, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT DISTINCT d.artifact_id FROM deployments d
+		WHERE d.organization_id = $1
+		  AND ((d.status <> 'cancelled' AND (d.continuous OR EXISTS (
+		          SELECT 1 FROM deployment_devices dd
+		          WHERE dd.deployment_id = d.id AND dd.status IN ('pending', 'downloading', 'installing'))))
+		    OR d.created_at > $2
+		    OR EXISTS (SELECT 1 FROM deployment_devices dd WHERE dd.deployment_id = d.id AND dd.finished_at > $2))
+	`, orgID, since)
+	if err != nil {
+		return nil, fmt.Errorf("list deployed artifacts: %w", err)
+	}
+	return scanIDs(rows)
+}
+
+func (r *DeploymentRepo) ListContinuous(ctx context.Context, orgID, deviceID uuid.UUID) ([]*domain.Deployment, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT d.id, d.organization_id, d.name, d.artifact_id, d.status, d.target_device_ids,
+		       d.target_device_tags, d.target_device_types, d.max_parallel, d.variables, d.continuous, d.channel_id, d.rollback_of, d.manifest_id,
+		       d.created_at, d.started_at, d.finished_at
+		FROM deployments d
+		WHERE d.organization_id = $1 AND d.continuous AND d.status = $2
+		  AND NOT EXISTS (SELECT 1 FROM deployment_devices dd WHERE dd.deployment_id = d.id AND dd.device_id = $3)
+		ORDER BY d.created_at
+	`, orgID, domain.DeploymentStatusActive, deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("list continuous deployments: %w", err)
+	}
+	defer rows.Close()
+
+	var deployments []*domain.Deployment
+	for rows.Next() {
+		d := &domain.Deployment{}
+		var variablesJSON []byte
+		if err := rows.Scan(
+			&d.ID, &d.OrgID, &d.Name, &d.ArtifactID, &d.Status, &d.TargetDeviceIDs,
+			&d.TargetDeviceTags, &d.TargetDeviceTypes, &d.MaxParallel, &variablesJSON, &d.Continuous, &d.ChannelID, &d.RollbackOf, &d.ManifestID,
+			&d.CreatedAt, &d.StartedAt, &d.FinishedAt,
+		); err != nil {
+			return nil, fmt.Errorf("scan deployment: %w", err)
+		}
+		if err := json.Unmarshal(variablesJSON, &d.Variables); err != nil {
+			return nil, fmt.Errorf("unmarshal variables: %w", err)
+		}
+		deployments = append(deployments, d)
+	}
+	return deployments, rows.Err()
+}
+
+func (r *DeploymentRepo) GetPendingDeploymentForDevice(ctx context.Context, orgID, deviceID uuid.UUID) (*domain.DeploymentDevice, *domain.Deployment, *domain.Artifact, error) {
+	dd, dep, art, err := scanDeviceDeployment(r.pool.QueryRow(ctx, deviceDeploymentSelect+`
+		WHERE d.organization_id = $1
+		  AND dd.device_id = $2
 		  AND dd.status = 'pending'
 		  AND d.status IN ('scheduled', 'active')
+		  AND a.quarantined_at IS NULL
 		ORDER BY d.created_at ASC
 		LIMIT 1
-	`, deviceID).Scan(
-		&dd.ID, &dd.DeploymentID, &dd.DeviceID, &dd.Status, &dd.Attempts,
-		&dep.ID, &dep.Name, &dep.ArtifactID, &dep.Status,
-		&art.ID, &art.Name, &art.Version, &art.FileName, &art.FileSize,
-		&art.ChecksumSHA256, &art.TargetPath, &art.FileMode, &art.FileOwner,
-		&art.DeviceTypes, &art.StoragePath, &art.PreInstallCmd, &art.PostInstallCmd,
-		&art.RollbackCmd,
-	)
+	`, orgID, deviceID))
 
 	if err != nil {
 		if err == pgx.ErrNoRows {
@@ -278,18 +509,41 @@ func (r *DeploymentRepo) GetPendingDeploymentForDevice(ctx context.Context, devi
 	return dd, dep, art, nil
 }
 
-func (r *DeploymentRepo) UpdateDeploymentDeviceStatus(ctx context.Context, id uuid.UUID, status domain.DeploymentDeviceStatus, log string) error {
-	var query string
+func (r *DeploymentRepo) GetActiveDeploymentDevice(ctx context.Context, orgID, deviceID, ddID uuid.UUID) (*domain.DeploymentDevice, *domain.Deployment, *domain.Artifact, error) {
+	dd, dep, art, err := scanDeviceDeployment(r.pool.QueryRow(ctx, deviceDeploymentSelect+`
+		WHERE d.organization_id = $1
+		  AND dd.device_id = $2
+		  AND dd.id = $3
+		  AND dd.status IN ('pending', 'downloading', 'installing')
+		  AND d.status IN ('scheduled', 'active')
+		  AND a.quarantined_at IS NULL
+	`, orgID, deviceID, ddID))
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil, nil, domain.ErrNotFound
+		}
+		return nil, nil, nil, fmt.Errorf("get deployment device: %w", err)
+	}
+
+	return dd, dep, art, nil
+}
+
+func (r *DeploymentRepo) UpdateDeploymentDeviceStatus(ctx context.Context, orgID, id uuid.UUID, status domain.DeploymentDeviceStatus, log string) error {
+	var set string
 	switch status {
 	case domain.DDStatusDownloading, domain.DDStatusInstalling:
-		query = `UPDATE deployment_devices SET status = $1, log = $2, attempts = attempts + 1, started_at = COALESCE(started_at, NOW()) WHERE id = $3`
+		set = `status = $1, log = $2, attempts = attempts + 1, started_at = COALESCE(started_at, NOW())`
 	case domain.DDStatusSuccess, domain.DDStatusFailure:
-		query = `UPDATE deployment_devices SET status = $1, log = $2, finished_at = NOW() WHERE id = $3`
+		set = `status = $1, log = $2, finished_at = NOW()`
 	default:
-		query = `UPDATE deployment_devices SET status = $1, log = $2 WHERE id = $3`
+		set = `status = $1, log = $2`
 	}
 
-	tag, err := r.pool.Exec(ctx, query, status, log, id)
+	query := `UPDATE deployment_devices SET ` + set + `
+		WHERE id = $3 AND deployment_id IN (SELECT id FROM deployments WHERE organization_id = $4)`
+
+	tag, err := r.pool.Exec(ctx, query, status, log, id, orgID)
 	if err != nil {
 		return fmt.Errorf("update dd status: %w", err)
 	}
@@ -299,11 +553,108 @@ func (r *DeploymentRepo) UpdateDeploymentDeviceStatus(ctx context.Context, id uu
 	return nil
 }
 
-func (r *DeploymentRepo) CountDeploymentDevicesByStatus(ctx context.Context, deploymentID uuid.UUID) (map[domain.DeploymentDeviceStatus]int, error) {
+func (r *DeploymentRepo) FinishScript(ctx context.Context, orgID, id uuid.UUID, status domain.DeploymentDeviceStatus, log string, code int) error {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE deployment_devices SET status = $1, log = $2, exit_code = $3, finished_at = NOW()
+		WHERE id = $4 AND status IN ('pending', 'downloading', 'installing')
+		  AND deployment_id IN (SELECT id FROM deployments WHERE organization_id = $5)
+	`, status, log, code, id, orgID)
+	if err != nil {
+		return fmt.Errorf("finish script: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+func (r *DeploymentRepo) AppendOutput(ctx context.Context, orgID, id uuid.UUID, chunk *domain.OutputChunk, maxSize int64) (bool, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// The row lock orders concurrent chunks of the same entry
+	var size int64
+	err = tx.QueryRow(ctx, `
+		SELECT dd.output_size FROM deployment_devices dd
+		JOIN deployments d ON d.id = dd.deployment_id
+		WHERE d.organization_id = $1 AND dd.id = $2
+		FOR UPDATE OF dd
+	`, orgID, id).Scan(&size)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return false, domain.ErrNotFound
+		}
+		return false, fmt.Errorf("lock deployment_device: %w", err)
+	}
+
+	data := []byte(chunk.Data)
+	truncated := false
+	if remaining := maxSize - size; int64(len(data)) > remaining {
+		data = data[:max(remaining, 0)]
+		truncated = true
+	}
+	if len(data) > 0 {
+		err = tx.QueryRow(ctx, `
+			INSERT INTO deployment_device_output (deployment_device_id, stream, data)
+			VALUES ($1, $2, $3)
+			RETURNING seq, created_at
+		`, id, chunk.Stream, data).Scan(&chunk.Seq, &chunk.CreatedAt)
+		if err != nil {
+			return false, fmt.Errorf("insert output: %w", err)
+		}
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE deployment_devices
+		SET output_size = output_size + $1, output_truncated = output_truncated OR $2
+		WHERE id = $3
+	`, len(data), truncated, id); err != nil {
+		return false, fmt.Errorf("update output size: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("commit output: %w", err)
+	}
+	return truncated, nil
+}
+
+func (r *DeploymentRepo) ListOutput(ctx context.Context, orgID, id uuid.UUID, after int64, limit int) ([]*domain.OutputChunk, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT o.seq, o.stream, o.data, o.created_at
+		FROM deployment_device_output o
+		JOIN deployment_devices dd ON dd.id = o.deployment_device_id
+		JOIN deployments d ON d.id = dd.deployment_id
+		WHERE d.organization_id = $1 AND o.deployment_device_id = $2 AND o.seq > $3
+		ORDER BY o.seq
+		LIMIT $4
+	`, orgID, id, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list output: %w", err)
+	}
+	defer rows.Close()
+
+	chunks := []*domain.OutputChunk{}
+	for rows.Next() {
+		c := &domain.OutputChunk{}
+		var data []byte
+		if err := rows.Scan(&c.Seq, &c.Stream, &data, &c.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan output: %w", err)
+		}
+		c.Data = string(data)
+		chunks = append(chunks, c)
+	}
+	return chunks, rows.Err()
+}
+
+func (r *DeploymentRepo) CountDeploymentDevicesByStatus(ctx context.Context, orgID, deploymentID uuid.UUID) (map[domain.DeploymentDeviceStatus]int, error) {
 	rows, err := r.pool.Query(ctx, `
-		SELECT status, COUNT(*) FROM deployment_devices
-		WHERE deployment_id = $1 GROUP BY status
-	`, deploymentID)
+		SELECT dd.status, COUNT(*) FROM deployment_devices dd
+		JOIN deployments d ON d.id = dd.deployment_id
+		WHERE d.organization_id = $1 AND dd.deployment_id = $2
+		GROUP BY dd.status
+	`, orgID, deploymentID)
 	if err != nil {
 		return nil, fmt.Errorf("count dd by status: %w", err)
 	}
@@ -31,10 +31,10 @@ func (r *DeviceRepo) Create(ctx context.Context, d *domain.Device) error {
 	}
 
 	err = r.pool.QueryRow(ctx, `
-		INSERT INTO devices (identity_hash, identity_data, status, device_type, inventory, tags)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO devices (organization_id, identity_hash, identity_data, status, device_type, inventory, tags)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 		RETURNING id, created_at, updated_at
-	`, d.IdentityHash, identityJSON, d.Status, d.DeviceType, inventoryJSON, d.Tags).
+	`, d.OrgID, d.IdentityHash, identityJSON, d.Status, d.DeviceType, inventoryJSON, d.Tags).
 		Scan(&d.ID, &d.CreatedAt, &d.UpdatedAt)
 
 	if err != nil {
@@ -46,55 +46,40 @@ func (r *DeviceRepo) Create(ctx context.Context, d *domain.Device) error {
 	return nil
 }
 
-func (r *DeviceRepo) GetByID(ctx context.Context, id uuid.UUID) (*domain.Device, error) {
-	d := &domain.Device{}
-	var identityJSON, inventoryJSON []byte
+const deviceColumns = `id, organization_id, identity_hash, identity_data, status, auth_token_hash,
+	inventory, device_type, tags, encryption_key, last_check_in, inventory_updated_at, created_at, updated_at`
 
-	err := r.pool.QueryRow(ctx, `
-		SELECT id, identity_hash, identity_data, status, auth_token_hash,
-		       inventory, device_type, tags, last_check_in, created_at, updated_at
-		FROM devices WHERE id = $1
-	`, id).Scan(
-		&d.ID, &d.IdentityHash, &identityJSON, &d.Status, &d.AuthTokenHash,
-		&inventoryJSON, &d.DeviceType, &d.Tags, &d.LastCheckIn, &d.CreatedAt, &d.UpdatedAt,
-	)
-	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, domain.ErrNotFound
-		}
-		return nil, fmt.Errorf("get device: %w", err)
-	}
+func (r *DeviceRepo) GetByID(ctx context.Context, orgID, id uuid.UUID) (*domain.Device, error) {
+	return r.getOne(ctx, `WHERE organization_id = $1 AND id = $2`, orgID, id)
+}
 
-	if err := json.Unmarshal(identityJSON, &d.IdentityData); err != nil {
-		return nil, fmt.Errorf("unmarshal identity: %w", err)
-	}
-	if err := json.Unmarshal(inventoryJSON, &d.Inventory); err != nil {
-		return nil, fmt.Errorf("unmarshal inventory: %w", err)
-	}
-	if d.Tags == nil {
-		d.Tags = []string{}
-	}
+func (r *DeviceRepo) GetByIdentityHash(ctx context.Context, orgID uuid.UUID, hash string) (*domain.Device, error) {
+	return r.getOne(ctx, `WHERE organization_id = $1 AND identity_hash = $2`, orgID, hash)
+}
 
-	return d, nil
+// GetByAuthTokenHash finds a device by its current auth token regardless of
+// organization. The device's OrgID then scopes every later query.
+func (r *DeviceRepo) GetByAuthTokenHash(ctx context.Context, tokenHash string) (*domain.Device, error) {
+	return r.getOne(ctx, `WHERE auth_token_hash = $1`, tokenHash)
 }
 
-func (r *DeviceRepo) GetByIdentityHash(ctx context.Context, hash string) (*domain.Device, error) {
+func (r *DeviceRepo) getOne(ctx context.Context, where string, args ...interface{}) (*domain.Device, error) {
 	d := &domain.Device{}
 	var identityJSON, inventoryJSON []byte
+	var authTokenHash *string
 
-	err := r.pool.QueryRow(ctx, `
-		SELECT id, identity_hash, identity_data, status, auth_token_hash,
-		       inventory, device_type, tags, last_check_in, created_at, updated_at
-		FROM devices WHERE identity_hash = $1
-	`, hash).Scan(
-		&d.ID, &d.IdentityHash, &identityJSON, &d.Status, &d.AuthTokenHash,
-		&inventoryJSON, &d.DeviceType, &d.Tags, &d.LastCheckIn, &d.CreatedAt, &d.UpdatedAt,
+	err := r.pool.QueryRow(ctx, `SELECT `+deviceColumns+` FROM devices `+where, args...).Scan(
+		&d.ID, &d.OrgID, &d.IdentityHash, &identityJSON, &d.Status, &authTokenHash,
+		&inventoryJSON, &d.DeviceType, &d.Tags, &d.EncryptionKey, &d.LastCheckIn, &d.InventoryUpdatedAt, &d.CreatedAt, &d.UpdatedAt,
 	)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, domain.ErrNotFound
 		}
-		return nil, fmt.Errorf("get device by hash: %w", err)
+		return nil, fmt.Errorf("get device: %w", err)
+	}
+	if authTokenHash != nil {
+		d.AuthTokenHash = *authTokenHash
 	}
 
 	if err := json.Unmarshal(identityJSON, &d.IdentityData); err != nil {
@@ -110,7 +95,7 @@ func (r *DeviceRepo) GetByIdentityHash(ctx context.Context, hash string) (*domai
 	return d, nil
 }
 
-func (r *DeviceRepo) List(ctx context.Context, f domain.DeviceFilter) ([]*domain.Device, int, error) {
+func (r *DeviceRepo) List(ctx context.Context, orgID uuid.UUID, f domain.DeviceFilter) ([]*domain.Device, int, error) {
 	if f.Page < 1 {
 		f.Page = 1
 	}
@@ -124,9 +109,9 @@ func (r *DeviceRepo) List(ctx context.Context, f domain.DeviceFilter) ([]*domain
 		f.SortOrder = "desc"
 	}
 
-	where := "WHERE 1=1"
-	args := []interface{}{}
-	argIdx := 1
+	where := "WHERE organization_id = $1"
+	args := []interface{}{orgID}
+	argIdx := 2
 
 	if f.Status != nil {
 		where += fmt.Sprintf(" AND status = $%d", argIdx)
@@ -162,8 +147,8 @@ func (r *DeviceRepo) List(ctx context.Context, f domain.DeviceFilter) ([]*domain
 
 	offset := (f.Page - 1) * f.PerPage
 	query := fmt.Sprintf(`
-		SELECT id, identity_hash, identity_data, status, inventory,
-		       device_type, tags, last_check_in, created_at, updated_at
+		SELECT id, organization_id, identity_hash, identity_data, status, inventory,
+		       device_type, tags, encryption_key, last_check_in, inventory_updated_at, created_at, updated_at
 		FROM devices %s
 		ORDER BY %s %s
 		LIMIT $%d OFFSET $%d
@@ -181,8 +166,8 @@ func (r *DeviceRepo) List(ctx context.Context, f domain.DeviceFilter) ([]*domain
 		d := &domain.Device{}
 		var identityJSON, inventoryJSON []byte
 		if err := rows.Scan(
-			&d.ID, &d.IdentityHash, &identityJSON, &d.Status, &inventoryJSON,
-			&d.DeviceType, &d.Tags, &d.LastCheckIn, &d.CreatedAt, &d.UpdatedAt,
+			&d.ID, &d.OrgID, &d.IdentityHash, &identityJSON, &d.Status, &inventoryJSON,
+			&d.DeviceType, &d.Tags, &d.EncryptionKey, &d.LastCheckIn, &d.InventoryUpdatedAt, &d.CreatedAt, &d.UpdatedAt,
 		); err != nil {
 			return nil, 0, fmt.Errorf("scan device: %w", err)
 		}
@@ -201,10 +186,10 @@ func (r *DeviceRepo) List(ctx context.Context, f domain.DeviceFilter) ([]*domain
 	return devices, total, nil
 }
 
-func (r *DeviceRepo) UpdateStatus(ctx context.Context, id uuid.UUID, status domain.DeviceStatus) error {
+func (r *DeviceRepo) UpdateStatus(ctx context.Context, orgID, id uuid.UUID, status domain.DeviceStatus) error {
 	tag, err := r.pool.Exec(ctx, `
-		UPDATE devices SET status = $1, updated_at = NOW() WHERE id = $2
-	`, status, id)
+		UPDATE devices SET status = $1, updated_at = NOW() WHERE organization_id = $2 AND id = $3
+	`, status, orgID, id)
 	if err != nil {
 		return fmt.Errorf("update device status: %w", err)
 	}
@@ -214,10 +199,10 @@ func (r *DeviceRepo) UpdateStatus(ctx context.Context, id uuid.UUID, status doma
 	return nil
 }
 
-func (r *DeviceRepo) UpdateAuthToken(ctx context.Context, id uuid.UUID, tokenHash string) error {
+func (r *DeviceRepo) UpdateAuthToken(ctx context.Context, orgID, id uuid.UUID, tokenHash string) error {
 	tag, err := r.pool.Exec(ctx, `
-		UPDATE devices SET auth_token_hash = $1, updated_at = NOW() WHERE id = $2
-	`, tokenHash, id)
+		UPDATE devices SET auth_token_hash = $1, updated_at = NOW() WHERE organization_id = $2 AND id = $3
+	`, tokenHash, orgID, id)
 	if err != nil {
 		return fmt.Errorf("update auth token: %w", err)
 	}
@@ -227,14 +212,14 @@ func (r *DeviceRepo) UpdateAuthToken(ctx context.Context, id uuid.UUID, tokenHas
 	return nil
 }
 
-func (r *DeviceRepo) UpdateInventory(ctx context.Context, id uuid.UUID, inventory map[string]interface{}) error {
+func (r *DeviceRepo) UpdateInventory(ctx context.Context, orgID, id uuid.UUID, inventory map[string]interface{}) error {
 	inventoryJSON, err := json.Marshal(inventory)
 	if err != nil {
 		return fmt.Errorf("marshal inventory: %w", err)
 	}
 	tag, err := r.pool.Exec(ctx, `
-		UPDATE devices SET inventory = $1, updated_at = NOW() WHERE id = $2
-	`, inventoryJSON, id)
+		UPDATE devices SET inventory = $1, inventory_updated_at = NOW(), updated_at = NOW() WHERE organization_id = $2 AND id = $3
+	`, inventoryJSON, orgID, id)
 	if err != nil {
 		return fmt.Errorf("update inventory: %w", err)
 	}
@@ -244,10 +229,10 @@ func (r *DeviceRepo) UpdateInventory(ctx context.Context, id uuid.UUID, inventor
 	return nil
 }
 
-func (r *DeviceRepo) UpdateTags(ctx context.Context, id uuid.UUID, tags []string) error {
+func (r *DeviceRepo) UpdateTags(ctx context.Context, orgID, id uuid.UUID, tags []string) error {
 	tag, err := r.pool.Exec(ctx, `
-		UPDATE devices SET tags = $1, updated_at = NOW() WHERE id = $2
-	`, tags, id)
+		UPDATE devices SET tags = $1, updated_at = NOW() WHERE organization_id = $2 AND id = $3
+	`, tags, orgID, id)
 	if err != nil {
 		return fmt.Errorf("update tags: %w", err)
 	}
@@ -257,20 +242,33 @@ func (r *DeviceRepo) UpdateTags(ctx context.Context, id uuid.UUID, tags []string
 	return nil
 }
 
-func (r *DeviceRepo) UpdateLastCheckIn(ctx context.Context, id uuid.UUID) error {
+func (r *DeviceRepo) UpdateEncryptionKey(ctx context.Context, orgID, id uuid.UUID, key string) error {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE devices SET encryption_key = $1, updated_at = NOW() WHERE organization_id = $2 AND id = $3
+	`, key, orgID, id)
+	if err != nil {
+		return fmt.Errorf("update encryption key: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+func (r *DeviceRepo) UpdateLastCheckIn(ctx context.Context, orgID, id uuid.UUID) error {
 	_, err := r.pool.Exec(ctx, `
-		UPDATE devices SET last_check_in = NOW(), updated_at = NOW() WHERE id = $1
-	`, id)
+		UPDATE devices SET last_check_in = NOW(), updated_at = NOW() WHERE organization_id = $1 AND id = $2
+	`, orgID, id)
 	if err != nil {
 		return fmt.Errorf("update last check-in: %w", err)
 	}
 	return nil
 }
 
-func (r *DeviceRepo) Delete(ctx context.Context, id uuid.UUID) error {
+func (r *DeviceRepo) Delete(ctx context.Context, orgID, id uuid.UUID) error {
 	tag, err := r.pool.Exec(ctx, `
-		UPDATE devices SET status = 'decommissioned', updated_at = NOW() WHERE id = $1
-	`, id)
+		UPDATE devices SET status = 'decommissioned', updated_at = NOW() WHERE organization_id = $1 AND id = $2
+	`, orgID, id)
 	if err != nil {
 		return fmt.Errorf("decommission device: %w", err)
 	}
@@ -280,10 +278,10 @@ func (r *DeviceRepo) Delete(ctx context.Context, id uuid.UUID) error {
 	return nil
 }
 
-func (r *DeviceRepo) CountByStatus(ctx context.Context) (map[domain.DeviceStatus]int, error) {
+func (r *DeviceRepo) CountByStatus(ctx context.Context, orgID uuid.UUID) (map[domain.DeviceStatus]int, error) {
 	rows, err := r.pool.Query(ctx, `
-		SELECT status, COUNT(*) FROM devices GROUP BY status
-	`)
+		SELECT status, COUNT(*) FROM devices WHERE organization_id = $1 GROUP BY status
+	`, orgID)
 	if err != nil {
 		return nil, fmt.Errorf("count by status: %w", err)
 	}
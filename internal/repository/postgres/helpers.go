@@ -8,3 +8,8 @@ func isUniqueViolation(err error) bool {
 	return strings.Contains(err.Error(), "23505") ||
 		strings.Contains(err.Error(), "unique constraint")
 }
+
+func isForeignKeyViolation(err error) bool {
+	return strings.Contains(err.Error(), "23503") ||
+		strings.Contains(err.Error(), "foreign key constraint")
+}
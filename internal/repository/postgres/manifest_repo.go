@@ -0,0 +1,171 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+type ManifestRepo struct {
+	pool *pgxpool.Pool
+}
+
+func NewManifestRepo(pool *pgxpool.Pool) *ManifestRepo {
+	return &ManifestRepo{pool: pool}
+}
+
+const manifestColumns = `id, organization_id, name, description, device_tags, created_at, updated_at`
+
+func (r *ManifestRepo) Create(ctx context.Context, m *domain.Manifest) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO manifests (organization_id, name, description, device_tags)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, updated_at
+	`, m.OrgID, m.Name, m.Description, m.DeviceTags).Scan(&m.ID, &m.CreatedAt, &m.UpdatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return domain.ErrConflict
+		}
+		return fmt.Errorf("insert manifest: %w", err)
+	}
+	if err := insertManifestItems(ctx, tx, m.ID, m.Items); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func insertManifestItems(ctx context.Context, tx pgx.Tx, manifestID uuid.UUID, items []domain.ManifestItem) error {
+	for _, item := range items {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO manifest_items (manifest_id, artifact_id, deployment_id) VALUES ($1, $2, $3)
+		`, manifestID, item.ArtifactID, item.DeploymentID); err != nil {
+			return fmt.Errorf("insert manifest item: %w", err)
+		}
+	}
+	return nil
+}
+
+func (r *ManifestRepo) GetByID(ctx context.Context, orgID, id uuid.UUID) (*domain.Manifest, error) {
+	manifests, err := r.query(ctx, `
+		SELECT `+manifestColumns+` FROM manifests WHERE organization_id = $1 AND id = $2
+	`, orgID, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(manifests) == 0 {
+		return nil, domain.ErrNotFound
+	}
+	return manifests[0], nil
+}
+
+func (r *ManifestRepo) List(ctx context.Context, orgID uuid.UUID) ([]*domain.Manifest, error) {
+	return r.query(ctx, `
+		SELECT `+manifestColumns+` FROM manifests WHERE organization_id = $1 ORDER BY name
+	`, orgID)
+}
+
+func (r *ManifestRepo) ListByTags(ctx context.Context, orgID uuid.UUID, tags []string) ([]*domain.Manifest, error) {
+	if tags == nil {
+		tags = []string{}
+	}
+	return r.query(ctx, `
+		SELECT `+manifestColumns+` FROM manifests WHERE organization_id = $1 AND device_tags <@ $2 ORDER BY name
+	`, orgID, tags)
+}
+
+// query returns the manifests selected by sql, with their items.
+func (r *ManifestRepo) query(ctx context.Context, sql string, args ...interface{}) ([]*domain.Manifest, error) {
+	rows, err := r.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list manifests: %w", err)
+	}
+	defer rows.Close()
+
+	manifests := []*domain.Manifest{}
+	byID := make(map[uuid.UUID]*domain.Manifest)
+	var ids []uuid.UUID
+	for rows.Next() {
+		m := &domain.Manifest{Items: []domain.ManifestItem{}}
+		if err := rows.Scan(&m.ID, &m.OrgID, &m.Name, &m.Description, &m.DeviceTags, &m.CreatedAt, &m.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scan manifest: %w", err)
+		}
+		manifests = append(manifests, m)
+		byID[m.ID] = m
+		ids = append(ids, m.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return manifests, nil
+	}
+
+	itemRows, err := r.pool.Query(ctx, `
+		SELECT i.manifest_id, i.artifact_id, i.deployment_id
+		FROM manifest_items i
+		JOIN artifacts a ON a.id = i.artifact_id
+		WHERE i.manifest_id = ANY($1)
+		ORDER BY a.name
+	`, ids)
+	if err != nil {
+		return nil, fmt.Errorf("list manifest items: %w", err)
+	}
+	defer itemRows.Close()
+
+	for itemRows.Next() {
+		var manifestID uuid.UUID
+		var item domain.ManifestItem
+		if err := itemRows.Scan(&manifestID, &item.ArtifactID, &item.DeploymentID); err != nil {
+			return nil, fmt.Errorf("scan manifest item: %w", err)
+		}
+		byID[manifestID].Items = append(byID[manifestID].Items, item)
+	}
+	return manifests, itemRows.Err()
+}
+
+func (r *ManifestRepo) SetItems(ctx context.Context, orgID, id uuid.UUID, items []domain.ManifestItem) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE manifests SET updated_at = NOW() WHERE organization_id = $1 AND id = $2
+	`, orgID, id)
+	if err != nil {
+		return fmt.Errorf("update manifest: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrNotFound
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM manifest_items WHERE manifest_id = $1`, id); err != nil {
+		return fmt.Errorf("delete manifest items: %w", err)
+	}
+	if err := insertManifestItems(ctx, tx, id, items); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *ManifestRepo) Delete(ctx context.Context, orgID, id uuid.UUID) error {
+	tag, err := r.pool.Exec(ctx, `DELETE FROM manifests WHERE organization_id = $1 AND id = $2`, orgID, id)
+	if err != nil {
+		return fmt.Errorf("delete manifest: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
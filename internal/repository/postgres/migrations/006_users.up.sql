@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS users (
+    id             UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    email          VARCHAR(255) NOT NULL,
+    password_hash  VARCHAR(100) NOT NULL,
+    role           VARCHAR(20) NOT NULL DEFAULT 'viewer',  -- admin, operator, viewer
+    totp_secret    VARCHAR(64) DEFAULT '',
+    totp_enabled   BOOLEAN NOT NULL DEFAULT FALSE,
+    totp_last_step BIGINT NOT NULL DEFAULT 0,              -- last accepted TOTP step (replay protection)
+    recovery_codes TEXT[] DEFAULT '{}',                    -- SHA-256 hashes of unused recovery codes
+    totp_failures  INT NOT NULL DEFAULT 0,                 -- failed second-factor attempts since the last accepted one
+    totp_failed_at TIMESTAMPTZ,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users (LOWER(email));
+
+CREATE TABLE IF NOT EXISTS settings (
+    key         VARCHAR(100) PRIMARY KEY,
+    value       JSONB NOT NULL DEFAULT '{}',
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS mfa BOOLEAN NOT NULL DEFAULT FALSE;
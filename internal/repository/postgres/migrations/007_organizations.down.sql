@@ -0,0 +1,18 @@
+DROP INDEX IF EXISTS idx_audit_log_org;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS organization_id;
+
+DROP INDEX IF EXISTS idx_deployments_org;
+ALTER TABLE deployments DROP COLUMN IF EXISTS organization_id;
+
+ALTER TABLE artifacts DROP CONSTRAINT IF EXISTS artifacts_org_name_version_key;
+ALTER TABLE artifacts DROP COLUMN IF EXISTS organization_id;
+ALTER TABLE artifacts ADD CONSTRAINT artifacts_name_version_key UNIQUE (name, version);
+
+DROP INDEX IF EXISTS idx_devices_auth_token_hash;
+DROP INDEX IF EXISTS idx_devices_org;
+ALTER TABLE devices DROP CONSTRAINT IF EXISTS devices_org_identity_hash_key;
+ALTER TABLE devices DROP COLUMN IF EXISTS organization_id;
+ALTER TABLE devices ADD CONSTRAINT devices_identity_hash_key UNIQUE (identity_hash);
+
+DROP TABLE IF EXISTS organization_members;
+DROP TABLE IF EXISTS organizations;
@@ -0,0 +1,54 @@
+CREATE TABLE IF NOT EXISTS organizations (
+    id                UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name              VARCHAR(255) NOT NULL,
+    slug              VARCHAR(100) UNIQUE NOT NULL,
+    tenant_token_hash VARCHAR(64) UNIQUE,            -- SHA-256 of the device enrolment token
+    created_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at        TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Everything that existed before multi-tenancy belongs to the default organization
+INSERT INTO organizations (id, name, slug)
+VALUES ('00000000-0000-0000-0000-000000000001', 'Default', 'default')
+ON CONFLICT (id) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS organization_members (
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id         UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (organization_id, user_id)
+);
+
+CREATE INDEX idx_organization_members_user ON organization_members(user_id);
+
+INSERT INTO organization_members (organization_id, user_id)
+SELECT '00000000-0000-0000-0000-000000000001', id FROM users
+ON CONFLICT DO NOTHING;
+
+-- Devices: identity is unique per organization
+ALTER TABLE devices ADD COLUMN organization_id UUID NOT NULL
+    DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations(id);
+ALTER TABLE devices ALTER COLUMN organization_id DROP DEFAULT;
+ALTER TABLE devices DROP CONSTRAINT IF EXISTS devices_identity_hash_key;
+ALTER TABLE devices ADD CONSTRAINT devices_org_identity_hash_key UNIQUE (organization_id, identity_hash);
+CREATE INDEX idx_devices_org ON devices(organization_id);
+CREATE INDEX idx_devices_auth_token_hash ON devices(auth_token_hash);
+
+-- Artifacts: name/version is unique per organization
+ALTER TABLE artifacts ADD COLUMN organization_id UUID NOT NULL
+    DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations(id);
+ALTER TABLE artifacts ALTER COLUMN organization_id DROP DEFAULT;
+ALTER TABLE artifacts DROP CONSTRAINT IF EXISTS artifacts_name_version_key;
+ALTER TABLE artifacts ADD CONSTRAINT artifacts_org_name_version_key UNIQUE (organization_id, name, version);
+
+ALTER TABLE deployments ADD COLUMN organization_id UUID NOT NULL
+    DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organizations(id);
+ALTER TABLE deployments ALTER COLUMN organization_id DROP DEFAULT;
+CREATE INDEX idx_deployments_org ON deployments(organization_id);
+
+-- Audit entries without an organization are server-level events (users, policy, sessions)
+ALTER TABLE audit_log ADD COLUMN organization_id UUID REFERENCES organizations(id);
+UPDATE audit_log SET organization_id = '00000000-0000-0000-0000-000000000001'
+WHERE resource IN ('device', 'artifact', 'deployment');
+CREATE INDEX idx_audit_log_org ON audit_log(organization_id, created_at);
@@ -0,0 +1,4 @@
+ALTER TABLE artifacts DROP COLUMN IF EXISTS signing_key_id;
+ALTER TABLE artifacts DROP COLUMN IF EXISTS signature;
+
+DROP TABLE IF EXISTS signing_keys;
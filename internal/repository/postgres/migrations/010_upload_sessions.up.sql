@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS upload_sessions (
+    id              UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    metadata        JSONB NOT NULL,          -- artifact fields applied on completion
+    size            BIGINT NOT NULL,
+    upload_offset   BIGINT NOT NULL DEFAULT 0,
+    checksum_sha256 VARCHAR(64) NOT NULL DEFAULT '',
+    expires_at      TIMESTAMPTZ NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_upload_sessions_expires ON upload_sessions(expires_at);
+
+CREATE TABLE IF NOT EXISTS upload_chunks (
+    session_id      UUID NOT NULL REFERENCES upload_sessions(id) ON DELETE CASCADE,
+    chunk_offset    BIGINT NOT NULL,
+    size            BIGINT NOT NULL,
+    checksum_sha256 VARCHAR(64) NOT NULL,
+    storage_path    TEXT NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (session_id, chunk_offset)
+);
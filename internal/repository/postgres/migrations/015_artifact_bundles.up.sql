@@ -0,0 +1,6 @@
+ALTER TABLE artifacts
+    ADD COLUMN IF NOT EXISTS kind  VARCHAR(20) NOT NULL DEFAULT 'file',
+    ADD COLUMN IF NOT EXISTS files JSONB NOT NULL DEFAULT '[]';
+
+-- Finds the bundles holding a file when the scrubber quarantines its content
+CREATE INDEX IF NOT EXISTS idx_artifacts_files ON artifacts USING GIN (files jsonb_path_ops);
@@ -0,0 +1,3 @@
+ALTER TABLE artifacts
+    DROP COLUMN IF EXISTS entries,
+    DROP COLUMN IF EXISTS archive_format;
@@ -0,0 +1,3 @@
+-- Variables that template artifacts are rendered with
+ALTER TABLE deployments
+    ADD COLUMN IF NOT EXISTS variables JSONB NOT NULL DEFAULT '{}';
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS deployment_device_output;
+
+ALTER TABLE deployment_devices
+    DROP COLUMN IF EXISTS output_truncated,
+    DROP COLUMN IF EXISTS output_size,
+    DROP COLUMN IF EXISTS exit_code;
+
+ALTER TABLE artifacts
+    DROP COLUMN IF EXISTS script;
@@ -0,0 +1,19 @@
+-- How the agent runs script artifacts
+ALTER TABLE artifacts
+    ADD COLUMN IF NOT EXISTS script JSONB;
+
+ALTER TABLE deployment_devices
+    ADD COLUMN IF NOT EXISTS exit_code        INTEGER,
+    ADD COLUMN IF NOT EXISTS output_size      BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS output_truncated BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Output of script artifacts, in the order the agent sent it
+CREATE TABLE IF NOT EXISTS deployment_device_output (
+    seq                  BIGSERIAL PRIMARY KEY,
+    deployment_device_id UUID NOT NULL REFERENCES deployment_devices(id) ON DELETE CASCADE,
+    stream               VARCHAR(10) NOT NULL,
+    data                 BYTEA NOT NULL,
+    created_at           TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_dd_output ON deployment_device_output(deployment_device_id, seq);
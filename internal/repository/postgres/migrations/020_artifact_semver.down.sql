@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_artifacts_version_key;
+
+ALTER TABLE artifacts DROP COLUMN IF EXISTS version_key;
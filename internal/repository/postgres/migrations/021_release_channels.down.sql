@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS channel_promotions;
+
+DROP INDEX IF EXISTS idx_deployments_continuous;
+
+ALTER TABLE deployments
+    DROP COLUMN IF EXISTS channel_id,
+    DROP COLUMN IF EXISTS continuous;
+
+DROP TABLE IF EXISTS channels;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS manifest_items;
+ALTER TABLE deployments DROP COLUMN IF EXISTS manifest_id;
+DROP TABLE IF EXISTS manifests;
+ALTER TABLE devices DROP COLUMN IF EXISTS inventory_updated_at;
@@ -0,0 +1,32 @@
+-- Manifests declare the artifacts the devices with all of their device_tags
+-- should have installed. Each artifact converges through a deployment of
+-- the manifest that devices are added to when they report drift.
+CREATE TABLE IF NOT EXISTS manifests (
+    id              UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    name            VARCHAR(100) NOT NULL,
+    description     TEXT NOT NULL DEFAULT '',
+    device_tags     TEXT[] NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    UNIQUE (organization_id, name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_manifests_device_tags ON manifests USING GIN (device_tags);
+
+-- When a device last reported its inventory, apart from the other updates
+-- that change updated_at, to tell drift from reports made before an install
+ALTER TABLE devices
+    ADD COLUMN IF NOT EXISTS inventory_updated_at TIMESTAMPTZ;
+
+ALTER TABLE deployments
+    ADD COLUMN IF NOT EXISTS manifest_id UUID REFERENCES manifests(id) ON DELETE SET NULL;
+
+CREATE TABLE IF NOT EXISTS manifest_items (
+    manifest_id   UUID NOT NULL REFERENCES manifests(id) ON DELETE CASCADE,
+    artifact_id   UUID NOT NULL REFERENCES artifacts(id) ON DELETE RESTRICT,
+    deployment_id UUID REFERENCES deployments(id) ON DELETE SET NULL,
+
+    PRIMARY KEY (manifest_id, artifact_id)
+);
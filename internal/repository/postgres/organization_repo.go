@@ -0,0 +1,182 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+type OrganizationRepo struct {
+	pool *pgxpool.Pool
+}
+
+func NewOrganizationRepo(pool *pgxpool.Pool) *OrganizationRepo {
+	return &OrganizationRepo{pool: pool}
+}
+
+const organizationColumns = `o.id, o.name, o.slug, COALESCE(o.tenant_token_hash, ''), o.created_at, o.updated_at`
+
+func scanOrganization(row pgx.Row) (*domain.Organization, error) {
+	o := &domain.Organization{}
+	if err := row.Scan(&o.ID, &o.Name, &o.Slug, &o.TenantTokenHash, &o.CreatedAt, &o.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (r *OrganizationRepo) Create(ctx context.Context, o *domain.Organization) error {
+	err := r.pool.QueryRow(ctx, `
+		INSERT INTO organizations (name, slug, tenant_token_hash)
+		VALUES ($1, $2, NULLIF($3, ''))
+		RETURNING id, created_at, updated_at
+	`, o.Name, o.Slug, o.TenantTokenHash).Scan(&o.ID, &o.CreatedAt, &o.UpdatedAt)
+
+	if err != nil {
+		if isUniqueViolation(err) {
+			return domain.ErrConflict
+		}
+		return fmt.Errorf("insert organization: %w", err)
+	}
+	return nil
+}
+
+func (r *OrganizationRepo) GetByID(ctx context.Context, id uuid.UUID) (*domain.Organization, error) {
+	return r.getOne(ctx, `WHERE o.id = $1`, id)
+}
+
+func (r *OrganizationRepo) GetByTenantTokenHash(ctx context.Context, hash string) (*domain.Organization, error) {
+	return r.getOne(ctx, `WHERE o.tenant_token_hash = $1`, hash)
+}
+
+func (r *OrganizationRepo) getOne(ctx context.Context, where string, args ...interface{}) (*domain.Organization, error) {
+	o, err := scanOrganization(r.pool.QueryRow(ctx, `SELECT `+organizationColumns+` FROM organizations o `+where, args...))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, domain.ErrNotFound
+		}
+		return nil, fmt.Errorf("get organization: %w", err)
+	}
+	return o, nil
+}
+
+func (r *OrganizationRepo) List(ctx context.Context) ([]*domain.Organization, error) {
+	return r.list(ctx, `SELECT `+organizationColumns+` FROM organizations o ORDER BY o.name`)
+}
+
+func (r *OrganizationRepo) ListForUser(ctx context.Context, userID uuid.UUID) ([]*domain.Organization, error) {
+	return r.list(ctx, `
+		SELECT `+organizationColumns+`
+		FROM organizations o
+		JOIN organization_members m ON m.organization_id = o.id
+		WHERE m.user_id = $1
+		ORDER BY o.name
+	`, userID)
+}
+
+func (r *OrganizationRepo) list(ctx context.Context, query string, args ...interface{}) ([]*domain.Organization, error) {
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list organizations: %w", err)
+	}
+	defer rows.Close()
+
+	var orgs []*domain.Organization
+	for rows.Next() {
+		o, err := scanOrganization(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan organization: %w", err)
+		}
+		orgs = append(orgs, o)
+	}
+
+	if orgs == nil {
+		orgs = []*domain.Organization{}
+	}
+
+	return orgs, nil
+}
+
+func (r *OrganizationRepo) UpdateTenantToken(ctx context.Context, id uuid.UUID, tokenHash string) error {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE organizations SET tenant_token_hash = $1, updated_at = NOW() WHERE id = $2
+	`, tokenHash, id)
+	if err != nil {
+		return fmt.Errorf("update tenant token: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+func (r *OrganizationRepo) AddMember(ctx context.Context, orgID, userID uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO organization_members (organization_id, user_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`, orgID, userID)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return domain.ErrNotFound
+		}
+		return fmt.Errorf("add organization member: %w", err)
+	}
+	return nil
+}
+
+func (r *OrganizationRepo) RemoveMember(ctx context.Context, orgID, userID uuid.UUID) error {
+	tag, err := r.pool.Exec(ctx, `
+		DELETE FROM organization_members WHERE organization_id = $1 AND user_id = $2
+	`, orgID, userID)
+	if err != nil {
+		return fmt.Errorf("remove organization member: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+func (r *OrganizationRepo) ListMembers(ctx context.Context, orgID uuid.UUID) ([]*domain.User, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+userColumns+`
+		FROM users
+		WHERE id IN (SELECT user_id FROM organization_members WHERE organization_id = $1)
+		ORDER BY email
+	`, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("list organization members: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*domain.User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan user: %w", err)
+		}
+		users = append(users, u)
+	}
+
+	if users == nil {
+		users = []*domain.User{}
+	}
+
+	return users, nil
+}
+
+func (r *OrganizationRepo) IsMember(ctx context.Context, orgID, userID uuid.UUID) (bool, error) {
+	var member bool
+	err := r.pool.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM organization_members WHERE organization_id = $1 AND user_id = $2)
+	`, orgID, userID).Scan(&member)
+	if err != nil {
+		return false, fmt.Errorf("check organization member: %w", err)
+	}
+	return member, nil
+}
@@ -0,0 +1,104 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+// scrubReportsKept is how many scrub reports are kept; older ones are
+// deleted when a new report is stored.
+const scrubReportsKept = 30
+
+type ScrubRepo struct {
+	pool *pgxpool.Pool
+}
+
+func NewScrubRepo(pool *pgxpool.Pool) *ScrubRepo {
+	return &ScrubRepo{pool: pool}
+}
+
+func (r *ScrubRepo) CreateReport(ctx context.Context, report *domain.ScrubReport) error {
+	findingsJSON, err := json.Marshal(report.Findings)
+	if err != nil {
+		return fmt.Errorf("marshal findings: %w", err)
+	}
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO scrub_reports (started_at, finished_at, blobs_checked, bytes_checked, files_listed, findings)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`, report.StartedAt, report.FinishedAt, report.BlobsChecked, report.BytesChecked,
+		report.FilesListed, findingsJSON).Scan(&report.ID)
+	if err != nil {
+		return fmt.Errorf("insert scrub report: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `
+		DELETE FROM scrub_reports WHERE id NOT IN (
+			SELECT id FROM scrub_reports ORDER BY started_at DESC LIMIT $1
+		)
+	`, scrubReportsKept); err != nil {
+		return fmt.Errorf("prune scrub reports: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+func (r *ScrubRepo) LatestReport(ctx context.Context) (*domain.ScrubReport, error) {
+	report := &domain.ScrubReport{}
+	var findingsJSON []byte
+	err := r.pool.QueryRow(ctx, `
+		SELECT id, started_at, finished_at, blobs_checked, bytes_checked, files_listed, findings
+		FROM scrub_reports ORDER BY started_at DESC LIMIT 1
+	`).Scan(&report.ID, &report.StartedAt, &report.FinishedAt, &report.BlobsChecked,
+		&report.BytesChecked, &report.FilesListed, &findingsJSON)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, fmt.Errorf("get scrub report: %w", err)
+	}
+	if err := json.Unmarshal(findingsJSON, &report.Findings); err != nil {
+		return nil, fmt.Errorf("unmarshal findings: %w", err)
+	}
+	return report, nil
+}
+
+func (r *ScrubRepo) ReferencedPaths(ctx context.Context) ([]string, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT storage_path FROM blobs
+		UNION SELECT storage_path FROM artifacts
+		UNION SELECT storage_path FROM artifact_deltas
+		UNION SELECT storage_path FROM upload_chunks
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("list storage paths: %w", err)
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, fmt.Errorf("scan storage path: %w", err)
+		}
+		paths = append(paths, p)
+	}
+	return paths, rows.Err()
+}
@@ -0,0 +1,57 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+const securityPolicyKey = "security_policy"
+
+type SettingsRepo struct {
+	pool *pgxpool.Pool
+}
+
+func NewSettingsRepo(pool *pgxpool.Pool) *SettingsRepo {
+	return &SettingsRepo{pool: pool}
+}
+
+// GetSecurityPolicy returns the stored policy, or the zero policy if none
+// has been saved yet.
+func (r *SettingsRepo) GetSecurityPolicy(ctx context.Context) (*domain.SecurityPolicy, error) {
+	var raw []byte
+	err := r.pool.QueryRow(ctx, `SELECT value FROM settings WHERE key = $1`, securityPolicyKey).Scan(&raw)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return &domain.SecurityPolicy{}, nil
+		}
+		return nil, fmt.Errorf("get security policy: %w", err)
+	}
+
+	policy := &domain.SecurityPolicy{}
+	if err := json.Unmarshal(raw, policy); err != nil {
+		return nil, fmt.Errorf("unmarshal security policy: %w", err)
+	}
+	return policy, nil
+}
+
+func (r *SettingsRepo) UpdateSecurityPolicy(ctx context.Context, policy *domain.SecurityPolicy) error {
+	raw, err := json.Marshal(policy)
+	if err != nil {
+		return fmt.Errorf("marshal security policy: %w", err)
+	}
+
+	_, err = r.pool.Exec(ctx, `
+		INSERT INTO settings (key, value) VALUES ($1, $2)
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = NOW()
+	`, securityPolicyKey, raw)
+	if err != nil {
+		return fmt.Errorf("update security policy: %w", err)
+	}
+	return nil
+}
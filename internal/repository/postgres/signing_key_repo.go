@@ -0,0 +1,93 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+type SigningKeyRepo struct {
+	pool *pgxpool.Pool
+}
+
+func NewSigningKeyRepo(pool *pgxpool.Pool) *SigningKeyRepo {
+	return &SigningKeyRepo{pool: pool}
+}
+
+const signingKeyColumns = `id, organization_id, key_id, name, public_key, revoked_at, created_at`
+
+func scanSigningKey(row pgx.Row) (*domain.SigningKey, error) {
+	k := &domain.SigningKey{}
+	if err := row.Scan(&k.ID, &k.OrgID, &k.KeyID, &k.Name, &k.PublicKey, &k.RevokedAt, &k.CreatedAt); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (r *SigningKeyRepo) Create(ctx context.Context, k *domain.SigningKey) error {
+	err := r.pool.QueryRow(ctx, `
+		INSERT INTO signing_keys (organization_id, key_id, name, public_key)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`, k.OrgID, k.KeyID, k.Name, k.PublicKey).Scan(&k.ID, &k.CreatedAt)
+
+	if err != nil {
+		if isUniqueViolation(err) {
+			return domain.ErrConflict
+		}
+		return fmt.Errorf("insert signing key: %w", err)
+	}
+	return nil
+}
+
+func (r *SigningKeyRepo) GetByKeyID(ctx context.Context, orgID uuid.UUID, keyID string) (*domain.SigningKey, error) {
+	k, err := scanSigningKey(r.pool.QueryRow(ctx, `
+		SELECT `+signingKeyColumns+` FROM signing_keys WHERE organization_id = $1 AND key_id = $2
+	`, orgID, keyID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, domain.ErrNotFound
+		}
+		return nil, fmt.Errorf("get signing key: %w", err)
+	}
+	return k, nil
+}
+
+func (r *SigningKeyRepo) List(ctx context.Context, orgID uuid.UUID) ([]*domain.SigningKey, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+signingKeyColumns+` FROM signing_keys WHERE organization_id = $1 ORDER BY created_at
+	`, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("list signing keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []*domain.SigningKey{}
+	for rows.Next() {
+		k, err := scanSigningKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan signing key: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func (r *SigningKeyRepo) Revoke(ctx context.Context, orgID, id uuid.UUID) error {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE signing_keys SET revoked_at = NOW()
+		WHERE organization_id = $1 AND id = $2 AND revoked_at IS NULL
+	`, orgID, id)
+	if err != nil {
+		return fmt.Errorf("revoke signing key: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+type TokenRepo struct {
+	pool *pgxpool.Pool
+}
+
+func NewTokenRepo(pool *pgxpool.Pool) *TokenRepo {
+	return &TokenRepo{pool: pool}
+}
+
+func (r *TokenRepo) CreateRefreshToken(ctx context.Context, t *domain.RefreshToken) error {
+	err := r.pool.QueryRow(ctx, `
+		INSERT INTO refresh_tokens (user_id, family_id, mfa, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`, t.UserID, t.FamilyID, t.MFA, t.TokenHash, t.ExpiresAt).Scan(&t.ID, &t.CreatedAt)
+
+	if err != nil {
+		if isUniqueViolation(err) {
+			return domain.ErrConflict
+		}
+		return fmt.Errorf("insert refresh token: %w", err)
+	}
+	return nil
+}
+
+func (r *TokenRepo) GetRefreshTokenByHash(ctx context.Context, hash string) (*domain.RefreshToken, error) {
+	t := &domain.RefreshToken{}
+	err := r.pool.QueryRow(ctx, `
+		SELECT id, user_id, family_id, mfa, token_hash, expires_at, revoked_at, replaced_by, created_at
+		FROM refresh_tokens WHERE token_hash = $1
+	`, hash).Scan(
+		&t.ID, &t.UserID, &t.FamilyID, &t.MFA, &t.TokenHash,
+		&t.ExpiresAt, &t.RevokedAt, &t.ReplacedBy, &t.CreatedAt,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, domain.ErrNotFound
+		}
+		return nil, fmt.Errorf("get refresh token: %w", err)
+	}
+	return t, nil
+}
+
+// RotateRefreshToken revokes oldID and stores next in a single transaction.
+// It returns domain.ErrConflict if oldID was already revoked, which happens
+// when two requests race to use the same refresh token.
+func (r *TokenRepo) RotateRefreshToken(ctx context.Context, oldID uuid.UUID, next *domain.RefreshToken) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO refresh_tokens (user_id, family_id, mfa, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`, next.UserID, next.FamilyID, next.MFA, next.TokenHash, next.ExpiresAt).Scan(&next.ID, &next.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("insert refresh token: %w", err)
+	}
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE refresh_tokens SET revoked_at = NOW(), replaced_by = $1
+		WHERE id = $2 AND revoked_at IS NULL
+	`, next.ID, oldID)
+	if err != nil {
+		return fmt.Errorf("revoke refresh token: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrConflict
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+func (r *TokenRepo) RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE family_id = $1 AND revoked_at IS NULL
+	`, familyID)
+	if err != nil {
+		return fmt.Errorf("revoke refresh token family: %w", err)
+	}
+	return nil
+}
+
+func (r *TokenRepo) RevokeUserRefreshTokens(ctx context.Context, userID string) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("revoke user refresh tokens: %w", err)
+	}
+	return nil
+}
+
+func (r *TokenRepo) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO revoked_access_tokens (jti, expires_at)
+		VALUES ($1, $2)
+		ON CONFLICT (jti) DO NOTHING
+	`, jti, expiresAt)
+	if err != nil {
+		return fmt.Errorf("revoke access token: %w", err)
+	}
+	return nil
+}
+
+func (r *TokenRepo) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	var revoked bool
+	err := r.pool.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM revoked_access_tokens WHERE jti = $1)
+	`, jti).Scan(&revoked)
+	if err != nil {
+		return false, fmt.Errorf("check revoked access token: %w", err)
+	}
+	return revoked, nil
+}
+
+// DeleteExpired purges refresh tokens and denylist entries that can no
+// longer be presented because their expiry has passed.
+func (r *TokenRepo) DeleteExpired(ctx context.Context) (int, error) {
+	refresh, err := r.pool.Exec(ctx, `DELETE FROM refresh_tokens WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("delete expired refresh tokens: %w", err)
+	}
+	denied, err := r.pool.Exec(ctx, `DELETE FROM revoked_access_tokens WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("delete expired revoked tokens: %w", err)
+	}
+	return int(refresh.RowsAffected() + denied.RowsAffected()), nil
+}
@@ -0,0 +1,165 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+type UploadSessionRepo struct {
+	pool *pgxpool.Pool
+}
+
+func NewUploadSessionRepo(pool *pgxpool.Pool) *UploadSessionRepo {
+	return &UploadSessionRepo{pool: pool}
+}
+
+const uploadSessionColumns = `id, organization_id, metadata, size, upload_offset, checksum_sha256,
+	expires_at, created_at, updated_at`
+
+func scanUploadSession(row pgx.Row) (*domain.UploadSession, error) {
+	s := &domain.UploadSession{}
+	var metadata []byte
+	if err := row.Scan(
+		&s.ID, &s.OrgID, &metadata, &s.Size, &s.Offset, &s.ChecksumSHA256,
+		&s.ExpiresAt, &s.CreatedAt, &s.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(metadata, &s.Metadata); err != nil {
+		return nil, fmt.Errorf("unmarshal upload metadata: %w", err)
+	}
+	return s, nil
+}
+
+func (r *UploadSessionRepo) Create(ctx context.Context, s *domain.UploadSession) error {
+	metadata, err := json.Marshal(s.Metadata)
+	if err != nil {
+		return fmt.Errorf("marshal upload metadata: %w", err)
+	}
+
+	err = r.pool.QueryRow(ctx, `
+		INSERT INTO upload_sessions (organization_id, metadata, size, checksum_sha256, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, upload_offset, created_at, updated_at
+	`, s.OrgID, metadata, s.Size, s.ChecksumSHA256, s.ExpiresAt).Scan(&s.ID, &s.Offset, &s.CreatedAt, &s.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("insert upload session: %w", err)
+	}
+	return nil
+}
+
+func (r *UploadSessionRepo) GetByID(ctx context.Context, orgID, id uuid.UUID) (*domain.UploadSession, error) {
+	s, err := scanUploadSession(r.pool.QueryRow(ctx, `
+		SELECT `+uploadSessionColumns+` FROM upload_sessions WHERE organization_id = $1 AND id = $2
+	`, orgID, id))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, domain.ErrNotFound
+		}
+		return nil, fmt.Errorf("get upload session: %w", err)
+	}
+	return s, nil
+}
+
+func (r *UploadSessionRepo) AddChunk(ctx context.Context, orgID uuid.UUID, c *domain.UploadChunk, expiresAt time.Time) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE upload_sessions
+		SET upload_offset = upload_offset + $4, expires_at = $5, updated_at = NOW()
+		WHERE organization_id = $1 AND id = $2 AND upload_offset = $3 AND upload_offset + $4 <= size
+	`, orgID, c.SessionID, c.Offset, c.Size, expiresAt)
+	if err != nil {
+		return fmt.Errorf("advance upload offset: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrConflict
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO upload_chunks (session_id, chunk_offset, size, checksum_sha256, storage_path)
+		VALUES ($1, $2, $3, $4, $5)
+	`, c.SessionID, c.Offset, c.Size, c.ChecksumSHA256, c.StoragePath)
+	if err != nil {
+		return fmt.Errorf("insert upload chunk: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+func (r *UploadSessionRepo) ListChunks(ctx context.Context, sessionID uuid.UUID) ([]*domain.UploadChunk, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT session_id, chunk_offset, size, checksum_sha256, storage_path
+		FROM upload_chunks WHERE session_id = $1 ORDER BY chunk_offset
+	`, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("list upload chunks: %w", err)
+	}
+	defer rows.Close()
+
+	chunks := []*domain.UploadChunk{}
+	for rows.Next() {
+		c := &domain.UploadChunk{}
+		if err := rows.Scan(&c.SessionID, &c.Offset, &c.Size, &c.ChecksumSHA256, &c.StoragePath); err != nil {
+			return nil, fmt.Errorf("scan upload chunk: %w", err)
+		}
+		chunks = append(chunks, c)
+	}
+	return chunks, nil
+}
+
+func (r *UploadSessionRepo) Delete(ctx context.Context, orgID, id uuid.UUID) error {
+	tag, err := r.pool.Exec(ctx, `DELETE FROM upload_sessions WHERE organization_id = $1 AND id = $2`, orgID, id)
+	if err != nil {
+		return fmt.Errorf("delete upload session: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+func (r *UploadSessionRepo) ReservedAfter(ctx context.Context, s *domain.UploadSession) (int64, error) {
+	var total int64
+	if err := r.pool.QueryRow(ctx, `
+		SELECT COALESCE(SUM(size), 0) FROM upload_sessions WHERE (created_at, id) > ($1, $2)
+	`, s.CreatedAt, s.ID).Scan(&total); err != nil {
+		return 0, fmt.Errorf("sum reserved upload sizes: %w", err)
+	}
+	return total, nil
+}
+
+func (r *UploadSessionRepo) ListExpired(ctx context.Context, t time.Time) ([]*domain.UploadSession, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+uploadSessionColumns+` FROM upload_sessions WHERE expires_at < $1
+	`, t)
+	if err != nil {
+		return nil, fmt.Errorf("list expired upload sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []*domain.UploadSession{}
+	for rows.Next() {
+		s, err := scanUploadSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan upload session: %w", err)
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, nil
+}
@@ -0,0 +1,203 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+type UserRepo struct {
+	pool *pgxpool.Pool
+}
+
+func NewUserRepo(pool *pgxpool.Pool) *UserRepo {
+	return &UserRepo{pool: pool}
+}
+
+const userColumns = `id, email, password_hash, role, totp_secret, totp_enabled,
+		       totp_last_step, recovery_codes, totp_failures, totp_failed_at, created_at, updated_at`
+
+func scanUser(row pgx.Row) (*domain.User, error) {
+	u := &domain.User{}
+	err := row.Scan(
+		&u.ID, &u.Email, &u.PasswordHash, &u.Role, &u.TOTPSecret, &u.TOTPEnabled,
+		&u.TOTPLastStep, &u.RecoveryCodeHashes, &u.TOTPFailures, &u.TOTPFailedAt, &u.CreatedAt, &u.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if u.RecoveryCodeHashes == nil {
+		u.RecoveryCodeHashes = []string{}
+	}
+	return u, nil
+}
+
+func (r *UserRepo) Create(ctx context.Context, u *domain.User) error {
+	err := r.pool.QueryRow(ctx, `
+		INSERT INTO users (email, password_hash, role)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, updated_at
+	`, u.Email, u.PasswordHash, u.Role).Scan(&u.ID, &u.CreatedAt, &u.UpdatedAt)
+
+	if err != nil {
+		if isUniqueViolation(err) {
+			return domain.ErrConflict
+		}
+		return fmt.Errorf("insert user: %w", err)
+	}
+	return nil
+}
+
+func (r *UserRepo) GetByID(ctx context.Context, id uuid.UUID) (*domain.User, error) {
+	u, err := scanUser(r.pool.QueryRow(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1`, id))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, domain.ErrNotFound
+		}
+		return nil, fmt.Errorf("get user: %w", err)
+	}
+	return u, nil
+}
+
+func (r *UserRepo) GetByEmail(ctx context.Context, email string) (*domain.User, error) {
+	u, err := scanUser(r.pool.QueryRow(ctx, `SELECT `+userColumns+` FROM users WHERE LOWER(email) = LOWER($1)`, email))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, domain.ErrNotFound
+		}
+		return nil, fmt.Errorf("get user by email: %w", err)
+	}
+	return u, nil
+}
+
+func (r *UserRepo) List(ctx context.Context) ([]*domain.User, error) {
+	rows, err := r.pool.Query(ctx, `SELECT `+userColumns+` FROM users ORDER BY email`)
+	if err != nil {
+		return nil, fmt.Errorf("list users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*domain.User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan user: %w", err)
+		}
+		users = append(users, u)
+	}
+
+	if users == nil {
+		users = []*domain.User{}
+	}
+
+	return users, nil
+}
+
+func (r *UserRepo) Count(ctx context.Context) (int, error) {
+	var n int
+	if err := r.pool.QueryRow(ctx, `SELECT COUNT(*) FROM users`).Scan(&n); err != nil {
+		return 0, fmt.Errorf("count users: %w", err)
+	}
+	return n, nil
+}
+
+func (r *UserRepo) UpdateRole(ctx context.Context, id uuid.UUID, role domain.Role) error {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE users SET role = $1, updated_at = NOW() WHERE id = $2
+	`, role, id)
+	if err != nil {
+		return fmt.Errorf("update role: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+func (r *UserRepo) UpdatePassword(ctx context.Context, id uuid.UUID, passwordHash string) error {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE users SET password_hash = $1, updated_at = NOW() WHERE id = $2
+	`, passwordHash, id)
+	if err != nil {
+		return fmt.Errorf("update password: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+func (r *UserRepo) UpdateTOTP(ctx context.Context, id uuid.UUID, secret string, enabled bool, recoveryCodeHashes []string) error {
+	if recoveryCodeHashes == nil {
+		recoveryCodeHashes = []string{}
+	}
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE users SET totp_secret = $1, totp_enabled = $2, recovery_codes = $3,
+		       totp_last_step = 0, totp_failures = 0, totp_failed_at = NULL, updated_at = NOW()
+		WHERE id = $4
+	`, secret, enabled, recoveryCodeHashes, id)
+	if err != nil {
+		return fmt.Errorf("update totp: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+func (r *UserRepo) AdvanceTOTPStep(ctx context.Context, id uuid.UUID, step int64) (bool, error) {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE users SET totp_last_step = $1 WHERE id = $2 AND totp_last_step < $1
+	`, step, id)
+	if err != nil {
+		return false, fmt.Errorf("advance totp step: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *UserRepo) ConsumeRecoveryCode(ctx context.Context, id uuid.UUID, codeHash string) (bool, error) {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE users SET recovery_codes = array_remove(recovery_codes, $1), updated_at = NOW()
+		WHERE id = $2 AND $1 = ANY(recovery_codes)
+	`, codeHash, id)
+	if err != nil {
+		return false, fmt.Errorf("consume recovery code: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *UserRepo) RecordTOTPFailure(ctx context.Context, id uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE users SET totp_failures = totp_failures + 1, totp_failed_at = NOW() WHERE id = $1
+	`, id)
+	if err != nil {
+		return fmt.Errorf("record totp failure: %w", err)
+	}
+	return nil
+}
+
+func (r *UserRepo) ResetTOTPFailures(ctx context.Context, id uuid.UUID) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE users SET totp_failures = 0, totp_failed_at = NULL WHERE id = $1 AND totp_failures > 0
+	`, id)
+	if err != nil {
+		return fmt.Errorf("reset totp failures: %w", err)
+	}
+	return nil
+}
+
+func (r *UserRepo) Delete(ctx context.Context, id uuid.UUID) error {
+	tag, err := r.pool.Exec(ctx, `DELETE FROM users WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("delete user: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
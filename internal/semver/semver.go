@@ -0,0 +1,164 @@
+// Package semver parses and orders artifact versions following Semantic
+// Versioning 2.0.0 (https://semver.org): MAJOR.MINOR.PATCH, optionally
+// followed by -PRERELEASE and +BUILD.
+package semver
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalid is wrapped by every error returned by Parse.
+var ErrInvalid = errors.New("invalid semantic version")
+
+// Version is a parsed semantic version.
+type Version struct {
+	Major, Minor, Patch uint64
+	// Prerelease holds the dot-separated identifiers after "-"
+	Prerelease []string
+	// Build is the metadata after "+". It does not affect precedence.
+	Build string
+}
+
+// Parse parses s as a semantic version. A leading "v" is not accepted.
+func Parse(s string) (Version, error) {
+	var v Version
+	rest := s
+	if i := strings.IndexByte(rest, '+'); i >= 0 {
+		v.Build = rest[i+1:]
+		rest = rest[:i]
+		if err := checkIdentifiers(v.Build, false); err != nil {
+			return Version{}, fmt.Errorf("%w: %q: build %v", ErrInvalid, s, err)
+		}
+	}
+	if i := strings.IndexByte(rest, '-'); i >= 0 {
+		pre := rest[i+1:]
+		rest = rest[:i]
+		if err := checkIdentifiers(pre, true); err != nil {
+			return Version{}, fmt.Errorf("%w: %q: prerelease %v", ErrInvalid, s, err)
+		}
+		v.Prerelease = strings.Split(pre, ".")
+	}
+
+	core := strings.Split(rest, ".")
+	if len(core) != 3 {
+		return Version{}, fmt.Errorf("%w: %q: expected MAJOR.MINOR.PATCH", ErrInvalid, s)
+	}
+	nums := [3]*uint64{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range core {
+		n, err := parseNumber(part)
+		if err != nil {
+			return Version{}, fmt.Errorf("%w: %q: %v", ErrInvalid, s, err)
+		}
+		*nums[i] = n
+	}
+	return v, nil
+}
+
+// checkIdentifiers checks the dot-separated identifiers of a prerelease or
+// build. Numeric prerelease identifiers may not have leading zeros.
+func checkIdentifiers(s string, prerelease bool) error {
+	if s == "" {
+		return errors.New("is empty")
+	}
+	for _, id := range strings.Split(s, ".") {
+		if id == "" {
+			return errors.New("has an empty identifier")
+		}
+		for _, c := range id {
+			if !isAlnum(c) && c != '-' {
+				return fmt.Errorf("identifier %q has invalid characters", id)
+			}
+		}
+		if prerelease && isNumeric(id) {
+			if _, err := parseNumber(id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func parseNumber(s string) (uint64, error) {
+	if s == "" || !isNumeric(s) {
+		return 0, fmt.Errorf("%q is not a number", s)
+	}
+	if len(s) > 1 && s[0] == '0' {
+		return 0, fmt.Errorf("%q has a leading zero", s)
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is too large", s)
+	}
+	return n, nil
+}
+
+func isNumeric(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlnum(c rune) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// IsPrerelease reports whether v has prerelease identifiers.
+func (v Version) IsPrerelease() bool {
+	return len(v.Prerelease) > 0
+}
+
+// String formats v back to its canonical form.
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.IsPrerelease() {
+		s += "-" + strings.Join(v.Prerelease, ".")
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// Compare returns -1, 0 or +1 as v has lower, equal or higher precedence
+// than w. Build metadata is ignored.
+func (v Version) Compare(w Version) int {
+	return strings.Compare(v.Key(), w.Key())
+}
+
+// Key returns a string whose byte order is the precedence order of versions,
+// so that versions can be sorted by a database. Versions that differ only in
+// build metadata have the same key.
+//
+// The core numbers are zero-padded to 20 digits. A release is followed by
+// "~", which sorts after the "-" that starts a prerelease. Prerelease
+// identifiers are separated by " ", which sorts before every identifier
+// character, so that a shorter list of identifiers sorts first. Numeric
+// identifiers are encoded as "0", their length in two digits and the
+// number, and sort before alphanumeric ones, encoded as "1" and the
+// identifier.
+func (v Version) Key() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%020d.%020d.%020d", v.Major, v.Minor, v.Patch)
+	if !v.IsPrerelease() {
+		b.WriteByte('~')
+		return b.String()
+	}
+	b.WriteByte('-')
+	for i, id := range v.Prerelease {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		if isNumeric(id) {
+			fmt.Fprintf(&b, "0%02d%s", len(id), id)
+		} else {
+			b.WriteString("1" + id)
+		}
+	}
+	return b.String()
+}
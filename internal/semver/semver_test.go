@@ -0,0 +1,66 @@
+package semver
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	v, err := Parse("1.12.3-rc.1+build.7")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if v.Major != 1 || v.Minor != 12 || v.Patch != 3 || len(v.Prerelease) != 2 || v.Build != "build.7" {
+		t.Errorf("unexpected version %+v", v)
+	}
+	if v.String() != "1.12.3-rc.1+build.7" {
+		t.Errorf("String() = %q", v.String())
+	}
+
+	for _, s := range []string{
+		"", "1", "1.2", "1.2.3.4", "v1.2.3", "01.2.3", "1.2.x", "1.2.3-", "1.2.3-rc..1",
+		"1.2.3-01", "1.2.3+", "1.2.3-rc_1", "18446744073709551616.0.0", " 1.2.3",
+	} {
+		if _, err := Parse(s); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Parse(%q): expected ErrInvalid, got %v", s, err)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	// In increasing precedence, from the semver specification
+	ordered := []string{
+		"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2",
+		"1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.2.0", "1.10.0", "2.0.0-0", "2.0.0",
+	}
+	for i := range ordered {
+		for j := range ordered {
+			a, b := mustParse(t, ordered[i]), mustParse(t, ordered[j])
+			want := 0
+			if i < j {
+				want = -1
+			} else if i > j {
+				want = 1
+			}
+			if got := a.Compare(b); got != want {
+				t.Errorf("Compare(%s, %s) = %d, want %d", ordered[i], ordered[j], got, want)
+			}
+		}
+	}
+
+	if mustParse(t, "1.0.0+a").Compare(mustParse(t, "1.0.0+b")) != 0 {
+		t.Error("build metadata should not affect precedence")
+	}
+	if mustParse(t, "1.0.0-a").Compare(mustParse(t, "1.0.0-a-b")) != -1 {
+		t.Error("expected 1.0.0-a < 1.0.0-a-b")
+	}
+}
+
+func mustParse(t *testing.T, s string) Version {
+	t.Helper()
+	v, err := Parse(s)
+	if err != nil {
+		t.Fatalf("parse %q: %v", s, err)
+	}
+	return v
+}
@@ -1,30 +1,53 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdh"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"log/slog"
+	"path"
+	"regexp"
+	"strings"
 
 	"github.com/google/uuid"
 
+	"github.com/CaioWing/Harbor/internal/archive"
 	"github.com/CaioWing/Harbor/internal/domain"
+	"github.com/CaioWing/Harbor/internal/render"
+	"github.com/CaioWing/Harbor/internal/semver"
 	"github.com/CaioWing/Harbor/internal/storage"
+	"github.com/CaioWing/Harbor/internal/storage/encrypted"
 )
 
 type ArtifactService struct {
-	repo  domain.ArtifactRepository
-	store storage.FileStore
+	repo          domain.ArtifactRepository
+	blobs         domain.BlobRepository
+	store         storage.FileStore
+	signing       *SigningService
+	deltas        *DeltaService
+	validation    *ValidationService
+	archiveLimits archive.Limits
+	// quota bounds the size of the stored files of every organization; 0
+	// leaves it unbounded
+	quota int64
 	log   *slog.Logger
 }
 
-func NewArtifactService(repo domain.ArtifactRepository, store storage.FileStore, log *slog.Logger) *ArtifactService {
-	return &ArtifactService{repo: repo, store: store, log: log}
+func NewArtifactService(repo domain.ArtifactRepository, blobs domain.BlobRepository, store storage.FileStore, signing *SigningService, deltas *DeltaService, validation *ValidationService, archiveLimits archive.Limits, quota int64, log *slog.Logger) *ArtifactService {
+	return &ArtifactService{repo: repo, blobs: blobs, store: store, signing: signing, deltas: deltas, validation: validation, archiveLimits: archiveLimits, quota: quota, log: log}
 }
 
 type CreateArtifactInput struct {
+	OrgID uuid.UUID
+	// Kind is ArtifactKindFile (the default), ArtifactKindArchive,
+	// ArtifactKindTemplate or ArtifactKindScript
+	Kind           domain.ArtifactKind
 	Name           string
 	Version        string
 	Description    string
@@ -36,72 +59,659 @@ type CreateArtifactInput struct {
 	PreInstallCmd  string
 	PostInstallCmd string
 	RollbackCmd    string
-	File           io.Reader
+	Signature      string
+	SigningKeyID   string
+	ChecksumSHA256 string
+	// EncryptForDevice delivers the file encrypted for each device's key
+	EncryptForDevice bool
+	// Script says how a script artifact is run; defaults apply when nil
+	Script       *domain.ScriptSpec
+	Requirements *domain.Requirements
+	File         io.Reader
+	// Size is the size of File when known, to refuse before storing it a
+	// file over the quota
+	Size int64
+
+	// reserved is how much of the storage usage File already accounts for,
+	// as the chunks of the upload session it is read from
+	reserved int64
 }
 
+// Create stores the artifact file. A non-empty ChecksumSHA256 must match the
+// file, and content the organization already stores is not stored again.
+// Invalid input fails with ErrInvalidInput; the upload checks do not, their
+// results are kept with the artifact.
 func (s *ArtifactService) Create(ctx context.Context, input CreateArtifactInput) (*domain.Artifact, error) {
-	if input.Name == "" || input.Version == "" || input.TargetPath == "" {
-		return nil, fmt.Errorf("%w: name, version, and target_path are required", domain.ErrInvalidInput)
+	if err := validateArtifactFields(input.Kind, input.Name, input.Version, input.TargetPath, input.DeviceTypes); err != nil {
+		return nil, err
+	}
+	switch input.Kind {
+	case "":
+		input.Kind = domain.ArtifactKindFile
+	case domain.ArtifactKindFile, domain.ArtifactKindTemplate:
+	case domain.ArtifactKindArchive:
+		if input.FileMode == "" {
+			input.FileMode = "0755"
+		}
+	case domain.ArtifactKindScript:
+		if input.Script == nil {
+			input.Script = &domain.ScriptSpec{}
+		}
+		if err := validateScript(input.Script); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: kind must be file, archive, template or script", domain.ErrInvalidInput)
 	}
-	if len(input.DeviceTypes) == 0 {
-		return nil, fmt.Errorf("%w: at least one device_type is required", domain.ErrInvalidInput)
+	if input.Script != nil && input.Kind != domain.ArtifactKindScript {
+		return nil, fmt.Errorf("%w: script settings are only used by script artifacts", domain.ErrInvalidInput)
+	}
+	if err := validateRequirements(input.Requirements); err != nil {
+		return nil, err
 	}
 	if input.FileMode == "" {
 		input.FileMode = "0644"
 	}
 
-	// Hash the file while saving
-	hasher := sha256.New()
-	tee := io.TeeReader(input.File, hasher)
+	blob, err := s.storeBlob(ctx, input.OrgID, input.File, input.ChecksumSHA256, input.Size, input.reserved)
+	if err != nil {
+		return nil, err
+	}
 
-	storageName := fmt.Sprintf("%s_%s_%s", input.Name, input.Version, input.FileName)
-	storagePath, fileSize, err := s.store.Save(storageName, tee)
+	var format string
+	var entries []domain.ArchiveEntry
+	switch input.Kind {
+	case domain.ArtifactKindArchive:
+		format, entries, err = s.inspectArchive(blob.StoragePath)
+	case domain.ArtifactKindTemplate:
+		_, err = s.readTemplate(blob.StoragePath)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("save file: %w", err)
+		s.releaseBlob(ctx, blob)
+		return nil, err
 	}
 
-	checksum := hex.EncodeToString(hasher.Sum(nil))
+	digest, _ := hex.DecodeString(blob.ChecksumSHA256)
+	signature, keyID, err := s.signing.SignArtifact(ctx, input.OrgID, digest, input.Signature, input.SigningKeyID)
+	if err != nil {
+		s.releaseBlob(ctx, blob)
+		return nil, err
+	}
+
+	artifact := &domain.Artifact{
+		OrgID:            input.OrgID,
+		Kind:             input.Kind,
+		Name:             input.Name,
+		Version:          input.Version,
+		Description:      input.Description,
+		FileName:         input.FileName,
+		FileSize:         blob.Size,
+		ChecksumSHA256:   blob.ChecksumSHA256,
+		TargetPath:       input.TargetPath,
+		FileMode:         input.FileMode,
+		FileOwner:        input.FileOwner,
+		DeviceTypes:      input.DeviceTypes,
+		StoragePath:      blob.StoragePath,
+		PreInstallCmd:    input.PreInstallCmd,
+		PostInstallCmd:   input.PostInstallCmd,
+		RollbackCmd:      input.RollbackCmd,
+		Signature:        signature,
+		SigningKeyID:     keyID,
+		EncryptForDevice: input.EncryptForDevice,
+		Script:           input.Script,
+		Requirements:     input.Requirements,
+		ArchiveFormat:    format,
+		Entries:          entries,
+	}
+	if artifact.Validation, err = s.validation.Validate(ctx, artifact); err != nil {
+		s.releaseBlob(ctx, blob)
+		return nil, fmt.Errorf("validate artifact: %w", err)
+	}
+
+	if err := s.repo.Create(ctx, artifact); err != nil {
+		s.releaseBlob(ctx, blob)
+		return nil, fmt.Errorf("create artifact: %w", err)
+	}
+
+	s.log.Info("artifact created", "id", artifact.ID, "name", artifact.Name, "version", artifact.Version)
+
+	// Deltas from earlier versions are generated in the background
+	s.deltas.Schedule(artifact)
+	return artifact, nil
+}
+
+// inspectArchive validates a stored archive and lists its entries. It fails
+// with ErrInvalidInput if an entry could not be extracted safely.
+func (s *ArtifactService) inspectArchive(path string) (string, []domain.ArchiveEntry, error) {
+	reader, err := s.store.Open(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("open archive: %w", err)
+	}
+	defer reader.Close()
+
+	format, entries, err := archive.Inspect(reader, s.archiveLimits)
+	if errors.Is(err, archive.ErrInvalid) {
+		return "", nil, fmt.Errorf("%w: %v", domain.ErrInvalidInput, err)
+	}
+	return format, entries, err
+}
+
+const (
+	defaultScriptInterpreter = "/bin/sh"
+	defaultScriptTimeout     = 300
+	maxScriptTimeout         = 24 * 60 * 60
+)
+
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateScript checks how a script is run and fills in the default
+// interpreter and timeout.
+func validateScript(spec *domain.ScriptSpec) error {
+	if spec.Interpreter == "" {
+		spec.Interpreter = defaultScriptInterpreter
+	}
+	if spec.TimeoutSec == 0 {
+		spec.TimeoutSec = defaultScriptTimeout
+	}
+	if !path.IsAbs(spec.Interpreter) || strings.ContainsAny(spec.Interpreter, " \t\r\n\x00") {
+		return fmt.Errorf("%w: interpreter must be an absolute path", domain.ErrInvalidInput)
+	}
+	if spec.TimeoutSec < 0 || spec.TimeoutSec > maxScriptTimeout {
+		return fmt.Errorf("%w: timeout_sec must be between 1 and %d", domain.ErrInvalidInput, maxScriptTimeout)
+	}
+	if spec.WorkingDir != "" && (!path.IsAbs(spec.WorkingDir) || strings.ContainsAny(spec.WorkingDir, "\r\n\x00")) {
+		return fmt.Errorf("%w: working_dir must be an absolute path", domain.ErrInvalidInput)
+	}
+	for name, value := range spec.Env {
+		if !envName.MatchString(name) || strings.ContainsRune(value, 0) {
+			return fmt.Errorf("%w: invalid environment variable %q", domain.ErrInvalidInput, name)
+		}
+	}
+	return nil
+}
+
+// readTemplate reads a stored template and fails with ErrInvalidInput if it
+// does not parse.
+func (s *ArtifactService) readTemplate(path string) ([]byte, error) {
+	reader, err := s.store.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open template: %w", err)
+	}
+	defer reader.Close()
+
+	src, err := io.ReadAll(io.LimitReader(reader, render.MaxTemplateSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("read template: %w", err)
+	}
+	if _, err := render.Parse(src); err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrInvalidInput, err)
+	}
+	return src, nil
+}
+
+// BundleFileInput is one file of a bundle to create.
+type BundleFileInput struct {
+	FileName   string
+	TargetPath string
+	FileMode   string
+	FileOwner  string
+	File       io.Reader
+	// Size is the size of File when known
+	Size int64
+}
+
+type CreateBundleInput struct {
+	OrgID          uuid.UUID
+	Name           string
+	Version        string
+	Description    string
+	DeviceTypes    []string
+	PreInstallCmd  string
+	PostInstallCmd string
+	RollbackCmd    string
+	Signature      string
+	SigningKeyID   string
+	Requirements   *domain.Requirements
+	Files          []BundleFileInput
+}
+
+// CreateBundle stores a bundle artifact, whose files the agent installs as
+// one update with the bundle's hooks. Each file is stored as the file of a
+// single-file artifact would be. The bundle checksum, and its signature, are
+// computed over the bundle manifest (see bundleDigest).
+func (s *ArtifactService) CreateBundle(ctx context.Context, input CreateBundleInput) (*domain.Artifact, error) {
+	if len(input.Files) == 0 {
+		return nil, fmt.Errorf("%w: a bundle needs at least one file", domain.ErrInvalidInput)
+	}
+	if err := validateRequirements(input.Requirements); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for i, f := range input.Files {
+		if err := validateArtifactFields(domain.ArtifactKindBundle, input.Name, input.Version, f.TargetPath, input.DeviceTypes); err != nil {
+			return nil, err
+		}
+		if seen[f.TargetPath] {
+			return nil, fmt.Errorf("%w: target_path %s appears more than once", domain.ErrInvalidInput, f.TargetPath)
+		}
+		seen[f.TargetPath] = true
+		// Each file is a line of the manifest the signature covers
+		if strings.ContainsAny(f.FileMode+f.FileOwner, " \t\r\n") || strings.ContainsAny(f.TargetPath, "\r\n") {
+			return nil, fmt.Errorf("%w: file_mode and file_owner cannot contain spaces, nor target_path line breaks", domain.ErrInvalidInput)
+		}
+		if f.FileMode == "" {
+			input.Files[i].FileMode = "0644"
+		}
+		if f.FileName == "" {
+			input.Files[i].FileName = path.Base(f.TargetPath)
+		}
+	}
+
+	var blobs []*domain.Blob
+	release := func() {
+		for _, b := range blobs {
+			s.releaseBlob(ctx, b)
+		}
+	}
+
+	files := make([]domain.ArtifactFile, 0, len(input.Files))
+	var total int64
+	for _, f := range input.Files {
+		blob, err := s.storeBlob(ctx, input.OrgID, f.File, "", f.Size, 0)
+		if err != nil {
+			release()
+			return nil, fmt.Errorf("%s: %w", f.FileName, err)
+		}
+		blobs = append(blobs, blob)
+		files = append(files, domain.ArtifactFile{
+			FileName:       f.FileName,
+			TargetPath:     f.TargetPath,
+			FileMode:       f.FileMode,
+			FileOwner:      f.FileOwner,
+			FileSize:       blob.Size,
+			ChecksumSHA256: blob.ChecksumSHA256,
+			StoragePath:    blob.StoragePath,
+		})
+		total += blob.Size
+	}
+
+	digest := bundleDigest(files)
+	signature, keyID, err := s.signing.SignArtifact(ctx, input.OrgID, digest, input.Signature, input.SigningKeyID)
+	if err != nil {
+		release()
+		return nil, err
+	}
 
 	artifact := &domain.Artifact{
+		OrgID:          input.OrgID,
+		Kind:           domain.ArtifactKindBundle,
 		Name:           input.Name,
 		Version:        input.Version,
 		Description:    input.Description,
-		FileName:       input.FileName,
-		FileSize:       fileSize,
-		ChecksumSHA256: checksum,
+		FileSize:       total,
+		ChecksumSHA256: hex.EncodeToString(digest),
+		DeviceTypes:    input.DeviceTypes,
+		PreInstallCmd:  input.PreInstallCmd,
+		PostInstallCmd: input.PostInstallCmd,
+		RollbackCmd:    input.RollbackCmd,
+		Signature:      signature,
+		SigningKeyID:   keyID,
+		Requirements:   input.Requirements,
+		Files:          files,
+	}
+	if artifact.Validation, err = s.validation.Validate(ctx, artifact); err != nil {
+		release()
+		return nil, fmt.Errorf("validate artifact: %w", err)
+	}
+	if err := s.repo.Create(ctx, artifact); err != nil {
+		release()
+		return nil, fmt.Errorf("create artifact: %w", err)
+	}
+
+	s.log.Info("bundle created", "id", artifact.ID, "name", artifact.Name, "version", artifact.Version, "files", len(files))
+	return artifact, nil
+}
+
+// bundleDigest is the SHA-256 of the bundle manifest: one line per file, in
+// order, of the form "<checksum_sha256> <file_mode> <file_owner> <target_path>\n".
+// Agents recompute it from the files they received to verify the signature.
+func bundleDigest(files []domain.ArtifactFile) []byte {
+	h := sha256.New()
+	for _, f := range files {
+		fmt.Fprintf(h, "%s %s %s %s\n", f.ChecksumSHA256, f.FileMode, f.FileOwner, f.TargetPath)
+	}
+	return h.Sum(nil)
+}
+
+type CreateOperationInput struct {
+	OrgID uuid.UUID
+	// Kind is one of the operation kinds, such as ArtifactKindDelete
+	Kind        domain.ArtifactKind
+	Name        string
+	Version     string
+	Description string
+	TargetPath  string
+	// SourcePath is required by rename and symlink operations
+	SourcePath     string
+	FileMode       string
+	FileOwner      string
+	DeviceTypes    []string
+	PreInstallCmd  string
+	PostInstallCmd string
+	RollbackCmd    string
+	Signature      string
+	SigningKeyID   string
+	Requirements   *domain.Requirements
+}
+
+// CreateOperation creates an artifact that deletes, renames, links or
+// creates a path on the device instead of writing a file. It is deployed and
+// tracked as any other artifact. The checksum, and the signature, are
+// computed over the operation manifest (see operationDigest).
+func (s *ArtifactService) CreateOperation(ctx context.Context, input CreateOperationInput) (*domain.Artifact, error) {
+	if err := validateArtifactFields(input.Kind, input.Name, input.Version, input.TargetPath, input.DeviceTypes); err != nil {
+		return nil, err
+	}
+	if err := validateOperation(&input); err != nil {
+		return nil, err
+	}
+	if err := validateRequirements(input.Requirements); err != nil {
+		return nil, err
+	}
+
+	digest := operationDigest(input.Kind, input.SourcePath, input.TargetPath, input.FileMode, input.FileOwner)
+	signature, keyID, err := s.signing.SignArtifact(ctx, input.OrgID, digest, input.Signature, input.SigningKeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	artifact := &domain.Artifact{
+		OrgID:          input.OrgID,
+		Kind:           input.Kind,
+		Name:           input.Name,
+		Version:        input.Version,
+		Description:    input.Description,
+		ChecksumSHA256: hex.EncodeToString(digest),
 		TargetPath:     input.TargetPath,
+		SourcePath:     input.SourcePath,
 		FileMode:       input.FileMode,
 		FileOwner:      input.FileOwner,
 		DeviceTypes:    input.DeviceTypes,
-		StoragePath:    storagePath,
 		PreInstallCmd:  input.PreInstallCmd,
 		PostInstallCmd: input.PostInstallCmd,
 		RollbackCmd:    input.RollbackCmd,
+		Signature:      signature,
+		SigningKeyID:   keyID,
+		Requirements:   input.Requirements,
 	}
-
 	if err := s.repo.Create(ctx, artifact); err != nil {
-		// Clean up stored file on DB error
-		s.store.Delete(storagePath)
 		return nil, fmt.Errorf("create artifact: %w", err)
 	}
 
-	s.log.Info("artifact created", "id", artifact.ID, "name", artifact.Name, "version", artifact.Version)
+	s.log.Info("operation created", "id", artifact.ID, "kind", artifact.Kind, "name", artifact.Name, "version", artifact.Version)
 	return artifact, nil
 }
 
-func (s *ArtifactService) GetByID(ctx context.Context, id uuid.UUID) (*domain.Artifact, error) {
-	return s.repo.GetByID(ctx, id)
+// validateOperation checks the paths of an operation and sets the default
+// mode of mkdir. Paths must be absolute and clean so that the agent acts on
+// exactly the path that was signed; only a symlink target may be relative.
+func validateOperation(input *CreateOperationInput) error {
+	absolute := func(field, p string) error {
+		if !path.IsAbs(p) || path.Clean(p) != p || p == "/" {
+			return fmt.Errorf("%w: %s must be a clean absolute path other than /", domain.ErrInvalidInput, field)
+		}
+		return nil
+	}
+	if strings.ContainsAny(input.TargetPath+input.SourcePath+input.FileMode+input.FileOwner, "\x00\r\n") {
+		return fmt.Errorf("%w: paths, file_mode and file_owner cannot contain line breaks or NUL", domain.ErrInvalidInput)
+	}
+	if err := absolute("target_path", input.TargetPath); err != nil {
+		return err
+	}
+
+	switch input.Kind {
+	case domain.ArtifactKindDelete:
+	case domain.ArtifactKindRename:
+		if err := absolute("source_path", input.SourcePath); err != nil {
+			return err
+		}
+		if input.SourcePath == input.TargetPath {
+			return fmt.Errorf("%w: source_path and target_path are the same", domain.ErrInvalidInput)
+		}
+	case domain.ArtifactKindSymlink:
+		if input.SourcePath == "" {
+			return fmt.Errorf("%w: source_path is required", domain.ErrInvalidInput)
+		}
+	case domain.ArtifactKindMkdir:
+		if input.FileMode == "" {
+			input.FileMode = "0755"
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: kind must be delete, rename, symlink or mkdir", domain.ErrInvalidInput)
+	}
+
+	if input.Kind == domain.ArtifactKindDelete && input.SourcePath != "" {
+		return fmt.Errorf("%w: source_path is only used by rename and symlink", domain.ErrInvalidInput)
+	}
+	if input.FileMode != "" || input.FileOwner != "" {
+		return fmt.Errorf("%w: file_mode and file_owner are only used by mkdir", domain.ErrInvalidInput)
+	}
+	return nil
 }
 
-func (s *ArtifactService) List(ctx context.Context, filter domain.ArtifactFilter) ([]*domain.Artifact, int, error) {
-	return s.repo.List(ctx, filter)
+// operationDigest is the SHA-256 of the operation manifest: the kind, source
+// path, target path, file mode and file owner, each followed by "\n". Agents
+// recompute it from the operation they received to verify the signature.
+func operationDigest(kind domain.ArtifactKind, sourcePath, targetPath, fileMode, fileOwner string) []byte {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n%s\n%s\n", kind, sourcePath, targetPath, fileMode, fileOwner)
+	return h.Sum(nil)
 }
 
-func (s *ArtifactService) OpenFile(ctx context.Context, id uuid.UUID) (io.ReadCloser, *domain.Artifact, error) {
-	artifact, err := s.repo.GetByID(ctx, id)
+// storeBlob stores file unless the organization already has the same content
+// and returns the blob with a reference taken for the caller. When expected is
+// set and the blob exists, the file is only read to verify it. With a quota,
+// a file of a known size that cannot fit is not stored, and others are only
+// stored up to the space left; either is then accepted only if it turns out
+// to be content the organization already has.
+func (s *ArtifactService) storeBlob(ctx context.Context, orgID uuid.UUID, file io.Reader, expected string, size, reserved int64) (*domain.Blob, error) {
+	expected = strings.ToLower(strings.TrimSpace(expected))
+	hasher := sha256.New()
+
+	if expected != "" {
+		blob, err := s.blobs.AddRef(ctx, orgID, expected)
+		if err == nil {
+			_, err := io.Copy(hasher, file)
+			if err == nil && hex.EncodeToString(hasher.Sum(nil)) == expected {
+				return blob, nil
+			}
+			s.releaseBlob(ctx, blob)
+			if err != nil {
+				return nil, fmt.Errorf("read file: %w", err)
+			}
+			return nil, fmt.Errorf("%w: file checksum %x does not match %s", domain.ErrInvalidInput, hasher.Sum(nil), expected)
+		}
+		if !errors.Is(err, domain.ErrNotFound) {
+			return nil, err
+		}
+	}
+
+	available := int64(-1)
+	if s.quota > 0 {
+		used, _, err := s.StorageUsage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		available = max(s.quota-(used-reserved), 0)
+	}
+
+	var path string
+	var overQuota error
+	if available >= 0 && size > available {
+		overQuota = fmt.Errorf("%w: %d bytes do not fit in the %d left", domain.ErrQuotaExceeded, size, available)
+	} else {
+		// The name only needs to be unique: blobs are found by checksum
+		var err error
+		path, size, err = s.store.Save(fmt.Sprintf("%s_%s", orgID, uuid.New()), quotaReader(io.TeeReader(file, hasher), available))
+		if errors.Is(err, domain.ErrQuotaExceeded) {
+			overQuota = err
+		} else if err != nil {
+			return nil, fmt.Errorf("save file: %w", err)
+		}
+	}
+	if overQuota != nil {
+		return s.existingBlob(ctx, orgID, file, hasher, expected, overQuota)
+	}
+
+	checksum := hex.EncodeToString(hasher.Sum(nil))
+	if expected != "" && expected != checksum {
+		s.store.Delete(path)
+		return nil, fmt.Errorf("%w: file checksum %s does not match %s", domain.ErrInvalidInput, checksum, expected)
+	}
+
+	blob := &domain.Blob{OrgID: orgID, ChecksumSHA256: checksum, Size: size, StoragePath: path}
+	created, err := s.blobs.Acquire(ctx, blob)
+	if err != nil {
+		s.store.Delete(path)
+		return nil, err
+	}
+	if !created {
+		s.store.Delete(path)
+		s.log.Info("upload deduplicated", "checksum", checksum, "size", size)
+		return blob, nil
+	}
+	// Concurrent uploads may each have fit on their own
+	if err := s.checkQuota(ctx, -reserved); err != nil {
+		s.releaseBlob(ctx, blob)
+		return nil, err
+	}
+	return blob, nil
+}
+
+// existingBlob reads the rest of a file that does not fit in the quota and
+// returns, with a reference, the blob of the organization with the same
+// content. Without one, overQuota is returned.
+func (s *ArtifactService) existingBlob(ctx context.Context, orgID uuid.UUID, file io.Reader, hasher hash.Hash, expected string, overQuota error) (*domain.Blob, error) {
+	if _, err := io.Copy(hasher, file); err != nil {
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+	checksum := hex.EncodeToString(hasher.Sum(nil))
+	if expected != "" && expected != checksum {
+		return nil, fmt.Errorf("%w: file checksum %s does not match %s", domain.ErrInvalidInput, checksum, expected)
+	}
+	blob, err := s.blobs.AddRef(ctx, orgID, checksum)
+	if errors.Is(err, domain.ErrNotFound) {
+		return nil, overQuota
+	}
+	if err == nil {
+		s.log.Info("upload deduplicated", "checksum", checksum, "size", blob.Size)
+	}
+	return blob, err
+}
+
+// quotaReader returns r limited to n bytes, failing with ErrQuotaExceeded
+// past them. A negative n leaves r unlimited.
+func quotaReader(r io.Reader, n int64) io.Reader {
+	if n < 0 {
+		return r
+	}
+	return &limitedQuotaReader{r: r, n: n}
+}
+
+type limitedQuotaReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedQuotaReader) Read(p []byte) (int, error) {
+	// Read one byte past the limit to tell a file that fits exactly
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.n {
+		return 0, fmt.Errorf("%w: the file does not fit in the space left", domain.ErrQuotaExceeded)
+	}
+	l.n -= int64(n)
+	return n, err
+}
+
+// checkQuota fails with ErrQuotaExceeded if storing size more bytes would
+// take the stored files over the quota.
+func (s *ArtifactService) checkQuota(ctx context.Context, size int64) error {
+	used, quota, err := s.StorageUsage(ctx)
+	if err != nil || quota == 0 || used+size <= quota {
+		return err
+	}
+	return fmt.Errorf("%w: %d of %d bytes would be used", domain.ErrQuotaExceeded, used+size, quota)
+}
+
+// StorageUsage returns the size of the stored files of every organization,
+// with delta files and the size upload sessions reserve, and the quota,
+// which is 0 when there is none.
+func (s *ArtifactService) StorageUsage(ctx context.Context) (used, quota int64, err error) {
+	used, err = s.blobs.StoredSize(ctx)
+	return used, s.quota, err
+}
+
+// releaseBlob drops a reference to a blob and removes its file when it was
+// the last one.
+func (s *ArtifactService) releaseBlob(ctx context.Context, blob *domain.Blob) {
+	released, err := s.blobs.Release(ctx, blob.OrgID, blob.ChecksumSHA256)
+	if err != nil {
+		s.log.Warn("failed to release blob", "checksum", blob.ChecksumSHA256, "err", err)
+		return
+	}
+	if released == nil {
+		return
+	}
+	if err := s.store.Delete(released.StoragePath); err != nil {
+		s.log.Warn("failed to delete blob file", "path", released.StoragePath, "err", err)
+	}
+}
+
+// validateArtifactFields checks the fields every artifact has. Versions are
+// semantic versions, so that the versions of a name can be ordered. Scripts
+// are run rather than installed and need no target_path.
+func validateArtifactFields(kind domain.ArtifactKind, name, version, targetPath string, deviceTypes []string) error {
+	if name == "" || version == "" || (targetPath == "" && kind != domain.ArtifactKindScript) {
+		return fmt.Errorf("%w: name, version, and target_path are required", domain.ErrInvalidInput)
+	}
+	if _, err := semver.Parse(version); err != nil {
+		return fmt.Errorf("%w: version must be MAJOR.MINOR.PATCH[-PRERELEASE][+BUILD]: %v", domain.ErrInvalidInput, err)
+	}
+	if len(deviceTypes) == 0 {
+		return fmt.Errorf("%w: at least one device_type is required", domain.ErrInvalidInput)
+	}
+	return nil
+}
+
+func (s *ArtifactService) GetByID(ctx context.Context, orgID, id uuid.UUID) (*domain.Artifact, error) {
+	return s.repo.GetByID(ctx, orgID, id)
+}
+
+func (s *ArtifactService) List(ctx context.Context, orgID uuid.UUID, filter domain.ArtifactFilter) ([]*domain.Artifact, int, error) {
+	return s.repo.List(ctx, orgID, filter)
+}
+
+// ListLatest returns the highest version of each artifact name for each
+// device type.
+func (s *ArtifactService) ListLatest(ctx context.Context, orgID uuid.UUID, filter domain.LatestArtifactFilter) ([]*domain.LatestArtifact, error) {
+	return s.repo.ListLatest(ctx, orgID, filter)
+}
+
+func (s *ArtifactService) OpenFile(ctx context.Context, orgID, id uuid.UUID) (io.ReadSeekCloser, *domain.Artifact, error) {
+	artifact, err := s.repo.GetByID(ctx, orgID, id)
 	if err != nil {
 		return nil, nil, err
 	}
+	if artifact.IsBundle() {
+		return nil, nil, fmt.Errorf("%w: the files of a bundle are downloaded one by one", domain.ErrInvalidInput)
+	}
+	if artifact.IsOperation() {
+		return nil, nil, fmt.Errorf("%w: %s operations have no file", domain.ErrInvalidInput, artifact.Kind)
+	}
 
 	reader, err := s.store.Open(artifact.StoragePath)
 	if err != nil {
@@ -111,18 +721,187 @@ func (s *ArtifactService) OpenFile(ctx context.Context, id uuid.UUID) (io.ReadCl
 	return reader, artifact, nil
 }
 
-func (s *ArtifactService) Delete(ctx context.Context, id uuid.UUID) error {
-	artifact, err := s.repo.GetByID(ctx, id)
+// OpenBundleFile opens the file at index of a bundle artifact. It fails with
+// ErrNotFound if the artifact has no such file.
+func (s *ArtifactService) OpenBundleFile(artifact *domain.Artifact, index int) (io.ReadSeekCloser, *domain.ArtifactFile, error) {
+	if !artifact.IsBundle() || index < 0 || index >= len(artifact.Files) {
+		return nil, nil, domain.ErrNotFound
+	}
+	file := &artifact.Files[index]
+	reader, err := s.store.Open(file.StoragePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open bundle file: %w", err)
+	}
+	return reader, file, nil
+}
+
+// RenderedTemplate is a template artifact rendered for one device. The
+// server key signs it when one is configured.
+type RenderedTemplate struct {
+	Content        []byte
+	ChecksumSHA256 string
+	Signature      string
+	SigningKeyID   string
+}
+
+// RenderForDevice renders the template artifact for device with the
+// variables of a deployment. It fails with ErrInvalidInput if the template
+// does not render, for instance because it uses a variable that is not set.
+func (s *ArtifactService) RenderForDevice(artifact *domain.Artifact, device *domain.Device, vars map[string]string) (*RenderedTemplate, error) {
+	if !artifact.IsTemplate() {
+		return nil, fmt.Errorf("%w: artifact is not a template", domain.ErrInvalidInput)
+	}
+	src, err := s.readTemplate(artifact.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+	if vars == nil {
+		vars = map[string]string{}
+	}
+	content, err := render.Render(src, render.Data{
+		ID:         device.ID.String(),
+		DeviceType: device.DeviceType,
+		Tags:       device.Tags,
+		Identity:   device.IdentityData,
+		Inventory:  device.Inventory,
+		Vars:       vars,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrInvalidInput, err)
+	}
+
+	sum := sha256.Sum256(content)
+	signature, keyID := s.signing.SignDigest(sum[:])
+	return &RenderedTemplate{
+		Content:        content,
+		ChecksumSHA256: hex.EncodeToString(sum[:]),
+		Signature:      signature,
+		SigningKeyID:   keyID,
+	}, nil
+}
+
+// OpenForDevice opens the file of artifact as delivered to device: as it is
+// stored or rendered with vars for templates, and sealed for the device's
+// encryption key when the artifact is encrypted for each device. seed is the
+// delivery seed of the deployment device entry. The checksum returned is that
+// of the file before encryption.
+func (s *ArtifactService) OpenForDevice(artifact *domain.Artifact, device *domain.Device, vars map[string]string, seed []byte) (io.ReadSeekCloser, string, error) {
+	if artifact.IsBundle() {
+		return nil, "", fmt.Errorf("%w: the files of a bundle are downloaded one by one", domain.ErrInvalidInput)
+	}
+	if artifact.IsOperation() {
+		return nil, "", fmt.Errorf("%w: %s operations have no file", domain.ErrInvalidInput, artifact.Kind)
+	}
+	var recipient *ecdh.PublicKey
+	if artifact.EncryptForDevice {
+		if device.EncryptionKey == "" {
+			return nil, "", domain.ErrNoEncryptionKey
+		}
+		key, err := encrypted.ParseDeviceKey(device.EncryptionKey)
+		if err != nil {
+			return nil, "", fmt.Errorf("device encryption key: %w", err)
+		}
+		recipient = key
+	}
+
+	var reader io.ReadSeekCloser
+	checksum, size := artifact.ChecksumSHA256, artifact.FileSize
+	if artifact.IsTemplate() {
+		rendered, err := s.RenderForDevice(artifact, device, vars)
+		if err != nil {
+			return nil, "", err
+		}
+		reader = nopCloser{bytes.NewReader(rendered.Content)}
+		checksum, size = rendered.ChecksumSHA256, int64(len(rendered.Content))
+	} else {
+		var err error
+		if reader, err = s.store.Open(artifact.StoragePath); err != nil {
+			return nil, "", fmt.Errorf("open artifact file: %w", err)
+		}
+	}
+	if recipient == nil {
+		return reader, checksum, nil
+	}
+
+	sealed, err := encrypted.SealForDevice(reader, size, recipient, seed)
+	if err != nil {
+		reader.Close()
+		return nil, "", err
+	}
+	return sealed, checksum, nil
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error { return nil }
+
+// DirectURL returns a presigned URL that downloads the artifact file straight
+// from the store, or an empty string when the store does not presign.
+func (s *ArtifactService) DirectURL(artifact *domain.Artifact) (string, error) {
+	if artifact.EncryptForDevice || artifact.IsBundle() || artifact.IsTemplate() || artifact.IsOperation() {
+		return "", nil
+	}
+	return s.presign(artifact.StoragePath, artifact.FileName)
+}
+
+// FileDirectURL is DirectURL for a file of a bundle.
+func (s *ArtifactService) FileDirectURL(file *domain.ArtifactFile) (string, error) {
+	return s.presign(file.StoragePath, file.FileName)
+}
+
+func (s *ArtifactService) presign(path, name string) (string, error) {
+	presigner, ok := s.store.(storage.Presigner)
+	if !ok {
+		return "", nil
+	}
+	url, _, err := presigner.PresignGet(path, name)
+	return url, err
+}
+
+func (s *ArtifactService) Delete(ctx context.Context, orgID, id uuid.UUID) error {
+	return s.delete(ctx, orgID, id, false)
+}
+
+// DeleteWithDeployments deletes an artifact together with its finished
+// deployments, which would otherwise keep it from being deleted.
+func (s *ArtifactService) DeleteWithDeployments(ctx context.Context, orgID, id uuid.UUID) error {
+	return s.delete(ctx, orgID, id, true)
+}
+
+func (s *ArtifactService) delete(ctx context.Context, orgID, id uuid.UUID, withDeployments bool) error {
+	artifact, err := s.repo.GetByID(ctx, orgID, id)
 	if err != nil {
 		return err
 	}
 
-	if err := s.repo.Delete(ctx, id); err != nil {
+	// Delta rows go with the artifact, their files must be removed here
+	deltas, err := s.deltas.List(ctx, orgID, id)
+	if err != nil {
 		return err
 	}
 
-	if err := s.store.Delete(artifact.StoragePath); err != nil {
-		s.log.Warn("failed to delete artifact file", "path", artifact.StoragePath, "err", err)
+	if withDeployments {
+		_, err = s.repo.DeleteWithDeployments(ctx, orgID, id)
+	} else {
+		err = s.repo.Delete(ctx, orgID, id)
+	}
+	if err != nil {
+		return err
+	}
+
+	if artifact.IsBundle() {
+		for _, f := range artifact.Files {
+			s.releaseBlob(ctx, &domain.Blob{OrgID: orgID, ChecksumSHA256: f.ChecksumSHA256})
+		}
+	} else if !artifact.IsOperation() {
+		s.releaseBlob(ctx, &domain.Blob{OrgID: orgID, ChecksumSHA256: artifact.ChecksumSHA256})
+	}
+	for _, d := range deltas {
+		if err := s.store.Delete(d.StoragePath); err != nil {
+			s.log.Warn("failed to delete delta file", "path", d.StoragePath, "err", err)
+		}
 	}
 
 	s.log.Info("artifact deleted", "id", id)
@@ -1,8 +1,16 @@
 package service
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"strings"
@@ -10,22 +18,32 @@ import (
 
 	"github.com/google/uuid"
 
+	"github.com/CaioWing/Harbor/internal/archive"
 	"github.com/CaioWing/Harbor/internal/domain"
+	"github.com/CaioWing/Harbor/internal/storage/encrypted"
 )
 
 func newTestArtifactService() (*ArtifactService, *mockArtifactRepo, *mockFileStore) {
 	repo := newMockArtifactRepo()
 	store := newMockFileStore()
 	log := slog.New(slog.NewTextHandler(io.Discard, nil))
-	svc := NewArtifactService(repo, store, log)
+	signing := NewSigningService(newMockSigningKeyRepo(), nil, log)
+	svc := NewArtifactService(repo, newMockBlobRepo(), store, signing, newDisabledDeltaService(repo, store, log), NewValidationService(store, ValidationConfig{}, log), archive.Limits{}, 0, log)
 	return svc, repo, store
 }
 
+// newDisabledDeltaService returns a delta service that never generates
+// deltas, for tests that do not exercise them.
+func newDisabledDeltaService(repo *mockArtifactRepo, store *mockFileStore, log *slog.Logger) *DeltaService {
+	return NewDeltaService(newMockArtifactDeltaRepo(), repo, newMockDeviceRepo(), store, DeltaConfig{}, log)
+}
+
 func TestArtifactCreate_Success(t *testing.T) {
 	svc, _, _ := newTestArtifactService()
 	ctx := context.Background()
 
 	input := CreateArtifactInput{
+		OrgID:       testOrgID,
 		Name:        "myapp",
 		Version:     "1.0.0",
 		Description: "test artifact",
@@ -61,6 +79,7 @@ func TestArtifactCreate_CustomFileMode(t *testing.T) {
 	ctx := context.Background()
 
 	input := CreateArtifactInput{
+		OrgID:       testOrgID,
 		Name:        "myapp",
 		Version:     "1.0.0",
 		FileName:    "myapp",
@@ -84,6 +103,7 @@ func TestArtifactCreate_MissingName(t *testing.T) {
 	ctx := context.Background()
 
 	input := CreateArtifactInput{
+		OrgID:       testOrgID,
 		Version:     "1.0.0",
 		FileName:    "myapp",
 		TargetPath:  "/usr/local/bin/myapp",
@@ -102,6 +122,7 @@ func TestArtifactCreate_MissingVersion(t *testing.T) {
 	ctx := context.Background()
 
 	input := CreateArtifactInput{
+		OrgID:       testOrgID,
 		Name:        "myapp",
 		FileName:    "myapp",
 		TargetPath:  "/usr/local/bin/myapp",
@@ -115,11 +136,75 @@ func TestArtifactCreate_MissingVersion(t *testing.T) {
 	}
 }
 
+func TestArtifactCreate_InvalidVersion(t *testing.T) {
+	svc, _, _ := newTestArtifactService()
+	ctx := context.Background()
+
+	for _, version := range []string{"1.0", "v1.0.0", "latest", "1.0.0-rc.01"} {
+		_, err := svc.Create(ctx, CreateArtifactInput{
+			OrgID:       testOrgID,
+			Name:        "myapp",
+			Version:     version,
+			FileName:    "myapp",
+			TargetPath:  "/usr/local/bin/myapp",
+			DeviceTypes: []string{"raspberry-pi-4"},
+			File:        strings.NewReader("content"),
+		})
+		if !errors.Is(err, domain.ErrInvalidInput) {
+			t.Errorf("version %q: expected ErrInvalidInput, got %v", version, err)
+		}
+	}
+}
+
+func TestArtifactListLatest(t *testing.T) {
+	svc, _, _ := newTestArtifactService()
+	ctx := context.Background()
+
+	create := func(version string, deviceTypes ...string) *domain.Artifact {
+		t.Helper()
+		a, err := svc.Create(ctx, CreateArtifactInput{
+			OrgID:       testOrgID,
+			Name:        "myapp",
+			Version:     version,
+			FileName:    "myapp",
+			TargetPath:  "/usr/local/bin/myapp",
+			DeviceTypes: deviceTypes,
+			File:        strings.NewReader("content " + version),
+		})
+		if err != nil {
+			t.Fatalf("create %s: %v", version, err)
+		}
+		return a
+	}
+	v1100 := create("1.10.0", "rpi4")
+	create("1.9.0", "rpi4", "rpi3")
+	v200rc := create("2.0.0-rc.1", "rpi4")
+
+	latest, err := svc.ListLatest(ctx, testOrgID, domain.LatestArtifactFilter{})
+	if err != nil {
+		t.Fatalf("list latest: %v", err)
+	}
+	got := map[string]string{}
+	for _, l := range latest {
+		got[l.DeviceType] = l.Artifact.Version
+	}
+	if len(got) != 2 || got["rpi4"] != v1100.Version || got["rpi3"] != "1.9.0" {
+		t.Errorf("unexpected latest versions %v", got)
+	}
+
+	deviceType := "rpi4"
+	latest, _ = svc.ListLatest(ctx, testOrgID, domain.LatestArtifactFilter{DeviceType: &deviceType, Prerelease: true})
+	if len(latest) != 1 || latest[0].Artifact.ID != v200rc.ID {
+		t.Errorf("expected 2.0.0-rc.1 with prereleases, got %+v", latest)
+	}
+}
+
 func TestArtifactCreate_MissingTargetPath(t *testing.T) {
 	svc, _, _ := newTestArtifactService()
 	ctx := context.Background()
 
 	input := CreateArtifactInput{
+		OrgID:       testOrgID,
 		Name:        "myapp",
 		Version:     "1.0.0",
 		FileName:    "myapp",
@@ -138,6 +223,7 @@ func TestArtifactCreate_NoDeviceTypes(t *testing.T) {
 	ctx := context.Background()
 
 	input := CreateArtifactInput{
+		OrgID:       testOrgID,
 		Name:        "myapp",
 		Version:     "1.0.0",
 		FileName:    "myapp",
@@ -157,6 +243,7 @@ func TestArtifactCreate_DuplicateNameVersion(t *testing.T) {
 	ctx := context.Background()
 
 	input := CreateArtifactInput{
+		OrgID:       testOrgID,
 		Name:        "myapp",
 		Version:     "1.0.0",
 		FileName:    "myapp",
@@ -177,11 +264,48 @@ func TestArtifactCreate_DuplicateNameVersion(t *testing.T) {
 	}
 }
 
+func TestArtifactCreate_SameNameVersionInOtherOrganization(t *testing.T) {
+	svc, _, store := newTestArtifactService()
+	ctx := context.Background()
+
+	input := CreateArtifactInput{
+		OrgID:       testOrgID,
+		Name:        "myapp",
+		Version:     "1.0.0",
+		FileName:    "myapp",
+		TargetPath:  "/usr/local/bin/myapp",
+		DeviceTypes: []string{"raspberry-pi-4"},
+		File:        strings.NewReader("content"),
+	}
+	first, err := svc.Create(ctx, input)
+	if err != nil {
+		t.Fatalf("unexpected error on first create: %v", err)
+	}
+
+	input.OrgID = uuid.New()
+	input.File = strings.NewReader("other content")
+	second, err := svc.Create(ctx, input)
+	if err != nil {
+		t.Fatalf("unexpected error in second organization: %v", err)
+	}
+	if first.StoragePath == second.StoragePath {
+		t.Fatal("artifacts of different organizations must not share a file")
+	}
+	if len(store.files) != 2 {
+		t.Fatalf("expected 2 stored files, got %d", len(store.files))
+	}
+
+	if _, err := svc.GetByID(ctx, testOrgID, second.ID); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound across organizations, got %v", err)
+	}
+}
+
 func TestArtifactGetByID(t *testing.T) {
 	svc, _, _ := newTestArtifactService()
 	ctx := context.Background()
 
 	input := CreateArtifactInput{
+		OrgID:       testOrgID,
 		Name:        "myapp",
 		Version:     "1.0.0",
 		FileName:    "myapp",
@@ -191,7 +315,7 @@ func TestArtifactGetByID(t *testing.T) {
 	}
 	created, _ := svc.Create(ctx, input)
 
-	found, err := svc.GetByID(ctx, created.ID)
+	found, err := svc.GetByID(ctx, testOrgID, created.ID)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -204,7 +328,7 @@ func TestArtifactGetByID_NotFound(t *testing.T) {
 	svc, _, _ := newTestArtifactService()
 	ctx := context.Background()
 
-	_, err := svc.GetByID(ctx, uuid.New())
+	_, err := svc.GetByID(ctx, testOrgID, uuid.New())
 	if !errors.Is(err, domain.ErrNotFound) {
 		t.Fatalf("expected ErrNotFound, got %v", err)
 	}
@@ -215,6 +339,7 @@ func TestArtifactDelete(t *testing.T) {
 	ctx := context.Background()
 
 	input := CreateArtifactInput{
+		OrgID:       testOrgID,
 		Name:        "myapp",
 		Version:     "1.0.0",
 		FileName:    "myapp",
@@ -224,7 +349,7 @@ func TestArtifactDelete(t *testing.T) {
 	}
 	created, _ := svc.Create(ctx, input)
 
-	err := svc.Delete(ctx, created.ID)
+	err := svc.Delete(ctx, testOrgID, created.ID)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -244,7 +369,7 @@ func TestArtifactDelete_NotFound(t *testing.T) {
 	svc, _, _ := newTestArtifactService()
 	ctx := context.Background()
 
-	err := svc.Delete(ctx, uuid.New())
+	err := svc.Delete(ctx, testOrgID, uuid.New())
 	if !errors.Is(err, domain.ErrNotFound) {
 		t.Fatalf("expected ErrNotFound, got %v", err)
 	}
@@ -256,6 +381,7 @@ func TestArtifactList(t *testing.T) {
 
 	for i := 0; i < 3; i++ {
 		svc.Create(ctx, CreateArtifactInput{
+			OrgID:       testOrgID,
 			Name:        "myapp",
 			Version:     string(rune('1'+i)) + ".0.0",
 			FileName:    "myapp",
@@ -265,7 +391,7 @@ func TestArtifactList(t *testing.T) {
 		})
 	}
 
-	artifacts, total, err := svc.List(ctx, domain.ArtifactFilter{Page: 1, PerPage: 20})
+	artifacts, total, err := svc.List(ctx, testOrgID, domain.ArtifactFilter{Page: 1, PerPage: 20})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -282,6 +408,7 @@ func TestArtifactOpenFile(t *testing.T) {
 	ctx := context.Background()
 
 	input := CreateArtifactInput{
+		OrgID:       testOrgID,
 		Name:        "myapp",
 		Version:     "1.0.0",
 		FileName:    "myapp",
@@ -291,7 +418,7 @@ func TestArtifactOpenFile(t *testing.T) {
 	}
 	created, _ := svc.Create(ctx, input)
 
-	reader, artifact, err := svc.OpenFile(ctx, created.ID)
+	reader, artifact, err := svc.OpenFile(ctx, testOrgID, created.ID)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -307,6 +434,7 @@ func TestArtifactCreate_WithHooks(t *testing.T) {
 	ctx := context.Background()
 
 	input := CreateArtifactInput{
+		OrgID:          testOrgID,
 		Name:           "myapp",
 		Version:        "1.0.0",
 		FileName:       "myapp",
@@ -332,3 +460,590 @@ func TestArtifactCreate_WithHooks(t *testing.T) {
 		t.Fatal("rollback_cmd not set")
 	}
 }
+
+func TestArtifactDirectURL(t *testing.T) {
+	svc, repo, store := newTestArtifactService()
+	ctx := context.Background()
+	created, err := svc.Create(ctx, CreateArtifactInput{
+		OrgID:       testOrgID,
+		Name:        "myapp",
+		Version:     "1.0.0",
+		FileName:    "myapp",
+		TargetPath:  "/usr/local/bin/myapp",
+		DeviceTypes: []string{"raspberry-pi-4"},
+		File:        strings.NewReader("binary data"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The local store cannot presign, so devices download through the server
+	if url, err := svc.DirectURL(created); err != nil || url != "" {
+		t.Fatalf("expected no direct URL, got %q, %v", url, err)
+	}
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	presigning := NewArtifactService(repo, newMockBlobRepo(), presigningFileStore{store}, NewSigningService(newMockSigningKeyRepo(), nil, log),
+		newDisabledDeltaService(repo, store, log), NewValidationService(store, ValidationConfig{}, log), archive.Limits{}, 0, log)
+	url, err := presigning.DirectURL(created)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(url, created.StoragePath) || !strings.Contains(url, "name=myapp") {
+		t.Errorf("unexpected direct URL %q", url)
+	}
+}
+
+func createVersion(t *testing.T, svc *ArtifactService, orgID uuid.UUID, version, content, checksum string) (*domain.Artifact, error) {
+	t.Helper()
+	return svc.Create(context.Background(), CreateArtifactInput{
+		OrgID:          orgID,
+		Name:           "myapp",
+		Version:        version,
+		FileName:       "myapp",
+		TargetPath:     "/usr/local/bin/myapp",
+		DeviceTypes:    []string{"raspberry-pi-4"},
+		ChecksumSHA256: checksum,
+		File:           strings.NewReader(content),
+	})
+}
+
+func TestArtifactCreate_DeduplicatesContent(t *testing.T) {
+	svc, _, store := newTestArtifactService()
+	ctx := context.Background()
+
+	v1, err := createVersion(t, svc, testOrgID, "1.0.0", "same binary", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	v2, err := createVersion(t, svc, testOrgID, "1.0.1", "same binary", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v1.StoragePath != v2.StoragePath || len(store.files) != 1 {
+		t.Fatalf("expected one shared file, got %d files", len(store.files))
+	}
+
+	// The file stays while an artifact still references it
+	if err := svc.Delete(ctx, testOrgID, v1.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, ok := store.files[v2.StoragePath]; !ok {
+		t.Fatal("expected shared file to be kept")
+	}
+	if err := svc.Delete(ctx, testOrgID, v2.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if len(store.files) != 0 {
+		t.Errorf("expected file removed with the last artifact, got %d files", len(store.files))
+	}
+}
+
+func TestArtifactCreate_SameNameSameDayDoesNotOverwrite(t *testing.T) {
+	svc, repo, store := newTestArtifactService()
+
+	a, err := createVersion(t, svc, testOrgID, "1.0.0", "first build", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	delete(repo.artifacts, a.ID)
+	b, err := createVersion(t, svc, testOrgID, "1.0.0", "second build", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a.StoragePath == b.StoragePath || string(store.files[a.StoragePath]) != "first build" {
+		t.Error("expected different content to be stored separately")
+	}
+}
+
+func TestArtifactCreate_KnownChecksumReusesBlob(t *testing.T) {
+	svc, _, store := newTestArtifactService()
+	ctx := context.Background()
+
+	v1, err := createVersion(t, svc, testOrgID, "1.0.0", "same binary", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A wrong file claiming the stored checksum is rejected without a leak
+	_, err = createVersion(t, svc, testOrgID, "1.0.1", "tampered", v1.ChecksumSHA256)
+	if !errors.Is(err, domain.ErrInvalidInput) {
+		t.Fatalf("expected ErrInvalidInput, got %v", err)
+	}
+
+	v2, err := createVersion(t, svc, testOrgID, "1.0.2", "same binary", strings.ToUpper(v1.ChecksumSHA256))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v2.StoragePath != v1.StoragePath || len(store.files) != 1 {
+		t.Fatalf("expected the existing file to be reused, got %d files", len(store.files))
+	}
+
+	svc.Delete(ctx, testOrgID, v1.ID)
+	svc.Delete(ctx, testOrgID, v2.ID)
+	if len(store.files) != 0 {
+		t.Errorf("expected no files left, got %d", len(store.files))
+	}
+}
+
+func TestArtifactCreate_NoDeduplicationAcrossOrganizations(t *testing.T) {
+	svc, _, store := newTestArtifactService()
+
+	a, err := createVersion(t, svc, testOrgID, "1.0.0", "same binary", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := createVersion(t, svc, uuid.New(), "1.0.0", "same binary", a.ChecksumSHA256)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a.StoragePath == b.StoragePath || len(store.files) != 2 {
+		t.Error("expected each organization to store its own copy")
+	}
+}
+
+func TestArtifactOpenForDevice_SealsForDeviceKey(t *testing.T) {
+	svc, repo, store := newTestArtifactService()
+	ctx := context.Background()
+	created, err := svc.Create(ctx, CreateArtifactInput{
+		OrgID:            testOrgID,
+		Name:             "wifi-config",
+		Version:          "1.0.0",
+		FileName:         "wpa_supplicant.conf",
+		TargetPath:       "/etc/wpa_supplicant.conf",
+		DeviceTypes:      []string{"raspberry-pi-4"},
+		EncryptForDevice: true,
+		File:             strings.NewReader("psk=secret"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	seed := bytes.Repeat([]byte{9}, encrypted.SeedSize)
+
+	device := &domain.Device{OrgID: testOrgID}
+	if _, _, err := svc.OpenForDevice(created, device, nil, seed); !errors.Is(err, domain.ErrNoEncryptionKey) {
+		t.Fatalf("expected ErrNoEncryptionKey, got %v", err)
+	}
+
+	key, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	device.EncryptionKey = base64.StdEncoding.EncodeToString(key.PublicKey().Bytes())
+	reader, _, err := svc.OpenForDevice(created, device, nil, seed)
+	if err != nil {
+		t.Fatalf("open for device: %v", err)
+	}
+	sealed, _ := io.ReadAll(reader)
+	reader.Close()
+	if bytes.Contains(sealed, []byte("secret")) {
+		t.Fatal("expected delivered file to be encrypted")
+	}
+
+	opened, err := encrypted.OpenSealed(nopSeekCloser{bytes.NewReader(sealed)}, key)
+	if err != nil {
+		t.Fatalf("open sealed: %v", err)
+	}
+	if plain, err := io.ReadAll(opened); err != nil || string(plain) != "psk=secret" {
+		t.Errorf("decrypted %q, %v", plain, err)
+	}
+
+	// A presigned URL would bypass the encryption
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	presigning := NewArtifactService(repo, newMockBlobRepo(), presigningFileStore{store}, NewSigningService(newMockSigningKeyRepo(), nil, log),
+		newDisabledDeltaService(repo, store, log), NewValidationService(store, ValidationConfig{}, log), archive.Limits{}, 0, log)
+	if url, err := presigning.DirectURL(created); err != nil || url != "" {
+		t.Errorf("expected no direct URL, got %q, %v", url, err)
+	}
+}
+
+func bundleInput(files ...BundleFileInput) CreateBundleInput {
+	return CreateBundleInput{
+		OrgID: testOrgID, Name: "myapp-bundle", Version: "1.0.0",
+		DeviceTypes: []string{"raspberry-pi-4"}, PostInstallCmd: "systemctl restart myapp",
+		Files: files,
+	}
+}
+
+func TestArtifactCreateBundle(t *testing.T) {
+	svc, _, store := newTestArtifactService()
+	ctx := context.Background()
+
+	artifact, err := svc.CreateBundle(ctx, bundleInput(
+		BundleFileInput{TargetPath: "/usr/local/bin/myapp", FileMode: "0755", File: strings.NewReader("binary")},
+		BundleFileInput{TargetPath: "/etc/myapp/config.toml", FileOwner: "myapp:myapp", File: strings.NewReader("config")},
+	))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !artifact.IsBundle() || len(artifact.Files) != 2 || len(store.files) != 2 {
+		t.Fatalf("expected a bundle of 2 stored files, got %+v", artifact)
+	}
+	if artifact.FileSize != int64(len("binary")+len("config")) {
+		t.Errorf("expected total size, got %d", artifact.FileSize)
+	}
+	f := artifact.Files[1]
+	if f.FileName != "config.toml" || f.FileMode != "0644" || f.FileOwner != "myapp:myapp" {
+		t.Errorf("unexpected file %+v", f)
+	}
+
+	// The checksum is that of the manifest, which agents recompute
+	manifest := fmt.Sprintf("%s 0755  /usr/local/bin/myapp\n%s 0644 myapp:myapp /etc/myapp/config.toml\n",
+		artifact.Files[0].ChecksumSHA256, artifact.Files[1].ChecksumSHA256)
+	if sum := sha256.Sum256([]byte(manifest)); artifact.ChecksumSHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("checksum %s is not the manifest digest", artifact.ChecksumSHA256)
+	}
+
+	// Files of the same content are stored once, across artifacts
+	single, err := svc.Create(ctx, CreateArtifactInput{
+		OrgID: testOrgID, Name: "myapp", Version: "1.0.0", FileName: "myapp",
+		TargetPath: "/usr/local/bin/myapp", DeviceTypes: []string{"raspberry-pi-4"},
+		File: strings.NewReader("binary"),
+	})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if single.StoragePath != artifact.Files[0].StoragePath || len(store.files) != 2 {
+		t.Errorf("expected the bundle file to be reused, got %d files", len(store.files))
+	}
+
+	// Deleting the bundle releases its files
+	if err := svc.Delete(ctx, testOrgID, artifact.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, ok := store.files[single.StoragePath]; !ok || len(store.files) != 1 {
+		t.Errorf("expected only the shared file to be kept, got %d files", len(store.files))
+	}
+}
+
+func TestArtifactCreateBundle_InvalidInput(t *testing.T) {
+	cases := map[string][]BundleFileInput{
+		"no files": nil,
+		"no target path": {
+			{File: strings.NewReader("a")},
+		},
+		"duplicate target path": {
+			{TargetPath: "/etc/a", File: strings.NewReader("a")},
+			{TargetPath: "/etc/a", File: strings.NewReader("b")},
+		},
+		"space in owner": {
+			{TargetPath: "/etc/a", FileOwner: "my app", File: strings.NewReader("a")},
+		},
+	}
+	for name, files := range cases {
+		svc, repo, store := newTestArtifactService()
+		_, err := svc.CreateBundle(context.Background(), bundleInput(files...))
+		if !errors.Is(err, domain.ErrInvalidInput) {
+			t.Errorf("%s: expected ErrInvalidInput, got %v", name, err)
+		}
+		if len(repo.artifacts) != 0 || len(store.files) != 0 {
+			t.Errorf("%s: expected nothing stored", name)
+		}
+	}
+}
+
+func TestArtifactOpenBundleFile(t *testing.T) {
+	svc, _, _ := newTestArtifactService()
+	ctx := context.Background()
+
+	artifact, err := svc.CreateBundle(ctx, bundleInput(
+		BundleFileInput{TargetPath: "/usr/local/bin/myapp", File: strings.NewReader("binary")},
+		BundleFileInput{TargetPath: "/etc/myapp/config.toml", File: strings.NewReader("config")},
+	))
+	if err != nil {
+		t.Fatalf("create bundle: %v", err)
+	}
+
+	reader, file, err := svc.OpenBundleFile(artifact, 1)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "config" || file.TargetPath != "/etc/myapp/config.toml" {
+		t.Errorf("got %q for %s", data, file.TargetPath)
+	}
+
+	if _, _, err := svc.OpenBundleFile(artifact, 2); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("expected ErrNotFound past the last file, got %v", err)
+	}
+	if _, _, err := svc.OpenFile(ctx, testOrgID, artifact.ID); !errors.Is(err, domain.ErrInvalidInput) {
+		t.Errorf("expected a bundle not to open as one file, got %v", err)
+	}
+}
+
+func TestArtifactCreate_Archive(t *testing.T) {
+	svc, repo, store := newTestArtifactService()
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("static/index.html")
+	w.Write([]byte("<html>"))
+	zw.Close()
+
+	input := CreateArtifactInput{
+		OrgID: testOrgID, Kind: domain.ArtifactKindArchive, Name: "webui", Version: "1.0.0",
+		FileName: "webui.zip", TargetPath: "/srv/webui", DeviceTypes: []string{"raspberry-pi-4"},
+		File: bytes.NewReader(buf.Bytes()),
+	}
+	artifact, err := svc.Create(ctx, input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if artifact.ArchiveFormat != "zip" || artifact.FileMode != "0755" || len(artifact.Entries) != 1 || artifact.Entries[0].Path != "static/index.html" {
+		t.Errorf("unexpected archive %+v", artifact)
+	}
+
+	// An archive that fails validation is not kept
+	input.Version = "1.0.1"
+	input.File = strings.NewReader("not an archive")
+	if _, err := svc.Create(ctx, input); !errors.Is(err, domain.ErrInvalidInput) {
+		t.Fatalf("expected ErrInvalidInput, got %v", err)
+	}
+	if len(repo.artifacts) != 1 || len(store.files) != 1 {
+		t.Errorf("expected the invalid archive to be discarded, got %d artifacts %d files", len(repo.artifacts), len(store.files))
+	}
+}
+
+func TestArtifactTemplate_RenderedPerDevice(t *testing.T) {
+	svc, repo, store := newTestArtifactService()
+	ctx := context.Background()
+
+	input := CreateArtifactInput{
+		OrgID: testOrgID, Kind: domain.ArtifactKindTemplate, Name: "agent-config", Version: "1.0.0",
+		FileName: "agent.conf", TargetPath: "/etc/agent.conf", DeviceTypes: []string{"raspberry-pi-4"},
+		File: strings.NewReader("host={{ .Identity.mac }}\nsite={{ .Vars.site }}\n"),
+	}
+	artifact, err := svc.Create(ctx, input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if artifact.Kind != domain.ArtifactKindTemplate {
+		t.Errorf("kind = %s", artifact.Kind)
+	}
+	if url, _ := svc.DirectURL(artifact); url != "" {
+		t.Errorf("expected no direct URL for a template, got %s", url)
+	}
+
+	device := &domain.Device{OrgID: testOrgID, IdentityData: domain.IdentityData{"mac": "aa:bb"}}
+	rendered, err := svc.RenderForDevice(artifact, device, map[string]string{"site": "lisbon"})
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	want := "host=aa:bb\nsite=lisbon\n"
+	sum := sha256.Sum256([]byte(want))
+	if string(rendered.Content) != want || rendered.ChecksumSHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("unexpected rendering %q %s", rendered.Content, rendered.ChecksumSHA256)
+	}
+
+	reader, checksum, err := svc.OpenForDevice(artifact, device, map[string]string{"site": "lisbon"}, nil)
+	if err != nil {
+		t.Fatalf("open for device: %v", err)
+	}
+	delivered, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(delivered) != want || checksum != rendered.ChecksumSHA256 {
+		t.Errorf("delivered %q with checksum %s", delivered, checksum)
+	}
+
+	// A variable the deployment does not set fails the rendering
+	if _, err := svc.RenderForDevice(artifact, device, nil); !errors.Is(err, domain.ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput for a missing variable, got %v", err)
+	}
+
+	// A template that does not parse is not kept
+	input.Version = "1.0.1"
+	input.File = strings.NewReader("{{ .Vars.site ")
+	if _, err := svc.Create(ctx, input); !errors.Is(err, domain.ErrInvalidInput) {
+		t.Fatalf("expected ErrInvalidInput, got %v", err)
+	}
+	if len(repo.artifacts) != 1 || len(store.files) != 1 {
+		t.Errorf("expected the invalid template to be discarded, got %d artifacts %d files", len(repo.artifacts), len(store.files))
+	}
+}
+
+func TestArtifactCreateOperation(t *testing.T) {
+	svc, repo, store := newTestArtifactService()
+	ctx := context.Background()
+
+	input := CreateOperationInput{
+		OrgID: testOrgID, Kind: domain.ArtifactKindRename, Name: "move-config", Version: "1.0.0",
+		SourcePath: "/etc/legacy/app.conf", TargetPath: "/etc/app/app.conf", DeviceTypes: []string{"raspberry-pi-4"},
+	}
+	artifact, err := svc.CreateOperation(ctx, input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sum := sha256.Sum256([]byte("rename\n/etc/legacy/app.conf\n/etc/app/app.conf\n\n\n"))
+	if artifact.ChecksumSHA256 != hex.EncodeToString(sum[:]) || artifact.FileSize != 0 || artifact.SourcePath != input.SourcePath {
+		t.Errorf("unexpected operation %+v", artifact)
+	}
+	if len(store.files) != 0 {
+		t.Errorf("expected no file to be stored, got %d", len(store.files))
+	}
+	if _, _, err := svc.OpenFile(ctx, testOrgID, artifact.ID); !errors.Is(err, domain.ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput opening an operation, got %v", err)
+	}
+
+	mkdir, err := svc.CreateOperation(ctx, CreateOperationInput{
+		OrgID: testOrgID, Kind: domain.ArtifactKindMkdir, Name: "data-dir", Version: "1.0.0",
+		TargetPath: "/var/lib/app", FileOwner: "app:app", DeviceTypes: []string{"raspberry-pi-4"},
+	})
+	if err != nil || mkdir.FileMode != "0755" {
+		t.Fatalf("expected mkdir with default mode, got %+v %v", mkdir, err)
+	}
+
+	invalid := map[string]CreateOperationInput{
+		"unknown kind":           {Kind: domain.ArtifactKindFile, TargetPath: "/etc/a"},
+		"relative target":        {Kind: domain.ArtifactKindDelete, TargetPath: "etc/a"},
+		"unclean target":         {Kind: domain.ArtifactKindDelete, TargetPath: "/etc/../a"},
+		"root":                   {Kind: domain.ArtifactKindDelete, TargetPath: "/"},
+		"delete with source":     {Kind: domain.ArtifactKindDelete, TargetPath: "/etc/a", SourcePath: "/etc/b"},
+		"rename without source":  {Kind: domain.ArtifactKindRename, TargetPath: "/etc/a"},
+		"rename onto itself":     {Kind: domain.ArtifactKindRename, TargetPath: "/etc/a", SourcePath: "/etc/a"},
+		"symlink without target": {Kind: domain.ArtifactKindSymlink, TargetPath: "/etc/a"},
+		"mode on delete":         {Kind: domain.ArtifactKindDelete, TargetPath: "/etc/a", FileMode: "0644"},
+		"line break":             {Kind: domain.ArtifactKindSymlink, TargetPath: "/etc/a", SourcePath: "b\nc"},
+	}
+	for name, in := range invalid {
+		in.OrgID, in.Name, in.Version, in.DeviceTypes = testOrgID, "op", "1.0.0", []string{"raspberry-pi-4"}
+		if _, err := svc.CreateOperation(ctx, in); !errors.Is(err, domain.ErrInvalidInput) {
+			t.Errorf("%s: expected ErrInvalidInput, got %v", name, err)
+		}
+	}
+
+	if err := svc.Delete(ctx, testOrgID, artifact.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if len(repo.artifacts) != 1 {
+		t.Errorf("expected one artifact left, got %d", len(repo.artifacts))
+	}
+}
+
+func TestArtifactCreate_Script(t *testing.T) {
+	svc, _, _ := newTestArtifactService()
+	ctx := context.Background()
+
+	input := CreateArtifactInput{
+		OrgID: testOrgID, Kind: domain.ArtifactKindScript, Name: "rotate-logs", Version: "1.0.0",
+		FileName: "rotate.sh", DeviceTypes: []string{"raspberry-pi-4"},
+		File: strings.NewReader("#!/bin/sh\nfind /var/log -name '*.gz' -delete\n"),
+	}
+	artifact, err := svc.Create(ctx, input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if artifact.Script == nil || artifact.Script.Interpreter != "/bin/sh" || artifact.Script.TimeoutSec != 300 {
+		t.Errorf("expected default script settings, got %+v", artifact.Script)
+	}
+
+	invalid := map[string]*domain.ScriptSpec{
+		"relative interpreter": {Interpreter: "bash"},
+		"negative timeout":     {TimeoutSec: -1},
+		"timeout too long":     {TimeoutSec: 7 * 24 * 60 * 60},
+		"relative working dir": {WorkingDir: "tmp"},
+		"invalid env name":     {Env: map[string]string{"A-B": "1"}},
+	}
+	for name, spec := range invalid {
+		input.Version, input.Script = name, spec
+		input.File = strings.NewReader("true")
+		if _, err := svc.Create(ctx, input); !errors.Is(err, domain.ErrInvalidInput) {
+			t.Errorf("%s: expected ErrInvalidInput, got %v", name, err)
+		}
+	}
+
+	// Script settings on another kind are a mistake
+	input.Kind, input.Version, input.TargetPath = domain.ArtifactKindFile, "2.0.0", "/usr/local/bin/rotate.sh"
+	input.Script = &domain.ScriptSpec{TimeoutSec: 10}
+	input.File = strings.NewReader("true")
+	if _, err := svc.Create(ctx, input); !errors.Is(err, domain.ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput for script settings on a file, got %v", err)
+	}
+}
+
+func TestArtifactCreate_Requirements(t *testing.T) {
+	svc, _, _ := newTestArtifactService()
+	ctx := context.Background()
+
+	input := CreateArtifactInput{
+		OrgID: testOrgID, Name: "myapp", Version: "1.0.0", FileName: "myapp",
+		TargetPath: "/usr/local/bin/myapp", DeviceTypes: []string{"raspberry-pi-4"},
+		Requirements: &domain.Requirements{
+			MinOSVersion: "5.10.0",
+			MaxOSVersion: "6.1.0",
+			Arch:         []string{"arm64"},
+			Depends:      []domain.Dependency{{Name: "runtime", MinVersion: "2.0.0"}},
+		},
+		File: strings.NewReader("binary"),
+	}
+	artifact, err := svc.Create(ctx, input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if artifact.Requirements == nil || artifact.Requirements.MinOSVersion != "5.10.0" {
+		t.Errorf("expected requirements to be stored, got %+v", artifact.Requirements)
+	}
+
+	invalid := map[string]*domain.Requirements{
+		"invalid min_os_version": {MinOSVersion: "5.10"},
+		"min above max":          {MinOSVersion: "6.2.0", MaxOSVersion: "6.1.0"},
+		"empty arch":             {Arch: []string{""}},
+		"unnamed dependency":     {Depends: []domain.Dependency{{MinVersion: "1.0.0"}}},
+		"invalid min_version":    {Depends: []domain.Dependency{{Name: "runtime", MinVersion: "two"}}},
+	}
+	for name, req := range invalid {
+		input.Version, input.Requirements = "2.0.0", req
+		input.File = strings.NewReader("binary")
+		if _, err := svc.Create(ctx, input); !errors.Is(err, domain.ErrInvalidInput) {
+			t.Errorf("%s: expected ErrInvalidInput, got %v", name, err)
+		}
+	}
+}
+
+func TestArtifactCreate_QuotaExceeded(t *testing.T) {
+	repo := newMockArtifactRepo()
+	store := newMockFileStore()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	svc := NewArtifactService(repo, newMockBlobRepo(), store, NewSigningService(newMockSigningKeyRepo(), nil, log), newDisabledDeltaService(repo, store, log), NewValidationService(store, ValidationConfig{}, log), archive.Limits{}, 30, log)
+	ctx := context.Background()
+
+	input := func(version, content string) CreateArtifactInput {
+		return CreateArtifactInput{
+			OrgID: testOrgID, Name: "myapp", Version: version, FileName: "myapp",
+			TargetPath: "/usr/local/bin/myapp", DeviceTypes: []string{"raspberry-pi-4"}, File: strings.NewReader(content),
+		}
+	}
+	if _, err := svc.Create(ctx, input("1.0.0", "binary content here")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Identical content is deduplicated and takes no more space
+	if _, err := svc.Create(ctx, input("1.0.1", "binary content here")); err != nil {
+		t.Fatalf("expected deduplicated upload under the quota, got %v", err)
+	}
+
+	_, err := svc.Create(ctx, input("2.0.0", "other binary content"))
+	if !errors.Is(err, domain.ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+	if len(store.files) != 1 {
+		t.Fatalf("expected the rejected file to be removed, %d files stored", len(store.files))
+	}
+	if used, quota, _ := svc.StorageUsage(ctx); used != 19 || quota != 30 {
+		t.Fatalf("expected 19 of 30 bytes used, got %d of %d", used, quota)
+	}
+
+	// A file declared too large is refused without being stored, unless it
+	// is content already stored
+	saves := len(store.files)
+	large := input("3.0.0", "other binary content")
+	large.Size = 20
+	if _, err := svc.Create(ctx, large); !errors.Is(err, domain.ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded for a declared size over the quota, got %v", err)
+	}
+	if len(store.files) != saves {
+		t.Fatalf("expected nothing stored for a file declared over the quota")
+	}
+	known := input("3.0.1", "binary content here")
+	known.Size = 19
+	if _, err := svc.Create(ctx, known); err != nil {
+		t.Fatalf("expected known content to be accepted over the quota, got %v", err)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"log/slog"
 
+	"github.com/google/uuid"
+
 	"github.com/CaioWing/Harbor/internal/domain"
 )
 
@@ -26,6 +28,8 @@ func (s *AuditService) Log(ctx context.Context, entry *domain.AuditEntry) {
 	}
 }
 
-func (s *AuditService) List(ctx context.Context, filter domain.AuditFilter) ([]*domain.AuditEntry, int, error) {
-	return s.repo.List(ctx, filter)
+// List returns the audit entries of an organization, or the server-level
+// entries when orgID is uuid.Nil.
+func (s *AuditService) List(ctx context.Context, orgID uuid.UUID, filter domain.AuditFilter) ([]*domain.AuditEntry, int, error) {
+	return s.repo.List(ctx, orgID, filter)
 }
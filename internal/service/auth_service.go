@@ -0,0 +1,294 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/CaioWing/Harbor/internal/auth"
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+type AuthService struct {
+	tokenRepo     domain.TokenRepository
+	userRepo      domain.UserRepository
+	settings      domain.SettingsRepository
+	jwtMgr        *auth.JWTManager
+	refreshExpiry time.Duration
+	log           *slog.Logger
+}
+
+func NewAuthService(
+	tokenRepo domain.TokenRepository,
+	userRepo domain.UserRepository,
+	settings domain.SettingsRepository,
+	jwtMgr *auth.JWTManager,
+	refreshExpiry time.Duration,
+	log *slog.Logger,
+) *AuthService {
+	return &AuthService{
+		tokenRepo:     tokenRepo,
+		userRepo:      userRepo,
+		settings:      settings,
+		jwtMgr:        jwtMgr,
+		refreshExpiry: refreshExpiry,
+		log:           log,
+	}
+}
+
+// TokenPair is a short-lived access JWT together with the opaque refresh
+// token that can be exchanged for the next pair.
+type TokenPair struct {
+	AccessToken      string
+	AccessExpiresAt  time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+}
+
+// dummyPasswordHash is a bcrypt hash, at the cost user passwords are hashed
+// with, that Login compares against for unknown emails so that they take as
+// long to refuse as a wrong password.
+const dummyPasswordHash = "$2a$10$TiyP5bK7ltYEQebG3X743ehj9DAwyj5EKuxQI8gzmlmbl7ffLbuRC"
+
+type LoginInput struct {
+	Email        string
+	Password     string
+	TOTPCode     string
+	RecoveryCode string
+}
+
+// Login verifies the user's credentials and, if the user has enrolled in
+// TOTP, their second factor. It returns domain.ErrTOTPRequired when the
+// password is correct but no code was supplied.
+func (s *AuthService) Login(ctx context.Context, input LoginInput) (*TokenPair, error) {
+	user, err := s.userRepo.GetByEmail(ctx, input.Email)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			_ = bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash), []byte(input.Password))
+			return nil, domain.ErrUnauthorized
+		}
+		return nil, fmt.Errorf("lookup user: %w", err)
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(input.Password)); err != nil {
+		return nil, domain.ErrUnauthorized
+	}
+
+	mfa := false
+	if user.TOTPEnabled {
+		if err := verifySecondFactor(ctx, s.userRepo, user, input.TOTPCode, input.RecoveryCode); err != nil {
+			return nil, err
+		}
+		mfa = true
+	}
+
+	return s.IssueTokens(ctx, user, mfa)
+}
+
+// IssueTokens starts a new session for user. mfa records whether the
+// session was established with a second factor.
+func (s *AuthService) IssueTokens(ctx context.Context, user *domain.User, mfa bool) (*TokenPair, error) {
+	refresh, hash, err := auth.GenerateRefreshToken()
+	if err != nil {
+		return nil, fmt.Errorf("generate refresh token: %w", err)
+	}
+
+	rt := &domain.RefreshToken{
+		UserID:    user.ID.String(),
+		FamilyID:  uuid.New(),
+		MFA:       mfa,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(s.refreshExpiry),
+	}
+	if err := s.tokenRepo.CreateRefreshToken(ctx, rt); err != nil {
+		return nil, fmt.Errorf("store refresh token: %w", err)
+	}
+
+	return s.pair(user, mfa, refresh, rt.ExpiresAt)
+}
+
+// Refresh exchanges a refresh token for a new token pair. The presented token
+// is revoked; presenting it again is treated as theft and revokes every token
+// in its family.
+func (s *AuthService) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	rt, err := s.tokenRepo.GetRefreshTokenByHash(ctx, auth.HashToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, domain.ErrUnauthorized
+		}
+		return nil, fmt.Errorf("lookup refresh token: %w", err)
+	}
+
+	if rt.RevokedAt != nil {
+		s.log.Warn("refresh token reuse detected, revoking session", "user", rt.UserID, "family", rt.FamilyID)
+		if err := s.tokenRepo.RevokeRefreshTokenFamily(ctx, rt.FamilyID); err != nil {
+			return nil, fmt.Errorf("revoke token family: %w", err)
+		}
+		return nil, domain.ErrUnauthorized
+	}
+	if time.Now().After(rt.ExpiresAt) {
+		return nil, domain.ErrUnauthorized
+	}
+
+	// Reload the user so role changes and deletions apply on the next refresh
+	user, err := s.lookupUser(ctx, rt.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh, hash, err := auth.GenerateRefreshToken()
+	if err != nil {
+		return nil, fmt.Errorf("generate refresh token: %w", err)
+	}
+
+	// The rotated token keeps the family's original expiry so that a session
+	// cannot be extended indefinitely by refreshing.
+	next := &domain.RefreshToken{
+		UserID:    rt.UserID,
+		FamilyID:  rt.FamilyID,
+		MFA:       rt.MFA,
+		TokenHash: hash,
+		ExpiresAt: rt.ExpiresAt,
+	}
+	if err := s.tokenRepo.RotateRefreshToken(ctx, rt.ID, next); err != nil {
+		if errors.Is(err, domain.ErrConflict) {
+			return nil, domain.ErrUnauthorized
+		}
+		return nil, fmt.Errorf("rotate refresh token: %w", err)
+	}
+
+	return s.pair(user, rt.MFA, refresh, next.ExpiresAt)
+}
+
+// ValidateAccessToken verifies an access JWT and rejects it if it has been
+// revoked by a logout.
+func (s *AuthService) ValidateAccessToken(ctx context.Context, token string) (*auth.ManagementClaims, error) {
+	claims, err := s.jwtMgr.Validate(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrUnauthorized, err)
+	}
+
+	if claims.ID != "" {
+		revoked, err := s.tokenRepo.IsAccessTokenRevoked(ctx, claims.ID)
+		if err != nil {
+			return nil, fmt.Errorf("check denylist: %w", err)
+		}
+		if revoked {
+			return nil, domain.ErrUnauthorized
+		}
+	}
+
+	return claims, nil
+}
+
+// CheckTOTPPolicy returns domain.ErrTOTPEnrolment if the security policy
+// requires a second factor for the token's role but the session was not
+// established with one.
+func (s *AuthService) CheckTOTPPolicy(ctx context.Context, claims *auth.ManagementClaims) error {
+	if claims.MFA {
+		return nil
+	}
+	policy, err := s.settings.GetSecurityPolicy(ctx)
+	if err != nil {
+		return fmt.Errorf("get security policy: %w", err)
+	}
+	if policy.RequiresTOTP(domain.Role(claims.Role)) {
+		return domain.ErrTOTPEnrolment
+	}
+	return nil
+}
+
+// Logout revokes the access token described by claims. If refreshToken is
+// given only its session is ended, otherwise all of the user's sessions are.
+func (s *AuthService) Logout(ctx context.Context, claims *auth.ManagementClaims, refreshToken string) error {
+	if claims.ID != "" && claims.ExpiresAt != nil {
+		if err := s.tokenRepo.RevokeAccessToken(ctx, claims.ID, claims.ExpiresAt.Time); err != nil {
+			return fmt.Errorf("revoke access token: %w", err)
+		}
+	}
+
+	if refreshToken == "" {
+		if err := s.tokenRepo.RevokeUserRefreshTokens(ctx, claims.UserID); err != nil {
+			return fmt.Errorf("revoke refresh tokens: %w", err)
+		}
+		s.log.Info("user logged out of all sessions", "user", claims.UserID)
+		return nil
+	}
+
+	rt, err := s.tokenRepo.GetRefreshTokenByHash(ctx, auth.HashToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("lookup refresh token: %w", err)
+	}
+	if rt.UserID != claims.UserID {
+		return domain.ErrForbidden
+	}
+	if err := s.tokenRepo.RevokeRefreshTokenFamily(ctx, rt.FamilyID); err != nil {
+		return fmt.Errorf("revoke token family: %w", err)
+	}
+
+	s.log.Info("user logged out", "user", claims.UserID)
+	return nil
+}
+
+// StartPurgeScheduler periodically deletes expired refresh tokens and
+// denylist entries. Call in a goroutine.
+func (s *AuthService) StartPurgeScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := s.tokenRepo.DeleteExpired(ctx)
+			if err != nil {
+				s.log.Warn("failed to purge expired tokens", "err", err)
+				continue
+			}
+			if n > 0 {
+				s.log.Info("purged expired tokens", "count", n)
+			}
+		}
+	}
+}
+
+func (s *AuthService) lookupUser(ctx context.Context, userID string) (*domain.User, error) {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, domain.ErrUnauthorized
+	}
+	user, err := s.userRepo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, domain.ErrUnauthorized
+		}
+		return nil, fmt.Errorf("lookup user: %w", err)
+	}
+	return user, nil
+}
+
+func (s *AuthService) pair(user *domain.User, mfa bool, refresh string, refreshExpiresAt time.Time) (*TokenPair, error) {
+	access, accessExpiresAt, err := s.jwtMgr.Generate(auth.Subject{
+		UserID: user.ID.String(),
+		Role:   string(user.Role),
+		MFA:    mfa,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:      access,
+		AccessExpiresAt:  accessExpiresAt,
+		RefreshToken:     refresh,
+		RefreshExpiresAt: refreshExpiresAt,
+	}, nil
+}
@@ -0,0 +1,379 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/CaioWing/Harbor/internal/auth"
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+type authTestEnv struct {
+	svc      *AuthService
+	users    *UserService
+	tokens   *mockTokenRepo
+	userRepo *mockUserRepo
+	settings *mockSettingsRepo
+	admin    *domain.User
+}
+
+func newTestAuthService() (*AuthService, *mockTokenRepo, *domain.User) {
+	env := newAuthTestEnv()
+	return env.svc, env.tokens, env.admin
+}
+
+func newAuthTestEnv() *authTestEnv {
+	tokens := newMockTokenRepo()
+	userRepo := newMockUserRepo()
+	settings := &mockSettingsRepo{}
+	jwtMgr := auth.NewJWTManager(auth.JWTKey{ID: "k1", Secret: "secret-1"}, nil, 15*time.Minute)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	env := &authTestEnv{
+		svc:      NewAuthService(tokens, userRepo, settings, jwtMgr, time.Hour, log),
+		users:    NewUserService(userRepo, settings, log),
+		tokens:   tokens,
+		userRepo: userRepo,
+		settings: settings,
+	}
+
+	admin, err := env.users.Create(context.Background(), CreateUserInput{
+		Email: "admin@harbor.local", Password: "admin", Role: domain.RoleAdmin,
+	})
+	if err != nil {
+		panic(err)
+	}
+	env.admin = admin
+	return env
+}
+
+// enrolTOTP enables TOTP for the user and returns its secret and recovery codes.
+func (e *authTestEnv) enrolTOTP(t *testing.T, userID uuid.UUID) (string, []string) {
+	t.Helper()
+	ctx := context.Background()
+
+	enrolment, err := e.users.BeginTOTPEnrolment(ctx, userID)
+	if err != nil {
+		t.Fatalf("begin enrolment: %v", err)
+	}
+	code, _ := auth.TOTPCode(enrolment.Secret, time.Now())
+	codes, err := e.users.ConfirmTOTPEnrolment(ctx, userID, code)
+	if err != nil {
+		t.Fatalf("confirm enrolment: %v", err)
+	}
+	return enrolment.Secret, codes
+}
+
+func TestAuthIssueTokens(t *testing.T) {
+	svc, repo, admin := newTestAuthService()
+	ctx := context.Background()
+
+	pair, err := svc.IssueTokens(ctx, admin, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pair.AccessToken == "" || pair.RefreshToken == "" {
+		t.Fatal("expected access and refresh tokens")
+	}
+	if len(repo.refresh) != 1 {
+		t.Fatalf("expected 1 stored refresh token, got %d", len(repo.refresh))
+	}
+
+	claims, err := svc.ValidateAccessToken(ctx, pair.AccessToken)
+	if err != nil {
+		t.Fatalf("unexpected error validating access token: %v", err)
+	}
+	if claims.UserID != admin.ID.String() || claims.ID == "" {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+}
+
+func TestAuthRefresh_RotatesToken(t *testing.T) {
+	svc, _, admin := newTestAuthService()
+	ctx := context.Background()
+
+	first, _ := svc.IssueTokens(ctx, admin, false)
+
+	second, err := svc.Refresh(ctx, first.RefreshToken)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if second.RefreshToken == first.RefreshToken {
+		t.Fatal("expected a new refresh token")
+	}
+	if !second.RefreshExpiresAt.Equal(first.RefreshExpiresAt) {
+		t.Fatal("expected rotated token to keep the session expiry")
+	}
+}
+
+func TestAuthRefresh_ReuseRevokesFamily(t *testing.T) {
+	svc, _, admin := newTestAuthService()
+	ctx := context.Background()
+
+	first, _ := svc.IssueTokens(ctx, admin, false)
+	second, _ := svc.Refresh(ctx, first.RefreshToken)
+
+	// Replaying the rotated token is treated as theft
+	if _, err := svc.Refresh(ctx, first.RefreshToken); !errors.Is(err, domain.ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized on reuse, got %v", err)
+	}
+
+	// ...and the legitimate successor is revoked with it
+	if _, err := svc.Refresh(ctx, second.RefreshToken); !errors.Is(err, domain.ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized after family revocation, got %v", err)
+	}
+}
+
+func TestAuthRefresh_UnknownToken(t *testing.T) {
+	svc, _, _ := newTestAuthService()
+
+	_, err := svc.Refresh(context.Background(), "not-a-token")
+	if !errors.Is(err, domain.ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized, got %v", err)
+	}
+}
+
+func TestAuthRefresh_Expired(t *testing.T) {
+	svc, repo, admin := newTestAuthService()
+	ctx := context.Background()
+
+	pair, _ := svc.IssueTokens(ctx, admin, false)
+	for _, rt := range repo.refresh {
+		rt.ExpiresAt = time.Now().Add(-time.Minute)
+	}
+
+	if _, err := svc.Refresh(ctx, pair.RefreshToken); !errors.Is(err, domain.ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized, got %v", err)
+	}
+}
+
+func TestAuthLogout_RevokesAccessAndRefresh(t *testing.T) {
+	svc, _, admin := newTestAuthService()
+	ctx := context.Background()
+
+	pair, _ := svc.IssueTokens(ctx, admin, false)
+	claims, _ := svc.ValidateAccessToken(ctx, pair.AccessToken)
+
+	if err := svc.Logout(ctx, claims, pair.RefreshToken); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := svc.ValidateAccessToken(ctx, pair.AccessToken); !errors.Is(err, domain.ErrUnauthorized) {
+		t.Fatalf("expected denylisted access token to be rejected, got %v", err)
+	}
+	if _, err := svc.Refresh(ctx, pair.RefreshToken); !errors.Is(err, domain.ErrUnauthorized) {
+		t.Fatalf("expected revoked refresh token to be rejected, got %v", err)
+	}
+}
+
+func TestAuthLogout_AllSessions(t *testing.T) {
+	svc, _, admin := newTestAuthService()
+	ctx := context.Background()
+
+	a, _ := svc.IssueTokens(ctx, admin, false)
+	b, _ := svc.IssueTokens(ctx, admin, false)
+	claims, _ := svc.ValidateAccessToken(ctx, a.AccessToken)
+
+	if err := svc.Logout(ctx, claims, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := svc.Refresh(ctx, b.RefreshToken); !errors.Is(err, domain.ErrUnauthorized) {
+		t.Fatalf("expected every session to be revoked, got %v", err)
+	}
+}
+
+func TestAuthValidate_KeyRotation(t *testing.T) {
+	old := auth.NewJWTManager(auth.JWTKey{ID: "k1", Secret: "secret-1"}, nil, time.Minute)
+	token, _, _ := old.Generate(auth.Subject{UserID: "admin"})
+
+	rotated := auth.NewJWTManager(
+		auth.JWTKey{ID: "k2", Secret: "secret-2"},
+		[]auth.JWTKey{{ID: "k1", Secret: "secret-1"}},
+		time.Minute,
+	)
+	if _, err := rotated.Validate(token); err != nil {
+		t.Fatalf("expected token signed with previous key to validate: %v", err)
+	}
+
+	retired := auth.NewJWTManager(auth.JWTKey{ID: "k2", Secret: "secret-2"}, nil, time.Minute)
+	if _, err := retired.Validate(token); err == nil {
+		t.Fatal("expected token signed with retired key to be rejected")
+	}
+}
+
+func TestAuthLogin_Password(t *testing.T) {
+	env := newAuthTestEnv()
+	ctx := context.Background()
+
+	if _, err := env.svc.Login(ctx, LoginInput{Email: "ADMIN@harbor.local", Password: "admin"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := env.svc.Login(ctx, LoginInput{Email: "admin@harbor.local", Password: "wrong"}); !errors.Is(err, domain.ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized for wrong password, got %v", err)
+	}
+	if _, err := env.svc.Login(ctx, LoginInput{Email: "nobody@harbor.local", Password: "admin"}); !errors.Is(err, domain.ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized for unknown user, got %v", err)
+	}
+	// Unknown emails are compared against a hash as costly as a user's
+	if cost, err := bcrypt.Cost([]byte(dummyPasswordHash)); err != nil || cost != bcrypt.DefaultCost {
+		t.Errorf("expected the dummy hash to use the default cost, got %d, %v", cost, err)
+	}
+}
+
+func TestAuthLogin_TOTPRequired(t *testing.T) {
+	env := newAuthTestEnv()
+	ctx := context.Background()
+	secret, _ := env.enrolTOTP(t, env.admin.ID)
+
+	_, err := env.svc.Login(ctx, LoginInput{Email: "admin@harbor.local", Password: "admin"})
+	if !errors.Is(err, domain.ErrTOTPRequired) {
+		t.Fatalf("expected ErrTOTPRequired, got %v", err)
+	}
+
+	_, err = env.svc.Login(ctx, LoginInput{Email: "admin@harbor.local", Password: "admin", TOTPCode: "000000"})
+	if !errors.Is(err, domain.ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized for bad code, got %v", err)
+	}
+
+	// Use the next step so the code differs from the one used to confirm enrolment
+	code, _ := auth.TOTPCode(secret, time.Now().Add(30*time.Second))
+	pair, err := env.svc.Login(ctx, LoginInput{Email: "admin@harbor.local", Password: "admin", TOTPCode: code})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	claims, _ := env.svc.ValidateAccessToken(ctx, pair.AccessToken)
+	if !claims.MFA || claims.Role != string(domain.RoleAdmin) {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+}
+
+func TestAuthLogin_TOTPLockout(t *testing.T) {
+	env := newAuthTestEnv()
+	ctx := context.Background()
+	secret, recovery := env.enrolTOTP(t, env.admin.ID)
+	login := func(code, recoveryCode string) error {
+		_, err := env.svc.Login(ctx, LoginInput{Email: "admin@harbor.local", Password: "admin", TOTPCode: code, RecoveryCode: recoveryCode})
+		return err
+	}
+	// backdate moves the last failure into the past, as if time had passed
+	backdate := func(d time.Duration) {
+		at := time.Now().Add(-d)
+		env.userRepo.users[env.admin.ID].TOTPFailedAt = &at
+	}
+
+	for i := 0; i < totpFreeFailures; i++ {
+		if err := login("000000", ""); !errors.Is(err, domain.ErrUnauthorized) {
+			t.Fatalf("attempt %d: expected ErrUnauthorized, got %v", i+1, err)
+		}
+	}
+	code, _ := auth.TOTPCode(secret, time.Now().Add(30*time.Second))
+	if err := login(code, ""); !errors.Is(err, domain.ErrTOTPLocked) {
+		t.Fatalf("expected a valid code to be locked out, got %v", err)
+	}
+	if err := login("", recovery[0]); !errors.Is(err, domain.ErrTOTPLocked) {
+		t.Fatalf("expected a recovery code to be locked out, got %v", err)
+	}
+	if err := login("", ""); !errors.Is(err, domain.ErrTOTPRequired) {
+		t.Fatalf("expected ErrTOTPRequired without a code, got %v", err)
+	}
+
+	// Each failure after the lock doubles it
+	backdate(totpBaseLock + time.Second)
+	if err := login("000000", ""); !errors.Is(err, domain.ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized once unlocked, got %v", err)
+	}
+	backdate(totpBaseLock + time.Second)
+	if err := login(code, ""); !errors.Is(err, domain.ErrTOTPLocked) {
+		t.Fatalf("expected the lock to have doubled, got %v", err)
+	}
+
+	backdate(2*totpBaseLock + time.Second)
+	if err := login(code, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if u := env.userRepo.users[env.admin.ID]; u.TOTPFailures != 0 || u.TOTPFailedAt != nil {
+		t.Errorf("expected an accepted code to reset the failures, got %d", u.TOTPFailures)
+	}
+}
+
+func TestAuthLogin_TOTPReplay(t *testing.T) {
+	env := newAuthTestEnv()
+	ctx := context.Background()
+	secret, _ := env.enrolTOTP(t, env.admin.ID)
+
+	code, _ := auth.TOTPCode(secret, time.Now().Add(30*time.Second))
+	input := LoginInput{Email: "admin@harbor.local", Password: "admin", TOTPCode: code}
+	if _, err := env.svc.Login(ctx, input); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := env.svc.Login(ctx, input); !errors.Is(err, domain.ErrUnauthorized) {
+		t.Fatalf("expected replayed code to be rejected, got %v", err)
+	}
+}
+
+func TestAuthLogin_RecoveryCodeSingleUse(t *testing.T) {
+	env := newAuthTestEnv()
+	ctx := context.Background()
+	_, codes := env.enrolTOTP(t, env.admin.ID)
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("expected %d recovery codes, got %d", recoveryCodeCount, len(codes))
+	}
+
+	input := LoginInput{Email: "admin@harbor.local", Password: "admin", RecoveryCode: codes[0]}
+	if _, err := env.svc.Login(ctx, input); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := env.svc.Login(ctx, input); !errors.Is(err, domain.ErrUnauthorized) {
+		t.Fatalf("expected used recovery code to be rejected, got %v", err)
+	}
+}
+
+func TestAuthRefresh_KeepsMFA(t *testing.T) {
+	env := newAuthTestEnv()
+	ctx := context.Background()
+
+	first, _ := env.svc.IssueTokens(ctx, env.admin, true)
+	second, err := env.svc.Refresh(ctx, first.RefreshToken)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	claims, _ := env.svc.ValidateAccessToken(ctx, second.AccessToken)
+	if !claims.MFA {
+		t.Fatal("expected refreshed session to keep its second-factor status")
+	}
+}
+
+func TestAuthCheckTOTPPolicy(t *testing.T) {
+	env := newAuthTestEnv()
+	ctx := context.Background()
+	env.settings.policy.RequireTOTPForDeployers = true
+
+	viewer, _ := env.users.Create(ctx, CreateUserInput{Email: "viewer@harbor.local", Password: "pw"})
+
+	tests := []struct {
+		name    string
+		user    *domain.User
+		mfa     bool
+		wantErr error
+	}{
+		{"admin without mfa", env.admin, false, domain.ErrTOTPEnrolment},
+		{"admin with mfa", env.admin, true, nil},
+		{"viewer without mfa", viewer, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pair, _ := env.svc.IssueTokens(ctx, tt.user, tt.mfa)
+			claims, _ := env.svc.ValidateAccessToken(ctx, pair.AccessToken)
+			if err := env.svc.CheckTOTPPolicy(ctx, claims); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+var resourceName = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,99}$`)
+
+type ChannelService struct {
+	repo      domain.ChannelRepository
+	artRepo   domain.ArtifactRepository
+	deploySvc *DeploymentService
+	auditSvc  *AuditService
+	log       *slog.Logger
+}
+
+func NewChannelService(repo domain.ChannelRepository, artRepo domain.ArtifactRepository, deploySvc *DeploymentService, auditSvc *AuditService, log *slog.Logger) *ChannelService {
+	return &ChannelService{repo: repo, artRepo: artRepo, deploySvc: deploySvc, auditSvc: auditSvc, log: log}
+}
+
+type CreateChannelInput struct {
+	OrgID       uuid.UUID
+	Name        string
+	Description string
+	DeviceTags  []string
+	UpstreamID  *uuid.UUID
+}
+
+// Create adds a release channel. Devices subscribe to it by having all of
+// its device tags.
+func (s *ChannelService) Create(ctx context.Context, input CreateChannelInput) (*domain.Channel, error) {
+	if !resourceName.MatchString(input.Name) {
+		return nil, fmt.Errorf("%w: name must be lowercase letters, digits, '.', '_' or '-'", domain.ErrInvalidInput)
+	}
+	var tags []string
+	for _, tag := range input.DeviceTags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("%w: at least one device tag is required", domain.ErrInvalidInput)
+	}
+	if input.UpstreamID != nil {
+		if _, err := s.repo.GetByID(ctx, input.OrgID, *input.UpstreamID); err != nil {
+			if errors.Is(err, domain.ErrNotFound) {
+				return nil, fmt.Errorf("%w: upstream channel not found", domain.ErrInvalidInput)
+			}
+			return nil, err
+		}
+	}
+
+	channel := &domain.Channel{
+		OrgID:       input.OrgID,
+		Name:        input.Name,
+		Description: input.Description,
+		DeviceTags:  tags,
+		UpstreamID:  input.UpstreamID,
+	}
+	if err := s.repo.Create(ctx, channel); err != nil {
+		return nil, err
+	}
+
+	s.log.Info("channel created", "id", channel.ID, "name", channel.Name)
+	return channel, nil
+}
+
+func (s *ChannelService) GetByID(ctx context.Context, orgID, id uuid.UUID) (*domain.Channel, error) {
+	return s.repo.GetByID(ctx, orgID, id)
+}
+
+func (s *ChannelService) List(ctx context.Context, orgID uuid.UUID) ([]*domain.Channel, error) {
+	return s.repo.List(ctx, orgID)
+}
+
+// Delete removes a channel and cancels its continuous deployment. Channels
+// downstream of it are left without an upstream.
+func (s *ChannelService) Delete(ctx context.Context, orgID, id uuid.UUID) error {
+	channel, err := s.repo.GetByID(ctx, orgID, id)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.Delete(ctx, orgID, id); err != nil {
+		return err
+	}
+	s.stopDeployment(ctx, orgID, channel.DeploymentID)
+	return nil
+}
+
+type PromoteInput struct {
+	OrgID      uuid.UUID
+	ChannelID  uuid.UUID
+	ArtifactID uuid.UUID
+	// Actor and IPAddress identify who promoted, for the audit log
+	Actor     string
+	IPAddress string
+	Reason    string
+}
+
+// Promote makes an artifact the current version of a channel. It creates a
+// continuous deployment of the artifact to the devices of the channel and
+// cancels the one of the previous promotion. If the channel has an upstream,
+// the artifact must have been promoted to it first.
+func (s *ChannelService) Promote(ctx context.Context, input PromoteInput) (*domain.ChannelPromotion, error) {
+	input.Reason = strings.TrimSpace(input.Reason)
+	if input.Reason == "" {
+		return nil, fmt.Errorf("%w: reason is required", domain.ErrInvalidInput)
+	}
+
+	channel, err := s.repo.GetByID(ctx, input.OrgID, input.ChannelID)
+	if err != nil {
+		return nil, err
+	}
+	artifact, err := s.artRepo.GetByID(ctx, input.OrgID, input.ArtifactID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, fmt.Errorf("%w: artifact not found", domain.ErrInvalidInput)
+		}
+		return nil, err
+	}
+	if channel.ArtifactID != nil && *channel.ArtifactID == artifact.ID {
+		return nil, fmt.Errorf("%w: %s %s is already the current version of %s", domain.ErrConflict, artifact.Name, artifact.Version, channel.Name)
+	}
+	if channel.UpstreamID != nil {
+		upstream, err := s.repo.GetByID(ctx, input.OrgID, *channel.UpstreamID)
+		if err != nil {
+			return nil, fmt.Errorf("upstream channel: %w", err)
+		}
+		promoted, err := s.repo.HasPromotion(ctx, input.OrgID, upstream.ID, artifact.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !promoted {
+			return nil, fmt.Errorf("%w: %s %s must be promoted to %s first", domain.ErrInvalidInput, artifact.Name, artifact.Version, upstream.Name)
+		}
+	}
+
+	deployment, err := s.deploySvc.Create(ctx, CreateDeploymentInput{
+		OrgID:            input.OrgID,
+		Name:             fmt.Sprintf("%s: %s %s", channel.Name, artifact.Name, artifact.Version),
+		ArtifactID:       artifact.ID,
+		TargetDeviceTags: channel.DeviceTags,
+		Continuous:       true,
+		ChannelID:        &channel.ID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	promotion := &domain.ChannelPromotion{
+		OrgID:        input.OrgID,
+		ChannelID:    channel.ID,
+		ArtifactID:   artifact.ID,
+		DeploymentID: &deployment.ID,
+		PromotedBy:   input.Actor,
+		Reason:       input.Reason,
+	}
+	if err := s.repo.CreatePromotion(ctx, promotion); err != nil {
+		s.stopDeployment(ctx, input.OrgID, &deployment.ID)
+		return nil, fmt.Errorf("record promotion: %w", err)
+	}
+	s.stopDeployment(ctx, input.OrgID, channel.DeploymentID)
+
+	details := map[string]interface{}{
+		"channel":       channel.Name,
+		"artifact_name": artifact.Name,
+		"version":       artifact.Version,
+		"deployment_id": deployment.ID.String(),
+		"reason":        input.Reason,
+	}
+	if channel.ArtifactID != nil {
+		details["previous_artifact_id"] = channel.ArtifactID.String()
+	}
+	s.auditSvc.Log(ctx, &domain.AuditEntry{
+		OrgID:      &input.OrgID,
+		Actor:      input.Actor,
+		ActorType:  "management",
+		Action:     "channel.promote",
+		Resource:   "channel",
+		ResourceID: channel.ID.String(),
+		Details:    details,
+		IPAddress:  input.IPAddress,
+	})
+
+	s.log.Info("artifact promoted", "channel", channel.Name, "artifact", artifact.ID, "version", artifact.Version, "by", input.Actor)
+	return promotion, nil
+}
+
+// stopDeployment cancels the continuous deployment of a channel, if it is
+// still running.
+func (s *ChannelService) stopDeployment(ctx context.Context, orgID uuid.UUID, id *uuid.UUID) {
+	if id == nil {
+		return
+	}
+	if err := s.deploySvc.Cancel(ctx, orgID, *id); err != nil && !errors.Is(err, domain.ErrInvalidInput) && !errors.Is(err, domain.ErrNotFound) {
+		s.log.Warn("failed to cancel channel deployment", "deployment", *id, "err", err)
+	}
+}
+
+// ListPromotions returns the promotions of a channel, newest first.
+func (s *ChannelService) ListPromotions(ctx context.Context, orgID, channelID uuid.UUID) ([]*domain.ChannelPromotion, error) {
+	if _, err := s.repo.GetByID(ctx, orgID, channelID); err != nil {
+		return nil, err
+	}
+	return s.repo.ListPromotions(ctx, orgID, channelID)
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+type channelTestEnv struct {
+	*deploymentTestEnv
+	svc       *ChannelService
+	repo      *mockChannelRepo
+	auditRepo *mockAuditRepo
+}
+
+func newTestChannelService() *channelTestEnv {
+	env := newTestDeploymentService()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	repo := newMockChannelRepo()
+	auditRepo := &mockAuditRepo{}
+	svc := NewChannelService(repo, env.artRepo, env.svc, NewAuditService(auditRepo, log), log)
+	return &channelTestEnv{deploymentTestEnv: env, svc: svc, repo: repo, auditRepo: auditRepo}
+}
+
+func TestChannelCreate_Validation(t *testing.T) {
+	env := newTestChannelService()
+	ctx := context.Background()
+
+	cases := map[string]CreateChannelInput{
+		"invalid name":     {OrgID: testOrgID, Name: "Stable Channel", DeviceTags: []string{"stable"}},
+		"no device tags":   {OrgID: testOrgID, Name: "stable", DeviceTags: []string{" "}},
+		"unknown upstream": {OrgID: testOrgID, Name: "stable", DeviceTags: []string{"stable"}, UpstreamID: ptr(uuid.New())},
+	}
+	for name, input := range cases {
+		if _, err := env.svc.Create(ctx, input); !errors.Is(err, domain.ErrInvalidInput) {
+			t.Errorf("%s: expected ErrInvalidInput, got %v", name, err)
+		}
+	}
+
+	if _, err := env.svc.Create(ctx, CreateChannelInput{OrgID: testOrgID, Name: "stable", DeviceTags: []string{"stable"}}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := env.svc.Create(ctx, CreateChannelInput{OrgID: testOrgID, Name: "stable", DeviceTags: []string{"other"}}); !errors.Is(err, domain.ErrConflict) {
+		t.Errorf("expected ErrConflict for a duplicate name, got %v", err)
+	}
+}
+
+func TestChannelPromote(t *testing.T) {
+	env := newTestChannelService()
+	ctx := context.Background()
+
+	staging, _ := env.svc.Create(ctx, CreateChannelInput{OrgID: testOrgID, Name: "staging", DeviceTags: []string{"staging"}})
+	stable, _ := env.svc.Create(ctx, CreateChannelInput{OrgID: testOrgID, Name: "stable", DeviceTags: []string{"stable"}, UpstreamID: &staging.ID})
+	stagingDevice := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{"staging"})
+	env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{"stable"})
+	v1 := env.createArtifact(ctx, "myapp", "1.0.0", []string{"raspberry-pi-4"})
+	v2 := env.createArtifact(ctx, "myapp", "1.1.0", []string{"raspberry-pi-4"})
+
+	promote := func(channel *domain.Channel, artifact *domain.Artifact) (*domain.ChannelPromotion, error) {
+		return env.svc.Promote(ctx, PromoteInput{
+			OrgID: testOrgID, ChannelID: channel.ID, ArtifactID: artifact.ID, Actor: "user-1", Reason: "release " + artifact.Version,
+		})
+	}
+
+	if _, err := env.svc.Promote(ctx, PromoteInput{OrgID: testOrgID, ChannelID: staging.ID, ArtifactID: v1.ID, Actor: "user-1"}); !errors.Is(err, domain.ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput without a reason, got %v", err)
+	}
+	if _, err := promote(stable, v1); !errors.Is(err, domain.ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput before promotion to the upstream, got %v", err)
+	}
+
+	p1, err := promote(staging, v1)
+	if err != nil {
+		t.Fatalf("promote to staging: %v", err)
+	}
+	dep1, _ := env.deployRepo.GetByID(ctx, testOrgID, *p1.DeploymentID)
+	if !dep1.Continuous || dep1.ChannelID == nil || *dep1.ChannelID != staging.ID {
+		t.Fatalf("expected a continuous deployment of the channel, got %+v", dep1)
+	}
+	dds, _ := env.deployRepo.GetDeploymentDevices(ctx, testOrgID, dep1.ID)
+	if len(dds) != 1 || dds[0].DeviceID != stagingDevice.ID {
+		t.Fatalf("expected only the staging device, got %+v", dds)
+	}
+	if _, err := promote(staging, v1); !errors.Is(err, domain.ErrConflict) {
+		t.Errorf("expected ErrConflict promoting the current version, got %v", err)
+	}
+	if _, err := promote(stable, v1); err != nil {
+		t.Fatalf("promote to stable: %v", err)
+	}
+
+	// A new promotion replaces the deployment of the channel
+	p2, err := promote(staging, v2)
+	if err != nil {
+		t.Fatalf("promote v2: %v", err)
+	}
+	if dep1, _ = env.deployRepo.GetByID(ctx, testOrgID, dep1.ID); dep1.Status != domain.DeploymentStatusCancelled {
+		t.Errorf("expected the previous deployment cancelled, got %s", dep1.Status)
+	}
+	channel, _ := env.svc.GetByID(ctx, testOrgID, staging.ID)
+	if *channel.ArtifactID != v2.ID || *channel.DeploymentID != *p2.DeploymentID {
+		t.Errorf("expected v2 current in staging, got %+v", channel)
+	}
+
+	promotions, _ := env.svc.ListPromotions(ctx, testOrgID, staging.ID)
+	if len(promotions) != 2 || promotions[0].ID != p2.ID || promotions[0].PromotedBy != "user-1" {
+		t.Errorf("unexpected promotions %+v", promotions)
+	}
+
+	var audited int
+	for _, e := range env.auditRepo.entries {
+		if e.Action == "channel.promote" && e.Actor == "user-1" && e.Details["reason"] != "" {
+			audited++
+		}
+	}
+	if audited != 3 {
+		t.Errorf("expected 3 audited promotions, got %d", audited)
+	}
+}
+
+func TestChannelPromote_EnrolsNewDevices(t *testing.T) {
+	env := newTestChannelService()
+	ctx := context.Background()
+
+	stable, _ := env.svc.Create(ctx, CreateChannelInput{OrgID: testOrgID, Name: "stable", DeviceTags: []string{"stable"}})
+	artifact := env.createArtifact(ctx, "myapp", "1.0.0", []string{"raspberry-pi-4"})
+	promotion, err := env.svc.Promote(ctx, PromoteInput{
+		OrgID: testOrgID, ChannelID: stable.ID, ArtifactID: artifact.ID, Actor: "user-1", Reason: "first release",
+	})
+	if err != nil {
+		t.Fatalf("promote: %v", err)
+	}
+
+	// Devices that join the channel later get the deployment when they poll
+	joined := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{"stable"})
+	other := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{"dev"})
+
+	dd, dep, _, err := env.deploymentTestEnv.svc.GetNextForDevice(ctx, testOrgID, joined.ID)
+	if err != nil || dep.ID != *promotion.DeploymentID || dd.DeviceID != joined.ID {
+		t.Fatalf("expected the channel deployment for the new device, got %v %v", dep, err)
+	}
+	if _, _, _, err := env.deploymentTestEnv.svc.GetNextForDevice(ctx, testOrgID, other.ID); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("expected no deployment for a device outside the channel, got %v", err)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
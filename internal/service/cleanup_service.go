@@ -2,31 +2,57 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"slices"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
+
 	"github.com/CaioWing/Harbor/internal/domain"
-	"github.com/CaioWing/Harbor/internal/storage"
+	"github.com/CaioWing/Harbor/internal/semver"
 )
 
+// RetentionPolicy decides which artifact versions the cleanup deletes.
+type RetentionPolicy struct {
+	// KeepVersions is how many versions of each artifact name are kept, by
+	// semantic version; 0 disables retention
+	KeepVersions int
+	// KeepDeployed keeps the artifacts of deployments that are scheduled,
+	// active, or were created or finished within this window
+	KeepDeployed time.Duration
+}
+
+// CleanupService removes data that is no longer needed. Stored artifact
+// files are checked by the ScrubService, which reports problems instead of
+// deleting records.
 type CleanupService struct {
-	artRepo    domain.ArtifactRepository
-	deployRepo domain.DeploymentRepository
-	store      storage.FileStore
-	log        *slog.Logger
+	uploads      *UploadService
+	artifacts    *ArtifactService
+	deployRepo   domain.DeploymentRepository
+	manifestRepo domain.ManifestRepository
+	channelRepo  domain.ChannelRepository
+	orgRepo      domain.OrganizationRepository
+	state        *StateService
+	policy       RetentionPolicy
+	log          *slog.Logger
+
+	// retention serializes passes of the retention policy
+	retention sync.Mutex
 }
 
-func NewCleanupService(
-	artRepo domain.ArtifactRepository,
-	deployRepo domain.DeploymentRepository,
-	store storage.FileStore,
-	log *slog.Logger,
-) *CleanupService {
+func NewCleanupService(uploads *UploadService, artifacts *ArtifactService, deployRepo domain.DeploymentRepository, manifestRepo domain.ManifestRepository, channelRepo domain.ChannelRepository, orgRepo domain.OrganizationRepository, state *StateService, policy RetentionPolicy, log *slog.Logger) *CleanupService {
 	return &CleanupService{
-		artRepo:    artRepo,
-		deployRepo: deployRepo,
-		store:      store,
-		log:        log,
+		uploads:      uploads,
+		artifacts:    artifacts,
+		deployRepo:   deployRepo,
+		manifestRepo: manifestRepo,
+		channelRepo:  channelRepo,
+		orgRepo:      orgRepo,
+		state:        state,
+		policy:       policy,
+		log:          log,
 	}
 }
 
@@ -48,58 +74,189 @@ func (s *CleanupService) StartScheduler(ctx context.Context, interval time.Durat
 	}
 }
 
-// RunCleanup finds artifacts that are not referenced by any active deployment
-// and whose storage files no longer match the DB record. It removes orphan
-// storage files that have no corresponding artifact in the database.
+// RunCleanup removes upload sessions that expired before completion,
+// together with their chunks, and applies the retention policy when one is
+// configured.
 func (s *CleanupService) RunCleanup(ctx context.Context) {
-	s.log.Info("running artifact cleanup")
+	s.log.Info("running cleanup")
+
+	purged := s.uploads.PurgeExpired(ctx)
+
+	deleted := 0
+	if s.policy.KeepVersions > 0 {
+		report, err := s.ApplyRetention(ctx, false)
+		if err != nil {
+			s.log.Error("retention failed", "err", err)
+		} else {
+			deleted = len(report.Deleted)
+		}
+	}
+
+	s.log.Info("cleanup completed", "expired_uploads", purged, "retention_deleted", deleted)
+}
+
+// ApplyRetention deletes, in every organization, the artifact versions
+// beyond the newest KeepVersions of each name, except those deployed within
+// KeepDeployed, those installed on a device, by its deployments or its own
+// report, and those a manifest or a channel refers to.
+// Their finished deployments are deleted with them. A dry run only reports
+// what would be deleted.
+func (s *CleanupService) ApplyRetention(ctx context.Context, dryRun bool) (*domain.RetentionReport, error) {
+	if s.policy.KeepVersions <= 0 {
+		return nil, fmt.Errorf("%w: no retention policy is configured", domain.ErrInvalidInput)
+	}
+	s.retention.Lock()
+	defer s.retention.Unlock()
 
-	artifacts, _, err := s.artRepo.List(ctx, domain.ArtifactFilter{Page: 1, PerPage: 100})
+	report := &domain.RetentionReport{DryRun: dryRun, StartedAt: time.Now(), Deleted: []*domain.RetentionCandidate{}}
+	orgs, err := s.orgRepo.List(ctx)
 	if err != nil {
-		s.log.Warn("cleanup: failed to list artifacts", "err", err)
-		return
+		return nil, err
 	}
+	for _, org := range orgs {
+		candidates, err := s.retentionCandidates(ctx, org.ID)
+		if err != nil {
+			return nil, fmt.Errorf("organization %s: %w", org.ID, err)
+		}
+		for _, c := range candidates {
+			if !dryRun {
+				// What is still in use is kept for a later run
+				if err := s.deleteRetained(ctx, c); err != nil {
+					s.log.Warn("retention could not delete artifact", "org_id", c.OrgID, "id", c.ArtifactID, "error", err)
+					continue
+				}
+			}
+			report.Deleted = append(report.Deleted, c)
+			report.FreedBytes += c.FileSize
+		}
+	}
+
+	if report.UsedBytes, report.QuotaBytes, err = s.artifacts.StorageUsage(ctx); err != nil {
+		return nil, err
+	}
+	report.FinishedAt = time.Now()
+	s.log.Info("retention applied", "dry_run", dryRun, "artifacts", len(report.Deleted), "freed_bytes", report.FreedBytes)
+	return report, nil
+}
 
-	cleaned := 0
-	for _, art := range artifacts {
-		// Check if artifact is referenced by any active deployment
-		deployments, _, err := s.deployRepo.List(ctx, domain.DeploymentFilter{
-			Page:    1,
-			PerPage: 100,
-		})
+// retentionCandidates returns the artifacts of an organization the policy
+// deletes.
+func (s *CleanupService) retentionCandidates(ctx context.Context, orgID uuid.UUID) ([]*domain.RetentionCandidate, error) {
+	const perPage = 100
+	var all []*domain.Artifact
+	byName := make(map[string][]*domain.Artifact)
+	var names []string
+	for page := 1; ; page++ {
+		artifacts, total, err := s.artifacts.List(ctx, orgID, domain.ArtifactFilter{Page: page, PerPage: perPage})
 		if err != nil {
-			s.log.Warn("cleanup: failed to list deployments", "err", err)
-			continue
+			return nil, err
+		}
+		for _, a := range artifacts {
+			if byName[a.Name] == nil {
+				names = append(names, a.Name)
+			}
+			byName[a.Name] = append(byName[a.Name], a)
+		}
+		all = append(all, artifacts...)
+		if len(artifacts) == 0 || page*perPage >= total {
+			break
 		}
+	}
 
-		inUse := false
-		for _, dep := range deployments {
-			if dep.ArtifactID == art.ID &&
-				(dep.Status == domain.DeploymentStatusScheduled || dep.Status == domain.DeploymentStatusActive) {
-				inUse = true
-				break
+	keep, err := s.referencedArtifacts(ctx, orgID, all)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []*domain.RetentionCandidate
+	slices.Sort(names)
+	for _, name := range names {
+		versions := byName[name]
+		slices.SortFunc(versions, newestFirst)
+		for _, a := range versions[min(s.policy.KeepVersions, len(versions)):] {
+			if keep[a.ID] {
+				continue
 			}
+			candidates = append(candidates, &domain.RetentionCandidate{
+				OrgID:      orgID,
+				ArtifactID: a.ID,
+				Name:       a.Name,
+				Version:    a.Version,
+				FileSize:   a.FileSize,
+			})
 		}
+	}
+	return candidates, nil
+}
+
+// referencedArtifacts returns the artifacts of an organization retention
+// must keep, whatever their version.
+func (s *CleanupService) referencedArtifacts(ctx context.Context, orgID uuid.UUID, artifacts []*domain.Artifact) (map[uuid.UUID]bool, error) {
+	keep, err := s.state.InstalledArtifacts(ctx, orgID, artifacts)
+	if err != nil {
+		return nil, err
+	}
+	deployed, err := s.deployRepo.ListDeployedArtifacts(ctx, orgID, time.Now().Add(-s.policy.KeepDeployed))
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range deployed {
+		keep[id] = true
+	}
 
-		if inUse {
-			continue
+	manifests, err := s.manifestRepo.List(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range manifests {
+		for _, item := range m.Items {
+			keep[item.ArtifactID] = true
 		}
+	}
 
-		// Verify the storage file exists and matches
-		reader, err := s.store.Open(art.StoragePath)
+	// Promotions are the history of a channel, the latest its current artifact
+	channels, err := s.channelRepo.List(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range channels {
+		if c.ArtifactID != nil {
+			keep[*c.ArtifactID] = true
+		}
+		promotions, err := s.channelRepo.ListPromotions(ctx, orgID, c.ID)
 		if err != nil {
-			// Storage file is missing — clean up the DB record
-			s.log.Info("cleanup: removing artifact with missing storage file",
-				"id", art.ID, "name", art.Name, "path", art.StoragePath)
-			if err := s.artRepo.Delete(ctx, art.ID); err != nil {
-				s.log.Warn("cleanup: failed to delete orphan artifact", "id", art.ID, "err", err)
-			} else {
-				cleaned++
-			}
-			continue
+			return nil, err
 		}
-		reader.Close()
+		for _, p := range promotions {
+			keep[p.ArtifactID] = true
+		}
+	}
+	return keep, nil
+}
+
+// newestFirst orders artifacts of one name by descending semantic version.
+// Versions that do not parse come last, newest upload first.
+func newestFirst(a, b *domain.Artifact) int {
+	va, errA := semver.Parse(a.Version)
+	vb, errB := semver.Parse(b.Version)
+	switch {
+	case errA == nil && errB == nil:
+		return vb.Compare(va)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
 	}
+	return b.CreatedAt.Compare(a.CreatedAt)
+}
 
-	s.log.Info("cleanup completed", "removed", cleaned)
+// deleteRetained deletes an artifact with its finished deployments, which
+// reference it. It fails with ErrConflict if the artifact came into use
+// since the candidates were listed.
+func (s *CleanupService) deleteRetained(ctx context.Context, c *domain.RetentionCandidate) error {
+	if err := s.artifacts.DeleteWithDeployments(ctx, c.OrgID, c.ArtifactID); err != nil {
+		return err
+	}
+	s.log.Info("artifact deleted by retention", "org_id", c.OrgID, "id", c.ArtifactID, "name", c.Name, "version", c.Version)
+	return nil
 }
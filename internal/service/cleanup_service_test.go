@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/CaioWing/Harbor/internal/archive"
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+func TestApplyRetention(t *testing.T) {
+	env := newTestDeploymentService()
+	ctx := context.Background()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := newMockFileStore()
+	artifactSvc := NewArtifactService(env.artRepo, newMockBlobRepo(), store, NewSigningService(newMockSigningKeyRepo(), nil, log), newDisabledDeltaService(env.artRepo, store, log), NewValidationService(store, ValidationConfig{}, log), archive.Limits{}, 0, log)
+	stateSvc := NewStateService(env.deployRepo, env.deviceRepo, env.artRepo, log)
+	manifestRepo := newMockManifestRepo()
+	channelRepo := newMockChannelRepo()
+	newCleanup := func(policy RetentionPolicy) *CleanupService {
+		return NewCleanupService(nil, artifactSvc, env.deployRepo, manifestRepo, channelRepo, newMockOrganizationRepo(newMockUserRepo()), stateSvc, policy, log)
+	}
+
+	device := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{})
+	deploy := func(artifact *domain.Artifact, succeed bool) *domain.Deployment {
+		dep, err := env.svc.Create(ctx, CreateDeploymentInput{
+			OrgID: testOrgID, Name: "install " + artifact.Version, ArtifactID: artifact.ID, TargetDeviceIDs: []uuid.UUID{device.ID},
+		})
+		if err != nil {
+			t.Fatalf("create deployment: %v", err)
+		}
+		if succeed {
+			dds, _ := env.deployRepo.GetDeploymentDevices(ctx, testOrgID, dep.ID)
+			for _, dd := range dds {
+				env.svc.UpdateDeviceStatus(ctx, testOrgID, dd.DeviceID, dd.ID, domain.DDStatusSuccess, "")
+			}
+		}
+		return dep
+	}
+
+	never := env.createArtifact(ctx, "myapp", "0.9.0", []string{"raspberry-pi-4"})
+	replaced := env.createArtifact(ctx, "myapp", "1.0.0", []string{"raspberry-pi-4"})
+	installed := env.createArtifact(ctx, "myapp", "2.0.0", []string{"raspberry-pi-4"})
+	pending := env.createArtifact(ctx, "myapp", "3.0.0", []string{"raspberry-pi-4"})
+	newest := env.createArtifact(ctx, "myapp", "4.0.0", []string{"raspberry-pi-4"})
+	otherName := env.createArtifact(ctx, "tool", "1.0.0", []string{"raspberry-pi-4"})
+	manifested := env.createArtifact(ctx, "myapp", "0.8.0", []string{"raspberry-pi-4"})
+	promoted := env.createArtifact(ctx, "myapp", "0.7.0", []string{"raspberry-pi-4"})
+	reported := env.createArtifact(ctx, "myapp", "0.6.0", []string{"raspberry-pi-4"})
+
+	// Referenced by a manifest, by a channel's history, and by the device's
+	// own report of what it runs
+	manifestRepo.Create(ctx, &domain.Manifest{
+		OrgID: testOrgID, Name: "kiosk", DeviceTags: []string{"kiosk"}, Items: []domain.ManifestItem{{ArtifactID: manifested.ID}},
+	})
+	channel := &domain.Channel{OrgID: testOrgID, Name: "stable", DeviceTags: []string{"stable"}}
+	channelRepo.Create(ctx, channel)
+	for _, a := range []*domain.Artifact{promoted, newest} {
+		channelRepo.CreatePromotion(ctx, &domain.ChannelPromotion{OrgID: testOrgID, ChannelID: channel.ID, ArtifactID: a.ID, PromotedBy: "admin", Reason: "release"})
+	}
+	env.deviceRepo.UpdateInventory(ctx, testOrgID, device.ID, map[string]interface{}{
+		domain.InventoryInstalledKey: map[string]interface{}{reported.TargetPath: map[string]interface{}{"version": "0.6.0"}},
+	})
+	replacedDep := deploy(replaced, true)
+	env.deployRepo.UpdateStatus(ctx, testOrgID, replacedDep.ID, domain.DeploymentStatusCompleted)
+	deploy(installed, true)
+	deploy(pending, false)
+
+	if _, err := newCleanup(RetentionPolicy{}).ApplyRetention(ctx, true); !errors.Is(err, domain.ErrInvalidInput) {
+		t.Fatalf("expected ErrInvalidInput without a policy, got %v", err)
+	}
+
+	// A window covering the deployments keeps what they deployed
+	report, err := newCleanup(RetentionPolicy{KeepVersions: 1, KeepDeployed: time.Hour}).ApplyRetention(ctx, true)
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if len(report.Deleted) != 1 || report.Deleted[0].ArtifactID != never.ID {
+		t.Fatalf("expected only 0.9.0 within the window, got %+v", report.Deleted)
+	}
+
+	// Finished deployments are outside a zero window
+	cleanup := newCleanup(RetentionPolicy{KeepVersions: 1})
+	report, err = cleanup.ApplyRetention(ctx, true)
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if !report.DryRun || len(report.Deleted) != 2 || report.FreedBytes != 200 {
+		t.Fatalf("expected 2 artifacts and 200 bytes on the dry run, got %+v", report)
+	}
+	if report.Deleted[0].ArtifactID != replaced.ID || report.Deleted[1].ArtifactID != never.ID {
+		t.Fatalf("expected 1.0.0 and 0.9.0, got %s and %s", report.Deleted[0].Version, report.Deleted[1].Version)
+	}
+	if _, err := env.artRepo.GetByID(ctx, testOrgID, replaced.ID); err != nil {
+		t.Fatalf("dry run deleted an artifact: %v", err)
+	}
+
+	if _, err := cleanup.ApplyRetention(ctx, false); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	for _, a := range []*domain.Artifact{never, replaced} {
+		if _, err := env.artRepo.GetByID(ctx, testOrgID, a.ID); !errors.Is(err, domain.ErrNotFound) {
+			t.Fatalf("expected %s to be deleted, got %v", a.Version, err)
+		}
+	}
+	for _, a := range []*domain.Artifact{installed, pending, newest, otherName, manifested, promoted, reported} {
+		if _, err := env.artRepo.GetByID(ctx, testOrgID, a.ID); err != nil {
+			t.Fatalf("expected %s %s to be kept, got %v", a.Name, a.Version, err)
+		}
+	}
+	if _, err := env.deployRepo.GetByID(ctx, testOrgID, replacedDep.ID); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("expected the deployment of 1.0.0 to be deleted, got %v", err)
+	}
+
+	// An artifact that came into use after it was listed is not deleted
+	err = cleanup.deleteRetained(ctx, &domain.RetentionCandidate{OrgID: testOrgID, ArtifactID: pending.ID})
+	if !errors.Is(err, domain.ErrConflict) {
+		t.Fatalf("expected ErrConflict for an active deployment, got %v", err)
+	}
+	if _, err := env.artRepo.GetByID(ctx, testOrgID, pending.ID); err != nil {
+		t.Fatalf("expected 3.0.0 to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/CaioWing/Harbor/internal/domain"
+	"github.com/CaioWing/Harbor/internal/semver"
+)
+
+// validateRequirements checks the requirements of a new artifact, which may
+// be nil.
+func validateRequirements(req *domain.Requirements) error {
+	if req == nil {
+		return nil
+	}
+	for field, version := range map[string]string{"min_os_version": req.MinOSVersion, "max_os_version": req.MaxOSVersion} {
+		if _, err := semver.Parse(version); version != "" && err != nil {
+			return fmt.Errorf("%w: %s: %v", domain.ErrInvalidInput, field, err)
+		}
+	}
+	if c, ok := compareVersions(req.MinOSVersion, req.MaxOSVersion); ok && c > 0 {
+		return fmt.Errorf("%w: min_os_version is higher than max_os_version", domain.ErrInvalidInput)
+	}
+	if slices.Contains(req.Arch, "") || slices.Contains(req.InventoryKeys, "") {
+		return fmt.Errorf("%w: arch and inventory_keys cannot have empty entries", domain.ErrInvalidInput)
+	}
+	for _, dep := range req.Depends {
+		if dep.Name == "" {
+			return fmt.Errorf("%w: every dependency needs a name", domain.ErrInvalidInput)
+		}
+		if _, err := semver.Parse(dep.MinVersion); dep.MinVersion != "" && err != nil {
+			return fmt.Errorf("%w: dependency %s: %v", domain.ErrInvalidInput, dep.Name, err)
+		}
+	}
+	return nil
+}
+
+// compareVersions compares two semantic versions. ok is false if either does
+// not parse.
+func compareVersions(a, b string) (c int, ok bool) {
+	va, err := semver.Parse(a)
+	if err != nil {
+		return 0, false
+	}
+	vb, err := semver.Parse(b)
+	if err != nil {
+		return 0, false
+	}
+	return va.Compare(vb), true
+}
+
+// installedVersions returns, for each of devices, the version of each
+// artifact name it has installed. Devices that report an installed section
+// in their inventory are read from it, at the target paths of the artifacts
+// of each name; the deployment history is used for the others, and for
+// names whose artifacts install no file, as scripts. Only dependencies need
+// it, so it returns nil for an artifact without any.
+func (s *DeploymentService) installedVersions(ctx context.Context, orgID uuid.UUID, artifact *domain.Artifact, devices []*domain.Device) (map[uuid.UUID]map[string]string, error) {
+	if artifact.Requirements == nil || len(artifact.Requirements.Depends) == 0 || len(devices) == 0 {
+		return nil, nil
+	}
+	ids := make([]uuid.UUID, len(devices))
+	for i, d := range devices {
+		ids[i] = d.ID
+	}
+	installations, err := s.deployRepo.ListInstallations(ctx, orgID, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	artifacts := make(map[uuid.UUID]*domain.Artifact)
+	versions := make(map[uuid.UUID]map[string]string)
+	for _, d := range devices {
+		versions[d.ID] = make(map[string]string)
+	}
+	for _, inst := range installations {
+		a, ok := artifacts[inst.ArtifactID]
+		if !ok {
+			if a, err = s.artRepo.GetByID(ctx, orgID, inst.ArtifactID); err != nil {
+				return nil, fmt.Errorf("artifact %s: %w", inst.ArtifactID, err)
+			}
+			artifacts[inst.ArtifactID] = a
+		}
+		versions[inst.DeviceID][a.Name] = a.Version
+	}
+
+	paths := make(map[string][]string)
+	for _, dep := range artifact.Requirements.Depends {
+		if _, ok := paths[dep.Name]; ok {
+			continue
+		}
+		named, err := s.artRepo.ListByName(ctx, orgID, dep.Name)
+		if err != nil {
+			return nil, fmt.Errorf("artifacts named %s: %w", dep.Name, err)
+		}
+		paths[dep.Name] = nil
+		for _, a := range named {
+			for path := range installedFiles(a) {
+				if !slices.Contains(paths[dep.Name], path) {
+					paths[dep.Name] = append(paths[dep.Name], path)
+				}
+			}
+		}
+	}
+	for _, d := range devices {
+		if _, ok := d.Inventory[domain.InventoryInstalledKey].(map[string]interface{}); !ok {
+			continue
+		}
+		for name, namePaths := range paths {
+			if len(namePaths) == 0 {
+				continue
+			}
+			delete(versions[d.ID], name)
+			for _, path := range namePaths {
+				actual, ok := domain.InstalledAt(d.Inventory, path)
+				if !ok {
+					continue
+				}
+				if c, ok := compareVersions(actual.Version, versions[d.ID][name]); versions[d.ID][name] == "" || ok && c > 0 {
+					versions[d.ID][name] = actual.Version
+				}
+			}
+		}
+	}
+	return versions, nil
+}
+
+// incompatibility returns why device cannot receive artifact, or "" if it
+// can. installed holds the versions the device has installed, as returned
+// by installedVersions.
+func incompatibility(artifact *domain.Artifact, device *domain.Device, installed map[string]string) string {
+	if !slices.Contains(artifact.DeviceTypes, device.DeviceType) {
+		return fmt.Sprintf("device type %s is not supported by the artifact", device.DeviceType)
+	}
+	if free, ok := device.Inventory[domain.InventoryFreeDiskKey].(float64); ok && float64(artifact.FileSize) > free {
+		return fmt.Sprintf("artifact needs %d bytes of disk, device has %.0f free", artifact.FileSize, free)
+	}
+
+	req := artifact.Requirements
+	if req == nil {
+		return ""
+	}
+	if req.MinOSVersion != "" || req.MaxOSVersion != "" {
+		osVersion, _ := device.Inventory[domain.InventoryOSVersionKey].(string)
+		if _, err := semver.Parse(osVersion); err != nil {
+			return fmt.Sprintf("device does not report a semantic %s", domain.InventoryOSVersionKey)
+		}
+		if c, ok := compareVersions(osVersion, req.MinOSVersion); ok && c < 0 {
+			return fmt.Sprintf("os_version %s is lower than %s", osVersion, req.MinOSVersion)
+		}
+		if c, ok := compareVersions(osVersion, req.MaxOSVersion); ok && c > 0 {
+			return fmt.Sprintf("os_version %s is higher than %s", osVersion, req.MaxOSVersion)
+		}
+	}
+	if len(req.Arch) > 0 {
+		arch, _ := device.Inventory[domain.InventoryArchKey].(string)
+		if !slices.Contains(req.Arch, arch) {
+			return fmt.Sprintf("arch %q is not one of %s", arch, strings.Join(req.Arch, ", "))
+		}
+	}
+	for _, key := range req.InventoryKeys {
+		if _, ok := device.Inventory[key]; !ok {
+			return fmt.Sprintf("inventory does not report %s", key)
+		}
+	}
+	for _, dep := range req.Depends {
+		version, ok := installed[dep.Name]
+		if !ok {
+			return fmt.Sprintf("depends on %s, which is not installed", dep.Name)
+		}
+		if dep.MinVersion == "" {
+			continue
+		}
+		if c, ok := compareVersions(version, dep.MinVersion); !ok || c < 0 {
+			return fmt.Sprintf("depends on %s %s or higher, %s is installed", dep.Name, dep.MinVersion, version)
+		}
+	}
+	return ""
+}
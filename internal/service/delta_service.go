@@ -0,0 +1,243 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/google/uuid"
+
+	"github.com/CaioWing/Harbor/internal/delta"
+	"github.com/CaioWing/Harbor/internal/domain"
+	"github.com/CaioWing/Harbor/internal/storage"
+)
+
+// DeltaConfig bounds delta generation. Diffing holds both files in memory
+// plus a suffix array of the older one, about 17 bytes per byte of input.
+type DeltaConfig struct {
+	// MaxSources is how many previous versions get a delta to each new
+	// version. Zero disables delta generation.
+	MaxSources int
+	// MaxFileSize skips artifacts larger than this, in bytes.
+	MaxFileSize int64
+}
+
+type DeltaService struct {
+	repo       domain.ArtifactDeltaRepository
+	artRepo    domain.ArtifactRepository
+	deviceRepo domain.DeviceRepository
+	store      storage.FileStore
+	cfg        DeltaConfig
+	log        *slog.Logger
+
+	// Limits generation to one artifact at a time
+	sem chan struct{}
+}
+
+func NewDeltaService(
+	repo domain.ArtifactDeltaRepository,
+	artRepo domain.ArtifactRepository,
+	deviceRepo domain.DeviceRepository,
+	store storage.FileStore,
+	cfg DeltaConfig,
+	log *slog.Logger,
+) *DeltaService {
+	return &DeltaService{
+		repo:       repo,
+		artRepo:    artRepo,
+		deviceRepo: deviceRepo,
+		store:      store,
+		cfg:        cfg,
+		log:        log,
+		sem:        make(chan struct{}, 1),
+	}
+}
+
+// Schedule generates deltas for a newly uploaded artifact in the background.
+func (s *DeltaService) Schedule(artifact *domain.Artifact) {
+	if s.cfg.MaxSources <= 0 {
+		return
+	}
+	go func() {
+		s.sem <- struct{}{}
+		defer func() { <-s.sem }()
+
+		if _, err := s.Generate(context.Background(), artifact); err != nil {
+			s.log.Warn("delta generation failed", "artifact", artifact.ID, "err", err)
+		}
+	}()
+}
+
+// Generate creates deltas to artifact from the most recent previous versions
+// with the same name. Deltas that are not smaller than the full file are not
+// kept. Artifacts encrypted for each device get no deltas, from or to them:
+// a patch reveals the content it was computed from. Bundles, templates and
+// operations get none either.
+func (s *DeltaService) Generate(ctx context.Context, artifact *domain.Artifact) ([]*domain.ArtifactDelta, error) {
+	if s.cfg.MaxSources <= 0 || artifact.FileSize > s.cfg.MaxFileSize || artifact.EncryptForDevice || artifact.IsBundle() || artifact.IsTemplate() || artifact.IsOperation() {
+		return nil, nil
+	}
+
+	versions, err := s.artRepo.ListByName(ctx, artifact.OrgID, artifact.Name)
+	if err != nil {
+		return nil, fmt.Errorf("list versions: %w", err)
+	}
+
+	var sources []*domain.Artifact
+	for _, v := range versions {
+		if len(sources) == s.cfg.MaxSources {
+			break
+		}
+		if v.ID == artifact.ID || v.ChecksumSHA256 == artifact.ChecksumSHA256 || v.FileSize > s.cfg.MaxFileSize || v.EncryptForDevice || v.IsBundle() || v.IsTemplate() || v.IsOperation() {
+			continue
+		}
+		sources = append(sources, v)
+	}
+	if len(sources) == 0 {
+		return nil, nil
+	}
+
+	newData, err := s.readFile(artifact.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var created []*domain.ArtifactDelta
+	for _, src := range sources {
+		d, err := s.generateFrom(ctx, artifact, src, newData)
+		if err != nil {
+			s.log.Warn("delta generation failed", "artifact", artifact.ID, "source", src.ID, "err", err)
+			continue
+		}
+		if d != nil {
+			created = append(created, d)
+		}
+	}
+	return created, nil
+}
+
+func (s *DeltaService) generateFrom(ctx context.Context, artifact, src *domain.Artifact, newData []byte) (*domain.ArtifactDelta, error) {
+	oldData, err := s.readFile(src.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+
+	patch, err := delta.Diff(oldData, newData)
+	if err != nil {
+		return nil, fmt.Errorf("diff: %w", err)
+	}
+	if int64(len(patch)) >= artifact.FileSize {
+		s.log.Info("delta not smaller than full file, skipped",
+			"artifact", artifact.ID, "source_version", src.Version, "size", len(patch))
+		return nil, nil
+	}
+
+	sum := sha256.Sum256(patch)
+	storageName := fmt.Sprintf("%s_%s_%s_from_%s.delta", artifact.OrgID, artifact.Name, artifact.Version, src.Version)
+	path, size, err := s.store.Save(storageName, bytes.NewReader(patch))
+	if err != nil {
+		return nil, fmt.Errorf("save delta: %w", err)
+	}
+
+	d := &domain.ArtifactDelta{
+		OrgID:            artifact.OrgID,
+		ArtifactID:       artifact.ID,
+		SourceArtifactID: src.ID,
+		SourceVersion:    src.Version,
+		SourceChecksum:   src.ChecksumSHA256,
+		FileSize:         size,
+		ChecksumSHA256:   hex.EncodeToString(sum[:]),
+		StoragePath:      path,
+	}
+	if err := s.repo.Create(ctx, d); err != nil {
+		s.store.Delete(path)
+		return nil, fmt.Errorf("create delta: %w", err)
+	}
+
+	s.log.Info("delta created", "artifact", artifact.ID, "source_version", src.Version,
+		"size", size, "full_size", artifact.FileSize)
+	return d, nil
+}
+
+// ForDevice returns the delta to artifact from the version the device
+// reports installed at the artifact's target path, or nil when there is none.
+// A reported checksum that differs from the source version's means the file
+// was changed on the device, so the full file is needed.
+func (s *DeltaService) ForDevice(ctx context.Context, orgID, deviceID uuid.UUID, artifact *domain.Artifact) (*domain.ArtifactDelta, error) {
+	if artifact.EncryptForDevice || artifact.IsBundle() || artifact.IsTemplate() || artifact.IsOperation() {
+		return nil, nil
+	}
+	device, err := s.deviceRepo.GetByID(ctx, orgID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	installed, ok := domain.InstalledAt(device.Inventory, artifact.TargetPath)
+	if !ok || installed.Version == artifact.Version {
+		return nil, nil
+	}
+
+	versions, err := s.artRepo.ListByName(ctx, orgID, artifact.Name)
+	if err != nil {
+		return nil, err
+	}
+	var src *domain.Artifact
+	for _, v := range versions {
+		if v.Version == installed.Version {
+			src = v
+			break
+		}
+	}
+	if src == nil {
+		return nil, nil
+	}
+	if installed.ChecksumSHA256 != "" && installed.ChecksumSHA256 != src.ChecksumSHA256 {
+		return nil, nil
+	}
+
+	d, err := s.repo.Get(ctx, orgID, artifact.ID, src.ID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return d, nil
+}
+
+// List returns the deltas that produce or start from an artifact.
+func (s *DeltaService) List(ctx context.Context, orgID, artifactID uuid.UUID) ([]*domain.ArtifactDelta, error) {
+	return s.repo.ListByArtifact(ctx, orgID, artifactID)
+}
+
+func (s *DeltaService) Open(d *domain.ArtifactDelta) (io.ReadSeekCloser, error) {
+	reader, err := s.store.Open(d.StoragePath)
+	if err != nil {
+		return nil, fmt.Errorf("open delta file: %w", err)
+	}
+	return reader, nil
+}
+
+// DirectURL returns a presigned URL for the delta file, like
+// ArtifactService.DirectURL.
+func (s *DeltaService) DirectURL(d *domain.ArtifactDelta, fileName string) (string, error) {
+	presigner, ok := s.store.(storage.Presigner)
+	if !ok {
+		return "", nil
+	}
+	url, _, err := presigner.PresignGet(d.StoragePath, fileName)
+	return url, err
+}
+
+func (s *DeltaService) readFile(path string) ([]byte, error) {
+	reader, err := s.store.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open artifact file: %w", err)
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
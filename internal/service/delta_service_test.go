@@ -0,0 +1,245 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"math/rand"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/CaioWing/Harbor/internal/archive"
+	"github.com/CaioWing/Harbor/internal/delta"
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+type deltaTestEnv struct {
+	deltas    *DeltaService
+	artifacts *ArtifactService
+	artRepo   *mockArtifactRepo
+	devices   *mockDeviceRepo
+	store     *mockFileStore
+}
+
+func newDeltaTestEnv(cfg DeltaConfig) *deltaTestEnv {
+	artRepo := newMockArtifactRepo()
+	store := newMockFileStore()
+	devices := newMockDeviceRepo()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	deltas := NewDeltaService(newMockArtifactDeltaRepo(), artRepo, devices, store, cfg, log)
+	// Uploads do not schedule generation so that tests can run it in the
+	// foreground with the service above
+	artifacts := NewArtifactService(artRepo, newMockBlobRepo(), store, NewSigningService(newMockSigningKeyRepo(), nil, log),
+		NewDeltaService(deltas.repo, artRepo, devices, store, DeltaConfig{}, log), NewValidationService(store, ValidationConfig{}, log), archive.Limits{}, 0, log)
+
+	return &deltaTestEnv{deltas: deltas, artifacts: artifacts, artRepo: artRepo, devices: devices, store: store}
+}
+
+func (e *deltaTestEnv) upload(t *testing.T, version string, content []byte) *domain.Artifact {
+	t.Helper()
+	a, err := e.artifacts.Create(context.Background(), CreateArtifactInput{
+		OrgID:       testOrgID,
+		Name:        "myapp",
+		Version:     version,
+		FileName:    "myapp",
+		TargetPath:  "/usr/local/bin/myapp",
+		DeviceTypes: []string{"raspberry-pi-4"},
+		File:        bytes.NewReader(content),
+	})
+	if err != nil {
+		t.Fatalf("upload %s: %v", version, err)
+	}
+	return a
+}
+
+func (e *deltaTestEnv) deviceWithInventory(t *testing.T, inventory map[string]interface{}) uuid.UUID {
+	t.Helper()
+	d := &domain.Device{OrgID: testOrgID, IdentityHash: uuid.NewString(), Inventory: inventory}
+	if err := e.devices.Create(context.Background(), d); err != nil {
+		t.Fatalf("create device: %v", err)
+	}
+	return d.ID
+}
+
+func installedInventory(version, checksum string) map[string]interface{} {
+	entry := map[string]interface{}{"version": version}
+	if checksum != "" {
+		entry["checksum_sha256"] = checksum
+	}
+	return map[string]interface{}{
+		domain.InventoryInstalledKey: map[string]interface{}{"/usr/local/bin/myapp": entry},
+	}
+}
+
+// binaryVersions returns a pseudo-random file and a copy with a few edits,
+// like two builds of the same program.
+func binaryVersions(size int) ([]byte, []byte) {
+	r := rand.New(rand.NewSource(42))
+	old := make([]byte, size)
+	r.Read(old)
+
+	updated := append([]byte{}, old...)
+	for i := 0; i < 10; i++ {
+		updated[r.Intn(size)] ^= 0xff
+	}
+	updated = append(updated[:size/2], append([]byte("new code path"), updated[size/2:]...)...)
+	return old, updated
+}
+
+func TestDeltaGenerate_PatchRebuildsNewVersion(t *testing.T) {
+	env := newDeltaTestEnv(DeltaConfig{MaxSources: 3, MaxFileSize: 1 << 20})
+	oldData, newData := binaryVersions(64 * 1024)
+
+	v1 := env.upload(t, "1.0.0", oldData)
+	v2 := env.upload(t, "1.1.0", newData)
+
+	deltas, err := env.deltas.Generate(context.Background(), v2)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if len(deltas) != 1 {
+		t.Fatalf("expected 1 delta, got %d", len(deltas))
+	}
+	d := deltas[0]
+	if d.SourceArtifactID != v1.ID || d.SourceVersion != "1.0.0" || d.SourceChecksum != v1.ChecksumSHA256 {
+		t.Fatalf("unexpected source: %+v", d)
+	}
+	if d.FileSize >= v2.FileSize {
+		t.Fatalf("expected delta smaller than %d bytes, got %d", v2.FileSize, d.FileSize)
+	}
+
+	patch := env.store.files[d.StoragePath]
+	sum := sha256.Sum256(patch)
+	if hex.EncodeToString(sum[:]) != d.ChecksumSHA256 {
+		t.Fatal("delta checksum does not match the stored patch")
+	}
+	rebuilt, err := delta.Apply(oldData, patch)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if !bytes.Equal(rebuilt, newData) {
+		t.Fatal("patched file differs from the new version")
+	}
+}
+
+func TestDeltaGenerate_SkipsUnrelatedFiles(t *testing.T) {
+	env := newDeltaTestEnv(DeltaConfig{MaxSources: 3, MaxFileSize: 1 << 20})
+	r := rand.New(rand.NewSource(1))
+	a, b := make([]byte, 4096), make([]byte, 4096)
+	r.Read(a)
+	r.Read(b)
+
+	env.upload(t, "1.0.0", a)
+	v2 := env.upload(t, "2.0.0", b)
+
+	deltas, err := env.deltas.Generate(context.Background(), v2)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if len(deltas) != 0 {
+		t.Fatalf("expected no delta when it is not smaller than the file, got %d", len(deltas))
+	}
+}
+
+func TestDeltaGenerate_LimitsToRecentVersions(t *testing.T) {
+	env := newDeltaTestEnv(DeltaConfig{MaxSources: 2, MaxFileSize: 1 << 20})
+	base, _ := binaryVersions(16 * 1024)
+
+	var versions []*domain.Artifact
+	for i, v := range []string{"1.0.0", "1.1.0", "1.2.0", "1.3.0"} {
+		content := append([]byte{}, base...)
+		content[i*100] ^= 0xff
+		versions = append(versions, env.upload(t, v, content))
+	}
+
+	deltas, err := env.deltas.Generate(context.Background(), versions[3])
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if len(deltas) != 2 {
+		t.Fatalf("expected 2 deltas, got %d", len(deltas))
+	}
+	for _, d := range deltas {
+		if d.SourceVersion != "1.2.0" && d.SourceVersion != "1.1.0" {
+			t.Fatalf("unexpected delta from %s", d.SourceVersion)
+		}
+	}
+}
+
+func TestDeltaGenerate_SkipsLargeFiles(t *testing.T) {
+	env := newDeltaTestEnv(DeltaConfig{MaxSources: 3, MaxFileSize: 1024})
+	oldData, newData := binaryVersions(4096)
+
+	env.upload(t, "1.0.0", oldData)
+	v2 := env.upload(t, "1.1.0", newData)
+
+	deltas, err := env.deltas.Generate(context.Background(), v2)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if len(deltas) != 0 {
+		t.Fatalf("expected no delta above the size limit, got %d", len(deltas))
+	}
+}
+
+func TestDeltaForDevice(t *testing.T) {
+	env := newDeltaTestEnv(DeltaConfig{MaxSources: 3, MaxFileSize: 1 << 20})
+	ctx := context.Background()
+	oldData, newData := binaryVersions(32 * 1024)
+
+	v1 := env.upload(t, "1.0.0", oldData)
+	v2 := env.upload(t, "1.1.0", newData)
+	if _, err := env.deltas.Generate(ctx, v2); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		inventory map[string]interface{}
+		wantDelta bool
+	}{
+		{"installed version", installedInventory("1.0.0", ""), true},
+		{"matching checksum", installedInventory("1.0.0", v1.ChecksumSHA256), true},
+		{"modified file", installedInventory("1.0.0", v2.ChecksumSHA256), false},
+		{"unknown version", installedInventory("0.9.0", ""), false},
+		{"nothing reported", map[string]interface{}{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deviceID := env.deviceWithInventory(t, tt.inventory)
+			d, err := env.deltas.ForDevice(ctx, testOrgID, deviceID, v2)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if (d != nil) != tt.wantDelta {
+				t.Fatalf("expected delta=%v, got %+v", tt.wantDelta, d)
+			}
+		})
+	}
+}
+
+func TestArtifactDelete_RemovesDeltaFiles(t *testing.T) {
+	env := newDeltaTestEnv(DeltaConfig{MaxSources: 3, MaxFileSize: 1 << 20})
+	ctx := context.Background()
+	oldData, newData := binaryVersions(32 * 1024)
+
+	v1 := env.upload(t, "1.0.0", oldData)
+	v2 := env.upload(t, "1.1.0", newData)
+	deltas, err := env.deltas.Generate(ctx, v2)
+	if err != nil || len(deltas) != 1 {
+		t.Fatalf("generate: %v (%d deltas)", err, len(deltas))
+	}
+
+	if err := env.artifacts.Delete(ctx, testOrgID, v1.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, ok := env.store.files[deltas[0].StoragePath]; ok {
+		t.Fatal("expected delta file to be removed with its source artifact")
+	}
+}
@@ -2,13 +2,17 @@ package service
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
 
 	"github.com/google/uuid"
 
 	"github.com/CaioWing/Harbor/internal/domain"
+	"github.com/CaioWing/Harbor/internal/semver"
+	"github.com/CaioWing/Harbor/internal/storage/encrypted"
 )
 
 type DeploymentService struct {
@@ -33,12 +37,25 @@ func NewDeploymentService(
 }
 
 type CreateDeploymentInput struct {
+	OrgID             uuid.UUID
 	Name              string
 	ArtifactID        uuid.UUID
 	TargetDeviceIDs   []uuid.UUID
 	TargetDeviceTags  []string
 	TargetDeviceTypes []string
 	MaxParallel       int
+	// Variables are available to template artifacts as .Vars
+	Variables map[string]string
+	// Continuous deployments target devices by tags and device types only,
+	// and enrol the devices that match later on. ChannelID is set for those
+	// created by a channel promotion.
+	Continuous bool
+	ChannelID  *uuid.UUID
+	// RollbackOf is set for the deployments created by Rollback
+	RollbackOf *uuid.UUID
+	// ManifestID deployments start without devices. Devices are added to
+	// them as they report drift from the manifest.
+	ManifestID *uuid.UUID
 }
 
 func (s *DeploymentService) Create(ctx context.Context, input CreateDeploymentInput) (*domain.Deployment, error) {
@@ -47,22 +64,51 @@ func (s *DeploymentService) Create(ctx context.Context, input CreateDeploymentIn
 	}
 
 	// Verify artifact exists
-	artifact, err := s.artRepo.GetByID(ctx, input.ArtifactID)
+	artifact, err := s.artRepo.GetByID(ctx, input.OrgID, input.ArtifactID)
 	if err != nil {
 		return nil, fmt.Errorf("artifact: %w", err)
 	}
+	if artifact.QuarantinedAt != nil {
+		return nil, fmt.Errorf("%w: artifact is quarantined (%s)", domain.ErrInvalidInput, artifact.QuarantineReason)
+	}
+	if block := artifact.ValidationBlock(); block != "" {
+		return nil, fmt.Errorf("%w: artifact failed validation (%s)", domain.ErrInvalidInput, block)
+	}
 
-	// Resolve target devices
-	deviceIDs, err := s.resolveTargets(ctx, input, artifact)
+	// Resolve target devices. A continuous deployment may start with none.
+	var devices []*domain.Device
+	switch {
+	case input.ManifestID != nil:
+	case input.Continuous:
+		if len(input.TargetDeviceIDs) > 0 {
+			return nil, fmt.Errorf("%w: continuous deployments target devices by tags and device types", domain.ErrInvalidInput)
+		}
+		devices, err = s.resolveContinuousTargets(ctx, input, artifact)
+	default:
+		devices, err = s.resolveTargets(ctx, input, artifact)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("resolve targets: %w", err)
 	}
 
-	if len(deviceIDs) == 0 {
+	if len(devices) == 0 && !input.Continuous && input.ManifestID == nil {
 		return nil, fmt.Errorf("%w: no matching devices found", domain.ErrInvalidInput)
 	}
 
+	return s.create(ctx, input, artifact, devices)
+}
+
+// create stores a deployment of artifact with an entry for each of devices
+// and activates it. The entries of devices that do not meet the artifact's
+// device types and requirements are skipped with the reason in their log.
+func (s *DeploymentService) create(ctx context.Context, input CreateDeploymentInput, artifact *domain.Artifact, devices []*domain.Device) (*domain.Deployment, error) {
+	installed, err := s.installedVersions(ctx, input.OrgID, artifact, devices)
+	if err != nil {
+		return nil, fmt.Errorf("installed versions: %w", err)
+	}
+
 	deployment := &domain.Deployment{
+		OrgID:             input.OrgID,
 		Name:              input.Name,
 		ArtifactID:        input.ArtifactID,
 		Status:            domain.DeploymentStatusScheduled,
@@ -70,6 +116,11 @@ func (s *DeploymentService) Create(ctx context.Context, input CreateDeploymentIn
 		TargetDeviceTags:  input.TargetDeviceTags,
 		TargetDeviceTypes: input.TargetDeviceTypes,
 		MaxParallel:       input.MaxParallel,
+		Variables:         input.Variables,
+		Continuous:        input.Continuous,
+		ChannelID:         input.ChannelID,
+		RollbackOf:        input.RollbackOf,
+		ManifestID:        input.ManifestID,
 	}
 
 	if err := s.deployRepo.Create(ctx, deployment); err != nil {
@@ -77,35 +128,152 @@ func (s *DeploymentService) Create(ctx context.Context, input CreateDeploymentIn
 	}
 
 	// Create deployment_device entries for each target
-	for _, deviceID := range deviceIDs {
-		dd := &domain.DeploymentDevice{
-			DeploymentID: deployment.ID,
-			DeviceID:     deviceID,
-			Status:       domain.DDStatusPending,
+	skipped := 0
+	for _, device := range devices {
+		dd, err := newDeploymentDevice(deployment.ID, device.ID, artifact)
+		if err != nil {
+			return nil, err
 		}
 		if err := s.deployRepo.CreateDeploymentDevice(ctx, dd); err != nil {
-			if errors.Is(err, domain.ErrConflict) {
-				continue // skip duplicates
+			if errors.Is(err, domain.ErrConflict) || errors.Is(err, domain.ErrNotFound) {
+				continue // skip duplicates and devices of other organizations
 			}
-			s.log.Warn("failed to create deployment_device", "device", deviceID, "err", err)
+			s.log.Warn("failed to create deployment_device", "device", device.ID, "err", err)
+			continue
+		}
+		if reason := incompatibility(artifact, device, installed[device.ID]); reason != "" {
+			s.skipIncompatible(ctx, input.OrgID, dd, reason)
+			skipped++
 		}
 	}
 
 	// Activate deployment
-	if err := s.deployRepo.SetStarted(ctx, deployment.ID); err != nil {
+	if err := s.deployRepo.SetStarted(ctx, input.OrgID, deployment.ID); err != nil {
 		s.log.Warn("failed to activate deployment", "id", deployment.ID, "err", err)
 	}
 
-	s.log.Info("deployment created", "id", deployment.ID, "devices", len(deviceIDs))
+	s.log.Info("deployment created", "id", deployment.ID, "devices", len(devices), "incompatible", skipped)
 	return deployment, nil
 }
 
-func (s *DeploymentService) resolveTargets(ctx context.Context, input CreateDeploymentInput, artifact *domain.Artifact) ([]uuid.UUID, error) {
-	deviceIDSet := make(map[uuid.UUID]bool)
+// skipIncompatible marks the entry of a device that cannot receive the
+// artifact as skipped, with the reason in its log.
+func (s *DeploymentService) skipIncompatible(ctx context.Context, orgID uuid.UUID, dd *domain.DeploymentDevice, reason string) error {
+	err := s.deployRepo.UpdateDeploymentDeviceStatus(ctx, orgID, dd.ID, domain.DDStatusSkipped, "incompatible: "+reason)
+	if err != nil {
+		s.log.Warn("failed to skip incompatible device", "deployment", dd.DeploymentID, "device", dd.DeviceID, "err", err)
+		return err
+	}
+	s.log.Info("incompatible device skipped", "deployment", dd.DeploymentID, "device", dd.DeviceID, "reason", reason)
+	return nil
+}
+
+// newDeploymentDevice returns a pending entry of deviceID in a deployment of
+// artifact.
+func newDeploymentDevice(deploymentID, deviceID uuid.UUID, artifact *domain.Artifact) (*domain.DeploymentDevice, error) {
+	dd := &domain.DeploymentDevice{
+		DeploymentID: deploymentID,
+		DeviceID:     deviceID,
+		Status:       domain.DDStatusPending,
+	}
+	if artifact.EncryptForDevice {
+		dd.DeliverySeed = make([]byte, encrypted.SeedSize)
+		if _, err := rand.Read(dd.DeliverySeed); err != nil {
+			return nil, fmt.Errorf("generate delivery seed: %w", err)
+		}
+	}
+	return dd, nil
+}
+
+// matchesContinuous reports whether device belongs in a continuous
+// deployment of artifact with the given targets: it is accepted, has all of
+// tags and one of types, which default to the device types of the artifact.
+func matchesContinuous(tags, types []string, artifact *domain.Artifact, device *domain.Device) bool {
+	if device.Status != domain.DeviceStatusAccepted {
+		return false
+	}
+	if len(types) == 0 {
+		types = artifact.DeviceTypes
+	}
+	if !slices.Contains(types, device.DeviceType) {
+		return false
+	}
+	for _, tag := range tags {
+		if !slices.Contains(device.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *DeploymentService) resolveContinuousTargets(ctx context.Context, input CreateDeploymentInput, artifact *domain.Artifact) ([]*domain.Device, error) {
+	const perPage = 100
+	var matched []*domain.Device
+	for page := 1; ; page++ {
+		devices, total, err := s.deviceRepo.List(ctx, input.OrgID, domain.DeviceFilter{
+			Status:  statusPtr(domain.DeviceStatusAccepted),
+			Tags:    input.TargetDeviceTags,
+			Page:    page,
+			PerPage: perPage,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range devices {
+			if matchesContinuous(input.TargetDeviceTags, input.TargetDeviceTypes, artifact, d) {
+				matched = append(matched, d)
+			}
+		}
+		if len(devices) == 0 || page*perPage >= total {
+			return matched, nil
+		}
+	}
+}
+
+// enrollContinuous adds deviceID to the active continuous deployments it
+// matches but is not part of yet, such as after it was tagged or accepted.
+func (s *DeploymentService) enrollContinuous(ctx context.Context, orgID, deviceID uuid.UUID) {
+	deployments, err := s.deployRepo.ListContinuous(ctx, orgID, deviceID)
+	if err != nil {
+		s.log.Warn("failed to list continuous deployments", "device", deviceID, "err", err)
+		return
+	}
+	if len(deployments) == 0 {
+		return
+	}
+	device, err := s.deviceRepo.GetByID(ctx, orgID, deviceID)
+	if err != nil {
+		s.log.Warn("failed to get device", "device", deviceID, "err", err)
+		return
+	}
+	for _, dep := range deployments {
+		artifact, err := s.artRepo.GetByID(ctx, orgID, dep.ArtifactID)
+		if err != nil || !matchesContinuous(dep.TargetDeviceTags, dep.TargetDeviceTypes, artifact, device) {
+			continue
+		}
+		dd, err := newDeploymentDevice(dep.ID, deviceID, artifact)
+		if err == nil {
+			err = s.deployRepo.CreateDeploymentDevice(ctx, dd)
+		}
+		if err != nil {
+			if !errors.Is(err, domain.ErrConflict) {
+				s.log.Warn("failed to enrol device in continuous deployment", "deployment", dep.ID, "device", deviceID, "err", err)
+			}
+			continue
+		}
+		s.log.Info("device enrolled in continuous deployment", "deployment", dep.ID, "device", deviceID)
+	}
+}
+
+// resolveTargets returns the accepted devices among the explicit targets,
+// with those that have the target tags or device types. Explicit targets are
+// not filtered by device type; create skips the ones that are incompatible.
+func (s *DeploymentService) resolveTargets(ctx context.Context, input CreateDeploymentInput, artifact *domain.Artifact) ([]*domain.Device, error) {
+	targets := make(map[uuid.UUID]*domain.Device)
 
 	// Add explicitly targeted devices
 	for _, id := range input.TargetDeviceIDs {
-		device, err := s.deviceRepo.GetByID(ctx, id)
+		device, err := s.deviceRepo.GetByID(ctx, input.OrgID, id)
 		if err != nil {
 			if errors.Is(err, domain.ErrNotFound) {
 				continue
@@ -113,13 +281,13 @@ func (s *DeploymentService) resolveTargets(ctx context.Context, input CreateDepl
 			return nil, err
 		}
 		if device.Status == domain.DeviceStatusAccepted {
-			deviceIDSet[id] = true
+			targets[id] = device
 		}
 	}
 
 	// Resolve by tags
 	if len(input.TargetDeviceTags) > 0 {
-		devices, _, err := s.deviceRepo.List(ctx, domain.DeviceFilter{
+		devices, _, err := s.deviceRepo.List(ctx, input.OrgID, domain.DeviceFilter{
 			Status:  statusPtr(domain.DeviceStatusAccepted),
 			Tags:    input.TargetDeviceTags,
 			Page:    1,
@@ -129,7 +297,7 @@ func (s *DeploymentService) resolveTargets(ctx context.Context, input CreateDepl
 			return nil, err
 		}
 		for _, d := range devices {
-			deviceIDSet[d.ID] = true
+			targets[d.ID] = d
 		}
 	}
 
@@ -139,7 +307,7 @@ func (s *DeploymentService) resolveTargets(ctx context.Context, input CreateDepl
 		targetTypes = artifact.DeviceTypes
 	}
 	for _, dt := range targetTypes {
-		devices, _, err := s.deviceRepo.List(ctx, domain.DeviceFilter{
+		devices, _, err := s.deviceRepo.List(ctx, input.OrgID, domain.DeviceFilter{
 			Status:     statusPtr(domain.DeviceStatusAccepted),
 			DeviceType: &dt,
 			Page:       1,
@@ -149,27 +317,27 @@ func (s *DeploymentService) resolveTargets(ctx context.Context, input CreateDepl
 			return nil, err
 		}
 		for _, d := range devices {
-			deviceIDSet[d.ID] = true
+			targets[d.ID] = d
 		}
 	}
 
-	ids := make([]uuid.UUID, 0, len(deviceIDSet))
-	for id := range deviceIDSet {
-		ids = append(ids, id)
+	devices := make([]*domain.Device, 0, len(targets))
+	for _, d := range targets {
+		devices = append(devices, d)
 	}
-	return ids, nil
+	return devices, nil
 }
 
-func (s *DeploymentService) GetByID(ctx context.Context, id uuid.UUID) (*domain.Deployment, error) {
-	return s.deployRepo.GetByID(ctx, id)
+func (s *DeploymentService) GetByID(ctx context.Context, orgID, id uuid.UUID) (*domain.Deployment, error) {
+	return s.deployRepo.GetByID(ctx, orgID, id)
 }
 
-func (s *DeploymentService) List(ctx context.Context, filter domain.DeploymentFilter) ([]*domain.Deployment, int, error) {
-	return s.deployRepo.List(ctx, filter)
+func (s *DeploymentService) List(ctx context.Context, orgID uuid.UUID, filter domain.DeploymentFilter) ([]*domain.Deployment, int, error) {
+	return s.deployRepo.List(ctx, orgID, filter)
 }
 
-func (s *DeploymentService) Cancel(ctx context.Context, id uuid.UUID) error {
-	dep, err := s.deployRepo.GetByID(ctx, id)
+func (s *DeploymentService) Cancel(ctx context.Context, orgID, id uuid.UUID) error {
+	dep, err := s.deployRepo.GetByID(ctx, orgID, id)
 	if err != nil {
 		return err
 	}
@@ -178,29 +346,156 @@ func (s *DeploymentService) Cancel(ctx context.Context, id uuid.UUID) error {
 	}
 
 	// Skip remaining pending devices
-	devices, err := s.deployRepo.GetDeploymentDevices(ctx, id)
+	devices, err := s.deployRepo.GetDeploymentDevices(ctx, orgID, id)
 	if err != nil {
 		return err
 	}
 	for _, dd := range devices {
 		if dd.Status == domain.DDStatusPending {
-			s.deployRepo.UpdateDeploymentDeviceStatus(ctx, dd.ID, domain.DDStatusSkipped, "deployment cancelled")
+			s.deployRepo.UpdateDeploymentDeviceStatus(ctx, orgID, dd.ID, domain.DDStatusSkipped, "deployment cancelled")
 		}
 	}
 
-	return s.deployRepo.UpdateStatus(ctx, id, domain.DeploymentStatusCancelled)
+	return s.deployRepo.UpdateStatus(ctx, orgID, id, domain.DeploymentStatusCancelled)
 }
 
-func (s *DeploymentService) GetDeploymentDevices(ctx context.Context, deploymentID uuid.UUID) ([]*domain.DeploymentDevice, error) {
-	return s.deployRepo.GetDeploymentDevices(ctx, deploymentID)
+// Rollback returns the devices that installed the artifact of deployment id
+// to the version with the same name and target path each of them installed
+// before. It creates one deployment per such version, targeting exactly
+// those devices, and reports the devices without an earlier version as
+// skipped. A deployment still running is cancelled first.
+func (s *DeploymentService) Rollback(ctx context.Context, orgID, id uuid.UUID) (*domain.Rollback, error) {
+	dep, err := s.deployRepo.GetByID(ctx, orgID, id)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := s.deployRepo.GetDeploymentDevices(ctx, orgID, id)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := s.deployRepo.ListPreviousArtifacts(ctx, orgID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Group the devices by the version they return to, in a stable order
+	result := &domain.Rollback{Deployments: []*domain.Deployment{}, SkippedDeviceIDs: []uuid.UUID{}}
+	targets := make(map[uuid.UUID][]*domain.Device)
+	var versions []*domain.Artifact
+	for _, dd := range entries {
+		if dd.Status != domain.DDStatusSuccess {
+			continue
+		}
+		artifactID, ok := previous[dd.DeviceID]
+		if !ok {
+			result.SkippedDeviceIDs = append(result.SkippedDeviceIDs, dd.DeviceID)
+			continue
+		}
+		device, err := s.deviceRepo.GetByID(ctx, orgID, dd.DeviceID)
+		if err != nil {
+			return nil, fmt.Errorf("device %s: %w", dd.DeviceID, err)
+		}
+		if _, ok := targets[artifactID]; !ok {
+			artifact, err := s.artRepo.GetByID(ctx, orgID, artifactID)
+			if err != nil {
+				return nil, fmt.Errorf("previous artifact: %w", err)
+			}
+			if artifact.QuarantinedAt != nil {
+				return nil, fmt.Errorf("%w: previous version %s is quarantined (%s)", domain.ErrInvalidInput, artifact.Version, artifact.QuarantineReason)
+			}
+			if block := artifact.ValidationBlock(); block != "" {
+				return nil, fmt.Errorf("%w: previous version %s failed validation (%s)", domain.ErrInvalidInput, artifact.Version, block)
+			}
+			versions = append(versions, artifact)
+		}
+		targets[artifactID] = append(targets[artifactID], device)
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("%w: no device of the deployment has an earlier version to roll back to", domain.ErrInvalidInput)
+	}
+
+	if dep.Status == domain.DeploymentStatusScheduled || dep.Status == domain.DeploymentStatusActive {
+		if err := s.Cancel(ctx, orgID, id); err != nil {
+			return nil, fmt.Errorf("cancel deployment: %w", err)
+		}
+	}
+
+	for _, artifact := range versions {
+		ids := make([]uuid.UUID, len(targets[artifact.ID]))
+		for i, d := range targets[artifact.ID] {
+			ids[i] = d.ID
+		}
+		deployment, err := s.create(ctx, CreateDeploymentInput{
+			OrgID:           orgID,
+			Name:            fmt.Sprintf("rollback: %s to %s", dep.Name, artifact.Version),
+			ArtifactID:      artifact.ID,
+			TargetDeviceIDs: ids,
+			MaxParallel:     dep.MaxParallel,
+			Variables:       dep.Variables,
+			RollbackOf:      &dep.ID,
+		}, artifact, targets[artifact.ID])
+		if err != nil {
+			return nil, err
+		}
+		result.Deployments = append(result.Deployments, deployment)
+	}
+
+	s.log.Info("deployment rolled back", "id", id, "deployments", len(result.Deployments), "skipped", len(result.SkippedDeviceIDs))
+	return result, nil
 }
 
-func (s *DeploymentService) GetNextForDevice(ctx context.Context, deviceID uuid.UUID) (*domain.DeploymentDevice, *domain.Deployment, *domain.Artifact, error) {
-	return s.deployRepo.GetPendingDeploymentForDevice(ctx, deviceID)
+func (s *DeploymentService) GetDeploymentDevices(ctx context.Context, orgID, deploymentID uuid.UUID) ([]*domain.DeploymentDevice, error) {
+	return s.deployRepo.GetDeploymentDevices(ctx, orgID, deploymentID)
 }
 
-func (s *DeploymentService) UpdateDeviceStatus(ctx context.Context, ddID uuid.UUID, status domain.DeploymentDeviceStatus, log string) error {
-	if err := s.deployRepo.UpdateDeploymentDeviceStatus(ctx, ddID, status, log); err != nil {
+// GetNextForDevice returns the oldest pending entry of the device, after
+// enrolling it in the continuous deployments it now matches. Entries whose
+// artifact the device no longer meets the requirements of, as its inventory
+// changed since they were created, are skipped.
+func (s *DeploymentService) GetNextForDevice(ctx context.Context, orgID, deviceID uuid.UUID) (*domain.DeploymentDevice, *domain.Deployment, *domain.Artifact, error) {
+	s.enrollContinuous(ctx, orgID, deviceID)
+	device, err := s.deviceRepo.GetByID(ctx, orgID, deviceID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for {
+		dd, dep, artifact, err := s.deployRepo.GetPendingDeploymentForDevice(ctx, orgID, deviceID)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		installed, err := s.installedVersions(ctx, orgID, artifact, []*domain.Device{device})
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		reason := incompatibility(artifact, device, installed[deviceID])
+		if reason == "" {
+			return dd, dep, artifact, nil
+		}
+		if err := s.skipIncompatible(ctx, orgID, dd, reason); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+}
+
+// GetForDownload returns the deployment device entry ddID, its deployment and
+// its artifact if it belongs to the device and has not finished. Unlike
+// GetNextForDevice it keeps working after the device reports downloading, so
+// that interrupted downloads can be resumed.
+func (s *DeploymentService) GetForDownload(ctx context.Context, orgID, deviceID, ddID uuid.UUID) (*domain.DeploymentDevice, *domain.Deployment, *domain.Artifact, error) {
+	return s.deployRepo.GetActiveDeploymentDevice(ctx, orgID, deviceID, ddID)
+}
+
+// UpdateDeviceStatus records the status the device reports for its entry
+// ddID. It fails with ErrNotFound if the entry is another device's.
+func (s *DeploymentService) UpdateDeviceStatus(ctx context.Context, orgID, deviceID, ddID uuid.UUID, status domain.DeploymentDeviceStatus, log string) error {
+	dd, err := s.deployRepo.GetDeploymentDevice(ctx, orgID, ddID)
+	if err != nil {
+		return err
+	}
+	if dd.DeviceID != deviceID {
+		return domain.ErrNotFound
+	}
+	if err := s.deployRepo.UpdateDeploymentDeviceStatus(ctx, orgID, ddID, status, log); err != nil {
 		return err
 	}
 
@@ -212,11 +507,150 @@ func (s *DeploymentService) UpdateDeviceStatus(ctx context.Context, ddID uuid.UU
 	return nil
 }
 
+const (
+	// maxOutputChunk bounds one chunk of script output sent by an agent
+	maxOutputChunk = 64 << 10
+	// maxScriptOutput bounds the output stored per deployment device entry
+	maxScriptOutput = 4 << 20
+	maxOutputPage   = 1000
+)
+
+// AppendOutput stores a chunk of the output of the script the device runs
+// for the entry ddID, while the entry is in progress. Output past
+// maxScriptOutput is dropped; the result reports whether this chunk was cut.
+func (s *DeploymentService) AppendOutput(ctx context.Context, orgID, deviceID, ddID uuid.UUID, stream domain.OutputStream, data string) (bool, error) {
+	if stream != domain.OutputStdout && stream != domain.OutputStderr {
+		return false, fmt.Errorf("%w: stream must be stdout or stderr", domain.ErrInvalidInput)
+	}
+	if len(data) > maxOutputChunk {
+		return false, fmt.Errorf("%w: output chunks are limited to %d bytes", domain.ErrInvalidInput, maxOutputChunk)
+	}
+	_, _, art, err := s.deployRepo.GetActiveDeploymentDevice(ctx, orgID, deviceID, ddID)
+	if err != nil {
+		return false, err
+	}
+	if !art.IsScript() {
+		return false, fmt.Errorf("%w: only script artifacts report output", domain.ErrInvalidInput)
+	}
+	return s.deployRepo.AppendOutput(ctx, orgID, ddID, &domain.OutputChunk{Stream: stream, Data: data}, maxScriptOutput)
+}
+
+// FinishScript records the final status of the entry ddID of the device
+// with the exit code of its script, while the entry is in progress.
+func (s *DeploymentService) FinishScript(ctx context.Context, orgID, deviceID, ddID uuid.UUID, status domain.DeploymentDeviceStatus, log string, code int) error {
+	if status != domain.DDStatusSuccess && status != domain.DDStatusFailure {
+		return fmt.Errorf("%w: exit_code is reported with the final status", domain.ErrInvalidInput)
+	}
+	_, _, art, err := s.deployRepo.GetActiveDeploymentDevice(ctx, orgID, deviceID, ddID)
+	if err != nil {
+		return err
+	}
+	if !art.IsScript() {
+		return fmt.Errorf("%w: only script artifacts report an exit code", domain.ErrInvalidInput)
+	}
+	if err := s.deployRepo.FinishScript(ctx, orgID, ddID, status, log, code); err != nil {
+		return err
+	}
+	s.checkDeploymentCompletion(ctx, ddID)
+	return nil
+}
+
+// GetOutput returns the entry ddID of the deployment and up to limit chunks
+// of its output with a sequence number after after, so that callers can
+// follow the output as it arrives.
+func (s *DeploymentService) GetOutput(ctx context.Context, orgID, deploymentID, ddID uuid.UUID, after int64, limit int) (*domain.DeploymentDevice, []*domain.OutputChunk, error) {
+	dd, err := s.deployRepo.GetDeploymentDevice(ctx, orgID, ddID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if dd.DeploymentID != deploymentID {
+		return nil, nil, domain.ErrNotFound
+	}
+	if limit < 1 || limit > maxOutputPage {
+		limit = maxOutputPage
+	}
+	chunks, err := s.deployRepo.ListOutput(ctx, orgID, ddID, after, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dd, chunks, nil
+}
+
 func (s *DeploymentService) checkDeploymentCompletion(ctx context.Context, ddID uuid.UUID) {
 	// This is a simplification — in production, get deploymentID from ddID
 	// For now, this is best-effort
 }
 
-func (s *DeploymentService) GetStats(ctx context.Context) (*domain.DeploymentStats, error) {
-	return s.deployRepo.GetStats(ctx)
+func (s *DeploymentService) GetStats(ctx context.Context, orgID uuid.UUID) (*domain.DeploymentStats, error) {
+	return s.deployRepo.GetStats(ctx, orgID)
+}
+
+// Lineage returns the versions of the artifact name, highest first, with
+// their deployments and how their devices fared. Versions stored before
+// versions had to be semantic come last, newest first.
+func (s *DeploymentService) Lineage(ctx context.Context, orgID uuid.UUID, name string) (*domain.ArtifactLineage, error) {
+	artifacts, err := s.artRepo.ListByName(ctx, orgID, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(artifacts) == 0 {
+		return nil, domain.ErrNotFound
+	}
+
+	type ranked struct {
+		artifact *domain.Artifact
+		version  semver.Version
+		ok       bool
+	}
+	versions := make([]ranked, len(artifacts))
+	ids := make([]uuid.UUID, len(artifacts))
+	for i, a := range artifacts {
+		v, err := semver.Parse(a.Version)
+		versions[i] = ranked{artifact: a, version: v, ok: err == nil}
+		ids[i] = a.ID
+	}
+	// ListByName is newest first, which a stable sort keeps among equals
+	slices.SortStableFunc(versions, func(a, b ranked) int {
+		switch {
+		case a.ok && b.ok:
+			return b.version.Compare(a.version)
+		case a.ok:
+			return -1
+		case b.ok:
+			return 1
+		}
+		return 0
+	})
+
+	summaries, err := s.deployRepo.ListSummariesByArtifacts(ctx, orgID, ids)
+	if err != nil {
+		return nil, err
+	}
+	byArtifact := make(map[uuid.UUID][]*domain.DeploymentSummary)
+	for _, d := range summaries {
+		byArtifact[d.ArtifactID] = append(byArtifact[d.ArtifactID], d)
+	}
+
+	lineage := &domain.ArtifactLineage{Name: name}
+	for _, v := range versions {
+		h := &domain.ArtifactVersionHistory{
+			Artifact:    v.artifact,
+			Deployments: byArtifact[v.artifact.ID],
+			Devices:     make(map[domain.DeploymentDeviceStatus]int),
+		}
+		if h.Deployments == nil {
+			h.Deployments = []*domain.DeploymentSummary{}
+		}
+		for _, d := range h.Deployments {
+			for status, n := range d.Devices {
+				h.Devices[status] += n
+			}
+		}
+		if finished := h.Devices[domain.DDStatusSuccess] + h.Devices[domain.DDStatusFailure]; finished > 0 {
+			rate := float64(h.Devices[domain.DDStatusSuccess]) / float64(finished)
+			h.SuccessRate = &rate
+		}
+		lineage.Versions = append(lineage.Versions, h)
+	}
+	return lineage, nil
 }
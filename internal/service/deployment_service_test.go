@@ -5,11 +5,13 @@ import (
 	"errors"
 	"io"
 	"log/slog"
+	"strings"
 	"testing"
 
 	"github.com/google/uuid"
 
 	"github.com/CaioWing/Harbor/internal/domain"
+	"github.com/CaioWing/Harbor/internal/storage/encrypted"
 )
 
 type deploymentTestEnv struct {
@@ -22,7 +24,7 @@ type deploymentTestEnv struct {
 func newTestDeploymentService() *deploymentTestEnv {
 	deviceRepo := newMockDeviceRepo()
 	artRepo := newMockArtifactRepo()
-	deployRepo := newMockDeploymentRepo(artRepo)
+	deployRepo := newMockDeploymentRepo(artRepo, deviceRepo)
 	log := slog.New(slog.NewTextHandler(io.Discard, nil))
 	svc := NewDeploymentService(deployRepo, deviceRepo, artRepo, log)
 	return &deploymentTestEnv{
@@ -35,6 +37,7 @@ func newTestDeploymentService() *deploymentTestEnv {
 
 func (e *deploymentTestEnv) createAcceptedDevice(ctx context.Context, deviceType string, tags []string) *domain.Device {
 	d := &domain.Device{
+		OrgID:        testOrgID,
 		IdentityHash: uuid.New().String(),
 		IdentityData: domain.IdentityData{"device_type": deviceType},
 		Status:       domain.DeviceStatusAccepted,
@@ -48,6 +51,7 @@ func (e *deploymentTestEnv) createAcceptedDevice(ctx context.Context, deviceType
 
 func (e *deploymentTestEnv) createArtifact(ctx context.Context, name, version string, deviceTypes []string) *domain.Artifact {
 	a := &domain.Artifact{
+		OrgID:       testOrgID,
 		Name:        name,
 		Version:     version,
 		DeviceTypes: deviceTypes,
@@ -68,6 +72,7 @@ func TestDeploymentCreate_Success(t *testing.T) {
 	artifact := env.createArtifact(ctx, "myapp", "1.0.0", []string{"raspberry-pi-4"})
 
 	input := CreateDeploymentInput{
+		OrgID:           testOrgID,
 		Name:            "deploy-1",
 		ArtifactID:      artifact.ID,
 		TargetDeviceIDs: []uuid.UUID{device.ID},
@@ -92,6 +97,7 @@ func TestDeploymentCreate_MissingName(t *testing.T) {
 	artifact := env.createArtifact(ctx, "myapp", "1.0.0", []string{"raspberry-pi-4"})
 
 	input := CreateDeploymentInput{
+		OrgID:      testOrgID,
 		ArtifactID: artifact.ID,
 	}
 
@@ -106,6 +112,7 @@ func TestDeploymentCreate_InvalidArtifact(t *testing.T) {
 	ctx := context.Background()
 
 	input := CreateDeploymentInput{
+		OrgID:      testOrgID,
 		Name:       "deploy-1",
 		ArtifactID: uuid.New(),
 	}
@@ -126,6 +133,7 @@ func TestDeploymentCreate_NoMatchingDevices(t *testing.T) {
 	env.createAcceptedDevice(ctx, "beaglebone", []string{})
 
 	input := CreateDeploymentInput{
+		OrgID:             testOrgID,
 		Name:              "deploy-1",
 		ArtifactID:        artifact.ID,
 		TargetDeviceTypes: []string{"raspberry-pi-4"},
@@ -147,6 +155,7 @@ func TestDeploymentCreate_ResolvesByTags(t *testing.T) {
 	artifact := env.createArtifact(ctx, "myapp", "1.0.0", []string{"type-c"}) // no device matches by type
 
 	input := CreateDeploymentInput{
+		OrgID:            testOrgID,
 		Name:             "deploy-1",
 		ArtifactID:       artifact.ID,
 		TargetDeviceTags: []string{"production"},
@@ -158,7 +167,7 @@ func TestDeploymentCreate_ResolvesByTags(t *testing.T) {
 	}
 
 	// Should have 1 deployment device (only production tagged; type-c resolves to 0 extra)
-	dds, _ := env.deployRepo.GetDeploymentDevices(ctx, dep.ID)
+	dds, _ := env.deployRepo.GetDeploymentDevices(ctx, testOrgID, dep.ID)
 	if len(dds) != 1 {
 		t.Fatalf("expected 1 deployment device, got %d", len(dds))
 	}
@@ -174,6 +183,7 @@ func TestDeploymentCreate_ResolvesByDeviceType(t *testing.T) {
 	artifact := env.createArtifact(ctx, "myapp", "1.0.0", []string{"raspberry-pi-4"})
 
 	input := CreateDeploymentInput{
+		OrgID:             testOrgID,
 		Name:              "deploy-1",
 		ArtifactID:        artifact.ID,
 		TargetDeviceTypes: []string{"raspberry-pi-4"},
@@ -184,7 +194,7 @@ func TestDeploymentCreate_ResolvesByDeviceType(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	dds, _ := env.deployRepo.GetDeploymentDevices(ctx, dep.ID)
+	dds, _ := env.deployRepo.GetDeploymentDevices(ctx, testOrgID, dep.ID)
 	if len(dds) != 2 {
 		t.Fatalf("expected 2 deployment devices, got %d", len(dds))
 	}
@@ -198,24 +208,25 @@ func TestDeploymentCancel(t *testing.T) {
 	artifact := env.createArtifact(ctx, "myapp", "1.0.0", []string{"raspberry-pi-4"})
 
 	dep, _ := env.svc.Create(ctx, CreateDeploymentInput{
+		OrgID:           testOrgID,
 		Name:            "deploy-1",
 		ArtifactID:      artifact.ID,
 		TargetDeviceIDs: []uuid.UUID{device.ID},
 	})
 
-	err := env.svc.Cancel(ctx, dep.ID)
+	err := env.svc.Cancel(ctx, testOrgID, dep.ID)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
 	// Check deployment status
-	updated, _ := env.deployRepo.GetByID(ctx, dep.ID)
+	updated, _ := env.deployRepo.GetByID(ctx, testOrgID, dep.ID)
 	if updated.Status != domain.DeploymentStatusCancelled {
 		t.Fatalf("expected cancelled, got %s", updated.Status)
 	}
 
 	// Check that pending devices are skipped
-	dds, _ := env.deployRepo.GetDeploymentDevices(ctx, dep.ID)
+	dds, _ := env.deployRepo.GetDeploymentDevices(ctx, testOrgID, dep.ID)
 	for _, dd := range dds {
 		if dd.Status != domain.DDStatusSkipped {
 			t.Fatalf("expected skipped, got %s", dd.Status)
@@ -231,13 +242,14 @@ func TestDeploymentCancel_AlreadyCancelled(t *testing.T) {
 	artifact := env.createArtifact(ctx, "myapp", "1.0.0", []string{"raspberry-pi-4"})
 
 	dep, _ := env.svc.Create(ctx, CreateDeploymentInput{
+		OrgID:           testOrgID,
 		Name:            "deploy-1",
 		ArtifactID:      artifact.ID,
 		TargetDeviceIDs: []uuid.UUID{device.ID},
 	})
 
-	env.svc.Cancel(ctx, dep.ID)
-	err := env.svc.Cancel(ctx, dep.ID)
+	env.svc.Cancel(ctx, testOrgID, dep.ID)
+	err := env.svc.Cancel(ctx, testOrgID, dep.ID)
 	if !errors.Is(err, domain.ErrInvalidInput) {
 		t.Fatalf("expected ErrInvalidInput, got %v", err)
 	}
@@ -251,12 +263,13 @@ func TestDeploymentGetByID(t *testing.T) {
 	artifact := env.createArtifact(ctx, "myapp", "1.0.0", []string{"raspberry-pi-4"})
 
 	dep, _ := env.svc.Create(ctx, CreateDeploymentInput{
+		OrgID:           testOrgID,
 		Name:            "deploy-1",
 		ArtifactID:      artifact.ID,
 		TargetDeviceIDs: []uuid.UUID{device.ID},
 	})
 
-	found, err := env.svc.GetByID(ctx, dep.ID)
+	found, err := env.svc.GetByID(ctx, testOrgID, dep.ID)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -269,7 +282,7 @@ func TestDeploymentGetByID_NotFound(t *testing.T) {
 	env := newTestDeploymentService()
 	ctx := context.Background()
 
-	_, err := env.svc.GetByID(ctx, uuid.New())
+	_, err := env.svc.GetByID(ctx, testOrgID, uuid.New())
 	if !errors.Is(err, domain.ErrNotFound) {
 		t.Fatalf("expected ErrNotFound, got %v", err)
 	}
@@ -283,12 +296,13 @@ func TestDeploymentGetNextForDevice(t *testing.T) {
 	artifact := env.createArtifact(ctx, "myapp", "1.0.0", []string{"raspberry-pi-4"})
 
 	env.svc.Create(ctx, CreateDeploymentInput{
+		OrgID:           testOrgID,
 		Name:            "deploy-1",
 		ArtifactID:      artifact.ID,
 		TargetDeviceIDs: []uuid.UUID{device.ID},
 	})
 
-	dd, dep, art, err := env.svc.GetNextForDevice(ctx, device.ID)
+	dd, dep, art, err := env.svc.GetNextForDevice(ctx, testOrgID, device.ID)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -307,12 +321,54 @@ func TestDeploymentGetNextForDevice_NoPending(t *testing.T) {
 	env := newTestDeploymentService()
 	ctx := context.Background()
 
-	_, _, _, err := env.svc.GetNextForDevice(ctx, uuid.New())
+	_, _, _, err := env.svc.GetNextForDevice(ctx, testOrgID, uuid.New())
 	if !errors.Is(err, domain.ErrNotFound) {
 		t.Fatalf("expected ErrNotFound, got %v", err)
 	}
 }
 
+func TestDeploymentGetForDownload_ResumesWhileInProgress(t *testing.T) {
+	env := newTestDeploymentService()
+	ctx := context.Background()
+
+	device := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{})
+	other := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{})
+	artifact := env.createArtifact(ctx, "myapp", "1.0.0", []string{"raspberry-pi-4"})
+
+	env.svc.Create(ctx, CreateDeploymentInput{
+		OrgID:           testOrgID,
+		Name:            "deploy-1",
+		ArtifactID:      artifact.ID,
+		TargetDeviceIDs: []uuid.UUID{device.ID},
+	})
+	dd, _, _, err := env.svc.GetNextForDevice(ctx, testOrgID, device.ID)
+	if err != nil {
+		t.Fatalf("get next: %v", err)
+	}
+
+	// The device reports downloading before fetching the file, after which
+	// the entry is no longer "next" but must stay downloadable
+	if err := env.svc.UpdateDeviceStatus(ctx, testOrgID, dd.DeviceID, dd.ID, domain.DDStatusDownloading, ""); err != nil {
+		t.Fatalf("update status: %v", err)
+	}
+	_, _, art, err := env.svc.GetForDownload(ctx, testOrgID, device.ID, dd.ID)
+	if err != nil {
+		t.Fatalf("expected download to stay available, got %v", err)
+	}
+	if art.ID != artifact.ID {
+		t.Fatal("artifact mismatch")
+	}
+
+	if _, _, _, err := env.svc.GetForDownload(ctx, testOrgID, other.ID, dd.ID); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for another device, got %v", err)
+	}
+
+	env.svc.UpdateDeviceStatus(ctx, testOrgID, dd.DeviceID, dd.ID, domain.DDStatusSuccess, "")
+	if _, _, _, err := env.svc.GetForDownload(ctx, testOrgID, device.ID, dd.ID); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound after success, got %v", err)
+	}
+}
+
 func TestDeploymentUpdateDeviceStatus(t *testing.T) {
 	env := newTestDeploymentService()
 	ctx := context.Background()
@@ -321,22 +377,33 @@ func TestDeploymentUpdateDeviceStatus(t *testing.T) {
 	artifact := env.createArtifact(ctx, "myapp", "1.0.0", []string{"raspberry-pi-4"})
 
 	dep, _ := env.svc.Create(ctx, CreateDeploymentInput{
+		OrgID:           testOrgID,
 		Name:            "deploy-1",
 		ArtifactID:      artifact.ID,
 		TargetDeviceIDs: []uuid.UUID{device.ID},
 	})
 
-	dds, _ := env.deployRepo.GetDeploymentDevices(ctx, dep.ID)
+	dds, _ := env.deployRepo.GetDeploymentDevices(ctx, testOrgID, dep.ID)
 	if len(dds) == 0 {
 		t.Fatal("expected deployment devices")
 	}
 
-	err := env.svc.UpdateDeviceStatus(ctx, dds[0].ID, domain.DDStatusSuccess, "done")
+	// Another device of the organization cannot report for this entry
+	other := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{})
+	err := env.svc.UpdateDeviceStatus(ctx, testOrgID, other.ID, dds[0].ID, domain.DDStatusFailure, "spoofed")
+	if !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for another device, got %v", err)
+	}
+	if dd, _ := env.deployRepo.GetDeploymentDevice(ctx, testOrgID, dds[0].ID); dd.Status != domain.DDStatusPending {
+		t.Fatalf("expected the entry to stay pending, got %s", dd.Status)
+	}
+
+	err = env.svc.UpdateDeviceStatus(ctx, testOrgID, dds[0].DeviceID, dds[0].ID, domain.DDStatusSuccess, "done")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	updated, _ := env.deployRepo.GetDeploymentDevices(ctx, dep.ID)
+	updated, _ := env.deployRepo.GetDeploymentDevices(ctx, testOrgID, dep.ID)
 	if updated[0].Status != domain.DDStatusSuccess {
 		t.Fatalf("expected success, got %s", updated[0].Status)
 	}
@@ -351,6 +418,7 @@ func TestDeploymentGetStats(t *testing.T) {
 
 	// Create and leave active
 	env.svc.Create(ctx, CreateDeploymentInput{
+		OrgID:           testOrgID,
 		Name:            "deploy-1",
 		ArtifactID:      artifact.ID,
 		TargetDeviceIDs: []uuid.UUID{device.ID},
@@ -358,13 +426,14 @@ func TestDeploymentGetStats(t *testing.T) {
 
 	// Create and cancel
 	dep2, _ := env.svc.Create(ctx, CreateDeploymentInput{
+		OrgID:           testOrgID,
 		Name:            "deploy-2",
 		ArtifactID:      artifact.ID,
 		TargetDeviceIDs: []uuid.UUID{device.ID},
 	})
-	env.svc.Cancel(ctx, dep2.ID)
+	env.svc.Cancel(ctx, testOrgID, dep2.ID)
 
-	stats, err := env.svc.GetStats(ctx)
+	stats, err := env.svc.GetStats(ctx, testOrgID)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -385,6 +454,7 @@ func TestDeploymentCreate_PendingDeviceExcluded(t *testing.T) {
 
 	// Create pending device (not accepted)
 	d := &domain.Device{
+		OrgID:        testOrgID,
 		IdentityHash: uuid.New().String(),
 		IdentityData: domain.IdentityData{"device_type": "raspberry-pi-4"},
 		Status:       domain.DeviceStatusPending,
@@ -397,6 +467,7 @@ func TestDeploymentCreate_PendingDeviceExcluded(t *testing.T) {
 	artifact := env.createArtifact(ctx, "myapp", "1.0.0", []string{"raspberry-pi-4"})
 
 	input := CreateDeploymentInput{
+		OrgID:           testOrgID,
 		Name:            "deploy-1",
 		ArtifactID:      artifact.ID,
 		TargetDeviceIDs: []uuid.UUID{d.ID},
@@ -407,3 +478,571 @@ func TestDeploymentCreate_PendingDeviceExcluded(t *testing.T) {
 		t.Fatalf("expected ErrInvalidInput (no matching devices), got %v", err)
 	}
 }
+
+func TestDeploymentCreate_IgnoresDevicesOfOtherOrganizations(t *testing.T) {
+	env := newTestDeploymentService()
+	ctx := context.Background()
+
+	local := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{})
+	foreign := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{})
+	foreign.OrgID = uuid.New()
+	artifact := env.createArtifact(ctx, "myapp", "1.0.0", []string{"raspberry-pi-4"})
+
+	dep, err := env.svc.Create(ctx, CreateDeploymentInput{
+		OrgID:           testOrgID,
+		Name:            "deploy-1",
+		ArtifactID:      artifact.ID,
+		TargetDeviceIDs: []uuid.UUID{local.ID, foreign.ID},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dds, _ := env.deployRepo.GetDeploymentDevices(ctx, testOrgID, dep.ID)
+	if len(dds) != 1 || dds[0].DeviceID != local.ID {
+		t.Fatalf("expected only the local device to be targeted, got %d entries", len(dds))
+	}
+}
+
+func TestDeploymentGetByID_OtherOrganization(t *testing.T) {
+	env := newTestDeploymentService()
+	ctx := context.Background()
+
+	env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{})
+	artifact := env.createArtifact(ctx, "myapp", "1.0.0", []string{"raspberry-pi-4"})
+	dep, _ := env.svc.Create(ctx, CreateDeploymentInput{
+		OrgID:      testOrgID,
+		Name:       "deploy-1",
+		ArtifactID: artifact.ID,
+	})
+
+	_, err := env.svc.GetByID(ctx, uuid.New(), dep.ID)
+	if !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := env.svc.Cancel(ctx, uuid.New(), dep.ID); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound on cancel, got %v", err)
+	}
+}
+
+func TestDeploymentCreate_ArtifactOfOtherOrganization(t *testing.T) {
+	env := newTestDeploymentService()
+	ctx := context.Background()
+
+	env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{})
+	artifact := env.createArtifact(ctx, "myapp", "1.0.0", []string{"raspberry-pi-4"})
+
+	_, err := env.svc.Create(ctx, CreateDeploymentInput{
+		OrgID:      uuid.New(),
+		Name:       "deploy-1",
+		ArtifactID: artifact.ID,
+	})
+	if !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestDeploymentCreate_QuarantinedArtifact(t *testing.T) {
+	env := newTestDeploymentService()
+	ctx := context.Background()
+
+	device := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{})
+	artifact := env.createArtifact(ctx, "myapp", "1.0.0", []string{"raspberry-pi-4"})
+	env.artRepo.SetQuarantine(ctx, testOrgID, artifact.ChecksumSHA256, string(domain.ScrubChecksumMismatch))
+
+	_, err := env.svc.Create(ctx, CreateDeploymentInput{
+		OrgID:           testOrgID,
+		Name:            "deploy-1",
+		ArtifactID:      artifact.ID,
+		TargetDeviceIDs: []uuid.UUID{device.ID},
+	})
+	if !errors.Is(err, domain.ErrInvalidInput) {
+		t.Fatalf("expected ErrInvalidInput, got %v", err)
+	}
+}
+
+func TestDeploymentCreate_ArtifactFailedValidation(t *testing.T) {
+	env := newTestDeploymentService()
+	ctx := context.Background()
+
+	device := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{})
+	artifact := env.createArtifact(ctx, "myapp", "1.0.0", []string{"raspberry-pi-4"})
+	artifact.Validation = []domain.ValidationResult{
+		{Check: domain.ValidationCheckExternal, Validator: "advisory", Status: domain.ValidationFailed},
+		{Check: domain.ValidationCheckMaxSize, Status: domain.ValidationFailed, Blocking: true, Detail: "too large"},
+	}
+
+	_, err := env.svc.Create(ctx, CreateDeploymentInput{
+		OrgID:           testOrgID,
+		Name:            "deploy-1",
+		ArtifactID:      artifact.ID,
+		TargetDeviceIDs: []uuid.UUID{device.ID},
+	})
+	if !errors.Is(err, domain.ErrInvalidInput) || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("expected ErrInvalidInput naming the failed check, got %v", err)
+	}
+
+	// Non-blocking failures do not prevent deployment
+	artifact.Validation = artifact.Validation[:1]
+	if _, err := env.svc.Create(ctx, CreateDeploymentInput{
+		OrgID:           testOrgID,
+		Name:            "deploy-2",
+		ArtifactID:      artifact.ID,
+		TargetDeviceIDs: []uuid.UUID{device.ID},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestDeploymentGetNextForDevice_SkipsQuarantined(t *testing.T) {
+	env := newTestDeploymentService()
+	ctx := context.Background()
+
+	device := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{})
+	artifact := env.createArtifact(ctx, "myapp", "1.0.0", []string{"raspberry-pi-4"})
+	if _, err := env.svc.Create(ctx, CreateDeploymentInput{
+		OrgID:           testOrgID,
+		Name:            "deploy-1",
+		ArtifactID:      artifact.ID,
+		TargetDeviceIDs: []uuid.UUID{device.ID},
+	}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	dd, _, _, err := env.svc.GetNextForDevice(ctx, testOrgID, device.ID)
+	if err != nil {
+		t.Fatalf("get next: %v", err)
+	}
+
+	env.artRepo.SetQuarantine(ctx, testOrgID, artifact.ChecksumSHA256, string(domain.ScrubMissingFile))
+
+	if _, _, _, err := env.svc.GetNextForDevice(ctx, testOrgID, device.ID); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("expected quarantined deployment to be withheld, got %v", err)
+	}
+	if _, _, _, err := env.svc.GetForDownload(ctx, testOrgID, device.ID, dd.ID); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("expected download of quarantined artifact to be refused, got %v", err)
+	}
+}
+
+func TestDeploymentCreate_DeliverySeedForDeviceEncryptedArtifact(t *testing.T) {
+	env := newTestDeploymentService()
+	ctx := context.Background()
+
+	first := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{})
+	second := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{})
+	plain := env.createArtifact(ctx, "myapp", "1.0.0", []string{"raspberry-pi-4"})
+	secret := env.createArtifact(ctx, "wifi-config", "1.0.0", []string{"raspberry-pi-4"})
+	secret.EncryptForDevice = true
+
+	seeds := map[string]bool{}
+	for _, art := range []*domain.Artifact{plain, secret} {
+		dep, err := env.svc.Create(ctx, CreateDeploymentInput{
+			OrgID:           testOrgID,
+			Name:            "deploy-" + art.Name,
+			ArtifactID:      art.ID,
+			TargetDeviceIDs: []uuid.UUID{first.ID, second.ID},
+		})
+		if err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		dds, _ := env.svc.GetDeploymentDevices(ctx, testOrgID, dep.ID)
+		for _, dd := range dds {
+			if !art.EncryptForDevice {
+				if dd.DeliverySeed != nil {
+					t.Error("expected no delivery seed for a plain artifact")
+				}
+				continue
+			}
+			if len(dd.DeliverySeed) != encrypted.SeedSize {
+				t.Fatalf("expected a %d byte delivery seed, got %d", encrypted.SeedSize, len(dd.DeliverySeed))
+			}
+			seeds[string(dd.DeliverySeed)] = true
+		}
+	}
+	if len(seeds) != 2 {
+		t.Error("expected every device to get its own delivery seed")
+	}
+}
+
+func TestDeploymentScriptOutput(t *testing.T) {
+	env := newTestDeploymentService()
+	ctx := context.Background()
+
+	device := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{})
+	other := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{})
+	script := env.createArtifact(ctx, "cleanup", "1.0.0", []string{"raspberry-pi-4"})
+	script.Kind = domain.ArtifactKindScript
+
+	dep, err := env.svc.Create(ctx, CreateDeploymentInput{
+		OrgID: testOrgID, Name: "cleanup", ArtifactID: script.ID, TargetDeviceIDs: []uuid.UUID{device.ID},
+	})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	dd, _, _, err := env.svc.GetNextForDevice(ctx, testOrgID, device.ID)
+	if err != nil {
+		t.Fatalf("get next: %v", err)
+	}
+	env.svc.UpdateDeviceStatus(ctx, testOrgID, dd.DeviceID, dd.ID, domain.DDStatusInstalling, "")
+
+	if _, err := env.svc.AppendOutput(ctx, testOrgID, device.ID, dd.ID, domain.OutputStdout, "removed 3 files\n"); err != nil {
+		t.Fatalf("append stdout: %v", err)
+	}
+	if _, err := env.svc.AppendOutput(ctx, testOrgID, device.ID, dd.ID, domain.OutputStderr, "warning\n"); err != nil {
+		t.Fatalf("append stderr: %v", err)
+	}
+	if _, err := env.svc.AppendOutput(ctx, testOrgID, device.ID, dd.ID, "stdin", "x"); !errors.Is(err, domain.ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput for an unknown stream, got %v", err)
+	}
+	if _, err := env.svc.AppendOutput(ctx, testOrgID, other.ID, dd.ID, domain.OutputStdout, "x"); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for another device, got %v", err)
+	}
+
+	if err := env.svc.FinishScript(ctx, testOrgID, device.ID, dd.ID, domain.DDStatusInstalling, "", 0); !errors.Is(err, domain.ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput for an exit code before the final status, got %v", err)
+	}
+	if err := env.svc.FinishScript(ctx, testOrgID, other.ID, dd.ID, domain.DDStatusSuccess, "", 0); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for another device's exit code, got %v", err)
+	}
+	if err := env.svc.FinishScript(ctx, testOrgID, device.ID, dd.ID, domain.DDStatusFailure, "exit status 2", 2); err != nil {
+		t.Fatalf("finish script: %v", err)
+	}
+	// A finished entry keeps its exit code
+	if err := env.svc.FinishScript(ctx, testOrgID, device.ID, dd.ID, domain.DDStatusSuccess, "", 0); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a finished entry, got %v", err)
+	}
+
+	got, chunks, err := env.svc.GetOutput(ctx, testOrgID, dep.ID, dd.ID, 0, 0)
+	if err != nil {
+		t.Fatalf("get output: %v", err)
+	}
+	if got.ExitCode == nil || *got.ExitCode != 2 || got.Status != domain.DDStatusFailure || got.OutputSize != int64(len("removed 3 files\nwarning\n")) {
+		t.Errorf("unexpected entry %+v", got)
+	}
+	if len(chunks) != 2 || chunks[0].Stream != domain.OutputStdout || chunks[1].Data != "warning\n" {
+		t.Fatalf("unexpected chunks %+v", chunks)
+	}
+	if _, rest, _ := env.svc.GetOutput(ctx, testOrgID, dep.ID, dd.ID, chunks[0].Seq, 0); len(rest) != 1 || rest[0].Seq != chunks[1].Seq {
+		t.Errorf("expected only the chunks after seq %d, got %+v", chunks[0].Seq, rest)
+	}
+	if _, _, err := env.svc.GetOutput(ctx, testOrgID, uuid.New(), dd.ID, 0, 0); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for another deployment, got %v", err)
+	}
+
+	// The entry finished: later output is refused
+	if _, err := env.svc.AppendOutput(ctx, testOrgID, device.ID, dd.ID, domain.OutputStdout, "late"); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("expected ErrNotFound after the entry finished, got %v", err)
+	}
+}
+
+func TestDeploymentScriptOutput_Limits(t *testing.T) {
+	env := newTestDeploymentService()
+	ctx := context.Background()
+
+	device := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{})
+	file := env.createArtifact(ctx, "myapp", "1.0.0", []string{"raspberry-pi-4"})
+	script := env.createArtifact(ctx, "noisy", "1.0.0", []string{"raspberry-pi-4"})
+	script.Kind = domain.ArtifactKindScript
+
+	for _, art := range []*domain.Artifact{file, script} {
+		env.svc.Create(ctx, CreateDeploymentInput{
+			OrgID: testOrgID, Name: art.Name, ArtifactID: art.ID, TargetDeviceIDs: []uuid.UUID{device.ID},
+		})
+	}
+	var fileDD, scriptDD uuid.UUID
+	for id, dd := range env.deployRepo.ddEntries {
+		if dep := env.deployRepo.deployments[dd.DeploymentID]; dep.ArtifactID == file.ID {
+			fileDD = id
+		} else {
+			scriptDD = id
+		}
+	}
+
+	if _, err := env.svc.AppendOutput(ctx, testOrgID, device.ID, fileDD, domain.OutputStdout, "x"); !errors.Is(err, domain.ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput for a file artifact, got %v", err)
+	}
+	if _, err := env.svc.AppendOutput(ctx, testOrgID, device.ID, scriptDD, domain.OutputStdout, string(make([]byte, maxOutputChunk+1))); !errors.Is(err, domain.ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput for an oversized chunk, got %v", err)
+	}
+
+	chunk := string(make([]byte, maxOutputChunk))
+	var truncated bool
+	for i := 0; i < maxScriptOutput/maxOutputChunk+1; i++ {
+		var err error
+		if truncated, err = env.svc.AppendOutput(ctx, testOrgID, device.ID, scriptDD, domain.OutputStdout, chunk); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+	dd := env.deployRepo.ddEntries[scriptDD]
+	if !truncated || !dd.OutputTruncated || dd.OutputSize != maxScriptOutput {
+		t.Errorf("expected output cut at %d bytes, got %d (truncated %v)", maxScriptOutput, dd.OutputSize, dd.OutputTruncated)
+	}
+}
+
+func TestDeploymentLineage(t *testing.T) {
+	env := newTestDeploymentService()
+	ctx := context.Background()
+
+	device1 := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{})
+	device2 := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{})
+	v1 := env.createArtifact(ctx, "myapp", "1.2.0", []string{"raspberry-pi-4"})
+	v2 := env.createArtifact(ctx, "myapp", "1.10.0", []string{"raspberry-pi-4"})
+	env.createArtifact(ctx, "myapp", "1.10.0-rc.1", []string{"raspberry-pi-4"})
+
+	dep, err := env.svc.Create(ctx, CreateDeploymentInput{
+		OrgID: testOrgID, Name: "rollout", ArtifactID: v2.ID, TargetDeviceIDs: []uuid.UUID{device1.ID, device2.ID},
+	})
+	if err != nil {
+		t.Fatalf("create deployment: %v", err)
+	}
+	dds, _ := env.deployRepo.GetDeploymentDevices(ctx, testOrgID, dep.ID)
+	env.svc.UpdateDeviceStatus(ctx, testOrgID, dds[0].DeviceID, dds[0].ID, domain.DDStatusSuccess, "")
+	env.svc.UpdateDeviceStatus(ctx, testOrgID, dds[1].DeviceID, dds[1].ID, domain.DDStatusFailure, "")
+
+	lineage, err := env.svc.Lineage(ctx, testOrgID, "myapp")
+	if err != nil {
+		t.Fatalf("lineage: %v", err)
+	}
+	var versions []string
+	for _, v := range lineage.Versions {
+		versions = append(versions, v.Artifact.Version)
+	}
+	if strings.Join(versions, " ") != "1.10.0 1.10.0-rc.1 1.2.0" {
+		t.Fatalf("unexpected version order %v", versions)
+	}
+
+	top := lineage.Versions[0]
+	if len(top.Deployments) != 1 || top.Deployments[0].ID != dep.ID {
+		t.Fatalf("expected the deployment of 1.10.0, got %+v", top.Deployments)
+	}
+	if top.SuccessRate == nil || *top.SuccessRate != 0.5 {
+		t.Errorf("expected success rate 0.5, got %v", top.SuccessRate)
+	}
+	if last := lineage.Versions[2]; last.Artifact.ID != v1.ID || len(last.Deployments) != 0 || last.SuccessRate != nil {
+		t.Errorf("expected 1.2.0 without deployments, got %+v", last)
+	}
+
+	if _, err := env.svc.Lineage(ctx, testOrgID, "missing"); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestDeploymentRollback(t *testing.T) {
+	env := newTestDeploymentService()
+	ctx := context.Background()
+
+	first := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{})
+	second := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{})
+	fresh := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{})
+	env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{})
+	v1 := env.createArtifact(ctx, "myapp", "1.0.0", []string{"raspberry-pi-4"})
+	v11 := env.createArtifact(ctx, "myapp", "1.1.0", []string{"raspberry-pi-4"})
+	bad := env.createArtifact(ctx, "myapp", "2.0.0", []string{"raspberry-pi-4"})
+
+	// install deploys artifact and reports success on the given devices only
+	install := func(artifact *domain.Artifact, devices ...*domain.Device) *domain.Deployment {
+		dep, err := env.svc.Create(ctx, CreateDeploymentInput{
+			OrgID: testOrgID, Name: "install " + artifact.Version, ArtifactID: artifact.ID, TargetDeviceIDs: []uuid.UUID{devices[0].ID},
+		})
+		if err != nil {
+			t.Fatalf("create deployment: %v", err)
+		}
+		dds, _ := env.deployRepo.GetDeploymentDevices(ctx, testOrgID, dep.ID)
+		for _, dd := range dds {
+			for _, d := range devices {
+				if dd.DeviceID == d.ID {
+					env.svc.UpdateDeviceStatus(ctx, testOrgID, dd.DeviceID, dd.ID, domain.DDStatusSuccess, "")
+				}
+			}
+		}
+		return dep
+	}
+
+	first1 := install(v1, first)
+	if _, err := env.svc.Rollback(ctx, testOrgID, first1.ID); !errors.Is(err, domain.ErrInvalidInput) {
+		t.Errorf("expected ErrInvalidInput without earlier versions, got %v", err)
+	}
+	install(v11, second)
+	dep := install(bad, first, second, fresh)
+
+	result, err := env.svc.Rollback(ctx, testOrgID, dep.ID)
+	if err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if len(result.SkippedDeviceIDs) != 1 || result.SkippedDeviceIDs[0] != fresh.ID {
+		t.Errorf("expected the device without an earlier version skipped, got %v", result.SkippedDeviceIDs)
+	}
+	if len(result.Deployments) != 2 {
+		t.Fatalf("expected a deployment per previous version, got %d", len(result.Deployments))
+	}
+	want := map[uuid.UUID]uuid.UUID{v1.ID: first.ID, v11.ID: second.ID}
+	for _, rb := range result.Deployments {
+		if rb.RollbackOf == nil || *rb.RollbackOf != dep.ID || rb.Status != domain.DeploymentStatusActive {
+			t.Errorf("unexpected rollback deployment %+v", rb)
+		}
+		dds, _ := env.deployRepo.GetDeploymentDevices(ctx, testOrgID, rb.ID)
+		if len(dds) != 1 || dds[0].DeviceID != want[rb.ArtifactID] {
+			t.Errorf("expected only the device that had %s, got %+v", rb.ArtifactID, dds)
+		}
+	}
+
+	if dep, _ = env.deployRepo.GetByID(ctx, testOrgID, dep.ID); dep.Status != domain.DeploymentStatusCancelled {
+		t.Errorf("expected the rolled back deployment cancelled, got %s", dep.Status)
+	}
+}
+
+func TestDeploymentCreate_SkipsIncompatibleDevices(t *testing.T) {
+	env := newTestDeploymentService()
+	ctx := context.Background()
+
+	inventory := func(osVersion string) map[string]interface{} {
+		return map[string]interface{}{"os_version": osVersion, "arch": "arm64", "serial": "SN-1", "free_disk_bytes": float64(1 << 20)}
+	}
+	newDevice := func(deviceType string, inv map[string]interface{}) *domain.Device {
+		d := env.createAcceptedDevice(ctx, deviceType, []string{})
+		env.deviceRepo.UpdateInventory(ctx, testOrgID, d.ID, inv)
+		return d
+	}
+	compatible := newDevice("raspberry-pi-4", inventory("5.15.0"))
+	otherType := newDevice("x86-gateway", inventory("5.15.0"))
+	oldOS := newDevice("raspberry-pi-4", inventory("5.4.0"))
+	noRuntime := newDevice("raspberry-pi-4", inventory("5.15.0"))
+	noSerial := newDevice("raspberry-pi-4", inventory("5.15.0"))
+	delete(noSerial.Inventory, "serial")
+	lowDisk := newDevice("raspberry-pi-4", inventory("5.15.0"))
+	lowDisk.Inventory["free_disk_bytes"] = float64(10)
+
+	// The dependency is installed on every device but noRuntime
+	runtime := env.createArtifact(ctx, "runtime", "2.1.0", []string{"raspberry-pi-4", "x86-gateway"})
+	dep, err := env.svc.Create(ctx, CreateDeploymentInput{
+		OrgID: testOrgID, Name: "runtime", ArtifactID: runtime.ID,
+		TargetDeviceIDs: []uuid.UUID{compatible.ID, otherType.ID, oldOS.ID, noSerial.ID, lowDisk.ID},
+	})
+	if err != nil {
+		t.Fatalf("create runtime deployment: %v", err)
+	}
+	entries, _ := env.svc.GetDeploymentDevices(ctx, testOrgID, dep.ID)
+	for _, dd := range entries {
+		if dd.DeviceID != noRuntime.ID {
+			env.svc.UpdateDeviceStatus(ctx, testOrgID, dd.DeviceID, dd.ID, domain.DDStatusSuccess, "")
+		}
+	}
+
+	artifact := env.createArtifact(ctx, "myapp", "1.0.0", []string{"raspberry-pi-4"})
+	artifact.Requirements = &domain.Requirements{
+		MinOSVersion:  "5.10.0",
+		Arch:          []string{"arm64", "armv7"},
+		InventoryKeys: []string{"serial"},
+		Depends:       []domain.Dependency{{Name: "runtime", MinVersion: "2.0.0"}},
+	}
+	// Explicit targets of another device type are checked too
+	deployment, err := env.svc.Create(ctx, CreateDeploymentInput{
+		OrgID: testOrgID, Name: "myapp", ArtifactID: artifact.ID,
+		TargetDeviceIDs: []uuid.UUID{otherType.ID},
+	})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	entries, _ = env.svc.GetDeploymentDevices(ctx, testOrgID, deployment.ID)
+	if len(entries) != 6 {
+		t.Fatalf("expected 6 entries, got %d", len(entries))
+	}
+	want := map[uuid.UUID]string{
+		compatible.ID: "",
+		otherType.ID:  "device type x86-gateway",
+		oldOS.ID:      "os_version 5.4.0 is lower than 5.10.0",
+		noRuntime.ID:  "depends on runtime",
+		noSerial.ID:   "does not report serial",
+		lowDisk.ID:    "needs 100 bytes",
+	}
+	for _, dd := range entries {
+		reason := want[dd.DeviceID]
+		switch {
+		case reason == "" && dd.Status != domain.DDStatusPending:
+			t.Errorf("expected compatible device to be pending, got %s (%s)", dd.Status, dd.Log)
+		case reason != "" && (dd.Status != domain.DDStatusSkipped || !strings.Contains(dd.Log, reason)):
+			t.Errorf("expected device to be skipped with %q, got %s (%s)", reason, dd.Status, dd.Log)
+		}
+	}
+}
+
+func TestDeploymentCreate_DependsOnReportedInventory(t *testing.T) {
+	env := newTestDeploymentService()
+	ctx := context.Background()
+
+	downgraded := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{})
+	installedByHand := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{})
+	unreported := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{})
+	runtime := env.createArtifact(ctx, "runtime", "2.1.0", []string{"raspberry-pi-4"})
+	dep, err := env.svc.Create(ctx, CreateDeploymentInput{
+		OrgID: testOrgID, Name: "runtime", ArtifactID: runtime.ID,
+		TargetDeviceIDs: []uuid.UUID{downgraded.ID, unreported.ID},
+	})
+	if err != nil {
+		t.Fatalf("create runtime deployment: %v", err)
+	}
+	entries, _ := env.svc.GetDeploymentDevices(ctx, testOrgID, dep.ID)
+	for _, dd := range entries {
+		env.svc.UpdateDeviceStatus(ctx, testOrgID, dd.DeviceID, dd.ID, domain.DDStatusSuccess, "")
+	}
+
+	// The history says 2.1.0 but the device reports an older runtime; the
+	// other device got a runtime outside of any deployment
+	report := func(device *domain.Device, version string) {
+		installed := map[string]interface{}{runtime.TargetPath: map[string]interface{}{"version": version}}
+		env.deviceRepo.UpdateInventory(ctx, testOrgID, device.ID, map[string]interface{}{domain.InventoryInstalledKey: installed})
+	}
+	report(downgraded, "1.5.0")
+	report(installedByHand, "2.2.0")
+
+	artifact := env.createArtifact(ctx, "myapp", "1.0.0", []string{"raspberry-pi-4"})
+	artifact.Requirements = &domain.Requirements{Depends: []domain.Dependency{{Name: "runtime", MinVersion: "2.0.0"}}}
+	deployment, err := env.svc.Create(ctx, CreateDeploymentInput{
+		OrgID: testOrgID, Name: "myapp", ArtifactID: artifact.ID,
+		TargetDeviceIDs: []uuid.UUID{downgraded.ID, installedByHand.ID, unreported.ID},
+	})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	want := map[uuid.UUID]string{
+		downgraded.ID:      "depends on runtime 2.0.0 or higher, 1.5.0 is installed",
+		installedByHand.ID: "",
+		unreported.ID:      "",
+	}
+	entries, _ = env.svc.GetDeploymentDevices(ctx, testOrgID, deployment.ID)
+	for _, dd := range entries {
+		reason := want[dd.DeviceID]
+		switch {
+		case reason == "" && dd.Status != domain.DDStatusPending:
+			t.Errorf("expected device to be pending, got %s (%s)", dd.Status, dd.Log)
+		case reason != "" && (dd.Status != domain.DDStatusSkipped || !strings.Contains(dd.Log, reason)):
+			t.Errorf("expected device to be skipped with %q, got %s (%s)", reason, dd.Status, dd.Log)
+		}
+	}
+}
+
+func TestDeploymentGetNextForDevice_SkipsNoLongerCompatible(t *testing.T) {
+	env := newTestDeploymentService()
+	ctx := context.Background()
+
+	device := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{})
+	artifact := env.createArtifact(ctx, "myapp", "1.0.0", []string{"raspberry-pi-4"})
+	deployment, err := env.svc.Create(ctx, CreateDeploymentInput{
+		OrgID: testOrgID, Name: "deploy-1", ArtifactID: artifact.ID,
+		TargetDeviceIDs: []uuid.UUID{device.ID},
+	})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	// The device ran out of disk after the deployment was created
+	env.deviceRepo.UpdateInventory(ctx, testOrgID, device.ID, map[string]interface{}{"free_disk_bytes": float64(50)})
+	if _, _, _, err := env.svc.GetNextForDevice(ctx, testOrgID, device.ID); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	entries, _ := env.svc.GetDeploymentDevices(ctx, testOrgID, deployment.ID)
+	if entries[0].Status != domain.DDStatusSkipped || !strings.Contains(entries[0].Log, "incompatible") {
+		t.Errorf("expected entry to be skipped as incompatible, got %s (%s)", entries[0].Status, entries[0].Log)
+	}
+}
@@ -3,37 +3,49 @@ package service
 import (
 	"context"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
 	"sort"
+	"strings"
 
 	"github.com/google/uuid"
 
 	"github.com/CaioWing/Harbor/internal/auth"
 	"github.com/CaioWing/Harbor/internal/domain"
+	"github.com/CaioWing/Harbor/internal/storage/encrypted"
 )
 
 type DeviceService struct {
-	repo domain.DeviceRepository
-	log  *slog.Logger
+	repo    domain.DeviceRepository
+	orgRepo domain.OrganizationRepository
+	log     *slog.Logger
 }
 
-func NewDeviceService(repo domain.DeviceRepository, log *slog.Logger) *DeviceService {
-	return &DeviceService{repo: repo, log: log}
+func NewDeviceService(repo domain.DeviceRepository, orgRepo domain.OrganizationRepository, log *slog.Logger) *DeviceService {
+	return &DeviceService{repo: repo, orgRepo: orgRepo, log: log}
 }
 
+// Authenticate registers or authenticates a device. A tenant token in the
+// identity data places the device in that token's organization; devices
+// without one join the default organization.
 func (s *DeviceService) Authenticate(ctx context.Context, identityData domain.IdentityData) (string, error) {
 	deviceType, ok := identityData["device_type"]
 	if !ok || deviceType == "" {
 		return "", fmt.Errorf("%w: device_type is required", domain.ErrInvalidInput)
 	}
 
+	orgID, identityData, err := s.resolveTenant(ctx, identityData)
+	if err != nil {
+		return "", err
+	}
+
 	hash := computeIdentityHash(identityData)
 
-	device, err := s.repo.GetByIdentityHash(ctx, hash)
+	device, err := s.repo.GetByIdentityHash(ctx, orgID, hash)
 	if err != nil {
 		if !errors.Is(err, domain.ErrNotFound) {
 			return "", fmt.Errorf("lookup device: %w", err)
@@ -41,6 +53,7 @@ func (s *DeviceService) Authenticate(ctx context.Context, identityData domain.Id
 
 		// New device — create as pending
 		device = &domain.Device{
+			OrgID:        orgID,
 			IdentityHash: hash,
 			IdentityData: identityData,
 			Status:       domain.DeviceStatusPending,
@@ -51,7 +64,7 @@ func (s *DeviceService) Authenticate(ctx context.Context, identityData domain.Id
 		if err := s.repo.Create(ctx, device); err != nil {
 			if errors.Is(err, domain.ErrConflict) {
 				// Race condition — another request created it
-				device, err = s.repo.GetByIdentityHash(ctx, hash)
+				device, err = s.repo.GetByIdentityHash(ctx, orgID, hash)
 				if err != nil {
 					return "", fmt.Errorf("re-lookup device: %w", err)
 				}
@@ -59,7 +72,7 @@ func (s *DeviceService) Authenticate(ctx context.Context, identityData domain.Id
 				return "", fmt.Errorf("create device: %w", err)
 			}
 		}
-		s.log.Info("new device registered", "id", device.ID, "type", deviceType)
+		s.log.Info("new device registered", "id", device.ID, "type", deviceType, "organization", orgID)
 	}
 
 	switch device.Status {
@@ -73,10 +86,10 @@ func (s *DeviceService) Authenticate(ctx context.Context, identityData domain.Id
 		if err != nil {
 			return "", fmt.Errorf("generate token: %w", err)
 		}
-		if err := s.repo.UpdateAuthToken(ctx, device.ID, tokenHash); err != nil {
+		if err := s.repo.UpdateAuthToken(ctx, orgID, device.ID, tokenHash); err != nil {
 			return "", fmt.Errorf("save token: %w", err)
 		}
-		s.repo.UpdateLastCheckIn(ctx, device.ID)
+		s.repo.UpdateLastCheckIn(ctx, orgID, device.ID)
 		s.log.Info("device authenticated", "id", device.ID)
 		return token, nil
 	default:
@@ -84,52 +97,114 @@ func (s *DeviceService) Authenticate(ctx context.Context, identityData domain.Id
 	}
 }
 
-func (s *DeviceService) ValidateToken(ctx context.Context, token string) (*domain.Device, error) {
-	tokenHash := auth.HashToken(token)
-	return s.findByTokenHash(ctx, tokenHash)
+// resolveTenant returns the organization for the tenant token in data and a
+// copy of data without the token.
+func (s *DeviceService) resolveTenant(ctx context.Context, data domain.IdentityData) (uuid.UUID, domain.IdentityData, error) {
+	token, ok := data[domain.TenantTokenKey]
+	if !ok {
+		return domain.DefaultOrganizationID, data, nil
+	}
+
+	identity := make(domain.IdentityData, len(data)-1)
+	for k, v := range data {
+		if k != domain.TenantTokenKey {
+			identity[k] = v
+		}
+	}
+
+	org, err := s.orgRepo.GetByTenantTokenHash(ctx, auth.HashToken(token))
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return uuid.Nil, nil, fmt.Errorf("%w: invalid tenant token", domain.ErrUnauthorized)
+		}
+		return uuid.Nil, nil, fmt.Errorf("lookup tenant: %w", err)
+	}
+	return org.ID, identity, nil
 }
 
-func (s *DeviceService) findByTokenHash(ctx context.Context, tokenHash string) (*domain.Device, error) {
-	// This searches across all devices, which is acceptable for MVP scale.
-	// For production, add GetByTokenHash to the repository interface.
-	devices, _, err := s.repo.List(ctx, domain.DeviceFilter{Page: 1, PerPage: 100})
+func (s *DeviceService) ValidateToken(ctx context.Context, token string) (*domain.Device, error) {
+	device, err := s.repo.GetByAuthTokenHash(ctx, auth.HashToken(token))
 	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, domain.ErrUnauthorized
+		}
 		return nil, err
 	}
-	for _, d := range devices {
-		if d.AuthTokenHash == tokenHash && d.Status == domain.DeviceStatusAccepted {
-			return d, nil
-		}
+	if device.Status != domain.DeviceStatusAccepted {
+		return nil, domain.ErrUnauthorized
 	}
-	return nil, domain.ErrUnauthorized
+	return device, nil
 }
 
-func (s *DeviceService) GetByID(ctx context.Context, id uuid.UUID) (*domain.Device, error) {
-	return s.repo.GetByID(ctx, id)
+func (s *DeviceService) GetByID(ctx context.Context, orgID, id uuid.UUID) (*domain.Device, error) {
+	return s.repo.GetByID(ctx, orgID, id)
 }
 
-func (s *DeviceService) List(ctx context.Context, filter domain.DeviceFilter) ([]*domain.Device, int, error) {
-	return s.repo.List(ctx, filter)
+func (s *DeviceService) List(ctx context.Context, orgID uuid.UUID, filter domain.DeviceFilter) ([]*domain.Device, int, error) {
+	return s.repo.List(ctx, orgID, filter)
 }
 
-func (s *DeviceService) UpdateStatus(ctx context.Context, id uuid.UUID, status domain.DeviceStatus) error {
-	return s.repo.UpdateStatus(ctx, id, status)
+func (s *DeviceService) UpdateStatus(ctx context.Context, orgID, id uuid.UUID, status domain.DeviceStatus) error {
+	return s.repo.UpdateStatus(ctx, orgID, id, status)
 }
 
-func (s *DeviceService) UpdateInventory(ctx context.Context, id uuid.UUID, inventory map[string]interface{}) error {
-	return s.repo.UpdateInventory(ctx, id, inventory)
+// UpdateInventory replaces the inventory of a device. The installed section,
+// if present, must map each target path to the version and optionally the
+// SHA-256 checksum of the file there.
+func (s *DeviceService) UpdateInventory(ctx context.Context, orgID, id uuid.UUID, inventory map[string]interface{}) error {
+	if err := validateInstalled(inventory); err != nil {
+		return err
+	}
+	return s.repo.UpdateInventory(ctx, orgID, id, inventory)
+}
+
+func validateInstalled(inventory map[string]interface{}) error {
+	section, ok := inventory[domain.InventoryInstalledKey]
+	if !ok {
+		return nil
+	}
+	installed, ok := section.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%w: %s must map target paths to files", domain.ErrInvalidInput, domain.InventoryInstalledKey)
+	}
+	for path, v := range installed {
+		entry, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%w: %s[%q] must be an object", domain.ErrInvalidInput, domain.InventoryInstalledKey, path)
+		}
+		if version, _ := entry["version"].(string); version == "" {
+			return fmt.Errorf("%w: %s[%q] has no version", domain.ErrInvalidInput, domain.InventoryInstalledKey, path)
+		}
+		if checksum, ok := entry["checksum_sha256"]; ok {
+			sum, _ := checksum.(string)
+			if b, err := hex.DecodeString(sum); err != nil || len(b) != sha256.Size {
+				return fmt.Errorf("%w: %s[%q] has an invalid checksum_sha256", domain.ErrInvalidInput, domain.InventoryInstalledKey, path)
+			}
+		}
+	}
+	return nil
 }
 
-func (s *DeviceService) UpdateTags(ctx context.Context, id uuid.UUID, tags []string) error {
-	return s.repo.UpdateTags(ctx, id, tags)
+func (s *DeviceService) UpdateTags(ctx context.Context, orgID, id uuid.UUID, tags []string) error {
+	return s.repo.UpdateTags(ctx, orgID, id, tags)
+}
+
+// SetEncryptionKey registers the X25519 public key (base64) that artifacts
+// encrypted for each device are sealed for.
+func (s *DeviceService) SetEncryptionKey(ctx context.Context, orgID, id uuid.UUID, key string) error {
+	pub, err := encrypted.ParseDeviceKey(strings.TrimSpace(key))
+	if err != nil {
+		return fmt.Errorf("%w: encryption key: %v", domain.ErrInvalidInput, err)
+	}
+	return s.repo.UpdateEncryptionKey(ctx, orgID, id, base64.StdEncoding.EncodeToString(pub.Bytes()))
 }
 
-func (s *DeviceService) Decommission(ctx context.Context, id uuid.UUID) error {
-	return s.repo.Delete(ctx, id)
+func (s *DeviceService) Decommission(ctx context.Context, orgID, id uuid.UUID) error {
+	return s.repo.Delete(ctx, orgID, id)
 }
 
-func (s *DeviceService) CountByStatus(ctx context.Context) (map[domain.DeviceStatus]int, error) {
-	return s.repo.CountByStatus(ctx)
+func (s *DeviceService) CountByStatus(ctx context.Context, orgID uuid.UUID) (map[domain.DeviceStatus]int, error) {
+	return s.repo.CountByStatus(ctx, orgID)
 }
 
 func computeIdentityHash(data domain.IdentityData) string {
@@ -1,10 +1,13 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"errors"
 	"io"
 	"log/slog"
+	"strings"
 	"testing"
 
 	"github.com/google/uuid"
@@ -14,10 +17,15 @@ import (
 )
 
 func newTestDeviceService() (*DeviceService, *mockDeviceRepo) {
+	svc, repo, _ := newTestDeviceServiceWithOrgs()
+	return svc, repo
+}
+
+func newTestDeviceServiceWithOrgs() (*DeviceService, *mockDeviceRepo, *OrganizationService) {
 	repo := newMockDeviceRepo()
+	orgRepo := newMockOrganizationRepo(newMockUserRepo())
 	log := slog.New(slog.NewTextHandler(io.Discard, nil))
-	svc := NewDeviceService(repo, log)
-	return svc, repo
+	return NewDeviceService(repo, orgRepo, log), repo, NewOrganizationService(orgRepo, log)
 }
 
 func TestAuthenticate_NewDevice_CreatesPending(t *testing.T) {
@@ -80,7 +88,7 @@ func TestAuthenticate_AcceptedDevice_ReturnsToken(t *testing.T) {
 	for id := range repo.devices {
 		deviceID = id
 	}
-	repo.UpdateStatus(ctx, deviceID, domain.DeviceStatusAccepted)
+	repo.UpdateStatus(ctx, testOrgID, deviceID, domain.DeviceStatusAccepted)
 
 	// Second call should return token
 	token, err := svc.Authenticate(ctx, identity)
@@ -107,7 +115,7 @@ func TestAuthenticate_RejectedDevice(t *testing.T) {
 	for id := range repo.devices {
 		deviceID = id
 	}
-	repo.UpdateStatus(ctx, deviceID, domain.DeviceStatusRejected)
+	repo.UpdateStatus(ctx, testOrgID, deviceID, domain.DeviceStatusRejected)
 
 	_, err := svc.Authenticate(ctx, identity)
 	if !errors.Is(err, domain.ErrDeviceRejected) {
@@ -141,6 +149,7 @@ func TestValidateToken(t *testing.T) {
 	// Create an accepted device with a known token
 	token, tokenHash, _ := auth.GenerateDeviceToken()
 	device := &domain.Device{
+		OrgID:         testOrgID,
 		IdentityHash:  "testhash123",
 		IdentityData:  domain.IdentityData{"device_type": "test"},
 		Status:        domain.DeviceStatusAccepted,
@@ -176,6 +185,7 @@ func TestGetByID(t *testing.T) {
 	ctx := context.Background()
 
 	device := &domain.Device{
+		OrgID:        testOrgID,
 		IdentityHash: "hash1",
 		IdentityData: domain.IdentityData{"device_type": "test"},
 		Status:       domain.DeviceStatusPending,
@@ -185,7 +195,7 @@ func TestGetByID(t *testing.T) {
 	}
 	repo.Create(ctx, device)
 
-	found, err := svc.GetByID(ctx, device.ID)
+	found, err := svc.GetByID(ctx, testOrgID, device.ID)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -198,7 +208,7 @@ func TestGetByID_NotFound(t *testing.T) {
 	svc, _ := newTestDeviceService()
 	ctx := context.Background()
 
-	_, err := svc.GetByID(ctx, uuid.New())
+	_, err := svc.GetByID(ctx, testOrgID, uuid.New())
 	if !errors.Is(err, domain.ErrNotFound) {
 		t.Fatalf("expected ErrNotFound, got %v", err)
 	}
@@ -209,6 +219,7 @@ func TestUpdateStatus(t *testing.T) {
 	ctx := context.Background()
 
 	device := &domain.Device{
+		OrgID:        testOrgID,
 		IdentityHash: "hash1",
 		IdentityData: domain.IdentityData{"device_type": "test"},
 		Status:       domain.DeviceStatusPending,
@@ -218,12 +229,12 @@ func TestUpdateStatus(t *testing.T) {
 	}
 	repo.Create(ctx, device)
 
-	err := svc.UpdateStatus(ctx, device.ID, domain.DeviceStatusAccepted)
+	err := svc.UpdateStatus(ctx, testOrgID, device.ID, domain.DeviceStatusAccepted)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	found, _ := repo.GetByID(ctx, device.ID)
+	found, _ := repo.GetByID(ctx, testOrgID, device.ID)
 	if found.Status != domain.DeviceStatusAccepted {
 		t.Fatalf("expected accepted, got %s", found.Status)
 	}
@@ -234,6 +245,7 @@ func TestUpdateInventory(t *testing.T) {
 	ctx := context.Background()
 
 	device := &domain.Device{
+		OrgID:        testOrgID,
 		IdentityHash: "hash1",
 		IdentityData: domain.IdentityData{"device_type": "test"},
 		Status:       domain.DeviceStatusAccepted,
@@ -244,12 +256,12 @@ func TestUpdateInventory(t *testing.T) {
 	repo.Create(ctx, device)
 
 	inv := map[string]interface{}{"os": "Linux", "arch": "arm64"}
-	err := svc.UpdateInventory(ctx, device.ID, inv)
+	err := svc.UpdateInventory(ctx, testOrgID, device.ID, inv)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	found, _ := repo.GetByID(ctx, device.ID)
+	found, _ := repo.GetByID(ctx, testOrgID, device.ID)
 	if found.Inventory["os"] != "Linux" {
 		t.Fatal("inventory not updated")
 	}
@@ -260,6 +272,7 @@ func TestUpdateTags(t *testing.T) {
 	ctx := context.Background()
 
 	device := &domain.Device{
+		OrgID:        testOrgID,
 		IdentityHash: "hash1",
 		IdentityData: domain.IdentityData{"device_type": "test"},
 		Status:       domain.DeviceStatusAccepted,
@@ -269,12 +282,12 @@ func TestUpdateTags(t *testing.T) {
 	}
 	repo.Create(ctx, device)
 
-	err := svc.UpdateTags(ctx, device.ID, []string{"production", "rack-1"})
+	err := svc.UpdateTags(ctx, testOrgID, device.ID, []string{"production", "rack-1"})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	found, _ := repo.GetByID(ctx, device.ID)
+	found, _ := repo.GetByID(ctx, testOrgID, device.ID)
 	if len(found.Tags) != 2 {
 		t.Fatalf("expected 2 tags, got %d", len(found.Tags))
 	}
@@ -285,6 +298,7 @@ func TestDecommission(t *testing.T) {
 	ctx := context.Background()
 
 	device := &domain.Device{
+		OrgID:        testOrgID,
 		IdentityHash: "hash1",
 		IdentityData: domain.IdentityData{"device_type": "test"},
 		Status:       domain.DeviceStatusAccepted,
@@ -294,12 +308,12 @@ func TestDecommission(t *testing.T) {
 	}
 	repo.Create(ctx, device)
 
-	err := svc.Decommission(ctx, device.ID)
+	err := svc.Decommission(ctx, testOrgID, device.ID)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	found, _ := repo.GetByID(ctx, device.ID)
+	found, _ := repo.GetByID(ctx, testOrgID, device.ID)
 	if found.Status != domain.DeviceStatusDecommissioned {
 		t.Fatalf("expected decommissioned, got %s", found.Status)
 	}
@@ -311,6 +325,7 @@ func TestCountByStatus(t *testing.T) {
 
 	for i := 0; i < 3; i++ {
 		repo.Create(ctx, &domain.Device{
+			OrgID:        testOrgID,
 			IdentityHash: uuid.New().String(),
 			IdentityData: domain.IdentityData{"device_type": "test"},
 			Status:       domain.DeviceStatusPending,
@@ -320,6 +335,7 @@ func TestCountByStatus(t *testing.T) {
 		})
 	}
 	repo.Create(ctx, &domain.Device{
+		OrgID:        testOrgID,
 		IdentityHash: uuid.New().String(),
 		IdentityData: domain.IdentityData{"device_type": "test"},
 		Status:       domain.DeviceStatusAccepted,
@@ -328,7 +344,7 @@ func TestCountByStatus(t *testing.T) {
 		Tags:         []string{},
 	})
 
-	counts, err := svc.CountByStatus(ctx)
+	counts, err := svc.CountByStatus(ctx, testOrgID)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -339,3 +355,138 @@ func TestCountByStatus(t *testing.T) {
 		t.Fatalf("expected 1 accepted, got %d", counts[domain.DeviceStatusAccepted])
 	}
 }
+
+func TestAuthenticate_TenantTokenSelectsOrganization(t *testing.T) {
+	svc, repo, orgs := newTestDeviceServiceWithOrgs()
+	ctx := context.Background()
+
+	org, token, err := orgs.Create(ctx, CreateOrganizationInput{Name: "Acme", Slug: "acme"})
+	if err != nil {
+		t.Fatalf("create organization: %v", err)
+	}
+
+	identity := domain.IdentityData{
+		"device_type":         "raspberry-pi-4",
+		"mac_address":         "aa:bb:cc:dd:ee:ff",
+		domain.TenantTokenKey: token,
+	}
+	if _, err := svc.Authenticate(ctx, identity); !errors.Is(err, domain.ErrDevicePending) {
+		t.Fatalf("expected ErrDevicePending, got %v", err)
+	}
+
+	devices, _, _ := repo.List(ctx, org.ID, domain.DeviceFilter{})
+	if len(devices) != 1 {
+		t.Fatalf("expected 1 device in organization, got %d", len(devices))
+	}
+	if _, stored := devices[0].IdentityData[domain.TenantTokenKey]; stored {
+		t.Fatal("tenant token must not be stored in the identity data")
+	}
+
+	// The same hardware without a token is a different device in the
+	// default organization
+	delete(identity, domain.TenantTokenKey)
+	svc.Authenticate(ctx, identity)
+	if len(repo.devices) != 2 {
+		t.Fatalf("expected 2 devices, got %d", len(repo.devices))
+	}
+}
+
+func TestAuthenticate_UnknownTenantToken(t *testing.T) {
+	svc, repo := newTestDeviceService()
+	ctx := context.Background()
+
+	_, err := svc.Authenticate(ctx, domain.IdentityData{
+		"device_type":         "raspberry-pi-4",
+		domain.TenantTokenKey: "not-a-token",
+	})
+	if !errors.Is(err, domain.ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized, got %v", err)
+	}
+	if len(repo.devices) != 0 {
+		t.Fatalf("expected no device to be created, got %d", len(repo.devices))
+	}
+}
+
+func TestGetByID_OtherOrganization(t *testing.T) {
+	svc, repo := newTestDeviceService()
+	ctx := context.Background()
+
+	device := &domain.Device{
+		OrgID:        testOrgID,
+		IdentityHash: "hash",
+		Status:       domain.DeviceStatusPending,
+		DeviceType:   "test",
+	}
+	repo.Create(ctx, device)
+
+	other := uuid.New()
+	if _, err := svc.GetByID(ctx, other, device.ID); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := svc.UpdateStatus(ctx, other, device.ID, domain.DeviceStatusAccepted); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound on update, got %v", err)
+	}
+	if device.Status != domain.DeviceStatusPending {
+		t.Fatalf("device of another organization was modified: %s", device.Status)
+	}
+}
+
+func TestSetEncryptionKey(t *testing.T) {
+	svc, repo := newTestDeviceService()
+	ctx := context.Background()
+
+	device := &domain.Device{
+		OrgID:        testOrgID,
+		IdentityHash: "hash1",
+		IdentityData: domain.IdentityData{"device_type": "test"},
+		Status:       domain.DeviceStatusAccepted,
+		DeviceType:   "test",
+		Inventory:    map[string]interface{}{},
+		Tags:         []string{},
+	}
+	repo.Create(ctx, device)
+
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{5}, 32))
+	if err := svc.SetEncryptionKey(ctx, testOrgID, device.ID, " "+key+"\n"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	found, _ := repo.GetByID(ctx, testOrgID, device.ID)
+	if found.EncryptionKey != key {
+		t.Fatalf("expected key %q, got %q", key, found.EncryptionKey)
+	}
+
+	for _, invalid := range []string{"", "not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if err := svc.SetEncryptionKey(ctx, testOrgID, device.ID, invalid); !errors.Is(err, domain.ErrInvalidInput) {
+			t.Errorf("%q: expected ErrInvalidInput, got %v", invalid, err)
+		}
+	}
+}
+
+func TestUpdateInventory_ValidatesInstalled(t *testing.T) {
+	svc, repo := newTestDeviceService()
+	ctx := context.Background()
+	d := &domain.Device{OrgID: testOrgID, IdentityHash: "hash", Status: domain.DeviceStatusAccepted}
+	repo.Create(ctx, d)
+
+	invalid := map[string]interface{}{
+		"not an object": "1.0.0",
+		"no version":    map[string]interface{}{"/usr/bin/app": map[string]interface{}{"checksum_sha256": strings.Repeat("a", 64)}},
+		"bad checksum":  map[string]interface{}{"/usr/bin/app": map[string]interface{}{"version": "1.0.0", "checksum_sha256": "abc"}},
+	}
+	for name, installed := range invalid {
+		err := svc.UpdateInventory(ctx, testOrgID, d.ID, map[string]interface{}{domain.InventoryInstalledKey: installed})
+		if !errors.Is(err, domain.ErrInvalidInput) {
+			t.Errorf("%s: expected ErrInvalidInput, got %v", name, err)
+		}
+	}
+
+	valid := map[string]interface{}{
+		"os": "Linux",
+		domain.InventoryInstalledKey: map[string]interface{}{
+			"/usr/bin/app": map[string]interface{}{"version": "1.0.0", "checksum_sha256": strings.Repeat("a", 64)},
+		},
+	}
+	if err := svc.UpdateInventory(ctx, testOrgID, d.ID, valid); err != nil {
+		t.Fatalf("update inventory: %v", err)
+	}
+}
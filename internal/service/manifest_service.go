@@ -0,0 +1,447 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+// ManifestService manages desired-state manifests. Devices converge to a
+// manifest through a deployment per artifact: when a device's report does
+// not match an artifact, it gets an entry in that deployment and installs
+// the artifact as for any deployment.
+type ManifestService struct {
+	repo       domain.ManifestRepository
+	artRepo    domain.ArtifactRepository
+	deviceRepo domain.DeviceRepository
+	deployRepo domain.DeploymentRepository
+	deploySvc  *DeploymentService
+	log        *slog.Logger
+}
+
+func NewManifestService(
+	repo domain.ManifestRepository,
+	artRepo domain.ArtifactRepository,
+	deviceRepo domain.DeviceRepository,
+	deployRepo domain.DeploymentRepository,
+	deploySvc *DeploymentService,
+	log *slog.Logger,
+) *ManifestService {
+	return &ManifestService{
+		repo:       repo,
+		artRepo:    artRepo,
+		deviceRepo: deviceRepo,
+		deployRepo: deployRepo,
+		deploySvc:  deploySvc,
+		log:        log,
+	}
+}
+
+type CreateManifestInput struct {
+	OrgID       uuid.UUID
+	Name        string
+	Description string
+	DeviceTags  []string
+	ArtifactIDs []uuid.UUID
+}
+
+// Create adds a manifest for the devices with all of its device tags,
+// creates the deployments they converge through and queues the devices
+// that do not match.
+func (s *ManifestService) Create(ctx context.Context, input CreateManifestInput) (*domain.Manifest, error) {
+	if !resourceName.MatchString(input.Name) {
+		return nil, fmt.Errorf("%w: name must be lowercase letters, digits, '.', '_' or '-'", domain.ErrInvalidInput)
+	}
+	var tags []string
+	for _, tag := range input.DeviceTags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("%w: at least one device tag is required", domain.ErrInvalidInput)
+	}
+	artifacts, err := s.manifestArtifacts(ctx, input.OrgID, input.ArtifactIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &domain.Manifest{
+		OrgID:       input.OrgID,
+		Name:        input.Name,
+		Description: input.Description,
+		DeviceTags:  tags,
+		Items:       []domain.ManifestItem{},
+	}
+	if err := s.repo.Create(ctx, manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Items, err = s.items(ctx, manifest, artifacts, nil); err != nil {
+		s.repo.Delete(ctx, input.OrgID, manifest.ID)
+		return nil, err
+	}
+	if err := s.repo.SetItems(ctx, input.OrgID, manifest.ID, manifest.Items); err != nil {
+		return nil, err
+	}
+	s.reconcileManifest(ctx, manifest)
+
+	s.log.Info("manifest created", "id", manifest.ID, "name", manifest.Name, "artifacts", len(manifest.Items))
+	return manifest, nil
+}
+
+// SetArtifacts replaces the artifacts of a manifest and queues the devices
+// that do not match the new ones. The deployments of artifacts that are no
+// longer in it are cancelled.
+func (s *ManifestService) SetArtifacts(ctx context.Context, orgID, id uuid.UUID, artifactIDs []uuid.UUID) (*domain.Manifest, error) {
+	manifest, err := s.repo.GetByID(ctx, orgID, id)
+	if err != nil {
+		return nil, err
+	}
+	artifacts, err := s.manifestArtifacts(ctx, orgID, artifactIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := manifest.Items
+	items, err := s.items(ctx, manifest, artifacts, previous)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.SetItems(ctx, orgID, id, items); err != nil {
+		return nil, err
+	}
+	for _, old := range previous {
+		if !containsArtifact(items, old.ArtifactID) {
+			s.stopDeployment(ctx, orgID, old.DeploymentID)
+		}
+	}
+
+	manifest.Items = items
+	s.reconcileManifest(ctx, manifest)
+	s.log.Info("manifest updated", "id", manifest.ID, "artifacts", len(items))
+	return manifest, nil
+}
+
+// manifestArtifacts returns the artifacts of ids. Each must install files
+// that devices report, and no two may share a name or a target path.
+func (s *ManifestService) manifestArtifacts(ctx context.Context, orgID uuid.UUID, ids []uuid.UUID) ([]*domain.Artifact, error) {
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("%w: at least one artifact is required", domain.ErrInvalidInput)
+	}
+	names := make(map[string]bool)
+	paths := make(map[string]string)
+	var artifacts []*domain.Artifact
+	for _, id := range ids {
+		artifact, err := s.artRepo.GetByID(ctx, orgID, id)
+		if err != nil {
+			if errors.Is(err, domain.ErrNotFound) {
+				return nil, fmt.Errorf("%w: artifact %s not found", domain.ErrInvalidInput, id)
+			}
+			return nil, err
+		}
+		if artifact.QuarantinedAt != nil {
+			return nil, fmt.Errorf("%w: %s %s is quarantined (%s)", domain.ErrInvalidInput, artifact.Name, artifact.Version, artifact.QuarantineReason)
+		}
+		if block := artifact.ValidationBlock(); block != "" {
+			return nil, fmt.Errorf("%w: %s %s failed validation (%s)", domain.ErrInvalidInput, artifact.Name, artifact.Version, block)
+		}
+		files := installedFiles(artifact)
+		if len(files) == 0 {
+			return nil, fmt.Errorf("%w: %s artifacts install no files and cannot be part of a manifest", domain.ErrInvalidInput, artifact.Kind)
+		}
+		if names[artifact.Name] {
+			return nil, fmt.Errorf("%w: manifest has more than one version of %s", domain.ErrInvalidInput, artifact.Name)
+		}
+		names[artifact.Name] = true
+		for path := range files {
+			if other, ok := paths[path]; ok {
+				return nil, fmt.Errorf("%w: %s and %s both install %s", domain.ErrInvalidInput, other, artifact.Name, path)
+			}
+			paths[path] = artifact.Name
+		}
+		artifacts = append(artifacts, artifact)
+	}
+	return artifacts, nil
+}
+
+// items returns the items of manifest for artifacts, keeping the deployments
+// of the previous items and creating one for each new artifact.
+func (s *ManifestService) items(ctx context.Context, manifest *domain.Manifest, artifacts []*domain.Artifact, previous []domain.ManifestItem) ([]domain.ManifestItem, error) {
+	items := make([]domain.ManifestItem, 0, len(artifacts))
+	for _, artifact := range artifacts {
+		if i := itemIndex(previous, artifact.ID); i >= 0 {
+			items = append(items, previous[i])
+			continue
+		}
+		deployment, err := s.deploySvc.Create(ctx, CreateDeploymentInput{
+			OrgID:            manifest.OrgID,
+			Name:             fmt.Sprintf("manifest %s: %s %s", manifest.Name, artifact.Name, artifact.Version),
+			ArtifactID:       artifact.ID,
+			TargetDeviceTags: manifest.DeviceTags,
+			ManifestID:       &manifest.ID,
+		})
+		if err != nil {
+			for _, item := range items {
+				if !containsArtifact(previous, item.ArtifactID) {
+					s.stopDeployment(ctx, manifest.OrgID, item.DeploymentID)
+				}
+			}
+			return nil, err
+		}
+		items = append(items, domain.ManifestItem{ArtifactID: artifact.ID, DeploymentID: &deployment.ID})
+	}
+	return items, nil
+}
+
+func itemIndex(items []domain.ManifestItem, artifactID uuid.UUID) int {
+	for i, item := range items {
+		if item.ArtifactID == artifactID {
+			return i
+		}
+	}
+	return -1
+}
+
+func containsArtifact(items []domain.ManifestItem, artifactID uuid.UUID) bool {
+	return itemIndex(items, artifactID) >= 0
+}
+
+// stopDeployment cancels a deployment of a manifest, if it is still running.
+func (s *ManifestService) stopDeployment(ctx context.Context, orgID uuid.UUID, id *uuid.UUID) {
+	if id == nil {
+		return
+	}
+	if err := s.deploySvc.Cancel(ctx, orgID, *id); err != nil && !errors.Is(err, domain.ErrInvalidInput) && !errors.Is(err, domain.ErrNotFound) {
+		s.log.Warn("failed to cancel manifest deployment", "deployment", *id, "err", err)
+	}
+}
+
+func (s *ManifestService) GetByID(ctx context.Context, orgID, id uuid.UUID) (*domain.Manifest, error) {
+	return s.repo.GetByID(ctx, orgID, id)
+}
+
+func (s *ManifestService) List(ctx context.Context, orgID uuid.UUID) ([]*domain.Manifest, error) {
+	return s.repo.List(ctx, orgID)
+}
+
+// Delete removes a manifest and cancels its deployments. Devices keep what
+// they installed.
+func (s *ManifestService) Delete(ctx context.Context, orgID, id uuid.UUID) error {
+	manifest, err := s.repo.GetByID(ctx, orgID, id)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.Delete(ctx, orgID, id); err != nil {
+		return err
+	}
+	for _, item := range manifest.Items {
+		s.stopDeployment(ctx, orgID, item.DeploymentID)
+	}
+	return nil
+}
+
+// DesiredState returns the artifacts of the manifests the device belongs to,
+// compared with its report, with the entry the device has in the deployment
+// of each artifact it does not match. It changes nothing: entries are added
+// by Reconcile.
+func (s *ManifestService) DesiredState(ctx context.Context, orgID, deviceID uuid.UUID) ([]*domain.DesiredItem, error) {
+	device, err := s.deviceRepo.GetByID(ctx, orgID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	desired := []*domain.DesiredItem{}
+	err = s.eachDesired(ctx, device, func(manifest *domain.Manifest, item domain.ManifestItem, artifact *domain.Artifact) error {
+		d := &domain.DesiredItem{
+			ManifestID:   manifest.ID,
+			ArtifactID:   artifact.ID,
+			ArtifactName: artifact.Name,
+			Version:      artifact.Version,
+			TargetPath:   artifact.TargetPath,
+			InSync:       reportMatches(artifact, device.Inventory),
+		}
+		if !d.InSync && item.DeploymentID != nil {
+			dd, err := s.deployRepo.GetDeviceEntry(ctx, orgID, *item.DeploymentID, device.ID)
+			if err != nil && !errors.Is(err, domain.ErrNotFound) {
+				return err
+			}
+			if err == nil {
+				d.DeploymentDeviceID = &dd.ID
+				d.Status = dd.Status
+			}
+		}
+		desired = append(desired, d)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return desired, nil
+}
+
+// Reconcile queues the device, in the deployment of each artifact of its
+// manifests that its report does not match, for the artifact to be
+// installed. It runs when the device reports its inventory.
+func (s *ManifestService) Reconcile(ctx context.Context, orgID, deviceID uuid.UUID) error {
+	device, err := s.deviceRepo.GetByID(ctx, orgID, deviceID)
+	if err != nil {
+		return err
+	}
+	return s.reconcile(ctx, device, nil)
+}
+
+// reconcile converges device to the artifacts of its manifests, or of only
+// manifest when it is set.
+func (s *ManifestService) reconcile(ctx context.Context, device *domain.Device, manifest *domain.Manifest) error {
+	return s.eachDesired(ctx, device, func(m *domain.Manifest, item domain.ManifestItem, artifact *domain.Artifact) error {
+		if manifest != nil && m.ID != manifest.ID {
+			return nil
+		}
+		if item.DeploymentID == nil || reportMatches(artifact, device.Inventory) {
+			return nil
+		}
+		_, err := s.converge(ctx, device.OrgID, *item.DeploymentID, device, artifact)
+		return err
+	})
+}
+
+// eachDesired calls fn for each artifact of the manifests of device that
+// the device is compatible with.
+func (s *ManifestService) eachDesired(ctx context.Context, device *domain.Device, fn func(*domain.Manifest, domain.ManifestItem, *domain.Artifact) error) error {
+	manifests, err := s.repo.ListByTags(ctx, device.OrgID, device.Tags)
+	if err != nil {
+		return err
+	}
+	for _, manifest := range manifests {
+		for _, item := range manifest.Items {
+			artifact, err := s.artRepo.GetByID(ctx, device.OrgID, item.ArtifactID)
+			if err != nil {
+				return fmt.Errorf("artifact %s: %w", item.ArtifactID, err)
+			}
+			if !matchesContinuous(manifest.DeviceTags, nil, artifact, device) {
+				continue
+			}
+			if err := fn(manifest, item, artifact); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reconcileManifest reconciles the accepted devices of a manifest that was
+// created or changed. Devices it fails for catch up when they next report.
+func (s *ManifestService) reconcileManifest(ctx context.Context, manifest *domain.Manifest) {
+	const perPage = 100
+	for page := 1; ; page++ {
+		devices, total, err := s.deviceRepo.List(ctx, manifest.OrgID, domain.DeviceFilter{
+			Status:  statusPtr(domain.DeviceStatusAccepted),
+			Tags:    manifest.DeviceTags,
+			Page:    page,
+			PerPage: perPage,
+		})
+		if err != nil {
+			s.log.Warn("failed to list manifest devices", "manifest", manifest.ID, "err", err)
+			return
+		}
+		for _, device := range devices {
+			if err := s.reconcile(ctx, device, manifest); err != nil {
+				s.log.Warn("failed to reconcile device", "manifest", manifest.ID, "device", device.ID, "err", err)
+			}
+		}
+		if len(devices) == 0 || page*perPage >= total {
+			return
+		}
+	}
+}
+
+// converge returns the entry of device in a deployment of a manifest it
+// does not match, adding it if needed. A successful entry is made pending
+// again if the device has reported its inventory since the install, as the
+// file changed after it. A failed entry stays failed until the manifest
+// changes.
+func (s *ManifestService) converge(ctx context.Context, orgID, deploymentID uuid.UUID, device *domain.Device, artifact *domain.Artifact) (*domain.DeploymentDevice, error) {
+	dd, err := s.deployRepo.GetDeviceEntry(ctx, orgID, deploymentID, device.ID)
+	if errors.Is(err, domain.ErrNotFound) {
+		if dd, err = newDeploymentDevice(deploymentID, device.ID, artifact); err != nil {
+			return nil, err
+		}
+		if err = s.deployRepo.CreateDeploymentDevice(ctx, dd); errors.Is(err, domain.ErrConflict) {
+			return s.deployRepo.GetDeviceEntry(ctx, orgID, deploymentID, device.ID)
+		}
+		if err == nil {
+			s.log.Info("device added to manifest deployment", "deployment", deploymentID, "device", device.ID)
+		}
+		return dd, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if dd.Status == domain.DDStatusSuccess && dd.FinishedAt != nil &&
+		device.InventoryUpdatedAt != nil && device.InventoryUpdatedAt.After(*dd.FinishedAt) {
+		if err := s.deployRepo.ResetDeploymentDevice(ctx, orgID, dd.ID); err != nil {
+			return nil, err
+		}
+		dd.Status = domain.DDStatusPending
+		s.log.Info("device drifted from manifest", "deployment", deploymentID, "device", device.ID)
+	}
+	return dd, nil
+}
+
+// Convergence returns how many of the accepted devices of a manifest match
+// all of its artifacts. Artifacts a device is not compatible with are not
+// required of it.
+func (s *ManifestService) Convergence(ctx context.Context, orgID, id uuid.UUID) (*domain.ManifestConvergence, error) {
+	manifest, err := s.repo.GetByID(ctx, orgID, id)
+	if err != nil {
+		return nil, err
+	}
+	artifacts := make([]*domain.Artifact, 0, len(manifest.Items))
+	for _, item := range manifest.Items {
+		artifact, err := s.artRepo.GetByID(ctx, orgID, item.ArtifactID)
+		if err != nil {
+			return nil, fmt.Errorf("artifact %s: %w", item.ArtifactID, err)
+		}
+		artifacts = append(artifacts, artifact)
+	}
+
+	result := &domain.ManifestConvergence{ManifestID: manifest.ID}
+	const perPage = 100
+	for page := 1; ; page++ {
+		devices, total, err := s.deviceRepo.List(ctx, orgID, domain.DeviceFilter{
+			Status:  statusPtr(domain.DeviceStatusAccepted),
+			Tags:    manifest.DeviceTags,
+			Page:    page,
+			PerPage: perPage,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, device := range devices {
+			result.Devices++
+			converged := true
+			for _, artifact := range artifacts {
+				if matchesContinuous(manifest.DeviceTags, nil, artifact, device) && !reportMatches(artifact, device.Inventory) {
+					converged = false
+					break
+				}
+			}
+			if converged {
+				result.Converged++
+			}
+		}
+		if len(devices) == 0 || page*perPage >= total {
+			break
+		}
+	}
+	if result.Devices > 0 {
+		result.Percentage = float64(result.Converged) * 100 / float64(result.Devices)
+	}
+	return result, nil
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+type manifestTestEnv struct {
+	*deploymentTestEnv
+	svc *ManifestService
+}
+
+func newTestManifestService() *manifestTestEnv {
+	env := newTestDeploymentService()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	svc := NewManifestService(newMockManifestRepo(), env.artRepo, env.deviceRepo, env.deployRepo, env.svc, log)
+	return &manifestTestEnv{deploymentTestEnv: env, svc: svc}
+}
+
+func TestManifestCreate_Validation(t *testing.T) {
+	env := newTestManifestService()
+	ctx := context.Background()
+
+	app := env.createArtifact(ctx, "myapp", "1.4.0", []string{"raspberry-pi-4"})
+	app2 := env.createArtifact(ctx, "myapp", "2.0.0", []string{"raspberry-pi-4"})
+	other := env.createArtifact(ctx, "other", "1.0.0", []string{"raspberry-pi-4"})
+	other.TargetPath = app.TargetPath
+	script := env.createArtifact(ctx, "cleanup", "1.0.0", []string{"raspberry-pi-4"})
+	script.Kind = domain.ArtifactKindScript
+
+	input := func(name string, ids ...uuid.UUID) CreateManifestInput {
+		return CreateManifestInput{OrgID: testOrgID, Name: name, DeviceTags: []string{"kiosk"}, ArtifactIDs: ids}
+	}
+	cases := map[string]CreateManifestInput{
+		"invalid name":     input("Kiosk Fleet", app.ID),
+		"no artifacts":     input("kiosk"),
+		"unknown artifact": input("kiosk", uuid.New()),
+		"script":           input("kiosk", script.ID),
+		"two versions":     input("kiosk", app.ID, app2.ID),
+		"same target path": input("kiosk", app.ID, other.ID),
+		"no device tags":   {OrgID: testOrgID, Name: "kiosk", ArtifactIDs: []uuid.UUID{app.ID}},
+	}
+	for name, in := range cases {
+		if _, err := env.svc.Create(ctx, in); !errors.Is(err, domain.ErrInvalidInput) {
+			t.Errorf("%s: expected ErrInvalidInput, got %v", name, err)
+		}
+	}
+
+	if _, err := env.svc.Create(ctx, input("kiosk", app.ID)); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := env.svc.Create(ctx, input("kiosk", app2.ID)); !errors.Is(err, domain.ErrConflict) {
+		t.Errorf("expected ErrConflict for a duplicate name, got %v", err)
+	}
+}
+
+func TestManifestConvergence(t *testing.T) {
+	env := newTestManifestService()
+	ctx := context.Background()
+
+	device := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{"kiosk"})
+	unreported := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{"kiosk"})
+	outside := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{"office"})
+	app := env.createArtifact(ctx, "myapp", "1.4.0", []string{"raspberry-pi-4"})
+	app.ChecksumSHA256 = strings.Repeat("a", 64)
+	agent := env.createArtifact(ctx, "agent", "2.0.0", []string{"raspberry-pi-4"})
+	agent.ChecksumSHA256 = strings.Repeat("b", 64)
+
+	// report stores the inventory of the device as the inventory endpoint does
+	report := func(files map[string]interface{}) {
+		if err := env.deviceRepo.UpdateInventory(ctx, testOrgID, device.ID, map[string]interface{}{domain.InventoryInstalledKey: files}); err != nil {
+			t.Fatalf("update inventory: %v", err)
+		}
+		if err := env.svc.Reconcile(ctx, testOrgID, device.ID); err != nil {
+			t.Fatalf("reconcile: %v", err)
+		}
+	}
+	desiredStatus := func() map[string]*domain.DesiredItem {
+		items, err := env.svc.DesiredState(ctx, testOrgID, device.ID)
+		if err != nil {
+			t.Fatalf("desired state: %v", err)
+		}
+		byName := make(map[string]*domain.DesiredItem)
+		for _, item := range items {
+			byName[item.ArtifactName] = item
+		}
+		return byName
+	}
+	entries := func(deploymentID uuid.UUID) map[uuid.UUID]bool {
+		dds, _ := env.deployRepo.GetDeploymentDevices(ctx, testOrgID, deploymentID)
+		devices := make(map[uuid.UUID]bool)
+		for _, dd := range dds {
+			devices[dd.DeviceID] = true
+		}
+		return devices
+	}
+
+	report(map[string]interface{}{app.TargetPath: map[string]interface{}{"version": "1.4.0", "checksum_sha256": app.ChecksumSHA256}})
+	manifest, err := env.svc.Create(ctx, CreateManifestInput{
+		OrgID: testOrgID, Name: "kiosk", DeviceTags: []string{"kiosk"}, ArtifactIDs: []uuid.UUID{app.ID, agent.ID},
+	})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	// Devices are queued for what their last report does not match
+	appDevices, agentDevices := entries(*manifest.Items[0].DeploymentID), entries(*manifest.Items[1].DeploymentID)
+	if len(appDevices) != 1 || !appDevices[unreported.ID] || len(agentDevices) != 2 || agentDevices[outside.ID] {
+		t.Fatalf("expected myapp queued for the unreported device and agent for both, got %v and %v", appDevices, agentDevices)
+	}
+	for _, item := range manifest.Items {
+		if dep, _ := env.deployRepo.GetByID(ctx, testOrgID, *item.DeploymentID); dep.ManifestID == nil || *dep.ManifestID != manifest.ID {
+			t.Fatalf("expected a deployment of the manifest, got %+v", dep)
+		}
+	}
+
+	desired := desiredStatus()
+	if len(desired) != 2 || !desired["myapp"].InSync || desired["myapp"].DeploymentDeviceID != nil {
+		t.Fatalf("expected myapp in sync, got %+v", desired["myapp"])
+	}
+	missing := desired["agent"]
+	if missing.InSync || missing.DeploymentDeviceID == nil || missing.Status != domain.DDStatusPending {
+		t.Fatalf("expected a pending entry for agent, got %+v", missing)
+	}
+
+	// The device installs through the regular deployment flow
+	dd, _, art, err := env.svc.deploySvc.GetNextForDevice(ctx, testOrgID, device.ID)
+	if err != nil || dd.ID != *missing.DeploymentDeviceID || art.ID != agent.ID {
+		t.Fatalf("expected the agent entry next, got %v %v", dd, err)
+	}
+	env.svc.deploySvc.UpdateDeviceStatus(ctx, testOrgID, dd.DeviceID, dd.ID, domain.DDStatusSuccess, "")
+	// Changes other than an inventory report are not drift
+	env.deviceRepo.UpdateTags(ctx, testOrgID, device.ID, []string{"kiosk", "lobby"})
+	env.deviceRepo.UpdateLastCheckIn(ctx, testOrgID, device.ID)
+	env.svc.Reconcile(ctx, testOrgID, device.ID)
+	if d := desiredStatus()["agent"]; d.Status != domain.DDStatusSuccess {
+		t.Errorf("expected the agent entry to stay successful before the device reports, got %+v", d)
+	}
+	synced := map[string]interface{}{
+		app.TargetPath:   map[string]interface{}{"version": "1.4.0", "checksum_sha256": app.ChecksumSHA256},
+		agent.TargetPath: map[string]interface{}{"version": "2.0.0", "checksum_sha256": agent.ChecksumSHA256},
+	}
+	report(synced)
+	if d := desiredStatus()["agent"]; !d.InSync {
+		t.Errorf("expected agent in sync after the install, got %+v", d)
+	}
+
+	convergence, err := env.svc.Convergence(ctx, testOrgID, manifest.ID)
+	if err != nil {
+		t.Fatalf("convergence: %v", err)
+	}
+	if convergence.Devices != 2 || convergence.Converged != 1 || convergence.Percentage != 50 {
+		t.Errorf("expected 1 of 2 devices converged, got %+v", convergence)
+	}
+	if items, _ := env.svc.DesiredState(ctx, testOrgID, outside.ID); len(items) != 0 {
+		t.Errorf("expected nothing desired of a device outside the manifest, got %+v", items)
+	}
+
+	// A file changed on the device is installed again once it reports, not
+	// when it asks for its desired state
+	synced[agent.TargetPath] = map[string]interface{}{"version": "2.0.0", "checksum_sha256": strings.Repeat("c", 64)}
+	env.deviceRepo.UpdateInventory(ctx, testOrgID, device.ID, map[string]interface{}{domain.InventoryInstalledKey: synced})
+	if d := desiredStatus()["agent"]; d.InSync || d.Status != domain.DDStatusSuccess {
+		t.Errorf("expected the desired state to leave the agent entry alone, got %+v", d)
+	}
+	report(synced)
+	if d := desiredStatus()["agent"]; d.InSync || d.Status != domain.DDStatusPending || *d.DeploymentDeviceID != dd.ID {
+		t.Errorf("expected the agent entry pending again, got %+v", d)
+	}
+
+	// Replacing an artifact cancels its deployment
+	agent21 := env.createArtifact(ctx, "agent", "2.1.0", []string{"raspberry-pi-4"})
+	updated, err := env.svc.SetArtifacts(ctx, testOrgID, manifest.ID, []uuid.UUID{app.ID, agent21.ID})
+	if err != nil {
+		t.Fatalf("set artifacts: %v", err)
+	}
+	if updated.Items[0] != manifest.Items[0] || updated.Items[1].ArtifactID != agent21.ID {
+		t.Errorf("unexpected items %+v", updated.Items)
+	}
+	if dep, _ := env.deployRepo.GetByID(ctx, testOrgID, *manifest.Items[1].DeploymentID); dep.Status != domain.DeploymentStatusCancelled {
+		t.Errorf("expected the agent 2.0.0 deployment cancelled, got %s", dep.Status)
+	}
+}
@@ -1,65 +1,100 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"io"
+	"io/fs"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 
 	"github.com/CaioWing/Harbor/internal/domain"
+	"github.com/CaioWing/Harbor/internal/semver"
 )
 
+// testOrgID is the organization fixtures are created in unless a test
+// exercises isolation between organizations.
+var testOrgID = domain.DefaultOrganizationID
+
 // --- Mock Device Repository ---
 
 type mockDeviceRepo struct {
 	mu      sync.RWMutex
 	devices map[uuid.UUID]*domain.Device
-	byHash  map[string]*domain.Device
 }
 
 func newMockDeviceRepo() *mockDeviceRepo {
 	return &mockDeviceRepo{
 		devices: make(map[uuid.UUID]*domain.Device),
-		byHash:  make(map[string]*domain.Device),
 	}
 }
 
 func (m *mockDeviceRepo) Create(_ context.Context, d *domain.Device) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	if _, exists := m.byHash[d.IdentityHash]; exists {
-		return domain.ErrConflict
+	for _, existing := range m.devices {
+		if existing.OrgID == d.OrgID && existing.IdentityHash == d.IdentityHash {
+			return domain.ErrConflict
+		}
 	}
 	d.ID = uuid.New()
 	m.devices[d.ID] = d
-	m.byHash[d.IdentityHash] = d
 	return nil
 }
 
-func (m *mockDeviceRepo) GetByID(_ context.Context, id uuid.UUID) (*domain.Device, error) {
+// get returns the device if it exists in orgID. Callers must hold the lock.
+func (m *mockDeviceRepo) get(orgID, id uuid.UUID) (*domain.Device, bool) {
+	d, ok := m.devices[id]
+	if !ok || d.OrgID != orgID {
+		return nil, false
+	}
+	return d, true
+}
+
+func (m *mockDeviceRepo) GetByID(_ context.Context, orgID, id uuid.UUID) (*domain.Device, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	if d, ok := m.devices[id]; ok {
+	if d, ok := m.get(orgID, id); ok {
 		return d, nil
 	}
 	return nil, domain.ErrNotFound
 }
 
-func (m *mockDeviceRepo) GetByIdentityHash(_ context.Context, hash string) (*domain.Device, error) {
+func (m *mockDeviceRepo) GetByIdentityHash(_ context.Context, orgID uuid.UUID, hash string) (*domain.Device, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	if d, ok := m.byHash[hash]; ok {
-		return d, nil
+	for _, d := range m.devices {
+		if d.OrgID == orgID && d.IdentityHash == hash {
+			return d, nil
+		}
+	}
+	return nil, domain.ErrNotFound
+}
+
+func (m *mockDeviceRepo) GetByAuthTokenHash(_ context.Context, tokenHash string) (*domain.Device, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, d := range m.devices {
+		if d.AuthTokenHash != "" && d.AuthTokenHash == tokenHash {
+			return d, nil
+		}
 	}
 	return nil, domain.ErrNotFound
 }
 
-func (m *mockDeviceRepo) List(_ context.Context, f domain.DeviceFilter) ([]*domain.Device, int, error) {
+func (m *mockDeviceRepo) List(_ context.Context, orgID uuid.UUID, f domain.DeviceFilter) ([]*domain.Device, int, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	var result []*domain.Device
 	for _, d := range m.devices {
+		if d.OrgID != orgID {
+			continue
+		}
 		if f.Status != nil && d.Status != *f.Status {
 			continue
 		}
@@ -74,64 +109,83 @@ func (m *mockDeviceRepo) List(_ context.Context, f domain.DeviceFilter) ([]*doma
 	return result, len(result), nil
 }
 
-func (m *mockDeviceRepo) UpdateStatus(_ context.Context, id uuid.UUID, status domain.DeviceStatus) error {
+func (m *mockDeviceRepo) UpdateStatus(_ context.Context, orgID, id uuid.UUID, status domain.DeviceStatus) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	d, ok := m.devices[id]
+	d, ok := m.get(orgID, id)
 	if !ok {
 		return domain.ErrNotFound
 	}
 	d.Status = status
+	d.UpdatedAt = time.Now()
 	return nil
 }
 
-func (m *mockDeviceRepo) UpdateAuthToken(_ context.Context, id uuid.UUID, tokenHash string) error {
+func (m *mockDeviceRepo) UpdateAuthToken(_ context.Context, orgID, id uuid.UUID, tokenHash string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	d, ok := m.devices[id]
+	d, ok := m.get(orgID, id)
 	if !ok {
 		return domain.ErrNotFound
 	}
 	d.AuthTokenHash = tokenHash
+	d.UpdatedAt = time.Now()
 	return nil
 }
 
-func (m *mockDeviceRepo) UpdateInventory(_ context.Context, id uuid.UUID, inventory map[string]interface{}) error {
+func (m *mockDeviceRepo) UpdateInventory(_ context.Context, orgID, id uuid.UUID, inventory map[string]interface{}) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	d, ok := m.devices[id]
+	d, ok := m.get(orgID, id)
 	if !ok {
 		return domain.ErrNotFound
 	}
+	now := time.Now()
 	d.Inventory = inventory
+	d.InventoryUpdatedAt, d.UpdatedAt = &now, now
 	return nil
 }
 
-func (m *mockDeviceRepo) UpdateTags(_ context.Context, id uuid.UUID, tags []string) error {
+func (m *mockDeviceRepo) UpdateTags(_ context.Context, orgID, id uuid.UUID, tags []string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	d, ok := m.devices[id]
+	d, ok := m.get(orgID, id)
 	if !ok {
 		return domain.ErrNotFound
 	}
 	d.Tags = tags
+	d.UpdatedAt = time.Now()
 	return nil
 }
 
-func (m *mockDeviceRepo) UpdateLastCheckIn(_ context.Context, id uuid.UUID) error {
+func (m *mockDeviceRepo) UpdateEncryptionKey(_ context.Context, orgID, id uuid.UUID, key string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	_, ok := m.devices[id]
+	d, ok := m.get(orgID, id)
 	if !ok {
 		return domain.ErrNotFound
 	}
+	d.EncryptionKey = key
+	d.UpdatedAt = time.Now()
 	return nil
 }
 
-func (m *mockDeviceRepo) Delete(_ context.Context, id uuid.UUID) error {
+func (m *mockDeviceRepo) UpdateLastCheckIn(_ context.Context, orgID, id uuid.UUID) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	d, ok := m.devices[id]
+	d, ok := m.get(orgID, id)
+	if !ok {
+		return domain.ErrNotFound
+	}
+	now := time.Now()
+	d.LastCheckIn, d.UpdatedAt = &now, now
+	return nil
+}
+
+func (m *mockDeviceRepo) Delete(_ context.Context, orgID, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.get(orgID, id)
 	if !ok {
 		return domain.ErrNotFound
 	}
@@ -139,12 +193,14 @@ func (m *mockDeviceRepo) Delete(_ context.Context, id uuid.UUID) error {
 	return nil
 }
 
-func (m *mockDeviceRepo) CountByStatus(_ context.Context) (map[domain.DeviceStatus]int, error) {
+func (m *mockDeviceRepo) CountByStatus(_ context.Context, orgID uuid.UUID) (map[domain.DeviceStatus]int, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	counts := make(map[domain.DeviceStatus]int)
 	for _, d := range m.devices {
-		counts[d.Status]++
+		if d.OrgID == orgID {
+			counts[d.Status]++
+		}
 	}
 	return counts, nil
 }
@@ -154,6 +210,9 @@ func (m *mockDeviceRepo) CountByStatus(_ context.Context) (map[domain.DeviceStat
 type mockArtifactRepo struct {
 	mu        sync.RWMutex
 	artifacts map[uuid.UUID]*domain.Artifact
+	order     []uuid.UUID // creation order
+	// deployRepo is set by newMockDeploymentRepo
+	deployRepo *mockDeploymentRepo
 }
 
 func newMockArtifactRepo() *mockArtifactRepo {
@@ -166,92 +225,227 @@ func (m *mockArtifactRepo) Create(_ context.Context, a *domain.Artifact) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	for _, existing := range m.artifacts {
-		if existing.Name == a.Name && existing.Version == a.Version {
+		if existing.OrgID == a.OrgID && existing.Name == a.Name && existing.Version == a.Version {
 			return domain.ErrConflict
 		}
 	}
 	a.ID = uuid.New()
+	a.CreatedAt = time.Now()
 	m.artifacts[a.ID] = a
+	m.order = append(m.order, a.ID)
 	return nil
 }
 
-func (m *mockArtifactRepo) GetByID(_ context.Context, id uuid.UUID) (*domain.Artifact, error) {
+func (m *mockArtifactRepo) GetByID(_ context.Context, orgID, id uuid.UUID) (*domain.Artifact, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	if a, ok := m.artifacts[id]; ok {
+	if a, ok := m.artifacts[id]; ok && a.OrgID == orgID {
 		return a, nil
 	}
 	return nil, domain.ErrNotFound
 }
 
-func (m *mockArtifactRepo) List(_ context.Context, _ domain.ArtifactFilter) ([]*domain.Artifact, int, error) {
+func (m *mockArtifactRepo) List(_ context.Context, orgID uuid.UUID, f domain.ArtifactFilter) ([]*domain.Artifact, int, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	var result []*domain.Artifact
 	for _, a := range m.artifacts {
-		result = append(result, a)
+		if f.Quarantined != nil && *f.Quarantined != (a.QuarantinedAt != nil) {
+			continue
+		}
+		if a.OrgID == orgID {
+			result = append(result, a)
+		}
 	}
 	return result, len(result), nil
 }
 
-func (m *mockArtifactRepo) Delete(_ context.Context, id uuid.UUID) error {
+func (m *mockArtifactRepo) ListByName(_ context.Context, orgID uuid.UUID, name string) ([]*domain.Artifact, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	result := []*domain.Artifact{}
+	for i := len(m.order) - 1; i >= 0; i-- {
+		if a, ok := m.artifacts[m.order[i]]; ok && a.OrgID == orgID && a.Name == name {
+			result = append(result, a)
+		}
+	}
+	return result, nil
+}
+
+func (m *mockArtifactRepo) ListLatest(_ context.Context, orgID uuid.UUID, f domain.LatestArtifactFilter) ([]*domain.LatestArtifact, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	best := make(map[[2]string]*domain.LatestArtifact)
+	versions := make(map[[2]string]semver.Version)
+	for _, id := range m.order {
+		a, ok := m.artifacts[id]
+		if !ok || a.OrgID != orgID || a.QuarantinedAt != nil || a.ValidationBlock() != "" || (f.Name != nil && a.Name != *f.Name) {
+			continue
+		}
+		v, err := semver.Parse(a.Version)
+		if err != nil || (v.IsPrerelease() && !f.Prerelease) {
+			continue
+		}
+		for _, dt := range a.DeviceTypes {
+			if f.DeviceType != nil && dt != *f.DeviceType {
+				continue
+			}
+			key := [2]string{a.Name, dt}
+			if cur, ok := versions[key]; !ok || v.Compare(cur) >= 0 {
+				best[key] = &domain.LatestArtifact{Name: a.Name, DeviceType: dt, Artifact: a}
+				versions[key] = v
+			}
+		}
+	}
+	result := []*domain.LatestArtifact{}
+	for _, l := range best {
+		result = append(result, l)
+	}
+	slices.SortFunc(result, func(a, b *domain.LatestArtifact) int {
+		return strings.Compare(a.Name+"\x00"+a.DeviceType, b.Name+"\x00"+b.DeviceType)
+	})
+	return result, nil
+}
+
+func (m *mockArtifactRepo) Delete(_ context.Context, orgID, id uuid.UUID) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	if _, ok := m.artifacts[id]; !ok {
+	if a, ok := m.artifacts[id]; !ok || a.OrgID != orgID {
 		return domain.ErrNotFound
 	}
 	delete(m.artifacts, id)
 	return nil
 }
 
+func (m *mockArtifactRepo) DeleteWithDeployments(ctx context.Context, orgID, id uuid.UUID) (int, error) {
+	m.mu.RLock()
+	a, ok := m.artifacts[id]
+	m.mu.RUnlock()
+	if !ok || a.OrgID != orgID {
+		return 0, domain.ErrNotFound
+	}
+	n := 0
+	if m.deployRepo != nil {
+		var err error
+		if n, err = m.deployRepo.deleteByArtifact(orgID, id); err != nil {
+			return 0, err
+		}
+	}
+	return n, m.Delete(ctx, orgID, id)
+}
+
+func (m *mockArtifactRepo) SetQuarantine(_ context.Context, orgID uuid.UUID, checksum, reason string) ([]uuid.UUID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ids []uuid.UUID
+	for _, a := range m.artifacts {
+		if a.OrgID != orgID || !hasContent(a, checksum) {
+			continue
+		}
+		if a.QuarantinedAt == nil {
+			now := time.Now()
+			a.QuarantinedAt = &now
+		}
+		a.QuarantineReason = reason
+		ids = append(ids, a.ID)
+	}
+	return ids, nil
+}
+
+func (m *mockArtifactRepo) ReleaseQuarantine(_ context.Context, orgID uuid.UUID, checksum string, broken []string) ([]uuid.UUID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ids []uuid.UUID
+	for _, a := range m.artifacts {
+		if a.OrgID != orgID || a.QuarantinedAt == nil || !hasContent(a, checksum) {
+			continue
+		}
+		if slices.ContainsFunc(a.Files, func(f domain.ArtifactFile) bool { return slices.Contains(broken, f.ChecksumSHA256) }) {
+			continue
+		}
+		a.QuarantinedAt = nil
+		a.QuarantineReason = ""
+		ids = append(ids, a.ID)
+	}
+	return ids, nil
+}
+
+func hasContent(a *domain.Artifact, checksum string) bool {
+	if a.ChecksumSHA256 == checksum {
+		return true
+	}
+	return slices.ContainsFunc(a.Files, func(f domain.ArtifactFile) bool { return f.ChecksumSHA256 == checksum })
+}
+
 // --- Mock Deployment Repository ---
 
 type mockDeploymentRepo struct {
 	mu          sync.RWMutex
 	deployments map[uuid.UUID]*domain.Deployment
 	ddEntries   map[uuid.UUID]*domain.DeploymentDevice
+	output      map[uuid.UUID][]*domain.OutputChunk
+	seq         int64
 	artRepo     *mockArtifactRepo
+	devRepo     *mockDeviceRepo
 }
 
-func newMockDeploymentRepo(artRepo *mockArtifactRepo) *mockDeploymentRepo {
-	return &mockDeploymentRepo{
+func newMockDeploymentRepo(artRepo *mockArtifactRepo, devRepo *mockDeviceRepo) *mockDeploymentRepo {
+	m := &mockDeploymentRepo{
 		deployments: make(map[uuid.UUID]*domain.Deployment),
 		ddEntries:   make(map[uuid.UUID]*domain.DeploymentDevice),
+		output:      make(map[uuid.UUID][]*domain.OutputChunk),
 		artRepo:     artRepo,
+		devRepo:     devRepo,
+	}
+	if artRepo != nil {
+		artRepo.deployRepo = m
 	}
+	return m
 }
 
 func (m *mockDeploymentRepo) Create(_ context.Context, d *domain.Deployment) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	d.ID = uuid.New()
+	d.CreatedAt = time.Now()
 	m.deployments[d.ID] = d
 	return nil
 }
 
-func (m *mockDeploymentRepo) GetByID(_ context.Context, id uuid.UUID) (*domain.Deployment, error) {
+// get returns the deployment if it exists in orgID. Callers must hold the lock.
+func (m *mockDeploymentRepo) get(orgID, id uuid.UUID) (*domain.Deployment, bool) {
+	d, ok := m.deployments[id]
+	if !ok || d.OrgID != orgID {
+		return nil, false
+	}
+	return d, true
+}
+
+func (m *mockDeploymentRepo) GetByID(_ context.Context, orgID, id uuid.UUID) (*domain.Deployment, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	if d, ok := m.deployments[id]; ok {
+	if d, ok := m.get(orgID, id); ok {
 		return d, nil
 	}
 	return nil, domain.ErrNotFound
 }
 
-func (m *mockDeploymentRepo) List(_ context.Context, _ domain.DeploymentFilter) ([]*domain.Deployment, int, error) {
+func (m *mockDeploymentRepo) List(_ context.Context, orgID uuid.UUID, _ domain.DeploymentFilter) ([]*domain.Deployment, int, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	var result []*domain.Deployment
 	for _, d := range m.deployments {
-		result = append(result, d)
+		if d.OrgID == orgID {
+			result = append(result, d)
+		}
 	}
 	return result, len(result), nil
 }
 
-func (m *mockDeploymentRepo) UpdateStatus(_ context.Context, id uuid.UUID, status domain.DeploymentStatus) error {
+func (m *mockDeploymentRepo) UpdateStatus(_ context.Context, orgID, id uuid.UUID, status domain.DeploymentStatus) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	d, ok := m.deployments[id]
+	d, ok := m.get(orgID, id)
 	if !ok {
 		return domain.ErrNotFound
 	}
@@ -259,10 +453,10 @@ func (m *mockDeploymentRepo) UpdateStatus(_ context.Context, id uuid.UUID, statu
 	return nil
 }
 
-func (m *mockDeploymentRepo) SetStarted(_ context.Context, id uuid.UUID) error {
+func (m *mockDeploymentRepo) SetStarted(_ context.Context, orgID, id uuid.UUID) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	d, ok := m.deployments[id]
+	d, ok := m.get(orgID, id)
 	if !ok {
 		return domain.ErrNotFound
 	}
@@ -270,10 +464,10 @@ func (m *mockDeploymentRepo) SetStarted(_ context.Context, id uuid.UUID) error {
 	return nil
 }
 
-func (m *mockDeploymentRepo) SetFinished(_ context.Context, id uuid.UUID) error {
+func (m *mockDeploymentRepo) SetFinished(_ context.Context, orgID, id uuid.UUID) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	d, ok := m.deployments[id]
+	d, ok := m.get(orgID, id)
 	if !ok {
 		return domain.ErrNotFound
 	}
@@ -281,11 +475,37 @@ func (m *mockDeploymentRepo) SetFinished(_ context.Context, id uuid.UUID) error
 	return nil
 }
 
-func (m *mockDeploymentRepo) GetStats(_ context.Context) (*domain.DeploymentStats, error) {
+func (m *mockDeploymentRepo) ListSummariesByArtifacts(_ context.Context, orgID uuid.UUID, artifactIDs []uuid.UUID) ([]*domain.DeploymentSummary, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	result := []*domain.DeploymentSummary{}
+	for _, d := range m.deployments {
+		if d.OrgID != orgID || !slices.Contains(artifactIDs, d.ArtifactID) {
+			continue
+		}
+		s := &domain.DeploymentSummary{
+			ID: d.ID, ArtifactID: d.ArtifactID, Name: d.Name, Status: d.Status,
+			Devices: make(map[domain.DeploymentDeviceStatus]int), CreatedAt: d.CreatedAt, FinishedAt: d.FinishedAt,
+		}
+		for _, dd := range m.ddEntries {
+			if dd.DeploymentID == d.ID {
+				s.Devices[dd.Status]++
+			}
+		}
+		result = append(result, s)
+	}
+	slices.SortFunc(result, func(a, b *domain.DeploymentSummary) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return result, nil
+}
+
+func (m *mockDeploymentRepo) GetStats(_ context.Context, orgID uuid.UUID) (*domain.DeploymentStats, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	stats := &domain.DeploymentStats{}
 	for _, d := range m.deployments {
+		if d.OrgID != orgID {
+			continue
+		}
 		stats.Total++
 		switch d.Status {
 		case domain.DeploymentStatusScheduled:
@@ -301,9 +521,16 @@ func (m *mockDeploymentRepo) GetStats(_ context.Context) (*domain.DeploymentStat
 	return stats, nil
 }
 
-func (m *mockDeploymentRepo) CreateDeploymentDevice(_ context.Context, dd *domain.DeploymentDevice) error {
+func (m *mockDeploymentRepo) CreateDeploymentDevice(ctx context.Context, dd *domain.DeploymentDevice) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	dep, ok := m.deployments[dd.DeploymentID]
+	if !ok {
+		return domain.ErrNotFound
+	}
+	if _, err := m.devRepo.GetByID(ctx, dep.OrgID, dd.DeviceID); err != nil {
+		return err
+	}
 	for _, existing := range m.ddEntries {
 		if existing.DeploymentID == dd.DeploymentID && existing.DeviceID == dd.DeviceID {
 			return domain.ErrConflict
@@ -314,10 +541,13 @@ func (m *mockDeploymentRepo) CreateDeploymentDevice(_ context.Context, dd *domai
 	return nil
 }
 
-func (m *mockDeploymentRepo) GetDeploymentDevices(_ context.Context, deploymentID uuid.UUID) ([]*domain.DeploymentDevice, error) {
+func (m *mockDeploymentRepo) GetDeploymentDevices(_ context.Context, orgID, deploymentID uuid.UUID) ([]*domain.DeploymentDevice, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	var result []*domain.DeploymentDevice
+	if _, ok := m.get(orgID, deploymentID); !ok {
+		return result, nil
+	}
 	for _, dd := range m.ddEntries {
 		if dd.DeploymentID == deploymentID {
 			result = append(result, dd)
@@ -326,115 +556,1385 @@ func (m *mockDeploymentRepo) GetDeploymentDevices(_ context.Context, deploymentI
 	return result, nil
 }
 
-func (m *mockDeploymentRepo) GetPendingDeploymentForDevice(_ context.Context, deviceID uuid.UUID) (*domain.DeploymentDevice, *domain.Deployment, *domain.Artifact, error) {
+func (m *mockDeploymentRepo) GetDeviceEntry(_ context.Context, orgID, deploymentID, deviceID uuid.UUID) (*domain.DeploymentDevice, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
+	if _, ok := m.get(orgID, deploymentID); !ok {
+		return nil, domain.ErrNotFound
+	}
 	for _, dd := range m.ddEntries {
-		if dd.DeviceID == deviceID && dd.Status == domain.DDStatusPending {
-			dep := m.deployments[dd.DeploymentID]
-			if dep != nil && dep.Status == domain.DeploymentStatusActive {
-				art, _ := m.artRepo.GetByID(context.Background(), dep.ArtifactID)
-				return dd, dep, art, nil
-			}
+		if dd.DeploymentID == deploymentID && dd.DeviceID == deviceID {
+			return dd, nil
 		}
 	}
-	return nil, nil, nil, domain.ErrNotFound
+	return nil, domain.ErrNotFound
 }
 
-func (m *mockDeploymentRepo) UpdateDeploymentDeviceStatus(_ context.Context, id uuid.UUID, status domain.DeploymentDeviceStatus, log string) error {
+func (m *mockDeploymentRepo) ResetDeploymentDevice(_ context.Context, orgID, id uuid.UUID) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	dd, ok := m.ddEntries[id]
+	dd, ok := m.getDD(orgID, id)
 	if !ok {
 		return domain.ErrNotFound
 	}
-	dd.Status = status
-	dd.Log = log
+	dd.Status = domain.DDStatusPending
+	dd.Log = ""
+	dd.StartedAt = nil
+	dd.FinishedAt = nil
+	dd.ExitCode = nil
 	return nil
 }
 
-func (m *mockDeploymentRepo) CountDeploymentDevicesByStatus(_ context.Context, deploymentID uuid.UUID) (map[domain.DeploymentDeviceStatus]int, error) {
+func (m *mockDeploymentRepo) ListPreviousArtifacts(ctx context.Context, orgID, deploymentID uuid.UUID) (map[uuid.UUID]uuid.UUID, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	counts := make(map[domain.DeploymentDeviceStatus]int)
+	bad, ok := m.get(orgID, deploymentID)
+	if !ok {
+		return map[uuid.UUID]uuid.UUID{}, nil
+	}
+	badArt, err := m.artRepo.GetByID(ctx, orgID, bad.ArtifactID)
+	if err != nil {
+		return nil, err
+	}
+	previous := make(map[uuid.UUID]uuid.UUID)
+	for _, badDD := range m.ddEntries {
+		if badDD.DeploymentID != deploymentID || badDD.Status != domain.DDStatusSuccess {
+			continue
+		}
+		var last *domain.DeploymentDevice
+		for _, dd := range m.ddEntries {
+			if dd.DeviceID != badDD.DeviceID || dd.Status != domain.DDStatusSuccess ||
+				!dd.FinishedAt.Before(*badDD.FinishedAt) || (last != nil && !dd.FinishedAt.After(*last.FinishedAt)) {
+				continue
+			}
+			dep, ok := m.get(orgID, dd.DeploymentID)
+			if !ok {
+				continue
+			}
+			art, err := m.artRepo.GetByID(ctx, orgID, dep.ArtifactID)
+			if err != nil || art.ID == badArt.ID || art.Name != badArt.Name || art.TargetPath != badArt.TargetPath {
+				continue
+			}
+			last = dd
+			previous[badDD.DeviceID] = art.ID
+		}
+	}
+	return previous, nil
+}
+
+func (m *mockDeploymentRepo) ListInstallations(_ context.Context, orgID uuid.UUID, deviceIDs []uuid.UUID) ([]*domain.Installation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var result []*domain.Installation
 	for _, dd := range m.ddEntries {
-		if dd.DeploymentID == deploymentID {
-			counts[dd.Status]++
+		dep, ok := m.get(orgID, dd.DeploymentID)
+		if !ok || dd.Status != domain.DDStatusSuccess || !slices.Contains(deviceIDs, dd.DeviceID) {
+			continue
 		}
+		result = append(result, &domain.Installation{
+			DeviceID: dd.DeviceID, DeploymentID: dep.ID, ArtifactID: dep.ArtifactID, InstalledAt: *dd.FinishedAt,
+		})
 	}
-	return counts, nil
+	slices.SortFunc(result, func(a, b *domain.Installation) int { return a.InstalledAt.Compare(b.InstalledAt) })
+	return result, nil
 }
 
-// --- Mock File Store ---
+func (m *mockDeploymentRepo) ListDeployedArtifacts(_ context.Context, orgID uuid.UUID, since time.Time) ([]uuid.UUID, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var result []uuid.UUID
+	for _, d := range m.deployments {
+		recent := d.CreatedAt.After(since)
+		running := d.Continuous
+		for _, dd := range m.ddEntries {
+			if dd.DeploymentID != d.ID {
+				continue
+			}
+			recent = recent || (dd.FinishedAt != nil && dd.FinishedAt.After(since))
+			running = running || dd.Status == domain.DDStatusPending || dd.Status == domain.DDStatusDownloading || dd.Status == domain.DDStatusInstalling
+		}
+		running = running && d.Status != domain.DeploymentStatusCancelled
+		if d.OrgID == orgID && (recent || running) && !slices.Contains(result, d.ArtifactID) {
+			result = append(result, d.ArtifactID)
+		}
+	}
+	return result, nil
+}
 
-type mockFileStore struct {
-	mu    sync.RWMutex
-	files map[string][]byte
+func (m *mockDeploymentRepo) deleteByArtifact(orgID, artifactID uuid.UUID) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, d := range m.deployments {
+		if d.OrgID == orgID && d.ArtifactID == artifactID &&
+			d.Status != domain.DeploymentStatusCompleted && d.Status != domain.DeploymentStatusCancelled {
+			return 0, domain.ErrConflict
+		}
+	}
+	n := 0
+	for id, d := range m.deployments {
+		if d.OrgID != orgID || d.ArtifactID != artifactID {
+			continue
+		}
+		for ddID, dd := range m.ddEntries {
+			if dd.DeploymentID == id {
+				delete(m.ddEntries, ddID)
+			}
+		}
+		delete(m.deployments, id)
+		n++
+	}
+	return n, nil
 }
 
-func newMockFileStore() *mockFileStore {
-	return &mockFileStore{files: make(map[string][]byte)}
+func (m *mockDeploymentRepo) ListContinuous(_ context.Context, orgID, deviceID uuid.UUID) ([]*domain.Deployment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var result []*domain.Deployment
+	for _, d := range m.deployments {
+		if d.OrgID != orgID || !d.Continuous || d.Status != domain.DeploymentStatusActive {
+			continue
+		}
+		enrolled := false
+		for _, dd := range m.ddEntries {
+			if dd.DeploymentID == d.ID && dd.DeviceID == deviceID {
+				enrolled = true
+			}
+		}
+		if !enrolled {
+			result = append(result, d)
+		}
+	}
+	return result, nil
 }
 
-func (m *mockFileStore) Save(name string, reader io.Reader) (string, int64, error) {
-	data, err := io.ReadAll(reader)
-	if err != nil {
-		return "", 0, err
+func (m *mockDeploymentRepo) GetPendingDeploymentForDevice(_ context.Context, orgID, deviceID uuid.UUID) (*domain.DeploymentDevice, *domain.Deployment, *domain.Artifact, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, dd := range m.ddEntries {
+		if dd.DeviceID == deviceID && dd.Status == domain.DDStatusPending {
+			dep, ok := m.get(orgID, dd.DeploymentID)
+			if ok && dep.Status == domain.DeploymentStatusActive {
+				art, _ := m.artRepo.GetByID(context.Background(), orgID, dep.ArtifactID)
+				if art != nil && art.QuarantinedAt != nil {
+					continue
+				}
+				return dd, dep, art, nil
+			}
+		}
 	}
-	path := "/mock/storage/" + name
-	m.mu.Lock()
-	m.files[path] = data
-	m.mu.Unlock()
-	return path, int64(len(data)), nil
+	return nil, nil, nil, domain.ErrNotFound
 }
 
-func (m *mockFileStore) Open(path string) (io.ReadCloser, error) {
+func (m *mockDeploymentRepo) GetActiveDeploymentDevice(_ context.Context, orgID, deviceID, ddID uuid.UUID) (*domain.DeploymentDevice, *domain.Deployment, *domain.Artifact, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	data, ok := m.files[path]
-	if !ok {
-		return nil, domain.ErrNotFound
+	dd, ok := m.ddEntries[ddID]
+	if !ok || dd.DeviceID != deviceID {
+		return nil, nil, nil, domain.ErrNotFound
+	}
+	switch dd.Status {
+	case domain.DDStatusPending, domain.DDStatusDownloading, domain.DDStatusInstalling:
+	default:
+		return nil, nil, nil, domain.ErrNotFound
+	}
+	dep, ok := m.get(orgID, dd.DeploymentID)
+	if !ok || (dep.Status != domain.DeploymentStatusActive && dep.Status != domain.DeploymentStatusScheduled) {
+		return nil, nil, nil, domain.ErrNotFound
 	}
-	return io.NopCloser(io.NewSectionReader(newBytesReaderAt(data), 0, int64(len(data)))), nil
+	art, _ := m.artRepo.GetByID(context.Background(), orgID, dep.ArtifactID)
+	if art != nil && art.QuarantinedAt != nil {
+		return nil, nil, nil, domain.ErrNotFound
+	}
+	return dd, dep, art, nil
 }
 
-func (m *mockFileStore) Delete(path string) error {
+func (m *mockDeploymentRepo) UpdateDeploymentDeviceStatus(_ context.Context, orgID, id uuid.UUID, status domain.DeploymentDeviceStatus, log string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	delete(m.files, path)
+	dd, ok := m.ddEntries[id]
+	if !ok {
+		return domain.ErrNotFound
+	}
+	if _, ok := m.get(orgID, dd.DeploymentID); !ok {
+		return domain.ErrNotFound
+	}
+	dd.Status = status
+	dd.Log = log
+	if status == domain.DDStatusSuccess || status == domain.DDStatusFailure {
+		now := time.Now()
+		dd.FinishedAt = &now
+	}
 	return nil
 }
 
-// Helper: bytes.Reader implements io.ReaderAt
-type bytesReaderAt struct {
-	data []byte
+// getDD returns the entry if its deployment is in orgID. Callers must hold
+// the lock.
+func (m *mockDeploymentRepo) getDD(orgID, id uuid.UUID) (*domain.DeploymentDevice, bool) {
+	dd, ok := m.ddEntries[id]
+	if !ok {
+		return nil, false
+	}
+	if _, ok := m.get(orgID, dd.DeploymentID); !ok {
+		return nil, false
+	}
+	return dd, true
 }
 
-func newBytesReaderAt(data []byte) *bytesReaderAt {
-	return &bytesReaderAt{data: data}
+func (m *mockDeploymentRepo) GetDeploymentDevice(_ context.Context, orgID, id uuid.UUID) (*domain.DeploymentDevice, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if dd, ok := m.getDD(orgID, id); ok {
+		return dd, nil
+	}
+	return nil, domain.ErrNotFound
 }
 
-func (b *bytesReaderAt) ReadAt(p []byte, off int64) (int, error) {
-	if off >= int64(len(b.data)) {
-		return 0, io.EOF
-	}
-	n := copy(p, b.data[off:])
-	if n < len(p) {
-		return n, io.EOF
+func (m *mockDeploymentRepo) FinishScript(_ context.Context, orgID, id uuid.UUID, status domain.DeploymentDeviceStatus, log string, code int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dd, ok := m.getDD(orgID, id)
+	if !ok || dd.FinishedAt != nil {
+		return domain.ErrNotFound
 	}
-	return n, nil
+	now := time.Now()
+	dd.Status, dd.Log, dd.ExitCode, dd.FinishedAt = status, log, &code, &now
+	return nil
 }
 
-// Helper function
-func containsAll(haystack []string, needles []string) bool {
-	set := make(map[string]bool, len(haystack))
-	for _, s := range haystack {
-		set[s] = true
+func (m *mockDeploymentRepo) AppendOutput(_ context.Context, orgID, id uuid.UUID, chunk *domain.OutputChunk, maxSize int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dd, ok := m.getDD(orgID, id)
+	if !ok {
+		return false, domain.ErrNotFound
 	}
-	for _, n := range needles {
-		if !set[n] {
-			return false
-		}
+	truncated := false
+	if remaining := maxSize - dd.OutputSize; int64(len(chunk.Data)) > remaining {
+		chunk.Data = chunk.Data[:max(remaining, 0)]
+		truncated = true
 	}
-	return true
+	if chunk.Data != "" {
+		m.seq++
+		chunk.Seq = m.seq
+		m.output[id] = append(m.output[id], chunk)
+	}
+	dd.OutputSize += int64(len(chunk.Data))
+	dd.OutputTruncated = dd.OutputTruncated || truncated
+	return truncated, nil
+}
+
+func (m *mockDeploymentRepo) ListOutput(_ context.Context, orgID, id uuid.UUID, after int64, limit int) ([]*domain.OutputChunk, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	chunks := []*domain.OutputChunk{}
+	if _, ok := m.getDD(orgID, id); !ok {
+		return chunks, nil
+	}
+	for _, c := range m.output[id] {
+		if c.Seq > after && len(chunks) < limit {
+			chunks = append(chunks, c)
+		}
+	}
+	return chunks, nil
+}
+
+func (m *mockDeploymentRepo) CountDeploymentDevicesByStatus(_ context.Context, orgID, deploymentID uuid.UUID) (map[domain.DeploymentDeviceStatus]int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	counts := make(map[domain.DeploymentDeviceStatus]int)
+	if _, ok := m.get(orgID, deploymentID); !ok {
+		return counts, nil
+	}
+	for _, dd := range m.ddEntries {
+		if dd.DeploymentID == deploymentID {
+			counts[dd.Status]++
+		}
+	}
+	return counts, nil
+}
+
+// --- Mock Token Repository ---
+
+type mockTokenRepo struct {
+	mu      sync.RWMutex
+	refresh map[uuid.UUID]*domain.RefreshToken
+	revoked map[string]time.Time
+}
+
+func newMockTokenRepo() *mockTokenRepo {
+	return &mockTokenRepo{
+		refresh: make(map[uuid.UUID]*domain.RefreshToken),
+		revoked: make(map[string]time.Time),
+	}
+}
+
+func (m *mockTokenRepo) CreateRefreshToken(_ context.Context, t *domain.RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t.ID = uuid.New()
+	t.CreatedAt = time.Now()
+	m.refresh[t.ID] = t
+	return nil
+}
+
+func (m *mockTokenRepo) GetRefreshTokenByHash(_ context.Context, hash string) (*domain.RefreshToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, t := range m.refresh {
+		if t.TokenHash == hash {
+			return t, nil
+		}
+	}
+	return nil, domain.ErrNotFound
+}
+
+func (m *mockTokenRepo) RotateRefreshToken(_ context.Context, oldID uuid.UUID, next *domain.RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old, ok := m.refresh[oldID]
+	if !ok || old.RevokedAt != nil {
+		return domain.ErrConflict
+	}
+	next.ID = uuid.New()
+	next.CreatedAt = time.Now()
+	m.refresh[next.ID] = next
+	now := time.Now()
+	old.RevokedAt = &now
+	old.ReplacedBy = &next.ID
+	return nil
+}
+
+func (m *mockTokenRepo) RevokeRefreshTokenFamily(_ context.Context, familyID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for _, t := range m.refresh {
+		if t.FamilyID == familyID && t.RevokedAt == nil {
+			t.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+func (m *mockTokenRepo) RevokeUserRefreshTokens(_ context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for _, t := range m.refresh {
+		if t.UserID == userID && t.RevokedAt == nil {
+			t.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+func (m *mockTokenRepo) RevokeAccessToken(_ context.Context, jti string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.revoked[jti] = expiresAt
+	return nil
+}
+
+func (m *mockTokenRepo) IsAccessTokenRevoked(_ context.Context, jti string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.revoked[jti]
+	return ok, nil
+}
+
+func (m *mockTokenRepo) DeleteExpired(_ context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	n := 0
+	for id, t := range m.refresh {
+		if t.ExpiresAt.Before(now) {
+			delete(m.refresh, id)
+			n++
+		}
+	}
+	for jti, exp := range m.revoked {
+		if exp.Before(now) {
+			delete(m.revoked, jti)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// --- Mock File Store ---
+
+type mockFileStore struct {
+	mu       sync.RWMutex
+	files    map[string][]byte
+	modTimes map[string]time.Time
+}
+
+func newMockFileStore() *mockFileStore {
+	return &mockFileStore{files: make(map[string][]byte), modTimes: make(map[string]time.Time)}
+}
+
+func (m *mockFileStore) Save(name string, reader io.Reader) (string, int64, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return "", 0, err
+	}
+	path := "/mock/storage/" + name
+	m.mu.Lock()
+	m.files[path] = data
+	m.modTimes[path] = time.Now()
+	m.mu.Unlock()
+	return path, int64(len(data)), nil
+}
+
+func (m *mockFileStore) Open(path string) (io.ReadSeekCloser, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	data, ok := m.files[path]
+	if !ok {
+		return nil, fmt.Errorf("open %s: %w", path, fs.ErrNotExist)
+	}
+	return nopSeekCloser{io.NewSectionReader(newBytesReaderAt(data), 0, int64(len(data)))}, nil
+}
+
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopSeekCloser) Close() error { return nil }
+
+func (m *mockFileStore) Delete(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.files, path)
+	delete(m.modTimes, path)
+	return nil
+}
+
+func (m *mockFileStore) Walk(fn func(path string, size int64, modTime time.Time) error) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for path, data := range m.files {
+		if err := fn(path, int64(len(data)), m.modTimes[path]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Helper: bytes.Reader implements io.ReaderAt
+type bytesReaderAt struct {
+	data []byte
+}
+
+func newBytesReaderAt(data []byte) *bytesReaderAt {
+	return &bytesReaderAt{data: data}
+}
+
+func (b *bytesReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(b.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, b.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Helper function
+func containsAll(haystack []string, needles []string) bool {
+	set := make(map[string]bool, len(haystack))
+	for _, s := range haystack {
+		set[s] = true
+	}
+	for _, n := range needles {
+		if !set[n] {
+			return false
+		}
+	}
+	return true
+}
+
+// --- Mock User Repository ---
+
+type mockUserRepo struct {
+	mu    sync.RWMutex
+	users map[uuid.UUID]*domain.User
+}
+
+func newMockUserRepo() *mockUserRepo {
+	return &mockUserRepo{users: make(map[uuid.UUID]*domain.User)}
+}
+
+func (m *mockUserRepo) Create(_ context.Context, u *domain.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.users {
+		if strings.EqualFold(existing.Email, u.Email) {
+			return domain.ErrConflict
+		}
+	}
+	u.ID = uuid.New()
+	u.CreatedAt = time.Now()
+	u.UpdatedAt = u.CreatedAt
+	m.users[u.ID] = u
+	return nil
+}
+
+func (m *mockUserRepo) GetByID(_ context.Context, id uuid.UUID) (*domain.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	u, ok := m.users[id]
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	cp := *u
+	return &cp, nil
+}
+
+func (m *mockUserRepo) GetByEmail(_ context.Context, email string) (*domain.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, u := range m.users {
+		if strings.EqualFold(u.Email, email) {
+			cp := *u
+			return &cp, nil
+		}
+	}
+	return nil, domain.ErrNotFound
+}
+
+func (m *mockUserRepo) List(_ context.Context) ([]*domain.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var result []*domain.User
+	for _, u := range m.users {
+		result = append(result, u)
+	}
+	return result, nil
+}
+
+func (m *mockUserRepo) Count(_ context.Context) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.users), nil
+}
+
+func (m *mockUserRepo) UpdateRole(_ context.Context, id uuid.UUID, role domain.Role) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok {
+		return domain.ErrNotFound
+	}
+	u.Role = role
+	return nil
+}
+
+func (m *mockUserRepo) UpdatePassword(_ context.Context, id uuid.UUID, passwordHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok {
+		return domain.ErrNotFound
+	}
+	u.PasswordHash = passwordHash
+	return nil
+}
+
+func (m *mockUserRepo) UpdateTOTP(_ context.Context, id uuid.UUID, secret string, enabled bool, hashes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok {
+		return domain.ErrNotFound
+	}
+	u.TOTPSecret = secret
+	u.TOTPEnabled = enabled
+	u.TOTPLastStep = 0
+	u.RecoveryCodeHashes = hashes
+	u.TOTPFailures, u.TOTPFailedAt = 0, nil
+	return nil
+}
+
+func (m *mockUserRepo) AdvanceTOTPStep(_ context.Context, id uuid.UUID, step int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok {
+		return false, domain.ErrNotFound
+	}
+	if step <= u.TOTPLastStep {
+		return false, nil
+	}
+	u.TOTPLastStep = step
+	return true, nil
+}
+
+func (m *mockUserRepo) ConsumeRecoveryCode(_ context.Context, id uuid.UUID, codeHash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok {
+		return false, domain.ErrNotFound
+	}
+	for i, h := range u.RecoveryCodeHashes {
+		if h == codeHash {
+			u.RecoveryCodeHashes = append(u.RecoveryCodeHashes[:i], u.RecoveryCodeHashes[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *mockUserRepo) RecordTOTPFailure(_ context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok {
+		return domain.ErrNotFound
+	}
+	now := time.Now()
+	u.TOTPFailures++
+	u.TOTPFailedAt = &now
+	return nil
+}
+
+func (m *mockUserRepo) ResetTOTPFailures(_ context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok {
+		return domain.ErrNotFound
+	}
+	u.TOTPFailures, u.TOTPFailedAt = 0, nil
+	return nil
+}
+
+func (m *mockUserRepo) Delete(_ context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[id]; !ok {
+		return domain.ErrNotFound
+	}
+	delete(m.users, id)
+	return nil
+}
+
+// --- Mock Settings Repository ---
+
+type mockSettingsRepo struct {
+	mu     sync.RWMutex
+	policy domain.SecurityPolicy
+}
+
+func (m *mockSettingsRepo) GetSecurityPolicy(_ context.Context) (*domain.SecurityPolicy, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	p := m.policy
+	return &p, nil
+}
+
+func (m *mockSettingsRepo) UpdateSecurityPolicy(_ context.Context, p *domain.SecurityPolicy) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.policy = *p
+	return nil
+}
+
+// --- Mock Organization Repository ---
+
+type mockOrganizationRepo struct {
+	mu      sync.RWMutex
+	orgs    map[uuid.UUID]*domain.Organization
+	members map[uuid.UUID]map[uuid.UUID]bool
+	users   *mockUserRepo
+}
+
+func newMockOrganizationRepo(users *mockUserRepo) *mockOrganizationRepo {
+	m := &mockOrganizationRepo{
+		orgs:    make(map[uuid.UUID]*domain.Organization),
+		members: make(map[uuid.UUID]map[uuid.UUID]bool),
+		users:   users,
+	}
+	m.orgs[domain.DefaultOrganizationID] = &domain.Organization{
+		ID:   domain.DefaultOrganizationID,
+		Name: "Default",
+		Slug: "default",
+	}
+	return m
+}
+
+func (m *mockOrganizationRepo) Create(_ context.Context, o *domain.Organization) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.orgs {
+		if existing.Slug == o.Slug {
+			return domain.ErrConflict
+		}
+	}
+	o.ID = uuid.New()
+	o.CreatedAt = time.Now()
+	o.UpdatedAt = o.CreatedAt
+	m.orgs[o.ID] = o
+	return nil
+}
+
+func (m *mockOrganizationRepo) GetByID(_ context.Context, id uuid.UUID) (*domain.Organization, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if o, ok := m.orgs[id]; ok {
+		return o, nil
+	}
+	return nil, domain.ErrNotFound
+}
+
+func (m *mockOrganizationRepo) GetByTenantTokenHash(_ context.Context, hash string) (*domain.Organization, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, o := range m.orgs {
+		if o.TenantTokenHash != "" && o.TenantTokenHash == hash {
+			return o, nil
+		}
+	}
+	return nil, domain.ErrNotFound
+}
+
+func (m *mockOrganizationRepo) List(_ context.Context) ([]*domain.Organization, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	result := []*domain.Organization{}
+	for _, o := range m.orgs {
+		result = append(result, o)
+	}
+	return result, nil
+}
+
+func (m *mockOrganizationRepo) ListForUser(_ context.Context, userID uuid.UUID) ([]*domain.Organization, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	result := []*domain.Organization{}
+	for id, o := range m.orgs {
+		if m.members[id][userID] {
+			result = append(result, o)
+		}
+	}
+	return result, nil
+}
+
+func (m *mockOrganizationRepo) UpdateTenantToken(_ context.Context, id uuid.UUID, tokenHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, ok := m.orgs[id]
+	if !ok {
+		return domain.ErrNotFound
+	}
+	o.TenantTokenHash = tokenHash
+	return nil
+}
+
+func (m *mockOrganizationRepo) AddMember(ctx context.Context, orgID, userID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.orgs[orgID]; !ok {
+		return domain.ErrNotFound
+	}
+	if _, err := m.users.GetByID(ctx, userID); err != nil {
+		return err
+	}
+	if m.members[orgID] == nil {
+		m.members[orgID] = make(map[uuid.UUID]bool)
+	}
+	m.members[orgID][userID] = true
+	return nil
+}
+
+func (m *mockOrganizationRepo) RemoveMember(_ context.Context, orgID, userID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.members[orgID][userID] {
+		return domain.ErrNotFound
+	}
+	delete(m.members[orgID], userID)
+	return nil
+}
+
+func (m *mockOrganizationRepo) ListMembers(ctx context.Context, orgID uuid.UUID) ([]*domain.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	result := []*domain.User{}
+	for userID := range m.members[orgID] {
+		if u, err := m.users.GetByID(ctx, userID); err == nil {
+			result = append(result, u)
+		}
+	}
+	return result, nil
+}
+
+func (m *mockOrganizationRepo) IsMember(_ context.Context, orgID, userID uuid.UUID) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.members[orgID][userID], nil
+}
+
+// --- Mock Signing Key Repository ---
+
+type mockSigningKeyRepo struct {
+	mu   sync.RWMutex
+	keys map[uuid.UUID]*domain.SigningKey
+}
+
+func newMockSigningKeyRepo() *mockSigningKeyRepo {
+	return &mockSigningKeyRepo{keys: make(map[uuid.UUID]*domain.SigningKey)}
+}
+
+func (m *mockSigningKeyRepo) Create(_ context.Context, k *domain.SigningKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.keys {
+		if existing.OrgID == k.OrgID && existing.KeyID == k.KeyID {
+			return domain.ErrConflict
+		}
+	}
+	k.ID = uuid.New()
+	k.CreatedAt = time.Now()
+	m.keys[k.ID] = k
+	return nil
+}
+
+func (m *mockSigningKeyRepo) GetByKeyID(_ context.Context, orgID uuid.UUID, keyID string) (*domain.SigningKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, k := range m.keys {
+		if k.OrgID == orgID && k.KeyID == keyID {
+			return k, nil
+		}
+	}
+	return nil, domain.ErrNotFound
+}
+
+func (m *mockSigningKeyRepo) List(_ context.Context, orgID uuid.UUID) ([]*domain.SigningKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	result := []*domain.SigningKey{}
+	for _, k := range m.keys {
+		if k.OrgID == orgID {
+			result = append(result, k)
+		}
+	}
+	return result, nil
+}
+
+func (m *mockSigningKeyRepo) Revoke(_ context.Context, orgID, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k, ok := m.keys[id]
+	if !ok || k.OrgID != orgID || k.RevokedAt != nil {
+		return domain.ErrNotFound
+	}
+	now := time.Now()
+	k.RevokedAt = &now
+	return nil
+}
+
+// --- Mock Artifact Delta Repository ---
+
+type mockArtifactDeltaRepo struct {
+	mu     sync.RWMutex
+	deltas map[uuid.UUID]*domain.ArtifactDelta
+}
+
+func newMockArtifactDeltaRepo() *mockArtifactDeltaRepo {
+	return &mockArtifactDeltaRepo{deltas: make(map[uuid.UUID]*domain.ArtifactDelta)}
+}
+
+func (m *mockArtifactDeltaRepo) Create(_ context.Context, d *domain.ArtifactDelta) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.deltas {
+		if existing.ArtifactID == d.ArtifactID && existing.SourceArtifactID == d.SourceArtifactID {
+			return domain.ErrConflict
+		}
+	}
+	d.ID = uuid.New()
+	d.CreatedAt = time.Now()
+	m.deltas[d.ID] = d
+	return nil
+}
+
+func (m *mockArtifactDeltaRepo) Get(_ context.Context, orgID, artifactID, sourceArtifactID uuid.UUID) (*domain.ArtifactDelta, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, d := range m.deltas {
+		if d.OrgID == orgID && d.ArtifactID == artifactID && d.SourceArtifactID == sourceArtifactID {
+			return d, nil
+		}
+	}
+	return nil, domain.ErrNotFound
+}
+
+func (m *mockArtifactDeltaRepo) ListByArtifact(_ context.Context, orgID, artifactID uuid.UUID) ([]*domain.ArtifactDelta, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	result := []*domain.ArtifactDelta{}
+	for _, d := range m.deltas {
+		if d.OrgID == orgID && (d.ArtifactID == artifactID || d.SourceArtifactID == artifactID) {
+			result = append(result, d)
+		}
+	}
+	return result, nil
+}
+
+// --- Mock Upload Session Repository ---
+
+type mockUploadSessionRepo struct {
+	mu       sync.RWMutex
+	sessions map[uuid.UUID]*domain.UploadSession
+	chunks   map[uuid.UUID][]*domain.UploadChunk
+}
+
+func newMockUploadSessionRepo() *mockUploadSessionRepo {
+	return &mockUploadSessionRepo{
+		sessions: make(map[uuid.UUID]*domain.UploadSession),
+		chunks:   make(map[uuid.UUID][]*domain.UploadChunk),
+	}
+}
+
+func (m *mockUploadSessionRepo) Create(_ context.Context, s *domain.UploadSession) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s.ID = uuid.New()
+	s.CreatedAt = time.Now()
+	s.UpdatedAt = s.CreatedAt
+	m.sessions[s.ID] = s
+	return nil
+}
+
+func (m *mockUploadSessionRepo) GetByID(_ context.Context, orgID, id uuid.UUID) (*domain.UploadSession, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.sessions[id]
+	if !ok || s.OrgID != orgID {
+		return nil, domain.ErrNotFound
+	}
+	cp := *s
+	return &cp, nil
+}
+
+func (m *mockUploadSessionRepo) AddChunk(_ context.Context, orgID uuid.UUID, c *domain.UploadChunk, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[c.SessionID]
+	if !ok || s.OrgID != orgID || s.Offset != c.Offset || s.Offset+c.Size > s.Size {
+		return domain.ErrConflict
+	}
+	s.Offset += c.Size
+	s.ExpiresAt = expiresAt
+	s.UpdatedAt = time.Now()
+	m.chunks[s.ID] = append(m.chunks[s.ID], c)
+	return nil
+}
+
+func (m *mockUploadSessionRepo) ListChunks(_ context.Context, sessionID uuid.UUID) ([]*domain.UploadChunk, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]*domain.UploadChunk{}, m.chunks[sessionID]...), nil
+}
+
+func (m *mockUploadSessionRepo) Delete(_ context.Context, orgID, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok || s.OrgID != orgID {
+		return domain.ErrNotFound
+	}
+	delete(m.sessions, id)
+	delete(m.chunks, id)
+	return nil
+}
+
+func (m *mockUploadSessionRepo) ReservedAfter(_ context.Context, session *domain.UploadSession) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var total int64
+	for _, s := range m.sessions {
+		if s.CreatedAt.After(session.CreatedAt) ||
+			s.CreatedAt.Equal(session.CreatedAt) && bytes.Compare(s.ID[:], session.ID[:]) > 0 {
+			total += s.Size
+		}
+	}
+	return total, nil
+}
+
+// reserved returns the declared size of every session.
+func (m *mockUploadSessionRepo) reserved() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var total int64
+	for _, s := range m.sessions {
+		total += s.Size
+	}
+	return total
+}
+
+func (m *mockUploadSessionRepo) ListExpired(_ context.Context, t time.Time) ([]*domain.UploadSession, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	result := []*domain.UploadSession{}
+	for _, s := range m.sessions {
+		if s.ExpiresAt.Before(t) {
+			result = append(result, s)
+		}
+	}
+	return result, nil
+}
+
+// presigningFileStore is a mockFileStore that also hands out direct URLs.
+type presigningFileStore struct {
+	*mockFileStore
+}
+
+func (p presigningFileStore) PresignGet(path, fileName string) (string, time.Time, error) {
+	return "https://objects.example.com" + path + "?name=" + fileName, time.Now().Add(time.Minute), nil
+}
+
+// --- Mock Blob Repository ---
+
+type mockBlobRepo struct {
+	mu    sync.Mutex
+	blobs map[string]*domain.Blob
+	// sessions, when set, reserve their declared size
+	sessions *mockUploadSessionRepo
+}
+
+func newMockBlobRepo() *mockBlobRepo {
+	return &mockBlobRepo{blobs: make(map[string]*domain.Blob)}
+}
+
+func blobKey(orgID uuid.UUID, checksum string) string {
+	return orgID.String() + "/" + checksum
+}
+
+func (m *mockBlobRepo) Acquire(_ context.Context, b *domain.Blob) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if existing, ok := m.blobs[blobKey(b.OrgID, b.ChecksumSHA256)]; ok {
+		existing.RefCount++
+		*b = *existing
+		return false, nil
+	}
+	b.RefCount = 1
+	b.CreatedAt = time.Now()
+	stored := *b
+	m.blobs[blobKey(b.OrgID, b.ChecksumSHA256)] = &stored
+	return true, nil
+}
+
+func (m *mockBlobRepo) AddRef(_ context.Context, orgID uuid.UUID, checksum string) (*domain.Blob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.blobs[blobKey(orgID, checksum)]
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	b.RefCount++
+	cp := *b
+	return &cp, nil
+}
+
+func (m *mockBlobRepo) Release(_ context.Context, orgID uuid.UUID, checksum string) (*domain.Blob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.blobs[blobKey(orgID, checksum)]
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	b.RefCount--
+	if b.RefCount > 0 {
+		return nil, nil
+	}
+	delete(m.blobs, blobKey(orgID, checksum))
+	return b, nil
+}
+
+func (m *mockBlobRepo) ListAll(_ context.Context) ([]*domain.Blob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := []*domain.Blob{}
+	for _, b := range m.blobs {
+		cp := *b
+		result = append(result, &cp)
+	}
+	return result, nil
+}
+
+func (m *mockBlobRepo) StoredSize(_ context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var total int64
+	for _, b := range m.blobs {
+		total += b.Size
+	}
+	if m.sessions != nil {
+		total += m.sessions.reserved()
+	}
+	return total, nil
+}
+
+// --- Mock Scrub Repository ---
+
+type mockScrubRepo struct {
+	mu      sync.Mutex
+	reports []*domain.ScrubReport
+	// paths referenced by records other than blobs, such as deltas
+	paths []string
+	blobs *mockBlobRepo
+}
+
+func newMockScrubRepo(blobs *mockBlobRepo) *mockScrubRepo {
+	return &mockScrubRepo{blobs: blobs}
+}
+
+func (m *mockScrubRepo) CreateReport(_ context.Context, r *domain.ScrubReport) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r.ID = uuid.New()
+	m.reports = append(m.reports, r)
+	return nil
+}
+
+func (m *mockScrubRepo) LatestReport(_ context.Context) (*domain.ScrubReport, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.reports) == 0 {
+		return nil, domain.ErrNotFound
+	}
+	return m.reports[len(m.reports)-1], nil
+}
+
+func (m *mockScrubRepo) ReferencedPaths(ctx context.Context) ([]string, error) {
+	blobs, _ := m.blobs.ListAll(ctx)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	paths := append([]string(nil), m.paths...)
+	for _, b := range blobs {
+		paths = append(paths, b.StoragePath)
+	}
+	return paths, nil
+}
+
+// --- Mock Channel Repository ---
+
+type mockChannelRepo struct {
+	mu         sync.Mutex
+	channels   map[uuid.UUID]*domain.Channel
+	promotions []*domain.ChannelPromotion
+}
+
+func newMockChannelRepo() *mockChannelRepo {
+	return &mockChannelRepo{channels: make(map[uuid.UUID]*domain.Channel)}
+}
+
+func (m *mockChannelRepo) Create(_ context.Context, c *domain.Channel) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.channels {
+		if existing.OrgID == c.OrgID && existing.Name == c.Name {
+			return domain.ErrConflict
+		}
+	}
+	c.ID = uuid.New()
+	c.CreatedAt, c.UpdatedAt = time.Now(), time.Now()
+	m.channels[c.ID] = c
+	return nil
+}
+
+func (m *mockChannelRepo) GetByID(_ context.Context, orgID, id uuid.UUID) (*domain.Channel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.channels[id]
+	if !ok || c.OrgID != orgID {
+		return nil, domain.ErrNotFound
+	}
+	copied := *c
+	return &copied, nil
+}
+
+func (m *mockChannelRepo) List(_ context.Context, orgID uuid.UUID) ([]*domain.Channel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := []*domain.Channel{}
+	for _, c := range m.channels {
+		if c.OrgID == orgID {
+			result = append(result, c)
+		}
+	}
+	slices.SortFunc(result, func(a, b *domain.Channel) int { return strings.Compare(a.Name, b.Name) })
+	return result, nil
+}
+
+func (m *mockChannelRepo) Delete(_ context.Context, orgID, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c, ok := m.channels[id]; !ok || c.OrgID != orgID {
+		return domain.ErrNotFound
+	}
+	delete(m.channels, id)
+	for _, c := range m.channels {
+		if c.UpstreamID != nil && *c.UpstreamID == id {
+			c.UpstreamID = nil
+		}
+	}
+	return nil
+}
+
+func (m *mockChannelRepo) CreatePromotion(_ context.Context, p *domain.ChannelPromotion) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.channels[p.ChannelID]
+	if !ok || c.OrgID != p.OrgID {
+		return domain.ErrNotFound
+	}
+	artifactID := p.ArtifactID
+	c.ArtifactID, c.DeploymentID = &artifactID, p.DeploymentID
+	p.ID = uuid.New()
+	p.CreatedAt = time.Now()
+	m.promotions = append(m.promotions, p)
+	return nil
+}
+
+func (m *mockChannelRepo) ListPromotions(_ context.Context, orgID, channelID uuid.UUID) ([]*domain.ChannelPromotion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := []*domain.ChannelPromotion{}
+	for i := len(m.promotions) - 1; i >= 0; i-- {
+		if p := m.promotions[i]; p.OrgID == orgID && p.ChannelID == channelID {
+			result = append(result, p)
+		}
+	}
+	return result, nil
+}
+
+func (m *mockChannelRepo) HasPromotion(_ context.Context, orgID, channelID, artifactID uuid.UUID) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, p := range m.promotions {
+		if p.OrgID == orgID && p.ChannelID == channelID && p.ArtifactID == artifactID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// --- Mock Audit Repository ---
+
+type mockAuditRepo struct {
+	mu      sync.Mutex
+	entries []*domain.AuditEntry
+}
+
+func (m *mockAuditRepo) Create(_ context.Context, e *domain.AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e.ID = uuid.New()
+	m.entries = append(m.entries, e)
+	return nil
+}
+
+func (m *mockAuditRepo) List(_ context.Context, orgID uuid.UUID, _ domain.AuditFilter) ([]*domain.AuditEntry, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var result []*domain.AuditEntry
+	for _, e := range m.entries {
+		if (e.OrgID == nil && orgID == uuid.Nil) || (e.OrgID != nil && *e.OrgID == orgID) {
+			result = append(result, e)
+		}
+	}
+	return result, len(result), nil
+}
+
+// --- Mock Manifest Repository ---
+
+type mockManifestRepo struct {
+	mu        sync.Mutex
+	manifests map[uuid.UUID]*domain.Manifest
+}
+
+func newMockManifestRepo() *mockManifestRepo {
+	return &mockManifestRepo{manifests: make(map[uuid.UUID]*domain.Manifest)}
+}
+
+func (m *mockManifestRepo) Create(_ context.Context, manifest *domain.Manifest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.manifests {
+		if existing.OrgID == manifest.OrgID && existing.Name == manifest.Name {
+			return domain.ErrConflict
+		}
+	}
+	manifest.ID = uuid.New()
+	manifest.CreatedAt, manifest.UpdatedAt = time.Now(), time.Now()
+	copied := *manifest
+	copied.Items = slices.Clone(manifest.Items)
+	m.manifests[manifest.ID] = &copied
+	return nil
+}
+
+func (m *mockManifestRepo) GetByID(_ context.Context, orgID, id uuid.UUID) (*domain.Manifest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	manifest, ok := m.manifests[id]
+	if !ok || manifest.OrgID != orgID {
+		return nil, domain.ErrNotFound
+	}
+	copied := *manifest
+	copied.Items = slices.Clone(manifest.Items)
+	return &copied, nil
+}
+
+func (m *mockManifestRepo) List(_ context.Context, orgID uuid.UUID) ([]*domain.Manifest, error) {
+	return m.list(orgID, func(*domain.Manifest) bool { return true }), nil
+}
+
+func (m *mockManifestRepo) ListByTags(_ context.Context, orgID uuid.UUID, tags []string) ([]*domain.Manifest, error) {
+	return m.list(orgID, func(manifest *domain.Manifest) bool { return containsAll(tags, manifest.DeviceTags) }), nil
+}
+
+func (m *mockManifestRepo) list(orgID uuid.UUID, match func(*domain.Manifest) bool) []*domain.Manifest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := []*domain.Manifest{}
+	for _, manifest := range m.manifests {
+		if manifest.OrgID != orgID || !match(manifest) {
+			continue
+		}
+		copied := *manifest
+		copied.Items = slices.Clone(manifest.Items)
+		result = append(result, &copied)
+	}
+	slices.SortFunc(result, func(a, b *domain.Manifest) int { return strings.Compare(a.Name, b.Name) })
+	return result
+}
+
+func (m *mockManifestRepo) SetItems(_ context.Context, orgID, id uuid.UUID, items []domain.ManifestItem) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	manifest, ok := m.manifests[id]
+	if !ok || manifest.OrgID != orgID {
+		return domain.ErrNotFound
+	}
+	manifest.Items = slices.Clone(items)
+	manifest.UpdatedAt = time.Now()
+	return nil
+}
+
+func (m *mockManifestRepo) Delete(_ context.Context, orgID, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if manifest, ok := m.manifests[id]; !ok || manifest.OrgID != orgID {
+		return domain.ErrNotFound
+	}
+	delete(m.manifests, id)
+	return nil
 }
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/CaioWing/Harbor/internal/auth"
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,99}$`)
+
+type OrganizationService struct {
+	repo domain.OrganizationRepository
+	log  *slog.Logger
+}
+
+func NewOrganizationService(repo domain.OrganizationRepository, log *slog.Logger) *OrganizationService {
+	return &OrganizationService{repo: repo, log: log}
+}
+
+type CreateOrganizationInput struct {
+	Name string
+	Slug string
+}
+
+// Create stores a new organization and returns it together with its tenant
+// token. Only the token's hash is persisted, so it cannot be shown again.
+func (s *OrganizationService) Create(ctx context.Context, input CreateOrganizationInput) (*domain.Organization, string, error) {
+	input.Name = strings.TrimSpace(input.Name)
+	input.Slug = strings.ToLower(strings.TrimSpace(input.Slug))
+	if input.Name == "" {
+		return nil, "", fmt.Errorf("%w: name is required", domain.ErrInvalidInput)
+	}
+	if !slugPattern.MatchString(input.Slug) {
+		return nil, "", fmt.Errorf("%w: slug must contain only lowercase letters, digits and dashes", domain.ErrInvalidInput)
+	}
+
+	token, hash, err := auth.GenerateTenantToken()
+	if err != nil {
+		return nil, "", fmt.Errorf("generate tenant token: %w", err)
+	}
+
+	org := &domain.Organization{
+		Name:            input.Name,
+		Slug:            input.Slug,
+		TenantTokenHash: hash,
+	}
+	if err := s.repo.Create(ctx, org); err != nil {
+		return nil, "", fmt.Errorf("create organization: %w", err)
+	}
+
+	s.log.Info("organization created", "id", org.ID, "slug", org.Slug)
+	return org, token, nil
+}
+
+func (s *OrganizationService) GetByID(ctx context.Context, id uuid.UUID) (*domain.Organization, error) {
+	return s.repo.GetByID(ctx, id)
+}
+
+// ListForUser returns the organizations the user can access. Admins can
+// access every organization.
+func (s *OrganizationService) ListForUser(ctx context.Context, userID uuid.UUID, role domain.Role) ([]*domain.Organization, error) {
+	if role == domain.RoleAdmin {
+		return s.repo.List(ctx)
+	}
+	return s.repo.ListForUser(ctx, userID)
+}
+
+// Resolve returns the organization if the user may act in it. Non-admin
+// users must be members.
+func (s *OrganizationService) Resolve(ctx context.Context, userID uuid.UUID, role domain.Role, orgID uuid.UUID) (*domain.Organization, error) {
+	org, err := s.repo.GetByID(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	if role == domain.RoleAdmin {
+		return org, nil
+	}
+
+	member, err := s.repo.IsMember(ctx, orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !member {
+		return nil, domain.ErrForbidden
+	}
+	return org, nil
+}
+
+// RotateTenantToken replaces the organization's tenant token. Devices that
+// already have an auth token keep working; only new enrolments need the new
+// token.
+func (s *OrganizationService) RotateTenantToken(ctx context.Context, id uuid.UUID) (string, error) {
+	token, hash, err := auth.GenerateTenantToken()
+	if err != nil {
+		return "", fmt.Errorf("generate tenant token: %w", err)
+	}
+	if err := s.repo.UpdateTenantToken(ctx, id, hash); err != nil {
+		return "", err
+	}
+
+	s.log.Info("tenant token rotated", "organization", id)
+	return token, nil
+}
+
+func (s *OrganizationService) AddMember(ctx context.Context, orgID, userID uuid.UUID) error {
+	return s.repo.AddMember(ctx, orgID, userID)
+}
+
+func (s *OrganizationService) RemoveMember(ctx context.Context, orgID, userID uuid.UUID) error {
+	return s.repo.RemoveMember(ctx, orgID, userID)
+}
+
+func (s *OrganizationService) ListMembers(ctx context.Context, orgID uuid.UUID) ([]*domain.User, error) {
+	if _, err := s.repo.GetByID(ctx, orgID); err != nil {
+		return nil, err
+	}
+	return s.repo.ListMembers(ctx, orgID)
+}
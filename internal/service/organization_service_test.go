@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/CaioWing/Harbor/internal/auth"
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+func newTestOrganizationService() (*OrganizationService, *mockOrganizationRepo, *mockUserRepo) {
+	users := newMockUserRepo()
+	repo := newMockOrganizationRepo(users)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return NewOrganizationService(repo, log), repo, users
+}
+
+func createTestUser(t *testing.T, users *mockUserRepo, email string, role domain.Role) *domain.User {
+	t.Helper()
+	u := &domain.User{Email: email, PasswordHash: "x", Role: role}
+	if err := users.Create(context.Background(), u); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return u
+}
+
+func TestOrganizationCreate_ReturnsTenantToken(t *testing.T) {
+	svc, repo, _ := newTestOrganizationService()
+	ctx := context.Background()
+
+	org, token, err := svc.Create(ctx, CreateOrganizationInput{Name: "Acme", Slug: "Acme"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if org.Slug != "acme" {
+		t.Fatalf("expected slug to be lowercased, got %s", org.Slug)
+	}
+	if token == "" || org.TenantTokenHash == token {
+		t.Fatal("expected a token distinct from the stored hash")
+	}
+
+	found, err := repo.GetByTenantTokenHash(ctx, auth.HashToken(token))
+	if err != nil || found.ID != org.ID {
+		t.Fatalf("tenant token does not resolve to the organization: %v", err)
+	}
+}
+
+func TestOrganizationCreate_InvalidSlug(t *testing.T) {
+	svc, _, _ := newTestOrganizationService()
+
+	_, _, err := svc.Create(context.Background(), CreateOrganizationInput{Name: "Acme", Slug: "acme corp"})
+	if !errors.Is(err, domain.ErrInvalidInput) {
+		t.Fatalf("expected ErrInvalidInput, got %v", err)
+	}
+}
+
+func TestOrganizationCreate_DuplicateSlug(t *testing.T) {
+	svc, _, _ := newTestOrganizationService()
+	ctx := context.Background()
+
+	svc.Create(ctx, CreateOrganizationInput{Name: "Acme", Slug: "acme"})
+	_, _, err := svc.Create(ctx, CreateOrganizationInput{Name: "Acme 2", Slug: "acme"})
+	if !errors.Is(err, domain.ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+}
+
+func TestOrganizationResolve(t *testing.T) {
+	svc, _, users := newTestOrganizationService()
+	ctx := context.Background()
+
+	org, _, _ := svc.Create(ctx, CreateOrganizationInput{Name: "Acme", Slug: "acme"})
+	admin := createTestUser(t, users, "admin@example.com", domain.RoleAdmin)
+	member := createTestUser(t, users, "member@example.com", domain.RoleOperator)
+	outsider := createTestUser(t, users, "outsider@example.com", domain.RoleOperator)
+
+	if err := svc.AddMember(ctx, org.ID, member.ID); err != nil {
+		t.Fatalf("add member: %v", err)
+	}
+
+	if _, err := svc.Resolve(ctx, admin.ID, admin.Role, org.ID); err != nil {
+		t.Fatalf("admin should access every organization: %v", err)
+	}
+	if _, err := svc.Resolve(ctx, member.ID, member.Role, org.ID); err != nil {
+		t.Fatalf("member should access the organization: %v", err)
+	}
+	if _, err := svc.Resolve(ctx, outsider.ID, outsider.Role, org.ID); !errors.Is(err, domain.ErrForbidden) {
+		t.Fatalf("expected ErrForbidden for non-member, got %v", err)
+	}
+	if _, err := svc.Resolve(ctx, admin.ID, admin.Role, uuid.New()); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for unknown organization, got %v", err)
+	}
+}
+
+func TestOrganizationListForUser(t *testing.T) {
+	svc, _, users := newTestOrganizationService()
+	ctx := context.Background()
+
+	org, _, _ := svc.Create(ctx, CreateOrganizationInput{Name: "Acme", Slug: "acme"})
+	admin := createTestUser(t, users, "admin@example.com", domain.RoleAdmin)
+	member := createTestUser(t, users, "member@example.com", domain.RoleViewer)
+	svc.AddMember(ctx, org.ID, member.ID)
+
+	all, _ := svc.ListForUser(ctx, admin.ID, admin.Role)
+	if len(all) != 2 {
+		t.Fatalf("expected admin to see 2 organizations, got %d", len(all))
+	}
+
+	mine, _ := svc.ListForUser(ctx, member.ID, member.Role)
+	if len(mine) != 1 || mine[0].ID != org.ID {
+		t.Fatalf("expected member to see only their organization, got %d", len(mine))
+	}
+}
+
+func TestOrganizationRotateTenantToken(t *testing.T) {
+	svc, repo, _ := newTestOrganizationService()
+	ctx := context.Background()
+
+	org, oldToken, _ := svc.Create(ctx, CreateOrganizationInput{Name: "Acme", Slug: "acme"})
+
+	newToken, err := svc.RotateTenantToken(ctx, org.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := repo.GetByTenantTokenHash(ctx, auth.HashToken(oldToken)); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatal("old tenant token should no longer resolve")
+	}
+	if _, err := repo.GetByTenantTokenHash(ctx, auth.HashToken(newToken)); err != nil {
+		t.Fatalf("new tenant token should resolve: %v", err)
+	}
+
+	if _, err := svc.RotateTenantToken(ctx, uuid.New()); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestOrganizationAddMember_UnknownUser(t *testing.T) {
+	svc, _, _ := newTestOrganizationService()
+
+	err := svc.AddMember(context.Background(), domain.DefaultOrganizationID, uuid.New())
+	if !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,286 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/CaioWing/Harbor/internal/domain"
+	"github.com/CaioWing/Harbor/internal/storage"
+)
+
+// orphanGracePeriod keeps files written shortly before a scrub out of the
+// orphan list, since an upload saves its file before the record exists.
+const orphanGracePeriod = time.Hour
+
+// ScrubService verifies stored files against the database. Every blob is
+// re-hashed; artifacts whose file is missing or corrupted are quarantined
+// so that devices no longer receive them, and released again once their
+// file verifies. Files no record points to are reported. Nothing is deleted.
+type ScrubService struct {
+	blobs   domain.BlobRepository
+	artRepo domain.ArtifactRepository
+	reports domain.ScrubRepository
+	store   storage.FileStore
+	log     *slog.Logger
+	running atomic.Bool
+}
+
+func NewScrubService(
+	blobs domain.BlobRepository,
+	artRepo domain.ArtifactRepository,
+	reports domain.ScrubRepository,
+	store storage.FileStore,
+	log *slog.Logger,
+) *ScrubService {
+	return &ScrubService{
+		blobs:   blobs,
+		artRepo: artRepo,
+		reports: reports,
+		store:   store,
+		log:     log,
+	}
+}
+
+// StartScheduler runs a scrub at the specified interval. Call in a goroutine.
+func (s *ScrubService) StartScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	s.log.Info("scrub scheduler started", "interval", interval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.log.Info("scrub scheduler stopped")
+			return
+		case <-ticker.C:
+			if _, err := s.Run(ctx); err != nil && !errors.Is(err, domain.ErrConflict) {
+				s.log.Warn("scrub failed", "err", err)
+			}
+		}
+	}
+}
+
+// Start runs a scrub in the background. It fails with ErrConflict while
+// another scrub is running.
+func (s *ScrubService) Start(ctx context.Context) error {
+	if !s.running.CompareAndSwap(false, true) {
+		return fmt.Errorf("%w: a scrub is already running", domain.ErrConflict)
+	}
+	// The scrub outlives the request that started it
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer s.running.Store(false)
+		if _, err := s.scrub(ctx); err != nil {
+			s.log.Warn("scrub failed", "err", err)
+		}
+	}()
+	return nil
+}
+
+// Running reports whether a scrub is in progress.
+func (s *ScrubService) Running() bool {
+	return s.running.Load()
+}
+
+// Run scrubs the storage and returns the stored report. It fails with
+// ErrConflict while another scrub is running.
+func (s *ScrubService) Run(ctx context.Context) (*domain.ScrubReport, error) {
+	if !s.running.CompareAndSwap(false, true) {
+		return nil, fmt.Errorf("%w: a scrub is already running", domain.ErrConflict)
+	}
+	defer s.running.Store(false)
+	return s.scrub(ctx)
+}
+
+func (s *ScrubService) LatestReport(ctx context.Context) (*domain.ScrubReport, error) {
+	return s.reports.LatestReport(ctx)
+}
+
+func (s *ScrubService) scrub(ctx context.Context) (*domain.ScrubReport, error) {
+	s.log.Info("running storage scrub")
+	report := &domain.ScrubReport{StartedAt: time.Now(), Findings: []domain.ScrubFinding{}}
+
+	blobs, err := s.blobs.ListAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list blobs: %w", err)
+	}
+	// Every blob is hashed before any quarantine changes, so that a bundle
+	// is not released for a healthy file while another of its files is
+	// broken.
+	var healthy []*domain.Blob
+	var broken []*domain.ScrubFinding
+	brokenByOrg := make(map[uuid.UUID][]string)
+	for _, b := range blobs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		finding := s.checkBlob(b, report)
+		switch {
+		case finding == nil:
+			healthy = append(healthy, b)
+		case finding.Kind == domain.ScrubUnreadable:
+			// A store that cannot be reached must not quarantine anything
+			report.Findings = append(report.Findings, *finding)
+		default:
+			broken = append(broken, finding)
+			brokenByOrg[b.OrgID] = append(brokenByOrg[b.OrgID], b.ChecksumSHA256)
+		}
+	}
+	for _, f := range broken {
+		s.quarantine(ctx, f)
+		report.Findings = append(report.Findings, *f)
+	}
+	for _, b := range healthy {
+		if finding := s.release(ctx, b, brokenByOrg[b.OrgID]); finding != nil {
+			report.Findings = append(report.Findings, *finding)
+		}
+	}
+
+	orphans, listed, err := s.findOrphans(ctx, report.StartedAt.Add(-orphanGracePeriod))
+	if err != nil {
+		// The blob results are still worth keeping
+		s.log.Warn("scrub: failed to list storage", "err", err)
+	}
+	report.FilesListed = listed
+	report.Findings = append(report.Findings, orphans...)
+
+	report.FinishedAt = time.Now()
+	if err := s.reports.CreateReport(ctx, report); err != nil {
+		return nil, fmt.Errorf("save report: %w", err)
+	}
+
+	s.log.Info("storage scrub completed",
+		"blobs", report.BlobsChecked, "bytes", report.BytesChecked,
+		"findings", len(report.Findings), "duration", report.FinishedAt.Sub(report.StartedAt))
+	return report, nil
+}
+
+// checkBlob re-hashes one blob. It returns nil for a healthy blob.
+func (s *ScrubService) checkBlob(b *domain.Blob, report *domain.ScrubReport) *domain.ScrubFinding {
+	report.BlobsChecked++
+	orgID := b.OrgID
+	finding := &domain.ScrubFinding{
+		OrgID:          &orgID,
+		ChecksumSHA256: b.ChecksumSHA256,
+		StoragePath:    b.StoragePath,
+		Size:           b.Size,
+	}
+
+	actual, size, err := s.hashFile(b.StoragePath)
+	report.BytesChecked += size
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		finding.Kind = domain.ScrubMissingFile
+		finding.Detail = "file not found in storage"
+	case err != nil:
+		finding.Kind = domain.ScrubUnreadable
+		finding.Detail = err.Error()
+	case actual != b.ChecksumSHA256 || size != b.Size:
+		finding.Kind = domain.ScrubChecksumMismatch
+		finding.ActualChecksum = actual
+		finding.Detail = fmt.Sprintf("read %d of %d bytes", size, b.Size)
+	default:
+		return nil
+	}
+	return finding
+}
+
+// quarantine quarantines the artifacts of a broken blob.
+func (s *ScrubService) quarantine(ctx context.Context, finding *domain.ScrubFinding) {
+	ids, err := s.artRepo.SetQuarantine(ctx, *finding.OrgID, finding.ChecksumSHA256, string(finding.Kind))
+	if err != nil {
+		s.log.Warn("scrub: failed to quarantine artifacts", "checksum", finding.ChecksumSHA256, "err", err)
+	}
+	finding.ArtifactIDs = ids
+	s.log.Warn("scrub: quarantined artifacts",
+		"organization", *finding.OrgID, "checksum", finding.ChecksumSHA256, "reason", finding.Kind, "artifacts", len(ids))
+}
+
+// release releases the artifacts of a healthy blob from quarantine, except
+// bundles holding one of the broken checksums. It returns nil when nothing
+// was released.
+func (s *ScrubService) release(ctx context.Context, b *domain.Blob, broken []string) *domain.ScrubFinding {
+	ids, err := s.artRepo.ReleaseQuarantine(ctx, b.OrgID, b.ChecksumSHA256, broken)
+	if err != nil {
+		s.log.Warn("scrub: failed to release quarantine", "checksum", b.ChecksumSHA256, "err", err)
+		return nil
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	s.log.Info("scrub: released artifacts from quarantine", "checksum", b.ChecksumSHA256, "artifacts", len(ids))
+	orgID := b.OrgID
+	return &domain.ScrubFinding{
+		Kind:           domain.ScrubRestored,
+		OrgID:          &orgID,
+		ChecksumSHA256: b.ChecksumSHA256,
+		StoragePath:    b.StoragePath,
+		Size:           b.Size,
+		ArtifactIDs:    ids,
+	}
+}
+
+func (s *ScrubService) hashFile(path string) (string, int64, error) {
+	reader, err := s.store.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer reader.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, reader)
+	if err != nil {
+		return "", n, fmt.Errorf("read file: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// findOrphans lists the store for files that no record points to and that
+// were last modified before cutoff. Stores that cannot be listed are
+// skipped.
+func (s *ScrubService) findOrphans(ctx context.Context, cutoff time.Time) ([]domain.ScrubFinding, int, error) {
+	lister, ok := s.store.(storage.Lister)
+	if !ok {
+		return nil, 0, nil
+	}
+
+	paths, err := s.reports.ReferencedPaths(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("list referenced paths: %w", err)
+	}
+	referenced := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		referenced[p] = true
+	}
+
+	var orphans []domain.ScrubFinding
+	listed := 0
+	err = lister.Walk(func(path string, size int64, modTime time.Time) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		listed++
+		if referenced[path] || modTime.After(cutoff) {
+			return nil
+		}
+		orphans = append(orphans, domain.ScrubFinding{
+			Kind:        domain.ScrubOrphanFile,
+			StoragePath: path,
+			Size:        size,
+			Detail:      "last modified " + modTime.UTC().Format(time.RFC3339),
+		})
+		return nil
+	})
+	return orphans, listed, err
+}
@@ -0,0 +1,265 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CaioWing/Harbor/internal/archive"
+	"github.com/CaioWing/Harbor/internal/domain"
+	"github.com/CaioWing/Harbor/internal/storage"
+)
+
+type scrubTestEnv struct {
+	svc       *ScrubService
+	artifacts *ArtifactService
+	artRepo   *mockArtifactRepo
+	scrubRepo *mockScrubRepo
+	store     *mockFileStore
+}
+
+func newTestScrubService() *scrubTestEnv {
+	return newTestScrubServiceWith(nil)
+}
+
+// newTestScrubServiceWith scrubs through wrap(store) when wrap is set, to
+// simulate a failing store.
+func newTestScrubServiceWith(wrap func(*mockFileStore) storage.FileStore) *scrubTestEnv {
+	artRepo := newMockArtifactRepo()
+	blobs := newMockBlobRepo()
+	store := newMockFileStore()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	signing := NewSigningService(newMockSigningKeyRepo(), nil, log)
+	artifacts := NewArtifactService(artRepo, blobs, store, signing, newDisabledDeltaService(artRepo, store, log), NewValidationService(store, ValidationConfig{}, log), archive.Limits{}, 0, log)
+	scrubRepo := newMockScrubRepo(blobs)
+
+	var scrubStore storage.FileStore = store
+	if wrap != nil {
+		scrubStore = wrap(store)
+	}
+	return &scrubTestEnv{
+		svc:       NewScrubService(blobs, artRepo, scrubRepo, scrubStore, log),
+		artifacts: artifacts,
+		artRepo:   artRepo,
+		scrubRepo: scrubRepo,
+		store:     store,
+	}
+}
+
+func (e *scrubTestEnv) createArtifact(t *testing.T, version, content string) *domain.Artifact {
+	t.Helper()
+	a, err := e.artifacts.Create(context.Background(), CreateArtifactInput{
+		OrgID: testOrgID, Name: "myapp", Version: version, FileName: "myapp",
+		TargetPath: "/usr/local/bin/myapp", DeviceTypes: []string{"raspberry-pi-4"},
+		File: strings.NewReader(content),
+	})
+	if err != nil {
+		t.Fatalf("create artifact: %v", err)
+	}
+	return a
+}
+
+// unreachableFileStore fails every Open as an object store that cannot be
+// reached would.
+type unreachableFileStore struct {
+	*mockFileStore
+}
+
+func (unreachableFileStore) Open(string) (io.ReadSeekCloser, error) {
+	return nil, errors.New("dial tcp: connection refused")
+}
+
+func TestScrub_HealthyStorage(t *testing.T) {
+	env := newTestScrubService()
+	env.createArtifact(t, "1.0.0", "binary data")
+
+	report, err := env.svc.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if report.BlobsChecked != 1 || report.BytesChecked != int64(len("binary data")) {
+		t.Errorf("unexpected counters %d blobs %d bytes", report.BlobsChecked, report.BytesChecked)
+	}
+	if len(report.Findings) != 0 {
+		t.Errorf("expected no findings, got %+v", report.Findings)
+	}
+	if latest, err := env.svc.LatestReport(context.Background()); err != nil || latest.ID != report.ID {
+		t.Errorf("expected report to be stored, got %v", err)
+	}
+}
+
+func TestScrub_QuarantinesCorruptedContent(t *testing.T) {
+	env := newTestScrubService()
+	ctx := context.Background()
+	first := env.createArtifact(t, "1.0.0", "binary data")
+	second := env.createArtifact(t, "1.0.1", "binary data")
+	other := env.createArtifact(t, "2.0.0", "other data")
+
+	original := env.store.files[first.StoragePath]
+	env.store.files[first.StoragePath] = []byte("binary dat4")
+
+	report, err := env.svc.Run(ctx)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if len(report.Findings) != 1 {
+		t.Fatalf("expected 1 finding, got %+v", report.Findings)
+	}
+	f := report.Findings[0]
+	if f.Kind != domain.ScrubChecksumMismatch || f.ActualChecksum == "" || len(f.ArtifactIDs) != 2 {
+		t.Errorf("unexpected finding %+v", f)
+	}
+	for _, a := range []*domain.Artifact{first, second} {
+		if a.QuarantinedAt == nil || a.QuarantineReason != string(domain.ScrubChecksumMismatch) {
+			t.Errorf("expected artifact %s to be quarantined", a.Version)
+		}
+	}
+	if other.QuarantinedAt != nil {
+		t.Error("expected artifact with other content to stay deliverable")
+	}
+	if len(env.store.files) != 2 {
+		t.Error("expected no files to be deleted")
+	}
+
+	// Restoring the file releases the artifacts on the next scrub
+	env.store.files[first.StoragePath] = original
+	report, err = env.svc.Run(ctx)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if len(report.Findings) != 1 || report.Findings[0].Kind != domain.ScrubRestored {
+		t.Fatalf("expected a restored finding, got %+v", report.Findings)
+	}
+	if first.QuarantinedAt != nil || second.QuarantinedAt != nil {
+		t.Error("expected artifacts to be released from quarantine")
+	}
+}
+
+func TestScrub_QuarantinesMissingFile(t *testing.T) {
+	env := newTestScrubService()
+	created := env.createArtifact(t, "1.0.0", "binary data")
+	env.store.Delete(created.StoragePath)
+
+	report, err := env.svc.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if len(report.Findings) != 1 || report.Findings[0].Kind != domain.ScrubMissingFile {
+		t.Fatalf("expected a missing_file finding, got %+v", report.Findings)
+	}
+	if _, err := env.artRepo.GetByID(context.Background(), testOrgID, created.ID); err != nil {
+		t.Errorf("expected artifact record to be kept, got %v", err)
+	}
+	if created.QuarantinedAt == nil {
+		t.Error("expected artifact to be quarantined")
+	}
+}
+
+func TestScrub_UnreachableStoreQuarantinesNothing(t *testing.T) {
+	env := newTestScrubServiceWith(func(s *mockFileStore) storage.FileStore {
+		return unreachableFileStore{s}
+	})
+	created := env.createArtifact(t, "1.0.0", "binary data")
+
+	report, err := env.svc.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if len(report.Findings) != 1 || report.Findings[0].Kind != domain.ScrubUnreadable {
+		t.Fatalf("expected an unreadable finding, got %+v", report.Findings)
+	}
+	if created.QuarantinedAt != nil {
+		t.Error("expected artifact not to be quarantined")
+	}
+}
+
+func TestScrub_ReportsOrphanFiles(t *testing.T) {
+	env := newTestScrubService()
+	env.createArtifact(t, "1.0.0", "binary data")
+
+	old := time.Now().Add(-2 * orphanGracePeriod)
+	env.store.files["/mock/storage/leftover"] = []byte("leftover")
+	env.store.modTimes["/mock/storage/leftover"] = old
+	env.store.files["/mock/storage/delta"] = []byte("patch")
+	env.store.modTimes["/mock/storage/delta"] = old
+	env.scrubRepo.paths = []string{"/mock/storage/delta"}
+	// Written moments ago, as by an upload still in progress
+	env.store.files["/mock/storage/in-flight"] = []byte("new")
+	env.store.modTimes["/mock/storage/in-flight"] = time.Now()
+
+	report, err := env.svc.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if report.FilesListed != 4 {
+		t.Errorf("expected 4 files listed, got %d", report.FilesListed)
+	}
+	if len(report.Findings) != 1 {
+		t.Fatalf("expected 1 finding, got %+v", report.Findings)
+	}
+	if f := report.Findings[0]; f.Kind != domain.ScrubOrphanFile || f.StoragePath != "/mock/storage/leftover" || f.Size != 8 {
+		t.Errorf("unexpected finding %+v", f)
+	}
+	if _, ok := env.store.files["/mock/storage/leftover"]; !ok {
+		t.Error("expected orphan file to be kept")
+	}
+}
+
+func TestScrub_RejectsConcurrentRun(t *testing.T) {
+	env := newTestScrubService()
+	env.svc.running.Store(true)
+
+	if _, err := env.svc.Run(context.Background()); !errors.Is(err, domain.ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	if err := env.svc.Start(context.Background()); !errors.Is(err, domain.ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+}
+
+func TestScrub_BundleStaysQuarantinedWhileAFileIsBroken(t *testing.T) {
+	env := newTestScrubService()
+	ctx := context.Background()
+	bundle, err := env.artifacts.CreateBundle(ctx, CreateBundleInput{
+		OrgID: testOrgID, Name: "myapp-bundle", Version: "1.0.0", DeviceTypes: []string{"raspberry-pi-4"},
+		Files: []BundleFileInput{
+			{TargetPath: "/usr/local/bin/myapp", File: strings.NewReader("binary data")},
+			{TargetPath: "/etc/myapp.conf", File: strings.NewReader("config")},
+		},
+	})
+	if err != nil {
+		t.Fatalf("create bundle: %v", err)
+	}
+	single := env.createArtifact(t, "1.0.0", "binary data")
+
+	// The bundle is quarantined for its config, the binary is healthy
+	env.store.files[bundle.Files[1].StoragePath] = []byte("c0nfig")
+	for run := 0; run < 2; run++ {
+		report, err := env.svc.Run(ctx)
+		if err != nil {
+			t.Fatalf("run: %v", err)
+		}
+		if len(report.Findings) != 1 || report.Findings[0].Kind != domain.ScrubChecksumMismatch {
+			t.Fatalf("run %d: expected only the mismatch, got %+v", run, report.Findings)
+		}
+		if bundle.QuarantinedAt == nil {
+			t.Fatalf("run %d: expected bundle to stay quarantined", run)
+		}
+		if single.QuarantinedAt != nil {
+			t.Fatalf("run %d: expected artifact sharing the healthy file to stay deliverable", run)
+		}
+	}
+
+	env.store.files[bundle.Files[1].StoragePath] = []byte("config")
+	report, err := env.svc.Run(ctx)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if len(report.Findings) != 1 || report.Findings[0].Kind != domain.ScrubRestored || bundle.QuarantinedAt != nil {
+		t.Errorf("expected bundle to be released, got %+v", report.Findings)
+	}
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/CaioWing/Harbor/internal/auth"
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+type SigningService struct {
+	repo   domain.SigningKeyRepository
+	signer *auth.Signer
+	log    *slog.Logger
+}
+
+// NewSigningService creates the service. signer is the server-held key and
+// may be nil, in which case artifacts uploaded without a signature stay
+// unsigned.
+func NewSigningService(repo domain.SigningKeyRepository, signer *auth.Signer, log *slog.Logger) *SigningService {
+	return &SigningService{repo: repo, signer: signer, log: log}
+}
+
+type AddSigningKeyInput struct {
+	OrgID     uuid.UUID
+	Name      string
+	PublicKey string
+}
+
+// AddKey trusts a public key for the organization's artifacts.
+func (s *SigningService) AddKey(ctx context.Context, input AddSigningKeyInput) (*domain.SigningKey, error) {
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		return nil, fmt.Errorf("%w: name is required", domain.ErrInvalidInput)
+	}
+	pub, err := auth.ParsePublicKey(input.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrInvalidInput, err)
+	}
+
+	key := &domain.SigningKey{
+		OrgID:     input.OrgID,
+		KeyID:     auth.KeyFingerprint(pub),
+		Name:      input.Name,
+		PublicKey: base64.StdEncoding.EncodeToString(pub),
+	}
+	if s.signer != nil && key.KeyID == s.signer.KeyID() {
+		return nil, fmt.Errorf("%w: key is the server signing key", domain.ErrConflict)
+	}
+	if err := s.repo.Create(ctx, key); err != nil {
+		return nil, fmt.Errorf("create signing key: %w", err)
+	}
+
+	s.log.Info("signing key added", "organization", input.OrgID, "key_id", key.KeyID)
+	return key, nil
+}
+
+// ListKeys returns the organization's keys, including revoked ones, preceded
+// by the server key when one is configured.
+func (s *SigningService) ListKeys(ctx context.Context, orgID uuid.UUID) ([]*domain.SigningKey, error) {
+	keys, err := s.repo.List(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	if s.signer != nil {
+		keys = append([]*domain.SigningKey{s.serverKey()}, keys...)
+	}
+	return keys, nil
+}
+
+// TrustedKeys returns the keys devices of the organization should accept.
+func (s *SigningService) TrustedKeys(ctx context.Context, orgID uuid.UUID) ([]*domain.SigningKey, error) {
+	keys, err := s.ListKeys(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	trusted := make([]*domain.SigningKey, 0, len(keys))
+	for _, k := range keys {
+		if k.RevokedAt == nil {
+			trusted = append(trusted, k)
+		}
+	}
+	return trusted, nil
+}
+
+// RevokeKey stops trusting a key. Devices refuse artifacts signed with it
+// from then on, so artifacts must be re-signed with another key.
+func (s *SigningService) RevokeKey(ctx context.Context, orgID, id uuid.UUID) error {
+	if err := s.repo.Revoke(ctx, orgID, id); err != nil {
+		return err
+	}
+	s.log.Info("signing key revoked", "organization", orgID, "id", id)
+	return nil
+}
+
+// SignArtifact returns the signature and key ID to store for an artifact
+// with the given SHA-256 digest. A supplied signature must verify against a
+// trusted key of the organization; without one the server key signs, if
+// configured.
+func (s *SigningService) SignArtifact(ctx context.Context, orgID uuid.UUID, digest []byte, signature, keyID string) (string, string, error) {
+	signature = strings.TrimSpace(signature)
+	keyID = strings.TrimSpace(keyID)
+
+	if signature == "" {
+		if keyID != "" {
+			return "", "", fmt.Errorf("%w: signing_key_id given without signature", domain.ErrInvalidInput)
+		}
+		signature, keyID := s.SignDigest(digest)
+		return signature, keyID, nil
+	}
+
+	if keyID == "" {
+		return "", "", fmt.Errorf("%w: signing_key_id is required with a signature", domain.ErrInvalidInput)
+	}
+	key, err := s.trustedKey(ctx, orgID, keyID)
+	if err != nil {
+		return "", "", err
+	}
+	pub, err := auth.ParsePublicKey(key.PublicKey)
+	if err != nil {
+		return "", "", fmt.Errorf("stored signing key %s: %w", keyID, err)
+	}
+	if !auth.VerifyDigest(pub, digest, signature) {
+		return "", "", fmt.Errorf("%w: signature does not match the file", domain.ErrInvalidInput)
+	}
+	return signature, keyID, nil
+}
+
+// SignDigest signs digest with the server key, for files the server produces
+// itself. It returns empty strings when no server key is configured.
+func (s *SigningService) SignDigest(digest []byte) (string, string) {
+	if s.signer == nil {
+		return "", ""
+	}
+	return s.signer.SignDigest(digest), s.signer.KeyID()
+}
+
+func (s *SigningService) trustedKey(ctx context.Context, orgID uuid.UUID, keyID string) (*domain.SigningKey, error) {
+	if s.signer != nil && keyID == s.signer.KeyID() {
+		return s.serverKey(), nil
+	}
+
+	key, err := s.repo.GetByKeyID(ctx, orgID, keyID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, fmt.Errorf("%w: unknown signing key %s", domain.ErrInvalidInput, keyID)
+		}
+		return nil, err
+	}
+	if key.RevokedAt != nil {
+		return nil, fmt.Errorf("%w: signing key %s has been revoked", domain.ErrInvalidInput, keyID)
+	}
+	return key, nil
+}
+
+func (s *SigningService) serverKey() *domain.SigningKey {
+	return &domain.SigningKey{
+		KeyID:     s.signer.KeyID(),
+		Name:      "server",
+		PublicKey: base64.StdEncoding.EncodeToString(s.signer.PublicKey()),
+		Server:    true,
+	}
+}
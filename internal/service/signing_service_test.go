@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/CaioWing/Harbor/internal/archive"
+	"github.com/CaioWing/Harbor/internal/auth"
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+type signingTestEnv struct {
+	signing   *SigningService
+	artifacts *ArtifactService
+	keys      *mockSigningKeyRepo
+}
+
+func newSigningTestEnv(t *testing.T, withServerKey bool) *signingTestEnv {
+	t.Helper()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	var signer *auth.Signer
+	if withServerKey {
+		seed := make([]byte, ed25519.SeedSize)
+		rand.Read(seed)
+		var err error
+		signer, err = auth.NewSigner(base64.StdEncoding.EncodeToString(seed))
+		if err != nil {
+			t.Fatalf("new signer: %v", err)
+		}
+	}
+
+	keys := newMockSigningKeyRepo()
+	signing := NewSigningService(keys, signer, log)
+	artRepo, store := newMockArtifactRepo(), newMockFileStore()
+	return &signingTestEnv{
+		signing:   signing,
+		artifacts: NewArtifactService(artRepo, newMockBlobRepo(), store, signing, newDisabledDeltaService(artRepo, store, log), NewValidationService(store, ValidationConfig{}, log), archive.Limits{}, 0, log),
+		keys:      keys,
+	}
+}
+
+// addCIKey registers a freshly generated key as trusted and returns its
+// private half.
+func (e *signingTestEnv) addCIKey(t *testing.T) (ed25519.PrivateKey, *domain.SigningKey) {
+	t.Helper()
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	key, err := e.signing.AddKey(context.Background(), AddSigningKeyInput{
+		OrgID:     testOrgID,
+		Name:      "ci",
+		PublicKey: base64.StdEncoding.EncodeToString(pub),
+	})
+	if err != nil {
+		t.Fatalf("add key: %v", err)
+	}
+	return priv, key
+}
+
+func signContent(priv ed25519.PrivateKey, content string) string {
+	digest := sha256.Sum256([]byte(content))
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(priv, digest[:]))
+}
+
+func signedInput(content, signature, keyID string) CreateArtifactInput {
+	return CreateArtifactInput{
+		OrgID:        testOrgID,
+		Name:         "myapp",
+		Version:      "1.0.0",
+		FileName:     "myapp",
+		TargetPath:   "/usr/local/bin/myapp",
+		DeviceTypes:  []string{"raspberry-pi-4"},
+		Signature:    signature,
+		SigningKeyID: keyID,
+		File:         strings.NewReader(content),
+	}
+}
+
+func TestSigningAddKey_InvalidPublicKey(t *testing.T) {
+	env := newSigningTestEnv(t, false)
+
+	_, err := env.signing.AddKey(context.Background(), AddSigningKeyInput{
+		OrgID:     testOrgID,
+		Name:      "ci",
+		PublicKey: base64.StdEncoding.EncodeToString([]byte("short")),
+	})
+	if !errors.Is(err, domain.ErrInvalidInput) {
+		t.Fatalf("expected ErrInvalidInput, got %v", err)
+	}
+}
+
+func TestSigningAddKey_FingerprintKeyID(t *testing.T) {
+	env := newSigningTestEnv(t, false)
+
+	_, key := env.addCIKey(t)
+	pub, _ := auth.ParsePublicKey(key.PublicKey)
+	if key.KeyID != auth.KeyFingerprint(pub) || len(key.KeyID) != 16 {
+		t.Fatalf("unexpected key id %q", key.KeyID)
+	}
+}
+
+func TestArtifactCreate_ValidSignature(t *testing.T) {
+	env := newSigningTestEnv(t, false)
+	priv, key := env.addCIKey(t)
+
+	sig := signContent(priv, "binary content")
+	artifact, err := env.artifacts.Create(context.Background(), signedInput("binary content", sig, key.KeyID))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if artifact.Signature != sig || artifact.SigningKeyID != key.KeyID {
+		t.Fatalf("signature not stored: %q %q", artifact.Signature, artifact.SigningKeyID)
+	}
+}
+
+func TestArtifactCreate_SignatureMismatch(t *testing.T) {
+	env := newSigningTestEnv(t, false)
+	priv, key := env.addCIKey(t)
+
+	sig := signContent(priv, "original content")
+	_, err := env.artifacts.Create(context.Background(), signedInput("tampered content", sig, key.KeyID))
+	if !errors.Is(err, domain.ErrInvalidInput) {
+		t.Fatalf("expected ErrInvalidInput, got %v", err)
+	}
+}
+
+func TestArtifactCreate_UnknownSigningKey(t *testing.T) {
+	env := newSigningTestEnv(t, false)
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+
+	sig := signContent(priv, "content")
+	_, err := env.artifacts.Create(context.Background(), signedInput("content", sig, "0011223344556677"))
+	if !errors.Is(err, domain.ErrInvalidInput) {
+		t.Fatalf("expected ErrInvalidInput, got %v", err)
+	}
+}
+
+func TestArtifactCreate_RevokedSigningKey(t *testing.T) {
+	env := newSigningTestEnv(t, false)
+	ctx := context.Background()
+	priv, key := env.addCIKey(t)
+
+	if err := env.signing.RevokeKey(ctx, testOrgID, key.ID); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+
+	_, err := env.artifacts.Create(ctx, signedInput("content", signContent(priv, "content"), key.KeyID))
+	if !errors.Is(err, domain.ErrInvalidInput) {
+		t.Fatalf("expected ErrInvalidInput, got %v", err)
+	}
+
+	trusted, _ := env.signing.TrustedKeys(ctx, testOrgID)
+	if len(trusted) != 0 {
+		t.Fatalf("revoked key must not be trusted, got %d keys", len(trusted))
+	}
+}
+
+func TestArtifactCreate_KeyOfOtherOrganization(t *testing.T) {
+	env := newSigningTestEnv(t, false)
+	priv, key := env.addCIKey(t)
+
+	input := signedInput("content", signContent(priv, "content"), key.KeyID)
+	input.OrgID = uuid.New()
+	_, err := env.artifacts.Create(context.Background(), input)
+	if !errors.Is(err, domain.ErrInvalidInput) {
+		t.Fatalf("expected ErrInvalidInput, got %v", err)
+	}
+}
+
+func TestArtifactCreate_ServerSignsUnsignedUpload(t *testing.T) {
+	env := newSigningTestEnv(t, true)
+	ctx := context.Background()
+
+	artifact, err := env.artifacts.Create(ctx, signedInput("content", "", ""))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	trusted, _ := env.signing.TrustedKeys(ctx, testOrgID)
+	if len(trusted) != 1 || !trusted[0].Server || trusted[0].KeyID != artifact.SigningKeyID {
+		t.Fatalf("expected the server key to be trusted and used, got %+v", trusted)
+	}
+
+	pub, _ := auth.ParsePublicKey(trusted[0].PublicKey)
+	digest := sha256.Sum256([]byte("content"))
+	if !auth.VerifyDigest(pub, digest[:], artifact.Signature) {
+		t.Fatal("server signature does not verify")
+	}
+}
+
+func TestArtifactCreate_UnsignedWithoutServerKey(t *testing.T) {
+	env := newSigningTestEnv(t, false)
+
+	artifact, err := env.artifacts.Create(context.Background(), signedInput("content", "", ""))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if artifact.Signature != "" || artifact.SigningKeyID != "" {
+		t.Fatal("expected artifact to be unsigned")
+	}
+}
@@ -0,0 +1,281 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+// StateService compares what the deployment history says each device holds
+// with what the device reports in the installed section of its inventory.
+type StateService struct {
+	deployRepo domain.DeploymentRepository
+	deviceRepo domain.DeviceRepository
+	artRepo    domain.ArtifactRepository
+	log        *slog.Logger
+}
+
+func NewStateService(deployRepo domain.DeploymentRepository, deviceRepo domain.DeviceRepository, artRepo domain.ArtifactRepository, log *slog.Logger) *StateService {
+	return &StateService{deployRepo: deployRepo, deviceRepo: deviceRepo, artRepo: artRepo, log: log}
+}
+
+// DeviceState returns the desired-versus-actual view of a device.
+func (s *StateService) DeviceState(ctx context.Context, orgID, deviceID uuid.UUID) (*domain.DeviceState, error) {
+	device, err := s.deviceRepo.GetByID(ctx, orgID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	states, err := s.states(ctx, orgID, []*domain.Device{device})
+	if err != nil {
+		return nil, err
+	}
+	return states[0], nil
+}
+
+// Drift returns the accepted devices whose files were modified locally, are
+// missing or hold another version than the one deployed, with only those
+// files.
+func (s *StateService) Drift(ctx context.Context, orgID uuid.UUID) ([]*domain.DeviceState, error) {
+	const perPage = 100
+	drifted := []*domain.DeviceState{}
+	for page := 1; ; page++ {
+		devices, total, err := s.deviceRepo.List(ctx, orgID, domain.DeviceFilter{
+			Status:  statusPtr(domain.DeviceStatusAccepted),
+			Page:    page,
+			PerPage: perPage,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(devices) > 0 {
+			states, err := s.states(ctx, orgID, devices)
+			if err != nil {
+				return nil, err
+			}
+			for _, state := range states {
+				if !state.Drifted {
+					continue
+				}
+				state.Files = slices.DeleteFunc(state.Files, func(f *domain.FileState) bool { return !f.Status.Drifted() })
+				drifted = append(drifted, state)
+			}
+		}
+		if len(devices) == 0 || page*perPage >= total {
+			return drifted, nil
+		}
+	}
+}
+
+// InstalledArtifacts returns the artifacts that make up the current state of
+// any device of the organization: those providing a desired file, the
+// rename and delete operations the state was computed with, and those of
+// reported that a device reports at their version in its inventory.
+func (s *StateService) InstalledArtifacts(ctx context.Context, orgID uuid.UUID, reported []*domain.Artifact) (map[uuid.UUID]bool, error) {
+	byPath := make(map[string][]*domain.Artifact)
+	for _, a := range reported {
+		for path := range installedFiles(a) {
+			byPath[path] = append(byPath[path], a)
+		}
+	}
+
+	const perPage = 100
+	installed := make(map[uuid.UUID]bool)
+	for page := 1; ; page++ {
+		devices, total, err := s.deviceRepo.List(ctx, orgID, domain.DeviceFilter{Page: page, PerPage: perPage})
+		if err != nil {
+			return nil, err
+		}
+		if len(devices) > 0 {
+			desired, operations, err := s.desiredFiles(ctx, orgID, devices)
+			if err != nil {
+				return nil, err
+			}
+			for _, files := range desired {
+				for _, f := range files {
+					installed[f.ArtifactID] = true
+				}
+			}
+			for id := range operations {
+				installed[id] = true
+			}
+		}
+		for _, d := range devices {
+			for path, artifacts := range byPath {
+				actual, ok := domain.InstalledAt(d.Inventory, path)
+				if !ok {
+					continue
+				}
+				for _, a := range artifacts {
+					if actual.Version == a.Version && sameChecksum(installedFiles(a)[path], actual.ChecksumSHA256) {
+						installed[a.ID] = true
+					}
+				}
+			}
+		}
+		if len(devices) == 0 || page*perPage >= total {
+			return installed, nil
+		}
+	}
+}
+
+// states computes the state of each of devices from their installations.
+func (s *StateService) states(ctx context.Context, orgID uuid.UUID, devices []*domain.Device) ([]*domain.DeviceState, error) {
+	desired, _, err := s.desiredFiles(ctx, orgID, devices)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]*domain.DeviceState, len(devices))
+	for i, d := range devices {
+		states[i] = compareState(d, desired[d.ID])
+	}
+	return states, nil
+}
+
+// desiredFiles replays the installations of devices and returns the desired
+// files of each, by device and target path, with the rename and delete
+// operations that were applied.
+func (s *StateService) desiredFiles(ctx context.Context, orgID uuid.UUID, devices []*domain.Device) (map[uuid.UUID]map[string]*domain.DesiredFile, map[uuid.UUID]bool, error) {
+	ids := make([]uuid.UUID, len(devices))
+	for i, d := range devices {
+		ids[i] = d.ID
+	}
+	installations, err := s.deployRepo.ListInstallations(ctx, orgID, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	artifacts := make(map[uuid.UUID]*domain.Artifact)
+	desired := make(map[uuid.UUID]map[string]*domain.DesiredFile)
+	operations := make(map[uuid.UUID]bool)
+	for _, inst := range installations {
+		artifact, ok := artifacts[inst.ArtifactID]
+		if !ok {
+			if artifact, err = s.artRepo.GetByID(ctx, orgID, inst.ArtifactID); err != nil {
+				return nil, nil, fmt.Errorf("artifact %s: %w", inst.ArtifactID, err)
+			}
+			artifacts[inst.ArtifactID] = artifact
+		}
+		if desired[inst.DeviceID] == nil {
+			desired[inst.DeviceID] = make(map[string]*domain.DesiredFile)
+		}
+		applyInstallation(desired[inst.DeviceID], inst, artifact)
+		if artifact.Kind == domain.ArtifactKindDelete || artifact.Kind == domain.ArtifactKindRename {
+			operations[artifact.ID] = true
+		}
+	}
+	return desired, operations, nil
+}
+
+// applyInstallation updates the desired files of a device, by target path,
+// with an installation of artifact. Scripts, symlinks and directories leave
+// no file to compare.
+func applyInstallation(files map[string]*domain.DesiredFile, inst *domain.Installation, artifact *domain.Artifact) {
+	switch artifact.Kind {
+	case domain.ArtifactKindDelete:
+		delete(files, artifact.TargetPath)
+	case domain.ArtifactKindRename:
+		if moved, ok := files[artifact.SourcePath]; ok {
+			files[artifact.TargetPath] = moved
+			delete(files, artifact.SourcePath)
+		}
+	default:
+		for path, checksum := range installedFiles(artifact) {
+			files[path] = &domain.DesiredFile{
+				ArtifactID:     artifact.ID,
+				ArtifactName:   artifact.Name,
+				Version:        artifact.Version,
+				ChecksumSHA256: checksum,
+				DeploymentID:   inst.DeploymentID,
+				InstalledAt:    inst.InstalledAt,
+			}
+		}
+	}
+}
+
+// installedFiles returns the target paths artifact installs files at, with
+// the checksum each should have, or "" when the content cannot be compared
+// with the device's report, as for archives and templates.
+func installedFiles(artifact *domain.Artifact) map[string]string {
+	switch {
+	case artifact.IsBundle():
+		files := make(map[string]string, len(artifact.Files))
+		for _, f := range artifact.Files {
+			files[f.TargetPath] = f.ChecksumSHA256
+		}
+		return files
+	case artifact.Kind == domain.ArtifactKindArchive || artifact.IsTemplate():
+		return map[string]string{artifact.TargetPath: ""}
+	case artifact.IsScript() || artifact.IsOperation():
+		return nil
+	}
+	return map[string]string{artifact.TargetPath: artifact.ChecksumSHA256}
+}
+
+// reportMatches reports whether the installed section of inventory shows
+// every file of artifact at its version, with the same checksum when both
+// sides have one.
+func reportMatches(artifact *domain.Artifact, inventory map[string]interface{}) bool {
+	for path, checksum := range installedFiles(artifact) {
+		actual, ok := domain.InstalledAt(inventory, path)
+		if !ok || actual.Version != artifact.Version || !sameChecksum(checksum, actual.ChecksumSHA256) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameChecksum reports whether two checksums match, treating a missing one
+// as matching.
+func sameChecksum(a, b string) bool {
+	return a == "" || b == "" || strings.EqualFold(a, b)
+}
+
+// compareState compares the desired files of a device with its report.
+// Checksums are compared when both sides have one.
+func compareState(device *domain.Device, desired map[string]*domain.DesiredFile) *domain.DeviceState {
+	state := &domain.DeviceState{DeviceID: device.ID, DeviceType: device.DeviceType, Files: []*domain.FileState{}}
+	reported, _ := device.Inventory[domain.InventoryInstalledKey].(map[string]interface{})
+
+	paths := make([]string, 0, len(desired)+len(reported))
+	for path := range desired {
+		paths = append(paths, path)
+	}
+	for path := range reported {
+		if _, ok := desired[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	slices.Sort(paths)
+
+	for _, path := range paths {
+		fs := &domain.FileState{TargetPath: path, Desired: desired[path]}
+		if actual, ok := domain.InstalledAt(device.Inventory, path); ok {
+			fs.Actual = &actual
+		}
+		switch {
+		case fs.Desired == nil:
+			if fs.Actual == nil {
+				continue
+			}
+			fs.Status = domain.DriftUnmanaged
+		case fs.Actual == nil:
+			fs.Status = domain.DriftMissing
+		case fs.Actual.Version != fs.Desired.Version:
+			fs.Status = domain.DriftOutdated
+		case !sameChecksum(fs.Desired.ChecksumSHA256, fs.Actual.ChecksumSHA256):
+			fs.Status = domain.DriftModified
+		default:
+			fs.Status = domain.DriftInSync
+		}
+		state.Drifted = state.Drifted || fs.Status.Drifted()
+		state.Files = append(state.Files, fs)
+	}
+	return state
+}
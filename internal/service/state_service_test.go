@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+func TestDeviceStateAndDrift(t *testing.T) {
+	env := newTestDeploymentService()
+	ctx := context.Background()
+	svc := NewStateService(env.deployRepo, env.deviceRepo, env.artRepo, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	modified := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{})
+	outdated := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{})
+	missing := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{})
+	inSync := env.createAcceptedDevice(ctx, "raspberry-pi-4", []string{})
+	v1 := env.createArtifact(ctx, "myapp", "1.0.0", []string{"raspberry-pi-4"})
+	v1.ChecksumSHA256 = strings.Repeat("a", 64)
+	v2 := env.createArtifact(ctx, "myapp", "2.0.0", []string{"raspberry-pi-4"})
+	v2.ChecksumSHA256 = strings.Repeat("b", 64)
+
+	// install deploys artifact and reports success on the given devices only
+	install := func(artifact *domain.Artifact, devices ...*domain.Device) {
+		dep, err := env.svc.Create(ctx, CreateDeploymentInput{
+			OrgID: testOrgID, Name: "install " + artifact.Version, ArtifactID: artifact.ID, TargetDeviceIDs: []uuid.UUID{devices[0].ID},
+		})
+		if err != nil {
+			t.Fatalf("create deployment: %v", err)
+		}
+		dds, _ := env.deployRepo.GetDeploymentDevices(ctx, testOrgID, dep.ID)
+		for _, dd := range dds {
+			for _, d := range devices {
+				if dd.DeviceID == d.ID {
+					env.svc.UpdateDeviceStatus(ctx, testOrgID, dd.DeviceID, dd.ID, domain.DDStatusSuccess, "")
+				}
+			}
+		}
+	}
+	report := func(d *domain.Device, files map[string]interface{}) {
+		d.Inventory = map[string]interface{}{domain.InventoryInstalledKey: files}
+	}
+
+	install(v1, modified, outdated)
+	install(v2, modified, outdated, missing, inSync)
+	report(modified, map[string]interface{}{v2.TargetPath: map[string]interface{}{"version": "2.0.0", "checksum_sha256": strings.Repeat("c", 64)}})
+	report(outdated, map[string]interface{}{v2.TargetPath: map[string]interface{}{"version": "1.0.0"}})
+	report(inSync, map[string]interface{}{
+		v2.TargetPath:   map[string]interface{}{"version": "2.0.0", "checksum_sha256": v2.ChecksumSHA256},
+		"/etc/hand.cfg": map[string]interface{}{"version": "local"},
+	})
+
+	state, err := svc.DeviceState(ctx, testOrgID, inSync.ID)
+	if err != nil {
+		t.Fatalf("device state: %v", err)
+	}
+	if state.Drifted || len(state.Files) != 2 {
+		t.Fatalf("expected two files in sync or unmanaged, got %+v", state)
+	}
+	if f := state.Files[0]; f.TargetPath != "/etc/hand.cfg" || f.Status != domain.DriftUnmanaged {
+		t.Errorf("expected the unmanaged file first, got %+v", f)
+	}
+	if f := state.Files[1]; f.Status != domain.DriftInSync || f.Desired.ArtifactID != v2.ID {
+		t.Errorf("expected v2 in sync, got %+v", f)
+	}
+
+	drift, err := svc.Drift(ctx, testOrgID)
+	if err != nil {
+		t.Fatalf("drift: %v", err)
+	}
+	want := map[uuid.UUID]domain.DriftStatus{
+		modified.ID: domain.DriftModified,
+		outdated.ID: domain.DriftOutdated,
+		missing.ID:  domain.DriftMissing,
+	}
+	if len(drift) != len(want) {
+		t.Fatalf("expected %d drifted devices, got %d", len(want), len(drift))
+	}
+	for _, s := range drift {
+		if len(s.Files) != 1 || s.Files[0].Status != want[s.DeviceID] {
+			t.Errorf("device %s: expected %s, got %+v", s.DeviceID, want[s.DeviceID], s.Files)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/CaioWing/Harbor/internal/domain"
+	"github.com/CaioWing/Harbor/internal/storage"
+)
+
+// MaxUploadChunkSize bounds a single chunk of a resumable upload.
+const MaxUploadChunkSize = 64 << 20
+
+// UploadService handles resumable artifact uploads: a session is created
+// with the artifact metadata and total size, the file is written in
+// sequential chunks, and completing the session creates the artifact.
+type UploadService struct {
+	repo      domain.UploadSessionRepository
+	artifacts *ArtifactService
+	store     storage.FileStore
+	ttl       time.Duration
+	log       *slog.Logger
+}
+
+// NewUploadService creates the service. Sessions expire ttl after their last
+// chunk.
+func NewUploadService(repo domain.UploadSessionRepository, artifacts *ArtifactService, store storage.FileStore, ttl time.Duration, log *slog.Logger) *UploadService {
+	return &UploadService{repo: repo, artifacts: artifacts, store: store, ttl: ttl, log: log}
+}
+
+type CreateUploadInput struct {
+	OrgID          uuid.UUID
+	Metadata       domain.UploadMetadata
+	Size           int64
+	ChecksumSHA256 string
+}
+
+func (s *UploadService) CreateSession(ctx context.Context, input CreateUploadInput) (*domain.UploadSession, error) {
+	m := input.Metadata
+	if err := validateArtifactFields(m.Kind, m.Name, m.Version, m.TargetPath, m.DeviceTypes); err != nil {
+		return nil, err
+	}
+	if m.FileName == "" {
+		return nil, fmt.Errorf("%w: file_name is required", domain.ErrInvalidInput)
+	}
+	if err := validateRequirements(m.Requirements); err != nil {
+		return nil, err
+	}
+	switch m.Kind {
+	case "", domain.ArtifactKindFile, domain.ArtifactKindArchive, domain.ArtifactKindTemplate:
+	default:
+		return nil, fmt.Errorf("%w: kind must be file, archive or template", domain.ErrInvalidInput)
+	}
+	if input.Size <= 0 {
+		return nil, fmt.Errorf("%w: size must be positive", domain.ErrInvalidInput)
+	}
+	// The session reserves its size, and is refused if that does not fit
+	// with the stored files and the other sessions
+	if err := s.artifacts.checkQuota(ctx, input.Size); err != nil {
+		return nil, err
+	}
+
+	session := &domain.UploadSession{
+		OrgID:          input.OrgID,
+		Metadata:       m,
+		Size:           input.Size,
+		ChecksumSHA256: strings.ToLower(strings.TrimSpace(input.ChecksumSHA256)),
+		ExpiresAt:      time.Now().Add(s.ttl),
+	}
+	if err := s.repo.Create(ctx, session); err != nil {
+		return nil, fmt.Errorf("create upload session: %w", err)
+	}
+
+	s.log.Info("upload session created", "id", session.ID, "name", m.Name, "version", m.Version, "size", input.Size)
+	return session, nil
+}
+
+func (s *UploadService) GetSession(ctx context.Context, orgID, id uuid.UUID) (*domain.UploadSession, error) {
+	return s.repo.GetByID(ctx, orgID, id)
+}
+
+// WriteChunk appends a chunk at offset, which must be the current session
+// offset, and returns the new offset. checksum is the hex SHA-256 of the
+// chunk and is verified when set. An offset that does not match fails with
+// ErrConflict so that the client can resume from the session offset.
+func (s *UploadService) WriteChunk(ctx context.Context, orgID, id uuid.UUID, offset int64, checksum string, body io.Reader) (int64, error) {
+	session, err := s.repo.GetByID(ctx, orgID, id)
+	if err != nil {
+		return 0, err
+	}
+	if offset != session.Offset {
+		return session.Offset, fmt.Errorf("%w: upload offset is %d", domain.ErrConflict, session.Offset)
+	}
+	if err := s.checkQuota(ctx, session); err != nil {
+		return session.Offset, err
+	}
+
+	remaining := min(session.Size-offset, MaxUploadChunkSize)
+	hasher := sha256.New()
+	// Read one byte past the limit to detect oversized chunks
+	name := fmt.Sprintf("upload_%s_%d", session.ID, offset)
+	path, size, err := s.store.Save(name, io.TeeReader(io.LimitReader(body, remaining+1), hasher))
+	if err != nil {
+		return session.Offset, fmt.Errorf("save chunk: %w", err)
+	}
+
+	sum := hex.EncodeToString(hasher.Sum(nil))
+	switch {
+	case size == 0:
+		err = fmt.Errorf("%w: empty chunk", domain.ErrInvalidInput)
+	case size > remaining:
+		err = fmt.Errorf("%w: chunk exceeds %d bytes", domain.ErrInvalidInput, remaining)
+	case checksum != "" && !strings.EqualFold(checksum, sum):
+		err = domain.ErrChecksumMismatch
+	}
+	if err != nil {
+		s.store.Delete(path)
+		return session.Offset, err
+	}
+
+	chunk := &domain.UploadChunk{
+		SessionID:      session.ID,
+		Offset:         offset,
+		Size:           size,
+		ChecksumSHA256: sum,
+		StoragePath:    path,
+	}
+	if err := s.repo.AddChunk(ctx, orgID, chunk, time.Now().Add(s.ttl)); err != nil {
+		s.store.Delete(path)
+		if errors.Is(err, domain.ErrConflict) {
+			return session.Offset, fmt.Errorf("%w: concurrent write at offset %d", domain.ErrConflict, offset)
+		}
+		return session.Offset, err
+	}
+
+	return offset + size, nil
+}
+
+// Complete assembles the chunks into an artifact and removes the session.
+// The session is kept when the artifact cannot be created, until it expires.
+func (s *UploadService) Complete(ctx context.Context, orgID, id uuid.UUID) (*domain.Artifact, error) {
+	session, err := s.repo.GetByID(ctx, orgID, id)
+	if err != nil {
+		return nil, err
+	}
+	if session.Offset != session.Size {
+		return nil, fmt.Errorf("%w: upload incomplete, %d of %d bytes received", domain.ErrInvalidInput, session.Offset, session.Size)
+	}
+
+	younger, err := s.reservedAfter(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := s.repo.ListChunks(ctx, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	file := &chunkReader{store: s.store, chunks: chunks}
+	defer file.Close()
+
+	m := session.Metadata
+	artifact, err := s.artifacts.Create(ctx, CreateArtifactInput{
+		OrgID:            orgID,
+		Kind:             m.Kind,
+		Name:             m.Name,
+		Version:          m.Version,
+		Description:      m.Description,
+		FileName:         m.FileName,
+		TargetPath:       m.TargetPath,
+		FileMode:         m.FileMode,
+		FileOwner:        m.FileOwner,
+		DeviceTypes:      m.DeviceTypes,
+		PreInstallCmd:    m.PreInstallCmd,
+		PostInstallCmd:   m.PostInstallCmd,
+		RollbackCmd:      m.RollbackCmd,
+		Signature:        m.Signature,
+		SigningKeyID:     m.SigningKeyID,
+		ChecksumSHA256:   session.ChecksumSHA256,
+		EncryptForDevice: m.EncryptForDevice,
+		Requirements:     m.Requirements,
+		File:             file,
+		Size:             session.Size,
+		// The reservation goes once the artifact is created, and younger
+		// sessions wait for this one
+		reserved: session.Size + younger,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.remove(ctx, session, chunks)
+	return artifact, nil
+}
+
+// checkQuota fails with ErrQuotaExceeded if session does not fit in the
+// quota with the stored files and the sessions created before it. Sessions
+// created at the same time may each have passed CreateSession; the oldest
+// then go on and the others fail until there is room.
+func (s *UploadService) checkQuota(ctx context.Context, session *domain.UploadSession) error {
+	younger, err := s.reservedAfter(ctx, session)
+	if err != nil {
+		return err
+	}
+	return s.artifacts.checkQuota(ctx, -younger)
+}
+
+// reservedAfter returns the size the sessions created after session reserve.
+func (s *UploadService) reservedAfter(ctx context.Context, session *domain.UploadSession) (int64, error) {
+	if s.artifacts.quota == 0 {
+		return 0, nil
+	}
+	return s.repo.ReservedAfter(ctx, session)
+}
+
+// Abort discards a session and its chunks.
+func (s *UploadService) Abort(ctx context.Context, orgID, id uuid.UUID) error {
+	session, err := s.repo.GetByID(ctx, orgID, id)
+	if err != nil {
+		return err
+	}
+	chunks, err := s.repo.ListChunks(ctx, session.ID)
+	if err != nil {
+		return err
+	}
+	s.remove(ctx, session, chunks)
+	return nil
+}
+
+// PurgeExpired removes sessions that expired and returns how many.
+func (s *UploadService) PurgeExpired(ctx context.Context) int {
+	sessions, err := s.repo.ListExpired(ctx, time.Now())
+	if err != nil {
+		s.log.Warn("failed to list expired upload sessions", "err", err)
+		return 0
+	}
+
+	purged := 0
+	for _, session := range sessions {
+		chunks, err := s.repo.ListChunks(ctx, session.ID)
+		if err != nil {
+			s.log.Warn("failed to list upload chunks", "session", session.ID, "err", err)
+			continue
+		}
+		s.remove(ctx, session, chunks)
+		purged++
+	}
+	return purged
+}
+
+func (s *UploadService) remove(ctx context.Context, session *domain.UploadSession, chunks []*domain.UploadChunk) {
+	if err := s.repo.Delete(ctx, session.OrgID, session.ID); err != nil && !errors.Is(err, domain.ErrNotFound) {
+		s.log.Warn("failed to delete upload session", "id", session.ID, "err", err)
+	}
+	for _, c := range chunks {
+		if err := s.store.Delete(c.StoragePath); err != nil {
+			s.log.Warn("failed to delete upload chunk", "path", c.StoragePath, "err", err)
+		}
+	}
+}
+
+// chunkReader reads the chunks of a session in order, opening one file at a
+// time.
+type chunkReader struct {
+	store  storage.FileStore
+	chunks []*domain.UploadChunk
+	cur    io.ReadCloser
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if c.cur == nil {
+			if len(c.chunks) == 0 {
+				return 0, io.EOF
+			}
+			f, err := c.store.Open(c.chunks[0].StoragePath)
+			if err != nil {
+				return 0, fmt.Errorf("open chunk: %w", err)
+			}
+			c.cur = f
+			c.chunks = c.chunks[1:]
+		}
+
+		n, err := c.cur.Read(p)
+		if err == io.EOF {
+			c.cur.Close()
+			c.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *chunkReader) Close() error {
+	if c.cur != nil {
+		return c.cur.Close()
+	}
+	return nil
+}
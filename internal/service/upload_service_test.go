@@ -0,0 +1,301 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/CaioWing/Harbor/internal/archive"
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+func newTestUploadService(ttl time.Duration) (*UploadService, *mockUploadSessionRepo, *mockArtifactRepo, *mockFileStore) {
+	artRepo := newMockArtifactRepo()
+	store := newMockFileStore()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	signing := NewSigningService(newMockSigningKeyRepo(), nil, log)
+	artifacts := NewArtifactService(artRepo, newMockBlobRepo(), store, signing, newDisabledDeltaService(artRepo, store, log), NewValidationService(store, ValidationConfig{}, log), archive.Limits{}, 0, log)
+	repo := newMockUploadSessionRepo()
+	return NewUploadService(repo, artifacts, store, ttl, log), repo, artRepo, store
+}
+
+func createTestUpload(t *testing.T, svc *UploadService, content []byte) *domain.UploadSession {
+	t.Helper()
+	sum := sha256.Sum256(content)
+	session, err := svc.CreateSession(context.Background(), CreateUploadInput{
+		OrgID: testOrgID,
+		Metadata: domain.UploadMetadata{
+			Name:        "myapp",
+			Version:     "1.0.0",
+			FileName:    "myapp",
+			TargetPath:  "/usr/local/bin/myapp",
+			DeviceTypes: []string{"raspberry-pi-4"},
+		},
+		Size:           int64(len(content)),
+		ChecksumSHA256: hex.EncodeToString(sum[:]),
+	})
+	if err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	return session
+}
+
+func chunkSum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestUpload_ChunksThenComplete(t *testing.T) {
+	svc, repo, _, store := newTestUploadService(time.Hour)
+	ctx := context.Background()
+	content := []byte("0123456789abcdefghij")
+	session := createTestUpload(t, svc, content)
+
+	offset := int64(0)
+	for _, part := range [][]byte{content[:7], content[7:15], content[15:]} {
+		next, err := svc.WriteChunk(ctx, testOrgID, session.ID, offset, chunkSum(part), bytes.NewReader(part))
+		if err != nil {
+			t.Fatalf("write chunk at %d: %v", offset, err)
+		}
+		offset = next
+	}
+	if offset != int64(len(content)) {
+		t.Fatalf("expected offset %d, got %d", len(content), offset)
+	}
+
+	artifact, err := svc.Complete(ctx, testOrgID, session.ID)
+	if err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if artifact.FileSize != int64(len(content)) || artifact.ChecksumSHA256 != chunkSum(content) {
+		t.Errorf("unexpected artifact size %d checksum %s", artifact.FileSize, artifact.ChecksumSHA256)
+	}
+	if !bytes.Equal(store.files[artifact.StoragePath], content) {
+		t.Error("stored artifact does not match uploaded content")
+	}
+
+	if _, err := repo.GetByID(ctx, testOrgID, session.ID); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("expected session removed, got %v", err)
+	}
+	if len(store.files) != 1 {
+		t.Errorf("expected only the artifact file left, got %d files", len(store.files))
+	}
+}
+
+func TestUpload_WrongOffsetConflicts(t *testing.T) {
+	svc, _, _, _ := newTestUploadService(time.Hour)
+	ctx := context.Background()
+	content := []byte("0123456789")
+	session := createTestUpload(t, svc, content)
+
+	if _, err := svc.WriteChunk(ctx, testOrgID, session.ID, 0, "", bytes.NewReader(content[:4])); err != nil {
+		t.Fatalf("write chunk: %v", err)
+	}
+
+	// Replaying the first chunk, as after a lost response, reports where to resume
+	current, err := svc.WriteChunk(ctx, testOrgID, session.ID, 0, "", bytes.NewReader(content[:4]))
+	if !errors.Is(err, domain.ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	if current != 4 {
+		t.Errorf("expected current offset 4, got %d", current)
+	}
+}
+
+func TestUpload_ChunkChecksumMismatch(t *testing.T) {
+	svc, _, _, store := newTestUploadService(time.Hour)
+	ctx := context.Background()
+	content := []byte("0123456789")
+	session := createTestUpload(t, svc, content)
+
+	_, err := svc.WriteChunk(ctx, testOrgID, session.ID, 0, chunkSum([]byte("other")), bytes.NewReader(content[:5]))
+	if !errors.Is(err, domain.ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+
+	got, _ := svc.GetSession(ctx, testOrgID, session.ID)
+	if got.Offset != 0 {
+		t.Errorf("expected offset to stay 0, got %d", got.Offset)
+	}
+	if len(store.files) != 0 {
+		t.Errorf("expected rejected chunk to be deleted, got %d files", len(store.files))
+	}
+}
+
+func TestUpload_ChunkPastSize(t *testing.T) {
+	svc, _, _, store := newTestUploadService(time.Hour)
+	content := []byte("0123456789")
+	session := createTestUpload(t, svc, content)
+
+	_, err := svc.WriteChunk(context.Background(), testOrgID, session.ID, 0, "", bytes.NewReader(append(content, 'x')))
+	if !errors.Is(err, domain.ErrInvalidInput) {
+		t.Fatalf("expected ErrInvalidInput, got %v", err)
+	}
+	if len(store.files) != 0 {
+		t.Errorf("expected rejected chunk to be deleted, got %d files", len(store.files))
+	}
+}
+
+func TestUpload_CompleteIncomplete(t *testing.T) {
+	svc, _, _, _ := newTestUploadService(time.Hour)
+	ctx := context.Background()
+	content := []byte("0123456789")
+	session := createTestUpload(t, svc, content)
+
+	if _, err := svc.WriteChunk(ctx, testOrgID, session.ID, 0, "", bytes.NewReader(content[:5])); err != nil {
+		t.Fatalf("write chunk: %v", err)
+	}
+	if _, err := svc.Complete(ctx, testOrgID, session.ID); !errors.Is(err, domain.ErrInvalidInput) {
+		t.Fatalf("expected ErrInvalidInput, got %v", err)
+	}
+}
+
+func TestUpload_CompleteChecksumMismatch(t *testing.T) {
+	svc, _, artRepo, _ := newTestUploadService(time.Hour)
+	ctx := context.Background()
+	session, err := svc.CreateSession(ctx, CreateUploadInput{
+		OrgID: testOrgID,
+		Metadata: domain.UploadMetadata{
+			Name: "myapp", Version: "1.0.0", FileName: "myapp",
+			TargetPath: "/usr/local/bin/myapp", DeviceTypes: []string{"raspberry-pi-4"},
+		},
+		Size:           4,
+		ChecksumSHA256: chunkSum([]byte("abcd")),
+	})
+	if err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	if _, err := svc.WriteChunk(ctx, testOrgID, session.ID, 0, "", bytes.NewReader([]byte("abce"))); err != nil {
+		t.Fatalf("write chunk: %v", err)
+	}
+
+	if _, err := svc.Complete(ctx, testOrgID, session.ID); !errors.Is(err, domain.ErrInvalidInput) {
+		t.Fatalf("expected ErrInvalidInput, got %v", err)
+	}
+	if len(artRepo.artifacts) != 0 {
+		t.Error("expected no artifact to be created")
+	}
+}
+
+func TestUpload_CreateValidation(t *testing.T) {
+	svc, _, _, _ := newTestUploadService(time.Hour)
+	_, err := svc.CreateSession(context.Background(), CreateUploadInput{
+		OrgID: testOrgID,
+		Metadata: domain.UploadMetadata{
+			Name: "myapp", Version: "1.0.0", FileName: "myapp",
+			TargetPath: "/usr/local/bin/myapp", DeviceTypes: []string{"raspberry-pi-4"},
+		},
+	})
+	if !errors.Is(err, domain.ErrInvalidInput) {
+		t.Fatalf("expected ErrInvalidInput for missing size, got %v", err)
+	}
+}
+
+func TestUpload_OtherOrganization(t *testing.T) {
+	svc, _, _, _ := newTestUploadService(time.Hour)
+	session := createTestUpload(t, svc, []byte("0123456789"))
+
+	_, err := svc.WriteChunk(context.Background(), uuid.New(), session.ID, 0, "", bytes.NewReader([]byte("0")))
+	if !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestUpload_Abort(t *testing.T) {
+	svc, repo, _, store := newTestUploadService(time.Hour)
+	ctx := context.Background()
+	content := []byte("0123456789")
+	session := createTestUpload(t, svc, content)
+
+	if _, err := svc.WriteChunk(ctx, testOrgID, session.ID, 0, "", bytes.NewReader(content[:5])); err != nil {
+		t.Fatalf("write chunk: %v", err)
+	}
+	if err := svc.Abort(ctx, testOrgID, session.ID); err != nil {
+		t.Fatalf("abort: %v", err)
+	}
+	if len(repo.sessions) != 0 || len(store.files) != 0 {
+		t.Errorf("expected session and chunks removed, got %d sessions %d files", len(repo.sessions), len(store.files))
+	}
+}
+
+func TestUpload_PurgeExpired(t *testing.T) {
+	svc, repo, _, store := newTestUploadService(time.Hour)
+	ctx := context.Background()
+	content := []byte("0123456789")
+
+	stale := createTestUpload(t, svc, content)
+	if _, err := svc.WriteChunk(ctx, testOrgID, stale.ID, 0, "", bytes.NewReader(content[:5])); err != nil {
+		t.Fatalf("write chunk: %v", err)
+	}
+	repo.sessions[stale.ID].ExpiresAt = time.Now().Add(-time.Minute)
+	active := createTestUpload(t, svc, content)
+
+	if n := svc.PurgeExpired(ctx); n != 1 {
+		t.Fatalf("expected 1 purged session, got %d", n)
+	}
+	if _, ok := repo.sessions[stale.ID]; ok {
+		t.Error("expected expired session to be removed")
+	}
+	if _, ok := repo.sessions[active.ID]; !ok {
+		t.Error("expected active session to be kept")
+	}
+	if len(store.files) != 0 {
+		t.Errorf("expected expired chunks to be deleted, got %d files", len(store.files))
+	}
+}
+
+func TestUpload_ConcurrentSessionsOverQuota(t *testing.T) {
+	artRepo := newMockArtifactRepo()
+	store := newMockFileStore()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	repo := newMockUploadSessionRepo()
+	blobs := newMockBlobRepo()
+	blobs.sessions = repo
+	artifacts := NewArtifactService(artRepo, blobs, store, NewSigningService(newMockSigningKeyRepo(), nil, log),
+		newDisabledDeltaService(artRepo, store, log), NewValidationService(store, ValidationConfig{}, log), archive.Limits{}, 100, log)
+	svc := NewUploadService(repo, artifacts, store, time.Hour, log)
+	ctx := context.Background()
+
+	first := bytes.Repeat([]byte("a"), 60)
+	second := bytes.Repeat([]byte("b"), 60)
+	older := createTestUpload(t, svc, first)
+
+	// The reservation of the first session leaves no room for the second
+	_, err := svc.CreateSession(ctx, CreateUploadInput{OrgID: testOrgID, Metadata: older.Metadata, Size: int64(len(second))})
+	if !errors.Is(err, domain.ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded for a second session, got %v", err)
+	}
+
+	// As if both had passed CreateSession at the same time
+	metadata := older.Metadata
+	metadata.Version = "1.0.1"
+	younger := &domain.UploadSession{OrgID: testOrgID, Metadata: metadata, Size: int64(len(second)), ExpiresAt: time.Now().Add(time.Hour)}
+	repo.Create(ctx, younger)
+
+	if _, err := svc.WriteChunk(ctx, testOrgID, younger.ID, 0, "", bytes.NewReader(second)); !errors.Is(err, domain.ErrQuotaExceeded) {
+		t.Fatalf("expected the younger session to be refused, got %v", err)
+	}
+	if _, err := svc.WriteChunk(ctx, testOrgID, older.ID, 0, "", bytes.NewReader(first)); err != nil {
+		t.Fatalf("write chunk: %v", err)
+	}
+	if _, err := svc.Complete(ctx, testOrgID, older.ID); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if _, err := svc.WriteChunk(ctx, testOrgID, younger.ID, 0, "", bytes.NewReader(second)); !errors.Is(err, domain.ErrQuotaExceeded) {
+		t.Fatalf("expected the younger session to still not fit, got %v", err)
+	}
+
+	svc.Abort(ctx, testOrgID, younger.ID)
+	used, quota, _ := artifacts.StorageUsage(ctx)
+	if used != int64(len(first)) || used > quota {
+		t.Errorf("expected only the first upload stored within the quota, got %d of %d bytes", used, quota)
+	}
+}
@@ -0,0 +1,320 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/CaioWing/Harbor/internal/auth"
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+const (
+	totpIssuer        = "Harbor"
+	recoveryCodeCount = 10
+)
+
+type UserService struct {
+	repo     domain.UserRepository
+	settings domain.SettingsRepository
+	log      *slog.Logger
+}
+
+func NewUserService(repo domain.UserRepository, settings domain.SettingsRepository, log *slog.Logger) *UserService {
+	return &UserService{repo: repo, settings: settings, log: log}
+}
+
+type CreateUserInput struct {
+	Email    string
+	Password string
+	Role     domain.Role
+}
+
+func (s *UserService) Create(ctx context.Context, input CreateUserInput) (*domain.User, error) {
+	input.Email = strings.TrimSpace(input.Email)
+	if input.Email == "" || input.Password == "" {
+		return nil, fmt.Errorf("%w: email and password are required", domain.ErrInvalidInput)
+	}
+	if input.Role == "" {
+		input.Role = domain.RoleViewer
+	}
+	if !input.Role.Valid() {
+		return nil, fmt.Errorf("%w: invalid role %q", domain.ErrInvalidInput, input.Role)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("hash password: %w", err)
+	}
+
+	user := &domain.User{
+		Email:        input.Email,
+		PasswordHash: string(hash),
+		Role:         input.Role,
+	}
+	if err := s.repo.Create(ctx, user); err != nil {
+		return nil, fmt.Errorf("create user: %w", err)
+	}
+
+	s.log.Info("user created", "id", user.ID, "role", user.Role)
+	return user, nil
+}
+
+// EnsureAdmin creates the bootstrap admin account when no users exist yet.
+func (s *UserService) EnsureAdmin(ctx context.Context, email, password string) error {
+	n, err := s.repo.Count(ctx)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+
+	_, err = s.Create(ctx, CreateUserInput{Email: email, Password: password, Role: domain.RoleAdmin})
+	if err != nil {
+		// Another instance may have created it concurrently
+		if errors.Is(err, domain.ErrConflict) {
+			return nil
+		}
+		return err
+	}
+	s.log.Info("bootstrap admin user created", "email", email)
+	return nil
+}
+
+func (s *UserService) GetByID(ctx context.Context, id uuid.UUID) (*domain.User, error) {
+	return s.repo.GetByID(ctx, id)
+}
+
+func (s *UserService) List(ctx context.Context) ([]*domain.User, error) {
+	return s.repo.List(ctx)
+}
+
+func (s *UserService) UpdateRole(ctx context.Context, id uuid.UUID, role domain.Role) error {
+	if !role.Valid() {
+		return fmt.Errorf("%w: invalid role %q", domain.ErrInvalidInput, role)
+	}
+	return s.repo.UpdateRole(ctx, id, role)
+}
+
+func (s *UserService) Delete(ctx context.Context, id uuid.UUID) error {
+	return s.repo.Delete(ctx, id)
+}
+
+// TOTPEnrolment is returned when a user starts TOTP enrolment. The URI is
+// meant to be rendered as a QR code by the frontend.
+type TOTPEnrolment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// BeginTOTPEnrolment generates a new secret for the user. TOTP is not
+// enforced until the enrolment is confirmed with a valid code.
+func (s *UserService) BeginTOTPEnrolment(ctx context.Context, userID uuid.UUID) (*TOTPEnrolment, error) {
+	user, err := s.repo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, fmt.Errorf("%w: two-factor authentication is already enabled", domain.ErrConflict)
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.UpdateTOTP(ctx, userID, secret, false, nil); err != nil {
+		return nil, err
+	}
+
+	return &TOTPEnrolment{
+		Secret:          secret,
+		ProvisioningURI: auth.TOTPProvisioningURI(totpIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTPEnrolment enables TOTP once the user proves their authenticator
+// produces valid codes, and returns a fresh set of recovery codes.
+func (s *UserService) ConfirmTOTPEnrolment(ctx context.Context, userID uuid.UUID, code string) ([]string, error) {
+	user, err := s.repo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, fmt.Errorf("%w: two-factor authentication is already enabled", domain.ErrConflict)
+	}
+	if user.TOTPSecret == "" {
+		return nil, fmt.Errorf("%w: enrolment has not been started", domain.ErrInvalidInput)
+	}
+	if _, ok := auth.ValidateTOTP(user.TOTPSecret, code, time.Now()); !ok {
+		return nil, fmt.Errorf("%w: invalid two-factor code", domain.ErrInvalidInput)
+	}
+
+	codes, hashes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.UpdateTOTP(ctx, userID, user.TOTPSecret, true, hashes); err != nil {
+		return nil, err
+	}
+
+	s.log.Info("totp enabled", "user", userID)
+	return codes, nil
+}
+
+// DisableTOTP turns off TOTP for the user after verifying a current code.
+// It is refused when the security policy requires TOTP for the user's role.
+func (s *UserService) DisableTOTP(ctx context.Context, userID uuid.UUID, code string) error {
+	user, err := s.repo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !user.TOTPEnabled {
+		return fmt.Errorf("%w: two-factor authentication is not enabled", domain.ErrInvalidInput)
+	}
+
+	policy, err := s.settings.GetSecurityPolicy(ctx)
+	if err != nil {
+		return err
+	}
+	if policy.RequiresTOTP(user.Role) {
+		return fmt.Errorf("%w: two-factor authentication is required for role %s", domain.ErrForbidden, user.Role)
+	}
+
+	if err := verifySecondFactor(ctx, s.repo, user, code, ""); err != nil {
+		return err
+	}
+	if err := s.repo.UpdateTOTP(ctx, userID, "", false, nil); err != nil {
+		return err
+	}
+
+	s.log.Info("totp disabled", "user", userID)
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after verifying a
+// current TOTP code.
+func (s *UserService) RegenerateRecoveryCodes(ctx context.Context, userID uuid.UUID, code string) ([]string, error) {
+	user, err := s.repo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.TOTPEnabled {
+		return nil, fmt.Errorf("%w: two-factor authentication is not enabled", domain.ErrInvalidInput)
+	}
+	if err := verifySecondFactor(ctx, s.repo, user, code, ""); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.UpdateTOTP(ctx, userID, user.TOTPSecret, true, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// ResetTOTP is the admin escape hatch for users who lost their authenticator.
+func (s *UserService) ResetTOTP(ctx context.Context, userID uuid.UUID) error {
+	if err := s.repo.UpdateTOTP(ctx, userID, "", false, nil); err != nil {
+		return err
+	}
+	s.log.Info("totp reset by admin", "user", userID)
+	return nil
+}
+
+func (s *UserService) GetSecurityPolicy(ctx context.Context) (*domain.SecurityPolicy, error) {
+	return s.settings.GetSecurityPolicy(ctx)
+}
+
+func (s *UserService) UpdateSecurityPolicy(ctx context.Context, policy *domain.SecurityPolicy) error {
+	if err := s.settings.UpdateSecurityPolicy(ctx, policy); err != nil {
+		return err
+	}
+	s.log.Info("security policy updated", "require_totp_for_deployers", policy.RequireTOTPForDeployers)
+	return nil
+}
+
+const (
+	// totpFreeFailures failed second-factor attempts are allowed before the
+	// second factor locks, for totpBaseLock after the next failure and twice
+	// as long after each further one, up to totpMaxLock.
+	totpFreeFailures = 5
+	totpBaseLock     = 30 * time.Second
+	totpMaxLock      = time.Hour
+)
+
+// totpLockedUntil returns when the second factor of user unlocks, which is
+// in the past unless it has failed too often.
+func totpLockedUntil(user *domain.User) time.Time {
+	if user.TOTPFailures < totpFreeFailures || user.TOTPFailedAt == nil {
+		return time.Time{}
+	}
+	lock := totpMaxLock
+	if n := user.TOTPFailures - totpFreeFailures; n < 8 {
+		lock = min(totpBaseLock<<n, totpMaxLock)
+	}
+	return user.TOTPFailedAt.Add(lock)
+}
+
+// verifySecondFactor checks a TOTP code or, failing that, a recovery code.
+// Both are single-use: a TOTP step cannot be replayed and a recovery code is
+// removed once consumed. Failed attempts are counted, and once there are too
+// many the second factor is refused with ErrTOTPLocked for a while.
+func verifySecondFactor(ctx context.Context, repo domain.UserRepository, user *domain.User, totpCode, recoveryCode string) error {
+	if totpCode == "" && recoveryCode == "" {
+		return domain.ErrTOTPRequired
+	}
+	if until := totpLockedUntil(user); time.Now().Before(until) {
+		return fmt.Errorf("%w: retry after %s", domain.ErrTOTPLocked, until.UTC().Format(time.RFC3339))
+	}
+
+	err := checkSecondFactor(ctx, repo, user, totpCode, recoveryCode)
+	switch {
+	case errors.Is(err, domain.ErrUnauthorized):
+		if recordErr := repo.RecordTOTPFailure(ctx, user.ID); recordErr != nil {
+			return recordErr
+		}
+		return err
+	case err != nil:
+		return err
+	}
+	if user.TOTPFailures > 0 {
+		return repo.ResetTOTPFailures(ctx, user.ID)
+	}
+	return nil
+}
+
+func checkSecondFactor(ctx context.Context, repo domain.UserRepository, user *domain.User, totpCode, recoveryCode string) error {
+	if totpCode != "" {
+		step, ok := auth.ValidateTOTP(user.TOTPSecret, totpCode, time.Now())
+		if !ok {
+			return fmt.Errorf("%w: invalid two-factor code", domain.ErrUnauthorized)
+		}
+		fresh, err := repo.AdvanceTOTPStep(ctx, user.ID, step)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return fmt.Errorf("%w: two-factor code already used", domain.ErrUnauthorized)
+		}
+		return nil
+	}
+
+	ok, err := repo.ConsumeRecoveryCode(ctx, user.ID, auth.HashRecoveryCode(recoveryCode))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: invalid recovery code", domain.ErrUnauthorized)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/CaioWing/Harbor/internal/auth"
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+func TestUserCreate_DefaultsToViewer(t *testing.T) {
+	env := newAuthTestEnv()
+
+	user, err := env.users.Create(context.Background(), CreateUserInput{Email: "ops@harbor.local", Password: "pw"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if user.Role != domain.RoleViewer {
+		t.Fatalf("expected viewer role, got %s", user.Role)
+	}
+}
+
+func TestUserCreate_InvalidRole(t *testing.T) {
+	env := newAuthTestEnv()
+
+	_, err := env.users.Create(context.Background(), CreateUserInput{Email: "x@harbor.local", Password: "pw", Role: "root"})
+	if !errors.Is(err, domain.ErrInvalidInput) {
+		t.Fatalf("expected ErrInvalidInput, got %v", err)
+	}
+}
+
+func TestUserEnsureAdmin_OnlyWhenEmpty(t *testing.T) {
+	env := newAuthTestEnv()
+	ctx := context.Background()
+
+	if err := env.users.EnsureAdmin(ctx, "other@harbor.local", "pw"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n, _ := env.userRepo.Count(ctx); n != 1 {
+		t.Fatalf("expected no extra admin to be created, got %d users", n)
+	}
+}
+
+func TestUserConfirmTOTP_InvalidCode(t *testing.T) {
+	env := newAuthTestEnv()
+	ctx := context.Background()
+
+	if _, err := env.users.BeginTOTPEnrolment(ctx, env.admin.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := env.users.ConfirmTOTPEnrolment(ctx, env.admin.ID, "000000"); !errors.Is(err, domain.ErrInvalidInput) {
+		t.Fatalf("expected ErrInvalidInput, got %v", err)
+	}
+
+	user, _ := env.userRepo.GetByID(ctx, env.admin.ID)
+	if user.TOTPEnabled {
+		t.Fatal("expected TOTP to stay disabled until confirmed")
+	}
+}
+
+func TestUserBeginTOTP_AlreadyEnabled(t *testing.T) {
+	env := newAuthTestEnv()
+	env.enrolTOTP(t, env.admin.ID)
+
+	if _, err := env.users.BeginTOTPEnrolment(context.Background(), env.admin.ID); !errors.Is(err, domain.ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+}
+
+func TestUserDisableTOTP_BlockedByPolicy(t *testing.T) {
+	env := newAuthTestEnv()
+	ctx := context.Background()
+	secret, _ := env.enrolTOTP(t, env.admin.ID)
+	env.settings.policy.RequireTOTPForDeployers = true
+
+	code, _ := auth.TOTPCode(secret, time.Now().Add(30*time.Second))
+	if err := env.users.DisableTOTP(ctx, env.admin.ID, code); !errors.Is(err, domain.ErrForbidden) {
+		t.Fatalf("expected ErrForbidden, got %v", err)
+	}
+
+	env.settings.policy.RequireTOTPForDeployers = false
+	if err := env.users.DisableTOTP(ctx, env.admin.ID, code); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	user, _ := env.userRepo.GetByID(ctx, env.admin.ID)
+	if user.TOTPEnabled || user.TOTPSecret != "" {
+		t.Fatal("expected TOTP to be disabled and the secret cleared")
+	}
+}
+
+func TestUserResetTOTP(t *testing.T) {
+	env := newAuthTestEnv()
+	ctx := context.Background()
+	env.enrolTOTP(t, env.admin.ID)
+
+	if err := env.users.ResetTOTP(ctx, env.admin.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := env.svc.Login(ctx, LoginInput{Email: "admin@harbor.local", Password: "admin"}); err != nil {
+		t.Fatalf("expected password-only login after reset, got %v", err)
+	}
+}
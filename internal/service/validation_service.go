@@ -0,0 +1,351 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/CaioWing/Harbor/internal/domain"
+	"github.com/CaioWing/Harbor/internal/storage"
+	"github.com/CaioWing/Harbor/internal/validate"
+)
+
+// ValidationConfig sets the checks run on uploaded artifacts besides the
+// built-in ones, which always run where they apply.
+type ValidationConfig struct {
+	// DeviceTypes holds the limits of each device type. Types without an
+	// entry have none.
+	DeviceTypes map[string]DeviceTypeLimits
+	// Validators run, in order, on every file of every artifact
+	Validators []ExternalValidator
+}
+
+// DeviceTypeLimits are checked against the artifacts declaring the device
+// type.
+type DeviceTypeLimits struct {
+	// Arch lists the architectures ELF binaries may be built for; empty
+	// accepts any
+	Arch []string
+	// MaxSize bounds the size of the artifact, in bytes; 0 disables it
+	MaxSize int64
+}
+
+// ExternalValidator is an executable run with the path of a local copy of
+// the file as its argument. It passes the file by exiting with status 0.
+type ExternalValidator struct {
+	Name string
+	Path string
+	// Blocking keeps artifacts it fails, or could not check, from being
+	// deployed
+	Blocking bool
+	// Timeout defaults to defaultValidatorTimeout
+	Timeout time.Duration
+}
+
+const (
+	defaultValidatorTimeout = time.Minute
+	// maxConfigCheckSize bounds the configuration files whose syntax is
+	// checked, as they are read in memory
+	maxConfigCheckSize = 16 << 20
+)
+
+// ValidationService runs the upload checks of artifacts: the size limit of
+// their device types, the architecture of ELF binaries, the syntax of shell
+// scripts and of JSON and YAML files, and the external validators.
+type ValidationService struct {
+	store storage.FileStore
+	cfg   ValidationConfig
+	log   *slog.Logger
+}
+
+func NewValidationService(store storage.FileStore, cfg ValidationConfig, log *slog.Logger) *ValidationService {
+	return &ValidationService{store: store, cfg: cfg, log: log}
+}
+
+// validationFile is a stored file of an artifact to check.
+type validationFile struct {
+	name        string
+	targetPath  string
+	storagePath string
+}
+
+// Validate runs the checks that apply to artifact, whose files are stored,
+// and returns their results. Failed built-in checks are blocking: they keep
+// the artifact from being deployed, not from being created. An error is
+// returned only when the files could not be read.
+func (s *ValidationService) Validate(ctx context.Context, artifact *domain.Artifact) ([]domain.ValidationResult, error) {
+	results := s.checkSize(artifact)
+	if artifact.IsOperation() {
+		return results, nil
+	}
+
+	files := []validationFile{{name: artifact.FileName, targetPath: artifact.TargetPath, storagePath: artifact.StoragePath}}
+	if artifact.IsBundle() {
+		files = files[:0]
+		for _, f := range artifact.Files {
+			files = append(files, validationFile{name: f.FileName, targetPath: f.TargetPath, storagePath: f.StoragePath})
+		}
+	}
+	for _, f := range files {
+		fileResults, err := s.validateFile(ctx, artifact, f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.name, err)
+		}
+		results = append(results, fileResults...)
+	}
+
+	for _, r := range results {
+		if r.Blocking {
+			s.log.Warn("artifact failed validation", "name", artifact.Name, "version", artifact.Version, "result", r.String())
+		}
+	}
+	return results, nil
+}
+
+// checkSize checks the artifact against the size limit of each of its
+// device types.
+func (s *ValidationService) checkSize(artifact *domain.Artifact) []domain.ValidationResult {
+	var limited []string
+	for _, dt := range artifact.DeviceTypes {
+		limit := s.cfg.DeviceTypes[dt].MaxSize
+		if limit <= 0 {
+			continue
+		}
+		if artifact.FileSize > limit {
+			return []domain.ValidationResult{{
+				Check:    domain.ValidationCheckMaxSize,
+				Status:   domain.ValidationFailed,
+				Blocking: true,
+				Detail:   fmt.Sprintf("%d bytes exceed the %d allowed for %s", artifact.FileSize, limit, dt),
+			}}
+		}
+		limited = append(limited, dt)
+	}
+	if len(limited) == 0 {
+		return nil
+	}
+	return []domain.ValidationResult{{Check: domain.ValidationCheckMaxSize, Status: domain.ValidationPassed}}
+}
+
+// validateFile copies a stored file to a temporary directory, under its own
+// name so that validators can tell its type, and checks it.
+func (s *ValidationService) validateFile(ctx context.Context, artifact *domain.Artifact, f validationFile) ([]domain.ValidationResult, error) {
+	dir, err := os.MkdirTemp("", "harbor-validate-")
+	if err != nil {
+		return nil, fmt.Errorf("create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Base(f.name)
+	if name == "." || name == string(filepath.Separator) {
+		name = "artifact"
+	}
+	local := filepath.Join(dir, name)
+	if err := s.copyLocal(f.storagePath, local); err != nil {
+		return nil, err
+	}
+	// Outputs mention the temporary copy; report the file name instead
+	clean := func(output string) string { return strings.ReplaceAll(output, local, name) }
+
+	var results []domain.ValidationResult
+	add := func(r domain.ValidationResult) {
+		if artifact.IsBundle() {
+			r.File = f.targetPath
+		}
+		r.Detail = clean(r.Detail)
+		results = append(results, r)
+	}
+
+	switch artifact.Kind {
+	case domain.ArtifactKindFile, domain.ArtifactKindBundle, "":
+		if r, ok, err := s.checkELF(artifact, local); err != nil {
+			return nil, err
+		} else if ok {
+			add(r)
+		}
+		if format := validate.ConfigFormat(f.targetPath); format != "" {
+			add(checkConfig(format, local))
+		}
+		if shell := scriptShell(local); shell != "" || path.Ext(f.targetPath) == ".sh" {
+			add(checkShell(ctx, cmp.Or(shell, "sh"), local))
+		}
+	case domain.ArtifactKindScript:
+		if artifact.Script != nil && validate.IsShell(artifact.Script.Interpreter) {
+			add(checkShell(ctx, artifact.Script.Interpreter, local))
+		}
+	}
+
+	env := []string{
+		"HARBOR_ARTIFACT_NAME=" + artifact.Name,
+		"HARBOR_ARTIFACT_VERSION=" + artifact.Version,
+		"HARBOR_ARTIFACT_KIND=" + string(artifact.Kind),
+		"HARBOR_TARGET_PATH=" + f.targetPath,
+		"HARBOR_DEVICE_TYPES=" + strings.Join(artifact.DeviceTypes, ","),
+	}
+	for _, v := range s.cfg.Validators {
+		add(s.runExternal(ctx, v, local, env))
+	}
+	return results, nil
+}
+
+func (s *ValidationService) copyLocal(storagePath, local string) error {
+	reader, err := s.store.Open(storagePath)
+	if err != nil {
+		return fmt.Errorf("open file: %w", err)
+	}
+	defer reader.Close()
+
+	out, err := os.OpenFile(local, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("create temporary file: %w", err)
+	}
+	if _, err := io.Copy(out, reader); err != nil {
+		out.Close()
+		return fmt.Errorf("copy file: %w", err)
+	}
+	return out.Close()
+}
+
+// checkELF compares the architecture of an ELF binary with those of the
+// artifact's device types and requirements. ok is false for other files.
+func (s *ValidationService) checkELF(artifact *domain.Artifact, local string) (r domain.ValidationResult, ok bool, err error) {
+	f, err := os.Open(local)
+	if err != nil {
+		return r, false, err
+	}
+	defer f.Close()
+
+	r.Check = domain.ValidationCheckELFArch
+	arch, err := validate.ELFArch(f)
+	switch {
+	case errors.Is(err, validate.ErrNotELF):
+		return r, false, nil
+	case err != nil:
+		r.Status, r.Blocking, r.Detail = domain.ValidationFailed, true, err.Error()
+		return r, true, nil
+	}
+
+	expected := make(map[string][]string)
+	for _, dt := range artifact.DeviceTypes {
+		if archs := s.cfg.DeviceTypes[dt].Arch; len(archs) > 0 {
+			expected["device type "+dt] = archs
+		}
+	}
+	if artifact.Requirements != nil && len(artifact.Requirements.Arch) > 0 {
+		expected["requirements"] = artifact.Requirements.Arch
+	}
+	sources := make([]string, 0, len(expected))
+	for source := range expected {
+		sources = append(sources, source)
+	}
+	slices.Sort(sources)
+	for _, source := range sources {
+		if !slices.ContainsFunc(expected[source], func(a string) bool { return validate.SameArch(a, arch) }) {
+			r.Status, r.Blocking = domain.ValidationFailed, true
+			r.Detail = fmt.Sprintf("built for %s, %s expects %s", arch, source, strings.Join(expected[source], " or "))
+			return r, true, nil
+		}
+	}
+	r.Status, r.Detail = domain.ValidationPassed, "built for "+arch
+	return r, true, nil
+}
+
+// scriptShell returns the shell named by the #! line of a file, or "".
+func scriptShell(local string) string {
+	f, err := os.Open(local)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	head := make([]byte, 128)
+	n, _ := io.ReadFull(f, head)
+	line, _, _ := strings.Cut(string(head[:n]), "\n")
+	fields := strings.Fields(strings.TrimPrefix(line, "#!"))
+	if !strings.HasPrefix(line, "#!") || len(fields) == 0 {
+		return ""
+	}
+	// #!/usr/bin/env bash
+	if path.Base(fields[0]) == "env" && len(fields) > 1 {
+		fields = fields[1:]
+	}
+	if !validate.IsShell(fields[0]) {
+		return ""
+	}
+	return fields[0]
+}
+
+func checkConfig(format, local string) domain.ValidationResult {
+	r := domain.ValidationResult{Check: domain.ValidationCheckConfig, Detail: format}
+	info, err := os.Stat(local)
+	if err == nil && info.Size() > maxConfigCheckSize {
+		r.Status, r.Detail = domain.ValidationError, fmt.Sprintf("larger than %d bytes, not checked", maxConfigCheckSize)
+		return r
+	}
+	data, err := os.ReadFile(local)
+	if err == nil {
+		err = validate.CheckConfig(format, data)
+	}
+	if err != nil {
+		r.Status, r.Blocking, r.Detail = domain.ValidationFailed, true, fmt.Sprintf("invalid %s: %v", format, err)
+		return r
+	}
+	r.Status = domain.ValidationPassed
+	return r
+}
+
+// checkShell parses a script with shell. A shell the server does not have is
+// reported without blocking the artifact.
+func checkShell(ctx context.Context, shell, local string) domain.ValidationResult {
+	r := domain.ValidationResult{Check: domain.ValidationCheckShell, Detail: path.Base(shell)}
+	var syntax *validate.SyntaxError
+	switch err := validate.ShellSyntax(ctx, shell, local); {
+	case err == nil:
+		r.Status = domain.ValidationPassed
+	case errors.As(err, &syntax):
+		r.Status, r.Blocking, r.Detail = domain.ValidationFailed, true, syntax.Error()
+	default:
+		r.Status, r.Detail = domain.ValidationError, fmt.Sprintf("%s not checked: %v", path.Base(shell), err)
+	}
+	return r
+}
+
+// runExternal runs an external validator. Blocking validators that cannot
+// run block the artifact as failing ones do.
+func (s *ValidationService) runExternal(ctx context.Context, v ExternalValidator, local string, env []string) domain.ValidationResult {
+	timeout := v.Timeout
+	if timeout <= 0 {
+		timeout = defaultValidatorTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	r := domain.ValidationResult{Check: domain.ValidationCheckExternal, Validator: v.Name}
+	passed, output, err := validate.External(ctx, v.Path, local, env)
+	switch {
+	case err != nil:
+		r.Status, r.Blocking = domain.ValidationError, v.Blocking
+		r.Detail = err.Error()
+		if errors.Is(err, context.DeadlineExceeded) {
+			r.Detail = fmt.Sprintf("timed out after %s", timeout)
+		}
+		if output != "" {
+			r.Detail += ": " + output
+		}
+		s.log.Warn("validator did not run", "validator", v.Name, "err", err)
+	case passed:
+		r.Status, r.Detail = domain.ValidationPassed, output
+	default:
+		r.Status, r.Blocking, r.Detail = domain.ValidationFailed, v.Blocking, output
+	}
+	return r
+}
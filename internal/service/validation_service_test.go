@@ -0,0 +1,217 @@
+package service
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/CaioWing/Harbor/internal/archive"
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+func newTestValidatingArtifactService(cfg ValidationConfig) *ArtifactService {
+	repo := newMockArtifactRepo()
+	store := newMockFileStore()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return NewArtifactService(repo, newMockBlobRepo(), store, NewSigningService(newMockSigningKeyRepo(), nil, log),
+		newDisabledDeltaService(repo, store, log), NewValidationService(store, cfg, log), archive.Limits{}, 0, log)
+}
+
+// findResult returns the result of check in artifact, or nil.
+func findResult(artifact *domain.Artifact, check string) *domain.ValidationResult {
+	for i := range artifact.Validation {
+		if artifact.Validation[i].Check == check {
+			return &artifact.Validation[i]
+		}
+	}
+	return nil
+}
+
+func requireShell(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+}
+
+func TestValidation_MaxSize(t *testing.T) {
+	svc := newTestValidatingArtifactService(ValidationConfig{
+		DeviceTypes: map[string]DeviceTypeLimits{"sensor": {MaxSize: 10}},
+	})
+	ctx := context.Background()
+
+	input := CreateArtifactInput{
+		OrgID: testOrgID, Name: "firmware", Version: "1.0.0", FileName: "firmware.bin",
+		TargetPath: "/opt/firmware.bin", DeviceTypes: []string{"raspberry-pi-4", "sensor"},
+		File: strings.NewReader("more than ten bytes"),
+	}
+	artifact, err := svc.Create(ctx, input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r := findResult(artifact, domain.ValidationCheckMaxSize)
+	if r == nil || r.Status != domain.ValidationFailed || !r.Blocking {
+		t.Fatalf("expected a blocking max_size failure, got %+v", artifact.Validation)
+	}
+	if artifact.ValidationBlock() == "" {
+		t.Error("expected the artifact to be blocked")
+	}
+
+	input.Version, input.File = "1.0.1", strings.NewReader("small")
+	if artifact, err = svc.Create(ctx, input); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r := findResult(artifact, domain.ValidationCheckMaxSize); r == nil || r.Status != domain.ValidationPassed {
+		t.Errorf("expected max_size to pass, got %+v", artifact.Validation)
+	}
+}
+
+func TestValidation_ConfigFiles(t *testing.T) {
+	svc := newTestValidatingArtifactService(ValidationConfig{})
+	ctx := context.Background()
+
+	cases := []struct {
+		target, content string
+		valid           bool
+	}{
+		{"/etc/app/config.json", `{"port": 8080}`, true},
+		{"/etc/app/config.json", `{"port": 8080,}`, false},
+		{"/etc/app/config.yaml", "server:\n  port: 8080\n", true},
+		{"/etc/app/config.yml", "server:\n\tport: 8080\n", false},
+	}
+	for i, c := range cases {
+		artifact, err := svc.Create(ctx, CreateArtifactInput{
+			OrgID: testOrgID, Name: "config", Version: "1.0." + string(rune('0'+i)), FileName: filepath.Base(c.target),
+			TargetPath: c.target, DeviceTypes: []string{"raspberry-pi-4"}, File: strings.NewReader(c.content),
+		})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.target, err)
+		}
+		r := findResult(artifact, domain.ValidationCheckConfig)
+		switch {
+		case r == nil:
+			t.Errorf("%s: expected a config_syntax result", c.target)
+		case c.valid && r.Status != domain.ValidationPassed:
+			t.Errorf("%s: expected valid content to pass, got %+v", c.target, r)
+		case !c.valid && (r.Status != domain.ValidationFailed || !r.Blocking):
+			t.Errorf("%s: expected invalid content to block, got %+v", c.target, r)
+		}
+	}
+}
+
+func TestValidation_ShellSyntax(t *testing.T) {
+	requireShell(t)
+	svc := newTestValidatingArtifactService(ValidationConfig{})
+	ctx := context.Background()
+
+	input := CreateArtifactInput{
+		OrgID: testOrgID, Kind: domain.ArtifactKindScript, Name: "rotate-logs", Version: "1.0.0",
+		FileName: "rotate.sh", DeviceTypes: []string{"raspberry-pi-4"},
+		File: strings.NewReader("#!/bin/sh\nif true; then\n  echo rotating\n"),
+	}
+	artifact, err := svc.Create(ctx, input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r := findResult(artifact, domain.ValidationCheckShell); r == nil || r.Status != domain.ValidationFailed || !r.Blocking {
+		t.Fatalf("expected a blocking shell_syntax failure, got %+v", artifact.Validation)
+	}
+
+	// A file artifact installed as a script is checked by its #! line
+	input.Kind, input.Version, input.TargetPath = domain.ArtifactKindFile, "1.0.1", "/usr/local/bin/rotate"
+	input.File = strings.NewReader("#!/usr/bin/env sh\nfor f in *.log; do gzip \"$f\"; done\n")
+	if artifact, err = svc.Create(ctx, input); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r := findResult(artifact, domain.ValidationCheckShell); r == nil || r.Status != domain.ValidationPassed {
+		t.Errorf("expected shell_syntax to pass, got %+v", artifact.Validation)
+	}
+}
+
+func TestValidation_ELFArch(t *testing.T) {
+	exe, err := os.ReadFile(os.Args[0])
+	if err != nil {
+		t.Fatalf("read test binary: %v", err)
+	}
+	if len(exe) < 4 || string(exe[:4]) != "\x7fELF" {
+		t.Skip("the test binary is not an ELF file")
+	}
+	svc := newTestValidatingArtifactService(ValidationConfig{
+		DeviceTypes: map[string]DeviceTypeLimits{"mips-router": {Arch: []string{"mips"}}},
+	})
+	ctx := context.Background()
+
+	input := CreateArtifactInput{
+		OrgID: testOrgID, Name: "agent", Version: "1.0.0", FileName: "agent",
+		TargetPath: "/usr/local/bin/agent", DeviceTypes: []string{"mips-router"},
+		File: strings.NewReader(string(exe)),
+	}
+	artifact, err := svc.Create(ctx, input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r := findResult(artifact, domain.ValidationCheckELFArch); r == nil || r.Status != domain.ValidationFailed || !r.Blocking {
+		t.Fatalf("expected a blocking elf_arch failure, got %+v", artifact.Validation)
+	}
+
+	input.Version, input.DeviceTypes = "1.0.1", []string{"raspberry-pi-4"}
+	input.File = strings.NewReader(string(exe))
+	if artifact, err = svc.Create(ctx, input); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r := findResult(artifact, domain.ValidationCheckELFArch); r == nil || r.Status != domain.ValidationPassed {
+		t.Errorf("expected elf_arch to pass without limits, got %+v", artifact.Validation)
+	}
+}
+
+func TestValidation_ExternalValidators(t *testing.T) {
+	requireShell(t)
+	dir := t.TempDir()
+	validator := filepath.Join(dir, "no-secrets")
+	script := "#!/bin/sh\nif grep -q SECRET \"$1\"; then echo \"secret in $HARBOR_TARGET_PATH\"; exit 1; fi\n"
+	if err := os.WriteFile(validator, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	svc := newTestValidatingArtifactService(ValidationConfig{Validators: []ExternalValidator{
+		{Name: "no-secrets", Path: validator, Blocking: true},
+		{Name: "advisory", Path: validator},
+		{Name: "missing", Path: filepath.Join(dir, "missing")},
+	}})
+	ctx := context.Background()
+
+	input := CreateArtifactInput{
+		OrgID: testOrgID, Name: "env", Version: "1.0.0", FileName: "app.env",
+		TargetPath: "/etc/app.env", DeviceTypes: []string{"raspberry-pi-4"},
+		File: strings.NewReader("TOKEN=public\n"),
+	}
+	artifact, err := svc.Create(ctx, input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(artifact.Validation) != 3 {
+		t.Fatalf("expected a result per validator, got %+v", artifact.Validation)
+	}
+	if artifact.ValidationBlock() != "" {
+		t.Errorf("expected no blocking result, got %s", artifact.ValidationBlock())
+	}
+	if r := artifact.Validation[2]; r.Validator != "missing" || r.Status != domain.ValidationError || r.Blocking {
+		t.Errorf("expected a non-blocking error for the missing validator, got %+v", r)
+	}
+
+	input.Version, input.File = "1.0.1", strings.NewReader("TOKEN=SECRET\n")
+	if artifact, err = svc.Create(ctx, input); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	blocking, advisory := artifact.Validation[0], artifact.Validation[1]
+	if blocking.Status != domain.ValidationFailed || !blocking.Blocking || blocking.Detail != "secret in /etc/app.env" {
+		t.Errorf("expected the blocking validator to fail, got %+v", blocking)
+	}
+	if advisory.Status != domain.ValidationFailed || advisory.Blocking {
+		t.Errorf("expected the advisory validator to fail without blocking, got %+v", advisory)
+	}
+}
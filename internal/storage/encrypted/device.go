@@ -0,0 +1,210 @@
+package encrypted
+
+import (
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Files delivered to a single device are sealed for the device's X25519
+// public key: a header holding deviceMagic, the server's ephemeral public key
+// and a nonce prefix, followed by segments as in stored files. The segment
+// key is derived with HKDF-SHA256 from the X25519 shared secret, salted with
+// the ephemeral and the device public keys. The ephemeral key and nonce
+// prefix are derived from a seed, so that sealing the same file for the same
+// seed and device key always yields the same bytes and an interrupted
+// download can be resumed.
+
+const (
+	// DeviceAlgorithm names the scheme in responses to devices.
+	DeviceAlgorithm = "X25519-HKDF-SHA256-AES-256-GCM"
+	// SeedSize is the size of the seed SealForDevice derives keys from.
+	SeedSize = 32
+
+	deviceMagic      = "HRBDEV01"
+	deviceKeySize    = 32
+	deviceHeaderSize = len(deviceMagic) + deviceKeySize + noncePrefixSize
+
+	ephemeralInfo = "harbor device delivery ephemeral"
+	segmentInfo   = "harbor device delivery"
+)
+
+// ParseDeviceKey decodes a base64 X25519 public key.
+func ParseDeviceKey(s string) (*ecdh.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64: %w", err)
+	}
+	if len(b) != deviceKeySize {
+		return nil, fmt.Errorf("public key must be %d bytes, got %d", deviceKeySize, len(b))
+	}
+	return ecdh.X25519().NewPublicKey(b)
+}
+
+// SealedSize returns the size of a file of size bytes sealed for a device.
+func SealedSize(size int64) int64 {
+	return int64(deviceHeaderSize) + size + segmentCount(size)*tagSize
+}
+
+func segmentCount(size int64) int64 {
+	if size == 0 {
+		return 1
+	}
+	return (size + segmentSize - 1) / segmentSize
+}
+
+// SealForDevice returns src, a file of size bytes, encrypted for recipient.
+// The result can seek to any offset; segments are encrypted as they are
+// read. Closing it closes src.
+func SealForDevice(src io.ReadSeekCloser, size int64, recipient *ecdh.PublicKey, seed []byte) (io.ReadSeekCloser, error) {
+	if len(seed) != SeedSize {
+		return nil, errors.New("encrypted: invalid delivery seed")
+	}
+	derived, err := hkdf.Key(sha256.New, seed, nil, ephemeralInfo, deviceKeySize+noncePrefixSize)
+	if err != nil {
+		return nil, err
+	}
+	ephemeral, err := ecdh.X25519().NewPrivateKey(derived[:deviceKeySize])
+	if err != nil {
+		return nil, err
+	}
+	prefix := derived[deviceKeySize:]
+
+	aead, err := deviceAEAD(ephemeral, recipient, ephemeral.PublicKey(), recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, deviceHeaderSize)
+	header = append(header, deviceMagic...)
+	header = append(header, ephemeral.PublicKey().Bytes()...)
+	header = append(header, prefix...)
+
+	return &sealReader{
+		src:      src,
+		aead:     aead,
+		prefix:   prefix,
+		header:   header,
+		size:     size,
+		segments: segmentCount(size),
+		loaded:   -1,
+		plain:    make([]byte, segmentSize),
+		sealed:   make([]byte, 0, sealedSegmentSize),
+	}, nil
+}
+
+// OpenSealed decrypts a file sealed by SealForDevice with the device's
+// private key, as an agent does with a download.
+func OpenSealed(src io.ReadSeekCloser, key *ecdh.PrivateKey) (io.ReadSeekCloser, error) {
+	header, err := readHeader(src, deviceMagic, deviceHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(header[len(deviceMagic) : len(deviceMagic)+deviceKeySize])
+	if err != nil {
+		return nil, errCorrupt
+	}
+	aead, err := deviceAEAD(key, ephemeral, ephemeral, key.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	return newSegmentReader(aead, src, header[len(deviceMagic)+deviceKeySize:], int64(deviceHeaderSize))
+}
+
+// deviceAEAD derives the segment cipher from the shared secret of priv and
+// peer.
+func deviceAEAD(priv *ecdh.PrivateKey, peer, ephemeral, recipient *ecdh.PublicKey) (cipher.AEAD, error) {
+	shared, err := priv.ECDH(peer)
+	if err != nil {
+		return nil, fmt.Errorf("encrypted: key agreement: %w", err)
+	}
+	salt := append(ephemeral.Bytes(), recipient.Bytes()...)
+	key, err := hkdf.Key(sha256.New, shared, salt, segmentInfo, KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return newAEAD(key)
+}
+
+// sealReader encrypts a seekable file one segment at a time, as it is read.
+type sealReader struct {
+	src      io.ReadSeekCloser
+	aead     cipher.AEAD
+	prefix   []byte
+	header   []byte
+	size     int64 // plaintext size
+	segments int64
+
+	pos    int64
+	loaded int64 // index of the segment in sealed, or -1
+	plain  []byte
+	sealed []byte
+}
+
+func (s *sealReader) total() int64 {
+	return int64(len(s.header)) + s.size + s.segments*tagSize
+}
+
+func (s *sealReader) Read(p []byte) (int, error) {
+	if s.pos >= s.total() {
+		return 0, io.EOF
+	}
+	if s.pos < int64(len(s.header)) {
+		n := copy(p, s.header[s.pos:])
+		s.pos += int64(n)
+		return n, nil
+	}
+
+	off := s.pos - int64(len(s.header))
+	index := off / sealedSegmentSize
+	if index != s.loaded {
+		if err := s.load(index); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.sealed[off-index*sealedSegmentSize:])
+	s.pos += int64(n)
+	return n, nil
+}
+
+func (s *sealReader) load(index int64) error {
+	start := index * segmentSize
+	if _, err := s.src.Seek(start, io.SeekStart); err != nil {
+		return fmt.Errorf("seek file: %w", err)
+	}
+	n := min(int64(segmentSize), s.size-start)
+	if _, err := io.ReadFull(s.src, s.plain[:n]); err != nil {
+		s.loaded = -1
+		return fmt.Errorf("read file: %w", err)
+	}
+	last := index == s.segments-1
+	s.sealed = s.aead.Seal(s.sealed[:0], segmentNonce(s.prefix, uint32(index), last), s.plain[:n], nil)
+	s.loaded = index
+	return nil
+}
+
+func (s *sealReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.pos
+	case io.SeekEnd:
+		offset += s.total()
+	default:
+		return 0, errors.New("encrypted: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("encrypted: negative position")
+	}
+	s.pos = offset
+	return offset, nil
+}
+
+func (s *sealReader) Close() error {
+	return s.src.Close()
+}
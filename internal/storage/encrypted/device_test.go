@@ -0,0 +1,112 @@
+package encrypted
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"testing"
+)
+
+func newDeviceKey(t *testing.T) *ecdh.PrivateKey {
+	t.Helper()
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	return key
+}
+
+func seal(t *testing.T, data []byte, recipient *ecdh.PublicKey, seed []byte) []byte {
+	t.Helper()
+	r, err := SealForDevice(nopCloser{bytes.NewReader(data)}, int64(len(data)), recipient, seed)
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	defer r.Close()
+	sealed, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read sealed: %v", err)
+	}
+	return sealed
+}
+
+func TestSealForDevice_RoundTrip(t *testing.T) {
+	key := newDeviceKey(t)
+	seed := bytes.Repeat([]byte{7}, SeedSize)
+
+	for _, n := range []int{0, 1, segmentSize, 2*segmentSize + 3} {
+		data := randomBytes(n)
+		sealed := seal(t, data, key.PublicKey(), seed)
+		if int64(len(sealed)) != SealedSize(int64(n)) {
+			t.Errorf("%d bytes: sealed size %d, SealedSize %d", n, len(sealed), SealedSize(int64(n)))
+		}
+		if n > 16 && bytes.Contains(sealed, data[:16]) {
+			t.Errorf("%d bytes: plaintext found in sealed file", n)
+		}
+
+		r, err := OpenSealed(nopCloser{bytes.NewReader(sealed)}, key)
+		if err != nil {
+			t.Fatalf("open sealed: %v", err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%d bytes: round trip does not match (%v)", n, err)
+		}
+	}
+}
+
+func TestSealForDevice_SameSeedResumes(t *testing.T) {
+	key := newDeviceKey(t)
+	seed := bytes.Repeat([]byte{1}, SeedSize)
+	data := randomBytes(3*segmentSize + 10)
+	sealed := seal(t, data, key.PublicKey(), seed)
+
+	if !bytes.Equal(seal(t, data, key.PublicKey(), seed), sealed) {
+		t.Fatal("expected sealing with the same seed to be deterministic")
+	}
+	if bytes.Equal(seal(t, data, key.PublicKey(), bytes.Repeat([]byte{2}, SeedSize)), sealed) {
+		t.Fatal("expected another seed to yield other bytes")
+	}
+
+	// A download resumed at an arbitrary offset continues the same bytes
+	r, _ := SealForDevice(nopCloser{bytes.NewReader(data)}, int64(len(data)), key.PublicKey(), seed)
+	defer r.Close()
+	for _, off := range []int64{5, int64(deviceHeaderSize) + sealedSegmentSize - 3, int64(len(sealed)) - 20} {
+		if _, err := r.Seek(off, io.SeekStart); err != nil {
+			t.Fatalf("seek: %v", err)
+		}
+		rest, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(rest, sealed[off:]) {
+			t.Errorf("resume at %d does not match (%v)", off, err)
+		}
+	}
+}
+
+func TestSealForDevice_OnlyRecipientCanOpen(t *testing.T) {
+	key, other := newDeviceKey(t), newDeviceKey(t)
+	sealed := seal(t, []byte("wifi password"), key.PublicKey(), bytes.Repeat([]byte{3}, SeedSize))
+
+	r, err := OpenSealed(nopCloser{bytes.NewReader(sealed)}, other)
+	if err == nil {
+		_, err = io.ReadAll(r)
+	}
+	if !errors.Is(err, errCorrupt) {
+		t.Errorf("expected another key to fail authentication, got %v", err)
+	}
+}
+
+func TestParseDeviceKey(t *testing.T) {
+	key := newDeviceKey(t)
+	parsed, err := ParseDeviceKey(base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()))
+	if err != nil || !parsed.Equal(key.PublicKey()) {
+		t.Errorf("parse = %v, %v", parsed, err)
+	}
+	for _, s := range []string{"", "not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := ParseDeviceKey(s); err == nil {
+			t.Errorf("expected %q to be rejected", s)
+		}
+	}
+}
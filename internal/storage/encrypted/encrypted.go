@@ -0,0 +1,240 @@
+// Package encrypted wraps a FileStore so that files are encrypted at rest.
+//
+// Every file gets its own random AES-256 data key and is encrypted in
+// segments while it is streamed to the underlying store, so that byte ranges
+// can later be decrypted without reading the whole file. Data keys are
+// wrapped with a master key from the server configuration and kept in the
+// database. Rotating the master key re-wraps the data keys only; files
+// written with a previous master key stay readable while that key is
+// configured as a previous key.
+//
+// The package also seals files for delivery to a single device, with the
+// same segment format; see SealForDevice.
+package encrypted
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/CaioWing/Harbor/internal/domain"
+	"github.com/CaioWing/Harbor/internal/storage"
+)
+
+// KeySize is the size of master and data keys (AES-256).
+const KeySize = 32
+
+// rewrapBatchSize is how many data keys Rewrap loads at a time.
+const rewrapBatchSize = 100
+
+type MasterKey struct {
+	ID  string
+	Key []byte
+}
+
+type Store struct {
+	inner     storage.FileStore
+	keys      domain.DataKeyRepository
+	currentID string
+	masters   map[string]cipher.AEAD
+}
+
+// New wraps inner. New files are encrypted with data keys wrapped by
+// current; previous keys are only used to read files written before a
+// rotation, until Rewrap has moved them to current.
+func New(inner storage.FileStore, keys domain.DataKeyRepository, current MasterKey, previous []MasterKey) (*Store, error) {
+	s := &Store{
+		inner:     inner,
+		keys:      keys,
+		currentID: current.ID,
+		masters:   make(map[string]cipher.AEAD),
+	}
+	for _, k := range append([]MasterKey{current}, previous...) {
+		if k.ID == "" {
+			return nil, errors.New("encrypted: master key id is required")
+		}
+		if _, ok := s.masters[k.ID]; ok {
+			return nil, fmt.Errorf("encrypted: duplicate master key id %q", k.ID)
+		}
+		if len(k.Key) != KeySize {
+			return nil, fmt.Errorf("encrypted: master key %q must be %d bytes", k.ID, KeySize)
+		}
+		aead, err := newAEAD(k.Key)
+		if err != nil {
+			return nil, err
+		}
+		s.masters[k.ID] = aead
+	}
+	return s, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Save encrypts the file while it is written and returns its plaintext size.
+func (s *Store) Save(name string, reader io.Reader) (string, int64, error) {
+	dataKey := make([]byte, KeySize)
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", 0, fmt.Errorf("generate data key: %w", err)
+	}
+	if _, err := rand.Read(prefix); err != nil {
+		return "", 0, fmt.Errorf("generate nonce: %w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", 0, err
+	}
+
+	enc := newEncryptReader(aead, prefix, reader)
+	path, _, err := s.inner.Save(name, enc)
+	if err != nil {
+		return "", 0, err
+	}
+
+	wrapped, err := s.wrap(s.currentID, path, dataKey)
+	if err == nil {
+		err = s.keys.Create(context.Background(), &domain.DataKey{
+			StoragePath: path,
+			MasterKeyID: s.currentID,
+			WrappedKey:  wrapped,
+		})
+	}
+	if err != nil {
+		s.inner.Delete(path)
+		return "", 0, fmt.Errorf("store data key: %w", err)
+	}
+	return path, enc.size, nil
+}
+
+// Open returns a reader of the decrypted file. Files stored before
+// encryption was enabled have no data key and are returned as they are.
+func (s *Store) Open(path string) (io.ReadSeekCloser, error) {
+	f, err := s.inner.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := s.keys.Get(context.Background(), path)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return f, nil
+		}
+		f.Close()
+		return nil, fmt.Errorf("get data key: %w", err)
+	}
+
+	dataKey, err := s.unwrap(key)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r, err := newDecryptReader(aead, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (s *Store) Delete(path string) error {
+	if err := s.inner.Delete(path); err != nil {
+		return err
+	}
+	return s.keys.Delete(context.Background(), path)
+}
+
+// Walk lists the underlying store. Sizes are those of the encrypted files.
+func (s *Store) Walk(fn func(path string, size int64, modTime time.Time) error) error {
+	lister, ok := s.inner.(storage.Lister)
+	if !ok {
+		return errors.New("encrypted: underlying store cannot be listed")
+	}
+	return lister.Walk(fn)
+}
+
+// Rewrap re-wraps every data key that is not wrapped with the current master
+// key, and returns how many were re-wrapped. Keys wrapped with a master key
+// that is no longer configured are skipped and reported in the error.
+func (s *Store) Rewrap(ctx context.Context) (int, error) {
+	rewrapped, unknown := 0, 0
+	after := ""
+	for {
+		keys, err := s.keys.ListForRewrap(ctx, s.currentID, after, rewrapBatchSize)
+		if err != nil {
+			return rewrapped, err
+		}
+		if len(keys) == 0 {
+			break
+		}
+		for _, k := range keys {
+			after = k.StoragePath
+			dataKey, err := s.unwrap(k)
+			if err != nil {
+				unknown++
+				continue
+			}
+			wrapped, err := s.wrap(s.currentID, k.StoragePath, dataKey)
+			if err != nil {
+				return rewrapped, err
+			}
+			oldID := k.MasterKeyID
+			k.MasterKeyID, k.WrappedKey = s.currentID, wrapped
+			if err := s.keys.Rewrap(ctx, k, oldID); err != nil {
+				// Replaced by a new file meanwhile
+				if errors.Is(err, domain.ErrConflict) {
+					continue
+				}
+				return rewrapped, err
+			}
+			rewrapped++
+		}
+	}
+	if unknown > 0 {
+		return rewrapped, fmt.Errorf("encrypted: %d data keys could not be unwrapped with the configured master keys", unknown)
+	}
+	return rewrapped, nil
+}
+
+// wrap encrypts a data key with a master key. The storage path is
+// authenticated too, so that a wrapped key only opens the file it was
+// created for.
+func (s *Store) wrap(masterID, path string, dataKey []byte) ([]byte, error) {
+	aead := s.masters[masterID]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, dataKey, []byte(path)), nil
+}
+
+func (s *Store) unwrap(k *domain.DataKey) ([]byte, error) {
+	aead, ok := s.masters[k.MasterKeyID]
+	if !ok {
+		return nil, fmt.Errorf("encrypted: unknown master key %q", k.MasterKeyID)
+	}
+	if len(k.WrappedKey) < aead.NonceSize() {
+		return nil, errCorrupt
+	}
+	nonce, sealed := k.WrappedKey[:aead.NonceSize()], k.WrappedKey[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, sealed, []byte(k.StoragePath))
+	if err != nil {
+		return nil, fmt.Errorf("encrypted: data key of %s failed authentication with master key %q", k.StoragePath, k.MasterKeyID)
+	}
+	return dataKey, nil
+}
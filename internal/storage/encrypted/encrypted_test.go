@@ -0,0 +1,352 @@
+package encrypted
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CaioWing/Harbor/internal/domain"
+)
+
+// memStore is an in-memory FileStore.
+type memStore struct {
+	mu    sync.Mutex
+	files map[string][]byte
+	next  int
+}
+
+func newMemStore() *memStore {
+	return &memStore{files: make(map[string][]byte)}
+}
+
+func (m *memStore) Save(name string, reader io.Reader) (string, int64, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return "", 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.next++
+	path := fmt.Sprintf("%d/%s", m.next, name)
+	m.files[path] = data
+	return path, int64(len(data)), nil
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error { return nil }
+
+func (m *memStore) Open(path string) (io.ReadSeekCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[path]
+	if !ok {
+		return nil, fmt.Errorf("open %s: %w", path, fs.ErrNotExist)
+	}
+	return nopCloser{bytes.NewReader(data)}, nil
+}
+
+func (m *memStore) Delete(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.files, path)
+	return nil
+}
+
+// memKeys is an in-memory DataKeyRepository.
+type memKeys struct {
+	mu   sync.Mutex
+	keys map[string]domain.DataKey
+}
+
+func newMemKeys() *memKeys {
+	return &memKeys{keys: make(map[string]domain.DataKey)}
+}
+
+func (m *memKeys) Create(_ context.Context, k *domain.DataKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k.CreatedAt = time.Now()
+	m.keys[k.StoragePath] = *k
+	return nil
+}
+
+func (m *memKeys) Get(_ context.Context, path string) (*domain.DataKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k, ok := m.keys[path]
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return &k, nil
+}
+
+func (m *memKeys) Delete(_ context.Context, path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.keys, path)
+	return nil
+}
+
+func (m *memKeys) ListForRewrap(_ context.Context, masterKeyID, afterPath string, limit int) ([]*domain.DataKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var paths []string
+	for p, k := range m.keys {
+		if k.MasterKeyID != masterKeyID && p > afterPath {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	if len(paths) > limit {
+		paths = paths[:limit]
+	}
+	result := []*domain.DataKey{}
+	for _, p := range paths {
+		k := m.keys[p]
+		result = append(result, &k)
+	}
+	return result, nil
+}
+
+func (m *memKeys) Rewrap(_ context.Context, k *domain.DataKey, oldMasterKeyID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.keys[k.StoragePath]
+	if !ok || stored.MasterKeyID != oldMasterKeyID {
+		return domain.ErrConflict
+	}
+	m.keys[k.StoragePath] = *k
+	return nil
+}
+
+func masterKey(id string) MasterKey {
+	return MasterKey{ID: id, Key: bytes.Repeat([]byte(id[:1]), KeySize)}
+}
+
+func newTestStore(t *testing.T, current MasterKey, previous ...MasterKey) (*Store, *memStore, *memKeys) {
+	t.Helper()
+	inner, keys := newMemStore(), newMemKeys()
+	store, err := New(inner, keys, current, previous)
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	return store, inner, keys
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(b)
+	return b
+}
+
+func readAll(t *testing.T, s *Store, path string) []byte {
+	t.Helper()
+	r, err := s.Open(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return data
+}
+
+func TestStore_RoundTrip(t *testing.T) {
+	store, inner, _ := newTestStore(t, masterKey("k1"))
+
+	for _, n := range []int{0, 1, segmentSize - 1, segmentSize, segmentSize + 1, 3*segmentSize + 5} {
+		data := randomBytes(n)
+		path, size, err := store.Save("file.bin", bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("save %d bytes: %v", n, err)
+		}
+		if size != int64(n) {
+			t.Errorf("save %d bytes: reported size %d", n, size)
+		}
+		if n > 16 && bytes.Contains(inner.files[path], data[:16]) {
+			t.Errorf("save %d bytes: plaintext found in stored file", n)
+		}
+		if got := readAll(t, store, path); !bytes.Equal(got, data) {
+			t.Errorf("round trip of %d bytes does not match", n)
+		}
+	}
+}
+
+func TestStore_SeekDecryptsRanges(t *testing.T) {
+	store, _, _ := newTestStore(t, masterKey("k1"))
+	data := randomBytes(3*segmentSize + 100)
+	path, _, err := store.Save("file.bin", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	r, err := store.Open(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer r.Close()
+
+	if end, _ := r.Seek(0, io.SeekEnd); end != int64(len(data)) {
+		t.Fatalf("size = %d, want %d", end, len(data))
+	}
+	for _, off := range []int64{segmentSize - 10, 2 * segmentSize, int64(len(data)) - 50} {
+		if _, err := r.Seek(off, io.SeekStart); err != nil {
+			t.Fatalf("seek: %v", err)
+		}
+		buf := make([]byte, 40)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			t.Fatalf("read at %d: %v", off, err)
+		}
+		if !bytes.Equal(buf, data[off:off+40]) {
+			t.Errorf("range at %d does not match", off)
+		}
+	}
+}
+
+func TestStore_DetectsTampering(t *testing.T) {
+	store, inner, _ := newTestStore(t, masterKey("k1"))
+	data := randomBytes(2*segmentSize + 10)
+
+	tamper := map[string]func([]byte) []byte{
+		"flipped byte": func(b []byte) []byte { b[headerSize+segmentSize+5] ^= 1; return b },
+		"truncated":    func(b []byte) []byte { return b[:headerSize+2*sealedSegmentSize] },
+		"swapped segments": func(b []byte) []byte {
+			first := append([]byte(nil), b[headerSize:headerSize+sealedSegmentSize]...)
+			copy(b[headerSize:], b[headerSize+sealedSegmentSize:headerSize+2*sealedSegmentSize])
+			copy(b[headerSize+sealedSegmentSize:], first)
+			return b
+		},
+	}
+	for name, fn := range tamper {
+		path, _, err := store.Save("file.bin", bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("save: %v", err)
+		}
+		inner.files[path] = fn(inner.files[path])
+
+		r, err := store.Open(path)
+		if err == nil {
+			_, err = io.ReadAll(r)
+			r.Close()
+		}
+		if !errors.Is(err, errCorrupt) {
+			t.Errorf("%s: expected corruption error, got %v", name, err)
+		}
+	}
+}
+
+func TestStore_KeyBoundToPath(t *testing.T) {
+	store, inner, keys := newTestStore(t, masterKey("k1"))
+	a, _, _ := store.Save("a.bin", bytes.NewReader([]byte("secret a")))
+	b, _, _ := store.Save("b.bin", bytes.NewReader([]byte("secret b")))
+
+	// A wrapped key copied to another file's row must not open it
+	k, _ := keys.Get(context.Background(), a)
+	k.StoragePath = b
+	keys.Create(context.Background(), k)
+	inner.files[b] = inner.files[a]
+
+	if _, err := store.Open(b); err == nil {
+		t.Fatal("expected data key bound to another path to be rejected")
+	}
+}
+
+func TestStore_ReadsFilesStoredBeforeEncryption(t *testing.T) {
+	store, inner, _ := newTestStore(t, masterKey("k1"))
+	path, _, _ := inner.Save("legacy.bin", bytes.NewReader([]byte("plain")))
+
+	if got := readAll(t, store, path); string(got) != "plain" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestStore_DeleteRemovesKey(t *testing.T) {
+	store, inner, keys := newTestStore(t, masterKey("k1"))
+	path, _, _ := store.Save("file.bin", bytes.NewReader([]byte("data")))
+
+	if err := store.Delete(path); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if len(inner.files) != 0 || len(keys.keys) != 0 {
+		t.Errorf("expected file and key removed, got %d files %d keys", len(inner.files), len(keys.keys))
+	}
+	if _, err := store.Open(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
+	}
+}
+
+func TestStore_RotateMasterKey(t *testing.T) {
+	ctx := context.Background()
+	old, next := masterKey("k1"), masterKey("k2")
+	store, inner, keys := newTestStore(t, old)
+
+	var paths []string
+	for i := 0; i < rewrapBatchSize+5; i++ {
+		path, _, err := store.Save("file.bin", bytes.NewReader([]byte(fmt.Sprintf("file %d", i))))
+		if err != nil {
+			t.Fatalf("save: %v", err)
+		}
+		paths = append(paths, path)
+	}
+	stored := inner.files[paths[0]]
+
+	// Step 1: the new key is current, the old one still reads
+	rotated, err := New(inner, keys, next, []MasterKey{old})
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	if got := readAll(t, rotated, paths[0]); string(got) != "file 0" {
+		t.Errorf("got %q before rewrap", got)
+	}
+
+	n, err := rotated.Rewrap(ctx)
+	if err != nil || n != len(paths) {
+		t.Fatalf("rewrap = %d, %v; want %d", n, err, len(paths))
+	}
+	if !bytes.Equal(inner.files[paths[0]], stored) {
+		t.Error("expected files not to be rewritten")
+	}
+
+	// Step 2: the old key is retired
+	retired, err := New(inner, keys, next, nil)
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	for i, path := range paths {
+		if got := readAll(t, retired, path); string(got) != fmt.Sprintf("file %d", i) {
+			t.Fatalf("file %d: got %q after rotation", i, got)
+		}
+	}
+}
+
+func TestStore_RewrapReportsUnknownMasterKeys(t *testing.T) {
+	store, inner, keys := newTestStore(t, masterKey("k1"))
+	store.Save("file.bin", bytes.NewReader([]byte("data")))
+
+	rotated, _ := New(inner, keys, masterKey("k2"), nil)
+	if n, err := rotated.Rewrap(context.Background()); err == nil || n != 0 {
+		t.Errorf("expected an error for keys wrapped with a retired master key, got %d, %v", n, err)
+	}
+}
+
+func TestNew_ValidatesKeys(t *testing.T) {
+	if _, err := New(newMemStore(), newMemKeys(), MasterKey{ID: "k1", Key: []byte("short")}, nil); err == nil {
+		t.Error("expected short key to be rejected")
+	}
+	if _, err := New(newMemStore(), newMemKeys(), masterKey("k1"), []MasterKey{masterKey("k1")}); err == nil {
+		t.Error("expected duplicate key id to be rejected")
+	}
+}
@@ -0,0 +1,239 @@
+package encrypted
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// File format: a header holding a magic string and a random nonce prefix,
+// followed by the plaintext in segments of segmentSize bytes, each sealed
+// with AES-256-GCM. The nonce of a segment is the prefix, the segment index
+// and a flag set only on the last segment, so that segments cannot be
+// reordered and a truncated file fails authentication. Every file has at
+// least one segment, possibly empty.
+
+const (
+	magic             = "HRBENC01"
+	noncePrefixSize   = 7
+	headerSize        = len(magic) + noncePrefixSize
+	segmentSize       = 64 << 10
+	tagSize           = 16
+	sealedSegmentSize = segmentSize + tagSize
+)
+
+var errCorrupt = errors.New("encrypted: file is corrupted")
+
+func segmentNonce(prefix []byte, index uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], index)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encryptReader encrypts src as it is read. It reads one byte past each
+// segment to know whether the segment is the last one.
+type encryptReader struct {
+	src    io.Reader
+	aead   cipher.AEAD
+	prefix []byte
+
+	buf    []byte // plaintext of the next segment, plus one byte
+	have   int
+	sealed []byte
+	out    []byte // ciphertext not yet returned
+	index  uint32
+	done   bool
+	size   int64 // plaintext bytes encrypted so far
+}
+
+func newEncryptReader(aead cipher.AEAD, prefix []byte, src io.Reader) *encryptReader {
+	header := make([]byte, 0, headerSize)
+	header = append(header, magic...)
+	header = append(header, prefix...)
+	return &encryptReader{
+		src:    src,
+		aead:   aead,
+		prefix: prefix,
+		buf:    make([]byte, segmentSize+1),
+		sealed: make([]byte, 0, sealedSegmentSize),
+		out:    header,
+	}
+}
+
+func (e *encryptReader) Read(p []byte) (int, error) {
+	for len(e.out) == 0 {
+		if e.done {
+			return 0, io.EOF
+		}
+		if err := e.sealNext(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, e.out)
+	e.out = e.out[n:]
+	return n, nil
+}
+
+func (e *encryptReader) sealNext() error {
+	n, err := io.ReadFull(e.src, e.buf[e.have:])
+	e.have += n
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		e.done = true
+	case err != nil:
+		return err
+	}
+	if !e.done && e.index == math.MaxUint32 {
+		return errors.New("encrypted: file too large")
+	}
+
+	size := e.have
+	if !e.done {
+		size = segmentSize
+	}
+	e.out = e.aead.Seal(e.sealed[:0], segmentNonce(e.prefix, e.index, e.done), e.buf[:size], nil)
+	e.size += int64(size)
+	e.index++
+
+	// Keep the byte read past the segment for the next one
+	if !e.done {
+		e.buf[0] = e.buf[segmentSize]
+		e.have = 1
+	}
+	return nil
+}
+
+// decryptReader decrypts a file one segment at a time, which lets it seek
+// to any offset.
+type decryptReader struct {
+	f        io.ReadSeekCloser
+	aead     cipher.AEAD
+	prefix   []byte
+	offset   int64 // size of the header before the first segment
+	size     int64 // plaintext size
+	segments int64
+	lastLen  int // sealed size of the last segment
+
+	pos    int64
+	loaded int64 // index of the segment in plain, or -1
+	plain  []byte
+	sealed []byte
+}
+
+func newDecryptReader(aead cipher.AEAD, f io.ReadSeekCloser) (*decryptReader, error) {
+	header, err := readHeader(f, magic, headerSize)
+	if err != nil {
+		return nil, err
+	}
+	return newSegmentReader(aead, f, header[len(magic):], int64(headerSize))
+}
+
+// readHeader reads the first n bytes of f and checks that they start with
+// the magic string m.
+func readHeader(f io.ReadSeeker, m string, n int) ([]byte, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seek file: %w", err)
+	}
+	header := make([]byte, n)
+	if _, err := io.ReadFull(f, header); err != nil || string(header[:len(m)]) != m {
+		return nil, errCorrupt
+	}
+	return header, nil
+}
+
+// newSegmentReader reads the segments following a header of offset bytes.
+func newSegmentReader(aead cipher.AEAD, f io.ReadSeekCloser, prefix []byte, offset int64) (*decryptReader, error) {
+	total, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("get file size: %w", err)
+	}
+
+	body := total - offset
+	segments := (body + sealedSegmentSize - 1) / sealedSegmentSize
+	if segments <= 0 {
+		return nil, errCorrupt
+	}
+	lastLen := body - (segments-1)*sealedSegmentSize
+	if lastLen < tagSize {
+		return nil, errCorrupt
+	}
+
+	return &decryptReader{
+		f:        f,
+		aead:     aead,
+		prefix:   prefix,
+		offset:   offset,
+		size:     body - segments*tagSize,
+		segments: segments,
+		lastLen:  int(lastLen),
+		loaded:   -1,
+		plain:    make([]byte, 0, segmentSize),
+		sealed:   make([]byte, sealedSegmentSize),
+	}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	if d.pos >= d.size {
+		return 0, io.EOF
+	}
+	index := d.pos / segmentSize
+	if index != d.loaded {
+		if err := d.load(index); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain[d.pos-index*segmentSize:])
+	d.pos += int64(n)
+	return n, nil
+}
+
+func (d *decryptReader) load(index int64) error {
+	if _, err := d.f.Seek(d.offset+index*sealedSegmentSize, io.SeekStart); err != nil {
+		return fmt.Errorf("seek file: %w", err)
+	}
+	last := index == d.segments-1
+	n := sealedSegmentSize
+	if last {
+		n = d.lastLen
+	}
+	if _, err := io.ReadFull(d.f, d.sealed[:n]); err != nil {
+		return fmt.Errorf("read file: %w", err)
+	}
+
+	plain, err := d.aead.Open(d.plain[:0], segmentNonce(d.prefix, uint32(index), last), d.sealed[:n], nil)
+	if err != nil {
+		d.loaded = -1
+		return fmt.Errorf("%w: segment %d failed authentication", errCorrupt, index)
+	}
+	d.plain = plain
+	d.loaded = index
+	return nil
+}
+
+func (d *decryptReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += d.pos
+	case io.SeekEnd:
+		offset += d.size
+	default:
+		return 0, errors.New("encrypted: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("encrypted: negative position")
+	}
+	d.pos = offset
+	return offset, nil
+}
+
+func (d *decryptReader) Close() error {
+	return d.f.Close()
+}
@@ -1,8 +1,10 @@
 package local
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"time"
@@ -41,7 +43,7 @@ func (s *LocalStore) Save(name string, reader io.Reader) (string, int64, error)
 	return path, n, nil
 }
 
-func (s *LocalStore) Open(path string) (io.ReadCloser, error) {
+func (s *LocalStore) Open(path string) (io.ReadSeekCloser, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, fmt.Errorf("open file: %w", err)
@@ -55,3 +57,24 @@ func (s *LocalStore) Delete(path string) error {
 	}
 	return nil
 }
+
+// Walk calls fn for every file under the storage directory.
+func (s *LocalStore) Walk(fn func(path string, size int64, modTime time.Time) error) error {
+	return filepath.WalkDir(s.basePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			// Deleted since the directory was read
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		return fn(path, info.Size(), info.ModTime())
+	})
+}
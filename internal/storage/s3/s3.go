@@ -0,0 +1,462 @@
+// Package s3 stores artifact files in an S3-compatible object store (AWS S3,
+// MinIO, Ceph RGW, ...). Requests are signed with AWS Signature Version 4
+// using only the standard library.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MinPartSize is the smallest part S3 accepts in a multipart upload, other
+// than the last one.
+const MinPartSize = 5 << 20
+
+type Config struct {
+	// Endpoint is the base URL of the service, e.g. http://minio:9000.
+	// Defaults to the AWS endpoint of Region.
+	Endpoint string
+	// PublicEndpoint is used in presigned URLs when devices reach the store
+	// at another address than the server. Defaults to Endpoint.
+	PublicEndpoint string
+	Region         string
+	Bucket         string
+	AccessKey      string
+	SecretKey      string
+	// Prefix is prepended to every object key.
+	Prefix string
+	// PathStyle addresses the bucket in the path (endpoint/bucket/key)
+	// instead of the host name, as most self-hosted services require.
+	PathStyle bool
+	// PartSize is the multipart upload part size. Files smaller than one
+	// part are sent in a single request.
+	PartSize int64
+	// PresignExpiry is how long presigned download URLs stay valid. Zero
+	// disables them.
+	PresignExpiry time.Duration
+	HTTPClient    *http.Client
+}
+
+type S3Store struct {
+	cfg      Config
+	endpoint *url.URL
+	public   *url.URL
+	creds    credentials
+	client   *http.Client
+}
+
+func New(cfg Config) (*S3Store, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3: bucket is required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	if cfg.PublicEndpoint == "" {
+		cfg.PublicEndpoint = cfg.Endpoint
+	}
+	if cfg.PartSize == 0 {
+		cfg.PartSize = 16 << 20
+	}
+	if cfg.PartSize < MinPartSize {
+		return nil, fmt.Errorf("s3: part size must be at least %d bytes", MinPartSize)
+	}
+	if cfg.PresignExpiry > 7*24*time.Hour {
+		return nil, errors.New("s3: presigned URLs cannot be valid for more than 7 days")
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{}
+	}
+
+	endpoint, err := parseEndpoint(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	public, err := parseEndpoint(cfg.PublicEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3Store{
+		cfg:      cfg,
+		endpoint: endpoint,
+		public:   public,
+		creds:    credentials{accessKey: cfg.AccessKey, secretKey: cfg.SecretKey, region: cfg.Region},
+		client:   cfg.HTTPClient,
+	}, nil
+}
+
+func parseEndpoint(raw string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimRight(raw, "/"))
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("s3: invalid endpoint %q", raw)
+	}
+	return u, nil
+}
+
+// Save uploads the file under a dated key, like the local store's
+// directories, and returns the key as the path. Files larger than one part
+// use a multipart upload, so memory use is bounded by the part size.
+func (s *S3Store) Save(name string, reader io.Reader) (string, int64, error) {
+	key := s.cfg.Prefix + time.Now().Format("2006/01/02") + "/" + name
+	ctx := context.Background()
+
+	buf := make([]byte, s.cfg.PartSize)
+	n, err := io.ReadFull(reader, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		if err := s.putObject(ctx, key, buf[:n]); err != nil {
+			return "", 0, err
+		}
+		return key, int64(n), nil
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("read file: %w", err)
+	}
+
+	size, err := s.multipartUpload(ctx, key, buf, reader)
+	if err != nil {
+		return "", 0, err
+	}
+	return key, size, nil
+}
+
+func (s *S3Store) putObject(ctx context.Context, key string, data []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, key, nil, nil, data)
+	if err != nil {
+		return fmt.Errorf("put object: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+// multipartUpload sends first, already read into a full part, followed by
+// the rest of reader. The upload is aborted on failure so that no parts are
+// left billed in the bucket.
+func (s *S3Store) multipartUpload(ctx context.Context, key string, first []byte, reader io.Reader) (int64, error) {
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil, nil)
+	if err != nil {
+		return 0, fmt.Errorf("create multipart upload: %w", err)
+	}
+	var created struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	if err != nil || created.UploadID == "" {
+		return 0, fmt.Errorf("create multipart upload: invalid response")
+	}
+
+	size, err := s.uploadParts(ctx, key, created.UploadID, first, reader)
+	if err != nil {
+		if resp, abortErr := s.do(ctx, http.MethodDelete, key, url.Values{"uploadId": {created.UploadID}}, nil, nil); abortErr == nil {
+			resp.Body.Close()
+		}
+		return 0, err
+	}
+	return size, nil
+}
+
+func (s *S3Store) uploadParts(ctx context.Context, key, uploadID string, part []byte, reader io.Reader) (int64, error) {
+	var complete completeMultipartUpload
+	var size int64
+	next := make([]byte, len(part))
+
+	for number := 1; ; number++ {
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+		resp, err := s.do(ctx, http.MethodPut, key, query, nil, part)
+		if err != nil {
+			return 0, fmt.Errorf("upload part %d: %w", number, err)
+		}
+		resp.Body.Close()
+		complete.Parts = append(complete.Parts, completedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+		size += int64(len(part))
+
+		n, err := io.ReadFull(reader, next)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return 0, fmt.Errorf("read file: %w", err)
+		}
+		part, next = next[:n], part[:cap(part)]
+	}
+
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, nil, body)
+	if err != nil {
+		return 0, fmt.Errorf("complete multipart upload: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Completion can fail after the 200 status has been sent, in which case
+	// the body is an error document
+	result, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("complete multipart upload: %w", err)
+	}
+	if apiErr := parseError(resp.StatusCode, result); apiErr.Code != "" {
+		return 0, fmt.Errorf("complete multipart upload: %w", apiErr)
+	}
+	return size, nil
+}
+
+// Open returns a reader that streams the object with ranged GET requests,
+// starting a new request only after a seek.
+func (s *S3Store) Open(path string) (io.ReadSeekCloser, error) {
+	resp, err := s.do(context.Background(), http.MethodHead, path, nil, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("open object: %w", err)
+	}
+	resp.Body.Close()
+	return &objectReader{store: s, key: path, size: resp.ContentLength}, nil
+}
+
+func (s *S3Store) Delete(path string) error {
+	resp, err := s.do(context.Background(), http.MethodDelete, path, nil, nil, nil)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("delete object: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// PresignGet returns a URL that downloads the object without credentials
+// until it expires, or an empty URL when presigning is disabled. fileName
+// sets the Content-Disposition of the response.
+func (s *S3Store) PresignGet(path, fileName string) (string, time.Time, error) {
+	if s.cfg.PresignExpiry <= 0 {
+		return "", time.Time{}, nil
+	}
+	now := time.Now().UTC()
+	u := s.objectURL(s.public, path)
+
+	query := url.Values{
+		"X-Amz-Algorithm":     {algorithm},
+		"X-Amz-Credential":    {s.creds.accessKey + "/" + s.creds.scope(now)},
+		"X-Amz-Date":          {now.Format(amzDateFormat)},
+		"X-Amz-Expires":       {strconv.Itoa(int(s.cfg.PresignExpiry.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	if fileName != "" {
+		query.Set("response-content-disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+	}
+	header := http.Header{"Host": {u.Host}}
+	sig := s.creds.signature(now, http.MethodGet, u.EscapedPath(), query, header, []string{"host"}, unsignedPayload)
+
+	u.RawQuery = canonicalQuery(query) + "&X-Amz-Signature=" + sig
+	return u.String(), now.Add(s.cfg.PresignExpiry), nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// Walk calls fn for every object under the configured prefix, listing the
+// bucket one page (ListObjectsV2) at a time.
+func (s *S3Store) Walk(fn func(path string, size int64, modTime time.Time) error) error {
+	query := url.Values{"list-type": {"2"}}
+	if s.cfg.Prefix != "" {
+		query.Set("prefix", s.cfg.Prefix)
+	}
+
+	for {
+		resp, err := s.do(context.Background(), http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return fmt.Errorf("list objects: %w", err)
+		}
+		var page listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("decode object list: %w", err)
+		}
+
+		for _, obj := range page.Contents {
+			if err := fn(obj.Key, obj.Size, obj.LastModified); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+func (s *S3Store) objectURL(base *url.URL, key string) *url.URL {
+	u := *base
+	path := "/" + key
+	if s.cfg.PathStyle {
+		path = "/" + s.cfg.Bucket + path
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+	}
+	u.Path = strings.TrimRight(base.Path, "/") + path
+	u.RawPath = uriEncode(u.Path, false)
+	return &u
+}
+
+// do sends a signed request and turns error responses into an *Error. A
+// missing object is reported as fs.ErrNotExist, like the local store.
+func (s *S3Store) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := s.objectURL(s.endpoint, key)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		req.Body, req.GetBody, req.ContentLength = http.NoBody, nil, 0
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	now := time.Now().UTC()
+	payloadHash := hashHex(body)
+	req.Header.Set("X-Amz-Date", now.Format(amzDateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if req.Header.Get("Range") != "" {
+		signed = append(signed, "range")
+	}
+	signHeader := req.Header.Clone()
+	signHeader.Set("Host", u.Host)
+	req.Header.Set("Authorization", s.creds.authorization(now, method, u.EscapedPath(), query, signHeader, signed, payloadHash))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		apiErr := parseError(resp.StatusCode, data)
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %v", fs.ErrNotExist, apiErr)
+		}
+		return nil, apiErr
+	}
+	return resp, nil
+}
+
+// Error is an error response of the S3 API.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("s3: %s: %s", e.Code, e.Message)
+}
+
+func parseError(status int, body []byte) *Error {
+	apiErr := &Error{StatusCode: status}
+	var doc struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}
+	if xml.Unmarshal(body, &doc) == nil {
+		apiErr.Code, apiErr.Message = doc.Code, doc.Message
+	}
+	return apiErr
+}
+
+// objectReader reads an object from offset, opening a ranged GET lazily.
+type objectReader struct {
+	store  *S3Store
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (r *objectReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		header := http.Header{"Range": {fmt.Sprintf("bytes=%d-", r.offset)}}
+		resp, err := r.store.do(context.Background(), http.MethodGet, r.key, nil, header, nil)
+		if err != nil {
+			return 0, fmt.Errorf("read object: %w", err)
+		}
+		r.body = resp.Body
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if err == io.EOF && r.offset < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *objectReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("s3: negative seek offset")
+	}
+	if offset != r.offset {
+		r.Close()
+		r.offset = offset
+	}
+	return offset, nil
+}
+
+func (r *objectReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
@@ -0,0 +1,137 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AWS Signature Version 4, as described in "Authenticating Requests (AWS
+// Signature Version 4)" of the S3 API reference.
+
+const (
+	algorithm       = "AWS4-HMAC-SHA256"
+	amzDateFormat   = "20060102T150405Z"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	service         = "s3"
+)
+
+// emptyPayloadHash is the SHA-256 of an empty body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+type credentials struct {
+	accessKey string
+	secretKey string
+	region    string
+}
+
+func (c credentials) scope(t time.Time) string {
+	return t.Format("20060102") + "/" + c.region + "/" + service + "/aws4_request"
+}
+
+// signature signs a request. escapedPath is the URI-encoded path as sent on
+// the wire, header must hold a Host entry and signed lists the lower-case
+// names of the headers covered by the signature.
+func (c credentials) signature(t time.Time, method, escapedPath string, query url.Values, header http.Header, signed []string, payloadHash string) string {
+	signed = append([]string(nil), signed...)
+	sort.Strings(signed)
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		escapedPath,
+		canonicalQuery(query),
+		canonicalHeaders(header, signed),
+		strings.Join(signed, ";"),
+		payloadHash,
+	}, "\n")
+
+	stringToSign := strings.Join([]string{
+		algorithm,
+		t.Format(amzDateFormat),
+		c.scope(t),
+		hashHex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), t.Format("20060102"))
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// authorization returns the Authorization header value for a request.
+func (c credentials) authorization(t time.Time, method, escapedPath string, query url.Values, header http.Header, signed []string, payloadHash string) string {
+	signed = append([]string(nil), signed...)
+	sort.Strings(signed)
+	sig := c.signature(t, method, escapedPath, query, header, signed, payloadHash)
+	return algorithm + " Credential=" + c.accessKey + "/" + c.scope(t) +
+		", SignedHeaders=" + strings.Join(signed, ";") + ", Signature=" + sig
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func canonicalHeaders(header http.Header, signed []string) string {
+	var b strings.Builder
+	for _, name := range signed {
+		values := append([]string(nil), header.Values(name)...)
+		for i := range values {
+			values[i] = strings.Join(strings.Fields(values[i]), " ")
+		}
+		b.WriteString(name + ":" + strings.Join(values, ",") + "\n")
+	}
+	return b.String()
+}
+
+// uriEncode escapes everything but the unreserved characters of RFC 3986.
+// Slashes are kept when encoding an object key path.
+func uriEncode(s string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&15])
+		}
+	}
+	return b.String()
+}
+
+func hashHex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -1,9 +1,28 @@
 package storage
 
-import "io"
+import (
+	"io"
+	"time"
+)
 
 type FileStore interface {
 	Save(name string, reader io.Reader) (path string, size int64, err error)
-	Open(path string) (io.ReadCloser, error)
+	// Open returns a seekable reader so that downloads can serve byte ranges.
+	// A missing file is reported with an error matching fs.ErrNotExist.
+	Open(path string) (io.ReadSeekCloser, error)
 	Delete(path string) error
 }
+
+// Presigner is implemented by stores that can hand out time-limited URLs to
+// download a file straight from the store, bypassing the server. The URL is
+// empty when the store is configured not to presign.
+type Presigner interface {
+	PresignGet(path, fileName string) (url string, expiresAt time.Time, err error)
+}
+
+// Lister is implemented by stores that can enumerate their files, with paths
+// in the form Save returns. The storage scrubber uses it to find files that
+// no record points to.
+type Lister interface {
+	Walk(fn func(path string, size int64, modTime time.Time) error) error
+}
@@ -0,0 +1,222 @@
+// Package validate checks the content of uploaded artifact files: the
+// architecture of ELF binaries, the syntax of shell scripts and of JSON and
+// YAML configuration files, and runs external validators on them.
+//
+// Checks that run another program work on a file on the local disk; the
+// others read the content they are given.
+package validate
+
+import (
+	"bytes"
+	"context"
+	"debug/elf"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// ErrNotELF is returned by ELFArch for content that is not an ELF file.
+var ErrNotELF = errors.New("not an ELF file")
+
+// elfMachines maps ELF machines to the architecture names Go uses.
+var elfMachines = map[elf.Machine]string{
+	elf.EM_X86_64:    "amd64",
+	elf.EM_386:       "386",
+	elf.EM_AARCH64:   "arm64",
+	elf.EM_ARM:       "arm",
+	elf.EM_RISCV:     "riscv64",
+	elf.EM_MIPS:      "mips",
+	elf.EM_PPC64:     "ppc64",
+	elf.EM_S390:      "s390x",
+	elf.EM_LOONGARCH: "loong64",
+}
+
+// archAliases maps other common names of an architecture, such as those
+// printed by uname -m, to the name ELFArch returns.
+var archAliases = map[string]string{
+	"x86_64":      "amd64",
+	"x64":         "amd64",
+	"i386":        "386",
+	"i686":        "386",
+	"x86":         "386",
+	"aarch64":     "arm64",
+	"armv6l":      "arm",
+	"armv7l":      "arm",
+	"armhf":       "arm",
+	"armel":       "arm",
+	"riscv":       "riscv64",
+	"mipsel":      "mipsle",
+	"loongarch64": "loong64",
+}
+
+// ELFArch returns the architecture an ELF file was built for, as Go names
+// it, or ErrNotELF.
+func ELFArch(r io.ReaderAt) (string, error) {
+	magic := make([]byte, len(elf.ELFMAG))
+	if _, err := r.ReadAt(magic, 0); err != nil || string(magic) != elf.ELFMAG {
+		return "", ErrNotELF
+	}
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return "", fmt.Errorf("read ELF header: %w", err)
+	}
+	arch, ok := elfMachines[f.Machine]
+	if !ok {
+		return strings.ToLower(strings.TrimPrefix(f.Machine.String(), "EM_")), nil
+	}
+	if f.Machine == elf.EM_MIPS && f.Class == elf.ELFCLASS64 {
+		arch = "mips64"
+	}
+	// Both byte orders share a machine number
+	if (f.Machine == elf.EM_MIPS || f.Machine == elf.EM_PPC64) && f.Data == elf.ELFDATA2LSB {
+		arch += "le"
+	}
+	return arch, nil
+}
+
+// SameArch reports whether two architecture names, such as "aarch64" and
+// "arm64", designate the same architecture.
+func SameArch(a, b string) bool {
+	return normalizeArch(a) == normalizeArch(b)
+}
+
+func normalizeArch(arch string) string {
+	arch = strings.ToLower(strings.TrimSpace(arch))
+	if alias, ok := archAliases[arch]; ok {
+		return alias
+	}
+	return arch
+}
+
+// Config formats recognised by ConfigFormat.
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+// ConfigFormat returns the configuration format of a file from its name,
+// or "" when the extension is not one of .json, .yaml or .yml.
+func ConfigFormat(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".json":
+		return FormatJSON
+	case ".yaml", ".yml":
+		return FormatYAML
+	}
+	return ""
+}
+
+// CheckConfig checks that data is valid in format.
+func CheckConfig(format string, data []byte) error {
+	switch format {
+	case FormatJSON:
+		return CheckJSON(data)
+	case FormatYAML:
+		return CheckYAML(data)
+	}
+	return fmt.Errorf("unknown config format %q", format)
+}
+
+// CheckJSON checks that data holds exactly one JSON value.
+func CheckJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		var syntax *json.SyntaxError
+		if errors.As(err, &syntax) {
+			line := bytes.Count(data[:min(int(syntax.Offset), len(data))], []byte("\n")) + 1
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("unexpected content after the JSON value")
+	}
+	return nil
+}
+
+// shells are the interpreters whose scripts ShellSyntax can check.
+var shells = map[string]bool{"sh": true, "bash": true, "dash": true, "ash": true, "ksh": true, "zsh": true}
+
+// IsShell reports whether interpreter, a path or a name, is a POSIX-like
+// shell.
+func IsShell(interpreter string) bool {
+	return shells[path.Base(interpreter)]
+}
+
+// ShellSyntax parses the script at file with shell -n, without running it.
+// The shell is looked up by name in PATH, so that it does not need to be
+// installed where devices have it. The error wraps exec.ErrNotFound when the
+// shell is not available.
+func ShellSyntax(ctx context.Context, shell, file string) error {
+	bin, err := exec.LookPath(path.Base(shell))
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, bin, "-n", file)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) {
+			return &SyntaxError{Output: strings.TrimSpace(stderr.String())}
+		}
+		return err
+	}
+	return nil
+}
+
+// SyntaxError is a script the shell could not parse.
+type SyntaxError struct {
+	Output string
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Output == "" {
+		return "syntax error"
+	}
+	return e.Output
+}
+
+// maxOutput bounds the output of an external validator that is kept.
+const maxOutput = 4 << 10
+
+// External runs the validator at command with file as its only argument
+// and env added to its environment. passed reports whether it exited with
+// status 0; err is set only when it could not be run to completion, as when
+// ctx expires. output holds the start of what it wrote to stdout and stderr.
+func External(ctx context.Context, command, file string, env []string) (passed bool, output string, err error) {
+	var out limitedBuffer
+	cmd := exec.CommandContext(ctx, command, file)
+	cmd.Env = append(cmd.Environ(), env...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err = cmd.Run()
+	output = strings.TrimSpace(out.String())
+	var exit *exec.ExitError
+	switch {
+	case err == nil:
+		return true, output, nil
+	case ctx.Err() != nil:
+		return false, output, ctx.Err()
+	case errors.As(err, &exit):
+		return false, output, nil
+	}
+	return false, output, err
+}
+
+// limitedBuffer keeps the first maxOutput bytes written to it.
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := maxOutput - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(room, len(p))])
+	}
+	return len(p), nil
+}
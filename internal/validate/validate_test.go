@@ -0,0 +1,169 @@
+package validate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestELFArch(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the test binary is an ELF file on linux only")
+	}
+	exe, err := os.Open(os.Args[0])
+	if err != nil {
+		t.Fatalf("open test binary: %v", err)
+	}
+	defer exe.Close()
+
+	arch, err := ELFArch(exe)
+	if err != nil {
+		t.Fatalf("ELFArch: %v", err)
+	}
+	if arch != runtime.GOARCH {
+		t.Errorf("got %s, want %s", arch, runtime.GOARCH)
+	}
+	if _, err := ELFArch(bytes.NewReader([]byte("#!/bin/sh\necho hi\n"))); !errors.Is(err, ErrNotELF) {
+		t.Errorf("expected ErrNotELF for a script, got %v", err)
+	}
+}
+
+func TestSameArch(t *testing.T) {
+	for _, pair := range [][2]string{{"arm64", "aarch64"}, {"amd64", "x86_64"}, {"arm", "armv7l"}, {"386", "i686"}} {
+		if !SameArch(pair[0], pair[1]) {
+			t.Errorf("expected %s and %s to match", pair[0], pair[1])
+		}
+	}
+	if SameArch("arm64", "arm") {
+		t.Error("arm64 and arm should not match")
+	}
+}
+
+func TestCheckJSON(t *testing.T) {
+	if err := CheckJSON([]byte(`{"a": [1, 2], "b": null}`)); err != nil {
+		t.Errorf("valid JSON rejected: %v", err)
+	}
+	for _, src := range []string{`{"a": 1,}`, `{"a": 1} {}`, "{\n\"a\": \n}", ``} {
+		if err := CheckJSON([]byte(src)); err == nil {
+			t.Errorf("expected an error for %q", src)
+		}
+	}
+	if err := CheckJSON([]byte("{\n\"a\": 1\n\"b\": 2}")); err == nil || !strings.HasPrefix(err.Error(), "line 3") {
+		t.Errorf("expected an error on line 3, got %v", err)
+	}
+}
+
+func TestCheckYAML(t *testing.T) {
+	valid := `# service settings
+---
+server:
+  host: "0.0.0.0"   # all interfaces
+  port: 8080
+  url: http://example.com:8080/path
+  message: it's fine
+  tags: [a, "b, c", {d: e}]
+  nested: {
+    a: 1, b: [2, 3]
+  }
+  script: |
+    key: not a key
+    - not an item
+
+  long: this plain scalar
+    continues here
+listeners:
+  - name: a
+    port: 1
+  - name: a
+    port: 2
+  -
+    name: b
+anchors: &base
+  x: 1
+merged:
+  <<: *base
+  <<: *base
+'quoted key': 'it''s'
+`
+	if err := CheckYAML([]byte(valid)); err != nil {
+		t.Fatalf("valid YAML rejected: %v", err)
+	}
+
+	cases := map[string]string{
+		"tab indentation":     "a:\n\tb: 1\n",
+		"unclosed quote":      "a: \"open\nb: 1\n",
+		"unclosed flow":       "a: [1, 2\nb: 3\n",
+		"mismatched flow":     "a: [1, 2}\n",
+		"duplicate key":       "a: 1\nb: 2\na: 3\n",
+		"nested duplicate":    "a:\n  x: 1\n  x: 2\n",
+		"key after a value":   "a: 1\n  b: 2\n",
+		"bad dedent":          "a:\n    b: 1\n  c: 2\n",
+		"text instead of key": "a: 1\nplain text\n",
+		"invalid utf-8":       "a: \xff\n",
+	}
+	for name, src := range cases {
+		if err := CheckYAML([]byte(src)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestShellSyntax(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	ctx := context.Background()
+
+	if err := ShellSyntax(ctx, "/bin/sh", write("ok.sh", "if true; then\n  echo ok\nfi\n")); err != nil {
+		t.Errorf("valid script rejected: %v", err)
+	}
+	var syntax *SyntaxError
+	if err := ShellSyntax(ctx, "/bin/sh", write("bad.sh", "if true; then\n  echo ok\n")); !errors.As(err, &syntax) {
+		t.Errorf("expected a SyntaxError, got %v", err)
+	}
+	if err := ShellSyntax(ctx, "/opt/no-such-shell", write("x.sh", "")); !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("expected exec.ErrNotFound, got %v", err)
+	}
+	if !IsShell("/usr/bin/bash") || IsShell("/usr/bin/python3") {
+		t.Error("IsShell misclassified an interpreter")
+	}
+}
+
+func TestExternal(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	dir := t.TempDir()
+	validator := filepath.Join(dir, "validator")
+	script := "#!/bin/sh\necho \"checked $1 for $HARBOR_ARTIFACT_NAME\"\n[ \"$HARBOR_ARTIFACT_NAME\" = good ]\n"
+	if err := os.WriteFile(validator, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	passed, output, err := External(ctx, validator, "/tmp/file", []string{"HARBOR_ARTIFACT_NAME=good"})
+	if err != nil || !passed || output != "checked /tmp/file for good" {
+		t.Errorf("got passed=%v output=%q err=%v", passed, output, err)
+	}
+	passed, _, err = External(ctx, validator, "/tmp/file", []string{"HARBOR_ARTIFACT_NAME=bad"})
+	if err != nil || passed {
+		t.Errorf("expected a failure without error, got passed=%v err=%v", passed, err)
+	}
+	if _, _, err := External(ctx, filepath.Join(dir, "missing"), "/tmp/file", nil); err == nil {
+		t.Error("expected an error for a missing validator")
+	}
+}
@@ -0,0 +1,239 @@
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// CheckYAML checks the structure of a YAML document without building it:
+// indentation with tabs, quoted strings and flow collections ([...] and
+// {...}) that are not closed, mapping keys at an indentation that does not
+// continue the document, text where a key is expected and keys repeated in
+// the same mapping. It does not resolve anchors, tags or the types of
+// scalars, so a document it accepts may still be rejected by a full parser.
+func CheckYAML(data []byte) error {
+	if !utf8.Valid(data) {
+		return errors.New("not valid UTF-8")
+	}
+	c := &yamlChecker{block: -1, opened: true}
+	for i, line := range strings.Split(string(data), "\n") {
+		if err := c.line(strings.TrimRight(line, "\r"), i+1); err != nil {
+			return err
+		}
+	}
+	if c.quote != 0 {
+		return fmt.Errorf("line %d: quoted string is not closed", c.quoteLine)
+	}
+	if len(c.flow) > 0 {
+		return fmt.Errorf("line %d: %c is not closed", c.flowLine, c.flow[len(c.flow)-1])
+	}
+	return nil
+}
+
+// yamlLevel is a block mapping being read: the column of its keys and the
+// keys seen so far.
+type yamlLevel struct {
+	indent int
+	keys   map[string]bool
+}
+
+type yamlChecker struct {
+	// quote is the quote of a string continued on the next line
+	quote     byte
+	quoteLine int
+	// flow holds the open brackets of a flow collection
+	flow     []byte
+	flowLine int
+	// levels are the open block mappings, innermost last
+	levels []yamlLevel
+	// opened is set when the previous line left a node without a value,
+	// which the next lines may provide as a nested block
+	opened bool
+	// block is the column a block scalar's lines are indented past, or -1
+	block int
+}
+
+func (c *yamlChecker) line(line string, n int) error {
+	if c.block >= 0 {
+		if strings.TrimSpace(line) == "" || indentation(line) > c.block {
+			return nil
+		}
+		c.block = -1
+	}
+	if c.quote != 0 || len(c.flow) > 0 {
+		_, _, _, err := c.scan(line, n, false)
+		return err
+	}
+
+	content := strings.TrimLeft(line, " ")
+	if strings.TrimSpace(content) == "" || content[0] == '#' || line[0] == '%' {
+		return nil
+	}
+	if content[0] == '\t' {
+		return fmt.Errorf("line %d: tabs cannot be used for indentation", n)
+	}
+	if isDocumentMarker(line) {
+		c.levels, c.opened = nil, true
+		return nil
+	}
+
+	// Sequence entries, possibly nested on one line
+	pos := indentation(line)
+	dash, item := pos, false
+	for content == "-" || strings.HasPrefix(content, "- ") {
+		c.closeDeeper(pos)
+		dash, item = pos, true
+		rest := strings.TrimLeft(content[1:], " ")
+		pos += len(content) - len(rest)
+		content = rest
+	}
+	if item {
+		c.opened = true
+	}
+	if content == "" || content[0] == '#' {
+		return nil
+	}
+	if content == "?" || strings.HasPrefix(content, "? ") {
+		// Complex keys are not followed
+		c.opened = true
+		return nil
+	}
+
+	key, value, isKey, err := c.scan(content, n, true)
+	if err != nil {
+		return err
+	}
+	if !isKey {
+		if !item && len(c.levels) > 0 && pos <= c.levels[len(c.levels)-1].indent && !c.opened {
+			return fmt.Errorf("line %d: expected a mapping key", n)
+		}
+		c.opened = false
+		if isBlockScalar(value) {
+			c.block = dash
+		}
+		return nil
+	}
+
+	c.closeDeeper(pos)
+	if top := len(c.levels) - 1; top >= 0 && c.levels[top].indent == pos {
+		if c.levels[top].keys[key] && key != "<<" {
+			return fmt.Errorf("line %d: duplicate key %q", n, key)
+		}
+		c.levels[top].keys[key] = true
+	} else {
+		if !c.opened && len(c.levels) > 0 {
+			return fmt.Errorf("line %d: mapping key %q is not expected at this indentation", n, key)
+		}
+		c.levels = append(c.levels, yamlLevel{indent: pos, keys: map[string]bool{key: true}})
+	}
+
+	c.opened = len(c.flow) == 0 && c.quote == 0 && nodeValue(value) == ""
+	if isBlockScalar(value) {
+		c.block = pos
+	}
+	return nil
+}
+
+// closeDeeper closes the mappings indented past column.
+func (c *yamlChecker) closeDeeper(column int) {
+	for len(c.levels) > 0 && c.levels[len(c.levels)-1].indent > column {
+		c.levels = c.levels[:len(c.levels)-1]
+	}
+}
+
+// scan follows the quotes, flow collections and comments of s, which is
+// line n without its indentation, or a continuation line. With findKey it
+// looks for the "key: " separator of a block mapping entry and returns the
+// key, unquoted, and the value after it; otherwise, or when there is no
+// key, value is s without its comment.
+func (c *yamlChecker) scan(s string, n int, findKey bool) (key, value string, isKey bool, err error) {
+	start, end := 0, len(s)
+	tokenStart := true
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if c.quote != 0 {
+			switch {
+			case c.quote == '"' && ch == '\\':
+				i++
+			case c.quote == '\'' && ch == '\'' && i+1 < len(s) && s[i+1] == '\'':
+				i++
+			case ch == c.quote:
+				c.quote = 0
+			}
+			continue
+		}
+		switch {
+		case ch == ' ' || ch == '\t':
+			continue
+		case ch == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			end = i
+			i = len(s)
+			continue
+		case (ch == '"' || ch == '\'') && tokenStart:
+			c.quote, c.quoteLine = ch, n
+		case (ch == '[' || ch == '{') && tokenStart:
+			if len(c.flow) == 0 {
+				c.flowLine = n
+			}
+			c.flow = append(c.flow, ch)
+			continue
+		case (ch == ']' || ch == '}') && len(c.flow) > 0:
+			open := c.flow[len(c.flow)-1]
+			if (open == '[') != (ch == ']') {
+				return "", "", false, fmt.Errorf("line %d: %c closes %c", n, ch, open)
+			}
+			c.flow = c.flow[:len(c.flow)-1]
+		case ch == ',' && len(c.flow) > 0:
+			tokenStart = true
+			continue
+		case ch == ':' && (i+1 == len(s) || s[i+1] == ' ' || s[i+1] == '\t'):
+			if findKey && !isKey && len(c.flow) == 0 {
+				key, isKey, start = unquote(strings.TrimSpace(s[:i])), true, i+1
+			}
+			tokenStart = true
+			continue
+		}
+		tokenStart = false
+	}
+	return key, strings.TrimSpace(s[start:end]), isKey, nil
+}
+
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+func isDocumentMarker(line string) bool {
+	return (strings.HasPrefix(line, "---") || strings.HasPrefix(line, "...")) &&
+		(len(line) == 3 || line[3] == ' ' || line[3] == '\t')
+}
+
+// nodeValue returns value without its leading anchors and tags.
+func nodeValue(value string) string {
+	for value != "" && (value[0] == '&' || value[0] == '!') {
+		i := strings.IndexAny(value, " \t")
+		if i < 0 {
+			return ""
+		}
+		value = strings.TrimSpace(value[i:])
+	}
+	return value
+}
+
+// isBlockScalar reports whether value starts a literal (|) or folded (>)
+// block scalar.
+func isBlockScalar(value string) bool {
+	value = nodeValue(value)
+	if value == "" || (value[0] != '|' && value[0] != '>') {
+		return false
+	}
+	return strings.Trim(value[1:], "+-0123456789") == ""
+}
+
+func unquote(key string) string {
+	if len(key) >= 2 && (key[0] == '"' || key[0] == '\'') && key[len(key)-1] == key[0] {
+		return key[1 : len(key)-1]
+	}
+	return key
+}
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS signing_keys (
+    id              UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    key_id          VARCHAR(16) NOT NULL,   -- fingerprint: first 8 bytes of SHA-256(public_key), hex
+    name            VARCHAR(255) NOT NULL,
+    public_key      TEXT NOT NULL,          -- base64 Ed25519 public key
+    revoked_at      TIMESTAMPTZ,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (organization_id, key_id)
+);
+
+-- Detached Ed25519 signature (base64) over the raw SHA-256 digest of the file
+ALTER TABLE artifacts ADD COLUMN IF NOT EXISTS signature TEXT NOT NULL DEFAULT '';
+ALTER TABLE artifacts ADD COLUMN IF NOT EXISTS signing_key_id VARCHAR(16) NOT NULL DEFAULT '';
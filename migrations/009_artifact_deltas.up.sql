@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS artifact_deltas (
+    id                     UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id        UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    artifact_id            UUID NOT NULL REFERENCES artifacts(id) ON DELETE CASCADE,
+    source_artifact_id     UUID NOT NULL REFERENCES artifacts(id) ON DELETE CASCADE,
+    source_version         VARCHAR(100) NOT NULL,
+    source_checksum_sha256 VARCHAR(64) NOT NULL,
+    file_size              BIGINT NOT NULL,
+    checksum_sha256        VARCHAR(64) NOT NULL,   -- of the patch file itself
+    storage_path           TEXT NOT NULL,
+    created_at             TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (artifact_id, source_artifact_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_artifact_deltas_source ON artifact_deltas(source_artifact_id);
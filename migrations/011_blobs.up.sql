@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS blobs (
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    checksum_sha256 VARCHAR(64) NOT NULL,
+    size            BIGINT NOT NULL,
+    storage_path    TEXT NOT NULL,
+    ref_count       INTEGER NOT NULL CHECK (ref_count >= 0),
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (organization_id, checksum_sha256)
+);
+
+-- Artifacts uploaded before deduplication each have their own file. The
+-- first file of each content becomes the blob and every artifact points to
+-- it; the other copies are no longer referenced and can be removed by hand.
+INSERT INTO blobs (organization_id, checksum_sha256, size, storage_path, ref_count)
+SELECT organization_id, checksum_sha256, MAX(file_size), MIN(storage_path), COUNT(*)
+FROM artifacts
+GROUP BY organization_id, checksum_sha256;
+
+UPDATE artifacts a SET storage_path = b.storage_path
+FROM blobs b
+WHERE a.organization_id = b.organization_id AND a.checksum_sha256 = b.checksum_sha256;
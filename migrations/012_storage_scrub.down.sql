@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS scrub_reports;
+
+DROP INDEX IF EXISTS idx_artifacts_quarantined;
+
+ALTER TABLE artifacts
+    DROP COLUMN IF EXISTS quarantine_reason,
+    DROP COLUMN IF EXISTS quarantined_at;
@@ -0,0 +1,17 @@
+ALTER TABLE artifacts
+    ADD COLUMN IF NOT EXISTS quarantined_at    TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS quarantine_reason TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_artifacts_quarantined ON artifacts(organization_id) WHERE quarantined_at IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS scrub_reports (
+    id            UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    started_at    TIMESTAMPTZ NOT NULL,
+    finished_at   TIMESTAMPTZ NOT NULL,
+    blobs_checked INTEGER NOT NULL,
+    bytes_checked BIGINT NOT NULL,
+    files_listed  INTEGER NOT NULL,
+    findings      JSONB NOT NULL DEFAULT '[]'
+);
+
+CREATE INDEX IF NOT EXISTS idx_scrub_reports_started ON scrub_reports(started_at DESC);
@@ -0,0 +1,10 @@
+-- Data keys of encrypted files, wrapped with a master key from the server
+-- configuration. Files stored before encryption was enabled have no row.
+CREATE TABLE IF NOT EXISTS data_keys (
+    storage_path  TEXT PRIMARY KEY,
+    master_key_id VARCHAR(64) NOT NULL,
+    wrapped_key   BYTEA NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_data_keys_master_key ON data_keys(master_key_id);
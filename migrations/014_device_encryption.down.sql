@@ -0,0 +1,5 @@
+ALTER TABLE deployment_devices DROP COLUMN IF EXISTS delivery_seed;
+
+ALTER TABLE artifacts DROP COLUMN IF EXISTS encrypt_for_device;
+
+ALTER TABLE devices DROP COLUMN IF EXISTS encryption_key;
@@ -0,0 +1,8 @@
+ALTER TABLE devices
+    ADD COLUMN IF NOT EXISTS encryption_key TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE artifacts
+    ADD COLUMN IF NOT EXISTS encrypt_for_device BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE deployment_devices
+    ADD COLUMN IF NOT EXISTS delivery_seed BYTEA;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_artifacts_files;
+
+ALTER TABLE artifacts
+    DROP COLUMN IF EXISTS files,
+    DROP COLUMN IF EXISTS kind;
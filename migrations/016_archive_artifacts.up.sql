@@ -0,0 +1,4 @@
+-- Entries of archive artifacts, as validated on upload
+ALTER TABLE artifacts
+    ADD COLUMN IF NOT EXISTS archive_format VARCHAR(10) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS entries        JSONB NOT NULL DEFAULT '[]';
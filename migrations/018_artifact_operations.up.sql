@@ -0,0 +1,3 @@
+-- Source of rename and symlink operation artifacts
+ALTER TABLE artifacts
+    ADD COLUMN IF NOT EXISTS source_path TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,17 @@
+-- Sort key of the semantic version of an artifact (see internal/semver). It
+-- is NULL for versions stored before versions had to be semantic.
+ALTER TABLE artifacts
+    ADD COLUMN IF NOT EXISTS version_key TEXT COLLATE "C";
+
+-- Releases without prerelease identifiers can be keyed here; the others stay
+-- NULL and sort before every semantic version.
+UPDATE artifacts a SET version_key =
+        lpad(v.m[1], 20, '0') || '.' || lpad(v.m[2], 20, '0') || '.' || lpad(v.m[3], 20, '0') || '~'
+    FROM (
+        SELECT id, regexp_match(version,
+            '^(0|[1-9][0-9]{0,18})\.(0|[1-9][0-9]{0,18})\.(0|[1-9][0-9]{0,18})(\+[0-9A-Za-z.-]+)?$') AS m
+        FROM artifacts
+    ) v
+    WHERE a.id = v.id AND v.m IS NOT NULL AND a.version_key IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_artifacts_version_key ON artifacts (organization_id, name, version_key);
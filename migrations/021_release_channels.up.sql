@@ -0,0 +1,37 @@
+-- Release channels (dev, staging, stable...). Devices with all of the
+-- device_tags of a channel are subscribed to it.
+CREATE TABLE IF NOT EXISTS channels (
+    id              UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    name            VARCHAR(100) NOT NULL,
+    description     TEXT NOT NULL DEFAULT '',
+    device_tags     TEXT[] NOT NULL,
+    upstream_id     UUID REFERENCES channels(id) ON DELETE SET NULL,
+    artifact_id     UUID REFERENCES artifacts(id) ON DELETE SET NULL,
+    deployment_id   UUID REFERENCES deployments(id) ON DELETE SET NULL,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    UNIQUE (organization_id, name)
+);
+
+-- Continuous deployments also enrol the matching devices that appear after
+-- they are created. Promotions create one per channel.
+ALTER TABLE deployments
+    ADD COLUMN IF NOT EXISTS continuous BOOLEAN NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS channel_id UUID REFERENCES channels(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_deployments_continuous ON deployments (organization_id) WHERE continuous AND status = 'active';
+
+CREATE TABLE IF NOT EXISTS channel_promotions (
+    id              UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    channel_id      UUID NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+    artifact_id     UUID NOT NULL REFERENCES artifacts(id) ON DELETE RESTRICT,
+    deployment_id   UUID REFERENCES deployments(id) ON DELETE SET NULL,
+    promoted_by     TEXT NOT NULL,
+    reason          TEXT NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_channel_promotions_channel ON channel_promotions (channel_id, created_at DESC);
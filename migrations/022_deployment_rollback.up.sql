@@ -0,0 +1,4 @@
+-- Rollback deployments point at the deployment whose devices they return to
+-- the version installed before it.
+ALTER TABLE deployments
+    ADD COLUMN IF NOT EXISTS rollback_of UUID REFERENCES deployments(id) ON DELETE SET NULL;
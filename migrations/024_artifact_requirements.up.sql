@@ -0,0 +1,3 @@
+-- What devices need, besides a device type, to receive an artifact
+ALTER TABLE artifacts
+    ADD COLUMN IF NOT EXISTS requirements JSONB;
@@ -0,0 +1,3 @@
+-- Results of the checks run on the files of an artifact when it was uploaded
+ALTER TABLE artifacts
+    ADD COLUMN IF NOT EXISTS validation JSONB;